@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// client calls the API as an admin and keeps the invoice tokens and queue barcodes the
+// scenarios work on
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+
+	mu       sync.Mutex
+	tokens   []string
+	barcodes []string
+	next     atomic.Uint64
+}
+
+func newClient(base string, token string) *client {
+	return &client{
+		base:  base,
+		token: token,
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{MaxIdleConnsPerHost: 256},
+		},
+	}
+}
+
+// envelope is the part of an API response the scenarios read
+type envelope struct {
+	Message     string          `json:"message"`
+	ErrorCode   string          `json:"error_code"`
+	AccessToken string          `json:"access_token"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// do sends a request and decodes the response envelope. Responses the API reports as
+// failed, including not found answers sent with status 200, return an error.
+func (c *client) do(ctx context.Context, method string, path string, contentType string, body io.Reader, admin bool) (int, *envelope, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if admin {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	env := &envelope{}
+	json.NewDecoder(resp.Body).Decode(env)
+	if resp.StatusCode >= 400 || env.ErrorCode != "" {
+		return resp.StatusCode, env, fmt.Errorf("%s %s: %d %s %s", method, path, resp.StatusCode, env.ErrorCode, env.Message)
+	}
+	return resp.StatusCode, env, nil
+}
+
+// doJSON sends a JSON body
+func (c *client) doJSON(ctx context.Context, method string, path string, payload interface{}, admin bool) (int, *envelope, error) {
+	if payload == nil {
+		return c.do(ctx, method, path, "", nil, admin)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return c.do(ctx, method, path, "application/json", bytes.NewReader(body), admin)
+}
+
+// login exchanges admin credentials for an access token
+func (c *client) login(ctx context.Context, username string, password string) error {
+	_, env, err := c.doJSON(ctx, "POST", "/api/v1/auth/login", map[string]string{
+		"username": username,
+		"password": password,
+	}, false)
+	if err != nil {
+		return err
+	}
+	if env.AccessToken == "" {
+		return errors.New("no access token in the login response")
+	}
+	c.token = env.AccessToken
+	return nil
+}
+
+// createOrder creates a small order and keeps its invoice token for polling
+func (c *client) createOrder(ctx context.Context, salesID string) (int, error) {
+	order, status, err := c.newOrder(ctx, salesID)
+	if err != nil {
+		return status, err
+	}
+	c.mu.Lock()
+	c.tokens = append(c.tokens, order.InvoiceToken)
+	c.mu.Unlock()
+	return status, nil
+}
+
+type createdOrder struct {
+	ID           string `json:"id"`
+	InvoiceToken string `json:"invoice_token"`
+}
+
+func (c *client) newOrder(ctx context.Context, salesID string) (*createdOrder, int, error) {
+	status, env, err := c.doJSON(ctx, "POST", "/api/v1/orders", map[string]interface{}{
+		"sales_id":      salesID,
+		"customer_name": "Load test",
+		"items": []map[string]interface{}{
+			{"product_name": "Load test item", "quantity": 1, "unit_price": 1000},
+		},
+	}, true)
+	if err != nil {
+		return nil, status, err
+	}
+
+	var data struct {
+		Order createdOrder `json:"order"`
+	}
+	if err := json.Unmarshal(env.Data, &data); err != nil || data.Order.InvoiceToken == "" {
+		return nil, status, errors.New("no order in the create response")
+	}
+	return &data.Order, status, nil
+}
+
+// loadTokens collects invoice tokens of recent orders to poll and returns how many it found
+func (c *client) loadTokens(ctx context.Context) (int, error) {
+	_, env, err := c.doJSON(ctx, "GET", "/api/v1/orders?limit=100&with_total=false", nil, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var orders []createdOrder
+	if err := json.Unmarshal(env.Data, &orders); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, order := range orders {
+		if order.InvoiceToken != "" {
+			c.tokens = append(c.tokens, order.InvoiceToken)
+		}
+	}
+	return len(c.tokens), nil
+}
+
+// pollStatus requests the order status and queue pages of the client, which poll them
+// while they wait
+func (c *client) pollStatus(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	if len(c.tokens) == 0 {
+		c.mu.Unlock()
+		return 0, errors.New("no invoice tokens to poll yet")
+	}
+	n := c.next.Add(1)
+	token := c.tokens[int(n)%len(c.tokens)]
+	c.mu.Unlock()
+
+	page := "/api/v1/client/status/"
+	if n%2 == 0 {
+		page = "/api/v1/client/queue/"
+	}
+	status, _, err := c.doJSON(ctx, "GET", page+token, nil, false)
+	return status, err
+}
+
+// prepareScans takes orders through payment and driver data so their queue barcodes can
+// be scanned
+func (c *client) prepareScans(ctx context.Context, salesID string, count int) error {
+	proof, err := proofImage()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		order, _, err := c.newOrder(ctx, salesID)
+		if err != nil {
+			return err
+		}
+		if err := c.uploadProof(ctx, order.InvoiceToken, proof); err != nil {
+			return err
+		}
+		if _, _, err := c.doJSON(ctx, "POST", "/api/v1/payments/"+order.ID+"/verify", nil, true); err != nil {
+			return err
+		}
+
+		_, env, err := c.doJSON(ctx, "POST", "/api/v1/client/driver/"+order.InvoiceToken, map[string]string{
+			"driver_name":   "Load test",
+			"driver_phone":  fmt.Sprintf("62800000%05d", i),
+			"vehicle_plate": fmt.Sprintf("LT %04d", i),
+		}, false)
+		if err != nil {
+			return err
+		}
+		var data struct {
+			Barcode string `json:"queue_barcode"`
+		}
+		if err := json.Unmarshal(env.Data, &data); err != nil || data.Barcode == "" {
+			return errors.New("no queue barcode in the driver response")
+		}
+		c.barcodes = append(c.barcodes, data.Barcode)
+	}
+	return nil
+}
+
+func (c *client) uploadProof(ctx context.Context, token string, proof []byte) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("proof", "loadtest.png")
+	if err != nil {
+		return err
+	}
+	part.Write(proof)
+	form.Close()
+
+	_, _, err = c.do(ctx, "POST", "/api/v1/client/payment/"+token, form.FormDataContentType(), &body, false)
+	return err
+}
+
+// scan scans the prepared barcodes in turn. Only the first scan of each queues its order;
+// a scan clears the barcode, so later ones measure the lookup of an unknown barcode.
+func (c *client) scan(ctx context.Context) (int, error) {
+	n := c.next.Add(1)
+	barcode := c.barcodes[int(n)%len(c.barcodes)]
+	status, _, err := c.doJSON(ctx, "POST", "/api/v1/queue/scan", map[string]string{"barcode": barcode}, true)
+	return status, err
+}
+
+// proofImage is a tiny PNG to upload as payment proof
+func proofImage() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseScenarios(t *testing.T) {
+	scenarios, err := parseScenarios("create=2, poll=50.5")
+	if err != nil || len(scenarios) != 2 || scenarios["create"] != 2 || scenarios["poll"] != 50.5 {
+		t.Fatalf("scenarios = %v (%v)", scenarios, err)
+	}
+	for _, value := range []string{"create", "poll=0", "scan=fast"} {
+		if _, err := parseScenarios(value); err == nil {
+			t.Fatalf("%q: want an error", value)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := newStats("poll", 10)
+	for i := 1; i <= 100; i++ {
+		s.record(time.Duration(i)*time.Millisecond, 200, nil)
+	}
+	s.record(time.Second, 0, errors.New("connection refused"))
+	s.drop()
+
+	got := s.summarize(10 * time.Second)
+	if got.Requests != 101 || got.Errors != 1 || got.Dropped != 1 || got.Achieved != 10.1 {
+		t.Fatalf("summary = %+v", got)
+	}
+	if got.P50 != 51 || got.P99 != 100 || got.Max != 1000 {
+		t.Fatalf("percentiles: p50 %v, p99 %v, max %v", got.P50, got.P99, got.Max)
+	}
+	// Requests that never got a response are counted apart from the HTTP statuses
+	if got.Statuses["200"] != 100 || got.Statuses["transport"] != 1 || got.LastError != "connection refused" {
+		t.Fatalf("statuses = %v, last error %q", got.Statuses, got.LastError)
+	}
+
+	var out bytes.Buffer
+	report(&out, map[string]*stats{"poll": s}, 10*time.Second, true)
+	var decoded []summary
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded) != 1 || decoded[0].Name != "poll" {
+		t.Fatalf("json report: %s (%v)", out.String(), err)
+	}
+}
+
+func TestDriveDropsWhenWorkersAreBusy(t *testing.T) {
+	s := newStats("scan", 200)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		drive(context.Background(), s, func(ctx context.Context) (int, error) {
+			<-release
+			return 200, nil
+		}, 100*time.Millisecond, 1)
+		close(done)
+	}()
+
+	// The only worker is busy, so the ticks of the run are dropped, not queued
+	time.Sleep(150 * time.Millisecond)
+	close(release)
+	<-done
+
+	summary := s.summarize(100 * time.Millisecond)
+	if summary.Requests != 1 || summary.Dropped == 0 {
+		t.Fatalf("want one request and dropped ticks, got %+v", summary)
+	}
+}
@@ -0,0 +1,193 @@
+// Command loadtest drives order creation, client polling and queue scanning against a
+// running API at fixed request rates and reports latency percentiles per scenario.
+//
+// It creates real orders, so point it at a staging deployment:
+//
+//	go run ./cmd/loadtest -base https://staging.example.com -username admin -password secret \
+//		-sales 64f0c0ffee0000000000beef -scenarios create=2,poll=50,scan=5 -duration 1m
+//
+// Rates are requests per second. Requests are sent open-loop: a slow server does not
+// slow the sender down, and ticks that find every worker busy are counted as dropped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// options are the command line flags
+type options struct {
+	base       string
+	token      string
+	username   string
+	password   string
+	salesID    string
+	scenarios  map[string]float64
+	duration   time.Duration
+	workers    int
+	scanOrders int
+	jsonOutput bool
+}
+
+func main() {
+	opts := parseFlags()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := newClient(opts.base, opts.token)
+	if client.token == "" {
+		if err := client.login(ctx, opts.username, opts.password); err != nil {
+			log.Fatalf("Login failed: %v", err)
+		}
+	}
+
+	runners := map[string]func(context.Context) (int, error){}
+	for name := range opts.scenarios {
+		switch name {
+		case "create":
+			if opts.salesID == "" {
+				log.Fatal("The create scenario needs -sales")
+			}
+			runners[name] = func(ctx context.Context) (int, error) { return client.createOrder(ctx, opts.salesID) }
+		case "poll":
+			found, err := client.loadTokens(ctx)
+			if err != nil {
+				log.Fatalf("Failed to load invoice tokens to poll: %v", err)
+			}
+			if _, creating := opts.scenarios["create"]; found == 0 && !creating {
+				log.Fatal("No orders to poll, add the create scenario or create orders first")
+			}
+			runners[name] = client.pollStatus
+		case "scan":
+			if opts.salesID == "" || opts.scanOrders < 1 {
+				log.Fatal("The scan scenario needs -sales and at least one -scan-orders")
+			}
+			log.Printf("Preparing %d orders ready for the queue...", opts.scanOrders)
+			if err := client.prepareScans(ctx, opts.salesID, opts.scanOrders); err != nil {
+				log.Fatalf("Failed to prepare orders to scan: %v", err)
+			}
+			runners[name] = client.scan
+		default:
+			log.Fatalf("Unknown scenario %q, use create, poll or scan", name)
+		}
+	}
+
+	log.Printf("Running %s against %s", opts.duration, opts.base)
+	started := time.Now()
+	results := map[string]*stats{}
+	var wg sync.WaitGroup
+	for name, rate := range opts.scenarios {
+		results[name] = newStats(name, rate)
+		wg.Add(1)
+		go func(s *stats, run func(context.Context) (int, error)) {
+			defer wg.Done()
+			drive(ctx, s, run, opts.duration, opts.workers)
+		}(results[name], runners[name])
+	}
+	wg.Wait()
+
+	report(os.Stdout, results, time.Since(started), opts.jsonOutput)
+}
+
+// drive calls run at the scenario's rate for duration, with at most workers calls in
+// flight, and waits for the calls in flight to finish
+func drive(ctx context.Context, s *stats, run func(context.Context) (int, error), duration time.Duration, workers int) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.Rate))
+	defer ticker.Stop()
+
+	slots := make(chan struct{}, workers)
+	var inflight sync.WaitGroup
+	defer inflight.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			s.drop()
+			continue
+		}
+
+		inflight.Add(1)
+		go func() {
+			defer inflight.Done()
+			defer func() { <-slots }()
+
+			// Calls in flight may finish after the run ends, they still count
+			callCtx, callCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer callCancel()
+
+			start := time.Now()
+			status, err := run(callCtx)
+			s.record(time.Since(start), status, err)
+		}()
+	}
+}
+
+func parseFlags() options {
+	var opts options
+	var scenarios string
+	flag.StringVar(&opts.base, "base", "http://localhost:8000", "base URL of the API")
+	flag.StringVar(&opts.token, "token", "", "admin access token, instead of -username and -password")
+	flag.StringVar(&opts.username, "username", "", "admin username to log in with")
+	flag.StringVar(&opts.password, "password", "", "admin password to log in with")
+	flag.StringVar(&opts.salesID, "sales", "", "sales ID the created orders belong to")
+	flag.StringVar(&scenarios, "scenarios", "create=2,poll=20,scan=2", "scenarios to run with their rates in requests per second")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "how long to send requests")
+	flag.IntVar(&opts.workers, "workers", 64, "maximum requests in flight per scenario")
+	flag.IntVar(&opts.scanOrders, "scan-orders", 20, "orders made ready for the queue before the scan scenario starts")
+	flag.BoolVar(&opts.jsonOutput, "json", false, "print the report as JSON")
+	flag.Parse()
+
+	opts.base = strings.TrimRight(opts.base, "/")
+	if opts.token == "" && (opts.username == "" || opts.password == "") {
+		log.Fatal("Pass -token, or -username and -password")
+	}
+	if opts.workers < 1 || opts.duration <= 0 {
+		log.Fatal("-workers and -duration must be positive")
+	}
+
+	var err error
+	opts.scenarios, err = parseScenarios(scenarios)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return opts
+}
+
+// parseScenarios reads a list like "create=2,poll=50"
+func parseScenarios(value string) (map[string]float64, error) {
+	scenarios := map[string]float64{}
+	for _, part := range strings.Split(value, ",") {
+		name, rate, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("scenario %q has no rate, write it as %s=10", part, part)
+		}
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("scenario %s has an invalid rate %q", name, rate)
+		}
+		scenarios[name] = r
+	}
+	if len(scenarios) == 0 {
+		return nil, fmt.Errorf("no scenarios given")
+	}
+	return scenarios, nil
+}
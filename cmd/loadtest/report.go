@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats collects the outcome of the requests of one scenario
+type stats struct {
+	Name string  `json:"name"`
+	Rate float64 `json:"rate"`
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+	dropped   int
+	lastError string
+}
+
+func newStats(name string, rate float64) *stats {
+	return &stats{Name: name, Rate: rate, statuses: map[int]int{}}
+}
+
+func (s *stats) record(latency time.Duration, status int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, latency)
+	s.statuses[status]++
+	if err != nil {
+		s.errors++
+		s.lastError = err.Error()
+	}
+}
+
+func (s *stats) drop() {
+	s.mu.Lock()
+	s.dropped++
+	s.mu.Unlock()
+}
+
+// summary is the report line of a scenario
+type summary struct {
+	Name      string         `json:"name"`
+	Rate      float64        `json:"target_rate"`
+	Requests  int            `json:"requests"`
+	Achieved  float64        `json:"achieved_rate"`
+	Errors    int            `json:"errors"`
+	Dropped   int            `json:"dropped"`
+	Statuses  map[string]int `json:"statuses"`
+	P50       float64        `json:"p50_ms"`
+	P90       float64        `json:"p90_ms"`
+	P99       float64        `json:"p99_ms"`
+	Max       float64        `json:"max_ms"`
+	LastError string         `json:"last_error,omitempty"`
+}
+
+func (s *stats) summarize(elapsed time.Duration) summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latencies := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	statuses := map[string]int{}
+	for status, n := range s.statuses {
+		key := strconv.Itoa(status)
+		if status == 0 {
+			key = "transport"
+		}
+		statuses[key] = n
+	}
+
+	return summary{
+		Name:      s.Name,
+		Rate:      s.Rate,
+		Requests:  len(latencies),
+		Achieved:  float64(len(latencies)) / elapsed.Seconds(),
+		Errors:    s.errors,
+		Dropped:   s.dropped,
+		Statuses:  statuses,
+		P50:       percentile(latencies, 0.50),
+		P90:       percentile(latencies, 0.90),
+		P99:       percentile(latencies, 0.99),
+		Max:       percentile(latencies, 1),
+		LastError: s.lastError,
+	}
+}
+
+// percentile returns the latency in milliseconds below which the fraction p of the
+// sorted latencies falls
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+// report prints the summary of every scenario as a table or as JSON
+func report(w io.Writer, results map[string]*stats, elapsed time.Duration, asJSON bool) {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	summaries := make([]summary, 0, len(names))
+	for _, name := range names {
+		summaries = append(summaries, results[name].summarize(elapsed))
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(summaries)
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tRATE\tREQUESTS\tERRORS\tDROPPED\tP50\tP90\tP99\tMAX\tSTATUSES")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%.1f/%.1f\t%d\t%d\t%d\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%s\n",
+			s.Name, s.Achieved, s.Rate, s.Requests, s.Errors, s.Dropped, s.P50, s.P90, s.P99, s.Max, formatStatuses(s.Statuses))
+	}
+	tw.Flush()
+
+	for _, s := range summaries {
+		if s.LastError != "" {
+			fmt.Fprintf(w, "%s last error: %s\n", s.Name, s.LastError)
+		}
+	}
+}
+
+func formatStatuses(statuses map[string]int) string {
+	keys := make([]string, 0, len(statuses))
+	for key := range statuses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := ""
+	for i, key := range keys {
+		if i > 0 {
+			out += " "
+		}
+		out += fmt.Sprintf("%s:%d", key, statuses[key])
+	}
+	return out
+}
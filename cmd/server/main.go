@@ -1,22 +1,52 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"bg-go/internal/config"
 	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/changestream"
+	"bg-go/internal/lib/chatalert"
+	"bg-go/internal/lib/clientview"
 	"bg-go/internal/lib/cloudinary"
+	"bg-go/internal/lib/cron"
+	"bg-go/internal/lib/csat"
+	"bg-go/internal/lib/dailystats"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/invoiceview"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/orderchange"
+	"bg-go/internal/lib/otp"
+	"bg-go/internal/lib/outbox"
+	"bg-go/internal/lib/presence"
+	"bg-go/internal/lib/queueday"
+	"bg-go/internal/lib/reportbuilder"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/resthook"
+	"bg-go/internal/lib/retention"
+	"bg-go/internal/lib/shortlink"
+	"bg-go/internal/lib/sla"
+	"bg-go/internal/lib/target"
+	"bg-go/internal/lib/tracing"
+	"bg-go/internal/lib/uom"
 	"bg-go/internal/lib/whatsapp"
 	"bg-go/internal/middleware"
 	"bg-go/internal/routes"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
-	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/joho/godotenv"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 func init() {
 	// Load .env file (ignore error in production)
 	godotenv.Load()
@@ -30,6 +60,17 @@ func main() {
 	log.Printf("Environment: %s", cfg.App.Env)
 	log.Printf("Port: %s", cfg.App.Port)
 	log.Printf("Database Driver: %s", cfg.Database.Driver)
+	if cfg.Notification.Sandbox {
+		log.Printf("Notification sandbox enabled: messages are captured in sandbox_outbox (%d allowlisted)", len(cfg.Notification.SandboxAllowlist))
+	}
+
+	// Initialize tracing (optional)
+	shutdownTracing, err := tracing.Init()
+	if err != nil {
+		log.Printf("Warning: Failed to initialize tracing: %v", err)
+	} else {
+		defer shutdownTracing(context.Background())
+	}
 
 	// Initialize CDN (optional)
 	if err := cloudinary.Init(); err != nil {
@@ -37,13 +78,45 @@ func main() {
 	}
 
 	// Connect to database (non-fatal for health check to work)
-	if _, err := database.Connect(&cfg.Database); err != nil {
+	db, err := database.Connect(&cfg.Database)
+	if err != nil {
 		log.Printf("ERROR: Failed to connect to database: %v", err)
 		// Don't crash - let health check return error status
 	} else {
 		log.Printf("Database connected successfully")
+
+		// Deliver side effects recorded in the outbox
+		notification.Init(cfg.Client.URL)
+		outbox.Start(cfg.Notification.OutboxInterval)
+
+		// Deliver events to the REST hooks of integrations
+		resthook.Start()
+
+		// Feed order changes to event subscribers, such as the client tracking views
+		if cfg.ChangeStream.Enabled {
+			clientview.Start()
+			changestream.Start(cfg.ChangeStream.PollInterval)
+		}
+
+		// Unique indexes backing the handlers' uniqueness checks
+		database.EnsureUniqueIndexes()
+
+		// Archive lookup, TTL, short link, presence and one-time code indexes
+		archive.EnsureIndexes()
+		shortlink.EnsureIndexes()
+		presence.EnsureIndexes()
+		otp.EnsureIndexes()
+		target.EnsureIndexes()
+		csat.EnsureIndexes()
+		middleware.EnsureJournalIndexes()
+
+		// Unit of measure registry, seeded with the default units
+		uom.EnsureDefaults()
 	}
 
+	// WhatsApp Cloud API provider (optional)
+	whatsapp.InitCloud()
+
 	// Initialize WhatsApp (optional)
 	if err := whatsapp.Init(); err != nil {
 		log.Printf("Warning: Failed to initialize WhatsApp: %v", err)
@@ -51,20 +124,61 @@ func main() {
 		log.Println("WhatsApp initialized. Use /api/v1/whatsapp/connect to connect.")
 	}
 
+	// Back the WhatsApp session up to MongoDB (optional)
+	whatsapp.StartBackups(cfg.WhatsApp.SessionBackupInterval)
+
+	// Background jobs (optional)
+	if cfg.Cron.Enabled {
+		cron.Register("notification-retry", cfg.Notification.RetryInterval, notification.RetryDue)
+		cron.Register("order-archive", cfg.Archive.Interval, archive.Run)
+		cron.Register("retention", 24*time.Hour, retention.Run)
+		cron.Register("saved-reports", 5*time.Minute, reportbuilder.RunDue)
+		cron.Register("queue-days", time.Hour, queueday.Run)
+		cron.Register("daily-stats", time.Hour, dailystats.Run)
+		cron.Register("queue-wait", 5*time.Minute, notification.NotifyLongWaits)
+		cron.Register("scheduled-messages", time.Minute, notification.SendScheduled)
+		cron.Register("notification-broadcasts", time.Minute, notification.SendBroadcasts)
+		cron.Register("payment-reminders", 15*time.Minute, notification.SendPaymentReminders)
+		cron.Register("overdue-orders", time.Hour, notification.CheckOverdue)
+		cron.Register("chat-alerts", time.Minute, chatalert.Check)
+		cron.Register("presence-claims", time.Minute, presence.ReleaseStaleClaims)
+		cron.Register("invoice-unviewed", 15*time.Minute, invoiceview.CheckUnviewed)
+		cron.Register("sales-targets", time.Hour, target.SendSummaries)
+		cron.Register("csat-surveys", 5*time.Minute, csat.SendDue)
+		cron.Register("order-changes", time.Minute, orderchange.ResolveExpired)
+		cron.Register("sla-alerts", time.Minute, sla.Check)
+		if cfg.ChangeStream.Enabled {
+			cron.Register("client-views", 10*time.Minute, clientview.Prune)
+		}
+		if cfg.Upload.OrphanCleanup {
+			cron.Register("upload-orphans", 24*time.Hour, file.CleanupOrphans)
+		}
+		cron.Start()
+	}
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:      cfg.App.Name,
 		ServerHeader: cfg.App.Name,
 		BodyLimit:    int(cfg.Upload.MaxFileSize),
+		ErrorHandler: response.ErrorHandler,
 	})
 
 	// Middleware
-	app.Use(recover.New())
+	app.Use(middleware.ErrorReporting())
+	app.Use(tracing.Middleware())
+	app.Use(middleware.RequestContext())
 	app.Use(logger.New())
+	app.Use(middleware.RequestCapture())
 
-	// CORS middleware
-	app.Use(middleware.CustomCORS())
-	app.Use(middleware.SetupCORS())
+	// CORS middleware; the public client pages accept any configured client origin
+	app.Use(middleware.CORS(middleware.DefaultCORSPolicy(), middleware.CORSOverride{
+		Prefix: "/api/v1/client",
+		Policy: middleware.ClientCORSPolicy(),
+	}))
+
+	// Fail fast while the database is unreachable
+	app.Use(middleware.DatabaseBreaker())
 
 	// Setup routes
 	routes.SetupRoutes(app)
@@ -86,7 +200,28 @@ func main() {
 		port = cfg.App.Port
 	}
 
+	// Shut down gracefully on SIGINT/SIGTERM: in-flight requests finish and the WhatsApp
+	// session gets its final snapshot before the process exits
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		log.Printf("Shutting down...")
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			log.Printf("Warning: Failed to shut down the server: %v", err)
+		}
+	}()
+
 	if err := app.Listen(":" + port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+
+	cron.Stop()
+	if whatsapp.WhatsApp != nil {
+		whatsapp.WhatsApp.Close()
+	}
+	if db != nil {
+		db.Close()
+	}
+	log.Printf("Server stopped")
 }
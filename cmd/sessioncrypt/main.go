@@ -0,0 +1,122 @@
+// Command sessioncrypt manages the encryption at rest of the WhatsApp session. It reads
+// the same environment (or .env) as the server:
+//
+//	go run ./cmd/sessioncrypt status    # which key the session is sealed with
+//	go run ./cmd/sessioncrypt rotate    # re-encrypt with WHATSAPP_SESSION_KEY
+//	go run ./cmd/sessioncrypt encrypt   # seal a plaintext whatsapp.db and remove it
+//	go run ./cmd/sessioncrypt decrypt -out whatsapp.db
+//
+// To rotate, set the new key in WHATSAPP_SESSION_KEY, move the previous one to
+// WHATSAPP_SESSION_OLD_KEYS and run rotate; the server also re-encrypts a session sealed
+// with an old key when it starts. Once it ran, the old key can be dropped.
+//
+// Stop the server before encrypt and decrypt: a running server overwrites the snapshot.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/envelope"
+	"bg-go/internal/lib/whatsapp"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	godotenv.Load()
+	cfg := config.Load().WhatsApp
+
+	if len(os.Args) < 2 {
+		log.Fatal("Usage: sessioncrypt status|rotate|encrypt|decrypt [-out file]")
+	}
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	out := flags.String("out", "", "decrypt: file to write the plaintext database to")
+	flags.Parse(os.Args[2:])
+
+	sealedPath := filepath.Join(cfg.SessionPath, whatsapp.SealedSessionFile)
+	plainPath := filepath.Join(cfg.SessionPath, whatsapp.SessionFile)
+
+	keyring, err := whatsapp.Keyring()
+	if err != nil {
+		log.Fatalf("Invalid key: %v", err)
+	}
+	if keyring == nil {
+		log.Fatal("WHATSAPP_SESSION_KEY is not set")
+	}
+
+	switch command {
+	case "status":
+		sealed := readSealed(sealedPath)
+		keyID, err := envelope.KeyID(sealed)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", sealedPath, err)
+		}
+		state := "an old key"
+		if keyID == keyring.CurrentID() {
+			state = "the current key"
+		}
+		fmt.Printf("%s is sealed with key %s (%s)\n", sealedPath, keyID, state)
+
+	case "rotate":
+		rewrapped, changed, err := keyring.Rewrap(readSealed(sealedPath))
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt %s: %v", sealedPath, err)
+		}
+		if !changed {
+			fmt.Printf("%s already uses key %s\n", sealedPath, keyring.CurrentID())
+			return
+		}
+		if err := whatsapp.WriteFileAtomic(sealedPath, rewrapped); err != nil {
+			log.Fatalf("Failed to write %s: %v", sealedPath, err)
+		}
+		fmt.Printf("%s re-encrypted with key %s\n", sealedPath, keyring.CurrentID())
+
+	case "encrypt":
+		plaintext, err := os.ReadFile(plainPath)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", plainPath, err)
+		}
+		sealed, err := keyring.Seal(plaintext)
+		if err != nil {
+			log.Fatalf("Failed to encrypt: %v", err)
+		}
+		if err := whatsapp.WriteFileAtomic(sealedPath, sealed); err != nil {
+			log.Fatalf("Failed to write %s: %v", sealedPath, err)
+		}
+		for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+			os.Remove(plainPath + suffix)
+		}
+		fmt.Printf("%s encrypted into %s with key %s\n", plainPath, sealedPath, keyring.CurrentID())
+
+	case "decrypt":
+		if *out == "" {
+			log.Fatal("decrypt needs -out")
+		}
+		plaintext, _, err := keyring.Open(readSealed(sealedPath))
+		if err != nil {
+			log.Fatalf("Failed to decrypt %s: %v", sealedPath, err)
+		}
+		if err := os.WriteFile(*out, plaintext, 0600); err != nil {
+			log.Fatalf("Failed to write %s: %v", *out, err)
+		}
+		fmt.Printf("%s decrypted into %s\n", sealedPath, *out)
+
+	default:
+		log.Fatalf("Unknown command %q, use status, rotate, encrypt or decrypt", command)
+	}
+}
+
+// readSealed reads the sealed session or exits
+func readSealed(path string) []byte {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return sealed
+}
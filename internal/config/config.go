@@ -2,27 +2,48 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Config holds all application configuration
 type Config struct {
-	App      AppConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	CDN      CDNConfig
-	Upload   UploadConfig
-	CORS     CORSConfig
-	Cron     CronConfig
-	Client   ClientConfig
-	WhatsApp WhatsAppConfig
+	App          AppConfig
+	Database     DatabaseConfig
+	JWT          JWTConfig
+	CDN          CDNConfig
+	Upload       UploadConfig
+	CORS         CORSConfig
+	Cron         CronConfig
+	Client       ClientConfig
+	WhatsApp     WhatsAppConfig
+	Notification NotificationConfig
+	Archive      ArchiveConfig
+	ChangeStream ChangeStreamConfig
+	Capture      CaptureConfig
+	Tracing      TracingConfig
+	Breaker      BreakerConfig
+	Mail         MailConfig
+	SMS          SMSConfig
+	Geocode      GeocodeConfig
+	ShortLink    ShortLinkConfig
+	Concurrency  ConcurrencyConfig
+	Media        MediaConfig
+	CSAT         CSATConfig
+	ErrorReport  ErrorReportConfig
+	Journal      JournalConfig
+	Screening    ScreeningConfig
 }
 
 type AppConfig struct {
 	Name string
 	Env  string
 	Port string
+
+	BannerLabel string // Environment banner the frontend shows; none when empty (the default in production)
+	BannerColor string // CSS color of the banner
 }
 
 type DatabaseConfig struct {
@@ -47,11 +68,13 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	AccessSecret   string
-	RefreshSecret  string
-	AccessExpiry   time.Duration
-	RefreshExpiry  time.Duration
-	GenesisPassword string
+	AccessSecret        string
+	RefreshSecret       string
+	AccessExpiry        time.Duration
+	RefreshExpiry       time.Duration
+	GenesisPassword     string
+	ImpersonationExpiry time.Duration
+	InviteExpiry        time.Duration // How long a user invite link can be accepted
 }
 
 type CDNConfig struct {
@@ -59,17 +82,28 @@ type CDNConfig struct {
 	APIKey    string
 	APISecret string
 	Folder    string
+
+	Moderation      string        // Moderation add-on images are sent to, e.g. aws_rek or manual; off when empty
+	NotificationURL string        // Public URL of POST /api/v1/integrations/cloudinary/webhook
+	WebhookMaxAge   time.Duration // Webhook calls signed longer ago are rejected as replays
 }
 
 type UploadConfig struct {
 	MaxFileSize       int64
 	AllowedFileTypes  []string
+	OrphanCleanup    bool          // Daily job deleting CDN files that no document references
+	OrphanGrace      time.Duration // Files younger than this are never orphans (their document may not be saved yet)
+	KeepPhotoGPS     bool          // Keep the GPS position of uploaded photos in the database; it is always stripped from the file
 }
 
 type CORSConfig struct {
-	AllowedOrigins string
-	AllowedMethods string
-	AllowedHeaders string
+	AllowedOrigins   []string // Origin allowlist; entries may use wildcards, e.g. https://*.example.com
+	ClientOrigins    []string // Allowlist for the public client pages; "*" allows any origin without credentials
+	AllowedMethods   string
+	AllowedHeaders   string
+	ExposedHeaders   string
+	AllowCredentials bool
+	MaxAge           int // Seconds browsers may cache a preflight response
 }
 
 type CronConfig struct {
@@ -77,11 +111,158 @@ type CronConfig struct {
 }
 
 type ClientConfig struct {
-	URL string
+	URL                  string
+	RequireAcceptance    bool          // Sales must accept an order on the invoice page before uploading payment
+	InvoiceUnviewedAfter time.Duration // Publish invoice.unviewed when an invoice link stays unopened this long (0 disables)
+	DriverOTP            bool          // Driver data needs a code sent to the sales rep's WhatsApp
+	ChangeTimeout        time.Duration // Staged item edits left unanswered this long are resolved (0 never)
+	ChangeTimeoutAction  string        // "apply" or "discard" the edits resolved by the timeout
+	PollEnforce          bool          // Reject status polls arriving far faster than the suggested interval with 429
 }
 
 type WhatsAppConfig struct {
-	SessionPath string
+	SessionPath       string
+	BroadcastInterval time.Duration // Pause between messages of a broadcast, to stay under rate limits
+
+	// Provider messages are sent through: "whatsmeow" (a paired WhatsApp Web session) or
+	// "cloud" (the Meta WhatsApp Cloud API). With ProviderFallback, the other configured
+	// provider sends while the selected one is down.
+	Provider         string
+	ProviderFallback bool
+
+	// Meta WhatsApp Cloud API, used by the cloud provider
+	CloudURL           string        // Graph API base URL, including the version
+	CloudPhoneNumberID string        // ID of the business phone number messages are sent from
+	CloudAccessToken   string        // System user access token with whatsapp_business_messaging
+	CloudTimeout       time.Duration // How long to wait for the API per message
+
+	// Encryption of the session database at rest. With a key, SessionPath only holds the
+	// encrypted snapshot; the working copy lives in SessionRuntimePath (tmpfs by default).
+	SessionKey              string        // Passphrase or base64 key; empty stores the session in plaintext
+	SessionOldKeys          []string      // Previous keys, still accepted to decrypt during a rotation
+	SessionRuntimePath      string        // Directory of the decrypted working copy
+	SessionSnapshotInterval time.Duration // How often the working copy is encrypted back to SessionPath
+
+	// Backup of the session to MongoDB GridFS, restored when a deployment starts without
+	// a session directory (e.g. an ephemeral disk)
+	SessionBackup         bool
+	SessionBackupInterval time.Duration // How often the session is backed up when it changed
+	SessionBackupKeep     int           // Backups kept, newest first
+}
+
+type NotificationConfig struct {
+	MaxAttempts         int           // Attempts before a notification is dead-lettered
+	RetryInterval       time.Duration // Base backoff between attempts (doubles each attempt)
+	DeadLetterThreshold int           // Alert admins when failed count reaches this (0 disables)
+	OutboxInterval      time.Duration // How often the outbox dispatcher sweeps for undelivered entries
+	Sandbox             bool          // Capture outgoing messages in sandbox_outbox instead of delivering them
+	SandboxAllowlist    []string      // Phones/emails that still receive real messages in sandbox mode
+	WaitThreshold       time.Duration // Queued orders expected to wait longer than this get an ETA update (0 disables)
+	WaitInterval        time.Duration // Minimum time between ETA updates of the same order
+}
+
+type ArchiveConfig struct {
+	OrderAge        time.Duration // Completed/cancelled orders older than this move to orders_archive
+	BatchSize       int           // Orders moved per batch
+	Interval        time.Duration // How often the archival job runs
+	NotificationTTL time.Duration // Sent notifications are deleted after this (0 disables)
+	OutboxTTL       time.Duration // Delivered outbox entries are deleted after this (0 disables)
+}
+
+type ChangeStreamConfig struct {
+	Enabled      bool          // Feed order changes to event subscribers
+	PollInterval time.Duration // How often orders are polled when MongoDB cannot be watched (standalone)
+}
+
+type CaptureConfig struct {
+	Enabled       bool  // Store sampled request/response pairs in request_logs
+	SamplePercent int   // Percentage of requests captured; server errors are always captured
+	MaxBodyBytes  int   // Bodies are truncated to this size
+	StorageBytes  int64 // Size of the capped collection
+}
+
+type TracingConfig struct {
+	Enabled       bool
+	Endpoint      string // OTLP/HTTP collector, host:port
+	Insecure      bool   // Send without TLS (local collectors)
+	SamplePercent int    // Percentage of new traces recorded; incoming sampled traces are always kept
+}
+
+type BreakerConfig struct {
+	FailureThreshold int           // Consecutive failures that open a breaker
+	Cooldown         time.Duration // How long an open breaker fails fast before probing again
+	CDNTimeout       time.Duration // Timeout of a single Cloudinary call
+	CDNMaxConcurrent int           // Uploads in flight at once; further uploads are shed (0 disables)
+}
+
+type MailConfig struct {
+	Host     string // SMTP server; email delivery is disabled when empty
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+type SMSConfig struct {
+	Gateway  string        // twilio, vonage or http; the SMS fallback is disabled when empty
+	URL      string        // Overrides the gateway's API base URL; the endpoint of the http gateway
+	Account  string        // Twilio account SID or Vonage API key
+	Token    string        // Twilio auth token, Vonage API secret or bearer token of the http gateway
+	From     string        // Sender number or alphanumeric sender ID
+	Types    []string      // Notification types that fall back to SMS, "*" for all
+	Cost     float64       // Cost recorded per segment when the gateway reports no price
+	Currency string        // Currency of Cost
+	Timeout  time.Duration // How long to wait for the gateway per message
+}
+
+type GeocodeConfig struct {
+	Provider string // nominatim or google; geocoding is disabled when empty
+	URL      string // Overrides the provider's API base URL (self-hosted Nominatim)
+	APIKey   string
+	Timeout  time.Duration
+}
+
+type ShortLinkConfig struct {
+	BaseURL string        // Public base of short links, e.g. https://lln.id; links stay long when empty
+	TTL     time.Duration // How long a short link keeps redirecting
+}
+
+type MediaConfig struct {
+	BaseURL string        // Public base of the API for signed image links, e.g. https://api.lln.id; links are relative when empty
+	TTL     time.Duration // How long a signed payment proof or vehicle photo link works
+	Secret  string        // Signs the image links; the JWT secret when empty
+}
+
+type CSATConfig struct {
+	After    time.Duration // Send the satisfaction survey this long after an order completes (0 disables)
+	Window   time.Duration // Orders completed longer ago than this never get a survey, e.g. after enabling it
+	LowScore int           // Ratings at or below this are flagged for follow-up
+}
+
+type ErrorReportConfig struct {
+	DSN          string // Sentry (or Sentry-compatible, e.g. GlitchTip) DSN; empty disables
+	WebhookURL   string // Generic sink receiving every report as a JSON POST; empty disables
+	Release      string // Release tag sent with every report; the commit of the build when empty
+	ServerErrors bool   // Report 5xx responses as well as panics
+}
+
+type JournalConfig struct {
+	Enabled      bool          // Journal the bodies of client page POSTs for inspection and replay
+	TTL          time.Duration // How long journal entries are kept
+	MaxBodyBytes int           // Bodies are truncated to this size
+}
+
+type ScreeningConfig struct {
+	URL     string        // Fraud-screening endpoint receiving every uploaded payment proof; empty disables
+	Secret  string        // Signs the payload in X-Screening-Signature (hex HMAC-SHA256); empty sends none
+	Timeout time.Duration // How long to wait for a verdict before recording an error
+}
+
+type ConcurrencyConfig struct {
+	Reports    int           // Report requests in flight at once (0 disables the limit)
+	Exports    int           // Export requests in flight at once
+	Dashboard  int           // Dashboard requests in flight at once
+	RetryAfter time.Duration // Retry-After sent with 429 responses
 }
 
 // Cfg holds the global configuration
@@ -95,11 +276,17 @@ func Load() *Config {
 		port = getEnv("APP_PORT", "8000")
 	}
 
+	env := getEnv("APP_ENV", "development")
+	clientURL := getEnv("CLIENT_URL", "http://localhost:3001")
+
 	cfg := &Config{
 		App: AppConfig{
 			Name: getEnv("APP_NAME", "BG-API"),
-			Env:  getEnv("APP_ENV", "development"),
+			Env:  env,
 			Port: port,
+
+			BannerLabel: getEnv("APP_BANNER_LABEL", defaultBannerLabel(env)),
+			BannerColor: getEnv("APP_BANNER_COLOR", defaultBannerColor(env)),
 		},
 		Database: DatabaseConfig{
 			Driver:           getEnv("DB_DRIVER", "mongodb"),
@@ -116,35 +303,171 @@ func Load() *Config {
 			MySQLDatabase:    getEnv("MYSQL_DATABASE", "bgdb"),
 		},
 		JWT: JWTConfig{
-			AccessSecret:    getEnv("JWT_SECRET", "secret"),
-			RefreshSecret:   getEnv("JWT_REFRESH_SECRET", "refresh-secret"),
-			AccessExpiry:    getDurationEnv("JWT_ACCESS_EXPIRY", 24*time.Hour),
-			RefreshExpiry:   getDurationEnv("JWT_REFRESH_EXPIRY", 168*time.Hour),
-			GenesisPassword: getEnv("GENESIS_PASSWORD", ""),
+			AccessSecret:        getEnv("JWT_SECRET", "secret"),
+			RefreshSecret:       getEnv("JWT_REFRESH_SECRET", "refresh-secret"),
+			AccessExpiry:        getDurationEnv("JWT_ACCESS_EXPIRY", 24*time.Hour),
+			RefreshExpiry:       getDurationEnv("JWT_REFRESH_EXPIRY", 168*time.Hour),
+			GenesisPassword:     getEnv("GENESIS_PASSWORD", ""),
+			ImpersonationExpiry: getDurationEnv("JWT_IMPERSONATION_EXPIRY", 15*time.Minute),
+			InviteExpiry:        getDurationEnv("INVITE_EXPIRY", 72*time.Hour),
 		},
 		CDN: CDNConfig{
 			CloudName: getEnv("CDN_CLOUD_NAME", ""),
 			APIKey:    getEnv("CDN_API_KEY", ""),
 			APISecret: getEnv("CDN_API_SECRET", ""),
 			Folder:    getEnv("CDN_FOLDER", "bg-uploads"),
+
+			Moderation:      getEnv("CDN_MODERATION", ""),
+			NotificationURL: getEnv("CDN_NOTIFICATION_URL", ""),
+			WebhookMaxAge:   getDurationEnv("CDN_WEBHOOK_MAX_AGE", 2*time.Hour),
 		},
 		Upload: UploadConfig{
 			MaxFileSize:      getInt64Env("MAX_FILE_SIZE", 52428800),
 			AllowedFileTypes: getSliceEnv("ALLOWED_FILE_TYPES", []string{"jpg", "jpeg", "png", "gif", "webp", "pdf"}),
+			OrphanCleanup:    getBoolEnv("UPLOAD_ORPHAN_CLEANUP", false),
+			OrphanGrace:      getDurationEnv("UPLOAD_ORPHAN_GRACE", 24*time.Hour),
+			KeepPhotoGPS:     getBoolEnv("UPLOAD_KEEP_PHOTO_GPS", false),
 		},
 		CORS: CORSConfig{
-			AllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", "*"),
-			AllowedMethods: getEnv("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
-			AllowedHeaders: getEnv("CORS_ALLOWED_HEADERS", "Origin,Content-Type,Accept,Authorization"),
+			AllowedOrigins:   getSliceEnv("CORS_ALLOWED_ORIGINS", defaultCORSOrigins(env, clientURL)),
+			ClientOrigins:    getSliceEnv("CORS_CLIENT_ORIGINS", []string{"*"}),
+			AllowedMethods:   getEnv("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
+			AllowedHeaders:   getEnv("CORS_ALLOWED_HEADERS", "Origin,Content-Type,Accept,Authorization"),
+			ExposedHeaders:   getEnv("CORS_EXPOSED_HEADERS", "Content-Disposition,Retry-After"),
+			AllowCredentials: getBoolEnv("CORS_ALLOW_CREDENTIALS", true),
+			MaxAge:           getIntEnv("CORS_MAX_AGE", 86400),
 		},
 		Cron: CronConfig{
 			Enabled: getBoolEnv("CRON_ENABLED", false),
 		},
 		Client: ClientConfig{
-			URL: getEnv("CLIENT_URL", "http://localhost:3001"),
+			URL:                  clientURL,
+			RequireAcceptance:    getBoolEnv("CLIENT_REQUIRE_ACCEPTANCE", false),
+			InvoiceUnviewedAfter: getDurationEnv("CLIENT_INVOICE_UNVIEWED_AFTER", 24*time.Hour),
+			DriverOTP:            getBoolEnv("CLIENT_DRIVER_OTP", false),
+			ChangeTimeout:        getDurationEnv("CLIENT_CHANGE_TIMEOUT", 24*time.Hour),
+			ChangeTimeoutAction:  getEnv("CLIENT_CHANGE_TIMEOUT_ACTION", "apply"),
+			PollEnforce:          getBoolEnv("CLIENT_POLL_ENFORCE", true),
 		},
 		WhatsApp: WhatsAppConfig{
-			SessionPath: getEnv("WHATSAPP_SESSION_PATH", "./whatsapp-session"),
+			SessionPath:       getEnv("WHATSAPP_SESSION_PATH", "./whatsapp-session"),
+			BroadcastInterval: getDurationEnv("WHATSAPP_BROADCAST_INTERVAL", 3*time.Second),
+
+			Provider:         getEnv("WHATSAPP_PROVIDER", "whatsmeow"),
+			ProviderFallback: getBoolEnv("WHATSAPP_PROVIDER_FALLBACK", false),
+
+			CloudURL:           getEnv("WHATSAPP_CLOUD_API_URL", "https://graph.facebook.com/v21.0"),
+			CloudPhoneNumberID: getEnv("WHATSAPP_CLOUD_PHONE_NUMBER_ID", ""),
+			CloudAccessToken:   getEnv("WHATSAPP_CLOUD_ACCESS_TOKEN", ""),
+			CloudTimeout:       getDurationEnv("WHATSAPP_CLOUD_TIMEOUT", 15*time.Second),
+
+			SessionKey:              getEnv("WHATSAPP_SESSION_KEY", ""),
+			SessionOldKeys:          getSliceEnv("WHATSAPP_SESSION_OLD_KEYS", []string{}),
+			SessionRuntimePath:      getEnv("WHATSAPP_SESSION_RUNTIME_PATH", defaultRuntimePath()),
+			SessionSnapshotInterval: getDurationEnv("WHATSAPP_SESSION_SNAPSHOT_INTERVAL", time.Minute),
+
+			SessionBackup:         getBoolEnv("WHATSAPP_SESSION_BACKUP", false),
+			SessionBackupInterval: getDurationEnv("WHATSAPP_SESSION_BACKUP_INTERVAL", 10*time.Minute),
+			SessionBackupKeep:     getIntEnv("WHATSAPP_SESSION_BACKUP_KEEP", 3),
+		},
+		Notification: NotificationConfig{
+			MaxAttempts:         getIntEnv("NOTIFICATION_MAX_ATTEMPTS", 5),
+			RetryInterval:       getDurationEnv("NOTIFICATION_RETRY_INTERVAL", time.Minute),
+			DeadLetterThreshold: getIntEnv("NOTIFICATION_DEAD_LETTER_THRESHOLD", 10),
+			OutboxInterval:      getDurationEnv("NOTIFICATION_OUTBOX_INTERVAL", 5*time.Second),
+			Sandbox:             getBoolEnv("NOTIFICATION_SANDBOX", false),
+			SandboxAllowlist:    getSliceEnv("NOTIFICATION_SANDBOX_ALLOWLIST", []string{}),
+			WaitThreshold:       getDurationEnv("NOTIFICATION_WAIT_THRESHOLD", 2*time.Hour),
+			WaitInterval:        getDurationEnv("NOTIFICATION_WAIT_INTERVAL", time.Hour),
+		},
+		ChangeStream: ChangeStreamConfig{
+			Enabled:      getBoolEnv("CHANGE_STREAM_ENABLED", true),
+			PollInterval: getDurationEnv("CHANGE_STREAM_POLL_INTERVAL", 5*time.Second),
+		},
+		Archive: ArchiveConfig{
+			OrderAge:        getDurationEnv("ARCHIVE_ORDER_AGE", 2*365*24*time.Hour),
+			BatchSize:       getIntEnv("ARCHIVE_BATCH_SIZE", 500),
+			Interval:        getDurationEnv("ARCHIVE_INTERVAL", 24*time.Hour),
+			NotificationTTL: getDurationEnv("NOTIFICATION_TTL", 180*24*time.Hour),
+			OutboxTTL:       getDurationEnv("OUTBOX_TTL", 7*24*time.Hour),
+		},
+		Capture: CaptureConfig{
+			Enabled:       getBoolEnv("REQUEST_CAPTURE_ENABLED", false),
+			SamplePercent: getIntEnv("REQUEST_CAPTURE_SAMPLE_PERCENT", 10),
+			MaxBodyBytes:  getIntEnv("REQUEST_CAPTURE_MAX_BODY_BYTES", 8192),
+			StorageBytes:  getInt64Env("REQUEST_CAPTURE_STORAGE_BYTES", 100*1024*1024),
+		},
+		Tracing: TracingConfig{
+			Enabled:       getBoolEnv("TRACING_ENABLED", false),
+			Endpoint:      getEnv("TRACING_ENDPOINT", "localhost:4318"),
+			Insecure:      getBoolEnv("TRACING_INSECURE", true),
+			SamplePercent: getIntEnv("TRACING_SAMPLE_PERCENT", 10),
+		},
+		Breaker: BreakerConfig{
+			FailureThreshold: getIntEnv("BREAKER_FAILURE_THRESHOLD", 5),
+			Cooldown:         getDurationEnv("BREAKER_COOLDOWN", 30*time.Second),
+			CDNTimeout:       getDurationEnv("CDN_TIMEOUT", 15*time.Second),
+			CDNMaxConcurrent: getIntEnv("CDN_MAX_CONCURRENT_UPLOADS", 8),
+		},
+		Mail: MailConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getIntEnv("SMTP_PORT", 587),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", ""),
+		},
+		SMS: SMSConfig{
+			Gateway:  getEnv("SMS_GATEWAY", ""),
+			URL:      getEnv("SMS_URL", ""),
+			Account:  getEnv("SMS_ACCOUNT", ""),
+			Token:    getEnv("SMS_TOKEN", ""),
+			From:     getEnv("SMS_FROM", ""),
+			Types:    getSliceEnv("SMS_FALLBACK_TYPES", []string{"*"}),
+			Cost:     getFloatEnv("SMS_COST_PER_SEGMENT", 0),
+			Currency: getEnv("SMS_CURRENCY", "IDR"),
+			Timeout:  getDurationEnv("SMS_TIMEOUT", 15*time.Second),
+		},
+		Geocode: GeocodeConfig{
+			Provider: getEnv("GEOCODE_PROVIDER", ""),
+			URL:      getEnv("GEOCODE_URL", ""),
+			APIKey:   getEnv("GEOCODE_API_KEY", ""),
+			Timeout:  getDurationEnv("GEOCODE_TIMEOUT", 10*time.Second),
+		},
+		ShortLink: ShortLinkConfig{
+			BaseURL: strings.TrimSuffix(getEnv("SHORT_LINK_BASE_URL", ""), "/"),
+			TTL:     getDurationEnv("SHORT_LINK_TTL", 90*24*time.Hour),
+		},
+		Concurrency: ConcurrencyConfig{
+			Reports:    getIntEnv("CONCURRENCY_REPORTS", 4),
+			Exports:    getIntEnv("CONCURRENCY_EXPORTS", 2),
+			Dashboard:  getIntEnv("CONCURRENCY_DASHBOARD", 8),
+			RetryAfter: getDurationEnv("CONCURRENCY_RETRY_AFTER", 5*time.Second),
+		},
+		Media: MediaConfig{
+			BaseURL: strings.TrimSuffix(getEnv("MEDIA_BASE_URL", ""), "/"),
+			TTL:     getDurationEnv("MEDIA_URL_TTL", 15*time.Minute),
+			Secret:  getEnv("MEDIA_SIGNING_SECRET", getEnv("JWT_SECRET", "secret")),
+		},
+		CSAT: CSATConfig{
+			After:    getDurationEnv("CSAT_SURVEY_AFTER", 2*time.Hour),
+			Window:   getDurationEnv("CSAT_SURVEY_WINDOW", 3*24*time.Hour),
+			LowScore: getIntEnv("CSAT_LOW_SCORE", 2),
+		},
+		ErrorReport: ErrorReportConfig{
+			DSN:          getEnv("ERROR_REPORT_DSN", getEnv("SENTRY_DSN", "")),
+			WebhookURL:   getEnv("ERROR_REPORT_WEBHOOK_URL", ""),
+			Release:      getEnv("ERROR_REPORT_RELEASE", getEnv("RELEASE", "")),
+			ServerErrors: getBoolEnv("ERROR_REPORT_SERVER_ERRORS", true),
+		},
+		Journal: JournalConfig{
+			Enabled:      getBoolEnv("CLIENT_JOURNAL_ENABLED", false),
+			TTL:          getDurationEnv("CLIENT_JOURNAL_TTL", 7*24*time.Hour),
+			MaxBodyBytes: getIntEnv("CLIENT_JOURNAL_MAX_BODY_BYTES", 16384),
+		},
+		Screening: ScreeningConfig{
+			URL:     getEnv("FRAUD_SCREENING_URL", ""),
+			Secret:  getEnv("FRAUD_SCREENING_SECRET", ""),
+			Timeout: getDurationEnv("FRAUD_SCREENING_TIMEOUT", 20*time.Second),
 		},
 	}
 
@@ -152,6 +475,43 @@ func Load() *Config {
 	return cfg
 }
 
+// defaultRuntimePath keeps the decrypted WhatsApp session in memory-backed /dev/shm
+// where available, so plaintext credentials never reach the disk
+func defaultRuntimePath() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm/bg-go-whatsapp"
+	}
+	return filepath.Join(os.TempDir(), "bg-go-whatsapp")
+}
+
+// defaultCORSOrigins allows local dev servers in development and only the client app elsewhere
+func defaultCORSOrigins(env, clientURL string) []string {
+	if env == "development" {
+		return []string{"http://localhost:*", "http://127.0.0.1:*"}
+	}
+	return []string{clientURL}
+}
+
+// defaultBannerLabel names every environment but production in the frontend banner
+func defaultBannerLabel(env string) string {
+	if env == "production" {
+		return ""
+	}
+	return strings.ToUpper(env)
+}
+
+// defaultBannerColor tells environments apart at a glance: blue for development, amber
+// for staging and red for anything else that is not production
+func defaultBannerColor(env string) string {
+	switch env {
+	case "development":
+		return "#2563eb"
+	case "staging":
+		return "#d97706"
+	}
+	return "#dc2626"
+}
+
 // Helper functions
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -178,6 +538,15 @@ func getInt64Env(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return defaultValue
+}
+
 func getBoolEnv(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolVal, err := strconv.ParseBool(value); err == nil {
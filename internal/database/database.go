@@ -2,12 +2,15 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"bg-go/internal/config"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"gorm.io/driver/mysql"
@@ -59,7 +62,9 @@ func (db *DB) connectMongo(cfg *config.DatabaseConfig) error {
 		ApplyURI(cfg.MongoURL).
 		SetMaxPoolSize(10).
 		SetMinPoolSize(2).
-		SetServerSelectionTimeout(5 * time.Second)
+		SetServerSelectionTimeout(5 * time.Second).
+		SetMonitor(commandMonitor()).
+		SetServerMonitor(serverMonitor())
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
@@ -138,6 +143,78 @@ func GetMongoCollection(name string) *mongo.Collection {
 	return DBInstance.MongoDB.Collection(name)
 }
 
+var (
+	transactionsOnce      sync.Once
+	transactionsSupported bool
+)
+
+// supportsTransactions reports whether the MongoDB deployment is a replica set or
+// sharded cluster; standalone servers reject multi-document transactions
+func supportsTransactions(ctx context.Context) bool {
+	transactionsOnce.Do(func() {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		err := DBInstance.MongoDB.RunCommand(ctx, bson.M{"hello": 1}).Decode(&hello)
+		transactionsSupported = err == nil && (hello.SetName != "" || hello.Msg == "isdbgrid")
+		if !transactionsSupported {
+			log.Println("Warning: MongoDB does not support transactions, writes will not be atomic")
+		}
+	})
+	return transactionsSupported
+}
+
+// SupportsChangeStreams reports whether collections can be watched; like transactions,
+// change streams need a replica set or sharded cluster
+func SupportsChangeStreams(ctx context.Context) bool {
+	if DBInstance == nil || DBInstance.MongoDB == nil {
+		return false
+	}
+	return supportsTransactions(ctx)
+}
+
+// WithTransaction runs fn inside a MongoDB transaction. The context passed to fn
+// must be used for every operation that belongs to the transaction.
+// On standalone servers fn runs without a transaction.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if DBInstance == nil || DBInstance.Mongo == nil {
+		return fmt.Errorf("MongoDB not connected")
+	}
+
+	if !supportsTransactions(ctx) {
+		return fn(ctx)
+	}
+
+	session, err := DBInstance.Mongo.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// EnsureCappedCollection creates a capped collection of sizeBytes if it does not exist yet
+func EnsureCappedCollection(name string, sizeBytes int64) error {
+	if DBInstance == nil || DBInstance.MongoDB == nil {
+		return fmt.Errorf("MongoDB not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := DBInstance.MongoDB.CreateCollection(ctx, name, options.CreateCollection().SetCapped(true).SetSizeInBytes(sizeBytes))
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 48 { // NamespaceExists
+		return nil
+	}
+	return err
+}
+
 // GetGormDB returns Gorm DB instance
 func GetGormDB() *gorm.DB {
 	if DBInstance == nil {
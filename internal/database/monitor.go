@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"bg-go/internal/lib/breaker"
+	"bg-go/internal/lib/tracing"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// commandMonitor traces every command and feeds connectivity failures to the database breaker
+func commandMonitor() *event.CommandMonitor {
+	traced := tracing.MongoMonitor()
+
+	return &event.CommandMonitor{
+		Started: traced.Started,
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			traced.Succeeded(ctx, evt)
+			breaker.Get(breaker.Database).Record(nil)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			traced.Failed(ctx, evt)
+			// Server errors such as duplicate keys mean the database is reachable
+			if isConnectivityFailure(evt.Failure) {
+				breaker.Get(breaker.Database).Record(errors.New(evt.Failure))
+			}
+		},
+	}
+}
+
+// serverMonitor feeds heartbeats to the database breaker, so it also opens when
+// no command can be sent at all and closes again once the server is back
+func serverMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		ServerHeartbeatSucceeded: func(*event.ServerHeartbeatSucceededEvent) {
+			breaker.Get(breaker.Database).Record(nil)
+		},
+		ServerHeartbeatFailed: func(evt *event.ServerHeartbeatFailedEvent) {
+			breaker.Get(breaker.Database).Record(evt.Failure)
+		},
+	}
+}
+
+func isConnectivityFailure(failure string) bool {
+	failure = strings.ToLower(failure)
+	for _, marker := range []string{"connection", "timeout", "timed out", "deadline exceeded", "no reachable servers", "server selection"} {
+		if strings.Contains(failure, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"time"
+
+	"bg-go/internal/lib/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Names of the unique indexes on the core collections
+const (
+	IndexUsername        = "unique_username"
+	IndexSalesPhone      = "unique_active_sales_phone"
+	IndexOrderNumber     = "unique_order_number"
+	IndexInvoiceToken    = "unique_invoice_token"
+	IndexQueueToken      = "unique_queue_token"
+	IndexPendingCancel   = "unique_pending_cancel_request"
+	IndexSettingsVersion = "unique_settings_version"
+)
+
+// uniqueIndexes enforce at the database level what the handlers check before writing,
+// so concurrent requests cannot both pass the check. Values that are empty on legacy
+// documents are left out with a partial filter.
+var uniqueIndexes = map[string][]mongo.IndexModel{
+	"users": {{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetName(IndexUsername).SetUnique(true),
+	}},
+	"sales": {{
+		// Merged and deactivated reps keep their phone
+		Keys: bson.D{{Key: "phone", Value: 1}},
+		Options: options.Index().SetName(IndexSalesPhone).SetUnique(true).
+			SetPartialFilterExpression(bson.M{"is_active": true}),
+	}},
+	"orders": {
+		{
+			Keys: bson.D{{Key: "order_number", Value: 1}},
+			Options: options.Index().SetName(IndexOrderNumber).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"order_number": bson.M{"$gt": ""}}),
+		},
+		{
+			Keys: bson.D{{Key: "invoice_token", Value: 1}},
+			Options: options.Index().SetName(IndexInvoiceToken).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"invoice_token": bson.M{"$gt": ""}}),
+		},
+		{
+			Keys: bson.D{{Key: "queue_token", Value: 1}},
+			Options: options.Index().SetName(IndexQueueToken).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"queue_token": bson.M{"$gt": ""}}),
+		},
+	},
+	"cancel_requests": {{
+		// One order has at most one request waiting for review
+		Keys: bson.D{{Key: "order_id", Value: 1}},
+		Options: options.Index().SetName(IndexPendingCancel).SetUnique(true).
+			SetPartialFilterExpression(bson.M{"status": "pending"}),
+	}},
+	"company_settings_history": {{
+		// Concurrent saves must not record the same version twice
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetName(IndexSettingsVersion).SetUnique(true),
+	}},
+}
+
+// EnsureUniqueIndexes creates the unique indexes of the core collections. An index
+// fails to build while the collection holds duplicates; the failure is logged and only
+// the handlers' checks apply until the duplicates are fixed.
+func EnsureUniqueIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	normalizeSalesPhones(ctx)
+	cancel()
+
+	for collection, indexes := range uniqueIndexes {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		for _, index := range indexes {
+			if _, err := GetMongoCollection(collection).Indexes().CreateOne(ctx, index); err != nil {
+				log.Printf("[Database] Failed to create index %s on %s: %v", *index.Options.Name, collection, err)
+			}
+		}
+		cancel()
+	}
+}
+
+// normalizeSalesPhones rewrites sales phones stored before phones were normalized, so
+// the same phone entered as "0812..." and "62812..." collides in the phone index. Phones
+// that do not normalize are left for admins to fix.
+func normalizeSalesPhones(ctx context.Context) {
+	collection := GetMongoCollection("sales")
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"phone": 1}))
+	if err != nil {
+		log.Printf("[Database] Failed to read sales phones: %v", err)
+		return
+	}
+	var rows []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Phone string             `bson:"phone"`
+	}
+	err = cursor.All(ctx, &rows)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[Database] Failed to read sales phones: %v", err)
+		return
+	}
+
+	normalized := 0
+	for _, row := range rows {
+		phone, err := utils.NormalizePhone(row.Phone)
+		if err != nil || phone == row.Phone {
+			continue
+		}
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": row.ID, "phone": row.Phone}, bson.M{"$set": bson.M{"phone": phone}})
+		if err != nil {
+			log.Printf("[Database] Failed to normalize the phone of sales %s: %v", row.ID.Hex(), err)
+			continue
+		}
+		normalized++
+	}
+	if normalized > 0 {
+		log.Printf("[Database] Normalized %d sales phones", normalized)
+	}
+}
+
+// dupKeyIndex finds the index name in a duplicate key error message, e.g.
+// "E11000 duplicate key error collection: db.users index: unique_username dup key: ..."
+var dupKeyIndex = regexp.MustCompile(`index: (\S+) dup key`)
+
+// DuplicateKeyIndex returns the name of the unique index a write violated, and whether
+// err is a duplicate key error at all
+func DuplicateKeyIndex(err error) (string, bool) {
+	if !mongo.IsDuplicateKeyError(err) {
+		return "", false
+	}
+
+	var messages []string
+	var writeErr mongo.WriteException
+	var cmdErr mongo.CommandError
+	switch {
+	case errors.As(err, &writeErr):
+		for _, e := range writeErr.WriteErrors {
+			messages = append(messages, e.Message)
+		}
+	case errors.As(err, &cmdErr):
+		messages = append(messages, cmdErr.Message)
+	default:
+		messages = append(messages, err.Error())
+	}
+	for _, message := range messages {
+		if match := dupKeyIndex.FindStringSubmatch(message); match != nil {
+			return match[1], true
+		}
+	}
+	return "", true
+}
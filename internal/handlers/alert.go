@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/chatalert"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetAlertPolicy returns the chat alert policy
+func (h *SettingsHandler) GetAlertPolicy(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	return response.Success(c, 200, chatalert.LoadPolicy(ctx))
+}
+
+// UpdateAlertPolicy saves the chat channels and the rule of every alert type
+func (h *SettingsHandler) UpdateAlertPolicy(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		Channels  []models.AlertChannel `json:"channels"`
+		Rules     []models.AlertRule    `json:"rules"`
+		OpenFrom  string                `json:"open_from"`
+		OpenUntil string                `json:"open_until"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Channels == nil {
+		req.Channels = []models.AlertChannel{}
+	}
+
+	names := map[string]bool{}
+	for _, channel := range req.Channels {
+		if channel.Name == "" || names[channel.Name] {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Every channel needs a unique name")
+		}
+		names[channel.Name] = true
+		if !chatalert.IsKind(channel.Kind) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Channel kind must be slack or discord")
+		}
+		if target, err := url.Parse(channel.WebhookURL); err != nil || target.Scheme != "https" || target.Host == "" {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Channel "+channel.Name+" needs an https webhook_url")
+		}
+	}
+
+	types := map[string]bool{}
+	for _, rule := range req.Rules {
+		if !chatalert.IsType(rule.Type) || types[rule.Type] {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown or repeated alert type: "+rule.Type)
+		}
+		types[rule.Type] = true
+		if rule.Threshold < 0 || rule.CooldownMinutes < 0 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "threshold and cooldown_minutes cannot be negative")
+		}
+		for _, name := range rule.Channels {
+			if !names[name] {
+				return response.ErrorCode(c, 400, response.CodeValidationFailed, "Rule "+rule.Type+" uses the unknown channel "+name)
+			}
+		}
+	}
+
+	if req.OpenFrom != "" || req.OpenUntil != "" {
+		from, errFrom := chatalert.ParseClock(req.OpenFrom)
+		until, errUntil := chatalert.ParseClock(req.OpenUntil)
+		if errFrom != nil || errUntil != nil || from >= until {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "open_from and open_until must be HH:MM, open_from first")
+		}
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"channels":   req.Channels,
+			"rules":      req.Rules,
+			"open_from":  req.OpenFrom,
+			"open_until": req.OpenUntil,
+			"updated_by": middleware.GetUserID(c),
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection(chatalert.Collection)
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save alert policy")
+	}
+
+	enabled := []string{}
+	for _, rule := range req.Rules {
+		if rule.Enabled {
+			enabled = append(enabled, rule.Type)
+		}
+	}
+	audit.Log(c, audit.ActionAlertPolicy, chatalert.Collection, "", map[string]interface{}{
+		"channels": len(req.Channels),
+		"enabled":  enabled,
+	})
+
+	return response.Success(c, 200, chatalert.LoadPolicy(ctx))
+}
+
+// TestAlert posts a test message to one channel of the saved policy
+func (h *SettingsHandler) TestAlert(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	name := c.Query("channel")
+	for _, channel := range chatalert.LoadPolicy(ctx).Channels {
+		if channel.Name != name {
+			continue
+		}
+		err := chatalert.Send(channel, chatalert.Alert{
+			Type:  "test",
+			Title: "Test alert",
+			Text:  fmt.Sprintf("Operational alerts are posted to %s.", channel.Name),
+		})
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "The webhook rejected the test message: "+err.Error())
+		}
+		return response.SuccessWithMessage(c, 200, "Test alert sent")
+	}
+	return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown channel "+name)
+}
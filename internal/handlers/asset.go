@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AssetHandler handles the ledger of returnable assets (pallets, containers)
+type AssetHandler struct{}
+
+// NewAssetHandler creates a new asset handler
+func NewAssetHandler() *AssetHandler {
+	return &AssetHandler{}
+}
+
+// AssetRequest is the body for lending or returning assets. Lending needs the delivery
+// note; returns name either the delivery note or the sales (and customer) returning them.
+type AssetRequest struct {
+	DeliveryNoteID string              `json:"delivery_note_id"`
+	SalesID        string              `json:"sales_id"`
+	CustomerPhone  string              `json:"customer_phone"`
+	Items          []models.AssetCount `json:"items"`
+	Note           string              `json:"note"`
+}
+
+// AssetBalance is the outstanding quantity of one asset type for a sales/customer
+type AssetBalance struct {
+	SalesID       string `json:"sales_id" bson:"sales_id"`
+	SalesName     string `json:"sales_name" bson:"sales_name"`
+	CustomerName  string `json:"customer_name,omitempty" bson:"customer_name"`
+	CustomerPhone string `json:"customer_phone,omitempty" bson:"customer_phone"`
+	AssetType     string `json:"asset_type" bson:"asset_type"`
+	Lent          int    `json:"lent" bson:"lent"`
+	Returned      int    `json:"returned" bson:"returned"`
+	Outstanding   int    `json:"outstanding" bson:"outstanding"`
+}
+
+// normalizeAssets lowercases asset types and merges duplicates
+func normalizeAssets(items []models.AssetCount) ([]models.AssetCount, error) {
+	merged := []models.AssetCount{}
+	index := map[string]int{}
+	for _, item := range items {
+		assetType := strings.ToLower(strings.TrimSpace(item.AssetType))
+		if assetType == "" || item.Quantity <= 0 {
+			return nil, errors.New("Every item needs an asset type and a positive quantity")
+		}
+		if i, ok := index[assetType]; ok {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+		index[assetType] = len(merged)
+		merged = append(merged, models.AssetCount{AssetType: assetType, Quantity: item.Quantity})
+	}
+	if len(merged) == 0 {
+		return nil, errors.New("At least one item is required")
+	}
+	return merged, nil
+}
+
+// customerMatch matches entries without a customer when phone is empty
+func customerMatch(phone string) interface{} {
+	if phone == "" {
+		return nil
+	}
+	return phone
+}
+
+// assetBalances sums the ledger entries matching filter per sales, customer and asset type
+func assetBalances(ctx context.Context, filter bson.M, outstandingOnly bool) ([]AssetBalance, error) {
+	lentOrReturned := func(kind string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$kind", kind}}, "$quantity", 0}}}
+	}
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$sort": bson.M{"created_at": 1}},
+		{"$group": bson.M{
+			"_id":           bson.M{"sales_id": "$sales_id", "customer_phone": "$customer_phone", "asset_type": "$asset_type"},
+			"sales_name":    bson.M{"$last": "$sales_name"},
+			"customer_name": bson.M{"$last": "$customer_name"},
+			"lent":          lentOrReturned(models.AssetEntryLent),
+			"returned":      lentOrReturned(models.AssetEntryReturned),
+		}},
+		{"$project": bson.M{
+			"_id":            0,
+			"sales_id":       "$_id.sales_id",
+			"customer_phone": "$_id.customer_phone",
+			"asset_type":     "$_id.asset_type",
+			"sales_name":     1,
+			"customer_name":  1,
+			"lent":           1,
+			"returned":       1,
+			"outstanding":    bson.M{"$subtract": bson.A{"$lent", "$returned"}},
+		}},
+	}
+	if outstandingOnly {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"outstanding": bson.M{"$gt": 0}}})
+	}
+	pipeline = append(pipeline, bson.M{"$sort": bson.D{{Key: "sales_name", Value: 1}, {Key: "customer_name", Value: 1}, {Key: "asset_type", Value: 1}}})
+
+	cursor, err := database.GetMongoCollection("asset_ledger").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	balances := []AssetBalance{}
+	if err := cursor.All(ctx, &balances); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// loadNoteParty returns a delivery note and the order it was issued for
+func loadNoteParty(ctx context.Context, noteID string) (*models.DeliveryNote, *models.Order, error) {
+	noteObjID, err := primitive.ObjectIDFromHex(noteID)
+	if err != nil {
+		return nil, nil, errors.New("Invalid delivery note ID")
+	}
+	note := &models.DeliveryNote{}
+	if err := database.GetMongoCollection("delivery_notes").FindOne(ctx, bson.M{"_id": noteObjID}).Decode(note); err != nil {
+		return nil, nil, errors.New("Delivery note not found")
+	}
+	order := &models.Order{}
+	if orderObjID, err := primitive.ObjectIDFromHex(note.OrderID); err == nil {
+		database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": orderObjID}).Decode(order)
+	}
+	return note, order, nil
+}
+
+// Lend records the assets lent with a delivery note
+func (h *AssetHandler) Lend(c *fiber.Ctx) error {
+	var req AssetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.DeliveryNoteID == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Delivery note is required")
+	}
+	items, err := normalizeAssets(req.Items)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	note, order, err := loadNoteParty(ctx, req.DeliveryNoteID)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, err.Error())
+	}
+	if note.Superseded {
+		return response.ErrorCode(c, 400, response.CodeDeliveryNoteSuperseded, "Record assets on the latest revision")
+	}
+
+	now := time.Now()
+	entries := []interface{}{}
+	for _, item := range items {
+		entries = append(entries, &models.AssetEntry{
+			BaseModel:      models.BaseModel{ID: primitive.NewObjectID(), CreatedAt: now, UpdatedAt: now},
+			Kind:           models.AssetEntryLent,
+			AssetType:      item.AssetType,
+			Quantity:       item.Quantity,
+			SalesID:        order.SalesID,
+			SalesName:      note.SalesName,
+			CustomerName:   order.CustomerName,
+			CustomerPhone:  order.CustomerPhone,
+			DeliveryNoteID: note.ID.Hex(),
+			NoteNumber:     note.NoteNumber,
+			OrderID:        note.OrderID,
+			Note:           strings.TrimSpace(req.Note),
+			RecordedBy:     middleware.GetUserID(c),
+		})
+	}
+
+	err = database.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := database.GetMongoCollection("asset_ledger").InsertMany(txCtx, entries); err != nil {
+			return err
+		}
+		return addNoteAssets(txCtx, note.ID, items, now)
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to record lent assets")
+	}
+	database.GetMongoCollection("delivery_notes").FindOne(ctx, bson.M{"_id": note.ID}).Decode(note)
+
+	audit.Log(c, audit.ActionAssetLend, "delivery_note", note.ID.Hex(), map[string]interface{}{
+		"note_number": note.NoteNumber,
+		"items":       items,
+	})
+
+	return response.Success(c, 201, fiber.Map{
+		"entries": entries,
+		"assets":  note.Assets,
+	})
+}
+
+// addNoteAssets adds lent assets to the totals a delivery note shows. Each total is
+// incremented in place, and a new asset type is pushed only while the note has none
+// of it, so concurrent lends on one note do not overwrite each other.
+func addNoteAssets(ctx context.Context, noteID primitive.ObjectID, items []models.AssetCount, now time.Time) error {
+	notes := database.GetMongoCollection("delivery_notes")
+	for _, item := range items {
+		added := false
+		// A lend that pushes the same new type first turns the push into an increment
+		for attempt := 0; attempt < 2 && !added; attempt++ {
+			result, err := notes.UpdateOne(ctx,
+				bson.M{"_id": noteID, "assets.asset_type": item.AssetType},
+				bson.M{"$inc": bson.M{"assets.$[asset].quantity": item.Quantity}, "$set": bson.M{"updated_at": now}},
+				options.Update().SetArrayFilters(options.ArrayFilters{
+					Filters: []interface{}{bson.M{"asset.asset_type": item.AssetType}},
+				}),
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount > 0 {
+				added = true
+				break
+			}
+
+			result, err = notes.UpdateOne(ctx,
+				bson.M{"_id": noteID, "assets.asset_type": bson.M{"$ne": item.AssetType}},
+				bson.M{"$push": bson.M{"assets": item}, "$set": bson.M{"updated_at": now}},
+			)
+			if err != nil {
+				return err
+			}
+			added = result.MatchedCount > 0
+		}
+		if !added {
+			return errors.New("delivery note assets changed while lending " + item.AssetType)
+		}
+	}
+	return nil
+}
+
+// Return records assets brought back; a sales/customer cannot return more than is outstanding
+func (h *AssetHandler) Return(c *fiber.Ctx) error {
+	var req AssetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	items, err := normalizeAssets(req.Items)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	entry := models.AssetEntry{
+		Kind:          models.AssetEntryReturned,
+		SalesID:       req.SalesID,
+		CustomerPhone: strings.TrimSpace(req.CustomerPhone),
+		Note:          strings.TrimSpace(req.Note),
+		RecordedBy:    middleware.GetUserID(c),
+	}
+	switch {
+	case req.DeliveryNoteID != "":
+		note, order, err := loadNoteParty(ctx, req.DeliveryNoteID)
+		if err != nil {
+			return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, err.Error())
+		}
+		entry.SalesID, entry.SalesName = order.SalesID, note.SalesName
+		entry.CustomerName, entry.CustomerPhone = order.CustomerName, order.CustomerPhone
+		entry.DeliveryNoteID, entry.NoteNumber, entry.OrderID = note.ID.Hex(), note.NoteNumber, note.OrderID
+	case req.SalesID != "":
+		salesObjID, err := primitive.ObjectIDFromHex(req.SalesID)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid sales ID format")
+		}
+		sales := &models.Sales{}
+		if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales); err != nil {
+			return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
+		}
+		entry.SalesName = sales.Name
+	default:
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Delivery note or sales is required")
+	}
+
+	balances, err := assetBalances(ctx, bson.M{
+		"sales_id":       entry.SalesID,
+		"customer_phone": customerMatch(entry.CustomerPhone),
+	}, false)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load outstanding assets")
+	}
+	for _, item := range items {
+		outstanding := 0
+		for _, balance := range balances {
+			if balance.AssetType == item.AssetType {
+				outstanding = balance.Outstanding
+			}
+		}
+		if item.Quantity > outstanding {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Only "+strconv.Itoa(outstanding)+" "+item.AssetType+" outstanding")
+		}
+	}
+	// Returns by sales and phone keep the customer name of the loans
+	if entry.CustomerName == "" && len(balances) > 0 {
+		entry.CustomerName = balances[0].CustomerName
+	}
+
+	now := time.Now()
+	entries := []interface{}{}
+	for _, item := range items {
+		returned := entry
+		returned.BaseModel = models.BaseModel{ID: primitive.NewObjectID(), CreatedAt: now, UpdatedAt: now}
+		returned.AssetType = item.AssetType
+		returned.Quantity = item.Quantity
+		entries = append(entries, &returned)
+	}
+	if _, err := database.GetMongoCollection("asset_ledger").InsertMany(ctx, entries); err != nil {
+		return response.Error(c, 500, "Failed to record returned assets")
+	}
+
+	audit.Log(c, audit.ActionAssetReturn, "sales", entry.SalesID, map[string]interface{}{
+		"customer_phone":   entry.CustomerPhone,
+		"delivery_note_id": entry.DeliveryNoteID,
+		"items":            items,
+	})
+
+	return response.Success(c, 201, entries)
+}
+
+// Ledger lists asset movements, newest first, filtered by sales_id, customer_phone,
+// asset_type, kind or delivery_note_id
+func (h *AssetHandler) Ledger(c *fiber.Ctx) error {
+	pq := parsePage(c, 20, maxPageLimit)
+
+	filter := bson.M{}
+	for _, key := range []string{"sales_id", "customer_phone", "kind", "delivery_note_id"} {
+		if value := c.Query(key); value != "" {
+			filter[key] = value
+		}
+	}
+	if assetType := c.Query("asset_type"); assetType != "" {
+		filter["asset_type"] = strings.ToLower(assetType)
+	}
+
+	collection := database.GetMongoCollection("asset_ledger")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch asset ledger")
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.AssetEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return response.Error(c, 500, "Failed to decode asset ledger")
+	}
+	entries, more := trimPage(pq, entries)
+
+	return response.SuccessWithPagination(c, 200, entries, pq.pagination(total, more))
+}
+
+// Outstanding reports the assets not yet returned per sales and customer, with totals
+// per asset type. Filters: sales_id, asset_type.
+func (h *AssetHandler) Outstanding(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if salesID := c.Query("sales_id"); salesID != "" {
+		filter["sales_id"] = salesID
+	}
+	if assetType := c.Query("asset_type"); assetType != "" {
+		filter["asset_type"] = strings.ToLower(assetType)
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	balances, err := assetBalances(ctx, filter, true)
+	if err != nil {
+		return response.Error(c, 500, "Failed to build outstanding assets report")
+	}
+
+	totals := []models.AssetCount{}
+	index := map[string]int{}
+	for _, balance := range balances {
+		if i, ok := index[balance.AssetType]; ok {
+			totals[i].Quantity += balance.Outstanding
+			continue
+		}
+		index[balance.AssetType] = len(totals)
+		totals = append(totals, models.AssetCount{AssetType: balance.AssetType, Quantity: balance.Outstanding})
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"balances": balances,
+		"totals":   totals,
+	})
+}
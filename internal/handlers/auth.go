@@ -1,21 +1,21 @@
 package handlers
 
 import (
-	"context"
 	"time"
 
 	"bg-go/internal/config"
 	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
 	"bg-go/internal/lib/crypt"
 	"bg-go/internal/lib/jwt"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/session"
 	"bg-go/internal/middleware"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // AuthHandler handles auth routes
@@ -44,7 +44,7 @@ func (h *AuthHandler) Genesis(c *fiber.Ctx) error {
 
 	// Check if superadmin exists
 	collection := database.GetMongoCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	count, _ := collection.CountDocuments(ctx, bson.M{"role": models.RoleSuperAdmin})
@@ -69,8 +69,14 @@ func (h *AuthHandler) Genesis(c *fiber.Ctx) error {
 		return response.Error(c, 500, "Failed to create genesis account")
 	}
 
+	// Start session
+	sess, err := session.Create(user.ID.Hex(), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return response.Error(c, 500, "Failed to create session")
+	}
+
 	// Generate tokens
-	tokenPair, err := jwt.GenerateTokenPair(user.ID.Hex(), user.Role)
+	tokenPair, err := jwt.GenerateTokenPair(user.ID.Hex(), user.Role, sess.ID.Hex())
 	if err != nil {
 		return response.Error(c, 500, "Failed to generate tokens")
 	}
@@ -104,32 +110,38 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 
 	var req LoginRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	// Find user
 	collection := database.GetMongoCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	user := &models.User{}
 	err := collection.FindOne(ctx, bson.M{"username": req.Username}).Decode(user)
 	if err != nil {
-		return response.Error(c, 400, "Invalid credentials")
+		return response.ErrorCode(c, 400, response.CodeInvalidCredentials, "Invalid credentials")
 	}
 
 	// Verify password
 	if !crypt.CheckPassword(req.Password, user.Password) {
-		return response.Error(c, 400, "Invalid credentials")
+		return response.ErrorCode(c, 400, response.CodeInvalidCredentials, "Invalid credentials")
 	}
 
 	// Check if user is active
 	if !user.IsActive {
-		return response.Error(c, 403, "Account is deactivated")
+		return response.ErrorCode(c, 403, response.CodeAccountDeactivated, "Account is deactivated")
+	}
+
+	// Start session
+	sess, err := session.Create(user.ID.Hex(), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return response.Error(c, 500, "Failed to create session")
 	}
 
 	// Generate tokens
-	tokenPair, err := jwt.GenerateTokenPair(user.ID.Hex(), user.Role)
+	tokenPair, err := jwt.GenerateTokenPair(user.ID.Hex(), user.Role, sess.ID.Hex())
 	if err != nil {
 		return response.Error(c, 500, "Failed to generate tokens")
 	}
@@ -167,30 +179,33 @@ func (h *AuthHandler) Me(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return response.Error(c, 400, "Invalid user ID")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid user ID")
 	}
 
 	collection := database.GetMongoCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	user := &models.User{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(user)
 	if err != nil {
-		return response.Error(c, 404, "User not found")
+		return response.ErrorCode(c, 404, response.CodeUserNotFound, "User not found")
 	}
 
 	// Return response (Express style: data at root level)
 	return response.SuccessWithData(c, 200, fiber.Map{
-		"status":       200,
-		"message":      "success",
-		"id":           user.ID.Hex(),
-		"username":     user.Username,
-		"display_name": user.DisplayName,
-		"role":         user.Role,
-		"email":        user.Email,
-		"is_active":    user.IsActive,
-		"created_at":   user.CreatedAt,
+		"status":        200,
+		"message":       "success",
+		"id":            user.ID.Hex(),
+		"username":      user.Username,
+		"display_name":  user.DisplayName,
+		"role":          user.Role,
+		"email":         user.Email,
+		"avatar":        user.Avatar,
+		"is_active":     user.IsActive,
+		"created_at":    user.CreatedAt,
+		"impersonating": middleware.IsImpersonating(c),
+		"actor_id":      middleware.GetActorID(c),
 	})
 }
 
@@ -203,16 +218,26 @@ func (h *AuthHandler) Me(c *fiber.Ctx) error {
 func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
 	refreshToken := c.Cookies("refresh_token")
 	if refreshToken == "" {
-		return response.Error(c, 401, "No refresh token")
+		return response.ErrorCode(c, 401, response.CodeRefreshTokenInvalid, "No refresh token")
 	}
 
 	claims, err := jwt.VerifyRefreshToken(refreshToken)
 	if err != nil {
-		return response.Unauthorized(c, "Invalid refresh token")
+		return response.ErrorCode(c, 401, response.CodeRefreshTokenInvalid, "Invalid refresh token")
+	}
+
+	// Check the session is still active. Refresh tokens issued before session tracking
+	// existed cannot be revoked, so their holders log in again.
+	sessionID := claims.SessionID
+	if sessionID == "" {
+		return response.ErrorCode(c, 401, response.CodeSessionRevoked, "Session has expired, log in again")
+	}
+	if err := session.Touch(sessionID, c.IP(), c.Get("User-Agent")); err != nil {
+		return response.ErrorCode(c, 401, response.CodeSessionRevoked, "Session has been revoked")
 	}
 
 	// Generate new tokens
-	tokenPair, err := jwt.GenerateTokenPair(claims.UserID, claims.Role)
+	tokenPair, err := jwt.GenerateTokenPair(claims.UserID, claims.Role, sessionID)
 	if err != nil {
 		return response.Error(c, 500, "Failed to generate tokens")
 	}
@@ -244,12 +269,9 @@ func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
 // @Success 200 {object} map[string]interface{}
 // @Router /auth/users [get]
 func (h *AuthHandler) ListUsers(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
+	pq := parsePage(c, 10, maxPageLimit)
 	search := c.Query("search")
 
-	skip := (page - 1) * limit
-
 	filter := bson.M{}
 	if search != "" {
 		filter = bson.M{
@@ -261,16 +283,14 @@ func (h *AuthHandler) ListUsers(c *fiber.Ctx) error {
 	}
 
 	collection := database.GetMongoCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	// Get total count
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
 	// Get users
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
+	findOptions := pq.findOptions().
 		SetSort(bson.D{{Key: "created_at", Value: -1}})
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
@@ -281,8 +301,9 @@ func (h *AuthHandler) ListUsers(c *fiber.Ctx) error {
 
 	var users []models.User
 	cursor.All(ctx, &users)
+	users, more := trimPage(pq, users)
 
-	return response.SuccessWithPagination(c, 200, users, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, users, pq.pagination(total, more))
 }
 
 // Register creates a new user (admin only)
@@ -297,22 +318,22 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 
 	var req RegisterRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	// Validate
 	if req.Username == "" || req.Password == "" {
-		return response.BadRequest(c, "Username and password required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Username and password required")
 	}
 
 	collection := database.GetMongoCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Check if username exists
 	count, _ := collection.CountDocuments(ctx, bson.M{"username": req.Username})
 	if count > 0 {
-		return response.Error(c, 400, "Username already exists")
+		return response.ErrorCode(c, 409, response.CodeUsernameTaken, "Username already exists")
 	}
 
 	// Hash password
@@ -335,9 +356,14 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 
 	_, err = collection.InsertOne(ctx, user)
 	if err != nil {
-		return response.Error(c, 500, "Failed to create user")
+		return writeFailed(c, err, "Failed to create user")
 	}
 
+	audit.Log(c, audit.ActionUserCreate, "user", user.ID.Hex(), map[string]interface{}{
+		"username": user.Username,
+		"role":     user.Role,
+	})
+
 	return response.Success(c, 201, fiber.Map{
 		"id":           user.ID.Hex(),
 		"username":     user.Username,
@@ -352,7 +378,7 @@ func (h *AuthHandler) UpdateUser(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid user ID")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid user ID")
 	}
 
 	type UpdateRequest struct {
@@ -366,11 +392,11 @@ func (h *AuthHandler) UpdateUser(c *fiber.Ctx) error {
 
 	var req UpdateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	collection := database.GetMongoCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Build update
@@ -382,7 +408,7 @@ func (h *AuthHandler) UpdateUser(c *fiber.Ctx) error {
 			"_id":      bson.M{"$ne": objID},
 		})
 		if count > 0 {
-			return response.Error(c, 400, "Username already exists")
+			return response.ErrorCode(c, 409, response.CodeUsernameTaken, "Username already exists")
 		}
 		update["username"] = req.Username
 	}
@@ -408,12 +434,20 @@ func (h *AuthHandler) UpdateUser(c *fiber.Ctx) error {
 
 	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
 	if err != nil {
-		return response.Error(c, 500, "Failed to update user")
+		return writeFailed(c, err, "Failed to update user")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "User not found")
+		return response.NotFoundCode(c, response.CodeUserNotFound, "User not found")
 	}
 
+	// Deactivated users are signed out everywhere
+	if req.IsActive != nil && !*req.IsActive {
+		session.RevokeAll(id, middleware.GetActorID(c))
+	}
+
+	delete(update, "password")
+	audit.Log(c, audit.ActionUserUpdate, "user", id, update)
+
 	return response.Success(c, 200, fiber.Map{
 		"message": "User updated successfully",
 	})
@@ -425,18 +459,18 @@ func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid user ID")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid user ID")
 	}
 
 	collection := database.GetMongoCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Check if user exists and is not superadmin
 	var user models.User
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
 	if err != nil {
-		return response.NotFound(c, "User not found")
+		return response.NotFoundCode(c, response.CodeUserNotFound, "User not found")
 	}
 
 	if user.Role == models.RoleSuperAdmin {
@@ -448,10 +482,144 @@ func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
 		return response.Error(c, 500, "Failed to delete user")
 	}
 	if result.DeletedCount == 0 {
-		return response.NotFound(c, "User not found")
+		return response.NotFoundCode(c, response.CodeUserNotFound, "User not found")
 	}
 
+	session.RevokeAll(id, middleware.GetActorID(c))
+
+	audit.Log(c, audit.ActionUserDelete, "user", id, map[string]interface{}{
+		"username": user.Username,
+	})
+
 	return response.Success(c, 200, fiber.Map{
 		"message": "User deleted successfully",
 	})
 }
+
+// Impersonate issues a short-lived token to act as another user (superadmin only)
+func (h *AuthHandler) Impersonate(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid user ID")
+	}
+
+	if middleware.IsImpersonating(c) {
+		return response.ErrorCode(c, 400, response.CodeImpersonationNotAllowed, "Already impersonating a user")
+	}
+
+	actorID := middleware.GetUserID(c)
+	if actorID == id {
+		return response.ErrorCode(c, 400, response.CodeImpersonationNotAllowed, "Cannot impersonate yourself")
+	}
+
+	collection := database.GetMongoCollection("users")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	user := &models.User{}
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(user)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeUserNotFound, "User not found")
+	}
+
+	if user.Role != models.RoleUser {
+		return response.ErrorCode(c, 400, response.CodeImpersonationNotAllowed, "Only USER accounts can be impersonated")
+	}
+
+	if !user.IsActive {
+		return response.ErrorCode(c, 400, response.CodeAccountDeactivated, "Account is deactivated")
+	}
+
+	accessToken, err := jwt.GenerateImpersonationToken(user.ID.Hex(), user.Role, actorID)
+	if err != nil {
+		return response.Error(c, 500, "Failed to generate tokens")
+	}
+
+	audit.Log(c, audit.ActionImpersonate, "user", user.ID.Hex(), map[string]interface{}{
+		"username": user.Username,
+	})
+
+	return response.SuccessWithData(c, 200, fiber.Map{
+		"status":        200,
+		"message":       "success",
+		"id":            user.ID.Hex(),
+		"username":      user.Username,
+		"display_name":  user.DisplayName,
+		"role":          user.Role,
+		"actor_id":      actorID,
+		"impersonating": true,
+		"access_token":  accessToken,
+		"expires_in":    int64(config.Cfg.JWT.ImpersonationExpiry.Seconds()),
+	})
+}
+
+// ListSessions lists active sessions of the current user
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	sessions, err := session.ListActive(userID)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch sessions")
+	}
+
+	currentID := ""
+	if claims := middleware.GetClaims(c); claims != nil {
+		currentID = claims.SessionID
+	}
+
+	type SessionView struct {
+		models.Session
+		Current bool `json:"current"`
+	}
+
+	result := []SessionView{}
+	for _, s := range sessions {
+		result = append(result, SessionView{
+			Session: s,
+			Current: s.ID.Hex() == currentID,
+		})
+	}
+
+	return response.Success(c, 200, result)
+}
+
+// RevokeSession revokes one of the current user's sessions
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	id := c.Params("id")
+	userID := middleware.GetUserID(c)
+
+	err := session.Revoke(id, userID, middleware.GetActorID(c))
+	if err == session.ErrSessionInvalid {
+		return response.NotFound(c, "Session not found")
+	}
+	if err != nil {
+		return response.Error(c, 500, "Failed to revoke session")
+	}
+
+	return response.SuccessWithMessage(c, 200, "Session revoked")
+}
+
+// RevokeUserSessions revokes all sessions of a user (admin only)
+func (h *AuthHandler) RevokeUserSessions(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid user ID")
+	}
+
+	revoked, err := session.RevokeAll(id, middleware.GetActorID(c))
+	if err != nil {
+		return response.Error(c, 500, "Failed to revoke sessions")
+	}
+
+	audit.Log(c, audit.ActionSessionRevokeAll, "user", id, map[string]interface{}{
+		"revoked": revoked,
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"message": "Sessions revoked",
+		"revoked": revoked,
+	})
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/crypt"
+	"bg-go/internal/lib/mailer"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// generateInviteToken returns a random invite token and the hash stored for it
+func generateInviteToken() (token string, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashInviteToken(token), nil
+}
+
+// hashInviteToken hashes an invite token for storage and lookup
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// inviteLink is the page where the invitee sets their password
+func inviteLink(token string) string {
+	return config.Cfg.Client.URL + "/accept-invite/" + token
+}
+
+// sendInvite delivers the invite link by email and WhatsApp and returns the channels it
+// went out on. Delivery failures are logged, the invite itself stays valid.
+func sendInvite(user *models.User, link string) []string {
+	name := user.DisplayName
+	if name == "" {
+		name = user.Username
+	}
+	message := notification.RenderInvite(name, config.Cfg.App.Name, link, user.InviteExpiresAt.Format("02/01/2006 15:04"))
+
+	channels := []string{}
+	if user.Email != "" && mailer.Enabled() {
+		if err := mailer.Send([]string{user.Email}, "Undangan "+config.Cfg.App.Name, message); err != nil {
+			log.Printf("[Auth] Failed to email invite to %s: %v", user.Username, err)
+		} else {
+			channels = append(channels, "email")
+		}
+	}
+	if user.Phone != "" {
+		if _, err := notification.SendInviteNotification(user.Phone, message); err != nil {
+			log.Printf("[Auth] Failed to send invite to %s over WhatsApp: %v", user.Username, err)
+		} else {
+			channels = append(channels, "whatsapp")
+		}
+	}
+	return channels
+}
+
+// inviteResult is the invite response. The link is only returned when it could not be
+// delivered, so the admin can pass it on by hand.
+func inviteResult(user *models.User, token string, channels []string) fiber.Map {
+	result := fiber.Map{
+		"id":                user.ID.Hex(),
+		"username":          user.Username,
+		"display_name":      user.DisplayName,
+		"role":              user.Role,
+		"invite_expires_at": user.InviteExpiresAt,
+		"sent_via":          channels,
+	}
+	if len(channels) == 0 {
+		result["invite_link"] = inviteLink(token)
+	}
+	return result
+}
+
+// Invite creates a user without a password and sends them a one-time link to set their
+// own (admin only)
+func (h *AuthHandler) Invite(c *fiber.Ctx) error {
+	type InviteRequest struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+		Email       string `json:"email,omitempty"`
+		Phone       string `json:"phone,omitempty"`
+		Role        string `json:"role,omitempty"`
+	}
+
+	var req InviteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Username == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Username required")
+	}
+	if req.Email == "" && req.Phone == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Email or phone required to deliver the invite")
+	}
+	if req.Email != "" && !strings.Contains(req.Email, "@") {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid email")
+	}
+	if req.Phone != "" {
+		phone, err := utils.NormalizePhone(req.Phone)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid phone number")
+		}
+		req.Phone = phone
+	}
+
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	switch req.Role {
+	case models.RoleUser, models.RoleAdmin:
+	case models.RoleSuperAdmin:
+		if middleware.GetUserRole(c) != models.RoleSuperAdmin {
+			return response.ErrorCode(c, 403, response.CodeForbidden, "Only a SUPERADMIN can invite a SUPERADMIN")
+		}
+	default:
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid role")
+	}
+
+	collection := database.GetMongoCollection("users")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	count, _ := collection.CountDocuments(ctx, bson.M{"username": req.Username})
+	if count > 0 {
+		return response.ErrorCode(c, 409, response.CodeUsernameTaken, "Username already exists")
+	}
+
+	token, hash, err := generateInviteToken()
+	if err != nil {
+		return response.Error(c, 500, "Failed to create invite token")
+	}
+	expiresAt := time.Now().Add(config.Cfg.JWT.InviteExpiry)
+
+	// The password stays empty until the invite is accepted, so the user cannot log in
+	user := models.NewUser()
+	user.Username = req.Username
+	user.DisplayName = req.DisplayName
+	user.Email = req.Email
+	user.Phone = req.Phone
+	user.Role = req.Role
+	user.InviteTokenHash = hash
+	user.InviteExpiresAt = &expiresAt
+	user.InvitedBy = middleware.GetUserID(c)
+
+	if _, err := collection.InsertOne(ctx, user); err != nil {
+		return writeFailed(c, err, "Failed to create user")
+	}
+
+	channels := sendInvite(user, inviteLink(token))
+
+	audit.Log(c, audit.ActionUserInvite, "user", user.ID.Hex(), map[string]interface{}{
+		"username": user.Username,
+		"role":     user.Role,
+		"sent_via": channels,
+	})
+
+	return response.Success(c, 201, inviteResult(user, token, channels))
+}
+
+// ResendInvite replaces the invite token of a user who has not accepted yet, which
+// invalidates the previous link (admin only)
+func (h *AuthHandler) ResendInvite(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("users")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	user := &models.User{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(user); err != nil {
+		return response.ErrorCode(c, 404, response.CodeUserNotFound, "User not found")
+	}
+	if user.InviteAcceptedAt != nil || user.InvitedBy == "" {
+		return response.ErrorCode(c, 400, response.CodeInviteInvalid, "User has no pending invite")
+	}
+	// The link may come back in the response, which would hand the account to the caller
+	if user.Role == models.RoleSuperAdmin && middleware.GetUserRole(c) != models.RoleSuperAdmin {
+		return response.ErrorCode(c, 403, response.CodeForbidden, "Only a SUPERADMIN can resend a SUPERADMIN invite")
+	}
+
+	token, hash, err := generateInviteToken()
+	if err != nil {
+		return response.Error(c, 500, "Failed to create invite token")
+	}
+	expiresAt := time.Now().Add(config.Cfg.JWT.InviteExpiry)
+	update := bson.M{"$set": bson.M{
+		"invite_token_hash": hash,
+		"invite_expires_at": expiresAt,
+		"updated_at":        time.Now(),
+	}}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID, "invite_accepted_at": bson.M{"$exists": false}}, update)
+	if err != nil {
+		return response.Error(c, 500, "Failed to renew invite")
+	}
+	if result.MatchedCount == 0 {
+		return response.ErrorCode(c, 400, response.CodeInviteInvalid, "User has no pending invite")
+	}
+	user.InviteExpiresAt = &expiresAt
+
+	channels := sendInvite(user, inviteLink(token))
+
+	audit.Log(c, audit.ActionUserInvite, "user", user.ID.Hex(), map[string]interface{}{
+		"username": user.Username,
+		"resent":   true,
+		"sent_via": channels,
+	})
+
+	return response.Success(c, 200, inviteResult(user, token, channels))
+}
+
+// findInvite loads the user of a pending invite token and answers the request when the
+// token is unknown or expired
+func findInvite(c *fiber.Ctx, token string) (*models.User, error) {
+	if token == "" {
+		return nil, response.ErrorCode(c, 400, response.CodeInviteInvalid, "Invalid invite link")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	user := &models.User{}
+	err := database.GetMongoCollection("users").FindOne(ctx, bson.M{"invite_token_hash": hashInviteToken(token)}).Decode(user)
+	if err != nil {
+		return nil, response.ErrorCode(c, 400, response.CodeInviteInvalid, "Invalid invite link")
+	}
+	if user.InviteExpiresAt == nil || time.Now().After(*user.InviteExpiresAt) {
+		return nil, response.ErrorCode(c, 410, response.CodeInviteExpired, "Invite link has expired")
+	}
+	if !user.IsActive {
+		return nil, response.ErrorCode(c, 403, response.CodeAccountDeactivated, "Account is deactivated")
+	}
+	return user, nil
+}
+
+// GetInvite checks an invite link before the password form is shown (public)
+func (h *AuthHandler) GetInvite(c *fiber.Ctx) error {
+	user, err := findInvite(c, c.Params("token"))
+	if user == nil {
+		return err
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"username":          user.Username,
+		"display_name":      user.DisplayName,
+		"invite_expires_at": user.InviteExpiresAt,
+	})
+}
+
+// AcceptInvite sets the invitee's password and uses up the invite token (public)
+func (h *AuthHandler) AcceptInvite(c *fiber.Ctx) error {
+	type AcceptRequest struct {
+		Password string `json:"password"`
+	}
+
+	var req AcceptRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if len(req.Password) < minPasswordLength {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Password must be at least 8 characters")
+	}
+
+	token := c.Params("token")
+	user, err := findInvite(c, token)
+	if user == nil {
+		return err
+	}
+
+	hashedPassword, err := crypt.HashPassword(req.Password)
+	if err != nil {
+		return response.Error(c, 500, "Failed to hash password")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"password":           hashedPassword,
+			"invite_accepted_at": now,
+			"updated_at":         now,
+		},
+		"$unset": bson.M{"invite_token_hash": "", "invite_expires_at": ""},
+	}
+	// Matching on the token hash makes the link single-use even under concurrent accepts
+	result, err := database.GetMongoCollection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "invite_token_hash": hashInviteToken(token)}, update)
+	if err != nil {
+		return response.Error(c, 500, "Failed to accept invite")
+	}
+	if result.ModifiedCount == 0 {
+		return response.ErrorCode(c, 400, response.CodeInviteInvalid, "Invalid invite link")
+	}
+
+	c.Locals("user_id", user.ID.Hex())
+	audit.Log(c, audit.ActionInviteAccept, "user", user.ID.Hex(), map[string]interface{}{
+		"username": user.Username,
+	})
+
+	return response.SuccessWithMessage(c, 200, "Password set, you can now log in")
+}
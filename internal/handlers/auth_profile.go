@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/crypt"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/session"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// minPasswordLength is the shortest password a user may choose for themselves
+const minPasswordLength = 8
+
+// currentUser loads the user making the request
+func currentUser(c *fiber.Ctx) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(middleware.GetUserID(c))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	user := &models.User{}
+	if err := database.GetMongoCollection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateMe lets users update their own display name, email and password. Changing the
+// password needs the current password and signs out the user's other sessions.
+func (h *AuthHandler) UpdateMe(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		DisplayName     *string `json:"display_name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+		NewPassword     string  `json:"new_password"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return response.ErrorCode(c, 404, response.CodeUserNotFound, "User not found")
+	}
+
+	update := bson.M{"updated_at": time.Now()}
+	changed := []string{}
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if name == "" {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Display name cannot be empty")
+		}
+		update["display_name"] = name
+		changed = append(changed, "display_name")
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != "" && !strings.Contains(email, "@") {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid email")
+		}
+		update["email"] = email
+		changed = append(changed, "email")
+	}
+	if req.NewPassword != "" {
+		// An impersonating admin must not be able to take over the account
+		if middleware.IsImpersonating(c) {
+			return response.ErrorCode(c, 403, response.CodeForbidden, "Password cannot be changed while impersonating")
+		}
+		if !crypt.CheckPassword(req.CurrentPassword, user.Password) {
+			return response.ErrorCode(c, 400, response.CodeInvalidCredentials, "Current password is wrong")
+		}
+		if len(req.NewPassword) < minPasswordLength {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "New password must be at least 8 characters")
+		}
+		hashedPassword, err := crypt.HashPassword(req.NewPassword)
+		if err != nil {
+			return response.Error(c, 500, "Failed to hash password")
+		}
+		update["password"] = hashedPassword
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if _, err := database.GetMongoCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": update}); err != nil {
+		return response.Error(c, 500, "Failed to update profile")
+	}
+
+	if len(changed) > 0 {
+		audit.Log(c, audit.ActionProfileUpdate, "user", user.ID.Hex(), map[string]interface{}{
+			"changed": changed,
+		})
+	}
+	if req.NewPassword != "" {
+		sessionID := ""
+		if claims := middleware.GetClaims(c); claims != nil {
+			sessionID = claims.SessionID
+		}
+		revoked, err := session.RevokeOthers(user.ID.Hex(), sessionID, user.ID.Hex())
+		if err != nil {
+			log.Printf("[Auth] Failed to revoke other sessions of %s: %v", user.Username, err)
+		}
+		audit.Log(c, audit.ActionPasswordChange, "user", user.ID.Hex(), map[string]interface{}{
+			"revoked_sessions": revoked,
+		})
+	}
+
+	return response.SuccessWithMessage(c, 200, "Profile updated successfully")
+}
+
+// UploadAvatar replaces the profile picture of the current user
+func (h *AuthHandler) UploadAvatar(c *fiber.Ctx) error {
+	formFile, err := c.FormFile("avatar")
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No image provided")
+	}
+	if !file.IsAllowedFileType(formFile.Filename) {
+		return response.ErrorCode(c, 400, response.CodeFileTypeNotAllowed, "File type not allowed")
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return response.ErrorCode(c, 404, response.CodeUserNotFound, "User not found")
+	}
+
+	uploadResult, err := file.UploadFile(formFile, file.CategoryAvatar)
+	if err != nil {
+		return uploadFailed(c, err, "Failed to upload avatar")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	avatar := uploadResult.Image()
+	update := bson.M{
+		"avatar":     avatar,
+		"updated_at": time.Now(),
+	}
+	if _, err := database.GetMongoCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": update}); err != nil {
+		file.DeleteFile(avatar.PublicID)
+		return response.Error(c, 500, "Failed to update avatar")
+	}
+
+	// The old picture is removed only once the new one is saved
+	if user.Avatar != nil && user.Avatar.PublicID != "" {
+		go file.DeleteFile(user.Avatar.PublicID)
+	}
+
+	return response.Success(c, 200, avatar)
+}
+
+// DeleteAvatar removes the profile picture of the current user
+func (h *AuthHandler) DeleteAvatar(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return response.ErrorCode(c, 404, response.CodeUserNotFound, "User not found")
+	}
+	if user.Avatar == nil {
+		return response.SuccessWithMessage(c, 200, "No avatar to remove")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	update := bson.M{
+		"$unset": bson.M{"avatar": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	}
+	if _, err := database.GetMongoCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+		return response.Error(c, 500, "Failed to remove avatar")
+	}
+
+	if user.Avatar.PublicID != "" {
+		go file.DeleteFile(user.Avatar.PublicID)
+	}
+
+	return response.SuccessWithMessage(c, 200, "Avatar removed")
+}
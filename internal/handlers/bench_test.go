@@ -0,0 +1,104 @@
+package handlers_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/models"
+	"bg-go/internal/testutil"
+)
+
+// Benchmarks of the list and polling endpoints. Besides time per request they report
+// queries/op, the database round trips of one request, which grows with the page size
+// when related documents are looked up one order at a time:
+//
+//	go test -run '^$' -bench . -benchmem ./internal/handlers/
+//
+// The in-memory database scans every document, so index changes only show in a load
+// test against MongoDB (cmd/loadtest).
+
+// seedOrders stores count orders spread over salesCount sales reps, with status
+func seedOrders(h *testutil.Harness, count int, salesCount int, status string) []*models.Order {
+	sales := make([]*models.Sales, salesCount)
+	for i := range sales {
+		sales[i] = seedSales(h)
+	}
+
+	orders := make([]*models.Order, count)
+	for i := range orders {
+		orders[i] = seedOrder(h, sales[i%salesCount], func(o *models.Order) {
+			withProof(o)
+			o.Status = status
+			o.QueueNumber = i + 1
+			o.CreatedAt = time.Now().Add(-time.Duration(i) * time.Minute)
+		})
+	}
+	return orders
+}
+
+// benchmarkGet requests path once per iteration and reports the queries of a request
+func benchmarkGet(b *testing.B, h *testutil.Harness, path string, token string) {
+	b.Helper()
+
+	// Handler logs would drown the benchmark output
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	start := h.Mongo.Queries()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp := h.Request("GET", path, nil, token)
+		if resp.Status != 200 || resp.ErrorCode() != "" {
+			b.Fatalf("GET %s: status = %d: %s", path, resp.Status, resp.Raw)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(h.Mongo.Queries()-start)/float64(b.N), "queries/op")
+}
+
+func BenchmarkListOrders(b *testing.B) {
+	for _, limit := range []int{10, 50, 100} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			h := testutil.New(b)
+			seedOrders(h, 500, 20, models.OrderStatusPaid)
+			benchmarkGet(b, h, fmt.Sprintf("/api/v1/orders?limit=%d", limit), h.Token(models.RoleAdmin))
+		})
+	}
+}
+
+func BenchmarkListPendingPayments(b *testing.B) {
+	for _, limit := range []int{10, 50} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			h := testutil.New(b)
+			seedOrders(h, 200, 20, models.OrderStatusPaid)
+			benchmarkGet(b, h, fmt.Sprintf("/api/v1/payments/pending?limit=%d", limit), h.Token(models.RoleAdmin))
+		})
+	}
+}
+
+func BenchmarkListQueue(b *testing.B) {
+	h := testutil.New(b)
+	seedOrders(h, 50, 10, models.OrderStatusQueued)
+	benchmarkGet(b, h, "/api/v1/queue/?limit=50", h.Token(models.RoleAdmin))
+}
+
+func BenchmarkClientPolling(b *testing.B) {
+	h := testutil.New(b)
+	orders := seedOrders(h, 50, 10, models.OrderStatusQueued)
+	token := orders[len(orders)-1].InvoiceToken
+	// Measure the handler, not the rejection of a client polling in a tight loop
+	b.Cleanup(func() { config.Cfg.Client.PollEnforce = true })
+	config.Cfg.Client.PollEnforce = false
+
+	b.Run("status", func(b *testing.B) {
+		benchmarkGet(b, h, "/api/v1/client/status/"+token, "")
+	})
+	b.Run("queue", func(b *testing.B) {
+		benchmarkGet(b, h, "/api/v1/client/queue/"+token, "")
+	})
+}
@@ -2,20 +2,32 @@ package handlers
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
+	"bg-go/internal/config"
 	"bg-go/internal/database"
+	"bg-go/internal/lib/barcode"
+	"bg-go/internal/lib/clientview"
 	"bg-go/internal/lib/file"
-	"bg-go/internal/lib/qrcode"
+	"bg-go/internal/lib/invoiceview"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/otp"
+	"bg-go/internal/lib/polling"
+	"bg-go/internal/lib/queuetime"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/screening"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ClientHandler handles client-side routes (public with token)
@@ -26,11 +38,35 @@ func NewClientHandler() *ClientHandler {
 	return &ClientHandler{}
 }
 
-// generateQueueBarcode generates a unique barcode for queue
-func generateQueueBarcode() string {
-	b := make([]byte, 8)
-	rand.Read(b)
-	return hex.EncodeToString(b)
+// queueBarcodeFields generates a queue barcode in the configured format.
+// The QR image is always included; 1D formats add a linear barcode image of the same code.
+func queueBarcodeFields(ctx context.Context) (bson.M, error) {
+	format := loadCompanySettings(ctx).BarcodeFormat
+	if !barcode.IsValidFormat(format) {
+		format = barcode.FormatQR
+	}
+
+	code := barcode.NewCode(format)
+	qrCodeBase64, err := barcode.GenerateQRCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := bson.M{
+		"queue_barcode":  code,
+		"queue_qrcode":   qrCodeBase64,
+		"barcode_format": format,
+		"barcode_image":  "",
+	}
+	if barcode.IsLinear(format) {
+		image, err := barcode.Generate(format, code)
+		if err != nil {
+			return nil, err
+		}
+		fields["barcode_image"] = image
+	}
+
+	return fields, nil
 }
 
 // GetInvoice returns invoice data by token
@@ -38,11 +74,11 @@ func (h *ClientHandler) GetInvoice(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	order := &models.Order{}
@@ -51,6 +87,9 @@ func (h *ClientHandler) GetInvoice(c *fiber.Ctx) error {
 		return response.NotFound(c, "Invoice not found")
 	}
 
+	// Log the view; the request's strings are reused by fiber once the handler returns
+	go invoiceview.Record(order.ID, order.OrderNumber, strings.Clone(c.IP()), strings.Clone(c.Get("User-Agent")))
+
 	// Populate sales and product data
 	if order.SalesID != "" {
 		salesCollection := database.GetMongoCollection("sales")
@@ -67,6 +106,15 @@ func (h *ClientHandler) GetInvoice(c *fiber.Ctx) error {
 		order.Product = product
 	}
 
+	// Notes shared with sales
+	order.Notes, err = sharedNotes(ctx, order.ID.Hex())
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch notes")
+	}
+	order.NeedsAccept = needsAcceptance(order)
+	order.DriverNeedsOTP = config.Cfg.Client.DriverOTP
+	media.SignOrder(order)
+
 	return response.Success(c, 200, order)
 }
 
@@ -75,120 +123,174 @@ func (h *ClientHandler) UploadPayment(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
 	formFile, err := c.FormFile("proof")
 	if err != nil {
-		return response.BadRequest(c, "No file provided")
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No file provided")
 	}
 
-	uploadResult, err := file.UploadFile(formFile)
+	uploadResult, err := file.UploadFile(formFile, file.CategoryPaymentProof)
 	if err != nil {
-		return response.Error(c, 500, "Failed to upload file")
+		return uploadFailed(c, err, "Failed to upload file")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Find order by invoice token
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	// Check if payment can be uploaded
 	if order.Status != models.OrderStatusPending {
-		return response.BadRequest(c, "Cannot upload payment for this order status")
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Cannot upload payment for this order status")
 	}
 
 	if order.PaymentStatus == models.PaymentStatusVerified {
-		return response.BadRequest(c, "Payment already verified")
+		return response.ErrorCode(c, 400, response.CodePaymentAlreadyVerified, "Payment already verified")
 	}
 
-	now := time.Now()
-	paymentProof := &models.Image{
-		PublicID: uploadResult.PublicID,
-		URL:      uploadResult.URL,
+	if needsAcceptance(order) {
+		return response.ErrorCode(c, 400, response.CodeOrderNotAccepted, "Order must be accepted before uploading payment")
+	}
+
+	if order.PendingChange != nil {
+		return response.ErrorCode(c, 400, response.CodeOrderChangePending, "Confirm the change to the order before uploading payment")
 	}
 
+	now := time.Now()
+	paymentProof := uploadResult.Image()
+
 	update := bson.M{
 		"payment_proof":       paymentProof,
 		"payment_uploaded_at": now,
 		"status":              models.OrderStatusPaid,
 		"updated_at":          now,
 	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusPaid, "")}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"invoice_token": token}, bson.M{"$set": update})
+	// Checked again in the filter, so a change staged or a status set since the read
+	// above is not overwritten
+	result, err := collection.UpdateOne(ctx, bson.M{
+		"invoice_token":  token,
+		"status":         models.OrderStatusPending,
+		"payment_status": bson.M{"$ne": models.PaymentStatusVerified},
+		"pending_change": bson.M{"$exists": false},
+	}, bson.M{"$set": update, "$push": statusChange})
 	if err != nil {
 		return response.Error(c, 500, "Failed to update order")
 	}
+	if result.MatchedCount == 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "The order changed while uploading, reload it and upload again")
+	}
+
+	matchUploadedProof(ctx, order)
+
+	go notification.NotifyStatusChange(order.ID, models.OrderStatusPaid)
+	go screening.Screen(order.ID)
 
 	return response.Success(c, 200, fiber.Map{
 		"message":       "Payment proof uploaded successfully",
-		"payment_proof": paymentProof,
+		"payment_proof": media.Sign(paymentProof, order.ID.Hex(), media.KindPaymentProof),
 		"status":        models.OrderStatusPaid,
 	})
 }
 
+// driverRequest is the driver data entered on the invoice page
+type driverRequest struct {
+	DriverName   string `json:"driver_name"`
+	DriverPhone  string `json:"driver_phone"`
+	VehiclePlate string `json:"vehicle_plate"`
+	Code         string `json:"code"` // Sent to the sales rep when driver codes are enabled
+}
+
+// rejection is why a client submission was refused
+type rejection struct {
+	Status  int
+	Code    response.Code
+	Message string
+}
+
+// send writes the rejection as the error response of the request
+func (r *rejection) send(c *fiber.Ctx) error {
+	if r.Status == 200 {
+		return response.NotFoundCode(c, r.Code, r.Message)
+	}
+	return response.ErrorCode(c, r.Status, r.Code, r.Message)
+}
+
+// validateDriver checks driver data against the order of an invoice token, without the
+// verification code. Journal replays use it too, so keep every check here.
+func validateDriver(ctx context.Context, token string, req *driverRequest) (*models.Order, *rejection) {
+	if req.DriverName == "" || req.DriverPhone == "" || req.VehiclePlate == "" {
+		return nil, &rejection{400, response.CodeValidationFailed, "Driver name, phone, and vehicle plate are required"}
+	}
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"invoice_token": token}).Decode(order); err != nil {
+		return nil, &rejection{200, response.CodeOrderNotFound, "Order not found"}
+	}
+	if order.PaymentStatus != models.PaymentStatusVerified {
+		return nil, &rejection{400, response.CodePaymentNotVerified, "Payment must be verified first"}
+	}
+	if order.ShipmentCount > 0 {
+		return nil, &rejection{400, response.CodeOrderSplit, "The order is split into shipments, ask the admin to enter their drivers"}
+	}
+	if order.LoadedWith != "" {
+		return nil, &rejection{400, response.CodeOrderInvalidStatus, "The order shares a truck with another order, whose driver was already entered"}
+	}
+	return order, nil
+}
+
 // SubmitDriver submits driver data by token
 func (h *ClientHandler) SubmitDriver(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
-	}
-
-	type DriverRequest struct {
-		DriverName   string `json:"driver_name"`
-		DriverPhone  string `json:"driver_phone"`
-		VehiclePlate string `json:"vehicle_plate"`
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
-	var req DriverRequest
+	var req driverRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
-	}
-
-	if req.DriverName == "" || req.DriverPhone == "" || req.VehiclePlate == "" {
-		return response.BadRequest(c, "Driver name, phone, and vehicle plate are required")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
-	// Find order by invoice token
-	order := &models.Order{}
-	err := collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
-	if err != nil {
-		return response.NotFound(c, "Order not found")
+	order, rejected := validateDriver(ctx, token, &req)
+	if rejected != nil {
+		return rejected.send(c)
 	}
 
-	// Check if payment is verified
-	if order.PaymentStatus != models.PaymentStatusVerified {
-		return response.BadRequest(c, "Payment must be verified first")
+	if config.Cfg.Client.DriverOTP {
+		if req.Code == "" {
+			return response.ErrorCode(c, 400, response.CodeOTPRequired, "Request a verification code first")
+		}
+		if err := otp.Verify(ctx, otp.PurposeDriver, order.ID.Hex(), strings.TrimSpace(req.Code)); err != nil {
+			return otpError(c, err)
+		}
 	}
 
 	// Generate barcode and QR code
-	barcode := generateQueueBarcode()
-	qrCodeBase64, err := qrcode.GenerateQRCode(barcode)
+	update, err := queueBarcodeFields(ctx)
 	if err != nil {
 		return response.Error(c, 500, "Failed to generate QR code")
 	}
 
 	now := time.Now()
-	update := bson.M{
-		"driver_name":      req.DriverName,
-		"driver_phone":     req.DriverPhone,
-		"vehicle_plate":    req.VehiclePlate,
-		"driver_filled_at": now,
-		"queue_barcode":    barcode,
-		"queue_qrcode":     qrCodeBase64,
-		"updated_at":       now,
-	}
+	update["driver_name"] = req.DriverName
+	update["driver_phone"] = req.DriverPhone
+	update["vehicle_plate"] = req.VehiclePlate
+	update["driver_filled_at"] = now
+	update["updated_at"] = now
 
 	_, err = collection.UpdateOne(ctx, bson.M{"invoice_token": token}, bson.M{"$set": update})
 	if err != nil {
@@ -199,45 +301,102 @@ func (h *ClientHandler) SubmitDriver(c *fiber.Ctx) error {
 	collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
 
 	return response.Success(c, 200, fiber.Map{
-		"message":       "Driver data submitted successfully",
-		"queue_barcode": order.QueueBarcode,
-		"queue_qrcode":  order.QueueQRCode,
+		"message":        "Driver data submitted successfully",
+		"queue_barcode":  order.QueueBarcode,
+		"queue_qrcode":   order.QueueQRCode,
+		"barcode_format": order.BarcodeFormat,
+		"barcode_image":  order.BarcodeImage,
 	})
 }
 
+// RequestDriverCode sends the code needed to submit driver data to the sales rep's
+// WhatsApp, so a forwarded invoice link alone cannot be used to register a driver
+func (h *ClientHandler) RequestDriverCode(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	if token == "" {
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
+	}
+	if !config.Cfg.Client.DriverOTP {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Driver codes are not enabled")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"invoice_token": token}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if order.PaymentStatus != models.PaymentStatusVerified {
+		return response.ErrorCode(c, 400, response.CodePaymentNotVerified, "Payment must be verified first")
+	}
+
+	salesID, _ := primitive.ObjectIDFromHex(order.SalesID)
+	sales := &models.Sales{}
+	if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesID}).Decode(sales); err != nil || sales.Phone == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "The sales rep has no phone to send the code to")
+	}
+
+	code, err := otp.Issue(ctx, otp.PurposeDriver, order.ID.Hex(), sales.Phone)
+	if errors.Is(err, otp.ErrRateLimited) {
+		return response.ErrorCode(c, 429, response.CodeTooManyRequests, "Too many codes requested, try again in an hour")
+	}
+	if err != nil {
+		return response.Error(c, 500, "Failed to create verification code")
+	}
+
+	message := notification.RenderOTP(notification.SenderName(ctx), code, "mengisi data sopir", order.OrderNumber,
+		strconv.Itoa(int(otp.Expiry.Minutes())))
+	if err := notification.SendOTPNotification(sales.Phone, order.ID.Hex(), message); err != nil {
+		return response.Error(c, 500, "Failed to send verification code")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"message":         "Verification code sent to the sales rep's WhatsApp",
+		"phone":           maskPhone(sales.Phone),
+		"expires_seconds": int(otp.Expiry.Seconds()),
+	})
+}
+
+// maskPhone hides all but the first and last digits of a phone, e.g. 6281******890
+func maskPhone(phone string) string {
+	if len(phone) <= 7 {
+		return phone
+	}
+	return phone[:4] + strings.Repeat("*", len(phone)-7) + phone[len(phone)-3:]
+}
+
 // UploadVehiclePhoto uploads vehicle photo by token
 func (h *ClientHandler) UploadVehiclePhoto(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
 	formFile, err := c.FormFile("photo")
 	if err != nil {
-		return response.BadRequest(c, "No file provided")
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No file provided")
 	}
 
-	uploadResult, err := file.UploadFile(formFile)
+	uploadResult, err := file.UploadFile(formFile, file.CategoryVehiclePhoto)
 	if err != nil {
-		return response.Error(c, 500, "Failed to upload file")
+		return uploadFailed(c, err, "Failed to upload file")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Find order by invoice token
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
-	vehiclePhoto := &models.Image{
-		PublicID: uploadResult.PublicID,
-		URL:      uploadResult.URL,
-	}
+	vehiclePhoto := uploadResult.Image()
 
 	update := bson.M{
 		"vehicle_photo": vehiclePhoto,
@@ -251,7 +410,7 @@ func (h *ClientHandler) UploadVehiclePhoto(c *fiber.Ctx) error {
 
 	return response.Success(c, 200, fiber.Map{
 		"message":       "Vehicle photo uploaded successfully",
-		"vehicle_photo": vehiclePhoto,
+		"vehicle_photo": media.Sign(vehiclePhoto, order.ID.Hex(), media.KindVehiclePhoto),
 	})
 }
 
@@ -260,67 +419,45 @@ func (h *ClientHandler) GetQueueStatus(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
-	orderCollection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	// Find order by invoice token
-	order := &models.Order{}
-	err := orderCollection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
+	view, err := clientview.ByToken(ctx, token)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	// If order is queued or loading, calculate estimated time
 	estimatedWait := ""
 	var ordersAhead int64 = 0
 	currentLoading := false
+	queue := clientview.QueueCollection()
 
-	if order.Status == models.OrderStatusQueued {
-		// Count orders ahead in queue
-		filter := bson.M{
-			"status":       bson.M{"$in": []string{models.OrderStatusQueued, models.OrderStatusLoading}},
-			"queue_number": bson.M{"$lt": order.QueueNumber},
-		}
-		ordersAhead, _ = orderCollection.CountDocuments(ctx, filter)
+	if view.Status == models.OrderStatusQueued && view.QueueEnteredAt != nil {
+		// Load orders ahead in the order's queue day
+		ahead, _ := queuetime.AheadIn(ctx, queue, *view.QueueEnteredAt, view.QueueNumber)
+		ordersAhead = int64(len(ahead))
 
-		// Calculate estimated wait
-		estimatedMinutes := int(ordersAhead) * models.QueueDurationMinutes
+		// Calculate estimated wait from their loading durations
+		estimatedMinutes := queuetime.Load(ctx).Total(ahead)
 		estimatedWait = formatDuration(estimatedMinutes)
 	}
 
-	if order.Status == models.OrderStatusLoading {
+	if view.Status == models.OrderStatusLoading {
 		currentLoading = true
 	}
 
-	// Get currently loading order info
-	currentOrder := &models.Order{}
-	err = orderCollection.FindOne(ctx, bson.M{"status": models.OrderStatusLoading}).Decode(currentOrder)
-	isLoading := err == nil
-
-	// Populate sales and product data
-	if order.SalesID != "" {
-		salesCollection := database.GetMongoCollection("sales")
-		salesObjID, _ := primitive.ObjectIDFromHex(order.SalesID)
-		sales := &models.Sales{}
-		salesCollection.FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
-		order.Sales = sales
-	}
-	if order.ProductID != "" {
-		productCollection := database.GetMongoCollection("products")
-		productObjID, _ := primitive.ObjectIDFromHex(order.ProductID)
-		product := &models.Product{}
-		productCollection.FindOne(ctx, bson.M{"_id": productObjID}).Decode(product)
-		order.Product = product
-	}
+	// Whether any order is loading right now
+	loading, _ := queue.CountDocuments(ctx, bson.M{"status": models.OrderStatusLoading}, options.Count().SetLimit(1))
+	isLoading := loading > 0
 
 	return response.Success(c, 200, fiber.Map{
-		"order":           order,
-		"status":          order.Status,
-		"queue_number":    order.QueueNumber,
+		"order":           view,
+		"status":          view.Status,
+		"queue_number":    view.QueueNumber,
 		"estimated_wait":  estimatedWait,
 		"orders_ahead":    ordersAhead,
 		"current_loading": currentLoading,
@@ -333,11 +470,11 @@ func (h *ClientHandler) GetDeliveryNote(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
 	deliveryCollection := database.GetMongoCollection("delivery_notes")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Try to find by delivery note token first
@@ -352,48 +489,67 @@ func (h *ClientHandler) GetDeliveryNote(c *fiber.Ctx) error {
 	order := &models.Order{}
 	err = orderCollection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Delivery note not found")
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
 	}
 
 	if order.DeliveryNoteID == "" {
-		return response.NotFound(c, "Delivery note not ready")
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotReady, "Delivery note not ready")
 	}
 
 	noteObjID, _ := primitive.ObjectIDFromHex(order.DeliveryNoteID)
 	err = deliveryCollection.FindOne(ctx, bson.M{"_id": noteObjID}).Decode(note)
 	if err != nil {
-		return response.NotFound(c, "Delivery note not found")
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
 	}
 
 	return response.Success(c, 200, note)
 }
 
-// GetOrderStatus returns current order status for polling
+// GetOrderStatus returns current order status for polling, with the interval the page
+// should poll at (poll_interval, in seconds; 0 to stop). Polls far faster than that get 429.
 func (h *ClientHandler) GetOrderStatus(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
-	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	now := time.Now()
+	pollKey := polling.Key(token, c.IP())
+	if config.Cfg.Client.PollEnforce {
+		if wait := polling.Default.Check(pollKey, now); wait > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+			return response.ErrorCode(c, fiber.StatusTooManyRequests, response.CodeTooManyRequests, "Polling too often, please slow down")
+		}
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
-	order := &models.Order{}
-	err := collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
+	view, err := clientview.ByToken(ctx, token)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
-	return response.Success(c, 200, fiber.Map{
-		"status":            order.Status,
-		"payment_status":    order.PaymentStatus,
-		"queue_number":      order.QueueNumber,
-		"estimated_time":    order.EstimatedTime,
-		"delivery_note_id":  order.DeliveryNoteID,
-		"delivery_note_url": order.DeliveryNoteURL,
-	})
+	status := fiber.Map{
+		"status":            view.Status,
+		"payment_status":    view.PaymentStatus,
+		"needs_accept":      viewNeedsAcceptance(view),
+		"queue_number":      view.QueueNumber,
+		"estimated_time":    view.EstimatedTime,
+		"delivery_note_id":  view.DeliveryNoteID,
+		"delivery_note_url": view.DeliveryNoteURL,
+	}
+	// Tracking for orders delivered to site
+	if view.Shipment != nil {
+		status["shipment"] = view.Shipment
+	}
+
+	interval := polling.Interval(view.Status, view.Shipment)
+	polling.Default.Record(pollKey, interval, now)
+	status["poll_interval"] = int(interval.Seconds())
+
+	return response.Success(c, 200, status)
 }
 
 // formatDuration formats minutes to human readable string
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ConfirmRequest is the body of the sales' answer on the invoice page
+type ConfirmRequest struct {
+	Action string `json:"action"` // "accept" or "decline"
+	Reason string `json:"reason"` // Required when declining
+}
+
+// needsAcceptance reports whether the sales must still accept the order before paying
+func needsAcceptance(order *models.Order) bool {
+	return config.Cfg.Client.RequireAcceptance &&
+		order.Status == models.OrderStatusPending &&
+		order.AcceptedAt == nil
+}
+
+// viewNeedsAcceptance is needsAcceptance for the client view of an order
+func viewNeedsAcceptance(view *models.ClientView) bool {
+	return config.Cfg.Client.RequireAcceptance &&
+		view.Status == models.OrderStatusPending &&
+		!view.Accepted
+}
+
+// Confirm records the sales' accept or decline of a pending order by token.
+// Declined orders move to the declined status with the given reason.
+func (h *ClientHandler) Confirm(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	if token == "" {
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
+	}
+
+	var req ConfirmRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Action != "accept" && req.Action != "decline" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Action must be accept or decline")
+	}
+	if req.Action == "decline" && req.Reason == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Reason is required to decline")
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	if order.Status != models.OrderStatusPending {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order can no longer be accepted or declined")
+	}
+	if order.AcceptedAt != nil {
+		return response.ErrorCode(c, 409, response.CodeConflict, "Order already accepted")
+	}
+
+	// Match the pending, unaccepted state so a concurrent answer cannot overwrite this one
+	filter := bson.M{
+		"_id":         order.ID,
+		"status":      models.OrderStatusPending,
+		"accepted_at": bson.M{"$exists": false},
+	}
+	now := time.Now()
+
+	var update bson.M
+	if req.Action == "accept" {
+		update = bson.M{"$set": bson.M{"accepted_at": now, "updated_at": now}}
+	} else {
+		update = bson.M{
+			"$set": bson.M{
+				"status":         models.OrderStatusDeclined,
+				"declined_at":    now,
+				"decline_reason": req.Reason,
+				"updated_at":     now,
+			},
+			"$push": bson.M{"status_history": models.NewStatusChange(models.OrderStatusDeclined, "")},
+		}
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return response.Error(c, 500, "Failed to update order")
+	}
+	if result.ModifiedCount == 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "Order was already answered")
+	}
+
+	if req.Action == "decline" {
+		go notification.NotifyStatusChange(order.ID, models.OrderStatusDeclined)
+
+		return response.Success(c, 200, fiber.Map{
+			"message":        "Order declined",
+			"status":         models.OrderStatusDeclined,
+			"decline_reason": req.Reason,
+		})
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"message":     "Order accepted",
+		"status":      order.Status,
+		"accepted_at": now,
+	})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReplayResult is the outcome of a journaled submission checked against the current
+// validation. Nothing is written, and verification codes are never checked: they are
+// redacted from the journal and would be used up.
+type ReplayResult struct {
+	Valid     bool          `json:"valid"`
+	Status    int           `json:"status,omitempty"`
+	ErrorCode response.Code `json:"error_code,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Parsed    interface{}   `json:"parsed,omitempty"` // The body as the handler decodes it
+	Skipped   []string      `json:"skipped,omitempty"`
+}
+
+// replayers check a journaled body for a client route, keyed by route path
+var replayers = map[string]func(ctx context.Context, token string, body []byte) ReplayResult{
+	"/api/v1/client/driver/:token": replayDriver,
+}
+
+// replayDriver checks driver data like SubmitDriver does
+func replayDriver(ctx context.Context, token string, body []byte) ReplayResult {
+	var req driverRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ReplayResult{Status: 400, ErrorCode: response.CodeInvalidBody, Message: "Invalid request body: " + err.Error()}
+	}
+	req.Code = ""
+
+	result := ReplayResult{Parsed: req, Skipped: []string{"verification code"}}
+	if _, rejected := validateDriver(ctx, token, &req); rejected != nil {
+		result.Status, result.ErrorCode, result.Message = rejected.Status, rejected.Code, rejected.Message
+		return result
+	}
+	result.Valid = true
+	return result
+}
+
+// Submissions lists journaled client page POSTs, newest first.
+// Filters: token, route (e.g. /api/v1/client/driver/:token), failed=true, from/to.
+func (h *RequestLogHandler) Submissions(c *fiber.Ctx) error {
+	pq := parsePage(c, 20, maxLogPageLimit)
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	filter := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+	if token := c.Query("token"); token != "" {
+		filter["token"] = token
+	}
+	if route := c.Query("route"); route != "" {
+		filter["route"] = route
+	}
+	if c.Query("failed") == "true" {
+		filter["error_code"] = bson.M{"$exists": true}
+	}
+
+	collection := database.GetMongoCollection(middleware.JournalCollection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch submissions")
+	}
+	defer cursor.Close(ctx)
+
+	submissions := []models.ClientSubmission{}
+	if err := cursor.All(ctx, &submissions); err != nil {
+		return response.Error(c, 500, "Failed to decode submissions")
+	}
+	submissions, more := trimPage(pq, submissions)
+
+	return response.SuccessWithPagination(c, 200, submissions, pq.pagination(total, more))
+}
+
+// ReplaySubmission checks a journaled submission against the current validation, to
+// tell a mangled body from an order that was not ready yet. Nothing is saved.
+func (h *RequestLogHandler) ReplaySubmission(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	submission := &models.ClientSubmission{}
+	if err := database.GetMongoCollection(middleware.JournalCollection).FindOne(ctx, bson.M{"_id": objID}).Decode(submission); err != nil {
+		return response.NotFound(c, "Submission not found")
+	}
+
+	replay, ok := replayers[submission.Route]
+	switch {
+	case !ok:
+		return response.BadRequest(c, "Submissions to "+submission.Route+" cannot be replayed")
+	case submission.Truncated:
+		return response.BadRequest(c, "The journaled body was truncated and cannot be replayed")
+	case !strings.HasPrefix(submission.ContentType, "application/json"):
+		return response.BadRequest(c, "Only JSON submissions can be replayed")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"submission": submission,
+		"replay":     replay(ctx, submission.Token, []byte(submission.Body)),
+	})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/otp"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// linkCodeSent is returned whether or not the details matched, so the endpoint cannot be
+// used to find out which phones and order numbers exist
+const linkCodeSent = "If the phone and order number match, a verification code was sent to the registered phone"
+
+// linkRequest identifies an order by its number and the phone of its sales rep
+type linkRequest struct {
+	Phone       string `json:"phone"`
+	OrderNumber string `json:"order_number"`
+	Code        string `json:"code"`
+}
+
+// linkOrder returns the order of a link request when the phone is the one registered
+// for the order's sales rep
+func linkOrder(ctx context.Context, req linkRequest) (*models.Order, *models.Sales, bool) {
+	phone, err := utils.NormalizePhone(req.Phone)
+	if err != nil || req.OrderNumber == "" {
+		return nil, nil, false
+	}
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"order_number": strings.TrimSpace(req.OrderNumber)}).Decode(order); err != nil {
+		return nil, nil, false
+	}
+	if order.InvoiceToken == "" || order.Status == models.OrderStatusCancelled {
+		return nil, nil, false
+	}
+
+	salesID, _ := primitive.ObjectIDFromHex(order.SalesID)
+	sales := &models.Sales{}
+	if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesID}).Decode(sales); err != nil {
+		return nil, nil, false
+	}
+	registered, err := utils.NormalizePhone(sales.Phone)
+	if err != nil || registered != phone {
+		return nil, nil, false
+	}
+	return order, sales, true
+}
+
+// otpError responds to a failed code check
+func otpError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, otp.ErrExpired):
+		return response.ErrorCode(c, 400, response.CodeOTPExpired, err.Error())
+	case errors.Is(err, otp.ErrTooManyAttempts):
+		return response.ErrorCode(c, 429, response.CodeTooManyRequests, err.Error())
+	case errors.Is(err, otp.ErrInvalid):
+		return response.ErrorCode(c, 400, response.CodeOTPInvalid, "The verification code is wrong")
+	default:
+		return response.Error(c, 500, "Failed to check verification code")
+	}
+}
+
+// RequestLinkCode sends a verification code to the sales rep who lost an invoice link
+func (h *ClientHandler) RequestLinkCode(c *fiber.Ctx) error {
+	var req linkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Phone == "" || req.OrderNumber == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "phone and order_number are required")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	// Requests are limited per requested phone whether or not they match, so the limit
+	// does not tell which pairs exist either
+	phone, err := utils.NormalizePhone(req.Phone)
+	if err != nil {
+		phone = strings.TrimSpace(req.Phone)
+	}
+
+	order, sales, ok := linkOrder(ctx, req)
+	if !ok {
+		err := otp.Throttle(ctx, otp.PurposeInvoiceLink, phone)
+		if errors.Is(err, otp.ErrRateLimited) {
+			return response.ErrorCode(c, 429, response.CodeTooManyRequests, "Too many codes requested, try again in an hour")
+		}
+		if err != nil {
+			return response.Error(c, 500, "Failed to create verification code")
+		}
+		audit.Log(c, audit.ActionLinkCodeRequest, "order", "", map[string]interface{}{
+			"order_number": req.OrderNumber,
+			"phone":        req.Phone,
+			"matched":      false,
+		})
+		return response.SuccessWithMessage(c, 200, linkCodeSent)
+	}
+
+	code, err := otp.Issue(ctx, otp.PurposeInvoiceLink, order.ID.Hex(), phone)
+	if errors.Is(err, otp.ErrRateLimited) {
+		return response.ErrorCode(c, 429, response.CodeTooManyRequests, "Too many codes requested, try again in an hour")
+	}
+	if err != nil {
+		return response.Error(c, 500, "Failed to create verification code")
+	}
+
+	message := notification.RenderOTP(notification.SenderName(ctx), code, "mengirim ulang link invoice", order.OrderNumber,
+		strconv.Itoa(int(otp.Expiry.Minutes())))
+	if err := notification.SendOTPNotification(sales.Phone, order.ID.Hex(), message); err != nil {
+		log.Printf("[Client] Failed to send link code for %s: %v", order.OrderNumber, err)
+	}
+
+	audit.Log(c, audit.ActionLinkCodeRequest, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"matched":      true,
+	})
+
+	return response.SuccessWithMessage(c, 200, linkCodeSent)
+}
+
+// ResendLink checks the verification code and re-sends the invoice link to the sales
+// rep's registered phone. The link itself is never returned to the caller.
+func (h *ClientHandler) ResendLink(c *fiber.Ctx) error {
+	var req linkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Code == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "code is required")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order, sales, ok := linkOrder(ctx, req)
+	if !ok {
+		return response.ErrorCode(c, 400, response.CodeOTPInvalid, "The verification code is wrong")
+	}
+
+	if err := otp.Verify(ctx, otp.PurposeInvoiceLink, order.ID.Hex(), strings.TrimSpace(req.Code)); err != nil {
+		return otpError(c, err)
+	}
+
+	// Same product summary as the invoice sent when the order was created
+	productName := ""
+	if len(order.Items) > 0 {
+		productName = order.Items[0].ProductName
+		if len(order.Items) > 1 {
+			productName = fmt.Sprintf("%s (+%d lainnya)", productName, len(order.Items)-1)
+		}
+	}
+
+	invoice := notification.InvoiceNotification(
+		sales.Phone,
+		sales.Name,
+		order.OrderNumber,
+		productName,
+		order.Quantity,
+		"item",
+		order.TotalPrice,
+		order.InvoiceToken,
+	)
+	invoice.OrderID = order.ID.Hex()
+	if _, err := notification.Deliver(invoice); err != nil {
+		return response.Error(c, 500, "Failed to send invoice link")
+	}
+
+	audit.Log(c, audit.ActionLinkResend, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+	})
+
+	return response.SuccessWithMessage(c, 200, "The invoice link was sent to the registered phone")
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/cloudinary"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cloudinaryNotification is the part of a Cloudinary notification the webhook reads
+type cloudinaryNotification struct {
+	NotificationType string `json:"notification_type"`
+	PublicID         string `json:"public_id"`
+	ModerationStatus string `json:"moderation_status"`
+	ModerationKind   string `json:"moderation_kind"`
+}
+
+// CloudinaryWebhook receives Cloudinary notifications, signed with the API secret. For
+// moderation verdicts it records the status on the images of the file; rejected payment
+// proofs then drop out of the verification lists. Other notifications are acknowledged
+// and ignored, so Cloudinary does not retry them.
+func (h *IntegrationHandler) CloudinaryWebhook(c *fiber.Ctx) error {
+	body := c.Body()
+	err := cloudinary.VerifyNotification(body, c.Get("X-Cld-Timestamp"), c.Get("X-Cld-Signature"), time.Now())
+	if errors.Is(err, cloudinary.ErrInvalidSignature) {
+		return response.ErrorCode(c, 401, response.CodeSignatureInvalid, "Invalid signature")
+	}
+
+	var notification cloudinaryNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if notification.NotificationType != "moderation" {
+		return response.SuccessWithMessage(c, 200, "Ignored")
+	}
+	if notification.PublicID == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "public_id is required")
+	}
+	switch notification.ModerationStatus {
+	case models.ModerationApproved, models.ModerationRejected, models.ModerationPending:
+	default:
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown moderation_status: "+notification.ModerationStatus)
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	updated, err := file.SetModeration(ctx, notification.PublicID, notification.ModerationStatus, time.Now())
+	if err != nil {
+		log.Printf("[Cloudinary] Failed to record moderation of %s: %v", notification.PublicID, err)
+		return response.Error(c, 500, "Failed to update images") // Cloudinary retries
+	}
+
+	audit.Log(c, audit.ActionImageModerate, "image", notification.PublicID, map[string]interface{}{
+		"status":    notification.ModerationStatus,
+		"kind":      notification.ModerationKind,
+		"documents": updated,
+	})
+
+	return response.Success(c, 200, fiber.Map{"updated": updated})
+}
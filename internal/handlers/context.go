@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Deadlines of the database work done for one request
+const (
+	readTimeout   = 5 * time.Second  // single document reads and writes
+	listTimeout   = 10 * time.Second // paginated lists and their counts
+	reportTimeout = 30 * time.Second // aggregations, exports and imports
+	bulkTimeout   = 60 * time.Second // scans and updates over whole collections
+)
+
+// requestContext derives a context for database calls from the request's user context,
+// so the calls carry its trace span and stop when the request is cancelled or the
+// deadline passes
+func requestContext(c *fiber.Ctx, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.UserContext(), timeout)
+}
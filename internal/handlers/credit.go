@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// agingBuckets are the receivables aging columns, by days past the due date
+var agingBuckets = []struct {
+	Name    string
+	MaxDays int // Inclusive; the last bucket has no maximum
+}{
+	{"current", 0},
+	{"1_30", 30},
+	{"31_60", 60},
+	{"61_90", 90},
+	{"over_90", -1},
+}
+
+// unpaidFilter matches the orders that count toward a sales' outstanding balance: not
+// cancelled or declined, and not yet verified as paid
+func unpaidFilter() bson.M {
+	return bson.M{
+		"status":         bson.M{"$nin": models.UnrealizedOrderStatuses},
+		"payment_status": bson.M{"$ne": models.PaymentStatusVerified},
+	}
+}
+
+// CreditStatus is a sales' credit terms with the current outstanding balance
+type CreditStatus struct {
+	SalesID         string   `json:"sales_id"`
+	CreditLimit     float64  `json:"credit_limit"` // 0 = no limit
+	PaymentTermDays int      `json:"payment_term_days"`
+	CreditCheck     string   `json:"credit_check"`
+	Outstanding     float64  `json:"outstanding"`
+	OpenOrders      int      `json:"open_orders"`
+	Available       *float64 `json:"available,omitempty"` // Credit left, when there is a limit
+}
+
+// creditStatus computes the outstanding balance of a sales
+func creditStatus(ctx context.Context, sales *models.Sales) (*CreditStatus, error) {
+	filter := unpaidFilter()
+	filter["sales_id"] = sales.ID.Hex()
+
+	cursor, err := database.GetMongoCollection("orders").Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$total_price"}, "orders": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Total  float64 `bson:"total"`
+		Orders int     `bson:"orders"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	status := &CreditStatus{
+		SalesID:         sales.ID.Hex(),
+		CreditLimit:     sales.CreditLimit,
+		PaymentTermDays: sales.PaymentTermDays,
+		CreditCheck:     creditCheck(sales),
+	}
+	if len(rows) > 0 {
+		status.Outstanding, status.OpenOrders = rows[0].Total, rows[0].Orders
+	}
+	if sales.CreditLimit > 0 {
+		available := sales.CreditLimit - status.Outstanding
+		status.Available = &available
+	}
+	return status, nil
+}
+
+// creditCheck returns what happens to orders of the sales past the credit limit
+func creditCheck(sales *models.Sales) string {
+	if sales.CreditCheck == models.CreditCheckWarn {
+		return models.CreditCheckWarn
+	}
+	return models.CreditCheckBlock
+}
+
+// checkCredit reports whether an order of orderTotal would take the sales past its credit
+// limit, with the credit status to show. Sales without a limit are never over it.
+func checkCredit(ctx context.Context, sales *models.Sales, orderTotal float64) (*CreditStatus, bool, error) {
+	if sales.CreditLimit <= 0 {
+		return nil, false, nil
+	}
+	status, err := creditStatus(ctx, sales)
+	if err != nil {
+		return nil, false, err
+	}
+	return status, status.Outstanding+orderTotal > sales.CreditLimit, nil
+}
+
+// Credit returns the credit terms and outstanding balance of a sales
+func (h *SalesHandler) Credit(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	sales := &models.Sales{}
+	if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": objID}).Decode(sales); err != nil {
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
+	}
+
+	status, err := creditStatus(ctx, sales)
+	if err != nil {
+		return response.Error(c, 500, "Failed to compute the outstanding balance")
+	}
+	return response.Success(c, 200, status)
+}
+
+// SetCredit saves the credit terms of a sales
+func (h *SalesHandler) SetCredit(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type CreditRequest struct {
+		CreditLimit     float64 `json:"credit_limit"`
+		PaymentTermDays int     `json:"payment_term_days"`
+		CreditCheck     string  `json:"credit_check"`
+	}
+
+	var req CreditRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.CreditLimit < 0 || req.PaymentTermDays < 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "credit_limit and payment_term_days cannot be negative")
+	}
+	if req.CreditCheck == "" {
+		req.CreditCheck = models.CreditCheckBlock
+	}
+	if req.CreditCheck != models.CreditCheckBlock && req.CreditCheck != models.CreditCheckWarn {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "credit_check must be block or warn")
+	}
+
+	collection := database.GetMongoCollection("sales")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	sales := &models.Sales{}
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"credit_limit":      req.CreditLimit,
+		"payment_term_days": req.PaymentTermDays,
+		"credit_check":      req.CreditCheck,
+		"updated_at":        time.Now(),
+	}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(sales)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
+	}
+
+	audit.Log(c, audit.ActionCreditTerms, "sales", objID.Hex(), map[string]interface{}{
+		"credit_limit":      req.CreditLimit,
+		"payment_term_days": req.PaymentTermDays,
+		"credit_check":      req.CreditCheck,
+	})
+
+	status, err := creditStatus(ctx, sales)
+	if err != nil {
+		return response.Error(c, 500, "Failed to compute the outstanding balance")
+	}
+	return response.Success(c, 200, status)
+}
+
+// AgingRow is the outstanding balance of one sales by days past due
+type AgingRow struct {
+	SalesID         string             `json:"sales_id"`
+	SalesName       string             `json:"sales_name"`
+	PaymentTermDays int                `json:"payment_term_days"`
+	CreditLimit     float64            `json:"credit_limit"`
+	Buckets         map[string]float64 `json:"buckets"`
+	Total           float64            `json:"total"`
+	Orders          int                `json:"orders"`
+	OldestDaysDue   int                `json:"oldest_days_past_due"`
+}
+
+func newAgingRow(salesID string) *AgingRow {
+	row := &AgingRow{SalesID: salesID, Buckets: map[string]float64{}}
+	for _, bucket := range agingBuckets {
+		row.Buckets[bucket.Name] = 0
+	}
+	return row
+}
+
+// add puts an unpaid order that is daysPastDue days past its due date in its bucket
+func (row *AgingRow) add(total float64, daysPastDue int) {
+	for _, bucket := range agingBuckets {
+		if bucket.MaxDays < 0 || daysPastDue <= bucket.MaxDays {
+			row.Buckets[bucket.Name] += total
+			break
+		}
+	}
+	row.Total += total
+	row.Orders++
+	row.OldestDaysDue = max(row.OldestDaysDue, daysPastDue)
+}
+
+// ReceivablesAging reports unpaid orders per sales by how long they are past due (umur
+// piutang), as of a date (today by default). An order is due on its due date, or for
+// orders without one the sales' payment term days after it was created.
+func (h *ReportHandler) ReceivablesAging(c *fiber.Ctx) error {
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid as_of date, use YYYY-MM-DD")
+		}
+		asOf = t.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	filter := unpaidFilter()
+	filter["created_at"] = bson.M{"$lte": asOf}
+	if salesID := c.Query("sales_id"); salesID != "" {
+		filter["sales_id"] = salesID
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("orders").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"sales_id": 1, "total_price": 1, "created_at": 1, "due_date": 1}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch unpaid orders")
+	}
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return response.Error(c, 500, "Failed to fetch unpaid orders")
+	}
+
+	objIDs := []primitive.ObjectID{}
+	seen := map[string]bool{}
+	for _, order := range orders {
+		if objID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil && !seen[order.SalesID] {
+			seen[order.SalesID] = true
+			objIDs = append(objIDs, objID)
+		}
+	}
+	salesByID := map[string]models.Sales{}
+	if len(objIDs) > 0 {
+		cursor, err := database.GetMongoCollection("sales").Find(ctx, bson.M{"_id": bson.M{"$in": objIDs}})
+		if err != nil {
+			return response.Error(c, 500, "Failed to fetch sales")
+		}
+		var sales []models.Sales
+		if err := cursor.All(ctx, &sales); err != nil {
+			return response.Error(c, 500, "Failed to fetch sales")
+		}
+		for _, s := range sales {
+			salesByID[s.ID.Hex()] = s
+		}
+	}
+
+	rows := map[string]*AgingRow{}
+	totals := newAgingRow("")
+	for _, order := range orders {
+		row := rows[order.SalesID]
+		if row == nil {
+			row = newAgingRow(order.SalesID)
+			if sales, ok := salesByID[order.SalesID]; ok {
+				row.SalesName, row.PaymentTermDays, row.CreditLimit = sales.Name, sales.PaymentTermDays, sales.CreditLimit
+			}
+			rows[order.SalesID] = row
+		}
+
+		due := order.CreatedAt.AddDate(0, 0, row.PaymentTermDays)
+		if order.DueDate != nil {
+			due = *order.DueDate
+		}
+		daysPastDue := max(0, int(asOf.Sub(due).Hours()/24))
+		row.add(order.TotalPrice, daysPastDue)
+		totals.add(order.TotalPrice, daysPastDue)
+	}
+
+	result := make([]*AgingRow, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].SalesID < result[j].SalesID
+	})
+
+	buckets := make([]string, 0, len(agingBuckets))
+	for _, bucket := range agingBuckets {
+		buckets = append(buckets, bucket.Name)
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"as_of":   asOf,
+		"buckets": buckets,
+		"sales":   result,
+		"totals":  totals,
+	})
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/csat"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxFeedbackLength limits the free-text feedback of a rating
+const maxFeedbackLength = 1000
+
+// CSATHandler handles customer satisfaction survey routes
+type CSATHandler struct{}
+
+// NewCSATHandler creates a new CSAT handler
+func NewCSATHandler() *CSATHandler {
+	return &CSATHandler{}
+}
+
+// CSATStats aggregates the surveys sent in a period. Score is the share of ratings of 4
+// or 5 in percent; Distribution counts the ratings 1 to 5.
+type CSATStats struct {
+	Key          string  `json:"key,omitempty"`
+	SalesID      string  `json:"sales_id,omitempty"`
+	SalesName    string  `json:"sales_name,omitempty"`
+	Sent         int     `json:"sent"`
+	Responses    int     `json:"responses"`
+	ResponseRate float64 `json:"response_rate"`
+	Average      float64 `json:"average"`
+	Score        float64 `json:"score"`
+	Low          int     `json:"low"`
+	Distribution [5]int  `json:"distribution"`
+	scoreSum     int
+}
+
+// add counts a survey
+func (s *CSATStats) add(survey *csat.Survey) {
+	s.Sent++
+	if survey.RespondedAt == nil || survey.Score < csat.MinScore || survey.Score > csat.MaxScore {
+		return
+	}
+	s.Responses++
+	s.scoreSum += survey.Score
+	s.Distribution[survey.Score-1]++
+	if survey.Low {
+		s.Low++
+	}
+}
+
+// finish computes the rates once every survey is counted
+func (s *CSATStats) finish() {
+	if s.Sent > 0 {
+		s.ResponseRate = math.Round(float64(s.Responses)/float64(s.Sent)*1000) / 10
+	}
+	if s.Responses > 0 {
+		s.Average = math.Round(float64(s.scoreSum)/float64(s.Responses)*100) / 100
+		s.Score = math.Round(float64(s.Distribution[3]+s.Distribution[4])/float64(s.Responses)*1000) / 10
+	}
+}
+
+// csatPeriodKey returns the bucket of a time for a period: day, week (ISO) or month
+func csatPeriodKey(t time.Time, period string) string {
+	switch period {
+	case "day":
+		return t.Format("2006-01-02")
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return t.Format("2006-01")
+}
+
+// Summary aggregates the surveys sent over a date range overall, per period (period=day,
+// week or month, default month) and per sales rep
+func (h *CSATHandler) Summary(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	period := c.Query("period", "month")
+	if period != "day" && period != "week" && period != "month" {
+		return response.BadRequest(c, "period must be day, week or month")
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	filter := bson.M{"sent_at": bson.M{"$gte": from, "$lt": to}}
+	if salesID := c.Query("sales_id"); salesID != "" {
+		filter["sales_id"] = salesID
+	}
+	cursor, err := database.GetMongoCollection(csat.Collection).Find(ctx, filter)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch surveys")
+	}
+	surveys := []csat.Survey{}
+	err = cursor.All(ctx, &surveys)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode surveys")
+	}
+
+	overall := &CSATStats{}
+	periods := map[string]*CSATStats{}
+	sales := map[string]*CSATStats{}
+	for i := range surveys {
+		survey := &surveys[i]
+		overall.add(survey)
+
+		key := csatPeriodKey(survey.SentAt.Local(), period)
+		if periods[key] == nil {
+			periods[key] = &CSATStats{Key: key}
+		}
+		periods[key].add(survey)
+
+		if sales[survey.SalesID] == nil {
+			sales[survey.SalesID] = &CSATStats{SalesID: survey.SalesID}
+		}
+		sales[survey.SalesID].add(survey)
+	}
+
+	salesIDs := make([]string, 0, len(sales))
+	for salesID := range sales {
+		salesIDs = append(salesIDs, salesID)
+	}
+	names, err := salesNames(c, salesIDs)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch sales")
+	}
+	overall.finish()
+	periodRows := make([]*CSATStats, 0, len(periods))
+	for _, stats := range periods {
+		stats.finish()
+		periodRows = append(periodRows, stats)
+	}
+	sort.Slice(periodRows, func(i, j int) bool { return periodRows[i].Key < periodRows[j].Key })
+
+	salesRows := make([]*CSATStats, 0, len(sales))
+	for _, stats := range sales {
+		stats.finish()
+		stats.SalesName = names[stats.SalesID]
+		salesRows = append(salesRows, stats)
+	}
+	sort.Slice(salesRows, func(i, j int) bool {
+		if salesRows[i].Average != salesRows[j].Average {
+			return salesRows[i].Average > salesRows[j].Average
+		}
+		return salesRows[i].SalesID < salesRows[j].SalesID
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"from":    from,
+		"to":      to,
+		"period":  period,
+		"overall": overall,
+		"periods": periodRows,
+		"sales":   salesRows,
+	})
+}
+
+// salesNames returns the names of sales reps by ID
+func salesNames(c *fiber.Ctx, salesIDs []string) (map[string]string, error) {
+	objIDs := []primitive.ObjectID{}
+	for _, salesID := range salesIDs {
+		if objID, err := primitive.ObjectIDFromHex(salesID); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	names := map[string]string{}
+	if len(objIDs) == 0 {
+		return names, nil
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("sales").Find(ctx, bson.M{"_id": bson.M{"$in": objIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	sales := []models.Sales{}
+	if err := cursor.All(ctx, &sales); err != nil {
+		return nil, err
+	}
+	for _, s := range sales {
+		names[s.ID.Hex()] = s.Name
+	}
+	return names, nil
+}
+
+// Responses lists rated surveys, newest first. low=true lists the low scores, and
+// follow_up=open those still waiting for follow-up.
+func (h *CSATHandler) Responses(c *fiber.Ctx) error {
+	pq := parsePage(c, 20, maxPageLimit)
+
+	filter := bson.M{"responded_at": bson.M{"$exists": true}}
+	if c.Query("low") == "true" {
+		filter["low"] = true
+	}
+	switch c.Query("follow_up") {
+	case "open":
+		filter["low"] = true
+		filter["followed_up_at"] = bson.M{"$exists": false}
+	case "done":
+		filter["followed_up_at"] = bson.M{"$exists": true}
+	}
+	if salesID := c.Query("sales_id"); salesID != "" {
+		filter["sales_id"] = salesID
+	}
+
+	collection := database.GetMongoCollection(csat.Collection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().
+		SetSort(bson.D{{Key: "responded_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch surveys")
+	}
+	surveys := []csat.Survey{}
+	err = cursor.All(ctx, &surveys)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode surveys")
+	}
+	surveys, more := trimPage(pq, surveys)
+
+	salesIDs := []string{}
+	for _, survey := range surveys {
+		salesIDs = append(salesIDs, survey.SalesID)
+	}
+	names, err := salesNames(c, salesIDs)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch sales")
+	}
+	for i := range surveys {
+		surveys[i].SalesName = names[surveys[i].SalesID]
+	}
+
+	return response.SuccessWithPagination(c, 200, surveys, pq.pagination(total, more))
+}
+
+// FollowUp records that a low score was followed up, with an optional note
+func (h *CSATHandler) FollowUp(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	collection := database.GetMongoCollection(csat.Collection)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	survey := &csat.Survey{}
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": objID, "responded_at": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{
+			"followed_up_at": time.Now(),
+			"followed_up_by": middleware.GetUserID(c),
+			"follow_up_note": strings.TrimSpace(req.Note),
+		}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(survey)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeSurveyNotFound, "Survey not found")
+	}
+
+	audit.Log(c, audit.ActionCSATFollowUp, "order", survey.OrderID, map[string]interface{}{
+		"order_number": survey.OrderNumber,
+		"score":        survey.Score,
+	})
+	return response.Success(c, 200, survey)
+}
+
+// GetSurvey returns the order of a rating link and whether it was answered
+func (h *CSATHandler) GetSurvey(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	survey, err := csat.ByToken(ctx, c.Params("token"))
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeSurveyNotFound, "Survey not found")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"order_number": survey.OrderNumber,
+		"answered":     survey.RespondedAt != nil,
+		"score":        survey.Score,
+		"min_score":    csat.MinScore,
+		"max_score":    csat.MaxScore,
+	})
+}
+
+// SubmitSurvey records the rating and feedback of a rating link, once
+func (h *CSATHandler) SubmitSurvey(c *fiber.Ctx) error {
+	var req struct {
+		Score    int    `json:"score"`
+		Feedback string `json:"feedback"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Score < csat.MinScore || req.Score > csat.MaxScore {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "score must be between 1 and 5")
+	}
+	feedback := strings.TrimSpace(req.Feedback)
+	if len(feedback) > maxFeedbackLength {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "feedback is limited to 1000 characters")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	survey, err := csat.ByToken(ctx, c.Params("token"))
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeSurveyNotFound, "Survey not found")
+	}
+	if err := csat.Respond(ctx, survey, req.Score, feedback); err != nil {
+		if err == csat.ErrAnswered {
+			return response.ErrorCode(c, 400, response.CodeSurveyAnswered, "This survey was already answered")
+		}
+		return response.Error(c, 500, "Failed to save rating")
+	}
+
+	return response.SuccessWithMessage(c, 200, "Thank you for your feedback")
+}
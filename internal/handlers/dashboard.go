@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"context"
 	"time"
 
 	"bg-go/internal/database"
@@ -24,19 +23,19 @@ func NewDashboardHandler() *DashboardHandler {
 
 // GetStats returns dashboard statistics
 func (h *DashboardHandler) GetStats(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	orderCollection := database.GetMongoCollection("orders")
 	salesCollection := database.GetMongoCollection("sales")
 
 	// Order stats
-	totalOrders, _ := orderCollection.CountDocuments(ctx, bson.M{})
-	pendingOrders, _ := orderCollection.CountDocuments(ctx, bson.M{"status": models.OrderStatusPending})
-	paidOrders, _ := orderCollection.CountDocuments(ctx, bson.M{"status": models.OrderStatusPaid})
-	confirmedOrders, _ := orderCollection.CountDocuments(ctx, bson.M{"status": models.OrderStatusConfirmed})
-	completedOrders, _ := orderCollection.CountDocuments(ctx, bson.M{"status": models.OrderStatusCompleted})
-	cancelledOrders, _ := orderCollection.CountDocuments(ctx, bson.M{"status": models.OrderStatusCancelled})
+	totalOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(bson.M{}))
+	pendingOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusPending}))
+	paidOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusPaid}))
+	confirmedOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusConfirmed}))
+	completedOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusCompleted}))
+	cancelledOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusCancelled}))
 
 	// Today's orders
 	today := time.Now().Format("2006-01-02")
@@ -49,11 +48,16 @@ func (h *DashboardHandler) GetStats(c *fiber.Ctx) error {
 			"$lt":  todayEnd,
 		},
 	}
-	todayOrders, _ := orderCollection.CountDocuments(ctx, todayFilter)
+	todayOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(todayFilter))
+
+	// Walk-in trucks, created and queued at the counter
+	walkInOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(bson.M{"walk_in": true}))
+	todayFilter["walk_in"] = true
+	todayWalkInOrders, _ := orderCollection.CountDocuments(ctx, withoutShipments(todayFilter))
 
 	// Revenue
 	revenuePipeline := []bson.M{
-		{"$match": bson.M{"status": bson.M{"$ne": models.OrderStatusCancelled}}},
+		{"$match": withoutShipments(bson.M{"status": bson.M{"$nin": models.UnrealizedOrderStatuses}})},
 		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$total_price"}}},
 	}
 	revenueCursor, _ := orderCollection.Aggregate(ctx, revenuePipeline)
@@ -71,7 +75,7 @@ func (h *DashboardHandler) GetStats(c *fiber.Ctx) error {
 	// Today's revenue
 	todayRevenuePipeline := []bson.M{
 		{"$match": bson.M{
-			"status":     bson.M{"$ne": models.OrderStatusCancelled},
+			"status":     bson.M{"$nin": models.UnrealizedOrderStatuses},
 			"created_at": bson.M{"$gte": todayStart, "$lt": todayEnd},
 		}},
 		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$total_price"}}},
@@ -94,7 +98,7 @@ func (h *DashboardHandler) GetStats(c *fiber.Ctx) error {
 
 	// Top sales by revenue
 	topSalesPipeline := []bson.M{
-		{"$match": bson.M{"status": bson.M{"$ne": models.OrderStatusCancelled}}},
+		{"$match": withoutShipments(bson.M{"status": bson.M{"$nin": models.UnrealizedOrderStatuses}})},
 		{"$group": bson.M{
 			"_id":           "$sales_id",
 			"order_count":   bson.M{"$sum": 1},
@@ -171,6 +175,56 @@ func (h *DashboardHandler) GetStats(c *fiber.Ctx) error {
 		})
 	}
 
+	// Tag stats (top 10 tags of non-cancelled orders)
+	tagPipeline := []bson.M{
+		{"$match": bson.M{"status": bson.M{"$nin": models.UnrealizedOrderStatuses}, "tags.0": bson.M{"$exists": true}}},
+		{"$unwind": "$tags"},
+		{"$group": bson.M{
+			"_id":           "$tags",
+			"order_count":   bson.M{"$sum": 1},
+			"total_revenue": bson.M{"$sum": "$total_price"},
+			"open_count": bson.M{"$sum": bson.M{"$cond": []interface{}{
+				bson.M{"$eq": []interface{}{"$status", models.OrderStatusCompleted}}, 0, 1,
+			}}},
+		}},
+		{"$sort": bson.M{"order_count": -1}},
+		{"$limit": 10},
+	}
+	tagCursor, err := orderCollection.Aggregate(ctx, tagPipeline)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch tag stats")
+	}
+	var tagResult []struct {
+		Name         string  `bson:"_id"`
+		OrderCount   int     `bson:"order_count"`
+		OpenCount    int     `bson:"open_count"`
+		TotalRevenue float64 `bson:"total_revenue"`
+	}
+	err = tagCursor.All(ctx, &tagResult)
+	tagCursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode tag stats")
+	}
+
+	type TagStat struct {
+		Name         string  `json:"name"`
+		Color        string  `json:"color"`
+		OrderCount   int     `json:"order_count"`
+		OpenCount    int     `json:"open_count"` // Not completed yet
+		TotalRevenue float64 `json:"total_revenue"`
+	}
+	colors := tagColors(ctx)
+	tagStats := []TagStat{}
+	for _, ts := range tagResult {
+		tagStats = append(tagStats, TagStat{
+			Name:         ts.Name,
+			Color:        tagHints([]string{ts.Name}, colors)[0].Color,
+			OrderCount:   ts.OrderCount,
+			OpenCount:    ts.OpenCount,
+			TotalRevenue: ts.TotalRevenue,
+		})
+	}
+
 	return response.Success(c, 200, fiber.Map{
 		"orders": fiber.Map{
 			"total":         totalOrders,
@@ -180,6 +234,8 @@ func (h *DashboardHandler) GetStats(c *fiber.Ctx) error {
 			"completed":     completedOrders,
 			"cancelled":     cancelledOrders,
 			"today":         todayOrders,
+			"walk_in":       walkInOrders,
+			"today_walk_in": todayWalkInOrders,
 			"revenue":       totalRevenue,
 			"today_revenue": todayRevenue,
 		},
@@ -189,5 +245,6 @@ func (h *DashboardHandler) GetStats(c *fiber.Ctx) error {
 		},
 		"top_sales":     topSales,
 		"recent_orders": recentOrdersResult,
+		"tags":          tagStats,
 	})
 }
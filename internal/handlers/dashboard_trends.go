@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/dailystats"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxTrendDays limits how far back a trend series goes
+const maxTrendDays = 365
+
+// Trends returns one stats point per day for the last days days (default 30), oldest
+// first. Past days come from the stored snapshots; a missing one is computed and stored,
+// and today is computed live.
+func (h *DashboardHandler) Trends(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > maxTrendDays {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "days must be between 1 and 365")
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	from := today.AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+	todayDate := today.Format("2006-01-02")
+
+	cursor, err := database.GetMongoCollection(dailystats.Collection).Find(ctx, bson.M{
+		"_id": bson.M{"$gte": from, "$lt": todayDate},
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch daily stats")
+	}
+	defer cursor.Close(ctx)
+
+	var stored []models.DailyStats
+	if err := cursor.All(ctx, &stored); err != nil {
+		return response.Error(c, 500, "Failed to decode daily stats")
+	}
+
+	byDate := map[string]models.DailyStats{}
+	for _, stats := range stored {
+		byDate[stats.Date] = stats
+	}
+
+	series := make([]models.DailyStats, 0, days)
+	for i := days - 1; i >= 1; i-- {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		stats, ok := byDate[date]
+		if !ok {
+			snapshot, err := dailystats.Snapshot(ctx, date)
+			if err != nil {
+				return response.Error(c, 500, "Failed to compute daily stats")
+			}
+			stats = *snapshot
+		}
+		series = append(series, stats)
+	}
+
+	live, err := dailystats.Build(ctx, todayDate)
+	if err != nil {
+		return response.Error(c, 500, "Failed to compute daily stats")
+	}
+	live.Live = true
+	series = append(series, *live)
+
+	return response.Success(c, 200, fiber.Map{
+		"days":   days,
+		"series": series,
+	})
+}
@@ -5,12 +5,18 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"time"
 
 	"bg-go/internal/config"
 	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/media"
 	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/numbering"
+	"bg-go/internal/lib/outbox"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/uom"
 	"bg-go/internal/middleware"
 	"bg-go/internal/models"
 
@@ -35,30 +41,45 @@ func generateDeliveryToken() string {
 	return hex.EncodeToString(b)
 }
 
-// generateNoteNumber generates delivery note number
-func generateNoteNumber() string {
-	return fmt.Sprintf("SJ-%s", time.Now().Format("20060102150405"))
+// generateNoteNumber allocates the next sequential delivery note number, e.g. SJ-202610-0001
+func generateNoteNumber(ctx context.Context) (string, error) {
+	return numbering.Next(ctx, numbering.SeriesDeliveryNote)
+}
+
+// releaseNumber records an allocated number whose document could not be saved as void,
+// so the numbering gaps report can explain it. It runs on its own context because the
+// request's may be what expired. A number another order already holds is not void.
+func releaseNumber(number string, cause error) {
+	if index, _ := database.DuplicateKeyIndex(cause); index == database.IndexOrderNumber {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := numbering.Release(ctx, number, "Save failed: "+cause.Error()); err != nil {
+		log.Printf("[Numbering] Failed to record void number %s: %v", number, err)
+	}
 }
 
 // List returns all delivery notes
 func (h *DeliveryHandler) List(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
+	pq := parsePage(c, 10, maxPageLimit)
+	includeSuperseded := c.QueryBool("include_superseded", false)
 
-	skip := (page - 1) * limit
+	filter := bson.M{}
+	if !includeSuperseded {
+		filter["superseded"] = bson.M{"$ne": true}
+	}
 
 	collection := database.GetMongoCollection("delivery_notes")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, bson.M{})
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
+	findOptions := pq.findOptions().
 		SetSort(bson.D{{Key: "created_at", Value: -1}})
 
-	cursor, err := collection.Find(ctx, bson.M{}, findOptions)
+	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
 		return response.Error(c, 500, "Failed to fetch delivery notes")
 	}
@@ -66,16 +87,14 @@ func (h *DeliveryHandler) List(c *fiber.Ctx) error {
 
 	var notes []models.DeliveryNote
 	cursor.All(ctx, &notes)
+	notes, more := trimPage(pq, notes)
 
-	return response.SuccessWithPagination(c, 200, notes, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, notes, pq.pagination(total, more))
 }
 
 // ListReady returns orders ready for delivery note creation (loading finished)
 func (h *DeliveryHandler) ListReady(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
-
-	skip := (page - 1) * limit
+	pq := parsePage(c, 10, maxPageLimit)
 
 	// Filter for orders that:
 	// 1. Have status "loading"
@@ -88,14 +107,12 @@ func (h *DeliveryHandler) ListReady(c *fiber.Ctx) error {
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
+	findOptions := pq.findOptions().
 		SetSort(bson.D{{Key: "loading_finished_at", Value: -1}})
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
@@ -106,12 +123,14 @@ func (h *DeliveryHandler) ListReady(c *fiber.Ctx) error {
 
 	var orders []models.Order
 	cursor.All(ctx, &orders)
+	orders, more := trimPage(pq, orders)
 
 	// Populate sales and product data
 	salesCollection := database.GetMongoCollection("sales")
 	productCollection := database.GetMongoCollection("products")
 
 	for i := range orders {
+		media.SignOrder(&orders[i])
 		if orders[i].SalesID != "" {
 			salesObjID, _ := primitive.ObjectIDFromHex(orders[i].SalesID)
 			sales := &models.Sales{}
@@ -126,7 +145,7 @@ func (h *DeliveryHandler) ListReady(c *fiber.Ctx) error {
 		}
 	}
 
-	return response.SuccessWithPagination(c, 200, orders, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, orders, pq.pagination(total, more))
 }
 
 // Detail returns a single delivery note by ID
@@ -135,17 +154,17 @@ func (h *DeliveryHandler) Detail(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	collection := database.GetMongoCollection("delivery_notes")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	note := &models.DeliveryNote{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(note)
 	if err != nil {
-		return response.NotFound(c, "Delivery note not found")
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
 	}
 
 	return response.Success(c, 200, note)
@@ -159,34 +178,34 @@ func (h *DeliveryHandler) Create(c *fiber.Ctx) error {
 
 	var req CreateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	if req.OrderID == "" {
-		return response.BadRequest(c, "Order ID is required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Order ID is required")
 	}
 
 	userID := middleware.GetUserID(c)
 
 	orderObjID, err := primitive.ObjectIDFromHex(req.OrderID)
 	if err != nil {
-		return response.BadRequest(c, "Invalid order ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid order ID format")
 	}
 
 	orderCollection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	// Get order
 	order := &models.Order{}
 	err = orderCollection.FindOne(ctx, bson.M{"_id": orderObjID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	// Check order status - allow both loading and completed status
 	if order.Status != models.OrderStatusLoading && order.Status != models.OrderStatusCompleted {
-		return response.BadRequest(c, "Order is not ready for delivery note")
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order is not ready for delivery note")
 	}
 
 	// Check if delivery note already exists for this order
@@ -229,13 +248,19 @@ func (h *DeliveryHandler) Create(c *fiber.Ctx) error {
 				Quantity:    item.Quantity,
 				Unit:        item.Unit,
 				UnitPrice:   item.UnitPrice,
+
+				BaseQuantity: item.BaseQuantity,
+				BaseUnit:     item.BaseUnit,
 			})
 		}
 	}
 
 	// Create delivery note
 	token := generateDeliveryToken()
-	noteNumber := generateNoteNumber()
+	noteNumber, err := generateNoteNumber(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to allocate delivery note number")
+	}
 	now := time.Now()
 
 	note := models.NewDeliveryNote()
@@ -256,13 +281,25 @@ func (h *DeliveryHandler) Create(c *fiber.Ctx) error {
 	note.VehiclePlate = order.VehiclePlate
 	note.Items = deliveryItems
 
-	// Save delivery note
-	deliveryCollection := database.GetMongoCollection("delivery_notes")
-	_, err = deliveryCollection.InsertOne(ctx, note)
-	if err != nil {
-		return response.Error(c, 500, "Failed to create delivery note")
+	// Get first product name for notification
+	firstProductName := ""
+	if len(order.Items) > 0 {
+		firstProductName = order.Items[0].ProductName
 	}
 
+	deliveryNotif := notification.DeliveryNotification(
+		sales.Phone,
+		sales.Name,
+		noteNumber,
+		firstProductName,
+		order.Quantity,
+		"pcs",
+		order.DriverName,
+		order.VehiclePlate,
+		token,
+	)
+	deliveryNotif.OrderID = req.OrderID
+
 	// Update order with delivery note info
 	orderUpdate := bson.M{
 		"delivery_note_id":     note.ID.Hex(),
@@ -274,32 +311,40 @@ func (h *DeliveryHandler) Create(c *fiber.Ctx) error {
 		"completed_at":         now,
 		"updated_at":           now,
 	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusCompleted, userID)}
 
-	_, err = orderCollection.UpdateOne(ctx, bson.M{"_id": orderObjID}, bson.M{"$set": orderUpdate})
+	// Save delivery note, order update and notification together
+	deliveryCollection := database.GetMongoCollection("delivery_notes")
+	err = database.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := deliveryCollection.InsertOne(txCtx, note); err != nil {
+			return err
+		}
+		if _, err := orderCollection.UpdateOne(txCtx, bson.M{"_id": orderObjID}, bson.M{"$set": orderUpdate, "$push": statusChange}); err != nil {
+			return err
+		}
+		_, err := outbox.EnqueueNotification(txCtx, deliveryNotif)
+		return err
+	})
 	if err != nil {
-		return response.Error(c, 500, "Failed to update order")
+		releaseNumber(noteNumber, err)
+		return response.Error(c, 500, "Failed to create delivery note")
 	}
+	outbox.Kick()
+	go notification.NotifyStatusChange(orderObjID, models.OrderStatusCompleted)
+	go sendNoteToRecipients(note.ID, false)
 
-	// Generate WhatsApp notification link
-	notification.Init(config.Cfg.Client.URL)
-
-	// Get first product name for notification
-	firstProductName := ""
-	if len(order.Items) > 0 {
-		firstProductName = order.Items[0].ProductName
+	// A shipment finished here counts towards its split order
+	if order.ShipmentOf != "" && order.Status == models.OrderStatusLoading {
+		fulfillShipment(ctx, order, userID)
 	}
 
-	waLink, _ := notification.SendDeliveryNotification(
-		sales.Phone,
-		sales.Name,
-		noteNumber,
-		firstProductName,
-		order.Quantity,
-		"pcs",
-		order.DriverName,
-		order.VehiclePlate,
-		token,
-	)
+	audit.Log(c, audit.ActionDeliveryCreate, "delivery_note", note.ID.Hex(), map[string]interface{}{
+		"order_id":    req.OrderID,
+		"note_number": noteNumber,
+	})
+
+	// Generate WhatsApp notification link
+	waLink := notification.GenerateWhatsAppLink(deliveryNotif.Phone, deliveryNotif.Message)
 
 	// Check WhatsApp status for frontend
 	waStatus := notification.WhatsAppStatus()
@@ -320,17 +365,17 @@ func (h *DeliveryHandler) GetByToken(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
 	collection := database.GetMongoCollection("delivery_notes")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	note := &models.DeliveryNote{}
 	err := collection.FindOne(ctx, bson.M{"token": token}).Decode(note)
 	if err != nil {
-		return response.NotFound(c, "Delivery note not found")
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
 	}
 
 	return response.Success(c, 200, note)
@@ -342,21 +387,21 @@ func (h *DeliveryHandler) GetByOrder(c *fiber.Ctx) error {
 
 	orderObjID, err := primitive.ObjectIDFromHex(orderID)
 	if err != nil {
-		return response.BadRequest(c, "Invalid order ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid order ID format")
 	}
 
 	orderCollection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	order := &models.Order{}
 	err = orderCollection.FindOne(ctx, bson.M{"_id": orderObjID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	if order.DeliveryNoteID == "" {
-		return response.NotFound(c, "No delivery note for this order")
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotReady, "No delivery note for this order")
 	}
 
 	deliveryCollection := database.GetMongoCollection("delivery_notes")
@@ -364,8 +409,204 @@ func (h *DeliveryHandler) GetByOrder(c *fiber.Ctx) error {
 	noteObjID, _ := primitive.ObjectIDFromHex(order.DeliveryNoteID)
 	err = deliveryCollection.FindOne(ctx, bson.M{"_id": noteObjID}).Decode(note)
 	if err != nil {
-		return response.NotFound(c, "Delivery note not found")
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
 	}
 
 	return response.Success(c, 200, note)
 }
+
+// Amend creates a new revision of a delivery note, superseding the current one
+func (h *DeliveryHandler) Amend(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type AmendRequest struct {
+		Reason       string                    `json:"reason"`
+		DriverName   string                    `json:"driver_name,omitempty"`
+		DriverPhone  string                    `json:"driver_phone,omitempty"`
+		VehiclePlate string                    `json:"vehicle_plate,omitempty"`
+		Items        []models.DeliveryNoteItem `json:"items,omitempty"`
+	}
+
+	var req AmendRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.Reason == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Reason for amendment is required")
+	}
+
+	deliveryCollection := database.GetMongoCollection("delivery_notes")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	current := &models.DeliveryNote{}
+	err = deliveryCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(current)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
+	}
+
+	if current.Superseded {
+		return response.BadRequest(c, "Only the latest revision can be amended")
+	}
+
+	// Build the new revision from the current snapshot
+	revision := *current
+	revision.ID = primitive.NewObjectID()
+	revision.Order = nil
+	revision.Token = generateDeliveryToken()
+	revision.Revision = current.Revision + 1
+	if current.Revision == 0 {
+		// Notes created before versioning count as revision 1
+		revision.Revision = 2
+	}
+	revision.RootID = current.RootID
+	if revision.RootID == "" {
+		revision.RootID = current.ID.Hex()
+	}
+	revision.AmendReason = req.Reason
+	revision.Recipients = nil
+	revision.CreatedBy = middleware.GetUserID(c)
+	now := time.Now()
+	revision.CreatedAt = now
+	revision.UpdatedAt = now
+
+	if req.DriverName != "" {
+		revision.DriverName = req.DriverName
+	}
+	if req.DriverPhone != "" {
+		revision.DriverPhone = req.DriverPhone
+	}
+	if req.VehiclePlate != "" {
+		revision.VehiclePlate = req.VehiclePlate
+	}
+	if len(req.Items) > 0 {
+		units, err := uom.Load(ctx)
+		if err != nil {
+			return unitsUnavailable(c)
+		}
+		productNames := ""
+		totalQty := 0
+		for i, item := range req.Items {
+			if item.Unit == "" {
+				item.Unit = "pcs"
+			}
+			registered, known := units.Lookup(item.Unit)
+			if !known {
+				return unknownUnit(c, units, i, item.Unit)
+			}
+			req.Items[i].Unit = registered.Code
+			req.Items[i].BaseQuantity, req.Items[i].BaseUnit, _ = units.ToBase(float64(item.Quantity), registered.Code)
+
+			if i > 0 {
+				productNames += ", "
+			}
+			productNames += item.ProductName
+			totalQty += item.Quantity
+		}
+		revision.Items = req.Items
+		revision.ProductName = productNames
+		revision.ProductQty = totalQty
+	}
+
+	// Mark the previous revision as superseded first, so of two concurrent amends only
+	// one continues the chain
+	result, err := deliveryCollection.UpdateOne(ctx, bson.M{"_id": objID, "superseded": bson.M{"$ne": true}}, bson.M{"$set": bson.M{
+		"revision":      max(current.Revision, 1),
+		"root_id":       revision.RootID,
+		"superseded":    true,
+		"superseded_by": revision.ID.Hex(),
+		"updated_at":    now,
+	}})
+	if err != nil {
+		return response.Error(c, 500, "Failed to supersede delivery note")
+	}
+	if result.MatchedCount == 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "The delivery note was amended meanwhile")
+	}
+
+	if _, err := deliveryCollection.InsertOne(ctx, revision); err != nil {
+		deliveryCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+			"$set":   bson.M{"superseded": false, "updated_at": now},
+			"$unset": bson.M{"superseded_by": ""},
+		})
+		return response.Error(c, 500, "Failed to create delivery note revision")
+	}
+
+	// Point the order to the latest revision
+	if orderObjID, err := primitive.ObjectIDFromHex(current.OrderID); err == nil {
+		orderCollection := database.GetMongoCollection("orders")
+		orderCollection.UpdateOne(ctx, bson.M{"_id": orderObjID}, bson.M{"$set": bson.M{
+			"delivery_note_id":    revision.ID.Hex(),
+			"delivery_note_token": revision.Token,
+			"delivery_note_url":   fmt.Sprintf("%s/delivery/%s", config.Cfg.Client.URL, revision.Token),
+			"updated_at":          now,
+		}})
+	}
+
+	// Recipients get the amended note with its new link
+	go sendNoteToRecipients(revision.ID, false)
+
+	audit.Log(c, audit.ActionDeliveryAmend, "delivery_note", revision.ID.Hex(), map[string]interface{}{
+		"previous_id": id,
+		"note_number": revision.NoteNumber,
+		"revision":    revision.Revision,
+		"reason":      req.Reason,
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"message":       "Delivery note amended successfully",
+		"delivery_note": revision,
+	})
+}
+
+// Revisions returns the revision history of a delivery note
+func (h *DeliveryHandler) Revisions(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("delivery_notes")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	note := &models.DeliveryNote{}
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(note)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
+	}
+
+	rootID := note.RootID
+	if rootID == "" {
+		rootID = note.ID.Hex()
+	}
+	rootObjID, _ := primitive.ObjectIDFromHex(rootID)
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"_id": rootObjID},
+			{"root_id": rootID},
+		},
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch revisions")
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []models.DeliveryNote{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return response.Error(c, 500, "Failed to decode revisions")
+	}
+
+	return response.Success(c, 200, revisions)
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/chatalert"
+	"bg-go/internal/lib/pdf"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportSyncLimit is the largest export streamed directly; bigger ranges run as a job
+const exportSyncLimit = 50
+
+// exportDir holds finished export archives until they are downloaded
+var exportDir = filepath.Join(os.TempDir(), "bg-exports")
+
+// Export exports delivery notes in a date range as a ZIP of PDFs.
+// Small ranges are streamed directly; large ranges (or ?async=true) start a job.
+func (h *DeliveryHandler) Export(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	filter := bson.M{
+		"created_at": bson.M{"$gte": from, "$lt": to},
+		"superseded": bson.M{"$ne": true},
+	}
+
+	collection := database.GetMongoCollection("delivery_notes")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return response.Error(c, 500, "Failed to count delivery notes")
+	}
+	if total == 0 {
+		return response.NotFound(c, "No delivery notes in this range")
+	}
+
+	fileName := fmt.Sprintf("surat-jalan_%s_%s.zip", from.Format("20060102"), to.Add(-time.Second).Format("20060102"))
+
+	if total > exportSyncLimit || c.QueryBool("async", false) {
+		job := &models.ExportJob{
+			ID:        primitive.NewObjectID(),
+			Type:      models.ExportTypeDeliveryNotes,
+			Status:    models.ExportStatusPending,
+			From:      from,
+			To:        to,
+			Total:     int(total),
+			FileName:  fileName,
+			CreatedBy: middleware.GetUserID(c),
+			CreatedAt: time.Now(),
+		}
+		if _, err := database.GetMongoCollection("export_jobs").InsertOne(ctx, job); err != nil {
+			return response.Error(c, 500, "Failed to create export job")
+		}
+
+		go runDeliveryExport(job.ID, filter)
+
+		return response.Success(c, 202, job)
+	}
+
+	notes := []models.DeliveryNote{}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch delivery notes")
+	}
+	err = cursor.All(ctx, &notes)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch delivery notes")
+	}
+
+	settings := loadCompanySettings(ctx)
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	release := middleware.HoldConcurrencySlot(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer release()
+		if err := writeDeliveryZip(w, notes, settings, nil); err != nil {
+			log.Printf("[Export] Failed to stream delivery notes: %v", err)
+		}
+		w.Flush()
+	})
+
+	return nil
+}
+
+// ExportStatus returns the progress of an export job
+func (h *DeliveryHandler) ExportStatus(c *fiber.Ctx) error {
+	job, err := findExportJob(c)
+	if err != nil {
+		return response.NotFound(c, "Export job not found")
+	}
+
+	progress := 0
+	if job.Total > 0 {
+		progress = job.Processed * 100 / job.Total
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"job":      job,
+		"progress": progress,
+	})
+}
+
+// ExportDownload sends the archive of a finished export job
+func (h *DeliveryHandler) ExportDownload(c *fiber.Ctx) error {
+	job, err := findExportJob(c)
+	if err != nil {
+		return response.NotFound(c, "Export job not found")
+	}
+
+	if job.Status != models.ExportStatusDone {
+		return response.ErrorCode(c, 400, response.CodeExportNotReady, "Export is not finished yet")
+	}
+	if _, err := os.Stat(job.FilePath); err != nil {
+		return response.NotFound(c, "Export file is no longer available")
+	}
+
+	return c.Download(job.FilePath, job.FileName)
+}
+
+// findExportJob loads the export job in the id route parameter
+func findExportJob(c *fiber.Ctx) (*models.ExportJob, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	job := &models.ExportJob{}
+	err = database.GetMongoCollection("export_jobs").FindOne(ctx, bson.M{"_id": objID}).Decode(job)
+	return job, err
+}
+
+// runDeliveryExport builds the archive for an export job in the background
+func runDeliveryExport(jobID primitive.ObjectID, filter bson.M) {
+	jobs := database.GetMongoCollection("export_jobs")
+	setJob := func(update bson.M) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		jobs.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$set": update})
+	}
+	fail := func(err error) {
+		log.Printf("[Export] Job %s failed: %v", jobID.Hex(), err)
+		setJob(bson.M{"status": models.ExportStatusFailed, "error": err.Error(), "finished_at": time.Now()})
+		chatalert.Fire(chatalert.Alert{
+			Type:   chatalert.TypeExportFailed,
+			Title:  "Delivery note export failed",
+			Text:   "A background export of delivery notes failed: " + err.Error(),
+			Fields: []chatalert.Field{{Name: "Job", Value: jobID.Hex()}},
+		})
+	}
+
+	setJob(bson.M{"status": models.ExportStatusRunning})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	notes := []models.DeliveryNote{}
+	cursor, err := database.GetMongoCollection("delivery_notes").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		fail(err)
+		return
+	}
+	err = cursor.All(ctx, &notes)
+	cursor.Close(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	if err := os.MkdirAll(exportDir, 0o755); err != nil {
+		fail(err)
+		return
+	}
+	path := filepath.Join(exportDir, jobID.Hex()+".zip")
+	file, err := os.Create(path)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	settings := loadCompanySettings(ctx)
+	err = writeDeliveryZip(file, notes, settings, func(done int) {
+		if done%10 == 0 {
+			setJob(bson.M{"processed": done})
+		}
+	})
+	file.Close()
+	if err != nil {
+		os.Remove(path)
+		fail(err)
+		return
+	}
+
+	setJob(bson.M{
+		"status":      models.ExportStatusDone,
+		"processed":   len(notes),
+		"total":       len(notes),
+		"file_path":   path,
+		"finished_at": time.Now(),
+	})
+}
+
+// writeDeliveryZip renders each note as a PDF into a ZIP archive
+func writeDeliveryZip(w io.Writer, notes []models.DeliveryNote, settings *models.CompanySettings, progress func(done int)) error {
+	archive := zip.NewWriter(w)
+
+	for i := range notes {
+		note := &notes[i]
+		name := note.NoteNumber
+		if note.Revision > 1 {
+			name = fmt.Sprintf("%s-rev%d", name, note.Revision)
+		}
+
+		entry, err := archive.Create(name + ".pdf")
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(renderDeliveryNotePDF(note, settings)); err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+
+	return archive.Close()
+}
+
+// renderDeliveryNotePDF renders a printable delivery note (surat jalan)
+func renderDeliveryNotePDF(note *models.DeliveryNote, settings *models.CompanySettings) []byte {
+	doc := pdf.New()
+	doc.AddPage()
+
+	left := 50.0
+	right := pdf.PageWidth - 50
+	y := 60.0
+
+	// Company header
+	doc.Text(left, y, 16, true, settings.Name)
+	y += 16
+	if settings.Address != "" {
+		doc.Text(left, y, 9, false, settings.Address)
+		y += 12
+	}
+	if settings.Phone != "" || settings.Email != "" {
+		doc.Text(left, y, 9, false, fmt.Sprintf("Telp: %s  Email: %s", settings.Phone, settings.Email))
+		y += 12
+	}
+	y += 6
+	doc.Line(left, y, right, y)
+	y += 28
+
+	doc.Text(left, y, 14, true, "SURAT JALAN")
+	y += 22
+
+	// Note info
+	rows := [][2]string{
+		{"No. Surat Jalan", note.NoteNumber},
+		{"Tanggal", note.CreatedAt.Format("02 Jan 2006 15:04")},
+		{"Sales", fmt.Sprintf("%s (%s)", note.SalesName, note.SalesPhone)},
+		{"Sopir", fmt.Sprintf("%s (%s)", note.DriverName, note.DriverPhone)},
+		{"No. Polisi", note.VehiclePlate},
+	}
+	if note.Revision > 1 {
+		rows = append(rows, [2]string{"Revisi", fmt.Sprintf("%d - %s", note.Revision, note.AmendReason)})
+	}
+	for _, row := range rows {
+		doc.Text(left, y, 10, false, row[0])
+		doc.Text(left+100, y, 10, false, ": "+row[1])
+		y += 15
+	}
+	y += 15
+
+	// Items table
+	colNo, colName, colQty, colUnit := left+5, left+35, right-120, right-55
+	doc.Line(left, y-12, right, y-12)
+	doc.Text(colNo, y, 10, true, "No")
+	doc.Text(colName, y, 10, true, "Produk")
+	doc.Text(colQty, y, 10, true, "Qty")
+	doc.Text(colUnit, y, 10, true, "Satuan")
+	y += 6
+	doc.Line(left, y, right, y)
+	y += 14
+
+	items := note.Items
+	if len(items) == 0 && note.ProductName != "" {
+		items = []models.DeliveryNoteItem{{ProductName: note.ProductName, Quantity: note.ProductQty, Unit: note.ProductUnit}}
+	}
+	for i, item := range items {
+		if y > pdf.PageHeight-160 {
+			doc.AddPage()
+			y = 60
+		}
+		doc.Text(colNo, y, 10, false, fmt.Sprintf("%d", i+1))
+		doc.Text(colName, y, 10, false, item.ProductName)
+		doc.Text(colQty, y, 10, false, fmt.Sprintf("%d", item.Quantity))
+		doc.Text(colUnit, y, 10, false, item.Unit)
+		y += 16
+	}
+	doc.Line(left, y-10, right, y-10)
+	y += 50
+
+	// Signatures
+	signers := []string{"Pengirim", "Sopir", "Penerima"}
+	width := (right - left) / float64(len(signers))
+	for i, signer := range signers {
+		x := left + float64(i)*width
+		doc.Text(x+10, y, 10, false, signer)
+		doc.Line(x+10, y+60, x+width-20, y+60)
+	}
+
+	return doc.Bytes()
+}
+
+// loadCompanySettings returns the company settings, or empty settings when none are saved
+func loadCompanySettings(ctx context.Context) *models.CompanySettings {
+	settings := &models.CompanySettings{}
+	database.GetMongoCollection("company_settings").FindOne(ctx, bson.M{}).Decode(settings)
+	return settings
+}
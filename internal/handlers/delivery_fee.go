@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/deliveryfee"
+	"bg-go/internal/lib/geocode"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// itemsTotal returns an order's price without delivery; older orders have no subtotal
+func itemsTotal(order *models.Order) float64 {
+	if order.Subtotal > 0 {
+		return order.Subtotal
+	}
+	return order.TotalPrice - order.DeliveryFee
+}
+
+// priceDelivery prices the delivery to the order's address and updates its totals.
+// The order is left untouched on error, including deliveryfee.ErrDisabled.
+func priceDelivery(ctx context.Context, order *models.Order) error {
+	var quote *models.DeliveryQuote
+	if order.DeliveryAddress != "" {
+		var err error
+		if quote, err = deliveryfee.Quote(ctx, deliveryfee.LoadPolicy(ctx), order.DeliveryAddress); err != nil {
+			return err
+		}
+	}
+
+	order.Subtotal = itemsTotal(order)
+	order.DeliveryFee, order.DeliveryDistanceKm, order.DeliveryLocation = 0, 0, nil
+	if quote != nil {
+		order.DeliveryFee = quote.Fee
+		order.DeliveryDistanceKm = quote.DistanceKm
+		order.DeliveryLocation = &quote.Location
+	}
+	order.TotalPrice = order.Subtotal + order.DeliveryFee
+	return nil
+}
+
+// deliveryFeeFailed responds to an error from pricing a delivery
+func deliveryFeeFailed(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, deliveryfee.ErrDisabled):
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Delivery fee is not enabled")
+	case errors.Is(err, deliveryfee.ErrOutOfArea):
+		return response.ErrorCode(c, 400, response.CodeDeliveryOutOfArea, "Address is outside the delivery area")
+	case errors.Is(err, geocode.ErrNotFound):
+		return response.ErrorCode(c, 400, response.CodeAddressNotFound, "Address could not be found")
+	default:
+		return response.ErrorCode(c, 503, response.CodeServiceUnavailable, "Geocoding failed: "+err.Error())
+	}
+}
+
+// QuoteDeliveryFee prices the delivery to an address without saving anything
+func (h *OrderHandler) QuoteDeliveryFee(c *fiber.Ctx) error {
+	type QuoteRequest struct {
+		Address string `json:"address"`
+	}
+
+	var req QuoteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	req.Address = strings.TrimSpace(req.Address)
+	if req.Address == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Address is required")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	quote, err := deliveryfee.Quote(ctx, deliveryfee.LoadPolicy(ctx), req.Address)
+	if err != nil {
+		return deliveryFeeFailed(c, err)
+	}
+
+	return response.Success(c, 200, quote)
+}
+
+// GetDeliveryFeePolicy returns the delivery fee policy
+func (h *SettingsHandler) GetDeliveryFeePolicy(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	return response.Success(c, 200, fiber.Map{
+		"policy":            deliveryfee.LoadPolicy(ctx),
+		"geocoding_enabled": geocode.Enabled(),
+	})
+}
+
+// UpdateDeliveryFeePolicy saves the delivery fee policy. Without coordinates the depot
+// address is geocoded.
+func (h *SettingsHandler) UpdateDeliveryFeePolicy(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		Enabled      bool                     `json:"enabled"`
+		DepotAddress string                   `json:"depot_address"`
+		DepotLat     *float64                 `json:"depot_lat"`
+		DepotLng     *float64                 `json:"depot_lng"`
+		RoadFactor   float64                  `json:"road_factor"`
+		Tiers        []models.DeliveryFeeTier `json:"tiers"`
+		MinimumFee   float64                  `json:"minimum_fee"`
+		RoundTo      float64                  `json:"round_to"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.Enabled && len(req.Tiers) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least one tier is required when enabled")
+	}
+	sort.Slice(req.Tiers, func(i, j int) bool { return req.Tiers[i].UpToKm < req.Tiers[j].UpToKm })
+	for i, tier := range req.Tiers {
+		if tier.UpToKm <= 0 || tier.PerKm < 0 || (i > 0 && tier.UpToKm == req.Tiers[i-1].UpToKm) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Tiers need distinct positive up_to_km and a non-negative per_km")
+		}
+	}
+	if req.RoadFactor == 0 {
+		req.RoadFactor = 1
+	}
+	if req.RoadFactor < 1 || req.MinimumFee < 0 || req.RoundTo < 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "road_factor must be at least 1, minimum_fee and round_to cannot be negative")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	req.DepotAddress = strings.TrimSpace(req.DepotAddress)
+	var lat, lng float64
+	switch {
+	case req.DepotLat != nil && req.DepotLng != nil:
+		lat, lng = *req.DepotLat, *req.DepotLng
+	case req.DepotAddress != "" && geocode.Enabled():
+		place, err := geocode.Geocode(ctx, req.DepotAddress)
+		if err != nil {
+			return deliveryFeeFailed(c, err)
+		}
+		lat, lng = place.Lat, place.Lng
+	case req.Enabled:
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Depot coordinates are required")
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Depot coordinates are out of range")
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"enabled":       req.Enabled,
+			"depot_address": req.DepotAddress,
+			"depot_lat":     lat,
+			"depot_lng":     lng,
+			"road_factor":   req.RoadFactor,
+			"tiers":         req.Tiers,
+			"minimum_fee":   req.MinimumFee,
+			"round_to":      req.RoundTo,
+			"updated_by":    middleware.GetUserID(c),
+			"updated_at":    now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection(deliveryfee.Collection)
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save delivery fee policy")
+	}
+
+	audit.Log(c, audit.ActionFeePolicyUpdate, deliveryfee.Collection, "", map[string]interface{}{
+		"enabled": req.Enabled,
+		"tiers":   len(req.Tiers),
+	})
+
+	return response.Success(c, 200, deliveryfee.LoadPolicy(ctx))
+}
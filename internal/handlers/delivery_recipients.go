@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/mailer"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/shortlink"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxDeliveryRecipients limits the recipient list of one order or sales
+const maxDeliveryRecipients = 10
+
+// RecipientsRequest is the body for replacing a recipient list; an empty list clears it
+type RecipientsRequest struct {
+	Recipients []models.DeliveryRecipient `json:"recipients"`
+}
+
+// normalizeRecipients validates a recipient list and normalizes its phones and emails
+func normalizeRecipients(recipients []models.DeliveryRecipient) ([]models.DeliveryRecipient, error) {
+	if len(recipients) > maxDeliveryRecipients {
+		return nil, fmt.Errorf("At most %d recipients are allowed", maxDeliveryRecipients)
+	}
+
+	result := make([]models.DeliveryRecipient, 0, len(recipients))
+	for i, recipient := range recipients {
+		recipient.Name = strings.TrimSpace(recipient.Name)
+		recipient.Role = strings.TrimSpace(recipient.Role)
+		recipient.Phone = strings.TrimSpace(recipient.Phone)
+		recipient.Email = strings.ToLower(strings.TrimSpace(recipient.Email))
+
+		if recipient.Phone == "" && recipient.Email == "" {
+			return nil, fmt.Errorf("Recipient %d needs a phone or an email", i+1)
+		}
+		if recipient.Phone != "" {
+			phone, err := utils.NormalizePhone(recipient.Phone)
+			if err != nil {
+				return nil, fmt.Errorf("Recipient %d: %s", i+1, err.Error())
+			}
+			recipient.Phone = phone
+		}
+		if recipient.Email != "" && !strings.Contains(recipient.Email, "@") {
+			return nil, fmt.Errorf("Recipient %d has an invalid email", i+1)
+		}
+		if recipient.Name == "" {
+			recipient.Name = recipient.Role
+		}
+		result = append(result, recipient)
+	}
+	return result, nil
+}
+
+// setRecipients replaces the delivery recipients of an order or sales document
+func setRecipients(c *fiber.Ctx, collectionName string, notFound func() error) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req RecipientsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	recipients, err := normalizeRecipients(req.Recipients)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	update := bson.M{"$set": bson.M{"delivery_recipients": recipients, "updated_at": time.Now()}}
+	if len(recipients) == 0 {
+		update = bson.M{"$set": bson.M{"updated_at": time.Now()}, "$unset": bson.M{"delivery_recipients": ""}}
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	result, err := database.GetMongoCollection(collectionName).UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		return response.Error(c, 500, "Failed to save recipients")
+	}
+	if result.MatchedCount == 0 {
+		return notFound()
+	}
+
+	audit.Log(c, audit.ActionRecipientsSet, collectionName, objID.Hex(), map[string]interface{}{
+		"recipients": len(recipients),
+	})
+
+	return response.Success(c, 200, fiber.Map{"recipients": recipients})
+}
+
+// SetRecipients replaces the extra delivery note recipients of one order
+func (h *OrderHandler) SetRecipients(c *fiber.Ctx) error {
+	return setRecipients(c, "orders", func() error {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	})
+}
+
+// SetRecipients replaces the delivery note recipients used for every order of a sales,
+// e.g. the customer's warehouse contact or finance
+func (h *SalesHandler) SetRecipients(c *fiber.Ctx) error {
+	return setRecipients(c, "sales", func() error {
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
+	})
+}
+
+// noteRecipients merges the sales and order recipient lists into one delivery per
+// channel and address. The sales' own phone is skipped, it gets the regular notification.
+func noteRecipients(sales *models.Sales, order *models.Order) []models.NoteRecipient {
+	list := []models.DeliveryRecipient{}
+	if sales != nil {
+		list = append(list, sales.DeliveryRecipients...)
+	}
+	list = append(list, order.DeliveryRecipients...)
+
+	seen := map[string]bool{}
+	if sales != nil && sales.Phone != "" {
+		if phone, err := utils.NormalizePhone(sales.Phone); err == nil {
+			seen[models.NoteChannelWhatsApp+":"+phone] = true
+		}
+	}
+
+	result := []models.NoteRecipient{}
+	add := func(recipient models.DeliveryRecipient, channel, to string) {
+		if to == "" || seen[channel+":"+to] {
+			return
+		}
+		seen[channel+":"+to] = true
+		result = append(result, models.NoteRecipient{
+			Name:    recipient.Name,
+			Role:    recipient.Role,
+			Channel: channel,
+			To:      to,
+			Status:  models.NoteRecipientPending,
+		})
+	}
+	for _, recipient := range list {
+		add(recipient, models.NoteChannelWhatsApp, recipient.Phone)
+		add(recipient, models.NoteChannelEmail, recipient.Email)
+	}
+	return result
+}
+
+// sendNoteToRecipients delivers a note to its extra recipients and saves the status of
+// each. With onlyFailed the previous deliveries are kept and only failed ones are retried.
+func sendNoteToRecipients(noteID primitive.ObjectID, onlyFailed bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := deliverNote(ctx, noteID, onlyFailed); err != nil {
+		log.Printf("delivery note %s: sending to recipients failed: %v", noteID.Hex(), err)
+	}
+}
+
+// deliverNote does the work of sendNoteToRecipients
+func deliverNote(ctx context.Context, noteID primitive.ObjectID, onlyFailed bool) error {
+	deliveryCollection := database.GetMongoCollection("delivery_notes")
+
+	note := &models.DeliveryNote{}
+	if err := deliveryCollection.FindOne(ctx, bson.M{"_id": noteID}).Decode(note); err != nil {
+		return err
+	}
+
+	recipients := note.Recipients
+	if !onlyFailed {
+		orderObjID, err := primitive.ObjectIDFromHex(note.OrderID)
+		if err != nil {
+			return err
+		}
+		order := &models.Order{}
+		if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": orderObjID}).Decode(order); err != nil {
+			return err
+		}
+		var sales *models.Sales
+		if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+			sales = &models.Sales{}
+			if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales); err != nil {
+				sales = nil
+			}
+		}
+		recipients = noteRecipients(sales, order)
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	var pdf []byte
+	now := time.Now()
+	for i := range recipients {
+		recipient := &recipients[i]
+		if onlyFailed && recipient.Status != models.NoteRecipientFailed {
+			continue
+		}
+		recipient.Status = models.NoteRecipientPending
+		recipient.Error = ""
+		recipient.SentAt = nil
+
+		switch recipient.Channel {
+		case models.NoteChannelWhatsApp:
+			notif := notification.DeliveryNotification(
+				recipient.To,
+				recipient.Name,
+				note.NoteNumber,
+				note.ProductName,
+				note.ProductQty,
+				note.ProductUnit,
+				note.DriverName,
+				note.VehiclePlate,
+				note.Token,
+			)
+			notif.ID = primitive.NewObjectID()
+			notif.OrderID = note.OrderID
+			recipient.NotificationID = notif.ID.Hex()
+			if _, err := notification.Deliver(notif); err != nil {
+				recipient.Status = models.NoteRecipientFailed
+				recipient.Error = err.Error()
+			}
+
+		case models.NoteChannelEmail:
+			if !mailer.Enabled() {
+				recipient.Status = models.NoteRecipientFailed
+				recipient.Error = "Email delivery is not configured"
+				continue
+			}
+			if pdf == nil {
+				pdf = renderDeliveryNotePDF(note, loadCompanySettings(ctx))
+			}
+			link := shortlink.Link(fmt.Sprintf("%s/delivery/%s", config.Cfg.Client.URL, note.Token))
+			body := fmt.Sprintf("Halo %s,\n\nSurat jalan %s terlampir.\n\nLihat surat jalan: %s\n",
+				recipient.Name, note.NoteNumber, link)
+			err := mailer.Send([]string{recipient.To}, "Surat Jalan "+note.NoteNumber, body, mailer.Attachment{
+				Name:        note.NoteNumber + ".pdf",
+				ContentType: "application/pdf",
+				Data:        pdf,
+			})
+			if err != nil {
+				recipient.Status = models.NoteRecipientFailed
+				recipient.Error = err.Error()
+				continue
+			}
+			recipient.Status = models.NoteRecipientSent
+			recipient.SentAt = &now
+		}
+	}
+
+	_, err := deliveryCollection.UpdateOne(ctx, bson.M{"_id": noteID}, bson.M{"$set": bson.M{"recipients": recipients}})
+	return err
+}
+
+// refreshRecipientStatus reads the status of WhatsApp deliveries from their notifications,
+// which are retried in the background after the note was sent
+func refreshRecipientStatus(ctx context.Context, recipients []models.NoteRecipient) error {
+	ids := []primitive.ObjectID{}
+	for _, recipient := range recipients {
+		if id, err := primitive.ObjectIDFromHex(recipient.NotificationID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	cursor, err := database.GetMongoCollection("notifications").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var notifications []notification.Notification
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return err
+	}
+
+	byID := map[string]notification.Notification{}
+	for _, notif := range notifications {
+		byID[notif.ID.Hex()] = notif
+	}
+	for i := range recipients {
+		notif, ok := byID[recipients[i].NotificationID]
+		if !ok {
+			continue
+		}
+		switch {
+		case notif.Status == notification.StatusFailed:
+			recipients[i].Status = models.NoteRecipientFailed
+			recipients[i].Error = notif.LastError
+		case notif.SentAt != nil:
+			recipients[i].Status = models.NoteRecipientSent
+			recipients[i].SentAt = notif.SentAt
+			recipients[i].Error = ""
+		default:
+			// Not sent by the gateway (yet), only a wa.me link for manual sending exists
+			recipients[i].Status = models.NoteRecipientPending
+		}
+	}
+	return nil
+}
+
+// Recipients returns the extra recipients of a delivery note with their delivery status
+func (h *DeliveryHandler) Recipients(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	note := &models.DeliveryNote{}
+	if err := database.GetMongoCollection("delivery_notes").FindOne(ctx, bson.M{"_id": objID}).Decode(note); err != nil {
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
+	}
+
+	recipients := note.Recipients
+	if recipients == nil {
+		recipients = []models.NoteRecipient{}
+	}
+	if err := refreshRecipientStatus(ctx, recipients); err != nil {
+		return response.Error(c, 500, "Failed to fetch delivery status")
+	}
+
+	summary := map[string]int{
+		models.NoteRecipientPending: 0,
+		models.NoteRecipientSent:    0,
+		models.NoteRecipientFailed:  0,
+	}
+	for _, recipient := range recipients {
+		summary[recipient.Status]++
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"note_number": note.NoteNumber,
+		"recipients":  recipients,
+		"summary":     summary,
+	})
+}
+
+// SendToRecipients sends a delivery note to its recipients again in the background.
+// With only_failed=true only failed deliveries are retried; otherwise the current
+// recipient lists of the sales and order are used.
+func (h *DeliveryHandler) SendToRecipients(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+	onlyFailed := c.QueryBool("only_failed", false)
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	note := &models.DeliveryNote{}
+	if err := database.GetMongoCollection("delivery_notes").FindOne(ctx, bson.M{"_id": objID}).Decode(note); err != nil {
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
+	}
+	if note.Superseded {
+		return response.ErrorCode(c, 400, response.CodeDeliveryNoteSuperseded, "Only the latest revision can be sent")
+	}
+	if onlyFailed {
+		if err := refreshRecipientStatus(ctx, note.Recipients); err != nil {
+			return response.Error(c, 500, "Failed to fetch delivery status")
+		}
+		failed := 0
+		for _, recipient := range note.Recipients {
+			if recipient.Status == models.NoteRecipientFailed {
+				failed++
+			}
+		}
+		if failed == 0 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "No failed deliveries to retry")
+		}
+		// Save the refreshed statuses so the retry picks up failures of the notifications
+		database.GetMongoCollection("delivery_notes").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"recipients": note.Recipients}})
+	}
+
+	go sendNoteToRecipients(objID, onlyFailed)
+
+	audit.Log(c, audit.ActionDeliverySend, "delivery_note", objID.Hex(), map[string]interface{}{
+		"only_failed": onlyFailed,
+	})
+
+	return response.SuccessWithMessage(c, 202, "Delivery note is being sent")
+}
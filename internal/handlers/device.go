@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/device"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeviceHandler handles kiosk device routes
+type DeviceHandler struct{}
+
+// NewDeviceHandler creates a new device handler
+func NewDeviceHandler() *DeviceHandler {
+	return &DeviceHandler{}
+}
+
+// List returns all registered devices
+func (h *DeviceHandler) List(c *fiber.Ctx) error {
+	collection := database.GetMongoCollection("devices")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch devices")
+	}
+	defer cursor.Close(ctx)
+
+	devices := []models.Device{}
+	if err := cursor.All(ctx, &devices); err != nil {
+		return response.Error(c, 500, "Failed to fetch devices")
+	}
+
+	return response.Success(c, 200, devices)
+}
+
+// Create registers a device and returns its token; the token is only shown once
+func (h *DeviceHandler) Create(c *fiber.Ctx) error {
+	type CreateRequest struct {
+		Name     string `json:"name"`
+		Location string `json:"location"`
+	}
+
+	var req CreateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.Name == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Name is required")
+	}
+
+	token, hash := device.GenerateToken()
+
+	dev := models.NewDevice()
+	dev.Name = req.Name
+	dev.Location = req.Location
+	dev.TokenHash = hash
+	dev.TokenHint = device.TokenHint(token)
+	dev.CreatedBy = middleware.GetActorID(c)
+
+	collection := database.GetMongoCollection("devices")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, dev); err != nil {
+		return response.Error(c, 500, "Failed to register device")
+	}
+
+	audit.Log(c, audit.ActionDeviceCreate, "device", dev.ID.Hex(), map[string]interface{}{
+		"name": dev.Name,
+	})
+
+	return response.Success(c, 201, fiber.Map{
+		"device": dev,
+		"token":  token,
+	})
+}
+
+// Update renames, relocates, enables or disables a device
+func (h *DeviceHandler) Update(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type UpdateRequest struct {
+		Name     string  `json:"name,omitempty"`
+		Location *string `json:"location,omitempty"`
+		Active   *bool   `json:"active,omitempty"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	update := bson.M{"updated_at": time.Now()}
+	if req.Name != "" {
+		update["name"] = req.Name
+	}
+	if req.Location != nil {
+		update["location"] = *req.Location
+	}
+	if req.Active != nil {
+		update["active"] = *req.Active
+	}
+
+	collection := database.GetMongoCollection("devices")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	if err != nil {
+		return response.Error(c, 500, "Failed to update device")
+	}
+	if result.MatchedCount == 0 {
+		return response.NotFoundCode(c, response.CodeDeviceNotFound, "Device not found")
+	}
+
+	audit.Log(c, audit.ActionDeviceUpdate, "device", objID.Hex(), map[string]interface{}{
+		"name":     req.Name,
+		"location": req.Location,
+		"active":   req.Active,
+	})
+
+	return response.SuccessWithMessage(c, 200, "Successfully updated")
+}
+
+// RotateToken issues a new token for a device, invalidating the previous one
+func (h *DeviceHandler) RotateToken(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	token, hash := device.GenerateToken()
+
+	collection := database.GetMongoCollection("devices")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	update := bson.M{
+		"token_hash": hash,
+		"token_hint": device.TokenHint(token),
+		"updated_at": time.Now(),
+	}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	if err != nil {
+		return response.Error(c, 500, "Failed to rotate token")
+	}
+	if result.MatchedCount == 0 {
+		return response.NotFoundCode(c, response.CodeDeviceNotFound, "Device not found")
+	}
+
+	audit.Log(c, audit.ActionDeviceRotate, "device", objID.Hex(), nil)
+
+	return response.Success(c, 200, fiber.Map{
+		"token": token,
+	})
+}
+
+// Delete removes a device; its token stops working immediately
+func (h *DeviceHandler) Delete(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("devices")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return response.Error(c, 500, "Failed to delete device")
+	}
+	if result.DeletedCount == 0 {
+		return response.NotFoundCode(c, response.CodeDeviceNotFound, "Device not found")
+	}
+
+	audit.Log(c, audit.ActionDeviceDelete, "device", objID.Hex(), nil)
+
+	return response.SuccessWithMessage(c, 200, "Device deleted")
+}
+
+// Scans returns the queue actions performed by a device, most recent first
+func (h *DeviceHandler) Scans(c *fiber.Ctx) error {
+	deviceID := c.Params("id")
+	if _, err := primitive.ObjectIDFromHex(deviceID); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	pq := parsePage(c, 20, maxLogPageLimit)
+
+	filter := bson.M{
+		"actor_id": deviceID,
+		"action":   bson.M{"$in": []string{audit.ActionQueueScan, audit.ActionQueueCallNext}},
+	}
+
+	collection := database.GetMongoCollection("audit_logs")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	findOptions := pq.findOptions().
+		SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch scans")
+	}
+	defer cursor.Close(ctx)
+
+	logs := []models.AuditLog{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		return response.Error(c, 500, "Failed to fetch scans")
+	}
+	logs, more := trimPage(pq, logs)
+
+	return response.SuccessWithPagination(c, 200, logs, pq.pagination(total, more))
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorCodeHandler handles the error code catalog
+type ErrorCodeHandler struct{}
+
+// NewErrorCodeHandler creates a new error code handler
+func NewErrorCodeHandler() *ErrorCodeHandler {
+	return &ErrorCodeHandler{}
+}
+
+// List returns every error code the API can return in error_code
+func (h *ErrorCodeHandler) List(c *fiber.Ctx) error {
+	return response.Success(c, 200, response.Catalog)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"bg-go/internal/database"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// EventHandler handles internal event routes
+type EventHandler struct{}
+
+// NewEventHandler creates a new event handler
+func NewEventHandler() *EventHandler {
+	return &EventHandler{}
+}
+
+// List returns published events, newest first, filtered by type, resource and the role
+// an alert is addressed to
+func (h *EventHandler) List(c *fiber.Ctx) error {
+	pq := parsePage(c, 20, maxPageLimit)
+
+	filter := bson.M{}
+	if eventType := c.Query("type"); eventType != "" {
+		filter["type"] = eventType
+	}
+	if resourceID := c.Query("resource_id"); resourceID != "" {
+		filter["resource_id"] = resourceID
+	}
+	if role := c.Query("role"); role != "" {
+		filter["data.role"] = role // Alerts addressed to a role, such as SLA alerts
+	}
+
+	collection := database.GetMongoCollection(events.Collection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch events")
+	}
+	defer cursor.Close(ctx)
+
+	var list []events.Event
+	if err := cursor.All(ctx, &list); err != nil {
+		return response.Error(c, 500, "Failed to decode events")
+	}
+	list, more := trimPage(pq, list)
+
+	return response.SuccessWithPagination(c, 200, list, pq.pagination(total, more))
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/export"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportLocation is the timezone of timestamps in CSV exports
+func exportLocation() *time.Location {
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ExportCSV streams the orders created in a date range as CSV.
+// Optional filters: status, payment_status, sales_id.
+func (h *OrderHandler) ExportCSV(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	match := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+	if status := c.Query("status"); status != "" {
+		match["status"] = status
+	}
+	if paymentStatus := c.Query("payment_status"); paymentStatus != "" {
+		match["payment_status"] = paymentStatus
+	}
+	if salesID := c.Query("sales_id"); salesID != "" {
+		match["sales_id"] = salesID
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": bson.M{"created_at": 1}},
+		{"$lookup": bson.M{
+			"from": "sales",
+			"let":  bson.M{"sales_id": bson.M{"$convert": bson.M{"input": "$sales_id", "to": "objectId", "onError": nil, "onNull": nil}}},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []string{"$_id", "$$sales_id"}}}},
+				{"$project": bson.M{"name": 1}},
+			},
+			"as": "sales",
+		}},
+		{"$set": bson.M{"sales": bson.M{"$arrayElemAt": []interface{}{"$sales", 0}}}},
+	}
+
+	ctx, cancel := export.Context()
+	cursor, err := database.GetMongoCollection("orders").Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(500))
+	if err != nil {
+		cancel()
+		return response.Error(c, 500, "Failed to export orders")
+	}
+
+	header := []string{
+		"order_number", "created_at", "status", "payment_status", "sales", "customer_name", "customer_phone",
+		"products", "quantity", "total_price", "driver_name", "vehicle_plate", "queue_number",
+		"payment_verified_at", "delivery_note_number", "completed_at",
+	}
+	loc := exportLocation()
+	fileName := fmt.Sprintf("orders_%s_%s.csv", from.Format("20060102"), to.Add(-time.Second).Format("20060102"))
+
+	return export.StreamCSV(ctx, cancel, c, fileName, header, cursor, func(cursor *mongo.Cursor) ([]string, error) {
+		var order models.Order
+		if err := cursor.Decode(&order); err != nil {
+			return nil, err
+		}
+
+		products := []string{}
+		for _, item := range order.Items {
+			products = append(products, fmt.Sprintf("%s x%d %s", item.ProductName, item.Quantity, item.Unit))
+		}
+		salesName := ""
+		if order.Sales != nil {
+			salesName = order.Sales.Name
+		}
+		queueNumber := ""
+		if order.QueueNumber > 0 {
+			queueNumber = strconv.Itoa(order.QueueNumber)
+		}
+
+		return []string{
+			order.OrderNumber,
+			export.FormatTime(&order.CreatedAt, loc),
+			order.Status,
+			order.PaymentStatus,
+			salesName,
+			order.CustomerName,
+			order.CustomerPhone,
+			strings.Join(products, "; "),
+			strconv.Itoa(order.Quantity),
+			strconv.FormatFloat(order.TotalPrice, 'f', -1, 64),
+			order.DriverName,
+			order.VehiclePlate,
+			queueNumber,
+			export.FormatTime(order.PaymentVerifiedAt, loc),
+			order.DeliveryNoteNumber,
+			export.FormatTime(order.CompletedAt, loc),
+		}, nil
+	})
+}
+
+// ExportCSV streams the current revision of delivery notes in a date range as CSV.
+// Items of a note are joined into one row.
+func (h *DeliveryHandler) ExportCSV(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	filter := bson.M{
+		"created_at": bson.M{"$gte": from, "$lt": to},
+		"superseded": bson.M{"$ne": true},
+	}
+
+	ctx, cancel := export.Context()
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetBatchSize(500)
+	cursor, err := database.GetMongoCollection("delivery_notes").Find(ctx, filter, findOptions)
+	if err != nil {
+		cancel()
+		return response.Error(c, 500, "Failed to export delivery notes")
+	}
+
+	header := []string{
+		"note_number", "revision", "created_at", "sales_name", "driver_name", "vehicle_plate",
+		"product_name", "quantity", "unit",
+	}
+	loc := exportLocation()
+	fileName := fmt.Sprintf("surat-jalan_%s_%s.csv", from.Format("20060102"), to.Add(-time.Second).Format("20060102"))
+
+	return export.StreamCSV(ctx, cancel, c, fileName, header, cursor, func(cursor *mongo.Cursor) ([]string, error) {
+		var note models.DeliveryNote
+		if err := cursor.Decode(&note); err != nil {
+			return nil, err
+		}
+
+		products := []string{note.ProductName}
+		quantities := []string{strconv.Itoa(note.ProductQty)}
+		units := []string{note.ProductUnit}
+		if len(note.Items) > 0 {
+			products, quantities, units = []string{}, []string{}, []string{}
+			for _, item := range note.Items {
+				products = append(products, item.ProductName)
+				quantities = append(quantities, strconv.Itoa(item.Quantity))
+				units = append(units, item.Unit)
+			}
+		}
+
+		return []string{
+			note.NoteNumber,
+			strconv.Itoa(note.Revision),
+			export.FormatTime(&note.CreatedAt, loc),
+			note.SalesName,
+			note.DriverName,
+			note.VehiclePlate,
+			strings.Join(products, "; "),
+			strings.Join(quantities, "; "),
+			strings.Join(units, "; "),
+		}, nil
+	})
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/resthook"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultPollLimit and maxPollLimit bound the records one poll returns
+	defaultPollLimit = 50
+	maxPollLimit     = 500
+)
+
+// IntegrationHandler handles the polling triggers and REST hooks used by no-code
+// integrations such as Zapier and Make
+type IntegrationHandler struct{}
+
+// NewIntegrationHandler creates a new integration handler
+func NewIntegrationHandler() *IntegrationHandler {
+	return &IntegrationHandler{}
+}
+
+// Triggers lists the events REST hooks can subscribe to
+func (h *IntegrationHandler) Triggers(c *fiber.Ctx) error {
+	return response.Success(c, 200, resthook.Triggers)
+}
+
+// Orders is the polling trigger for orders
+func (h *IntegrationHandler) Orders(c *fiber.Ctx) error {
+	return h.poll(c, bson.M{})
+}
+
+// Payments is the polling trigger for payments: orders with a payment proof
+func (h *IntegrationHandler) Payments(c *fiber.Ctx) error {
+	return h.poll(c, bson.M{"payment_uploaded_at": bson.M{"$exists": true}})
+}
+
+// pollCursor parses the updated_since of a poll: the cursor of a previous poll
+// (updated_at and _id joined by "_"), or a bare RFC 3339 time
+func pollCursor(value string) (time.Time, primitive.ObjectID, error) {
+	stamp, id, compound := strings.Cut(value, "_")
+	since, err := time.Parse(time.RFC3339Nano, stamp)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, err
+	}
+	if !compound {
+		return since, primitive.NilObjectID, nil
+	}
+	sinceID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, err
+	}
+	return since, sinceID, nil
+}
+
+// poll returns the orders matching filter as flat records. With updated_since it returns
+// those updated after it, oldest first, and the cursor to pass next; without it the most
+// recently updated come first, as Zapier polling triggers expect. The cursor holds the
+// updated_at and _id of the last record, so orders sharing a timestamp are never skipped
+// when has_more cuts a page. The order list filters (search, sales_id, tags, overdue) apply.
+func (h *IntegrationHandler) poll(c *fiber.Ctx, filter bson.M) error {
+	limit := c.QueryInt("limit", defaultPollLimit)
+	if limit < 1 || limit > maxPollLimit {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "limit must be between 1 and "+strconv.Itoa(maxPollLimit))
+	}
+	applyOrderFilters(c, filter)
+
+	updatedSince := c.Query("updated_since")
+	sort := bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}
+	if updatedSince != "" {
+		since, sinceID, err := pollCursor(updatedSince)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid updated_since, pass the cursor of the last poll or RFC 3339 such as 2024-01-31T08:00:00Z")
+		}
+		if sinceID.IsZero() {
+			filter["updated_at"] = bson.M{"$gt": since}
+		} else {
+			// $and keeps the search $or of the order list filters
+			filter["$and"] = []bson.M{{"$or": []bson.M{
+				{"updated_at": bson.M{"$gt": since}},
+				{"updated_at": since, "_id": bson.M{"$gt": sinceID}},
+			}}}
+		}
+		sort = bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("orders").Find(ctx, filter,
+		options.Find().SetSort(sort).SetLimit(int64(limit)+1))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch orders")
+	}
+	var orders []models.Order
+	err = cursor.All(ctx, &orders)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode orders")
+	}
+
+	more := len(orders) > limit
+	if more {
+		orders = orders[:limit]
+	}
+
+	// The cursor is the newest order handed out, so the next poll continues after it
+	records := make([]map[string]interface{}, 0, len(orders))
+	next := updatedSince
+	for i := range orders {
+		records = append(records, resthook.OrderRecord(&orders[i]))
+	}
+	if len(orders) > 0 {
+		newest := orders[0]
+		if updatedSince != "" {
+			newest = orders[len(orders)-1]
+		}
+		next = newest.UpdatedAt.UTC().Format(time.RFC3339Nano) + "_" + newest.ID.Hex()
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"records":  records,
+		"cursor":   next,
+		"has_more": more,
+	})
+}
+
+// ListHooks returns the REST hook subscriptions
+func (h *IntegrationHandler) ListHooks(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	hooks := []resthook.Hook{}
+	cursor, err := database.GetMongoCollection(resthook.Collection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch hooks")
+	}
+	err = cursor.All(ctx, &hooks)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode hooks")
+	}
+
+	return response.Success(c, 200, hooks)
+}
+
+// Subscribe adds a REST hook: every event of the type is POSTed to the target URL until
+// it is unsubscribed or the target responds 410 Gone
+func (h *IntegrationHandler) Subscribe(c *fiber.Ctx) error {
+	type SubscribeRequest struct {
+		Event     string `json:"event"`
+		TargetURL string `json:"target_url"`
+	}
+
+	var req SubscribeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if !resthook.IsTrigger(req.Event) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown event, see /integrations/triggers")
+	}
+	target, err := url.Parse(req.TargetURL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "target_url must be an http(s) URL")
+	}
+
+	hook := resthook.Hook{
+		ID:        primitive.NewObjectID(),
+		Event:     req.Event,
+		TargetURL: target.String(),
+		CreatedBy: middleware.GetUserID(c),
+		CreatedAt: time.Now(),
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if _, err := database.GetMongoCollection(resthook.Collection).InsertOne(ctx, hook); err != nil {
+		return response.Error(c, 500, "Failed to subscribe")
+	}
+
+	audit.Log(c, audit.ActionHookSubscribe, "rest_hook", hook.ID.Hex(), map[string]interface{}{
+		"event":      hook.Event,
+		"target_url": hook.TargetURL,
+	})
+
+	return response.Success(c, 201, hook)
+}
+
+// Unsubscribe removes a REST hook
+func (h *IntegrationHandler) Unsubscribe(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	hook := &resthook.Hook{}
+	if err := database.GetMongoCollection(resthook.Collection).FindOneAndDelete(ctx, bson.M{"_id": objID}).Decode(hook); err != nil {
+		return response.NotFoundCode(c, response.CodeHookNotFound, "Hook not found")
+	}
+
+	audit.Log(c, audit.ActionHookUnsubscribe, "rest_hook", objID.Hex(), map[string]interface{}{
+		"event":      hook.Event,
+		"target_url": hook.TargetURL,
+	})
+
+	return response.SuccessWithMessage(c, 200, "Successfully unsubscribed")
+}
@@ -0,0 +1,1569 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/breaker"
+	"bg-go/internal/lib/buildinfo"
+	"bg-go/internal/lib/clientview"
+	"bg-go/internal/lib/cloudinary"
+	"bg-go/internal/lib/crypt"
+	"bg-go/internal/lib/envelope"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/jwt"
+	"bg-go/internal/lib/mailer"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/resthook"
+	"bg-go/internal/lib/sandbox"
+	"bg-go/internal/lib/shortlink"
+	"bg-go/internal/lib/whatsapp"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+	"bg-go/internal/routes"
+	"bg-go/internal/testutil"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPollingTriggers(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	older := seedOrder(h, sales, func(o *models.Order) { o.UpdatedAt = time.Now().Add(-time.Hour) })
+	newer := seedOrder(h, sales, nil)
+
+	resp := h.Request("GET", "/api/v1/integrations/orders", nil, admin)
+	records, _ := resp.Data()["records"].([]interface{})
+	if resp.Status != 200 || len(records) != 2 || records[0].(map[string]interface{})["order_id"] != newer.ID.Hex() {
+		t.Fatalf("poll: want the newest order first: %s", resp.Raw)
+	}
+
+	since := older.UpdatedAt.Add(time.Minute).UTC().Format(time.RFC3339Nano)
+	resp = h.Request("GET", "/api/v1/integrations/orders?updated_since="+url.QueryEscape(since), nil, admin)
+	records, _ = resp.Data()["records"].([]interface{})
+	if len(records) != 1 || records[0].(map[string]interface{})["order_id"] != newer.ID.Hex() {
+		t.Fatalf("poll since %s: want only %s: %s", since, newer.OrderNumber, resp.Raw)
+	}
+
+	resp = h.Request("GET", "/api/v1/integrations/orders?updated_since="+url.QueryEscape(resp.Data()["cursor"].(string)), nil, admin)
+	if records, _ = resp.Data()["records"].([]interface{}); len(records) != 0 {
+		t.Fatalf("poll from the cursor: want no records: %s", resp.Raw)
+	}
+
+	// Orders sharing a timestamp are paged one by one without skipping any
+	batch := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	want := []string{}
+	for i := 0; i < 3; i++ {
+		want = append(want, seedOrder(h, sales, func(o *models.Order) { o.UpdatedAt = batch }).ID.Hex())
+	}
+	cursor := batch.Add(-time.Second).UTC().Format(time.RFC3339Nano)
+	got := []string{}
+	for page := 0; page < 4; page++ {
+		resp = h.Request("GET", "/api/v1/integrations/orders?limit=1&updated_since="+url.QueryEscape(cursor), nil, admin)
+		records, _ = resp.Data()["records"].([]interface{})
+		for _, record := range records {
+			got = append(got, record.(map[string]interface{})["order_id"].(string))
+		}
+		cursor, _ = resp.Data()["cursor"].(string)
+		if resp.Data()["has_more"] != true {
+			break
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("paged orders = %v, want %v", got, want)
+	}
+
+	for _, query := range []string{"limit=0", "updated_since=yesterday", "updated_since=" + url.QueryEscape(since) + "_nothex"} {
+		if resp := h.Request("GET", "/api/v1/integrations/orders?"+query, nil, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%s: got %d %q", query, resp.Status, resp.ErrorCode())
+		}
+	}
+}
+
+func TestRestHooks(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	resp := h.Request("POST", "/api/v1/integrations/hooks", map[string]interface{}{
+		"event": "order.exploded", "target_url": "https://hooks.zapier.com/hooks/standard/1/abc",
+	}, admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unknown event: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp = h.Request("POST", "/api/v1/integrations/hooks", map[string]interface{}{
+		"event": resthook.PaymentUpdated, "target_url": "https://hooks.zapier.com/hooks/standard/1/abc",
+	}, admin)
+	if resp.Status != 201 {
+		t.Fatalf("subscribe: status = %d: %s", resp.Status, resp.Raw)
+	}
+	id, _ := resp.Data()["id"].(string)
+
+	resp = h.Request("DELETE", "/api/v1/integrations/hooks/"+id, nil, admin)
+	if resp.Status != 200 || h.Count(resthook.Collection, bson.M{}) != 0 {
+		t.Fatalf("unsubscribe: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestErrorReporting(t *testing.T) {
+	h := testutil.New(t)
+
+	var mu sync.Mutex
+	reports := map[string][]map[string]interface{}{}
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		reports[r.URL.Path] = append(reports[r.URL.Path], event)
+		if r.URL.Path == "/api/42/store/" && !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("store request without the DSN key: %q", r.Header.Get("X-Sentry-Auth"))
+		}
+		mu.Unlock()
+	}))
+	defer sink.Close()
+
+	previous := config.Cfg.ErrorReport
+	t.Cleanup(func() { config.Cfg.ErrorReport = previous })
+	config.Cfg.ErrorReport = config.ErrorReportConfig{
+		DSN:          strings.Replace(sink.URL, "http://", "http://public@", 1) + "/42",
+		WebhookURL:   sink.URL + "/hook",
+		Release:      "v1.2.3",
+		ServerErrors: true,
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: response.ErrorHandler})
+	app.Use(middleware.ErrorReporting())
+	app.Post("/boom", func(c *fiber.Ctx) error { panic("nil map") })
+	app.Get("/fail", func(c *fiber.Ctx) error { return response.Error(c, 500, "Failed to fetch orders") })
+	app.Get("/ok", func(c *fiber.Ctx) error { return response.Success(c, 200, nil) })
+
+	req := httptest.NewRequest("POST", "/boom", strings.NewReader(`{"password":"hunter2","driver_name":"Joko"}`))
+	req.Header.Set("Content-Type", "application/json")
+	for _, r := range []*http.Request{req, httptest.NewRequest("GET", "/fail", nil), httptest.NewRequest("GET", "/ok", nil)} {
+		resp, err := app.Test(r)
+		if err != nil {
+			t.Fatalf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+		if r.URL.Path != "/ok" && resp.StatusCode != 500 {
+			t.Fatalf("%s: status = %d, want 500", r.URL.Path, resp.StatusCode)
+		}
+	}
+
+	h.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reports["/hook"]) == 2 && len(reports["/api/42/store/"]) == 2
+	}, "want the panic and the 500 reported to both sinks: %v", reports)
+
+	mu.Lock()
+	defer mu.Unlock()
+	byLevel := map[string]map[string]interface{}{}
+	for _, event := range reports["/hook"] {
+		byLevel[event["level"].(string)] = event
+		if event["release"] != "v1.2.3" {
+			t.Fatalf("report without the release tag: %v", event)
+		}
+	}
+	panicked := byLevel["fatal"]
+	if panicked == nil || !strings.Contains(panicked["message"].(string), "nil map") {
+		t.Fatalf("want the panic reported: %v", reports["/hook"])
+	}
+	data := panicked["request"].(map[string]interface{})["data"].(string)
+	if strings.Contains(data, "hunter2") || !strings.Contains(data, "Joko") {
+		t.Fatalf("want the body redacted but kept: %s", data)
+	}
+	if failed := byLevel["error"]; failed == nil || !strings.Contains(failed["message"].(string), "Failed to fetch orders") {
+		t.Fatalf("want the 500 response reported: %v", reports["/hook"])
+	}
+}
+
+func TestWhatsAppSessionImport(t *testing.T) {
+	h := testutil.New(t)
+	superadmin := h.Token(models.RoleSuperAdmin)
+
+	previous := config.Cfg.WhatsApp
+	t.Cleanup(func() { config.Cfg.WhatsApp = previous })
+	config.Cfg.WhatsApp.SessionPath = t.TempDir()
+	config.Cfg.WhatsApp.SessionRuntimePath = t.TempDir()
+	config.Cfg.WhatsApp.SessionKey = "current passphrase"
+
+	other, err := envelope.NewKeyring("another deployment", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := other.Seal([]byte("SQLite format 3\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := h.Upload("/api/v1/whatsapp/session/import", "session", "whatsapp.db", []byte("x"), h.Token(models.RoleAdmin)); resp.Status != 403 {
+		t.Fatalf("admin import: status = %d, want 403", resp.Status)
+	}
+	for name, data := range map[string][]byte{"junk": []byte("not a database"), "foreign key": foreign} {
+		resp := h.Upload("/api/v1/whatsapp/session/import", "session", "whatsapp.db.enc", data, superadmin)
+		if resp.Status != 400 || resp.ErrorCode() != response.CodeWhatsAppSessionInvalid {
+			t.Fatalf("%s: got %d %q: %s", name, resp.Status, resp.ErrorCode(), resp.Raw)
+		}
+	}
+
+	if resp := h.Request("GET", "/api/v1/whatsapp/session/export", nil, superadmin); resp.ErrorCode() != response.CodeWhatsAppNotReady {
+		t.Fatalf("export without a client: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestWhatsAppCloudProvider(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	var mu sync.Mutex
+	var sent []map[string]interface{}
+	reject := false
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v21.0/1234/messages" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("request to %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		if reject {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":{"message":"Re-engagement message","code":131047}}`))
+			return
+		}
+		sent = append(sent, body)
+		w.Write([]byte(`{"messages":[{"id":"wamid.1"}]}`))
+	}))
+	defer api.Close()
+
+	previous := config.Cfg.WhatsApp
+	t.Cleanup(func() {
+		config.Cfg.WhatsApp = previous
+		whatsapp.Cloud = nil
+	})
+	config.Cfg.WhatsApp.Provider = whatsapp.ProviderCloud
+	config.Cfg.WhatsApp.CloudURL = api.URL + "/v21.0/"
+	config.Cfg.WhatsApp.CloudPhoneNumberID = "1234"
+	config.Cfg.WhatsApp.CloudAccessToken = "token"
+	config.Cfg.WhatsApp.CloudTimeout = 5 * time.Second
+	whatsapp.InitCloud()
+
+	resp := h.Request("POST", "/api/v1/whatsapp/send", map[string]string{"phone": "0812-3456-7890", "message": "Halo"}, admin)
+	if resp.Status != 200 || resp.Data()["provider"] != whatsapp.ProviderCloud {
+		t.Fatalf("send: status = %d: %s", resp.Status, resp.Raw)
+	}
+	mu.Lock()
+	if len(sent) != 1 || sent[0]["to"] != "6281234567890" || sent[0]["text"].(map[string]interface{})["body"] != "Halo" {
+		t.Fatalf("sent = %v", sent)
+	}
+	reject = true
+	mu.Unlock()
+
+	resp = h.Request("POST", "/api/v1/whatsapp/send", map[string]string{"phone": "081234567890", "message": "Halo"}, admin)
+	if resp.Status != 500 || !strings.Contains(string(resp.Raw), "Re-engagement message") {
+		t.Fatalf("rejected send: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	status := h.Request("GET", "/api/v1/whatsapp/status", nil, admin).Data()
+	cloud, _ := status["providers"].(map[string]interface{})[whatsapp.ProviderCloud].(map[string]interface{})
+	if status["active"] != whatsapp.ProviderCloud || !strings.Contains(fmt.Sprint(cloud["last_error"]), "131047") {
+		t.Fatalf("status = %v", status)
+	}
+}
+
+func TestSMSFallback(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	var mu sync.Mutex
+	var texts []map[string]string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sms-token" {
+			t.Errorf("gateway called with %q", r.Header.Get("Authorization"))
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		texts = append(texts, body)
+		mu.Unlock()
+		w.Write([]byte(`{"id":"sms-1"}`))
+	}))
+	defer gateway.Close()
+
+	previous := config.Cfg.SMS
+	t.Cleanup(func() { config.Cfg.SMS = previous })
+	config.Cfg.SMS = config.SMSConfig{
+		Gateway: "http", URL: gateway.URL, Token: "sms-token", From: "LLN",
+		Types: []string{"invoice"}, Cost: 350, Currency: "IDR", Timeout: 5 * time.Second,
+	}
+	h.WhatsApp.Fail(fmt.Errorf("%w: %s", whatsapp.ErrNoWhatsApp, sales.Phone))
+
+	resp := h.Request("POST", "/api/v1/orders", map[string]interface{}{
+		"sales_id":      sales.ID.Hex(),
+		"customer_name": "Toko Maju",
+		"items":         []map[string]interface{}{{"product_name": "Semen", "quantity": 10, "unit_price": 50000}},
+	}, admin)
+	if resp.Status != 201 {
+		t.Fatalf("create: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// The invoice has no WhatsApp to go to, so it goes out by SMS right away
+	h.Eventually(func() bool {
+		return h.Count("notifications", bson.M{"type": "invoice", "sent_via": "sms"}) == 1
+	}, "the invoice was not sent by SMS")
+	var stored struct {
+		Status string `bson:"status"`
+		SMS    struct {
+			Gateway   string  `bson:"gateway"`
+			MessageID string  `bson:"message_id"`
+			Segments  int     `bson:"segments"`
+			Cost      float64 `bson:"cost"`
+			Estimated bool    `bson:"estimated"`
+		} `bson:"sms"`
+	}
+	h.Find("notifications", bson.M{"type": "invoice"}, &stored)
+	if stored.Status != "sent" || stored.SMS.MessageID != "sms-1" || !stored.SMS.Estimated || stored.SMS.Cost != 350*float64(stored.SMS.Segments) {
+		t.Fatalf("notification = %+v", stored)
+	}
+	mu.Lock()
+	if len(texts) != 1 || texts[0]["to"] != "+"+sales.Phone || !strings.Contains(texts[0]["message"], "Invoice") {
+		t.Fatalf("gateway received %v", texts)
+	}
+	mu.Unlock()
+
+	stats := h.Request("GET", "/api/v1/notifications/stats", nil, admin).Data()
+	costs, _ := stats["sms"].([]interface{})
+	if len(costs) != 1 || costs[0].(map[string]interface{})["cost"] != stored.SMS.Cost {
+		t.Fatalf("sms stats = %v", stats["sms"])
+	}
+}
+
+func TestNotificationBroadcast(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	previous := config.Cfg.WhatsApp.BroadcastInterval
+	t.Cleanup(func() { config.Cfg.WhatsApp.BroadcastInterval = previous })
+	config.Cfg.WhatsApp.BroadcastInterval = 0
+
+	resp := h.Request("GET", "/api/v1/notifications/broadcast/preview?segment=active_sales", nil, admin)
+	if resp.Status != 200 || resp.Data()["audience"] != float64(1) {
+		t.Fatalf("preview: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/notifications/broadcast", map[string]interface{}{
+		"message": "Halo {name}, gudang tutup besok", "segment": "unknown",
+	}, admin)
+	if resp.Status != 400 {
+		t.Fatalf("unknown segment: status = %d", resp.Status)
+	}
+
+	resp = h.Request("POST", "/api/v1/notifications/broadcast", map[string]interface{}{
+		"message": "Halo {name}, gudang tutup besok", "segment": "active_sales",
+	}, admin)
+	if resp.Status != 202 {
+		t.Fatalf("broadcast: status = %d: %s", resp.Status, resp.Raw)
+	}
+	id := resp.Data()["id"].(string)
+
+	h.Eventually(func() bool {
+		return h.Count("notification_broadcasts", bson.M{"status": models.ScheduledStatusSent}) == 1
+	}, "the broadcast was not sent")
+	if messages := h.WhatsApp.MessagesTo(sales.Phone); len(messages) != 1 || messages[0].Text != "Halo Budi, gudang tutup besok" {
+		t.Fatalf("messages = %v", messages)
+	}
+
+	resp = h.Request("GET", "/api/v1/notifications/broadcasts/"+id, nil, admin)
+	recipients, _ := resp.Data()["recipients"].([]interface{})
+	if resp.Status != 200 || len(recipients) != 1 || recipients[0].(map[string]interface{})["status"] != "sent" {
+		t.Fatalf("detail: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("DELETE", "/api/v1/notifications/broadcasts/"+id, nil, admin)
+	if resp.Status != 409 {
+		t.Fatalf("cancel sent broadcast: status = %d", resp.Status)
+	}
+}
+
+func TestMigrationDryRun(t *testing.T) {
+	h := testutil.New(t)
+	superadmin := h.Token(models.RoleSuperAdmin)
+	sales := seedSales(h)
+	seedOrder(h, sales, nil)
+	h.Insert("orders", bson.M{"_id": primitive.NewObjectID(), "order_number": "ORD-LEGACY", "invoice_url": "https://old.test/invoice"})
+
+	resp := h.Request("POST", "/api/v1/migration/cleanup-orders", nil, superadmin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeConfirmRequired {
+		t.Fatalf("unconfirmed cleanup: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", "/api/v1/migration/cleanup-orders?dry_run=true", nil, superadmin)
+	if resp.Status != 200 {
+		t.Fatalf("dry run: status = %d: %s", resp.Status, resp.Raw)
+	}
+	operations := resp.Data()["operations"].([]interface{})
+	unset := operations[1].(map[string]interface{})
+	rewrite := operations[2].(map[string]interface{})
+	// New orders still store the legacy fields empty, so both orders lose them
+	if unset["documents"] != float64(2) || rewrite["documents"] != float64(1) || len(rewrite["sample"].([]interface{})) != 1 {
+		t.Fatalf("operations = %v", operations)
+	}
+	if h.Count("orders", bson.M{"invoice_url": "https://old.test/invoice"}) != 1 {
+		t.Fatal("the dry run changed orders")
+	}
+	token := resp.Data()["confirm_token"].(string)
+
+	resp = h.Request("POST", "/api/v1/migration/cleanup-orders?confirm="+token, nil, superadmin)
+	if resp.Status != 200 || h.Count("orders", bson.M{"invoice_url": bson.M{"$exists": true}}) != 0 {
+		t.Fatalf("confirmed cleanup: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A token no longer runs once the data changed since its dry run
+	resp = h.Request("POST", "/api/v1/migration/reset-orders?dry_run=true", nil, superadmin)
+	token = resp.Data()["confirm_token"].(string)
+	seedOrder(h, sales, nil)
+	resp = h.Request("POST", "/api/v1/migration/reset-orders?confirm="+token, nil, superadmin)
+	if resp.Status != 409 || h.Count("orders", bson.M{}) != 3 {
+		t.Fatalf("stale token: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	h := testutil.New(t)
+
+	previous, version, features := config.Cfg.App, buildinfo.Version, buildinfo.Features
+	t.Cleanup(func() { config.Cfg.App, buildinfo.Version, buildinfo.Features = previous, version, features })
+	buildinfo.Version, buildinfo.Features = "v1.4.0", "driver-otp, broadcasts,"
+	config.Cfg.App.Env, config.Cfg.App.BannerLabel, config.Cfg.App.BannerColor = "staging", "STAGING", "#d97706"
+
+	resp := h.Request("GET", "/api/v1/meta", nil, "")
+	build, _ := resp.Data()["build"].(map[string]interface{})
+	banner, _ := resp.Data()["banner"].(map[string]interface{})
+	if resp.Status != 200 || build["version"] != "v1.4.0" || resp.Data()["environment"] != "staging" || banner["label"] != "STAGING" {
+		t.Fatalf("meta: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if got := fmt.Sprint(build["features"]); got != "[driver-otp broadcasts]" {
+		t.Fatalf("features = %s, want the stamped flags", got)
+	}
+
+	// Production shows no banner
+	config.Cfg.App.Env, config.Cfg.App.BannerLabel = "production", ""
+	resp = h.Request("GET", "/api/v1/meta", nil, "")
+	if resp.Status != 200 || resp.Data()["banner"] != nil {
+		t.Fatalf("production meta: want no banner: %s", resp.Raw)
+	}
+}
+
+func TestNotificationBranding(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	previous := config.Cfg.WhatsApp.BroadcastInterval
+	t.Cleanup(func() { config.Cfg.WhatsApp.BroadcastInterval = previous })
+	config.Cfg.WhatsApp.BroadcastInterval = 0
+
+	resp := h.Request("PUT", "/api/v1/settings", map[string]interface{}{
+		"name": "LabaLaba Nusantara", "sender_name": "LLN Gudang Cikarang",
+		"signature": "Tim Gudang LLN", "footer": "Balas STOP untuk berhenti",
+	}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("save branding: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", "/api/v1/templates/preview", map[string]interface{}{
+		"template": "Halo {name}, order {order_number} antrian #{queue_number}.", "footer": "",
+	}, admin)
+	want := "*LLN Gudang Cikarang*\n\nHalo Budi, order ORD-20260101-0001 antrian #12.\nTim Gudang LLN"
+	if resp.Status != 200 || resp.Data()["message"] != want {
+		t.Fatalf("preview: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/templates/preview", map[string]interface{}{"status": models.OrderStatusLoading}, admin)
+	if message, _ := resp.Data()["message"].(string); resp.Status != 200 || !strings.Contains(message, "loading bay 2") {
+		t.Fatalf("status preview: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/templates/preview", map[string]interface{}{}, admin)
+	if resp.Status != 400 {
+		t.Fatalf("empty preview: status = %d", resp.Status)
+	}
+
+	// Sent messages carry the saved branding
+	resp = h.Request("POST", "/api/v1/notifications/broadcast", map[string]interface{}{
+		"message": "Halo {name}, gudang tutup besok", "segment": "active_sales",
+	}, admin)
+	if resp.Status != 202 {
+		t.Fatalf("broadcast: status = %d: %s", resp.Status, resp.Raw)
+	}
+	h.Eventually(func() bool { return len(h.WhatsApp.MessagesTo(sales.Phone)) == 1 }, "the broadcast was not sent")
+	want = "*LLN Gudang Cikarang*\n\nHalo Budi, gudang tutup besok\nTim Gudang LLN\n\n_Balas STOP untuk berhenti_"
+	if messages := h.WhatsApp.MessagesTo(sales.Phone); messages[0].Text != want {
+		t.Fatalf("message = %q, want %q", messages[0].Text, want)
+	}
+}
+
+func TestSettingsKeepOmittedFields(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	resp := h.Request("PUT", "/api/v1/settings", map[string]interface{}{"name": "LabaLaba Nusantara", "barcode_format": "code128"}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("save settings: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A form that does not send the barcode format leaves it as saved
+	resp = h.Request("PUT", "/api/v1/settings", map[string]interface{}{"name": "LLN"}, admin)
+	if resp.Status != 200 || resp.Data()["name"] != "LLN" || resp.Data()["barcode_format"] != "code128" {
+		t.Fatalf("update without the barcode format: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// And the notification branding
+	resp = h.Request("PUT", "/api/v1/settings", map[string]interface{}{"name": "LLN", "sender_name": "LLN Gudang", "footer": "Balas STOP"}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("save branding: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("PUT", "/api/v1/settings", map[string]interface{}{"name": "LLN", "footer": ""}, admin)
+	if resp.Status != 200 || resp.Data()["sender_name"] != "LLN Gudang" || resp.Data()["footer"] != "" {
+		t.Fatalf("update without the sender name: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestSettingsVersionsConcurrently(t *testing.T) {
+	h := testutil.New(t)
+	database.EnsureUniqueIndexes()
+	admin := h.Token(models.RoleAdmin)
+
+	if resp := h.Request("PUT", "/api/v1/settings", map[string]interface{}{"name": "LLN"}, admin); resp.Status != 200 {
+		t.Fatalf("create settings: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Saves at the same time each get their own version
+	const saves = 6
+	var wg sync.WaitGroup
+	for i := 0; i < saves; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h.Request("PUT", "/api/v1/settings", map[string]interface{}{"name": fmt.Sprintf("LLN %d", i)}, admin)
+		}(i)
+	}
+	wg.Wait()
+
+	var revisions []models.SettingsRevision
+	cursor, _ := database.GetMongoCollection("company_settings_history").Find(context.Background(), bson.M{})
+	cursor.All(context.Background(), &revisions)
+	versions := map[int]bool{}
+	for _, revision := range revisions {
+		if versions[revision.Version] {
+			t.Fatalf("version %d recorded twice", revision.Version)
+		}
+		versions[revision.Version] = true
+	}
+	if len(revisions) != saves+1 {
+		t.Fatalf("want a version per save, got %d", len(revisions))
+	}
+}
+
+func TestNotificationSearch(t *testing.T) {
+	h := testutil.New(t)
+	at := func(days int) time.Time { return time.Date(2026, 3, days, 10, 0, 0, 0, time.UTC) }
+	h.Insert("notifications",
+		notification.Notification{ID: primitive.NewObjectID(), Type: "status_paid", Phone: "6281234567890", Message: "Pembayaran order ORD-1 telah diterima", Status: "sent", CreatedAt: at(2)},
+		notification.Notification{ID: primitive.NewObjectID(), Type: "status_paid", Phone: "6289876543210", Message: "Pembayaran order ORD-2 telah diterima", Status: "sent", CreatedAt: at(5)},
+		notification.Notification{ID: primitive.NewObjectID(), Type: "reminder", Phone: "6281234567890", Message: "Order ORD-3 menunggu pembayaran", Status: "failed", CreatedAt: at(9)},
+		notification.Notification{ID: primitive.NewObjectID(), Type: "reminder", Phone: "081234567890", Message: "Order ORD-4 menunggu pembayaran", Status: "sent", CreatedAt: at(20)},
+	)
+	superadmin := h.Token(models.RoleSuperAdmin)
+
+	if resp := h.Request("GET", "/api/v1/notifications/search?q=ORD-1", nil, h.Token(models.RoleAdmin)); resp.Status != 403 {
+		t.Fatalf("admin search: status = %d", resp.Status)
+	}
+	for _, query := range []string{"", "?q=pembayaran", "?q=pembayaran&from=2026-03-01", "?q=pembayaran&from=2025-01-01&to=2026-03-31"} {
+		if resp := h.Request("GET", "/api/v1/notifications/search"+query, nil, superadmin); resp.Status != 400 {
+			t.Fatalf("search %q: status = %d", query, resp.Status)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"q=pembayaran&from=2026-03-01&to=2026-03-31", 4},
+		{"q=%22telah+diterima%22&from=2026-03-01&to=2026-03-31", 2},
+		{"q=pembayaran+ORD-3&from=2026-03-01&to=2026-03-31", 1},
+		{"phone=0812-3456-7890", 3},
+		{"phone=0812-3456-7890&q=diterima&from=2026-03-01&to=2026-03-31", 1},
+		{"from=2026-03-04&to=2026-03-09", 2},
+		{"to=2026-03-04", 1},
+	}
+	for _, tt := range tests {
+		resp := h.Request("GET", "/api/v1/notifications/search?"+tt.query, nil, superadmin)
+		list, _ := resp.Body["data"].([]interface{})
+		if resp.Status != 200 || len(list) != tt.want {
+			t.Errorf("%s: status = %d, %d results, want %d", tt.query, resp.Status, len(list), tt.want)
+		}
+	}
+
+	resp := h.Request("GET", "/api/v1/notifications/search/export?phone=6281234567890", nil, superadmin)
+	if resp.Status != 200 || !strings.Contains(string(resp.Raw), "Order ORD-3 menunggu pembayaran") || !strings.Contains(string(resp.Raw), "ORD-4") || strings.Contains(string(resp.Raw), "ORD-2") {
+		t.Fatalf("export: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if n := h.Count("audit_logs", bson.M{"action": bson.M{"$regex": `^notification\.(search|export)$`}}); n != len(tests)+1 {
+		t.Fatalf("want every search and export audited, got %d entries", n)
+	}
+}
+
+func TestResendInvite(t *testing.T) {
+	h := testutil.New(t)
+
+	// No email or phone, so the resent link comes back in the response
+	invitee := models.NewUser()
+	invitee.Username = "owner"
+	invitee.Role = models.RoleSuperAdmin
+	invitee.IsActive = true
+	invitee.InviteTokenHash = "stale"
+	invitee.InvitedBy = primitive.NewObjectID().Hex()
+	h.Insert("users", invitee)
+
+	path := "/api/v1/auth/invite/" + invitee.ID.Hex() + "/resend"
+	resp := h.Request("POST", path, nil, h.Token(models.RoleAdmin))
+	if resp.Status != 403 || strings.Contains(string(resp.Raw), "invite_link") {
+		t.Fatalf("admin resending a superadmin invite: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", path, nil, h.Token(models.RoleSuperAdmin))
+	if resp.Status != 200 {
+		t.Fatalf("superadmin resend: status = %d: %s", resp.Status, resp.Raw)
+	}
+	link, _ := resp.Data()["invite_link"].(string)
+	token := link[strings.LastIndex(link, "/")+1:]
+	if resp = h.Request("GET", "/api/v1/auth/invite/"+token, nil, ""); resp.Status != 200 {
+		t.Fatalf("resent link: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestSessionRevocation(t *testing.T) {
+	h := testutil.New(t)
+	userID := primitive.NewObjectID().Hex()
+	token := h.TokenFor(userID, models.RoleUser)
+
+	resp := h.Request("GET", "/api/v1/auth/sessions", nil, token)
+	sessions, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(sessions) != 1 {
+		t.Fatalf("sessions: got %d: %s", resp.Status, resp.Raw)
+	}
+	sessionID, _ := sessions[0].(map[string]interface{})["id"].(string)
+
+	if resp = h.Request("DELETE", "/api/v1/auth/sessions/"+sessionID, nil, token); resp.Status != 200 {
+		t.Fatalf("revoke: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("GET", "/api/v1/auth/sessions", nil, token); resp.ErrorCode() != response.CodeSessionRevoked {
+		t.Fatalf("access token of a revoked session: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	other := primitive.NewObjectID().Hex()
+	otherToken := h.TokenFor(other, models.RoleUser)
+	if resp = h.Request("DELETE", "/api/v1/auth/users/"+other+"/sessions", nil, h.Token(models.RoleAdmin)); resp.Status != 200 {
+		t.Fatalf("revoke all: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("GET", "/api/v1/auth/sessions", nil, otherToken); resp.ErrorCode() != response.CodeSessionRevoked {
+		t.Fatalf("access token after revoke all: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	legacy, _ := jwt.GenerateAccessToken(userID, models.RoleUser, "")
+	if resp = h.Request("GET", "/api/v1/auth/sessions", nil, legacy); resp.ErrorCode() != response.CodeSessionRevoked {
+		t.Fatalf("access token without a session: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	// Sessions that cannot be read are an error, not an empty list or a pass
+	checked := h.TokenFor(primitive.NewObjectID().Hex(), models.RoleUser)
+	unchecked := h.TokenFor(primitive.NewObjectID().Hex(), models.RoleUser)
+	h.Request("GET", "/api/v1/auth/sessions", nil, checked)
+	h.Mongo.Fail("sessions")
+	if resp = h.Request("GET", "/api/v1/auth/sessions", nil, checked); resp.Status != 500 {
+		t.Fatalf("sessions unavailable: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("GET", "/api/v1/auth/sessions", nil, unchecked); resp.Status != 503 {
+		t.Fatalf("session check unavailable: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestRetryDueClaims(t *testing.T) {
+	h := testutil.New(t)
+
+	due := time.Now().Add(-time.Minute)
+	for _, phone := range []string{"6281111111111", "6282222222222", "6283333333333"} {
+		h.Insert("notifications", notification.Notification{
+			ID: primitive.NewObjectID(), Type: notification.NotificationTypeInvoice, Phone: phone,
+			Message: "Retry", Status: notification.StatusPending, Attempts: 1, NextAttemptAt: &due, CreatedAt: due,
+		})
+	}
+
+	// The cron sweep and a requeue can run at once; each message still goes out once
+	h.WhatsApp.SetDelay(20 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notification.RetryDue()
+		}()
+	}
+	wg.Wait()
+
+	if sent := len(h.WhatsApp.Messages()); sent != 3 {
+		t.Fatalf("sent %d messages, want 3", sent)
+	}
+	if count := h.Count("notifications", bson.M{"status": notification.StatusSent}); count != 3 {
+		t.Fatalf("%d notifications sent, want 3", count)
+	}
+}
+
+func TestRepairDanglingItemProducts(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	superadmin := h.Token(models.RoleSuperAdmin)
+
+	product := models.NewProduct()
+	product.Name = "Semen"
+	h.Insert("products", product)
+
+	orderID := primitive.NewObjectID()
+	missing := primitive.NewObjectID().Hex()
+	h.Insert("orders", bson.M{
+		"_id":      orderID,
+		"sales_id": sales.ID.Hex(),
+		"items": bson.A{
+			bson.M{"product_name": "Semen", "product_id": product.ID.Hex()},
+			bson.M{"product_name": "Pasir", "product_id": missing},
+		},
+	})
+
+	resp := h.Request("POST", "/api/v1/migration/integrity/repair?mode=nullify", nil, superadmin)
+	if resp.Status != 200 {
+		t.Fatalf("repair: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Only the item with the missing product loses its reference
+	var stored struct {
+		Items []struct {
+			ProductID string `bson:"product_id"`
+		} `bson:"items"`
+	}
+	h.Find("orders", bson.M{"_id": orderID}, &stored)
+	if len(stored.Items) != 2 || stored.Items[0].ProductID != product.ID.Hex() || stored.Items[1].ProductID != "" {
+		t.Fatalf("items after repair = %+v", stored.Items)
+	}
+}
+
+func TestPruneClientViews(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	kept := seedOrder(h, sales, nil)
+	deleted := primitive.NewObjectID()
+	h.Insert(clientview.Collection,
+		models.ClientView{ID: kept.ID, InvoiceToken: kept.InvoiceToken, OrderNumber: kept.OrderNumber},
+		models.ClientView{ID: deleted, InvoiceToken: "archived", OrderNumber: "ORD-OLD"},
+	)
+
+	clientview.Prune()
+	if h.Count(clientview.Collection, bson.M{"_id": deleted}) != 0 || h.Count(clientview.Collection, bson.M{"_id": kept.ID}) != 1 {
+		t.Fatal("want only the view of the deleted order pruned")
+	}
+}
+
+func TestHealthBreakersHideErrors(t *testing.T) {
+	h := testutil.New(t)
+	b := breaker.New("health-test", 5, time.Minute, 0)
+	b.Record(errors.New("dial tcp user:secret@db.internal:27017: refused"))
+
+	resp := h.Request("GET", "/health/breakers", nil, "")
+	if resp.Status != 200 || !strings.Contains(string(resp.Raw), "health-test") || strings.Contains(string(resp.Raw), "secret") {
+		t.Fatalf("health: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	h := testutil.New(t)
+	actorID := primitive.NewObjectID().Hex()
+	superadmin := h.TokenFor(actorID, models.RoleSuperAdmin)
+
+	user := models.NewUser()
+	user.Username = "cashier"
+	user.Role = models.RoleUser
+	inactive := models.NewUser()
+	inactive.Username = "former"
+	inactive.Role = models.RoleUser
+	inactive.IsActive = false
+	admin := models.NewUser()
+	admin.Username = "manager"
+	admin.Role = models.RoleAdmin
+	h.Insert("users", user, inactive, admin)
+
+	path := "/api/v1/auth/impersonate/"
+	if resp := h.Request("POST", path+user.ID.Hex(), nil, h.Token(models.RoleAdmin)); resp.Status != 403 {
+		t.Fatalf("admin impersonating: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", path+admin.ID.Hex(), nil, superadmin); resp.ErrorCode() != response.CodeImpersonationNotAllowed {
+		t.Fatalf("impersonating an admin: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	if resp := h.Request("POST", path+inactive.ID.Hex(), nil, superadmin); resp.ErrorCode() != response.CodeAccountDeactivated {
+		t.Fatalf("impersonating a deactivated user: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	if resp := h.Request("POST", path+primitive.NewObjectID().Hex(), nil, superadmin); resp.ErrorCode() != response.CodeUserNotFound {
+		t.Fatalf("impersonating a missing user: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp := h.Request("POST", path+user.ID.Hex(), nil, superadmin)
+	token, _ := resp.Body["access_token"].(string)
+	if resp.Status != 200 || token == "" || resp.Body["actor_id"] != actorID {
+		t.Fatalf("impersonate: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// The token acts as the user but still names the superadmin behind it
+	resp = h.Request("GET", "/api/v1/auth/me", nil, token)
+	if resp.Status != 200 || resp.Body["id"] != user.ID.Hex() || resp.Body["impersonating"] != true || resp.Body["actor_id"] != actorID {
+		t.Fatalf("me while impersonating: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("POST", path+admin.ID.Hex(), nil, token); resp.Status != 403 {
+		t.Fatalf("impersonating with an impersonation token: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	var entry models.AuditLog
+	h.Find("audit_logs", bson.M{"action": audit.ActionImpersonate}, &entry)
+	if entry.ActorID != actorID || entry.EntityID != user.ID.Hex() || entry.OnBehalfOf != "" {
+		t.Fatalf("audit entry = %+v", entry)
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	h := testutil.New(t)
+	superadmin := h.Token(models.RoleSuperAdmin)
+
+	invalid := []map[string]interface{}{
+		{"field": "email", "enabled": true, "after_days": 90},
+		{"field": models.RetentionFieldDriverPhone, "enabled": true, "after_days": 7},
+	}
+	for _, rule := range invalid {
+		body := map[string]interface{}{"fields": []interface{}{rule}}
+		if resp := h.Request("PUT", "/api/v1/settings/retention", body, superadmin); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("rule %v: got %d %q", rule, resp.Status, resp.ErrorCode())
+		}
+	}
+	body := map[string]interface{}{"fields": []map[string]interface{}{
+		{"field": models.RetentionFieldDriverPhone, "enabled": true, "after_days": 90},
+		{"field": models.RetentionFieldPaymentProof, "enabled": true, "after_days": 90},
+	}}
+	if resp := h.Request("PUT", "/api/v1/settings/retention", body, superadmin); resp.Status != 200 {
+		t.Fatalf("save policy: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	sales := seedSales(h)
+	longAgo := time.Now().AddDate(0, 0, -100)
+	expired := func(status string, updatedAt time.Time) func(o *models.Order) {
+		return func(o *models.Order) {
+			o.PaymentProof = &models.Image{PublicID: "payment-proof/" + o.ID.Hex()}
+			o.DriverPhone = "6289876543210"
+			o.Status = status
+			o.UpdatedAt = updatedAt
+		}
+	}
+	old := seedOrder(h, sales, expired(models.OrderStatusCompleted, longAgo))
+	recent := seedOrder(h, sales, expired(models.OrderStatusCompleted, time.Now()))
+	// Orders still in progress keep their data however old they are
+	open := seedOrder(h, sales, expired(models.OrderStatusLoading, longAgo))
+	note := models.NewDeliveryNote()
+	note.DriverPhone = "6289876543210"
+	note.CreatedAt = longAgo
+	h.Insert("delivery_notes", note)
+
+	resp := h.Request("GET", "/api/v1/settings/retention/preview", nil, superadmin)
+	if resp.Status != 200 || resp.Data()["dry_run"] != true || resp.Data()["total"] != 3.0 {
+		t.Fatalf("preview: got %d: %s", resp.Status, resp.Raw)
+	}
+	if h.Count("orders", bson.M{"driver_phone": ""}) != 0 {
+		t.Fatalf("the preview changed orders")
+	}
+
+	resp = h.Request("POST", "/api/v1/settings/retention/run", nil, superadmin)
+	if resp.Status != 200 || resp.Data()["total"] != 3.0 {
+		t.Fatalf("run: got %d: %s", resp.Status, resp.Raw)
+	}
+	anonymized := &models.Order{}
+	h.Find("orders", bson.M{"_id": old.ID}, anonymized)
+	if anonymized.DriverPhone != "" || anonymized.PaymentProof != nil {
+		t.Fatalf("expired order kept %q and %v", anonymized.DriverPhone, anonymized.PaymentProof)
+	}
+	if destroyed := h.Storage.Destroyed(); len(destroyed) != 1 || destroyed[0] != "payment-proof/"+old.ID.Hex() {
+		t.Fatalf("destroyed files = %v", destroyed)
+	}
+	if h.Count("delivery_notes", bson.M{"_id": note.ID, "driver_phone": ""}) != 1 {
+		t.Fatalf("the delivery note copy of the phone was kept")
+	}
+	for _, kept := range []*models.Order{recent, open} {
+		if h.Count("orders", bson.M{"_id": kept.ID, "driver_phone": "6289876543210", "payment_proof": bson.M{"$exists": true}}) != 1 {
+			t.Fatalf("order %s was anonymized", kept.OrderNumber)
+		}
+	}
+}
+
+func TestRequestCapture(t *testing.T) {
+	h := testutil.New(t)
+	previous := config.Cfg.Capture
+	t.Cleanup(func() { config.Cfg.Capture = previous })
+	config.Cfg.Capture = config.CaptureConfig{Enabled: true, SamplePercent: 100, MaxBodyBytes: 4096, StorageBytes: 1 << 20}
+
+	h.App = fiber.New(fiber.Config{ErrorHandler: response.ErrorHandler})
+	h.App.Use(middleware.RequestContext(), middleware.RequestCapture())
+	routes.SetupRoutes(h.App)
+
+	login := map[string]string{"username": "budi", "password": "hunter2", "note": "call 081234567890"}
+	h.Request("POST", "/api/v1/auth/login", login, "")
+	h.Request("GET", "/api/v1/client/invoice/0123456789abcdef0123456789abcdef", nil, "")
+	h.Eventually(func() bool { return h.Count(middleware.RequestLogCollection, bson.M{}) == 2 }, "the requests were not captured")
+
+	// Secrets are dropped, phone numbers masked and tokens in paths hidden
+	entry := &models.RequestLog{}
+	h.Find(middleware.RequestLogCollection, bson.M{"path": "/api/v1/auth/login"}, entry)
+	if strings.Contains(entry.RequestBody, "hunter2") || !strings.Contains(entry.RequestBody, `"password":"[REDACTED]"`) ||
+		strings.Contains(entry.RequestBody, "081234567890") || !strings.Contains(entry.RequestBody, "*********890") {
+		t.Fatalf("captured login body = %s", entry.RequestBody)
+	}
+	if h.Count(middleware.RequestLogCollection, bson.M{"path": "/api/v1/client/invoice/[token]"}) != 1 {
+		t.Fatalf("the invoice token was kept in the captured path")
+	}
+
+	admin := h.Token(models.RoleAdmin)
+	resp := h.Request("GET", "/api/v1/request-logs?method=post&path=/api/v1/auth", nil, admin)
+	if logs, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(logs) != 1 {
+		t.Fatalf("search: got %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("GET", "/api/v1/request-logs?q=hunter2", nil, admin)
+	if logs, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(logs) != 0 {
+		t.Fatalf("search for a redacted secret: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestNotificationSandbox(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	previous, previousMail := config.Cfg.Notification, config.Cfg.Mail
+	t.Cleanup(func() { config.Cfg.Notification, config.Cfg.Mail = previous, previousMail })
+	config.Cfg.Notification.Sandbox = true
+	config.Cfg.Notification.SandboxAllowlist = []string{"+62 811-1111-1111"}
+	config.Cfg.Mail.Host = "smtp.invalid"
+
+	// Allowlisted recipients match in any format and still get real messages
+	if _, err := notification.SendReportNotification("6282222222222", "Laporan harian"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := notification.SendReportNotification("081111111111", "Laporan harian"); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.WhatsApp.MessagesTo("6282222222222")) != 0 || len(h.WhatsApp.MessagesTo("081111111111")) != 1 {
+		t.Fatalf("whatsapp sent = %v", h.WhatsApp.Messages())
+	}
+	if h.Count("notifications", bson.M{"phone": "6282222222222", "status": notification.StatusSent, "sent_via": "sandbox"}) != 1 {
+		t.Fatal("the captured message was not recorded as sent")
+	}
+	// The email is captured, so the SMTP server that does not exist is never contacted
+	if err := mailer.Send([]string{"owner@example.com"}, "Laporan", "Isi", mailer.Attachment{Name: "laporan.csv"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := h.Request("GET", "/api/v1/notifications/sandbox?channel=whatsapp", nil, admin)
+	messages, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(messages) != 1 || messages[0].(map[string]interface{})["to"] != "6282222222222" {
+		t.Fatalf("whatsapp outbox: got %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("GET", "/api/v1/notifications/sandbox?to=owner@example.com", nil, admin)
+	messages, _ = resp.Body["data"].([]interface{})
+	if len(messages) != 1 || messages[0].(map[string]interface{})["subject"] != "Laporan" {
+		t.Fatalf("email outbox: %s", resp.Raw)
+	}
+
+	resp = h.Request("GET", "/api/v1/notifications/sandbox/status", nil, admin)
+	if resp.Data()["enabled"] != true || resp.Data()["captured"] != 2.0 {
+		t.Fatalf("status: %s", resp.Raw)
+	}
+	resp = h.Request("DELETE", "/api/v1/notifications/sandbox?channel=email", nil, admin)
+	if resp.Data()["deleted"] != 1.0 || h.Count(sandbox.Collection, bson.M{}) != 1 {
+		t.Fatalf("clear: %s", resp.Raw)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	resp := h.Request("GET", "/api/v1/error-codes", nil, "")
+	codes, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(codes) != len(response.Catalog) {
+		t.Fatalf("catalog: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp := h.Request("GET", "/api/v1/orders", nil, ""); resp.Status != 401 || resp.ErrorCode() != response.CodeTokenMissing {
+		t.Fatalf("no token: got %d: %s", resp.Status, resp.Raw)
+	}
+	req := httptest.NewRequest("GET", "/api/v1/orders", nil)
+	req.Header.Set("Authorization", "Basic "+admin)
+	if resp := h.Send(req); resp.ErrorCode() != response.CodeTokenInvalid {
+		t.Fatalf("token format: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	previous := config.Cfg.JWT.AccessExpiry
+	config.Cfg.JWT.AccessExpiry = -time.Minute
+	expired := h.Token(models.RoleAdmin)
+	config.Cfg.JWT.AccessExpiry = previous
+	if resp := h.Request("GET", "/api/v1/orders", nil, expired); resp.Status != 401 || resp.ErrorCode() != response.CodeTokenExpired {
+		t.Fatalf("expired token: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp := h.Request("POST", "/api/v1/orders", map[string]interface{}{}, h.Token(models.RoleUser)); resp.Status != 403 || resp.ErrorCode() != response.CodeInsufficientPermissions {
+		t.Fatalf("role guard: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/orders/nope", nil, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeInvalidID {
+		t.Fatalf("invalid id: got %d: %s", resp.Status, resp.Raw)
+	}
+	// Not found keeps the 200 status of the API, the code tells it apart
+	if resp := h.Request("GET", "/api/v1/orders/"+primitive.NewObjectID().Hex(), nil, admin); resp.Status != 200 || resp.ErrorCode() != response.CodeOrderNotFound {
+		t.Fatalf("missing order: got %d: %s", resp.Status, resp.Raw)
+	}
+	req = httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader("{"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+admin)
+	if resp := h.Send(req); resp.Status != 400 || resp.ErrorCode() != response.CodeInvalidBody {
+		t.Fatalf("invalid body: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp := h.Request("POST", "/api/v1/auth/login", map[string]interface{}{"username": "nobody", "password": "secret"}, ""); resp.ErrorCode() != response.CodeInvalidCredentials {
+		t.Fatalf("login: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/auth/refresh", nil, ""); resp.Status != 401 || resp.ErrorCode() != response.CodeRefreshTokenInvalid {
+		t.Fatalf("refresh: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestWhatsAppBroadcast(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	previous := config.Cfg.WhatsApp
+	t.Cleanup(func() { config.Cfg.WhatsApp = previous })
+	config.Cfg.WhatsApp.BroadcastInterval = 0
+
+	h.WhatsApp.SetGroups(
+		whatsapp.Group{JID: "1203-ops@g.us", Name: "Ops"},
+		whatsapp.Group{JID: "1203-news@g.us", Name: "News", AnnounceOnly: true},
+		whatsapp.Group{JID: "1203-board@g.us", Name: "Board", AnnounceOnly: true, IsAdmin: true},
+	)
+	sales := seedSales(h)
+	quiet := models.NewSales()
+	quiet.Name, quiet.Phone, quiet.IsActive = "Sari", "6281111111111", true
+	inactive := models.NewSales()
+	inactive.Name, inactive.Phone, inactive.IsActive = "Joko", "6282222222222", false
+	h.Insert("sales", quiet, inactive)
+	if _, err := notification.OptOut(context.Background(), quiet.Phone, models.OptOutSourceAdmin, "", ""); err != nil {
+		t.Fatalf("opt out: %v", err)
+	}
+
+	resp := h.Request("GET", "/api/v1/whatsapp/groups", nil, admin)
+	if groups, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(groups) != 3 {
+		t.Fatalf("groups: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"message": " ", "all_groups": true},
+		{"message": "Libur"},
+		{"message": "Libur", "group_jids": []string{"1203-other@g.us"}},
+		{"message": "Libur", "group_jids": []string{"1203-news@g.us"}},
+	} {
+		if resp := h.Request("POST", "/api/v1/whatsapp/broadcast", body, admin); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+	h.WhatsApp.SetLoggedIn(false)
+	if resp := h.Request("POST", "/api/v1/whatsapp/broadcast", map[string]interface{}{"message": "Libur", "all_sales": true}, admin); resp.ErrorCode() != response.CodeWhatsAppNotReady {
+		t.Fatalf("logged out: got %d: %s", resp.Status, resp.Raw)
+	}
+	h.WhatsApp.SetLoggedIn(true)
+
+	// Announcement groups the account cannot post in are left out of all_groups
+	resp = h.Request("POST", "/api/v1/whatsapp/broadcast", map[string]interface{}{
+		"message": "Gudang libur besok", "all_groups": true, "group_jids": []string{"1203-ops@g.us"}, "all_sales": true,
+	}, admin)
+	if resp.Status != 202 {
+		t.Fatalf("broadcast: got %d: %s", resp.Status, resp.Raw)
+	}
+	id, _ := primitive.ObjectIDFromHex(resp.Data()["id"].(string))
+	broadcast := &models.Broadcast{}
+	h.Eventually(func() bool {
+		database.GetMongoCollection("whatsapp_broadcasts").FindOne(context.Background(), bson.M{"_id": id}).Decode(broadcast)
+		return broadcast.Status == models.BroadcastStatusDone
+	}, "broadcast did not finish")
+
+	statuses := map[string]string{}
+	for _, target := range broadcast.Targets {
+		statuses[target.Name] = target.Status
+	}
+	if len(statuses) != 4 || statuses["Board"] != models.BroadcastTargetSent || statuses["Ops"] != models.BroadcastTargetSent ||
+		statuses["Budi"] != models.BroadcastTargetSent || statuses["Sari"] != models.BroadcastTargetSkipped {
+		t.Fatalf("targets = %v", statuses)
+	}
+	if broadcast.Sent != 3 || broadcast.Failed != 0 || broadcast.FinishedAt == nil {
+		t.Fatalf("broadcast = %+v", broadcast)
+	}
+	for _, to := range []string{"1203-ops@g.us", "1203-board@g.us", sales.Phone} {
+		if messages := h.WhatsApp.MessagesTo(to); len(messages) != 1 || messages[0].Text != "Gudang libur besok" {
+			t.Fatalf("messages to %s = %v", to, messages)
+		}
+	}
+	if n := len(h.WhatsApp.Messages()); n != 3 {
+		t.Fatalf("%d messages sent", n)
+	}
+
+	h.WhatsApp.Fail(errors.New("rate limited"))
+	resp = h.Request("POST", "/api/v1/whatsapp/broadcast", map[string]interface{}{"message": "Ulang", "group_jids": []string{"1203-ops@g.us"}}, admin)
+	failedID := resp.Data()["id"].(string)
+	h.Eventually(func() bool {
+		resp := h.Request("GET", "/api/v1/whatsapp/broadcasts/"+failedID, nil, admin)
+		return resp.Data()["status"] == models.BroadcastStatusDone
+	}, "failing broadcast did not finish")
+	resp = h.Request("GET", "/api/v1/whatsapp/broadcasts/"+failedID, nil, admin)
+	target := resp.Data()["targets"].([]interface{})[0].(map[string]interface{})
+	if resp.Data()["failed"] != 1.0 || target["status"] != models.BroadcastTargetFailed || target["error"] != "rate limited" {
+		t.Fatalf("failed broadcast: %s", resp.Raw)
+	}
+
+	resp = h.Request("GET", "/api/v1/whatsapp/broadcasts", nil, admin)
+	if list, _ := resp.Body["data"].([]interface{}); len(list) != 2 || list[0].(map[string]interface{})["id"] != failedID {
+		t.Fatalf("list: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	soon := time.Now().Add(time.Hour)
+
+	for _, body := range []map[string]interface{}{
+		{"phone": "081234567890", "message": " ", "send_at": soon},
+		{"phone": "abc", "message": "Halo", "send_at": soon},
+		{"phone": "081234567890", "message": "Halo", "send_at": time.Now().Add(-time.Minute)},
+		{"phone": "081234567890", "message": "Halo", "send_at": time.Now().Add(91 * 24 * time.Hour)},
+	} {
+		if resp := h.Request("POST", "/api/v1/whatsapp/schedule", body, admin); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+
+	schedule := func(phone string, message string) string {
+		t.Helper()
+		resp := h.Request("POST", "/api/v1/whatsapp/schedule", map[string]interface{}{"phone": phone, "message": message, "send_at": soon}, admin)
+		if resp.Status != 201 || resp.Data()["status"] != models.ScheduledStatusScheduled {
+			t.Fatalf("schedule: got %d: %s", resp.Status, resp.Raw)
+		}
+		return resp.Data()["id"].(string)
+	}
+	due := schedule("0812-3456-7890", "Pengingat pembayaran")
+	schedule("081234567890", "Nanti saja")
+	cancelled := schedule("081111111111", "Batal")
+
+	if resp := h.Request("DELETE", "/api/v1/whatsapp/schedule/"+cancelled, nil, admin); resp.Status != 200 {
+		t.Fatalf("cancel: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("DELETE", "/api/v1/whatsapp/schedule/"+cancelled, nil, admin); resp.Status != 409 || resp.ErrorCode() != response.CodeConflict {
+		t.Fatalf("cancel twice: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("DELETE", "/api/v1/whatsapp/schedule/"+primitive.NewObjectID().Hex(), nil, admin); resp.ErrorCode() != response.CodeNotFound {
+		t.Fatalf("cancel missing: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A run that died after delivering leaves the message claimed; the next run only marks it sent
+	scheduled := database.GetMongoCollection("scheduled_messages")
+	dueID, _ := primitive.ObjectIDFromHex(due)
+	scheduled.UpdateOne(context.Background(), bson.M{"_id": dueID}, bson.M{"$set": bson.M{"send_at": time.Now().Add(-time.Minute)}})
+	claimed := models.NewScheduledMessage()
+	claimed.Phone, claimed.Message, claimed.Status = "6282222222222", "Sudah terkirim", models.ScheduledStatusSending
+	claimed.SendAt = time.Now().Add(-time.Hour)
+	h.Insert("scheduled_messages", claimed)
+	h.Insert("notifications", bson.M{"_id": claimed.ID, "phone": claimed.Phone, "message": claimed.Message, "created_at": claimed.SendAt})
+
+	notification.SendScheduled()
+
+	if messages := h.WhatsApp.Messages(); len(messages) != 1 || messages[0].Phone != "6281234567890" || !strings.Contains(messages[0].Text, "Pengingat pembayaran") {
+		t.Fatalf("messages = %v", messages)
+	}
+	if n := h.Count("scheduled_messages", bson.M{"status": models.ScheduledStatusSent}); n != 2 {
+		t.Fatalf("%d messages sent", n)
+	}
+	if n := h.Count("notifications", bson.M{"_id": dueID, "type": string(notification.NotificationTypeScheduled)}); n != 1 {
+		t.Fatalf("%d notifications of the scheduled message", n)
+	}
+
+	resp := h.Request("GET", "/api/v1/whatsapp/schedule?status=scheduled&phone=0812-3456-7890", nil, admin)
+	if list, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(list) != 1 || list[0].(map[string]interface{})["message"] != "Nanti saja" {
+		t.Fatalf("list: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestNotificationOptOut(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	phone := "6281234567890"
+
+	if resp := h.Request("POST", "/api/v1/notifications/opt-outs", map[string]interface{}{"phone": "123"}, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("invalid phone: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/notifications/opt-outs", map[string]interface{}{"phone": phone}, h.Token(models.RoleUser)); resp.Status != 403 {
+		t.Fatalf("user: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Every format of the number maps to one entry
+	resp := h.Request("POST", "/api/v1/notifications/opt-outs", map[string]interface{}{"phone": "0812-3456-7890", "reason": " asked by phone "}, admin)
+	if resp.Status != 201 || resp.Data()["phone"] != phone || resp.Data()["source"] != models.OptOutSourceAdmin || resp.Data()["reason"] != "asked by phone" {
+		t.Fatalf("opt out: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Messages to the phone are saved as suppressed instead of sent
+	link, err := notification.SendInviteNotification("+62 812 3456 7890", "Undangan")
+	if err != nil || link != "" || len(h.WhatsApp.MessagesTo("+62 812 3456 7890")) != 0 {
+		t.Fatalf("suppressed send: link %q, err %v, messages %v", link, err, h.WhatsApp.Messages())
+	}
+	resp = h.Request("GET", "/api/v1/notifications/suppressed", nil, admin)
+	if list, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(list) != 1 || list[0].(map[string]interface{})["type"] != string(notification.NotificationTypeInvite) {
+		t.Fatalf("suppressed: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/notifications/stats", nil, admin); resp.Data()["suppressed"] != 1.0 {
+		t.Fatalf("stats = %v", resp.Data())
+	}
+
+	// A retry due after the phone opted out is suppressed too
+	due := time.Now().Add(-time.Minute)
+	retry := notification.Notification{
+		ID: primitive.NewObjectID(), Type: notification.NotificationTypeInvoice, Phone: phone,
+		Message: "Retry", Status: notification.StatusPending, Attempts: 1, NextAttemptAt: &due, CreatedAt: due,
+	}
+	h.Insert("notifications", retry)
+	notification.RetryDue()
+	stored := &notification.Notification{}
+	h.Find("notifications", bson.M{"_id": retry.ID}, stored)
+	if stored.Status != notification.StatusSuppressed || stored.NextAttemptAt != nil || len(h.WhatsApp.Messages()) != 0 {
+		t.Fatalf("retry = %+v, messages %v", stored, h.WhatsApp.Messages())
+	}
+
+	resp = h.Request("GET", "/api/v1/notifications/opt-outs?search=3456", nil, admin)
+	list, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(list) != 1 || list[0].(map[string]interface{})["suppressed"] != 1.0 || list[0].(map[string]interface{})["last_suppressed_at"] == nil {
+		t.Fatalf("opt-outs: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// START opts back in and confirms; other replies change nothing
+	notification.HandleInbound(phone, " start ")
+	if messages := h.WhatsApp.MessagesTo(phone); len(messages) != 1 || !strings.Contains(messages[0].Text, "Balas STOP") || h.Count(notification.OptOutsCollection, bson.M{}) != 0 {
+		t.Fatalf("start: messages %v", messages)
+	}
+	notification.HandleInbound(phone, "START")
+	notification.HandleInbound(phone, "stop please")
+	if len(h.WhatsApp.MessagesTo(phone)) != 1 || h.Count(notification.OptOutsCollection, bson.M{}) != 0 {
+		t.Fatalf("repeated start: messages %v", h.WhatsApp.Messages())
+	}
+	notification.HandleInbound(phone, "Berhenti")
+	optOut := &models.NotificationOptOut{}
+	h.Find(notification.OptOutsCollection, bson.M{"phone": phone}, optOut)
+	if optOut.Source != models.OptOutSourceInbound || optOut.Reason != "Replied BERHENTI" || len(h.WhatsApp.MessagesTo(phone)) != 2 {
+		t.Fatalf("stop: opt-out %+v, messages %v", optOut, h.WhatsApp.Messages())
+	}
+
+	if resp := h.Request("DELETE", "/api/v1/notifications/opt-outs/0812-3456-7890", nil, admin); resp.Status != 200 || resp.ErrorCode() != "" {
+		t.Fatalf("remove: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("DELETE", "/api/v1/notifications/opt-outs/0812-3456-7890", nil, admin); resp.ErrorCode() != response.CodeNotFound {
+		t.Fatalf("remove twice: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if link, err := notification.SendInviteNotification(phone, "Undangan"); err != nil || link == "" || len(h.WhatsApp.MessagesTo(phone)) != 3 {
+		t.Fatalf("send after opt in: link %q, err %v", link, err)
+	}
+}
+
+func TestShortLinks(t *testing.T) {
+	h := testutil.New(t)
+
+	saved := config.Cfg.ShortLink
+	config.Cfg.ShortLink = config.ShortLinkConfig{BaseURL: "https://lln.id", TTL: time.Hour}
+	t.Cleanup(func() { config.Cfg.ShortLink = saved })
+
+	shortURL := regexp.MustCompile(`https://lln\.id/s/([0-9A-Za-z]{7})`)
+	target := config.Cfg.Client.URL + "/order/token-1"
+
+	// Messages about the same order share one link
+	first := notification.InvoiceNotification("6281234567890", "Budi", "ORD-1", "Semen", 10, "sak", 500000, "token-1")
+	match := shortURL.FindStringSubmatch(first.Message)
+	if match == nil || strings.Contains(first.Message, target) {
+		t.Fatalf("invoice message = %q", first.Message)
+	}
+	code := match[1]
+	link := &models.ShortLink{}
+	h.Find(shortlink.Collection, bson.M{"_id": code}, link)
+	expiresAt := link.ExpiresAt
+	time.Sleep(2 * time.Millisecond)
+	again := notification.QueueNotification("6281234567890", "Budi", "ORD-1", 3, "10:00", "token-1")
+	if !strings.Contains(again.Message, "https://lln.id/s/"+code) || h.Count(shortlink.Collection, bson.M{}) != 1 {
+		t.Fatalf("queue message = %q", again.Message)
+	}
+	link = &models.ShortLink{}
+	h.Find(shortlink.Collection, bson.M{"_id": code}, link)
+	if link.Target != target || !link.ExpiresAt.After(expiresAt) {
+		t.Fatalf("reused link = %+v, expiry was %v", link, expiresAt)
+	}
+
+	// Opening the link redirects to the client page and counts the hit
+	resp, err := h.App.Test(httptest.NewRequest("GET", "/s/"+code, nil), -1)
+	if err != nil || resp.StatusCode != 302 || resp.Header.Get("Location") != target {
+		t.Fatalf("redirect: %v %v", resp, err)
+	}
+	link = &models.ShortLink{}
+	h.Find(shortlink.Collection, bson.M{"_id": code}, link)
+	if link.Hits != 1 || link.LastHitAt == nil {
+		t.Fatalf("hit link = %+v", link)
+	}
+
+	h.Insert(shortlink.Collection, &models.ShortLink{Code: "expired", Target: target, ExpiresAt: time.Now().Add(-time.Minute), CreatedAt: time.Now().Add(-time.Hour)})
+	if resp := h.Request("GET", "/s/expired", nil, ""); resp.Status != 410 || resp.ErrorCode() != response.CodeShortLinkExpired {
+		t.Fatalf("expired: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/s/missing", nil, ""); resp.Status != 404 || resp.ErrorCode() != response.CodeShortLinkNotFound {
+		t.Fatalf("missing: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// An expired link to the target is not reused
+	other := config.Cfg.Client.URL + "/delivery/token-2"
+	h.Insert(shortlink.Collection, &models.ShortLink{Code: "old0001", Target: other, ExpiresAt: time.Now().Add(-time.Minute)})
+	if short := shortlink.Link(other); !shortURL.MatchString(short) || strings.HasSuffix(short, "old0001") {
+		t.Fatalf("link after expiry = %q", short)
+	}
+
+	// Without a base URL messages keep the long link
+	config.Cfg.ShortLink.BaseURL = ""
+	if long := notification.InvoiceNotification("6281234567890", "Budi", "ORD-2", "Semen", 10, "sak", 500000, "token-3"); !strings.Contains(long.Message, config.Cfg.Client.URL+"/order/token-3") {
+		t.Fatalf("disabled message = %q", long.Message)
+	}
+	if shortlink.Link("") != "" {
+		t.Fatal("an empty target must stay empty")
+	}
+}
+
+func TestUploadCategories(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	superadmin := h.Token(models.RoleSuperAdmin)
+
+	saved := config.Cfg.Upload.OrphanGrace
+	config.Cfg.Upload.OrphanGrace = time.Hour
+	t.Cleanup(func() { config.Cfg.Upload.OrphanGrace = saved })
+
+	resp := h.Request("GET", "/api/v1/uploads/categories", nil, admin)
+	if categories, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(categories) != len(file.Categories) {
+		t.Fatalf("categories: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Each upload goes to the folder of its category
+	order := seedOrder(h, seedSales(h), nil)
+	if resp := h.Upload("/api/v1/client/payment/"+order.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), ""); resp.Status != 200 {
+		t.Fatalf("payment proof: status = %d: %s", resp.Status, resp.Raw)
+	}
+	product := models.NewProduct()
+	product.Name = "Semen"
+	h.Insert("products", product)
+	if resp := h.Upload("/api/v1/products/"+product.ID.Hex()+"/image", "image", "semen.png", []byte("png"), admin); resp.Status != 200 {
+		t.Fatalf("product image: status = %d: %s", resp.Status, resp.Raw)
+	}
+	uploads := h.Storage.Uploads()
+	if len(uploads) != 2 || !strings.HasPrefix(uploads[0], file.CategoryPaymentProof+"/") || !strings.HasPrefix(uploads[1], file.CategoryProductImage+"/") {
+		t.Fatalf("uploads = %v", uploads)
+	}
+
+	// Listing is by category, one CDN page at a time
+	old := time.Now().Add(-2 * time.Hour)
+	h.Storage.AddAsset(cloudinary.Asset{PublicID: "payment-proofs/stray", ResourceType: "image", Tags: []string{file.CategoryPaymentProof}, CreatedAt: old})
+	h.Storage.AddAsset(cloudinary.Asset{PublicID: "attachments/stray.pdf", ResourceType: "raw", Tags: []string{file.CategoryAttachment}, CreatedAt: old})
+	h.Storage.AddAsset(cloudinary.Asset{PublicID: "payment-proofs/just-uploaded", ResourceType: "image", Tags: []string{file.CategoryPaymentProof}, CreatedAt: time.Now()})
+	resp = h.Request("GET", "/api/v1/uploads?category=payment-proofs&limit=2", nil, admin)
+	assets, _ := resp.Data()["assets"].([]interface{})
+	if resp.Status != 200 || len(assets) != 2 || assets[0].(map[string]interface{})["public_id"] != uploads[0] || resp.Data()["next_cursor"] != "2" {
+		t.Fatalf("list: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("GET", "/api/v1/uploads?category=payment-proofs&limit=2&cursor=2", nil, admin)
+	if assets, _ := resp.Data()["assets"].([]interface{}); len(assets) != 1 || resp.Data()["next_cursor"] != "" {
+		t.Fatalf("second page: %s", resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/uploads?category=selfies", nil, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unknown category: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Only old files no document references are orphans; a dry run deletes nothing
+	if resp := h.Request("POST", "/api/v1/uploads/orphans/cleanup", nil, admin); resp.Status != 403 {
+		t.Fatalf("admin cleanup: status = %d", resp.Status)
+	}
+	resp = h.Request("POST", "/api/v1/uploads/orphans/cleanup", nil, superadmin)
+	orphans, _ := resp.Data()["orphans"].([]interface{})
+	if resp.Status != 200 || resp.Data()["dry_run"] != true || resp.Data()["scanned"] != 5.0 || resp.Data()["referenced"] != 2.0 || len(orphans) != 2 || len(h.Storage.Destroyed()) != 0 {
+		t.Fatalf("dry run: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/uploads/orphans/cleanup?dry_run=false", nil, superadmin)
+	if destroyed := h.Storage.Destroyed(); resp.Data()["deleted"] != 2.0 || len(destroyed) != 2 || destroyed[0] != "payment-proofs/stray" || destroyed[1] != "attachments/stray.pdf" {
+		t.Fatalf("cleanup: %s, destroyed %v", resp.Raw, h.Storage.Destroyed())
+	}
+	if h.Count("audit_logs", bson.M{"action": audit.ActionUploadCleanup}) != 1 {
+		t.Fatal("the cleanup was not audited")
+	}
+	if resp := h.Request("POST", "/api/v1/uploads/orphans/cleanup", nil, superadmin); len(resp.Data()["orphans"].([]interface{})) != 0 {
+		t.Fatalf("after cleanup: %s", resp.Raw)
+	}
+
+	h.Storage.Fail(breaker.ErrOpen)
+	if resp := h.Request("GET", "/api/v1/uploads?category=payment-proofs", nil, admin); resp.Status != 503 {
+		t.Fatalf("shed list: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	h := testutil.New(t)
+
+	hashed, err := crypt.HashPassword("old-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.NewUser()
+	user.Username = "budi"
+	user.DisplayName = "Budi"
+	user.Password = hashed
+	user.Role = models.RoleUser
+	user.IsActive = true
+	h.Insert("users", user)
+	token := h.TokenFor(user.ID.Hex(), models.RoleUser)
+	otherDevice := h.TokenFor(user.ID.Hex(), models.RoleUser)
+
+	for _, body := range []map[string]interface{}{{"display_name": "  "}, {"email": "budi.example.com"}} {
+		if resp := h.Request("PUT", "/api/v1/auth/me", body, token); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: status = %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+	if resp := h.Request("PUT", "/api/v1/auth/me", map[string]interface{}{"display_name": " Budi Santoso ", "email": "budi@example.com"}, token); resp.Status != 200 {
+		t.Fatalf("update: status = %d: %s", resp.Status, resp.Raw)
+	}
+	stored := &models.User{}
+	h.Find("users", bson.M{"_id": user.ID}, stored)
+	if stored.DisplayName != "Budi Santoso" || stored.Email != "budi@example.com" || h.Count("audit_logs", bson.M{"action": audit.ActionProfileUpdate}) != 1 {
+		t.Fatalf("updated user = %+v", stored)
+	}
+
+	// A new password needs the current one and signs out the other sessions
+	for _, tc := range []struct {
+		body map[string]interface{}
+		code response.Code
+	}{
+		{map[string]interface{}{"current_password": "wrong", "new_password": "new-secret"}, response.CodeInvalidCredentials},
+		{map[string]interface{}{"current_password": "old-secret", "new_password": "short"}, response.CodeValidationFailed},
+	} {
+		if resp := h.Request("PUT", "/api/v1/auth/me", tc.body, token); resp.Status != 400 || resp.ErrorCode() != tc.code {
+			t.Fatalf("%v: status = %d: %s", tc.body, resp.Status, resp.Raw)
+		}
+	}
+	if resp := h.Request("PUT", "/api/v1/auth/me", map[string]interface{}{"current_password": "old-secret", "new_password": "new-secret"}, token); resp.Status != 200 {
+		t.Fatalf("password: status = %d: %s", resp.Status, resp.Raw)
+	}
+	stored = &models.User{}
+	h.Find("users", bson.M{"_id": user.ID}, stored)
+	if !crypt.CheckPassword("new-secret", stored.Password) {
+		t.Fatal("the new password was not saved")
+	}
+	if resp := h.Request("GET", "/api/v1/auth/sessions", nil, otherDevice); resp.ErrorCode() != response.CodeSessionRevoked {
+		t.Fatalf("other session after the change: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	if resp := h.Request("GET", "/api/v1/auth/me", nil, token); resp.Status != 200 || resp.Body["display_name"] != "Budi Santoso" {
+		t.Fatalf("own session after the change: got %d: %s", resp.Status, resp.Raw)
+	}
+	var entry models.AuditLog
+	h.Find("audit_logs", bson.M{"action": audit.ActionPasswordChange}, &entry)
+	if entry.EntityID != user.ID.Hex() || entry.Details["revoked_sessions"] != int64(1) {
+		t.Fatalf("password audit = %+v", entry)
+	}
+
+	// An impersonating superadmin can edit the profile but not take over the account
+	resp := h.Request("POST", "/api/v1/auth/impersonate/"+user.ID.Hex(), nil, h.Token(models.RoleSuperAdmin))
+	impersonation, _ := resp.Body["access_token"].(string)
+	if resp := h.Request("PUT", "/api/v1/auth/me", map[string]interface{}{"current_password": "new-secret", "new_password": "taken-over"}, impersonation); resp.Status != 403 || resp.ErrorCode() != response.CodeForbidden {
+		t.Fatalf("impersonated password change: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp := h.Request("PUT", "/api/v1/auth/me", map[string]interface{}{"display_name": "Ghost"}, h.TokenFor(primitive.NewObjectID().Hex(), models.RoleUser)); resp.Status != 404 || resp.ErrorCode() != response.CodeUserNotFound {
+		t.Fatalf("missing user: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestProfileAvatar(t *testing.T) {
+	h := testutil.New(t)
+	user := models.NewUser()
+	user.Username = "budi"
+	user.Role = models.RoleUser
+	user.IsActive = true
+	h.Insert("users", user)
+	token := h.TokenFor(user.ID.Hex(), models.RoleUser)
+
+	if resp := h.Upload("/api/v1/auth/me/avatar", "avatar", "me.exe", []byte("MZ"), token); resp.Status != 400 || resp.ErrorCode() != response.CodeFileTypeNotAllowed {
+		t.Fatalf("exe: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Upload("/api/v1/auth/me/avatar", "photo", "me.png", []byte("png"), token); resp.Status != 400 || resp.ErrorCode() != response.CodeFileRequired {
+		t.Fatalf("wrong field: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp := h.Upload("/api/v1/auth/me/avatar", "avatar", "me.png", []byte("png"), token)
+	first, _ := resp.Data()["public_id"].(string)
+	if resp.Status != 200 || !strings.HasPrefix(first, file.CategoryAvatar+"/") {
+		t.Fatalf("upload: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/auth/me", nil, token); resp.Body["avatar"] == nil {
+		t.Fatalf("me without the avatar: %s", resp.Raw)
+	}
+
+	// A new picture replaces the old one, which is then deleted
+	resp = h.Upload("/api/v1/auth/me/avatar", "avatar", "me2.png", []byte("png2"), token)
+	second, _ := resp.Data()["public_id"].(string)
+	if resp.Status != 200 || second == first {
+		t.Fatalf("replace: status = %d: %s", resp.Status, resp.Raw)
+	}
+	h.Eventually(func() bool {
+		destroyed := h.Storage.Destroyed()
+		return len(destroyed) == 1 && destroyed[0] == first
+	}, "the old avatar was not deleted")
+
+	if resp := h.Request("DELETE", "/api/v1/auth/me/avatar", nil, token); resp.Status != 200 || resp.Body["message"] != "Avatar removed" {
+		t.Fatalf("delete: status = %d: %s", resp.Status, resp.Raw)
+	}
+	h.Eventually(func() bool { return len(h.Storage.Destroyed()) == 2 }, "the removed avatar was not deleted")
+	stored := &models.User{}
+	h.Find("users", bson.M{"_id": user.ID}, stored)
+	if stored.Avatar != nil {
+		t.Fatalf("avatar after delete = %+v", stored.Avatar)
+	}
+	if resp := h.Request("DELETE", "/api/v1/auth/me/avatar", nil, token); resp.Status != 200 || resp.Body["message"] != "No avatar to remove" {
+		t.Fatalf("delete twice: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
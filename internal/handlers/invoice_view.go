@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bg-go/internal/database"
+	"bg-go/internal/lib/invoiceview"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxInvoiceViews limits the access log returned for one order
+const maxInvoiceViews = 100
+
+// InvoiceViews returns when and from where the order's invoice link was opened
+func (h *OrderHandler) InvoiceViews(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	views, err := invoiceview.List(ctx, objID.Hex(), maxInvoiceViews)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch invoice views")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"first_viewed_at": order.InvoiceFirstViewedAt,
+		"last_viewed_at":  order.InvoiceLastViewedAt,
+		"view_count":      order.InvoiceViewCount,
+		"views":           views,
+	})
+}
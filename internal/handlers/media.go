@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MediaHandler serves sensitive images behind signed, expiring links
+type MediaHandler struct{}
+
+// NewMediaHandler creates a new media handler
+func NewMediaHandler() *MediaHandler {
+	return &MediaHandler{}
+}
+
+// OrderImage streams a payment proof or vehicle photo, or one of its variants, from the
+// CDN. The signature is the authorization, so the link works in an img tag without a token.
+func (h *MediaHandler) OrderImage(c *fiber.Ctx) error {
+	id := c.Params("id")
+	kind := c.Params("kind")
+	if !media.IsKind(kind) {
+		return response.ErrorCode(c, 403, response.CodeMediaLinkInvalid, "Invalid image link")
+	}
+
+	variant := c.Query("variant")
+	expiresAt, err := media.Verify(id, kind, variant, c.Query("expires"), c.Query("sig"))
+	if err == media.ErrExpired {
+		return response.ErrorCode(c, 403, response.CodeMediaLinkExpired, "Image link has expired")
+	}
+	if err != nil {
+		return response.ErrorCode(c, 403, response.CodeMediaLinkInvalid, "Invalid image link")
+	}
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	err = database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"payment_proof": 1, "vehicle_photo": 1})).Decode(order)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	source := media.SourceURL(media.Source(order, kind), variant)
+	if source == "" {
+		return response.NotFound(c, "Image not found")
+	}
+
+	upstream, err := media.Fetch(source)
+	if err != nil {
+		log.Printf("[Media] Failed to fetch %s of order %s: %v", kind, id, err)
+		return response.Error(c, 502, "Failed to load image")
+	}
+
+	// Browsers may keep the image only as long as the link works
+	maxAge := int(time.Until(expiresAt).Seconds())
+	c.Set("Content-Type", upstream.Header.Get("Content-Type"))
+	c.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	c.Set("X-Content-Type-Options", "nosniff")
+	return c.Status(200).SendStream(upstream.Body, int(upstream.ContentLength))
+}
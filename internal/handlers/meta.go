@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"bg-go/internal/config"
+	"bg-go/internal/lib/buildinfo"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MetaHandler handles the build and environment information
+type MetaHandler struct{}
+
+// NewMetaHandler creates a new meta handler
+func NewMetaHandler() *MetaHandler {
+	return &MetaHandler{}
+}
+
+// Get returns the running build, its enabled feature flags and the environment with the
+// banner the frontend shows for it, so support can tell which build is deployed where
+func (h *MetaHandler) Get(c *fiber.Ctx) error {
+	cfg := config.Cfg.App
+	banner := fiber.Map(nil)
+	if cfg.BannerLabel != "" {
+		banner = fiber.Map{"label": cfg.BannerLabel, "color": cfg.BannerColor}
+	}
+	return response.Success(c, 200, fiber.Map{
+		"name":        cfg.Name,
+		"environment": cfg.Env,
+		"banner":      banner,
+		"build":       buildinfo.Get(),
+	})
+}
@@ -3,14 +3,15 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
 	"bg-go/internal/lib/response"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // MigrationHandler handles data migration/cleanup
@@ -21,37 +22,139 @@ func NewMigrationHandler() *MigrationHandler {
 	return &MigrationHandler{}
 }
 
-// CleanupOrders cleans up old/obsolete fields from orders
+// legacyOrderFields are the obsolete order fields CleanupOrders removes:
+// - product_id (legacy)
+// - unit_price (legacy, now in items)
+// - invoice_url (redundant, we have invoice_token)
+// - payment_rejected_by (not used)
+var legacyOrderFields = []string{
+	"product_id",
+	"unit_price",
+	"invoice_url",
+	"payment_rejected_by",
+}
+
+// resetCollections are the collections ResetOrders drops
+var resetCollections = []string{"orders", "delivery_notes", "notifications", "products"}
+
+// cleanOrderItems keeps only the relevant fields of order items
+func cleanOrderItems(items []bson.M) []bson.M {
+	cleanItems := make([]bson.M, len(items))
+	for i, item := range items {
+		cleanItem := bson.M{
+			"product_name": item["product_name"],
+			"unit_price":   item["unit_price"],
+			"quantity":     item["quantity"],
+			"unit":         item["unit"],
+			"subtotal":     item["subtotal"],
+		}
+		// Set default unit if missing
+		if cleanItem["unit"] == nil || cleanItem["unit"] == "" {
+			cleanItem["unit"] = "pcs"
+		}
+		cleanItems[i] = cleanItem
+	}
+	return cleanItems
+}
+
+// dropOperation plans dropping a collection
+func dropOperation(ctx context.Context, name string) MigrationOperation {
+	count, _ := database.GetMongoCollection(name).CountDocuments(ctx, bson.M{})
+	return MigrationOperation{Operation: operationDrop, Collection: name, Documents: count}
+}
+
+// cleanupPlan reports what CleanupOrders changes, with sample documents before the change
+func cleanupPlan(ctx context.Context) ([]MigrationOperation, error) {
+	orderCollection := database.GetMongoCollection("orders")
+
+	// 1. Drop products collection (no longer used)
+	plan := []MigrationOperation{dropOperation(ctx, "products")}
+
+	// 2. Orders having an obsolete field
+	legacy := []bson.M{}
+	projection := bson.M{"order_number": 1}
+	for _, field := range legacyOrderFields {
+		legacy = append(legacy, bson.M{field: bson.M{"$exists": true}})
+		projection[field] = 1
+	}
+	unset := MigrationOperation{Operation: operationUnsetFields, Collection: "orders", Fields: legacyOrderFields}
+	count, err := orderCollection.CountDocuments(ctx, bson.M{"$or": legacy})
+	if err != nil {
+		return nil, err
+	}
+	unset.Documents = count
+	cursor, err := orderCollection.Find(ctx, bson.M{"$or": legacy},
+		options.Find().SetProjection(projection).SetLimit(migrationSampleSize))
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &unset.Sample)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan = append(plan, unset)
+
+	// 3. Orders whose items are rewritten, sampled with the items they get
+	rewrite := MigrationOperation{Operation: operationRewriteItems, Collection: "orders", Fields: []string{"items"}}
+	cursor, err = orderCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"order_number": 1, "items": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var order struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			OrderNumber string             `bson:"order_number"`
+			Items       []bson.M           `bson:"items"`
+		}
+		cursor.Decode(&order)
+		if len(order.Items) == 0 {
+			continue
+		}
+		rewrite.Documents++
+		if len(rewrite.Sample) < migrationSampleSize {
+			rewrite.Sample = append(rewrite.Sample, bson.M{
+				"_id":          order.ID,
+				"order_number": order.OrderNumber,
+				"items":        order.Items,
+				"items_after":  cleanOrderItems(order.Items),
+			})
+		}
+	}
+	return append(plan, rewrite), nil
+}
+
+// CleanupOrders cleans up old/obsolete fields from orders. dry_run=true reports what
+// would change and a confirm token; the cleanup itself needs that token in confirm.
 func (h *MigrationHandler) CleanupOrders(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := requestContext(c, reportTimeout)
 	defer cancel()
 
+	plan, err := cleanupPlan(ctx)
+	if err != nil {
+		return response.Error(c, 500, fmt.Sprintf("Failed to plan cleanup: %v", err))
+	}
+	if c.QueryBool("dry_run", false) {
+		return previewMigration(c, migrationCleanupOrders, plan)
+	}
+	if !checkConfirmation(c, migrationCleanupOrders, plan) {
+		return nil
+	}
+
 	orderCollection := database.GetMongoCollection("orders")
 	productCollection := database.GetMongoCollection("products")
 
 	// 1. Drop products collection (no longer used)
-	err := productCollection.Drop(ctx)
-	if err != nil {
-		fmt.Printf("Warning: Could not drop products collection: %v\n", err)
+	dropErr := productCollection.Drop(ctx)
+	if dropErr != nil {
+		fmt.Printf("Warning: Could not drop products collection: %v\n", dropErr)
 	}
 
 	// 2. Clean up orders - remove obsolete fields
-	// Fields to unset:
-	// - product_id (legacy)
-	// - unit_price (legacy, now in items)
-	// - invoice_url (redundant, we have invoice_token)
-	// - payment_verified_by (not used)
-	// - payment_rejected_by (not used)
-	// - loading_started_at (not used)
-	// - loading_finished_at (not used)
-	unsetFields := bson.M{
-		"product_id":          "",
-		"unit_price":          "",
-		"invoice_url":         "",
-		"payment_verified_by": "",
-		"payment_rejected_by": "",
-		"loading_started_at":  "",
-		"loading_finished_at": "",
+	unsetFields := bson.M{}
+	for _, field := range legacyOrderFields {
+		unsetFields[field] = ""
 	}
 
 	// Update all orders
@@ -81,29 +184,11 @@ func (h *MigrationHandler) CleanupOrders(c *fiber.Ctx) error {
 		cursor.Decode(&order)
 
 		if len(order.Items) > 0 {
-			// Clean up each item
-			cleanItems := make([]bson.M, len(order.Items))
-			for i, item := range order.Items {
-				// Keep only relevant fields
-				cleanItem := bson.M{
-					"product_name": item["product_name"],
-					"unit_price":   item["unit_price"],
-					"quantity":     item["quantity"],
-					"unit":         item["unit"],
-					"subtotal":     item["subtotal"],
-				}
-				// Set default unit if missing
-				if cleanItem["unit"] == nil || cleanItem["unit"] == "" {
-					cleanItem["unit"] = "pcs"
-				}
-				cleanItems[i] = cleanItem
-			}
-
 			// Update order
 			_, err := orderCollection.UpdateByID(
 				ctx,
 				order.ID,
-				bson.M{"$set": bson.M{"items": cleanItems}},
+				bson.M{"$set": bson.M{"items": cleanOrderItems(order.Items)}},
 			)
 			if err == nil {
 				ordersUpdated++
@@ -111,49 +196,57 @@ func (h *MigrationHandler) CleanupOrders(c *fiber.Ctx) error {
 		}
 	}
 
+	audit.Log(c, audit.ActionMigrationRun, "migration", migrationCleanupOrders, map[string]interface{}{
+		"operations": planFingerprint(plan),
+	})
+
 	return response.Success(c, 200, fiber.Map{
-		"message":               "Cleanup completed",
-		"orders_modified":        result.ModifiedCount,
-		"orders_items_cleaned":  ordersUpdated,
-		"products_dropped":      err == nil,
+		"message":              "Cleanup completed",
+		"orders_modified":      result.ModifiedCount,
+		"orders_items_cleaned": ordersUpdated,
+		"products_dropped":     dropErr == nil,
 	})
 }
 
-// ResetOrders drops all orders and related collections (for fresh start)
+// ResetOrders drops all orders and related collections (for fresh start). dry_run=true
+// reports the documents each drop removes and a confirm token; the reset needs it in confirm.
 func (h *MigrationHandler) ResetOrders(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := requestContext(c, reportTimeout)
 	defer cancel()
 
-	// Drop collections
-	orderCollection := database.GetMongoCollection("orders")
-	deliveryCollection := database.GetMongoCollection("delivery_notes")
-	notificationCollection := database.GetMongoCollection("notifications")
-	productCollection := database.GetMongoCollection("products")
+	plan := []MigrationOperation{}
+	for _, name := range resetCollections {
+		plan = append(plan, dropOperation(ctx, name))
+	}
+	if c.QueryBool("dry_run", false) {
+		return previewMigration(c, migrationResetOrders, plan)
+	}
+	if !checkConfirmation(c, migrationResetOrders, plan) {
+		return nil
+	}
 
-	// Drop orders
-	err1 := orderCollection.Drop(ctx)
-	
-	// Drop delivery notes
-	err2 := deliveryCollection.Drop(ctx)
-	
-	// Drop notifications
-	err3 := notificationCollection.Drop(ctx)
-	
-	// Drop products (no longer needed)
-	err4 := productCollection.Drop(ctx)
+	// Drop orders, delivery notes, notifications and products (no longer needed)
+	dropped := map[string]bool{}
+	for _, name := range resetCollections {
+		dropped[name] = database.GetMongoCollection(name).Drop(ctx) == nil
+	}
+
+	audit.Log(c, audit.ActionMigrationRun, "migration", migrationResetOrders, map[string]interface{}{
+		"operations": planFingerprint(plan),
+	})
 
 	return response.Success(c, 200, fiber.Map{
-		"message":           "Reset completed",
-		"orders_dropped":    err1 == nil,
-		"delivery_dropped":  err2 == nil,
-		"notifications_dropped": err3 == nil,
-		"products_dropped":  err4 == nil,
+		"message":               "Reset completed",
+		"orders_dropped":        dropped["orders"],
+		"delivery_dropped":      dropped["delivery_notes"],
+		"notifications_dropped": dropped["notifications"],
+		"products_dropped":      dropped["products"],
 	})
 }
 
 // GetOrderStats shows current order data statistics
 func (h *MigrationHandler) GetOrderStats(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	orderCollection := database.GetMongoCollection("orders")
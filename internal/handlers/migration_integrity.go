@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Integrity issue kinds
+const (
+	issueDanglingSales        = "dangling_sales"         // Order sales_id points to a missing sales
+	issueDanglingDeliveryNote = "dangling_delivery_note" // Order delivery_note_id points to a missing note
+	issueDanglingProduct      = "dangling_product"       // Legacy product_id points to a missing product
+	issueOrphanedOrderNote    = "orphaned_order_note"    // Order note whose order no longer exists
+	issueOrphanedDeliveryNote = "orphaned_delivery_note" // Delivery note whose order no longer exists
+)
+
+// Integrity repair modes
+const (
+	repairRelink  = "relink"  // Point the reference at the record it most likely meant
+	repairNullify = "nullify" // Clear the dangling reference
+	repairFlag    = "flag"    // Leave the data as is and tag the document in integrity_flags
+)
+
+// Repair outcomes
+const (
+	repairRelinked = "relinked"
+	repairNulled   = "nulled"
+	repairFlagged  = "flagged"
+	repairSkipped  = "skipped" // The mode does not apply, e.g. relink without a candidate
+	repairFailed   = "failed"
+)
+
+// integrityMaxListed caps the issues listed in a response; the summary always counts all of them
+const integrityMaxListed = 1000
+
+// IntegrityIssue is one broken soft reference
+type IntegrityIssue struct {
+	Kind       string `json:"kind"`
+	Collection string `json:"collection"`
+	DocumentID string `json:"document_id"`
+	Field      string `json:"field"`
+	Value      string `json:"value"`
+	Candidate  string `json:"candidate,omitempty"` // Record the relink mode would point to
+	Result     string `json:"result,omitempty"`
+	Message    string `json:"message,omitempty"`
+
+	id primitive.ObjectID
+}
+
+// integrityOrder is the projection of an order needed for the checks
+type integrityOrder struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	SalesID        string             `bson:"sales_id"`
+	DeliveryNoteID string             `bson:"delivery_note_id"`
+	ProductID      string             `bson:"product_id"`
+	Items          []struct {
+		ProductID string `bson:"product_id"`
+	} `bson:"items"`
+}
+
+// integrityNote is the projection of a delivery note needed for the checks
+type integrityNote struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	OrderID    string             `bson:"order_id"`
+	NoteNumber string             `bson:"note_number"`
+	Token      string             `bson:"token"`
+	SalesPhone string             `bson:"sales_phone"`
+	Revision   int                `bson:"revision"`
+	Superseded bool               `bson:"superseded"`
+}
+
+// CheckIntegrity scans orders, archived orders, delivery notes and order notes for
+// soft references that point at records which no longer exist
+func (h *MigrationHandler) CheckIntegrity(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, 120*time.Second)
+	defer cancel()
+
+	issues, err := scanIntegrity(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to scan references")
+	}
+
+	return response.Success(c, 200, integrityReport(issues, "", false))
+}
+
+// RepairIntegrity scans like CheckIntegrity and repairs every issue with the given mode
+// (relink, nullify or flag). With dry_run=true the outcome is reported but nothing is written.
+func (h *MigrationHandler) RepairIntegrity(c *fiber.Ctx) error {
+	mode := c.Query("mode")
+	if mode != repairRelink && mode != repairNullify && mode != repairFlag {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "mode must be relink, nullify or flag")
+	}
+	dryRun := c.QueryBool("dry_run", false)
+
+	ctx, cancel := requestContext(c, 300*time.Second)
+	defer cancel()
+
+	issues, err := scanIntegrity(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to scan references")
+	}
+
+	for i := range issues {
+		repairIssue(ctx, &issues[i], mode, dryRun)
+	}
+
+	report := integrityReport(issues, mode, dryRun)
+	if !dryRun {
+		audit.Log(c, audit.ActionIntegrityRepair, "migration", "", map[string]interface{}{
+			"mode":    mode,
+			"issues":  len(issues),
+			"results": report["results"],
+		})
+	}
+
+	return response.Success(c, 200, report)
+}
+
+func integrityReport(issues []IntegrityIssue, mode string, dryRun bool) fiber.Map {
+	summary := map[string]int{
+		issueDanglingSales:        0,
+		issueDanglingDeliveryNote: 0,
+		issueDanglingProduct:      0,
+		issueOrphanedOrderNote:    0,
+		issueOrphanedDeliveryNote: 0,
+	}
+	results := map[string]int{}
+	for _, issue := range issues {
+		summary[issue.Kind]++
+		if issue.Result != "" {
+			results[issue.Result]++
+		}
+	}
+
+	listed := issues
+	if len(listed) > integrityMaxListed {
+		listed = listed[:integrityMaxListed]
+	}
+
+	report := fiber.Map{
+		"total":     len(issues),
+		"summary":   summary,
+		"issues":    listed,
+		"truncated": len(issues) > len(listed),
+	}
+	if mode != "" {
+		report["mode"] = mode
+		report["dry_run"] = dryRun
+		report["results"] = results
+	}
+	return report
+}
+
+// scanIntegrity loads the referenced IDs once and checks every reference against them
+func scanIntegrity(ctx context.Context) ([]IntegrityIssue, error) {
+	issues := []IntegrityIssue{}
+
+	// Sales by ID, and by normalized phone to find relink candidates
+	salesIDs := map[string]bool{}
+	salesByPhone := map[string]string{}
+	cursor, err := database.GetMongoCollection("sales").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"phone": 1, "merged_into": 1}))
+	if err != nil {
+		return nil, err
+	}
+	for cursor.Next(ctx) {
+		var sales struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			Phone      string             `bson:"phone"`
+			MergedInto string             `bson:"merged_into"`
+		}
+		if cursor.Decode(&sales) != nil {
+			continue
+		}
+		salesIDs[sales.ID.Hex()] = true
+		if phone, err := utils.NormalizePhone(sales.Phone); err == nil && sales.MergedInto == "" {
+			salesByPhone[phone] = sales.ID.Hex()
+		}
+	}
+	cursor.Close(ctx)
+
+	productIDs, err := idSet(ctx, "products")
+	if err != nil {
+		return nil, err
+	}
+
+	// Delivery notes by ID, and the current revision per order for relinking
+	notes := map[string]integrityNote{}
+	currentNote := map[string]integrityNote{}
+	cursor, err = database.GetMongoCollection("delivery_notes").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+		"order_id": 1, "note_number": 1, "token": 1, "sales_phone": 1, "revision": 1, "superseded": 1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	for cursor.Next(ctx) {
+		var note integrityNote
+		if cursor.Decode(&note) != nil {
+			continue
+		}
+		notes[note.ID.Hex()] = note
+		if current, ok := currentNote[note.OrderID]; !note.Superseded && (!ok || note.Revision > current.Revision) {
+			currentNote[note.OrderID] = note
+		}
+	}
+	cursor.Close(ctx)
+
+	orderIDs := map[string]bool{}
+	orderByNote := map[string]string{}
+
+	for _, collection := range []string{"orders", archive.Collection} {
+		cursor, err := database.GetMongoCollection(collection).Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+			"sales_id": 1, "delivery_note_id": 1, "product_id": 1, "items.product_id": 1,
+		}))
+		if err != nil {
+			return nil, err
+		}
+
+		for cursor.Next(ctx) {
+			var order integrityOrder
+			if cursor.Decode(&order) != nil {
+				continue
+			}
+			orderID := order.ID.Hex()
+			orderIDs[orderID] = true
+			if order.DeliveryNoteID != "" {
+				orderByNote[order.DeliveryNoteID] = orderID
+			}
+			issue := func(kind string, field string, value string, candidate string) {
+				issues = append(issues, IntegrityIssue{
+					Kind: kind, Collection: collection, DocumentID: orderID, Field: field, Value: value, Candidate: candidate, id: order.ID,
+				})
+			}
+
+			if order.SalesID != "" && !salesIDs[order.SalesID] {
+				candidate := ""
+				if note, ok := currentNote[orderID]; ok {
+					if phone, err := utils.NormalizePhone(note.SalesPhone); err == nil {
+						candidate = salesByPhone[phone]
+					}
+				}
+				issue(issueDanglingSales, "sales_id", order.SalesID, candidate)
+			}
+
+			if order.DeliveryNoteID != "" {
+				if _, ok := notes[order.DeliveryNoteID]; !ok {
+					issue(issueDanglingDeliveryNote, "delivery_note_id", order.DeliveryNoteID, currentNote[orderID].idHex())
+				}
+			}
+
+			if order.ProductID != "" && !productIDs[order.ProductID] {
+				issue(issueDanglingProduct, "product_id", order.ProductID, "")
+			}
+			reported := map[string]bool{}
+			for _, item := range order.Items {
+				if item.ProductID != "" && !productIDs[item.ProductID] && !reported[item.ProductID] {
+					issue(issueDanglingProduct, "items.product_id", item.ProductID, "")
+					reported[item.ProductID] = true
+				}
+			}
+		}
+		cursor.Close(ctx)
+	}
+
+	for id, note := range notes {
+		if !orderIDs[note.OrderID] {
+			issues = append(issues, IntegrityIssue{
+				Kind: issueOrphanedDeliveryNote, Collection: "delivery_notes", DocumentID: id, Field: "order_id", Value: note.OrderID,
+				Candidate: orderByNote[id], id: note.ID,
+			})
+		}
+	}
+
+	cursor, err = database.GetMongoCollection("order_notes").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"order_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	for cursor.Next(ctx) {
+		var note struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			OrderID string             `bson:"order_id"`
+		}
+		if cursor.Decode(&note) != nil || orderIDs[note.OrderID] {
+			continue
+		}
+		issues = append(issues, IntegrityIssue{
+			Kind: issueOrphanedOrderNote, Collection: "order_notes", DocumentID: note.ID.Hex(), Field: "order_id", Value: note.OrderID, id: note.ID,
+		})
+	}
+	cursor.Close(ctx)
+
+	return issues, nil
+}
+
+// repairIssue applies mode to one issue and records the outcome on it
+func repairIssue(ctx context.Context, issue *IntegrityIssue, mode string, dryRun bool) {
+	var update bson.M
+	updateOpts := options.Update()
+	result := repairSkipped
+
+	switch mode {
+	case repairFlag:
+		update = bson.M{"$addToSet": bson.M{"integrity_flags": issue.Kind}}
+		result = repairFlagged
+
+	case repairRelink:
+		if issue.Candidate == "" {
+			issue.Result = repairSkipped
+			issue.Message = "No record to relink to"
+			return
+		}
+		result = repairRelinked
+		switch issue.Kind {
+		case issueDanglingSales, issueOrphanedDeliveryNote:
+			update = bson.M{"$set": bson.M{issue.Field: issue.Candidate}}
+		case issueDanglingDeliveryNote:
+			note, err := findIntegrityNote(ctx, issue.Candidate)
+			if err != nil {
+				issue.Result = repairFailed
+				issue.Message = "Candidate delivery note not found"
+				return
+			}
+			update = bson.M{"$set": bson.M{
+				"delivery_note_id":     issue.Candidate,
+				"delivery_note_number": note.NoteNumber,
+				"delivery_note_token":  note.Token,
+			}}
+		}
+
+	case repairNullify:
+		result = repairNulled
+		switch issue.Kind {
+		case issueDanglingSales:
+			update = bson.M{"$set": bson.M{"sales_id": ""}}
+		case issueDanglingDeliveryNote:
+			update = bson.M{"$unset": bson.M{
+				"delivery_note_id": "", "delivery_note_number": "", "delivery_note_token": "", "delivery_note_url": "", "delivery_note_at": "",
+			}}
+		case issueDanglingProduct:
+			if issue.Field == "product_id" {
+				update = bson.M{"$set": bson.M{"product_id": ""}}
+			} else {
+				// Only the items pointing at the missing product lose their reference
+				update = bson.M{"$set": bson.M{"items.$[bad].product_id": ""}}
+				updateOpts.SetArrayFilters(options.ArrayFilters{
+					Filters: []interface{}{bson.M{"bad.product_id": issue.Value}},
+				})
+			}
+		default:
+			// Orphans have no reference to clear; deleting them is left to a human
+			issue.Result = repairSkipped
+			issue.Message = "Orphaned records can only be relinked or flagged"
+			return
+		}
+	}
+
+	if update == nil {
+		issue.Result = repairSkipped
+		return
+	}
+	if !dryRun {
+		update["$currentDate"] = bson.M{"updated_at": true}
+		if _, err := database.GetMongoCollection(issue.Collection).UpdateOne(ctx, bson.M{"_id": issue.id}, update, updateOpts); err != nil {
+			issue.Result = repairFailed
+			issue.Message = err.Error()
+			return
+		}
+	}
+	issue.Result = result
+}
+
+func findIntegrityNote(ctx context.Context, id string) (*integrityNote, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	note := &integrityNote{}
+	if err := database.GetMongoCollection("delivery_notes").FindOne(ctx, bson.M{"_id": objID}).Decode(note); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// idSet returns the hex IDs of every document in a collection
+func idSet(ctx context.Context, collection string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	cursor, err := database.GetMongoCollection(collection).Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if cursor.Decode(&doc) == nil {
+			ids[doc.ID.Hex()] = true
+		}
+	}
+	return ids, cursor.Err()
+}
+
+func (n integrityNote) idHex() string {
+	if n.ID.IsZero() {
+		return ""
+	}
+	return n.ID.Hex()
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Destructive migrations
+const (
+	migrationCleanupOrders = "cleanup-orders"
+	migrationResetOrders   = "reset-orders"
+)
+
+// Kinds of migration operations
+const (
+	operationDrop         = "drop"          // Drop the whole collection
+	operationUnsetFields  = "unset_fields"  // Remove fields from the documents having them
+	operationRewriteItems = "rewrite_items" // Replace the items of an order with their cleaned copy
+)
+
+// migrationConfirmTTL is how long the confirm token of a dry run stays valid
+const migrationConfirmTTL = 10 * time.Minute
+
+// migrationSampleSize caps the sample documents listed per operation
+const migrationSampleSize = 5
+
+// MigrationOperation is one change of a migration, as reported by its dry run
+type MigrationOperation struct {
+	Operation  string   `json:"operation"`
+	Collection string   `json:"collection"`
+	Documents  int64    `json:"documents"` // Documents the operation changes or removes
+	Fields     []string `json:"fields,omitempty"`
+	Sample     []bson.M `json:"sample,omitempty"`
+}
+
+// planFingerprint identifies what a plan changes, so a confirm token only runs the plan
+// it was issued for
+func planFingerprint(plan []MigrationOperation) string {
+	parts := []string{}
+	for _, op := range plan {
+		parts = append(parts, op.Operation+":"+op.Collection+":"+strconv.FormatInt(op.Documents, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// confirmSignature signs a migration, its plan and the admin running it until expires
+func confirmSignature(migration string, userID string, fingerprint string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(config.Cfg.JWT.AccessSecret))
+	fmt.Fprintf(mac, "%s|%s|%s|%d", migration, userID, fingerprint, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// confirmToken returns the token that confirms running a plan, valid for migrationConfirmTTL
+func confirmToken(c *fiber.Ctx, migration string, plan []MigrationOperation, now time.Time) (string, time.Time) {
+	expires := now.Add(migrationConfirmTTL)
+	signature := confirmSignature(migration, middleware.GetUserID(c), planFingerprint(plan), expires.Unix())
+	return strconv.FormatInt(expires.Unix(), 10) + "." + signature, expires
+}
+
+// previewMigration answers a dry run with the plan and the token confirming it
+func previewMigration(c *fiber.Ctx, migration string, plan []MigrationOperation) error {
+	token, expires := confirmToken(c, migration, plan, time.Now())
+	return response.Success(c, 200, fiber.Map{
+		"dry_run":            true,
+		"migration":          migration,
+		"operations":         plan,
+		"confirm_token":      token,
+		"confirm_expires_at": expires,
+	})
+}
+
+// checkConfirmation verifies the confirm query of a destructive run against the current
+// plan. It answers the request and returns false when the run may not go ahead: without
+// a valid token, or when the data changed since the dry run that issued it.
+func checkConfirmation(c *fiber.Ctx, migration string, plan []MigrationOperation) bool {
+	token := c.Query("confirm")
+	if token == "" {
+		response.ErrorCode(c, 400, response.CodeConfirmRequired, "Run with dry_run=true first and pass its confirm_token as confirm")
+		return false
+	}
+	expiresRaw, signature, _ := strings.Cut(token, ".")
+	expires, err := strconv.ParseInt(expiresRaw, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		response.ErrorCode(c, 400, response.CodeConfirmRequired, "The confirm token is invalid or expired, run a new dry run")
+		return false
+	}
+	// The token was signed for the plan of its dry run, so it no longer matches once
+	// the data changed
+	if hmac.Equal([]byte(signature), []byte(confirmSignature(migration, middleware.GetUserID(c), planFingerprint(plan), expires))) {
+		return true
+	}
+	response.ErrorCode(c, 409, response.CodeConfirmRequired, "The confirm token does not match what the migration would change now, run a new dry run")
+	return false
+}
@@ -1,9 +1,6 @@
 package handlers
 
 import (
-	"context"
-	"time"
-
 	"bg-go/internal/database"
 	"bg-go/internal/lib/notification"
 	"bg-go/internal/lib/response"
@@ -11,7 +8,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // NotificationHandler handles notification routes
@@ -24,13 +21,10 @@ func NewNotificationHandler() *NotificationHandler {
 
 // List returns all notifications with pagination
 func (h *NotificationHandler) List(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
+	pq := parsePage(c, 10, maxPageLimit)
 	status := c.Query("status")
 	notifType := c.Query("type")
 
-	skip := (page - 1) * limit
-
 	filter := bson.M{}
 	if status != "" {
 		filter["status"] = status
@@ -40,14 +34,12 @@ func (h *NotificationHandler) List(c *fiber.Ctx) error {
 	}
 
 	collection := database.GetMongoCollection("notifications")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
+	findOptions := pq.findOptions().
 		SetSort(bson.D{{Key: "created_at", Value: -1}})
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
@@ -58,8 +50,9 @@ func (h *NotificationHandler) List(c *fiber.Ctx) error {
 
 	var notifs []notification.Notification
 	cursor.All(ctx, &notifs)
+	notifs, more := trimPage(pq, notifs)
 
-	return response.SuccessWithPagination(c, 200, notifs, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, notifs, pq.pagination(total, more))
 }
 
 // GetPending returns all pending notifications
@@ -72,13 +65,78 @@ func (h *NotificationHandler) GetPending(c *fiber.Ctx) error {
 	return response.Success(c, 200, notifs)
 }
 
+// GetFailed returns dead-lettered notifications with their last error
+func (h *NotificationHandler) GetFailed(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	filter := bson.M{"status": notification.StatusFailed}
+	if notifType := c.Query("type"); notifType != "" {
+		filter["type"] = notifType
+	}
+
+	collection := database.GetMongoCollection("notifications")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	findOptions := pq.findOptions().
+		SetSort(bson.D{{Key: "failed_at", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch failed notifications")
+	}
+	defer cursor.Close(ctx)
+
+	var notifs []notification.Notification
+	if err := cursor.All(ctx, &notifs); err != nil {
+		return response.Error(c, 500, "Failed to decode failed notifications")
+	}
+	notifs, more := trimPage(pq, notifs)
+
+	return response.SuccessWithPagination(c, 200, notifs, pq.pagination(total, more))
+}
+
+// RetryFailed requeues failed notifications; an empty ids list retries all of them
+func (h *NotificationHandler) RetryFailed(c *fiber.Ctx) error {
+	type RetryRequest struct {
+		IDs []string `json:"ids"`
+	}
+
+	var req RetryRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+		}
+	}
+
+	ids := []primitive.ObjectID{}
+	for _, id := range req.IDs {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format: "+id)
+		}
+		ids = append(ids, objID)
+	}
+
+	requeued, err := notification.Requeue(ids)
+	if err != nil {
+		return response.Error(c, 500, "Failed to retry notifications")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"requeued": requeued,
+	})
+}
+
 // MarkAsSent marks a notification as sent
 func (h *NotificationHandler) MarkAsSent(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	err = notification.MarkAsSent(objID)
@@ -98,11 +156,11 @@ func (h *NotificationHandler) SendManual(c *fiber.Ctx) error {
 
 	var req SendRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	if req.Phone == "" || req.Message == "" {
-		return response.BadRequest(c, "Phone and message are required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Phone and message are required")
 	}
 
 	link := notification.GenerateWhatsAppLink(req.Phone, req.Message)
@@ -117,24 +175,57 @@ func (h *NotificationHandler) SendManual(c *fiber.Ctx) error {
 // GetStats returns notification statistics
 func (h *NotificationHandler) GetStats(c *fiber.Ctx) error {
 	collection := database.GetMongoCollection("notifications")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	pendingCount, _ := collection.CountDocuments(ctx, bson.M{"status": "pending"})
-	sentCount, _ := collection.CountDocuments(ctx, bson.M{"status": "sent"})
+	pendingCount, _ := collection.CountDocuments(ctx, bson.M{"status": notification.StatusPending})
+	sentCount, _ := collection.CountDocuments(ctx, bson.M{"status": notification.StatusSent})
+	failedCount, _ := collection.CountDocuments(ctx, bson.M{"status": notification.StatusFailed})
+	suppressedCount, _ := collection.CountDocuments(ctx, bson.M{"status": notification.StatusSuppressed})
 
 	// Count by type
 	invoiceCount, _ := collection.CountDocuments(ctx, bson.M{"type": notification.NotificationTypeInvoice})
 	deliveryCount, _ := collection.CountDocuments(ctx, bson.M{"type": notification.NotificationTypeDelivery})
 	queueCount, _ := collection.CountDocuments(ctx, bson.M{"type": notification.NotificationTypeQueue})
+	returnCount, _ := collection.CountDocuments(ctx, bson.M{"type": notification.NotificationTypeReturn})
+
+	// SMS fallbacks and what they cost, per currency
+	type smsCost struct {
+		Currency string  `json:"currency" bson:"_id"`
+		Messages int     `json:"messages" bson:"messages"`
+		Segments int     `json:"segments" bson:"segments"`
+		Cost     float64 `json:"cost" bson:"cost"`
+	}
+	smsCosts := []smsCost{}
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"sms.gateway": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$sms.currency",
+			"messages": bson.M{"$sum": 1},
+			"segments": bson.M{"$sum": "$sms.segments"},
+			"cost":     bson.M{"$sum": "$sms.cost"},
+		}}},
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch SMS costs")
+	}
+	err = cursor.All(ctx, &smsCosts)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode SMS costs")
+	}
 
 	return response.Success(c, 200, fiber.Map{
-		"pending": pendingCount,
-		"sent":    sentCount,
+		"pending":    pendingCount,
+		"sent":       sentCount,
+		"failed":     failedCount,
+		"suppressed": suppressedCount,
+		"sms":        smsCosts,
 		"by_type": fiber.Map{
 			"invoice":  invoiceCount,
 			"delivery": deliveryCount,
 			"queue":    queueCount,
+			"return":   returnCount,
 		},
 	})
 }
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// broadcastPreviewSample is how many recipients the audience preview lists
+const broadcastPreviewSample = 10
+
+// NotificationBroadcastRequest is the body of a notification broadcast. The message may
+// use {name} for the recipient's name; send_at (RFC 3339) is optional and defaults to now.
+type NotificationBroadcastRequest struct {
+	Message string     `json:"message"`
+	Segment string     `json:"segment"`
+	SendAt  *time.Time `json:"send_at"`
+}
+
+// validSegment reports whether a segment can be targeted by a broadcast
+func validSegment(segment string) bool {
+	for _, s := range notification.BroadcastSegments {
+		if s == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviewBroadcast returns the audience size of a segment with a sample of recipients
+func (h *NotificationHandler) PreviewBroadcast(c *fiber.Ctx) error {
+	segment := c.Query("segment")
+	if !validSegment(segment) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Segment must be one of "+strings.Join(notification.BroadcastSegments, ", "))
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	recipients, err := notification.SegmentRecipients(ctx, segment, time.Now())
+	if err != nil {
+		return response.Error(c, 500, "Failed to resolve audience")
+	}
+	sample := recipients
+	if len(sample) > broadcastPreviewSample {
+		sample = sample[:broadcastPreviewSample]
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"segment":  segment,
+		"audience": len(recipients),
+		"sample":   sample,
+	})
+}
+
+// Broadcast sends a message to every recipient of a segment, now or at send_at. The
+// audience is resolved when sending starts; messages go out one every
+// WHATSAPP_BROADCAST_INTERVAL as regular notifications, so they are retried, fall back
+// to SMS and respect opt-outs like any other.
+func (h *NotificationHandler) Broadcast(c *fiber.Ctx) error {
+	var req NotificationBroadcastRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Message is required")
+	}
+	if !validSegment(req.Segment) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Segment must be one of "+strings.Join(notification.BroadcastSegments, ", "))
+	}
+
+	now := time.Now()
+	sendNow := req.SendAt == nil || req.SendAt.IsZero()
+	if !sendNow {
+		if !req.SendAt.After(now) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "send_at must be in the future")
+		}
+		if req.SendAt.Sub(now) > maxScheduleAhead {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "send_at must be within 90 days")
+		}
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	recipients, err := notification.SegmentRecipients(ctx, req.Segment, now)
+	if err != nil {
+		return response.Error(c, 500, "Failed to resolve audience")
+	}
+	if sendNow && len(recipients) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "No recipients matched")
+	}
+
+	broadcast := models.NewNotificationBroadcast()
+	broadcast.Message = req.Message
+	broadcast.Segment = req.Segment
+	broadcast.SendAt = now
+	if !sendNow {
+		broadcast.SendAt = *req.SendAt
+	}
+	broadcast.Audience = len(recipients) // Estimate until sending starts
+	broadcast.CreatedBy = middleware.GetUserID(c)
+
+	if _, err := database.GetMongoCollection(notification.BroadcastCollection).InsertOne(ctx, broadcast); err != nil {
+		return response.Error(c, 500, "Failed to create broadcast")
+	}
+
+	audit.Log(c, audit.ActionNotifyBroadcast, notification.BroadcastCollection, broadcast.ID.Hex(), map[string]interface{}{
+		"segment":  broadcast.Segment,
+		"audience": broadcast.Audience,
+		"send_at":  broadcast.SendAt,
+	})
+
+	if sendNow {
+		go notification.SendBroadcasts()
+		return response.Success(c, 202, broadcast)
+	}
+	return response.Success(c, 201, broadcast)
+}
+
+// ListNotificationBroadcasts returns broadcasts by send time, newest first, optionally by status
+func (h *NotificationHandler) ListNotificationBroadcasts(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	collection := database.GetMongoCollection(notification.BroadcastCollection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().
+		SetSort(bson.D{{Key: "send_at", Value: -1}}).
+		SetProjection(bson.M{"recipients": 0}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch broadcasts")
+	}
+	defer cursor.Close(ctx)
+
+	broadcasts := []models.NotificationBroadcast{}
+	if err := cursor.All(ctx, &broadcasts); err != nil {
+		return response.Error(c, 500, "Failed to decode broadcasts")
+	}
+	broadcasts, more := trimPage(pq, broadcasts)
+
+	return response.SuccessWithPagination(c, 200, broadcasts, pq.pagination(total, more))
+}
+
+// broadcastRecipientStatus is a recipient with the delivery status of its notification
+type broadcastRecipientStatus struct {
+	models.BroadcastRecipient `bson:",inline"`
+	Status                    string     `json:"status"`
+	SentVia                   string     `json:"sent_via,omitempty"`
+	LastError                 string     `json:"last_error,omitempty"`
+	Attempts                  int        `json:"attempts"`
+	SentAt                    *time.Time `json:"sent_at,omitempty"`
+}
+
+// NotificationBroadcastDetail returns a broadcast with the delivery status of every
+// recipient and the count of recipients per status; recipients not reached yet are pending
+func (h *NotificationHandler) NotificationBroadcastDetail(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	broadcast := &models.NotificationBroadcast{}
+	if err := database.GetMongoCollection(notification.BroadcastCollection).FindOne(ctx, bson.M{"_id": objID}).Decode(broadcast); err != nil {
+		return response.NotFound(c, "Broadcast not found")
+	}
+
+	cursor, err := database.GetMongoCollection("notifications").Find(ctx, bson.M{"broadcast_id": objID.Hex()})
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch deliveries")
+	}
+	var notifications []notification.Notification
+	err = cursor.All(ctx, &notifications)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode deliveries")
+	}
+
+	byID := map[string]notification.Notification{}
+	for _, n := range notifications {
+		byID[n.ID.Hex()] = n
+	}
+
+	counts := map[string]int{}
+	recipients := []broadcastRecipientStatus{}
+	for _, recipient := range broadcast.Recipients {
+		status := broadcastRecipientStatus{BroadcastRecipient: recipient, Status: notification.StatusPending}
+		if n, ok := byID[recipient.NotificationID]; ok {
+			status.Status = n.Status
+			status.SentVia = n.SentVia
+			status.LastError = n.LastError
+			status.Attempts = n.Attempts
+			status.SentAt = n.SentAt
+		}
+		counts[status.Status]++
+		recipients = append(recipients, status)
+	}
+	broadcast.Recipients = nil
+
+	return response.Success(c, 200, fiber.Map{
+		"broadcast":  broadcast,
+		"recipients": recipients,
+		"counts":     counts,
+	})
+}
+
+// CancelNotificationBroadcast cancels a broadcast that is scheduled or still sending;
+// messages already sent are not recalled
+func (h *NotificationHandler) CancelNotificationBroadcast(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection(notification.BroadcastCollection)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "status": bson.M{"$in": []string{models.ScheduledStatusScheduled, models.ScheduledStatusSending}}},
+		bson.M{"$set": bson.M{"status": models.ScheduledStatusCancelled, "cancelled_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return response.Error(c, 500, "Failed to cancel broadcast")
+	}
+	if result.MatchedCount == 0 {
+		broadcast := &models.NotificationBroadcast{}
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(broadcast); err != nil {
+			return response.NotFound(c, "Broadcast not found")
+		}
+		return response.ErrorCode(c, 409, response.CodeConflict, "Broadcast is already "+broadcast.Status)
+	}
+
+	audit.Log(c, audit.ActionNotifyCancel, notification.BroadcastCollection, objID.Hex(), nil)
+
+	return response.SuccessWithMessage(c, 200, "Broadcast cancelled")
+}
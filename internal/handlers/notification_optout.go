@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ListOptOuts returns the phones that opted out of automated messages
+func (h *NotificationHandler) ListOptOuts(c *fiber.Ctx) error {
+	pq := parsePage(c, 20, maxPageLimit)
+
+	filter := bson.M{}
+	if search := c.Query("search"); search != "" {
+		filter["phone"] = bson.M{"$regex": regexp.QuoteMeta(search)}
+	}
+	if source := c.Query("source"); source != "" {
+		filter["source"] = source
+	}
+
+	collection := database.GetMongoCollection(notification.OptOutsCollection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch opt-outs")
+	}
+	defer cursor.Close(ctx)
+
+	var optOuts []models.NotificationOptOut
+	if err := cursor.All(ctx, &optOuts); err != nil {
+		return response.Error(c, 500, "Failed to decode opt-outs")
+	}
+	optOuts, more := trimPage(pq, optOuts)
+
+	return response.SuccessWithPagination(c, 200, optOuts, pq.pagination(total, more))
+}
+
+// AddOptOut stops automated messages to a phone, e.g. when a sales rep asks by phone
+func (h *NotificationHandler) AddOptOut(c *fiber.Ctx) error {
+	type OptOutRequest struct {
+		Phone  string `json:"phone"`
+		Reason string `json:"reason"`
+	}
+
+	var req OptOutRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	phone, err := utils.NormalizePhone(req.Phone)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid phone number")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	optOut, err := notification.OptOut(ctx, phone, models.OptOutSourceAdmin, strings.TrimSpace(req.Reason), middleware.GetUserID(c))
+	if err != nil {
+		return response.Error(c, 500, "Failed to save opt-out")
+	}
+
+	audit.Log(c, audit.ActionOptOutAdd, notification.OptOutsCollection, phone, map[string]interface{}{
+		"reason": optOut.Reason,
+	})
+
+	return response.Success(c, 201, optOut)
+}
+
+// RemoveOptOut resumes automated messages to a phone
+func (h *NotificationHandler) RemoveOptOut(c *fiber.Ctx) error {
+	phone, err := utils.NormalizePhone(c.Params("phone"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid phone number")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	removed, err := notification.OptIn(ctx, phone)
+	if err != nil {
+		return response.Error(c, 500, "Failed to remove opt-out")
+	}
+	if !removed {
+		return response.NotFound(c, "Phone has not opted out")
+	}
+
+	audit.Log(c, audit.ActionOptOutRemove, notification.OptOutsCollection, phone, nil)
+
+	return response.SuccessWithMessage(c, 200, "Opt-out removed")
+}
+
+// GetSuppressed returns the notifications withheld because their phone opted out
+func (h *NotificationHandler) GetSuppressed(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	filter := bson.M{"status": notification.StatusSuppressed}
+	if phone := c.Query("phone"); phone != "" {
+		filter["phone"] = bson.M{"$regex": regexp.QuoteMeta(phone)}
+	}
+	if notifType := c.Query("type"); notifType != "" {
+		filter["type"] = notifType
+	}
+
+	collection := database.GetMongoCollection("notifications")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch suppressed notifications")
+	}
+	defer cursor.Close(ctx)
+
+	var notifs []notification.Notification
+	if err := cursor.All(ctx, &notifs); err != nil {
+		return response.Error(c, 500, "Failed to decode suppressed notifications")
+	}
+	notifs, more := trimPage(pq, notifs)
+
+	return response.SuccessWithPagination(c, 200, notifs, pq.pagination(total, more))
+}
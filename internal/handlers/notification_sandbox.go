@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/sandbox"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SandboxOutbox returns the messages captured in sandbox mode, newest first.
+// Filter with channel (whatsapp, email) and to.
+func (h *NotificationHandler) SandboxOutbox(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	filter := bson.M{}
+	if channel := c.Query("channel"); channel != "" {
+		filter["channel"] = channel
+	}
+	if to := c.Query("to"); to != "" {
+		filter["to"] = to
+	}
+
+	collection := database.GetMongoCollection(sandbox.Collection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	findOptions := pq.findOptions().
+		SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch sandbox outbox")
+	}
+	defer cursor.Close(ctx)
+
+	messages := []sandbox.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return response.Error(c, 500, "Failed to decode sandbox outbox")
+	}
+	messages, more := trimPage(pq, messages)
+
+	return response.SuccessWithPagination(c, 200, messages, pq.pagination(total, more))
+}
+
+// SandboxStatus reports whether sandbox mode is on and which recipients bypass it
+func (h *NotificationHandler) SandboxStatus(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	captured, _ := database.GetMongoCollection(sandbox.Collection).CountDocuments(ctx, bson.M{})
+
+	return response.Success(c, 200, fiber.Map{
+		"enabled":   sandbox.Enabled(),
+		"allowlist": sandbox.Allowlist(),
+		"captured":  captured,
+	})
+}
+
+// ClearSandbox deletes captured messages, optionally only those of one channel
+func (h *NotificationHandler) ClearSandbox(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if channel := c.Query("channel"); channel != "" {
+		filter["channel"] = channel
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	result, err := database.GetMongoCollection(sandbox.Collection).DeleteMany(ctx, filter)
+	if err != nil {
+		return response.Error(c, 500, "Failed to clear sandbox outbox")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"deleted": result.DeletedCount,
+	})
+}
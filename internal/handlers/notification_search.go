@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/export"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// searchTermPattern splits a message search into words and "quoted phrases"
+var searchTermPattern = regexp.MustCompile(`"([^"]+)"|(\S+)`)
+
+// maxContentSearchDays bounds the date range of a search by message content, which
+// cannot use an index and scans every notification in the range
+const maxContentSearchDays = 366
+
+// notificationSearchFilter builds the filter of a message search from the query:
+// q (words and "quoted phrases", all of which the message must contain, ignoring case),
+// phone (matching every stored form of it), type, status, order_id and the from/to date
+// range (YYYY-MM-DD, inclusive), which is unbounded when neither is given. A q search
+// needs both dates, at most maxContentSearchDays apart.
+func notificationSearchFilter(c *fiber.Ctx) (bson.M, error) {
+	filter := bson.M{}
+
+	terms := []bson.M{}
+	for _, match := range searchTermPattern.FindAllStringSubmatch(c.Query("q"), -1) {
+		term := match[1]
+		if term == "" {
+			term = match[2]
+		}
+		terms = append(terms, bson.M{"message": bson.M{"$regex": regexp.QuoteMeta(term), "$options": "i"}})
+	}
+	if len(terms) > 0 {
+		filter["$and"] = terms
+	}
+
+	if phone := c.Query("phone"); phone != "" {
+		if _, err := utils.NormalizePhone(phone); err != nil {
+			return nil, err
+		}
+		filter["phone"] = bson.M{"$in": utils.PhoneVariants(phone)}
+	}
+	if notifType := c.Query("type"); notifType != "" {
+		filter["type"] = notifType
+	}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		filter["order_id"] = orderID
+	}
+	created := bson.M{}
+	if v := c.Query("from"); v != "" {
+		from, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid from date, use YYYY-MM-DD")
+		}
+		created["$gte"] = from
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid to date, use YYYY-MM-DD")
+		}
+		created["$lt"] = to.Add(24 * time.Hour)
+	}
+	if len(terms) > 0 {
+		from, hasFrom := created["$gte"].(time.Time)
+		to, hasTo := created["$lt"].(time.Time)
+		if !hasFrom || !hasTo {
+			return nil, fmt.Errorf("Searching by q needs a from and to date")
+		}
+		if to.Sub(from) > maxContentSearchDays*24*time.Hour {
+			return nil, fmt.Errorf("Searching by q covers at most %d days", maxContentSearchDays)
+		}
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	return filter, nil
+}
+
+// Search finds the notifications sent to a phone or containing a text, newest first, for
+// support and legal enquiries. Every search is audited with its query.
+func (h *NotificationHandler) Search(c *fiber.Ctx) error {
+	filter, err := notificationSearchFilter(c)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	if len(filter) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Search by q, phone, type, status, order_id or a date range")
+	}
+
+	pq := parsePage(c, 20, maxPageLimit)
+	collection := database.GetMongoCollection("notifications")
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to search notifications")
+	}
+	defer cursor.Close(ctx)
+
+	var notifs []notification.Notification
+	if err := cursor.All(ctx, &notifs); err != nil {
+		return response.Error(c, 500, "Failed to decode notifications")
+	}
+	notifs, more := trimPage(pq, notifs)
+
+	audit.Log(c, audit.ActionMessageSearch, "notifications", "", map[string]interface{}{
+		"query": string(c.Request().URI().QueryString()),
+		"total": total,
+	})
+
+	return response.SuccessWithPagination(c, 200, notifs, pq.pagination(total, more))
+}
+
+// ExportSearch streams the notifications of a search as CSV, oldest first, with the full
+// message content. The export is audited with its query.
+func (h *NotificationHandler) ExportSearch(c *fiber.Ctx) error {
+	filter, err := notificationSearchFilter(c)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	if len(filter) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Search by q, phone, type, status, order_id or a date range")
+	}
+
+	ctx, cancel := export.Context()
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetBatchSize(500)
+	cursor, err := database.GetMongoCollection("notifications").Find(ctx, filter, findOptions)
+	if err != nil {
+		cancel()
+		return response.Error(c, 500, "Failed to export notifications")
+	}
+
+	audit.Log(c, audit.ActionMessageExport, "notifications", "", map[string]interface{}{
+		"query": string(c.Request().URI().QueryString()),
+	})
+
+	header := []string{
+		"id", "created_at", "type", "phone", "status", "sent_via", "provider", "sent_at",
+		"attempts", "last_error", "order_id", "broadcast_id", "link", "message",
+	}
+	loc := exportLocation()
+	fileName := "notifications_" + time.Now().In(loc).Format("20060102_150405") + ".csv"
+
+	return export.StreamCSV(ctx, cancel, c, fileName, header, cursor, func(cursor *mongo.Cursor) ([]string, error) {
+		var notif notification.Notification
+		if err := cursor.Decode(&notif); err != nil {
+			return nil, err
+		}
+
+		return []string{
+			notif.ID.Hex(),
+			export.FormatTime(&notif.CreatedAt, loc),
+			string(notif.Type),
+			notif.Phone,
+			notif.Status,
+			notif.SentVia,
+			notif.Provider,
+			export.FormatTime(notif.SentAt, loc),
+			strconv.Itoa(notif.Attempts),
+			notif.LastError,
+			notif.OrderID,
+			notif.BroadcastID,
+			notif.Link,
+			strings.TrimSpace(notif.Message),
+		}, nil
+	})
+}
@@ -3,22 +3,30 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
-	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"bg-go/internal/config"
 	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/deliveryfee"
+	"bg-go/internal/lib/media"
 	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/numbering"
+	"bg-go/internal/lib/outbox"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/sla"
+	"bg-go/internal/lib/uom"
+	"bg-go/internal/middleware"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/skip2/go-qrcode"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // OrderHandler handles order routes
@@ -36,49 +44,40 @@ func generateToken(length int) string {
 	return hex.EncodeToString(b)
 }
 
-// generateOrderNumber generates order number
-func generateOrderNumber() string {
-	return fmt.Sprintf("ORD-%s", time.Now().Format("20060102150405"))
-}
-
-// generateQRCode generates a QR code image as base64
-func generateQRCode(content string) (string, error) {
-	png, err := qrcode.Encode(content, qrcode.Medium, 256)
-	if err != nil {
-		return "", err
-	}
-	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+// generateOrderNumber allocates the next sequential order number, e.g. ORD-202610-0001
+func generateOrderNumber(ctx context.Context) (string, error) {
+	return numbering.Next(ctx, numbering.SeriesOrder)
 }
 
 // List returns all orders with pagination and filters
 func (h *OrderHandler) List(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
-	search := c.Query("search")
-	status := c.Query("status")
+	if code, err := applyView(c, models.ViewResourceOrders); err != nil {
+		return viewFailed(c, code, err)
+	}
 
-	skip := (page - 1) * limit
+	pq := parsePage(c, 10, maxPageLimit)
+	status := c.Query("status")
 
 	filter := bson.M{}
-	if search != "" {
-		filter["$or"] = []bson.M{
-			{"order_number": bson.M{"$regex": search, "$options": "i"}},
-		}
-	}
+	applyOrderFilters(c, filter)
 	if status != "" {
 		filter["status"] = status
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	policy := sla.LoadPolicy(ctx)
+	now := time.Now()
+	if !applySLAFilter(c, policy, filter, now) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "sla must be on_track, at_risk or breached")
+	}
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
-		SetSort(bson.D{{Key: "created_at", Value: -1}})
+	total := pq.count(ctx, collection, filter)
+
+	findOptions := pq.findOptions().
+		SetSort(listSort(c, models.ViewResourceOrders, bson.D{{Key: "created_at", Value: -1}}))
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
@@ -88,10 +87,15 @@ func (h *OrderHandler) List(c *fiber.Ctx) error {
 
 	var orders []models.Order
 	cursor.All(ctx, &orders)
+	orders, more := trimPage(pq, orders)
+	sla.Annotate(policy, orders, now)
 
 	// Populate sales data
 	salesCollection := database.GetMongoCollection("sales")
+	colors := tagColors(ctx)
 	for i := range orders {
+		orders[i].TagHints = tagHints(orders[i].Tags, colors)
+		media.SignOrder(&orders[i])
 		if orders[i].SalesID != "" {
 			salesObjID, _ := primitive.ObjectIDFromHex(orders[i].SalesID)
 			sales := &models.Sales{}
@@ -109,7 +113,7 @@ func (h *OrderHandler) List(c *fiber.Ctx) error {
 		}
 	}
 
-	return response.SuccessWithPagination(c, 200, orders, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, orders, pq.pagination(total, more))
 }
 
 // Detail returns a single order by ID
@@ -118,17 +122,17 @@ func (h *OrderHandler) Detail(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	// Populate sales data
@@ -140,6 +144,9 @@ func (h *OrderHandler) Detail(c *fiber.Ctx) error {
 		order.Sales = sales
 	}
 
+	order.TagHints = tagHints(order.Tags, tagColors(ctx))
+	media.SignOrder(order)
+
 	// Populate virtual product for items
 	for j := range order.Items {
 		order.Items[j].Product = &models.Product{
@@ -157,105 +164,287 @@ type CreateItem struct {
 	ProductName string  `json:"product_name"`
 	Quantity    int     `json:"quantity"`
 	UnitPrice   float64 `json:"unit_price"`
-	Unit        string  `json:"unit"` // Optional, default "pcs"
+	Unit        string  `json:"unit"`       // Optional, default "pcs"
+	Category    string  `json:"category"`   // Optional, defaults to the category of the product with this name
+	PriceNote   string  `json:"price_note"` // Required when the unit price deviates too far from the product's price
 }
 
 // CreateRequest represents the create order request
 type CreateRequest struct {
-	SalesID string       `json:"sales_id"`
-	Items   []CreateItem `json:"items"`
+	SalesID       string       `json:"sales_id"`
+	CustomerName  string       `json:"customer_name"`
+	CustomerPhone string       `json:"customer_phone"`
+	Items         []CreateItem `json:"items"`
+
+	// DeliveryAddress is set for orders delivered to the customer's site; its fee is
+	// computed from the delivery fee policy unless DeliveryFee is given
+	DeliveryAddress string   `json:"delivery_address"`
+	DeliveryFee     *float64 `json:"delivery_fee"`
+
+	// DueDate (YYYY-MM-DD) replaces the due date from the sales' payment terms
+	DueDate string `json:"due_date"`
+}
+
+// orderItems are the items of a new order with their totals and price check outcome
+type orderItems struct {
+	Items         []models.OrderItem
+	ProductNames  []string
+	TotalQuantity int
+	TotalPrice    float64
+	Warnings      []PriceWarning
+	Overridden    []models.OrderItem // The items the warnings are about, in the same order
+}
+
+// buildOrderItems converts the requested items of a new order, skipping empty ones. Units
+// must be registered and items without a category take their catalog product's; with
+// catalogPrice, items without a unit price take the catalog price too. Prices beyond the
+// price check policy need a price note. On failure the request is answered and nil returned.
+func buildOrderItems(c *fiber.Ctx, ctx context.Context, items []CreateItem, catalogPrice bool) (*orderItems, error) {
+	names := []string{}
+	for _, item := range items {
+		if item.ProductName != "" {
+			names = append(names, item.ProductName)
+		}
+	}
+	// Without catalog prices or the policy every override would pass unchecked, so fail closed
+	catalog, err := catalogProducts(ctx, names)
+	if err != nil {
+		return nil, response.Error(c, 500, "Failed to load catalog prices")
+	}
+	priceCheck, err := loadPriceCheckPolicy(ctx)
+	if err != nil {
+		return nil, response.Error(c, 500, "Failed to load price check policy")
+	}
+	units, err := uom.Load(ctx)
+	if err != nil {
+		return nil, unitsUnavailable(c)
+	}
+
+	built := &orderItems{
+		Items:        []models.OrderItem{},
+		ProductNames: []string{},
+		Warnings:     []PriceWarning{},
+		Overridden:   []models.OrderItem{},
+	}
+	for i, item := range items {
+		if item.ProductName == "" || item.Quantity <= 0 {
+			continue
+		}
+
+		// Default unit to "pcs"; units must be registered so quantities convert
+		unit := item.Unit
+		if unit == "" {
+			unit = "pcs"
+		}
+		registered, known := units.Lookup(unit)
+		if !known {
+			return nil, unknownUnit(c, units, i, unit)
+		}
+		baseQuantity, baseUnit, _ := units.ToBase(float64(item.Quantity), registered.Code)
+
+		product := catalog[strings.ToLower(strings.TrimSpace(item.ProductName))]
+		unitPrice := item.UnitPrice
+		if unitPrice == 0 && catalogPrice {
+			unitPrice = product.Price
+		}
+		category := strings.TrimSpace(item.Category)
+		if category == "" {
+			category = product.Category
+		}
+
+		subtotal := unitPrice * float64(item.Quantity)
+		orderItem := models.OrderItem{
+			ProductName: item.ProductName,
+			Quantity:    item.Quantity,
+			UnitPrice:   unitPrice,
+			Unit:        registered.Code,
+			Subtotal:    subtotal,
+			Category:    category,
+			ListPrice:   product.Price,
+			PriceNote:   strings.TrimSpace(item.PriceNote),
+
+			BaseQuantity: baseQuantity,
+			BaseUnit:     baseUnit,
+		}
+		if warning, deviates := checkPrice(priceCheck, i, orderItem); deviates {
+			built.Warnings = append(built.Warnings, warning)
+			built.Overridden = append(built.Overridden, orderItem)
+		}
+		built.Items = append(built.Items, orderItem)
+
+		built.TotalPrice += subtotal
+		built.ProductNames = append(built.ProductNames, item.ProductName)
+		built.TotalQuantity += item.Quantity
+	}
+
+	if len(built.Items) == 0 {
+		return nil, response.ErrorCode(c, 400, response.CodeValidationFailed, "No valid items provided")
+	}
+
+	// Prices far from the catalog price are overrides and need a justification
+	if missing := missingPriceNotes(built.Warnings); len(missing) > 0 {
+		return nil, response.ErrorCodeWithData(c, 400, response.CodePriceNoteRequired,
+			"Unit price deviates from the product price, add a price note", fiber.Map{
+				"max_deviation_percent": priceCheck.MaxDeviationPercent,
+				"price_warnings":        missing,
+			})
+	}
+	return built, nil
 }
 
 // Create creates a new order
 func (h *OrderHandler) Create(c *fiber.Ctx) error {
 	var req CreateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	// Validate sales
 	if req.SalesID == "" {
-		return response.BadRequest(c, "Sales is required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Sales is required")
 	}
 
 	// Validate items
 	if len(req.Items) == 0 {
-		return response.BadRequest(c, "At least one item is required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least one item is required")
 	}
 
 	// Validate sales exists
 	salesCollection := database.GetMongoCollection("sales")
-	salesCtx, salesCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	salesCtx, salesCancel := requestContext(c, readTimeout)
 	defer salesCancel()
 
 	salesObjID, _ := primitive.ObjectIDFromHex(req.SalesID)
 	sales := &models.Sales{}
 	err := salesCollection.FindOne(salesCtx, bson.M{"_id": salesObjID}).Decode(sales)
 	if err != nil {
-		return response.BadRequest(c, "Sales not found")
+		return response.ErrorCode(c, 400, response.CodeSalesNotFound, "Sales not found")
 	}
 
 	// Create order
 	order := models.NewOrder()
 	order.SalesID = req.SalesID
+	order.CustomerName = req.CustomerName
+	order.CustomerPhone = req.CustomerPhone
+	order.DeliveryAddress = strings.TrimSpace(req.DeliveryAddress)
+	order.StatusHistory[0].ChangedBy = middleware.GetUserID(c)
 	order.Items = []models.OrderItem{}
+	order.DueDate, err = dueDate(req.DueDate, sales, order.CreatedAt)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
 
 	// Process items
-	var totalPrice float64 = 0
-	productNames := []string{}
-	totalQuantity := 0
+	built, err := buildOrderItems(c, salesCtx, req.Items, false)
+	if built == nil {
+		return err
+	}
+	order.Items = built.Items
+	productNames := built.ProductNames
+	totalQuantity := built.TotalQuantity
+	priceWarnings := built.Warnings
 
-	for _, item := range req.Items {
-		if item.ProductName == "" || item.Quantity <= 0 {
-			continue
+	// Set totals
+	order.Quantity = totalQuantity
+	order.UnitPrice = built.TotalPrice / float64(totalQuantity)
+	order.Subtotal = built.TotalPrice
+	order.TotalPrice = built.TotalPrice
+
+	// Delivery fee, a separate line on the invoice
+	if req.DeliveryFee != nil {
+		if *req.DeliveryFee < 0 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Delivery fee cannot be negative")
 		}
-
-		// Default unit to "pcs"
-		unit := item.Unit
-		if unit == "" {
-			unit = "pcs"
+		order.DeliveryFee = *req.DeliveryFee
+		order.TotalPrice += order.DeliveryFee
+	} else if order.DeliveryAddress != "" {
+		quoteCtx, quoteCancel := requestContext(c, listTimeout)
+		err := priceDelivery(quoteCtx, order)
+		quoteCancel()
+		if err != nil && !errors.Is(err, deliveryfee.ErrDisabled) {
+			return deliveryFeeFailed(c, err)
 		}
-
-		subtotal := item.UnitPrice * float64(item.Quantity)
-
-		order.Items = append(order.Items, models.OrderItem{
-			ProductName: item.ProductName,
-			Quantity:    item.Quantity,
-			UnitPrice:   item.UnitPrice,
-			Unit:        unit,
-			Subtotal:    subtotal,
-		})
-
-		totalPrice += subtotal
-		productNames = append(productNames, item.ProductName)
-		totalQuantity += item.Quantity
 	}
 
-	if len(order.Items) == 0 {
-		return response.BadRequest(c, "No valid items provided")
+	// Credit limit of the sales, over it the order is blocked or only flagged
+	credit, overLimit, err := checkCredit(salesCtx, sales, order.TotalPrice)
+	if err != nil {
+		return response.Error(c, 500, "Failed to check the credit limit")
+	}
+	if overLimit && credit.CreditCheck == models.CreditCheckBlock {
+		return response.ErrorCodeWithData(c, 400, response.CodeCreditLimitExceeded,
+			"The order exceeds the sales' credit limit", fiber.Map{
+				"credit":      credit,
+				"order_total": order.TotalPrice,
+			})
 	}
-
-	// Set totals
-	order.Quantity = totalQuantity
-	order.UnitPrice = totalPrice / float64(totalQuantity)
-	order.TotalPrice = totalPrice
 
 	// Generate invoice token
 	invoiceToken := generateToken(32)
-	order.OrderNumber = generateOrderNumber()
+	order.OrderNumber, err = generateOrderNumber(salesCtx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to allocate order number")
+	}
 	order.Status = models.OrderStatusPending
 	order.PaymentStatus = models.PaymentStatusPending
 	order.InvoiceToken = invoiceToken
 	order.InvoiceURL = fmt.Sprintf("%s/order/%s", config.Cfg.Client.URL, invoiceToken)
 
-	// Save order
+	// Get first product name for notification
+	firstProductName := ""
+	if len(productNames) > 0 {
+		firstProductName = productNames[0]
+		if len(productNames) > 1 {
+			firstProductName = fmt.Sprintf("%s (+%d lainnya)", productNames[0], len(productNames)-1)
+		}
+	}
+
+	invoice := notification.InvoiceNotification(
+		sales.Phone,
+		sales.Name,
+		order.OrderNumber,
+		firstProductName,
+		totalQuantity,
+		"item",
+		order.TotalPrice,
+		invoiceToken,
+	)
+	invoice.OrderID = order.ID.Hex()
+
+	// Save order together with its invoice notification
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
-	_, err = collection.InsertOne(ctx, order)
+	err = database.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := collection.InsertOne(txCtx, order); err != nil {
+			return err
+		}
+		if len(priceWarnings) > 0 {
+			overrides := priceOverrides(c, order, built.Overridden, priceWarnings)
+			if _, err := database.GetMongoCollection(priceOverrideCollection).InsertMany(txCtx, overrides); err != nil {
+				return err
+			}
+		}
+		_, err := outbox.EnqueueNotification(txCtx, invoice)
+		return err
+	})
 	if err != nil {
-		return response.Error(c, 500, "Failed to create order")
+		releaseNumber(order.OrderNumber, err)
+		return writeFailed(c, err, "Failed to create order")
+	}
+	outbox.Kick()
+	go notification.NotifyStatusChange(order.ID, models.OrderStatusPending)
+
+	audit.Log(c, audit.ActionOrderCreate, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"total_price":  order.TotalPrice,
+	})
+	if len(priceWarnings) > 0 {
+		audit.Log(c, audit.ActionPriceOverride, "order", order.ID.Hex(), map[string]interface{}{
+			"order_number":   order.OrderNumber,
+			"price_warnings": priceWarnings,
+		})
 	}
 
 	// Populate virtual product for items
@@ -270,27 +459,8 @@ func (h *OrderHandler) Create(c *fiber.Ctx) error {
 	// Populate sales for response
 	order.Sales = sales
 
-	// Get first product name for notification
-	firstProductName := ""
-	if len(productNames) > 0 {
-		firstProductName = productNames[0]
-		if len(productNames) > 1 {
-			firstProductName = fmt.Sprintf("%s (+%d lainnya)", productNames[0], len(productNames)-1)
-		}
-	}
-
 	// Generate WhatsApp notification link
-	notification.Init(config.Cfg.Client.URL)
-	waLink, _ := notification.SendInvoiceNotification(
-		sales.Phone,
-		sales.Name,
-		order.OrderNumber,
-		firstProductName,
-		totalQuantity,
-		"item",
-		totalPrice,
-		invoiceToken,
-	)
+	waLink := notification.GenerateWhatsAppLink(invoice.Phone, invoice.Message)
 
 	// Check WhatsApp status
 	waStatus := notification.WhatsAppStatus()
@@ -300,6 +470,9 @@ func (h *OrderHandler) Create(c *fiber.Ctx) error {
 		"whatsapp_link":      waLink,
 		"whatsapp_connected": waStatus["logged_in"].(bool),
 		"whatsapp_auto_sent": waStatus["logged_in"].(bool),
+		"price_warnings":     priceWarnings,
+		"credit":             credit,
+		"credit_exceeded":    overLimit,
 	})
 }
 
@@ -309,35 +482,54 @@ func (h *OrderHandler) Update(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	type UpdateRequest struct {
-		Status string `json:"status,omitempty"`
+		Status  string  `json:"status,omitempty"`
+		DueDate *string `json:"due_date,omitempty"` // YYYY-MM-DD, empty removes the due date
 	}
 
 	var req UpdateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	update := bson.M{"updated_at": time.Now()}
+	changes := bson.M{"$set": update}
 	if req.Status != "" {
 		update["status"] = req.Status
+		changes["$push"] = bson.M{"status_history": models.NewStatusChange(req.Status, middleware.GetUserID(c))}
+	}
+	// A later due date clears the overdue flag on the next overdue check
+	if req.DueDate != nil && *req.DueDate == "" {
+		changes["$unset"] = bson.M{"due_date": "", "overdue": "", "overdue_since": ""}
+	} else if req.DueDate != nil {
+		due, err := parseDueDate(*req.DueDate)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+		}
+		update["due_date"] = due
 	}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, changes)
 	if err != nil {
 		return response.Error(c, 500, "Failed to update order")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
+	if req.Status != "" {
+		go notification.NotifyStatusChange(objID, req.Status)
+	}
+
+	audit.Log(c, audit.ActionOrderUpdate, "order", id, update)
+
 	return response.SuccessWithMessage(c, 200, "Successfully updated")
 }
 
@@ -347,23 +539,19 @@ func (h *OrderHandler) Delete(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objID},
-		bson.M{"$set": bson.M{"status": models.OrderStatusCancelled, "updated_at": time.Now()}},
-	)
-	if err != nil {
-		return response.Error(c, 500, "Failed to cancel order")
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
-	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Order not found")
+	if cancelled, err := cancelOrder(ctx, c, order); !cancelled {
+		return err
 	}
 
 	return response.SuccessWithMessage(c, 200, "Order cancelled successfully")
@@ -372,16 +560,16 @@ func (h *OrderHandler) Delete(c *fiber.Ctx) error {
 // GetStats returns order statistics for dashboard
 func (h *OrderHandler) GetStats(c *fiber.Ctx) error {
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	// Count by status
-	pendingCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusPending})
-	paidCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusPaid})
-	confirmedCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusConfirmed})
-	queuedCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusQueued})
-	loadingCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusLoading})
-	completedCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusCompleted})
+	pendingCount, _ := collection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusPending}))
+	paidCount, _ := collection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusPaid}))
+	confirmedCount, _ := collection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusConfirmed}))
+	queuedCount, _ := collection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusQueued}))
+	loadingCount, _ := collection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusLoading}))
+	completedCount, _ := collection.CountDocuments(ctx, withoutShipments(bson.M{"status": models.OrderStatusCompleted}))
 
 	// Today's stats
 	today := time.Now().Format("2006-01-02")
@@ -394,11 +582,11 @@ func (h *OrderHandler) GetStats(c *fiber.Ctx) error {
 			"$lt":  todayEnd,
 		},
 	}
-	todayOrders, _ := collection.CountDocuments(ctx, todayFilter)
+	todayOrders, _ := collection.CountDocuments(ctx, withoutShipments(todayFilter))
 
 	// Total revenue
 	pipeline := []bson.M{
-		{"$match": bson.M{"status": bson.M{"$ne": models.OrderStatusCancelled}}},
+		{"$match": withoutShipments(bson.M{"status": bson.M{"$nin": models.UnrealizedOrderStatuses}})},
 		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$total_price"}}},
 	}
 	cursor, _ := collection.Aggregate(ctx, pipeline)
@@ -431,23 +619,23 @@ func (h *OrderHandler) FinishLoading(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	orderCollection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	// Get order
 	order := &models.Order{}
 	err = orderCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	// Check status
 	if order.Status != models.OrderStatusLoading {
-		return response.BadRequest(c, "Order is not in loading status")
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order is not in loading status")
 	}
 
 	// Get sales data
@@ -458,27 +646,43 @@ func (h *OrderHandler) FinishLoading(c *fiber.Ctx) error {
 		salesCollection.FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
 	}
 
+	// Orders sharing the truck get the same, combined delivery note
+	loaded := append([]models.Order{*order}, mergedOrders(ctx, order)...)
+	combined := len(loaded) > 1
+
 	// Build product names from items array
 	productNames := ""
+	productQty := 0
 	var deliveryItems []models.DeliveryNoteItem
-	if len(order.Items) > 0 {
-		for i, item := range order.Items {
-			if i > 0 {
+	for _, source := range loaded {
+		for _, item := range source.Items {
+			if productNames != "" {
 				productNames += ", "
 			}
 			productNames += item.ProductName
-			deliveryItems = append(deliveryItems, models.DeliveryNoteItem{
+			deliveryItem := models.DeliveryNoteItem{
 				ProductName: item.ProductName,
 				Quantity:    item.Quantity,
 				Unit:        item.Unit,
 				UnitPrice:   item.UnitPrice,
-			})
+
+				BaseQuantity: item.BaseQuantity,
+				BaseUnit:     item.BaseUnit,
+			}
+			if combined {
+				deliveryItem.OrderNumber = source.OrderNumber
+			}
+			deliveryItems = append(deliveryItems, deliveryItem)
 		}
+		productQty += source.Quantity
 	}
 
 	// Create delivery note
 	token := generateToken(16)
-	noteNumber := generateNoteNumber()
+	noteNumber, err := generateNoteNumber(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to allocate delivery note number")
+	}
 	now := time.Now()
 
 	note := models.NewDeliveryNote()
@@ -491,17 +695,24 @@ func (h *OrderHandler) FinishLoading(c *fiber.Ctx) error {
 	note.SalesName = sales.Name
 	note.SalesPhone = sales.Phone
 	note.ProductName = productNames
-	note.ProductQty = order.Quantity
+	note.ProductQty = productQty
 	note.ProductUnit = "pcs"
 	note.DriverName = order.DriverName
 	note.DriverPhone = order.DriverPhone
 	note.VehiclePlate = order.VehiclePlate
 	note.Items = deliveryItems
+	if combined {
+		for _, source := range loaded {
+			note.OrderIDs = append(note.OrderIDs, source.ID.Hex())
+			note.OrderNumbers = append(note.OrderNumbers, source.OrderNumber)
+		}
+	}
 
 	// Save delivery note
 	deliveryCollection := database.GetMongoCollection("delivery_notes")
 	_, err = deliveryCollection.InsertOne(ctx, note)
 	if err != nil {
+		releaseNumber(noteNumber, err)
 		return response.Error(c, 500, "Failed to create delivery note")
 	}
 
@@ -516,15 +727,44 @@ func (h *OrderHandler) FinishLoading(c *fiber.Ctx) error {
 		"completed_at":         now,
 		"updated_at":           now,
 	}
+	// Orders delivered to site leave the warehouse once loaded
+	if order.DeliveryAddress != "" {
+		orderUpdate["dispatched_at"] = now
+	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusCompleted, middleware.GetUserID(c))}
 
-	result, err := orderCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": orderUpdate})
+	result, err := orderCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": orderUpdate, "$push": statusChange})
 	if err != nil {
 		return response.Error(c, 500, "Failed to finish loading")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	// The orders carried along complete with the truck
+	for _, merged := range loaded[1:] {
+		if _, err := orderCollection.UpdateOne(ctx, bson.M{"_id": merged.ID}, bson.M{"$set": orderUpdate, "$push": statusChange}); err != nil {
+			log.Printf("[Order] Failed to complete %s loaded with %s: %v", merged.OrderNumber, order.OrderNumber, err)
+			continue
+		}
+		go notification.NotifyStatusChange(merged.ID, models.OrderStatusCompleted)
 	}
 
+	go notification.NotifyStatusChange(objID, models.OrderStatusCompleted)
+	go sendNoteToRecipients(note.ID, false)
+
+	// A finished shipment counts towards its split order
+	for i := range loaded {
+		if loaded[i].ShipmentOf != "" {
+			fulfillShipment(ctx, &loaded[i], middleware.GetUserID(c))
+		}
+	}
+
+	audit.Log(c, audit.ActionDeliveryCreate, "delivery_note", note.ID.Hex(), map[string]interface{}{
+		"order_id":    id,
+		"note_number": noteNumber,
+	})
+
 	// Get updated order
 	orderCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
 
@@ -541,7 +781,7 @@ func (h *OrderHandler) SubmitDriver(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	type DriverRequest struct {
@@ -552,55 +792,60 @@ func (h *OrderHandler) SubmitDriver(c *fiber.Ctx) error {
 
 	var req DriverRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	// Validate
 	if req.DriverName == "" || req.DriverPhone == "" || req.VehiclePlate == "" {
-		return response.BadRequest(c, "All driver fields are required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "All driver fields are required")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Get order
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	// Check status
 	if order.Status != models.OrderStatusConfirmed {
-		return response.BadRequest(c, "Order must be confirmed first")
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order must be confirmed first")
+	}
+	if order.ShipmentCount > 0 {
+		return response.ErrorCode(c, 400, response.CodeOrderSplit, "Order is split, enter the driver of a shipment instead")
+	}
+	if order.LoadedWith != "" {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order is loaded with another order, which carries the driver")
 	}
 
-	// Generate QR code for queue
-	qrToken := generateToken(16)
-	qrCode, _ := generateQRCode(qrToken)
+	// Generate barcode and QR code for queue
+	update, err := queueBarcodeFields(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to generate QR code")
+	}
 
 	// Update order
-	update := bson.M{
-		"driver_name":    req.DriverName,
-		"driver_phone":   req.DriverPhone,
-		"vehicle_plate":  req.VehiclePlate,
-		"queue_barcode":  qrToken,
-		"queue_qrcode":   qrCode,
-		"updated_at":     time.Now(),
-	}
+	update["driver_name"] = req.DriverName
+	update["driver_phone"] = req.DriverPhone
+	update["vehicle_plate"] = req.VehiclePlate
+	update["updated_at"] = time.Now()
 
 	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
 	if err != nil {
 		return response.Error(c, 500, "Failed to submit driver data")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	return response.Success(c, 200, fiber.Map{
-		"message":     "Driver data submitted successfully",
-		"queue_token": qrToken,
+		"message":        "Driver data submitted successfully",
+		"queue_token":    update["queue_barcode"],
+		"barcode_format": update["barcode_format"],
 	})
 }
 
@@ -610,23 +855,34 @@ func (h *OrderHandler) CallQueue(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type CallRequest struct {
+		Bay string `json:"bay"`
+	}
+
+	var req CallRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+		}
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Get order
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	// Check status
 	if order.Status != models.OrderStatusQueued {
-		return response.BadRequest(c, "Order is not in queue")
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order is not in queue")
 	}
 
 	// Update status to loading
@@ -636,16 +892,24 @@ func (h *OrderHandler) CallQueue(c *fiber.Ctx) error {
 		"loading_at":     now,
 		"updated_at":     now,
 	}
+	if req.Bay != "" {
+		update["loading_bay"] = req.Bay
+	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusLoading, middleware.GetUserID(c))}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update, "$push": statusChange})
 	if err != nil {
 		return response.Error(c, 500, "Failed to call order")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
+	// Message the driver (and anyone else configured) that their turn is called
+	links := notification.NotifyStatusChange(objID, models.OrderStatusLoading)
+
 	return response.Success(c, 200, fiber.Map{
-		"message": "Order called successfully",
+		"message":              "Order called successfully",
+		"driver_whatsapp_link": links[models.RecipientDriver],
 	})
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FindByNumber returns an order by its order number, reading through to the
+// archive when the order has been moved out of the live collection
+func (h *OrderHandler) FindByNumber(c *fiber.Ctx) error {
+	orderNumber := c.Params("order_number")
+	if orderNumber == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Order number is required")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	filter := bson.M{"order_number": orderNumber}
+
+	order := &models.Order{}
+	err := database.GetMongoCollection("orders").FindOne(ctx, filter).Decode(order)
+	if err != nil {
+		order, err = archive.FindOrder(ctx, filter)
+		if err != nil {
+			return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+		}
+	}
+
+	// Populate sales data
+	if order.SalesID != "" {
+		salesObjID, _ := primitive.ObjectIDFromHex(order.SalesID)
+		sales := &models.Sales{}
+		database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
+		order.Sales = sales
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"order":    order,
+		"archived": order.ArchivedAt != nil,
+	})
+}
+
+// RunArchive archives old completed and cancelled orders immediately
+func (h *OrderHandler) RunArchive(c *fiber.Ctx) error {
+	moved, err := archive.ArchiveOrders()
+	if err != nil {
+		return response.Error(c, 500, "Archival failed: "+err.Error())
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"archived": moved,
+	})
+}
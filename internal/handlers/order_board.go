@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Cards per board column, unless limit asks for fewer or more
+const (
+	boardDefaultCards = 20
+	boardMaxCards     = 100
+)
+
+// orderTransitions lists the statuses an order can move to from each status
+var orderTransitions = map[string][]string{
+	models.OrderStatusPending:   {models.OrderStatusPaid, models.OrderStatusCancelled},
+	models.OrderStatusPaid:      {models.OrderStatusConfirmed, models.OrderStatusPending, models.OrderStatusCancelled},
+	models.OrderStatusConfirmed: {models.OrderStatusQueued, models.OrderStatusCancelled},
+	models.OrderStatusQueued:    {models.OrderStatusLoading, models.OrderStatusCancelled},
+	models.OrderStatusLoading:   {models.OrderStatusCompleted},
+}
+
+// moveActions names the action that performs a transition needing more than a status,
+// such as the payment proof or the driver's data
+var moveActions = map[string]string{
+	models.OrderStatusPending + ">" + models.OrderStatusPaid:      "upload the payment proof on the invoice page",
+	models.OrderStatusPaid + ">" + models.OrderStatusConfirmed:    "verify the payment with POST /api/v1/payments/:id/verify",
+	models.OrderStatusPaid + ">" + models.OrderStatusPending:      "reject the payment with POST /api/v1/payments/:id/reject",
+	models.OrderStatusConfirmed + ">" + models.OrderStatusQueued:  "submit the driver with POST /api/v1/orders/:id/driver",
+	models.OrderStatusLoading + ">" + models.OrderStatusCompleted: "finish loading with POST /api/v1/orders/:id/finish-loading",
+}
+
+// BoardColumn is one status column of the order board
+type BoardColumn struct {
+	Status string         `json:"status"`
+	Count  int            `json:"count"`
+	Orders []models.Order `json:"orders"`
+}
+
+// Board returns orders grouped by status for a kanban view: every column has the count
+// of its orders and the newest ones as cards, all from one aggregation. statuses
+// (comma separated) picks the columns, limit the cards per column; the list filters apply.
+func (h *OrderHandler) Board(c *fiber.Ctx) error {
+	statuses := models.OrderStatuses
+	if raw := c.Query("statuses"); raw != "" {
+		statuses = []string{}
+		for _, status := range strings.Split(raw, ",") {
+			status = strings.TrimSpace(status)
+			if !validOrderStatus(status) {
+				return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown status: "+status)
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	limit := boardDefaultCards
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > boardMaxCards {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "limit must be between 0 and "+strconv.Itoa(boardMaxCards))
+		}
+		limit = n
+	}
+
+	filter := bson.M{}
+	applyOrderFilters(c, filter)
+	filter["status"] = bson.M{"$in": statuses}
+
+	facets := bson.D{{Key: "counts", Value: bson.A{
+		bson.D{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	}}}
+	for _, status := range statuses {
+		cards := bson.A{
+			bson.D{{Key: "$match", Value: bson.M{"status": status}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: -1}}}},
+			bson.D{{Key: "$limit", Value: limit}},
+			bson.D{{Key: "$project", Value: bson.M{"status_history": 0, "queue_qrcode": 0, "barcode_image": 0}}},
+		}
+		if limit == 0 {
+			cards = bson.A{bson.D{{Key: "$match", Value: bson.M{"_id": nil}}}}
+		}
+		facets = append(facets, bson.E{Key: status, Value: cards})
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("orders").Aggregate(ctx, bson.A{
+		bson.D{{Key: "$match", Value: filter}},
+		bson.D{{Key: "$facet", Value: facets}},
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch order board")
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		return response.Error(c, 500, "Failed to fetch order board")
+	}
+
+	var counts []struct {
+		Status string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	if err := cursor.Current.Lookup("counts").Unmarshal(&counts); err != nil {
+		return response.Error(c, 500, "Failed to decode order board")
+	}
+	countOf := map[string]int{}
+	total := 0
+	for _, count := range counts {
+		countOf[count.Status] = count.Count
+		total += count.Count
+	}
+
+	columns := []BoardColumn{}
+	salesIDs := []primitive.ObjectID{}
+	for _, status := range statuses {
+		column := BoardColumn{Status: status, Count: countOf[status], Orders: []models.Order{}}
+		if value, err := cursor.Current.LookupErr(status); err == nil {
+			if err := value.Unmarshal(&column.Orders); err != nil {
+				return response.Error(c, 500, "Failed to decode order board")
+			}
+		}
+		for _, order := range column.Orders {
+			if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+				salesIDs = append(salesIDs, salesObjID)
+			}
+		}
+		columns = append(columns, column)
+	}
+
+	// Populate sales data in one query for every card
+	sales := map[string]*models.Sales{}
+	if len(salesIDs) > 0 {
+		salesCursor, err := database.GetMongoCollection("sales").Find(ctx, bson.M{"_id": bson.M{"$in": salesIDs}})
+		if err != nil {
+			return response.Error(c, 500, "Failed to fetch sales")
+		}
+		var found []models.Sales
+		err = salesCursor.All(ctx, &found)
+		salesCursor.Close(ctx)
+		if err != nil {
+			return response.Error(c, 500, "Failed to decode sales")
+		}
+		for i := range found {
+			sales[found[i].ID.Hex()] = &found[i]
+		}
+	}
+	colors := tagColors(ctx)
+	for i := range columns {
+		for j := range columns[i].Orders {
+			order := &columns[i].Orders[j]
+			order.Sales = sales[order.SalesID]
+			order.TagHints = tagHints(order.Tags, colors)
+			media.SignOrder(order)
+		}
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"columns": columns,
+		"total":   total,
+	})
+}
+
+// validOrderStatus reports whether status is an order status
+func validOrderStatus(status string) bool {
+	for _, s := range models.OrderStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Move moves an order to another board column. Only transitions of the order
+// lifecycle are allowed; the ones that need more than a status (payment, driver data,
+// delivery note) answer with the action to use instead. Calling the order from the
+// queue and cancelling are done right away.
+func (h *OrderHandler) Move(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type MoveRequest struct {
+		Status string `json:"status"`
+	}
+
+	var req MoveRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if !validOrderStatus(req.Status) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown status: "+req.Status)
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	allowed := false
+	for _, to := range orderTransitions[order.Status] {
+		allowed = allowed || to == req.Status
+	}
+	if !allowed {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "A "+order.Status+" order cannot move to "+req.Status)
+	}
+	if action, ok := moveActions[order.Status+">"+req.Status]; ok {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "To move the order to "+req.Status+", "+action)
+	}
+
+	if req.Status == models.OrderStatusLoading {
+		return h.CallQueue(c)
+	}
+
+	if cancelled, err := cancelOrder(ctx, c, order); !cancelled {
+		return err
+	}
+
+	return response.SuccessWithMessage(c, 200, "Order moved to "+req.Status)
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errCancelConflict is returned when the order's status changed before it was cancelled
+var errCancelConflict = errors.New("order status changed while cancelling")
+
+// cancelOrder cancels an order, provided it still has the status it was loaded with,
+// together with what hangs off it: the orders merged onto its truck are released to be
+// queued on their own, a merged order leaves its carrier, and the items of a shipment go
+// back to its split order to be shipped again. A split order with open shipments cannot
+// be cancelled. On failure the request is answered and false returned.
+func cancelOrder(ctx context.Context, c *fiber.Ctx, order *models.Order) (bool, error) {
+	collection := database.GetMongoCollection("orders")
+	userID := middleware.GetUserID(c)
+
+	if order.Status == models.OrderStatusCancelled {
+		return false, response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order is already cancelled")
+	}
+	if order.ShipmentCount > 0 {
+		open, err := collection.CountDocuments(ctx, bson.M{
+			"shipment_of": order.ID.Hex(),
+			"status":      bson.M{"$nin": []string{models.OrderStatusCompleted, models.OrderStatusCancelled}},
+		})
+		if err != nil {
+			return false, response.Error(c, 500, "Failed to cancel order")
+		}
+		if open > 0 {
+			return false, response.ErrorCode(c, 400, response.CodeOrderSplit, "The order has open shipments, cancel them first")
+		}
+	}
+
+	now := time.Now()
+	err := database.WithTransaction(ctx, func(txCtx context.Context) error {
+		result, err := collection.UpdateOne(txCtx,
+			bson.M{"_id": order.ID, "status": order.Status},
+			bson.M{
+				"$set":   bson.M{"status": models.OrderStatusCancelled, "updated_at": now},
+				"$unset": bson.M{"merged_orders": "", "loaded_with": ""},
+				"$push":  bson.M{"status_history": models.NewStatusChange(models.OrderStatusCancelled, userID)},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errCancelConflict
+		}
+
+		for _, id := range order.MergedOrders {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				continue
+			}
+			if _, err := collection.UpdateOne(txCtx,
+				bson.M{"_id": objID, "loaded_with": order.ID.Hex()},
+				bson.M{"$set": bson.M{"updated_at": now}, "$unset": bson.M{"loaded_with": ""}},
+			); err != nil {
+				return err
+			}
+		}
+
+		if carrierID, err := primitive.ObjectIDFromHex(order.LoadedWith); err == nil {
+			if _, err := collection.UpdateOne(txCtx,
+				bson.M{"_id": carrierID},
+				bson.M{"$set": bson.M{"updated_at": now}, "$pull": bson.M{"merged_orders": order.ID.Hex()}},
+			); err != nil {
+				return err
+			}
+		}
+
+		if parentID, err := primitive.ObjectIDFromHex(order.ShipmentOf); err == nil {
+			unshipped := bson.M{}
+			for _, item := range order.Items {
+				key := fmt.Sprintf("items.%d.shipped", item.ParentItem)
+				amount, _ := unshipped[key].(int)
+				unshipped[key] = amount - item.Quantity
+			}
+			if len(unshipped) > 0 {
+				if _, err := collection.UpdateOne(txCtx,
+					bson.M{"_id": parentID},
+					bson.M{"$inc": unshipped, "$set": bson.M{"updated_at": now}},
+				); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == errCancelConflict {
+		return false, response.ErrorCode(c, 409, response.CodeConflict, "Order status changed meanwhile")
+	}
+	if err != nil {
+		return false, response.Error(c, 500, "Failed to cancel order")
+	}
+
+	go notification.NotifyStatusChange(order.ID, models.OrderStatusCancelled)
+	if parentID, err := primitive.ObjectIDFromHex(order.ShipmentOf); err == nil {
+		completeSplitOrder(ctx, parentID, userID)
+	}
+
+	details := map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"from":         order.Status,
+	}
+	if len(order.MergedOrders) > 0 {
+		details["released_orders"] = order.MergedOrders
+	}
+	audit.Log(c, audit.ActionOrderCancel, "order", order.ID.Hex(), details)
+	return true, nil
+}
+
+// RequestCancel asks for the cancellation of an unpaid order by invoice token. The
+// request waits for an admin; one order has at most one request waiting.
+func (h *ClientHandler) RequestCancel(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	if token == "" {
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
+	}
+
+	type CancelRequestBody struct {
+		Reason string `json:"reason"`
+	}
+
+	var req CancelRequestBody
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Reason is required")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"invoice_token": token}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if order.Status != models.OrderStatusPending {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Only an unpaid order can be cancelled")
+	}
+
+	collection := database.GetMongoCollection("cancel_requests")
+	if collection.FindOne(ctx, bson.M{"order_id": order.ID.Hex(), "status": models.CancelRequestPending}).Err() == nil {
+		return response.ErrorCode(c, 409, response.CodeCancelPending, "A cancellation request is already waiting for review")
+	}
+
+	request := models.NewCancelRequest()
+	request.OrderID = order.ID.Hex()
+	request.OrderNumber = order.OrderNumber
+	request.SalesID = order.SalesID
+	request.Reason = req.Reason
+	if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+		sales := &models.Sales{}
+		if database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales) == nil {
+			request.SalesName = sales.Name
+			request.SalesPhone = sales.Phone
+		}
+	}
+
+	if _, err := collection.InsertOne(ctx, request); err != nil {
+		return writeFailed(c, err, "Failed to submit cancellation request")
+	}
+
+	return response.Success(c, 201, request)
+}
+
+// ListCancelRequests returns cancellation requests, newest first, filtered by status
+func (h *OrderHandler) ListCancelRequests(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		filter["order_id"] = orderID
+	}
+
+	collection := database.GetMongoCollection("cancel_requests")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch cancellation requests")
+	}
+	defer cursor.Close(ctx)
+
+	var requests []models.CancelRequest
+	if err := cursor.All(ctx, &requests); err != nil {
+		return response.Error(c, 500, "Failed to decode cancellation requests")
+	}
+	requests, more := trimPage(pq, requests)
+
+	return response.SuccessWithPagination(c, 200, requests, pq.pagination(total, more))
+}
+
+// ReviewCancelRequest approves or denies a cancellation request. Approval cancels the
+// order, which must still be unpaid. The requester is told the outcome on WhatsApp.
+func (h *OrderHandler) ReviewCancelRequest(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type ReviewRequest struct {
+		Action string `json:"action"` // "approve" or "deny"
+		Note   string `json:"note"`
+	}
+
+	var req ReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Action != "approve" && req.Action != "deny" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Action must be approve or deny")
+	}
+
+	collection := database.GetMongoCollection("cancel_requests")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	request := &models.CancelRequest{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(request); err != nil {
+		return response.NotFoundCode(c, response.CodeCancelNotFound, "Cancellation request not found")
+	}
+	if request.Status != models.CancelRequestPending {
+		return response.ErrorCode(c, 409, response.CodeConflict, "Cancellation request was already reviewed")
+	}
+
+	orders := database.GetMongoCollection("orders")
+	orderObjID, _ := primitive.ObjectIDFromHex(request.OrderID)
+	order := &models.Order{}
+	if err := orders.FindOne(ctx, bson.M{"_id": orderObjID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if req.Action == "approve" && order.Status != models.OrderStatusPending {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "The order is "+order.Status+" and can no longer be cancelled, deny the request")
+	}
+
+	// Settle the request first so two reviewers cannot both answer it
+	now := time.Now()
+	status := models.CancelRequestDenied
+	if req.Action == "approve" {
+		status = models.CancelRequestApproved
+	}
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "status": models.CancelRequestPending},
+		bson.M{"$set": bson.M{
+			"status":      status,
+			"review_note": req.Note,
+			"reviewed_by": middleware.GetUserID(c),
+			"reviewed_at": now,
+			"updated_at":  now,
+		}},
+	)
+	if err != nil {
+		return response.Error(c, 500, "Failed to review cancellation request")
+	}
+	if result.ModifiedCount == 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "Cancellation request was already reviewed")
+	}
+
+	if status == models.CancelRequestApproved {
+		if cancelled, err := cancelOrder(ctx, c, order); !cancelled {
+			// The order moved on or could not be cancelled; put the request back for another look
+			collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+				"$set":   bson.M{"status": models.CancelRequestPending, "updated_at": now},
+				"$unset": bson.M{"review_note": "", "reviewed_by": "", "reviewed_at": ""},
+			})
+			return err
+		}
+	}
+
+	request.Status = status
+	request.ReviewNote = req.Note
+	request.ReviewedBy = middleware.GetUserID(c)
+	request.ReviewedAt = &now
+	if request.SalesPhone != "" {
+		if _, err := notification.SendCancelRequestOutcome(request.SalesPhone, order, request); err != nil {
+			log.Printf("[Order] Failed to send cancellation outcome of %s: %v", order.OrderNumber, err)
+		}
+	}
+
+	audit.Log(c, audit.ActionCancelReview, "order", request.OrderID, map[string]interface{}{
+		"order_number":      request.OrderNumber,
+		"cancel_request_id": objID.Hex(),
+		"decision":          status,
+	})
+
+	return response.Success(c, 200, request)
+}
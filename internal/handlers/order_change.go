@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/orderchange"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/uom"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChangeItemsRequest is an edit of the quantities of an order's items
+type ChangeItemsRequest struct {
+	Items []struct {
+		Index    int `json:"index"`    // 0-based position in the order's items
+		Quantity int `json:"quantity"` // 0 removes the item
+	} `json:"items"`
+	Reason string `json:"reason"`
+}
+
+// ChangeItems stages an edit of the item quantities of a pending order. The invoice was
+// already sent, so the edit only takes effect once the sales rep accepts it on the
+// invoice page, reached from the WhatsApp message sent now; until then payment proofs
+// cannot be uploaded. A new edit replaces a staged one. Edits left unanswered for
+// CLIENT_CHANGE_TIMEOUT are applied or discarded, by CLIENT_CHANGE_TIMEOUT_ACTION.
+func (h *OrderHandler) ChangeItems(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req ChangeItemsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if len(req.Items) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least one item change is required")
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if order.Status != models.OrderStatusPending {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Only the items of a pending order can be changed")
+	}
+	if order.ShipmentCount > 0 {
+		return response.ErrorCode(c, 400, response.CodeOrderSplit, "The order is split into shipments")
+	}
+
+	// Edits are relative to the order as applied; a staged change is replaced, not stacked
+	quantities := map[int]int{}
+	for _, item := range req.Items {
+		if item.Index < 0 || item.Index >= len(order.Items) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, fmt.Sprintf("Item %d does not exist", item.Index))
+		}
+		if item.Quantity < 0 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Quantity cannot be negative")
+		}
+		quantities[item.Index] = item.Quantity
+	}
+
+	units, err := uom.Load(ctx)
+	if err != nil {
+		return unitsUnavailable(c)
+	}
+	change := &models.OrderChange{
+		ID:            primitive.NewObjectID().Hex(),
+		Items:         []models.OrderItem{},
+		Changes:       []models.ItemChange{},
+		PreviousTotal: order.TotalPrice,
+		Reason:        req.Reason,
+		RequestedBy:   middleware.GetUserID(c),
+		RequestedAt:   time.Now(),
+	}
+	for i, item := range order.Items {
+		quantity, changed := quantities[i]
+		if changed && quantity != item.Quantity {
+			change.Changes = append(change.Changes, models.ItemChange{
+				Index:       i,
+				ProductName: item.ProductName,
+				Unit:        item.Unit,
+				From:        item.Quantity,
+				To:          quantity,
+			})
+			item.Quantity = quantity
+			item.Subtotal = item.UnitPrice * float64(quantity)
+			item.BaseQuantity, _, _ = units.ToBase(float64(quantity), item.Unit)
+		}
+		if item.Quantity == 0 {
+			continue
+		}
+		change.Items = append(change.Items, item)
+		change.Quantity += item.Quantity
+		change.Subtotal += item.Subtotal
+	}
+	if len(change.Changes) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "The quantities are unchanged")
+	}
+	if len(change.Items) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least one item must remain, cancel the order instead")
+	}
+	change.TotalPrice = change.Subtotal + order.DeliveryFee
+	if timeout := config.Cfg.Client.ChangeTimeout; timeout > 0 {
+		expires := change.RequestedAt.Add(timeout)
+		change.ExpiresAt = &expires
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "status": models.OrderStatusPending},
+		bson.M{"$set": bson.M{"pending_change": change, "updated_at": change.RequestedAt}},
+	)
+	if err != nil {
+		return response.Error(c, 500, "Failed to stage order change")
+	}
+	if result.MatchedCount == 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "Order status changed meanwhile")
+	}
+
+	// The re-confirmation goes to the sales rep, who accepted the invoice
+	waLink := ""
+	if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+		sales := &models.Sales{}
+		if database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales) == nil && sales.Phone != "" {
+			waLink, err = notification.SendOrderChangeRequest(sales.Phone, sales.Name, order, change, orderchange.AutoApply())
+			if err != nil {
+				log.Printf("[Order] Failed to send change request of %s: %v", order.OrderNumber, err)
+			}
+		}
+	}
+
+	audit.Log(c, audit.ActionOrderChange, "order", objID.Hex(), map[string]interface{}{
+		"order_number":   order.OrderNumber,
+		"changes":        change.Changes,
+		"previous_total": change.PreviousTotal,
+		"total_price":    change.TotalPrice,
+	})
+
+	return response.Success(c, 202, fiber.Map{
+		"pending_change": change,
+		"whatsapp_link":  waLink,
+	})
+}
+
+// WithdrawChange drops the staged item change of an order
+func (h *OrderHandler) WithdrawChange(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if order.PendingChange == nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "The order has no pending change")
+	}
+
+	err = orderchange.Discard(ctx, objID, order.PendingChange, models.ChangeWithdrawn)
+	if errors.Is(err, orderchange.ErrNotPending) {
+		return response.ErrorCode(c, 409, response.CodeConflict, "The change was answered meanwhile")
+	}
+	if err != nil {
+		return response.Error(c, 500, "Failed to withdraw order change")
+	}
+
+	audit.Log(c, audit.ActionOrderChange, "order", objID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"withdrawn":    order.PendingChange.ID,
+	})
+
+	return response.SuccessWithMessage(c, 200, "Change withdrawn")
+}
+
+// ConfirmChange records the sales rep's answer to the staged change of an order, by
+// invoice token: accept applies it, reject keeps the order as it was
+func (h *ClientHandler) ConfirmChange(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	if token == "" {
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
+	}
+
+	type ConfirmChangeRequest struct {
+		Action   string `json:"action"`    // "accept" or "reject"
+		ChangeID string `json:"change_id"` // The change shown, so a replaced one is not answered
+	}
+
+	var req ConfirmChangeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Action != "accept" && req.Action != "reject" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Action must be accept or reject")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"invoice_token": token}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	change := order.PendingChange
+	if change == nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "The order has no pending change")
+	}
+	if req.ChangeID != "" && req.ChangeID != change.ID {
+		return response.ErrorCode(c, 409, response.CodeConflict, "The change was replaced, review the new one")
+	}
+
+	if req.Action == "accept" {
+		err := orderchange.Apply(ctx, order.ID, change, models.ChangeAccepted)
+		if errors.Is(err, orderchange.ErrNotPending) {
+			return response.ErrorCode(c, 409, response.CodeConflict, "The change was resolved meanwhile")
+		}
+		if err != nil {
+			return response.Error(c, 500, "Failed to apply order change")
+		}
+		return response.Success(c, 200, fiber.Map{
+			"message":     "Change accepted",
+			"items":       change.Items,
+			"total_price": change.TotalPrice,
+		})
+	}
+
+	err := orderchange.Discard(ctx, order.ID, change, models.ChangeRejected)
+	if errors.Is(err, orderchange.ErrNotPending) {
+		return response.ErrorCode(c, 409, response.CodeConflict, "The change was resolved meanwhile")
+	}
+	if err != nil {
+		return response.Error(c, 500, "Failed to reject order change")
+	}
+	return response.Success(c, 200, fiber.Map{
+		"message":     "Change rejected",
+		"items":       order.Items,
+		"total_price": order.TotalPrice,
+	})
+}
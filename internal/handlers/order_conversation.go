@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Conversation entry directions
+const (
+	conversationOutbound = "outbound"
+	conversationInbound  = "inbound"
+)
+
+// ConversationEntry is one message of an order's WhatsApp conversation
+type ConversationEntry struct {
+	ID        string    `json:"id"`
+	Direction string    `json:"direction"` // outbound or inbound
+	At        time.Time `json:"at"`
+	Phone     string    `json:"phone"`
+	Text      string    `json:"text"`
+	Type      string    `json:"type,omitempty"`       // Outbound notification type
+	Status    string    `json:"status,omitempty"`     // Outbound delivery status
+	SentVia   string    `json:"sent_via,omitempty"`   // Outbound channel
+	MatchedBy string    `json:"matched_by,omitempty"` // How an inbound reply was linked to the order
+}
+
+// Conversation returns the notifications sent about an order and the replies linked to
+// it, oldest first, so support can read the whole exchange in one place
+func (h *OrderHandler) Conversation(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	if database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": objID}).Err() != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	filter := bson.M{"order_id": objID.Hex()}
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	cursor, err := database.GetMongoCollection("notifications").Find(ctx, filter, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch conversation")
+	}
+	var notifs []notification.Notification
+	err = cursor.All(ctx, &notifs)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode conversation")
+	}
+
+	cursor, err = database.GetMongoCollection(notification.InboundCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "received_at", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch conversation")
+	}
+	var replies []models.InboundMessage
+	err = cursor.All(ctx, &replies)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode conversation")
+	}
+
+	entries := []ConversationEntry{}
+	for _, notif := range notifs {
+		at := notif.CreatedAt
+		if notif.SentAt != nil {
+			at = *notif.SentAt
+		}
+		entries = append(entries, ConversationEntry{
+			ID:        notif.ID.Hex(),
+			Direction: conversationOutbound,
+			At:        at,
+			Phone:     notif.Phone,
+			Text:      notif.Message,
+			Type:      string(notif.Type),
+			Status:    notif.Status,
+			SentVia:   notif.SentVia,
+		})
+	}
+	for _, reply := range replies {
+		entries = append(entries, ConversationEntry{
+			ID:        reply.ID.Hex(),
+			Direction: conversationInbound,
+			At:        reply.ReceivedAt,
+			Phone:     reply.Phone,
+			Text:      reply.Text,
+			MatchedBy: reply.MatchedBy,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].At.Before(entries[j].At)
+	})
+
+	return response.Success(c, 200, entries)
+}
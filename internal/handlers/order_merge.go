@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errMergeConflict is returned when one of the merged orders changed while merging
+var errMergeConflict = errors.New("order changed during merge")
+
+// MergeLoadingRequest lists the orders that share one truck. The first order carries
+// the queue entry; the driver defaults to the one already entered on it.
+type MergeLoadingRequest struct {
+	OrderIDs     []string `json:"order_ids"`
+	DriverName   string   `json:"driver_name"`
+	DriverPhone  string   `json:"driver_phone"`
+	VehiclePlate string   `json:"vehicle_plate"`
+}
+
+// mergedOrders returns the orders carried along with an order, in merge order
+func mergedOrders(ctx context.Context, order *models.Order) []models.Order {
+	merged := []models.Order{}
+	collection := database.GetMongoCollection("orders")
+	for _, id := range order.MergedOrders {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		other := models.Order{}
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&other); err == nil {
+			merged = append(merged, other)
+		}
+	}
+	return merged
+}
+
+// MergeLoading links confirmed orders of the same sales and customer to one queue entry,
+// so they are loaded on one truck under a combined delivery note. The other orders keep
+// their status until the truck finishes loading, then complete with the first one.
+func (h *OrderHandler) MergeLoading(c *fiber.Ctx) error {
+	var req MergeLoadingRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	seen := map[string]bool{}
+	ids := []primitive.ObjectID{}
+	for _, id := range req.OrderIDs {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, objID)
+		}
+	}
+	if len(ids) < 2 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least two orders are required")
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	orders := make([]*models.Order, len(ids))
+	for i, objID := range ids {
+		orders[i] = &models.Order{}
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(orders[i]); err != nil {
+			return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+		}
+	}
+
+	carrier := orders[0]
+	for _, order := range orders {
+		switch {
+		case order.Status != models.OrderStatusConfirmed:
+			return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus,
+				fmt.Sprintf("Order %s is not confirmed or already queued", order.OrderNumber))
+		case order.ShipmentCount > 0:
+			return response.ErrorCode(c, 400, response.CodeOrderSplit,
+				fmt.Sprintf("Order %s is split, merge its shipments instead", order.OrderNumber))
+		case order.LoadedWith != "" || len(order.MergedOrders) > 0:
+			return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus,
+				fmt.Sprintf("Order %s is already loaded with other orders", order.OrderNumber))
+		case order.SalesID != carrier.SalesID || order.CustomerPhone != carrier.CustomerPhone:
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Only orders of the same customer can share a truck")
+		}
+	}
+
+	driverName := strings.TrimSpace(req.DriverName)
+	driverPhone := strings.TrimSpace(req.DriverPhone)
+	vehiclePlate := strings.TrimSpace(req.VehiclePlate)
+	if driverName == "" && driverPhone == "" && vehiclePlate == "" {
+		driverName, driverPhone, vehiclePlate = carrier.DriverName, carrier.DriverPhone, carrier.VehiclePlate
+	}
+	if driverName == "" || driverPhone == "" || vehiclePlate == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Driver name, phone, and vehicle plate are required")
+	}
+
+	update, err := queueBarcodeFields(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to generate QR code")
+	}
+	now := time.Now()
+	merged := []string{}
+	for _, order := range orders[1:] {
+		merged = append(merged, order.ID.Hex())
+	}
+	update["driver_name"] = driverName
+	update["driver_phone"] = driverPhone
+	update["vehicle_plate"] = vehiclePlate
+	update["merged_orders"] = merged
+	update["updated_at"] = now
+	if carrier.DriverFilledAt == nil {
+		update["driver_filled_at"] = now
+	}
+
+	// Only orders nobody queued or merged meanwhile take part
+	unchanged := func(id primitive.ObjectID) bson.M {
+		return bson.M{
+			"_id":           id,
+			"status":        models.OrderStatusConfirmed,
+			"loaded_with":   bson.M{"$exists": false},
+			"merged_orders": bson.M{"$exists": false},
+		}
+	}
+	err = database.WithTransaction(ctx, func(txCtx context.Context) error {
+		result, err := collection.UpdateOne(txCtx, unchanged(carrier.ID), bson.M{"$set": update})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errMergeConflict
+		}
+		for _, order := range orders[1:] {
+			result, err := collection.UpdateOne(txCtx, unchanged(order.ID), bson.M{"$set": bson.M{
+				"loaded_with":   carrier.ID.Hex(),
+				"queue_barcode": "", // Scanned through the carrying order only
+				"driver_name":   driverName,
+				"driver_phone":  driverPhone,
+				"vehicle_plate": vehiclePlate,
+				"updated_at":    now,
+			}})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errMergeConflict
+			}
+		}
+		return nil
+	})
+	if err == errMergeConflict {
+		return response.ErrorCode(c, 409, response.CodeConflict, "One of the orders was changed meanwhile, reload them and merge again")
+	}
+	if err != nil {
+		return response.Error(c, 500, "Failed to merge orders")
+	}
+
+	numbers := []string{}
+	for _, order := range orders {
+		numbers = append(numbers, order.OrderNumber)
+	}
+	audit.Log(c, audit.ActionOrderMerge, "order", carrier.ID.Hex(), map[string]interface{}{
+		"order_numbers": numbers,
+		"vehicle_plate": vehiclePlate,
+	})
+
+	collection.FindOne(ctx, bson.M{"_id": carrier.ID}).Decode(carrier)
+	loaded := mergedOrders(ctx, carrier)
+	media.SignOrder(carrier)
+	for i := range loaded {
+		media.SignOrder(&loaded[i])
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"message":        "Orders merged for combined loading",
+		"order":          carrier,
+		"merged_orders":  loaded,
+		"queue_barcode":  carrier.QueueBarcode,
+		"queue_qrcode":   carrier.QueueQRCode,
+		"barcode_format": carrier.BarcodeFormat,
+		"barcode_image":  carrier.BarcodeImage,
+	})
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListNotes returns the notes thread of an order, oldest first
+func (h *OrderHandler) ListNotes(c *fiber.Ctx) error {
+	orderID := c.Params("id")
+	if _, err := primitive.ObjectIDFromHex(orderID); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	filter := bson.M{"order_id": orderID}
+	if visibility := c.Query("visibility"); visibility != "" {
+		filter["visibility"] = visibility
+	}
+
+	collection := database.GetMongoCollection("order_notes")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch notes")
+	}
+	defer cursor.Close(ctx)
+
+	notes := []models.OrderNote{}
+	if err := cursor.All(ctx, &notes); err != nil {
+		return response.Error(c, 500, "Failed to fetch notes")
+	}
+
+	return response.Success(c, 200, notes)
+}
+
+// AddNote adds a note to an order (multipart: text, visibility, attachment)
+func (h *OrderHandler) AddNote(c *fiber.Ctx) error {
+	orderID := c.Params("id")
+	objID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	text := c.FormValue("text")
+	visibility := c.FormValue("visibility", models.NoteVisibilityInternal)
+
+	if text == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Text is required")
+	}
+	if visibility != models.NoteVisibilityInternal && visibility != models.NoteVisibilityShared {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Visibility must be internal or shared")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	count, _ := database.GetMongoCollection("orders").CountDocuments(ctx, bson.M{"_id": objID})
+	if count == 0 {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	userID := middleware.GetUserID(c)
+	author := &models.User{}
+	if authorObjID, err := primitive.ObjectIDFromHex(userID); err == nil {
+		database.GetMongoCollection("users").FindOne(ctx, bson.M{"_id": authorObjID}).Decode(author)
+	}
+
+	note := models.NewOrderNote()
+	note.OrderID = orderID
+	note.AuthorID = userID
+	note.AuthorName = author.DisplayName
+	note.Text = text
+	note.Visibility = visibility
+
+	if formFile, err := c.FormFile("attachment"); err == nil {
+		if !file.IsAllowedFileType(formFile.Filename) {
+			return response.ErrorCode(c, 400, response.CodeFileTypeNotAllowed, "File type not allowed")
+		}
+		uploadResult, err := file.UploadFile(formFile, file.CategoryAttachment)
+		if err != nil {
+			return uploadFailed(c, err, "Failed to upload attachment")
+		}
+		note.Attachment = uploadResult.Image()
+	}
+
+	if _, err := database.GetMongoCollection("order_notes").InsertOne(ctx, note); err != nil {
+		return response.Error(c, 500, "Failed to add note")
+	}
+
+	return response.Success(c, 201, note)
+}
+
+// DeleteNote deletes a note; only its author or an admin may delete it
+func (h *OrderHandler) DeleteNote(c *fiber.Ctx) error {
+	orderID := c.Params("id")
+	noteObjID, err := primitive.ObjectIDFromHex(c.Params("note_id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid note ID format")
+	}
+
+	collection := database.GetMongoCollection("order_notes")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	note := &models.OrderNote{}
+	err = collection.FindOne(ctx, bson.M{"_id": noteObjID, "order_id": orderID}).Decode(note)
+	if err != nil {
+		return response.NotFound(c, "Note not found")
+	}
+
+	role := middleware.GetUserRole(c)
+	if note.AuthorID != middleware.GetUserID(c) && role != models.RoleSuperAdmin && role != models.RoleAdmin {
+		return response.ErrorCode(c, 403, response.CodeInsufficientPermissions, "Only the author or an admin can delete this note")
+	}
+
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": noteObjID}); err != nil {
+		return response.Error(c, 500, "Failed to delete note")
+	}
+
+	if note.Attachment != nil {
+		file.DeleteFile(note.Attachment.PublicID)
+	}
+
+	audit.Log(c, audit.ActionOrderNoteDelete, "order_note", note.ID.Hex(), map[string]interface{}{
+		"order_id": orderID,
+		"author":   note.AuthorID,
+	})
+
+	return response.SuccessWithMessage(c, 200, "Note deleted")
+}
+
+// sharedNotes returns the notes of an order that are visible to sales
+func sharedNotes(ctx context.Context, orderID string) ([]models.OrderNote, error) {
+	filter := bson.M{"order_id": orderID, "visibility": models.NoteVisibilityShared}
+	cursor, err := database.GetMongoCollection("order_notes").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	notes := []models.OrderNote{}
+	if err := cursor.All(ctx, &notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
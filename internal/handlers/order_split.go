@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errSplitConflict is returned when a split order changed while it was being split
+var errSplitConflict = errors.New("order changed during split")
+
+// SplitItem is the quantity of one item of the order carried by a shipment
+type SplitItem struct {
+	Index    int `json:"index"` // Position of the item in the order's items
+	Quantity int `json:"quantity"`
+}
+
+// SplitShipment is one truck of a split order. The driver can also be entered later
+// with POST /orders/:id/driver on the shipment.
+type SplitShipment struct {
+	Items        []SplitItem `json:"items"`
+	DriverName   string      `json:"driver_name"`
+	DriverPhone  string      `json:"driver_phone"`
+	VehiclePlate string      `json:"vehicle_plate"`
+}
+
+// SplitRequest is the body of an order split
+type SplitRequest struct {
+	Shipments []SplitShipment `json:"shipments"`
+}
+
+// ItemProgress is how much of an item of a split order was loaded
+type ItemProgress struct {
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+	Unit        string `json:"unit"`
+	Shipped     int    `json:"shipped"`   // Allocated to shipments
+	Fulfilled   int    `json:"fulfilled"` // Loaded by finished shipments
+	Remaining   int    `json:"remaining"` // Not loaded yet
+	Unallocated int    `json:"unallocated"`
+}
+
+// splitProgress returns the progress of every item of a split order
+func splitProgress(order *models.Order) []ItemProgress {
+	progress := make([]ItemProgress, len(order.Items))
+	for i, item := range order.Items {
+		progress[i] = ItemProgress{
+			ProductName: item.ProductName,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			Shipped:     item.Shipped,
+			Fulfilled:   item.Fulfilled,
+			Remaining:   item.Quantity - item.Fulfilled,
+			Unallocated: item.Quantity - item.Shipped,
+		}
+	}
+	return progress
+}
+
+// orderShipments returns the shipments of a split order by shipment number
+func orderShipments(ctx context.Context, orderID string) ([]models.Order, error) {
+	cursor, err := database.GetMongoCollection("orders").Find(ctx, bson.M{"shipment_of": orderID},
+		options.Find().SetSort(bson.D{{Key: "shipment_number", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	shipments := []models.Order{}
+	if err := cursor.All(ctx, &shipments); err != nil {
+		return nil, err
+	}
+	return shipments, nil
+}
+
+// Split divides a paid order into shipments, each queued, loaded and given a delivery
+// note as an order of its own. The order can be split again until all of it is
+// allocated, and completes once every item was loaded by a finished shipment.
+func (h *OrderHandler) Split(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req SplitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if len(req.Shipments) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least one shipment is required")
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if order.ShipmentOf != "" {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "A shipment cannot be split")
+	}
+	if order.Status != models.OrderStatusConfirmed {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Only paid orders that are not queued yet can be split")
+	}
+
+	// Allocate the shipments' quantities on top of the earlier shipments
+	shipped := make([]int, len(order.Items))
+	for i, item := range order.Items {
+		shipped[i] = item.Shipped
+	}
+	for n, shipment := range req.Shipments {
+		if len(shipment.Items) == 0 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, fmt.Sprintf("Shipment %d has no items", n+1))
+		}
+		hasDriver := shipment.DriverName != "" || shipment.DriverPhone != "" || shipment.VehiclePlate != ""
+		if hasDriver && (shipment.DriverName == "" || shipment.DriverPhone == "" || shipment.VehiclePlate == "") {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed,
+				fmt.Sprintf("Shipment %d needs the driver name, phone, and vehicle plate, or none of them", n+1))
+		}
+		for _, item := range shipment.Items {
+			if item.Index < 0 || item.Index >= len(order.Items) || item.Quantity <= 0 {
+				return response.ErrorCode(c, 400, response.CodeValidationFailed,
+					fmt.Sprintf("Shipment %d has an invalid item or quantity", n+1))
+			}
+			shipped[item.Index] += item.Quantity
+			if shipped[item.Index] > order.Items[item.Index].Quantity {
+				return response.ErrorCodeWithData(c, 400, response.CodeSplitExceeded,
+					fmt.Sprintf("Shipments carry more %s than the order has left", order.Items[item.Index].ProductName), fiber.Map{
+						"index":    item.Index,
+						"progress": splitProgress(order),
+					})
+			}
+		}
+	}
+
+	userID := middleware.GetUserID(c)
+	now := time.Now()
+	shipments := make([]interface{}, 0, len(req.Shipments))
+	created := make([]*models.Order, 0, len(req.Shipments))
+	for n, request := range req.Shipments {
+		shipment := models.NewOrder()
+		shipment.ShipmentOf = order.ID.Hex()
+		shipment.ShipmentNumber = order.ShipmentCount + n + 1
+		shipment.OrderNumber = fmt.Sprintf("%s-%d", order.OrderNumber, shipment.ShipmentNumber)
+		shipment.SalesID = order.SalesID
+		shipment.CustomerName = order.CustomerName
+		shipment.CustomerPhone = order.CustomerPhone
+		shipment.DeliveryAddress = order.DeliveryAddress
+		shipment.DeliveryRecipients = order.DeliveryRecipients
+		shipment.Tags = order.Tags
+
+		// Paid through the split order, which also carries the revenue
+		shipment.Status = models.OrderStatusConfirmed
+		shipment.PaymentStatus = models.PaymentStatusVerified
+		shipment.PaymentVerifiedAt = order.PaymentVerifiedAt
+		shipment.PaymentVerifiedBy = order.PaymentVerifiedBy
+		shipment.StatusHistory = []models.StatusChange{models.NewStatusChange(models.OrderStatusConfirmed, userID)}
+
+		shipment.Items = []models.OrderItem{}
+		for _, item := range request.Items {
+			source := order.Items[item.Index]
+			shipment.Items = append(shipment.Items, models.OrderItem{
+				ProductName: source.ProductName,
+				Quantity:    item.Quantity,
+				UnitPrice:   source.UnitPrice,
+				Unit:        source.Unit,
+				Subtotal:    source.UnitPrice * float64(item.Quantity),
+				Category:    source.Category,
+				ParentItem:  item.Index,
+
+				BaseQuantity: source.BaseQuantity / float64(source.Quantity) * float64(item.Quantity),
+				BaseUnit:     source.BaseUnit,
+			})
+			shipment.Quantity += item.Quantity
+		}
+
+		if request.DriverName != "" {
+			fields, err := queueBarcodeFields(ctx)
+			if err != nil {
+				return response.Error(c, 500, "Failed to generate QR code")
+			}
+			shipment.QueueBarcode = fields["queue_barcode"].(string)
+			shipment.QueueQRCode = fields["queue_qrcode"].(string)
+			shipment.BarcodeFormat = fields["barcode_format"].(string)
+			shipment.BarcodeImage = fields["barcode_image"].(string)
+			shipment.DriverName = strings.TrimSpace(request.DriverName)
+			shipment.DriverPhone = strings.TrimSpace(request.DriverPhone)
+			shipment.VehiclePlate = strings.TrimSpace(request.VehiclePlate)
+			shipment.DriverFilledAt = &now
+		}
+
+		shipments = append(shipments, shipment)
+		created = append(created, shipment)
+	}
+
+	update := bson.M{
+		"shipment_count": order.ShipmentCount + len(req.Shipments),
+		"queue_barcode":  "", // The split order itself is no longer queued
+		"updated_at":     now,
+	}
+	for i := range order.Items {
+		update[fmt.Sprintf("items.%d.shipped", i)] = shipped[i]
+	}
+
+	// The shipment count guards against a concurrent split allocating the same items
+	err = database.WithTransaction(ctx, func(txCtx context.Context) error {
+		result, err := collection.UpdateOne(txCtx,
+			bson.M{"_id": order.ID, "status": models.OrderStatusConfirmed, "shipment_count": countFilter(order.ShipmentCount)},
+			bson.M{"$set": update})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errSplitConflict
+		}
+		_, err = collection.InsertMany(txCtx, shipments)
+		return err
+	})
+	if err == errSplitConflict {
+		return response.ErrorCode(c, 409, response.CodeConflict, "The order was changed meanwhile, reload it and split again")
+	}
+	if err != nil {
+		return writeFailed(c, err, "Failed to split order")
+	}
+
+	numbers := make([]string, len(created))
+	for i, shipment := range created {
+		numbers[i] = shipment.OrderNumber
+	}
+	audit.Log(c, audit.ActionOrderSplit, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"shipments":    numbers,
+	})
+
+	collection.FindOne(ctx, bson.M{"_id": order.ID}).Decode(order)
+	stored, err := orderShipments(ctx, order.ID.Hex())
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch shipments")
+	}
+
+	return response.Success(c, 201, fiber.Map{
+		"order":     order,
+		"progress":  splitProgress(order),
+		"shipments": stored,
+	})
+}
+
+// Shipments returns the shipments of a split order with how much of each item they loaded
+func (h *OrderHandler) Shipments(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	shipments, err := orderShipments(ctx, order.ID.Hex())
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch shipments")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"order_id":     order.ID.Hex(),
+		"order_number": order.OrderNumber,
+		"status":       order.Status,
+		"progress":     splitProgress(order),
+		"shipments":    shipments,
+	})
+}
+
+// countFilter matches a counter that is still n; a missing field counts as 0
+func countFilter(n int) interface{} {
+	if n == 0 {
+		return bson.M{"$in": []interface{}{nil, 0}}
+	}
+	return n
+}
+
+// fulfillShipment books a finished shipment on its split order, and completes the split
+// order once every item was loaded and no shipment is left. Every path completing an
+// order calls it for shipments.
+func fulfillShipment(ctx context.Context, shipment *models.Order, changedBy string) {
+	parentID, err := primitive.ObjectIDFromHex(shipment.ShipmentOf)
+	if err != nil {
+		return
+	}
+	collection := database.GetMongoCollection("orders")
+
+	fulfilled := map[int]int{}
+	for _, item := range shipment.Items {
+		fulfilled[item.ParentItem] += item.Quantity
+	}
+	inc := bson.M{}
+	for index, quantity := range fulfilled {
+		inc[fmt.Sprintf("items.%d.fulfilled", index)] = quantity
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": parentID}, bson.M{"$inc": inc, "$set": bson.M{"updated_at": time.Now()}}); err != nil {
+		log.Printf("[Split] Failed to book shipment %s on its order: %v", shipment.OrderNumber, err)
+		return
+	}
+	completeSplitOrder(ctx, parentID, changedBy)
+}
+
+// completeSplitOrder completes a split order once every item was loaded and none of its
+// shipments is open. It is also run when a shipment ends by being cancelled.
+func completeSplitOrder(ctx context.Context, parentID primitive.ObjectID, changedBy string) {
+	collection := database.GetMongoCollection("orders")
+	parent := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"_id": parentID}).Decode(parent); err != nil {
+		return
+	}
+	for _, item := range parent.Items {
+		if item.Fulfilled < item.Quantity {
+			return
+		}
+	}
+	open, _ := collection.CountDocuments(ctx, bson.M{
+		"shipment_of": parentID.Hex(),
+		"status":      bson.M{"$nin": []string{models.OrderStatusCompleted, models.OrderStatusCancelled}},
+	})
+	if open > 0 {
+		return
+	}
+
+	now := time.Now()
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": parentID, "status": bson.M{"$nin": []string{models.OrderStatusCompleted, models.OrderStatusCancelled}}},
+		bson.M{
+			"$set":  bson.M{"status": models.OrderStatusCompleted, "completed_at": now, "updated_at": now},
+			"$push": bson.M{"status_history": models.NewStatusChange(models.OrderStatusCompleted, changedBy)},
+		})
+	if err != nil {
+		log.Printf("[Split] Failed to complete order %s: %v", parent.OrderNumber, err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		go notification.NotifyStatusChange(parentID, models.OrderStatusCompleted)
+	}
+}
+
+// withoutShipments leaves shipments out of an order stats filter, as their split order
+// already counts as the order
+func withoutShipments(filter bson.M) bson.M {
+	filter["shipment_of"] = bson.M{"$exists": false}
+	return filter
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Limits of the item suggestions
+const (
+	maxSuggestionLimit = 50
+	maxSuggestionDays  = 730
+)
+
+// ItemSuggestion is a product name and unit used on order items, with how often and how
+// recently it was used
+type ItemSuggestion struct {
+	ProductName string    `json:"product_name"`
+	Unit        string    `json:"unit"`
+	Uses        int       `json:"uses"`       // Order items with this name and unit
+	LastPrice   float64   `json:"last_price"` // Unit price of the most recent use
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ListPrice   float64   `json:"list_price,omitempty"` // Catalog price of the product with this name
+	InCatalog   bool      `json:"in_catalog"`
+}
+
+// ItemSuggestions autocompletes the product name of an order item being entered. It
+// returns the names and units of the items of orders created in the last days days
+// (default 180) containing q, the most used first, so the form can keep naming
+// consistent instead of adding a new spelling.
+func (h *OrderHandler) ItemSuggestions(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 10)
+	if limit < 1 || limit > maxSuggestionLimit {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "limit must be between 1 and 50")
+	}
+	days := c.QueryInt("days", 180)
+	if days < 1 || days > maxSuggestionDays {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "days must be between 1 and 730")
+	}
+
+	filter := bson.M{
+		"created_at":  bson.M{"$gte": time.Now().AddDate(0, 0, -days)},
+		"shipment_of": bson.M{"$exists": false}, // Shipments repeat the items of their split order
+	}
+	itemFilter := bson.M{"items.product_name": bson.M{"$ne": ""}}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		name := bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+		filter["items.product_name"] = name
+		itemFilter["items.product_name"] = name
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("orders").Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$sort": bson.D{{Key: "created_at", Value: -1}}},
+		{"$unwind": "$items"},
+		{"$match": itemFilter},
+		{"$group": bson.M{
+			"_id":          bson.D{{Key: "name", Value: "$items.product_name"}, {Key: "unit", Value: "$items.unit"}},
+			"uses":         bson.M{"$sum": 1},
+			"last_price":   bson.M{"$first": "$items.unit_price"},
+			"last_used_at": bson.M{"$max": "$created_at"},
+		}},
+		{"$sort": bson.D{{Key: "uses", Value: -1}, {Key: "last_used_at", Value: -1}}},
+		{"$limit": limit},
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch item suggestions")
+	}
+	var rows []struct {
+		ID struct {
+			Name string `bson:"name"`
+			Unit string `bson:"unit"`
+		} `bson:"_id"`
+		Uses       int       `bson:"uses"`
+		LastPrice  float64   `bson:"last_price"`
+		LastUsedAt time.Time `bson:"last_used_at"`
+	}
+	err = cursor.All(ctx, &rows)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode item suggestions")
+	}
+
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.ID.Name)
+	}
+	catalog, err := catalogProducts(ctx, names)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load catalog prices")
+	}
+
+	suggestions := make([]ItemSuggestion, 0, len(rows))
+	for _, row := range rows {
+		suggestion := ItemSuggestion{
+			ProductName: row.ID.Name,
+			Unit:        row.ID.Unit,
+			Uses:        row.Uses,
+			LastPrice:   row.LastPrice,
+			LastUsedAt:  row.LastUsedAt,
+		}
+		if product, found := catalog[strings.ToLower(strings.TrimSpace(row.ID.Name))]; found {
+			suggestion.InCatalog = true
+			suggestion.ListPrice = product.Price
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return response.Success(c, 200, suggestions)
+}
@@ -0,0 +1,2580 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/csat"
+	"bg-go/internal/lib/dailystats"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/lib/geocode"
+	"bg-go/internal/lib/invoiceview"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/numbering"
+	"bg-go/internal/lib/orderchange"
+	"bg-go/internal/lib/otp"
+	"bg-go/internal/lib/outbox"
+	"bg-go/internal/lib/reportbuilder"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/sla"
+	"bg-go/internal/models"
+	"bg-go/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// seedSales stores an active sales rep
+func seedSales(h *testutil.Harness) *models.Sales {
+	sales := models.NewSales()
+	sales.Name = "Budi"
+	sales.Phone = "6281234567890"
+	sales.IsActive = true
+	h.Insert("sales", sales)
+	return sales
+}
+
+// seedOrder stores a pending order of a sales rep, changed by edit before it is stored
+func seedOrder(h *testutil.Harness, sales *models.Sales, edit func(order *models.Order)) *models.Order {
+	order := models.NewOrder()
+	order.OrderNumber = "ORD-TEST-" + order.ID.Hex()[18:]
+	order.SalesID = sales.ID.Hex()
+	order.Status = models.OrderStatusPending
+	order.PaymentStatus = models.PaymentStatusPending
+	order.InvoiceToken = "token-" + order.ID.Hex()
+	order.Items = []models.OrderItem{{ProductName: "Semen", Quantity: 10, UnitPrice: 50000, Unit: "sak", Subtotal: 500000}}
+	order.Quantity = 10
+	order.TotalPrice = 500000
+	if edit != nil {
+		edit(order)
+	}
+	h.Insert("orders", order)
+	return order
+}
+
+func TestCreateOrder(t *testing.T) {
+	items := []map[string]interface{}{
+		{"product_name": "Semen", "quantity": 10, "unit_price": 50000},
+		{"product_name": "Pasir", "quantity": 2, "unit_price": 150000, "unit": "m3"},
+	}
+
+	tests := []struct {
+		name   string
+		role   string
+		body   func(sales *models.Sales) map[string]interface{}
+		status int
+		code   response.Code
+	}{
+		{
+			name: "requires a token",
+			body: func(s *models.Sales) map[string]interface{} {
+				return map[string]interface{}{"sales_id": s.ID.Hex(), "items": items}
+			},
+			status: 401,
+			code:   response.CodeTokenMissing,
+		},
+		{
+			name: "requires an admin",
+			role: models.RoleUser,
+			body: func(s *models.Sales) map[string]interface{} {
+				return map[string]interface{}{"sales_id": s.ID.Hex(), "items": items}
+			},
+			status: 403,
+			code:   response.CodeInsufficientPermissions,
+		},
+		{
+			name:   "requires sales",
+			role:   models.RoleAdmin,
+			body:   func(s *models.Sales) map[string]interface{} { return map[string]interface{}{"items": items} },
+			status: 400,
+			code:   response.CodeValidationFailed,
+		},
+		{
+			name:   "requires items",
+			role:   models.RoleAdmin,
+			body:   func(s *models.Sales) map[string]interface{} { return map[string]interface{}{"sales_id": s.ID.Hex()} },
+			status: 400,
+			code:   response.CodeValidationFailed,
+		},
+		{
+			name: "rejects unknown sales",
+			role: models.RoleAdmin,
+			body: func(s *models.Sales) map[string]interface{} {
+				return map[string]interface{}{"sales_id": models.NewSales().ID.Hex(), "items": items}
+			},
+			status: 400,
+			code:   response.CodeSalesNotFound,
+		},
+		{
+			name: "rejects items without quantity",
+			role: models.RoleAdmin,
+			body: func(s *models.Sales) map[string]interface{} {
+				return map[string]interface{}{
+					"sales_id": s.ID.Hex(),
+					"items":    []map[string]interface{}{{"product_name": "Semen", "quantity": 0, "unit_price": 50000}},
+				}
+			},
+			status: 400,
+			code:   response.CodeValidationFailed,
+		},
+		{
+			name: "rejects unknown units",
+			role: models.RoleAdmin,
+			body: func(s *models.Sales) map[string]interface{} {
+				return map[string]interface{}{
+					"sales_id": s.ID.Hex(),
+					"items":    []map[string]interface{}{{"product_name": "Semen", "quantity": 10, "unit_price": 50000, "unit": "sack"}},
+				}
+			},
+			status: 400,
+			code:   response.CodeUnitUnknown,
+		},
+		{
+			name: "rejects a negative delivery fee",
+			role: models.RoleAdmin,
+			body: func(s *models.Sales) map[string]interface{} {
+				return map[string]interface{}{"sales_id": s.ID.Hex(), "items": items, "delivery_fee": -1}
+			},
+			status: 400,
+			code:   response.CodeValidationFailed,
+		},
+		{
+			name: "creates a pending order",
+			role: models.RoleAdmin,
+			body: func(s *models.Sales) map[string]interface{} {
+				return map[string]interface{}{"sales_id": s.ID.Hex(), "items": items}
+			},
+			status: 201,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testutil.New(t)
+			sales := seedSales(h)
+
+			token := ""
+			if tt.role != "" {
+				token = h.Token(tt.role)
+			}
+			resp := h.Request("POST", "/api/v1/orders", tt.body(sales), token)
+			if resp.Status != tt.status {
+				t.Fatalf("status = %d, want %d: %s", resp.Status, tt.status, resp.Raw)
+			}
+			if resp.ErrorCode() != tt.code {
+				t.Fatalf("error_code = %q, want %q", resp.ErrorCode(), tt.code)
+			}
+			if tt.status != 201 {
+				if n := h.Count("orders", bson.M{}); n != 0 {
+					t.Fatalf("%d orders stored after a failed create", n)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	admin := h.Token(models.RoleAdmin)
+
+	resp := h.Request("POST", "/api/v1/orders", map[string]interface{}{
+		"sales_id":      sales.ID.Hex(),
+		"customer_name": "Toko Maju",
+		"items": []map[string]interface{}{
+			{"product_name": "Semen", "quantity": 10, "unit_price": 50000},
+			{"product_name": "Pasir", "quantity": 2, "unit_price": 150000, "unit": "m3"},
+		},
+	}, admin)
+	if resp.Status != 201 {
+		t.Fatalf("create: status = %d: %s", resp.Status, resp.Raw)
+	}
+	created, _ := resp.Data()["order"].(map[string]interface{})
+	id, _ := created["id"].(string)
+
+	order := &models.Order{}
+	h.Find("orders", bson.M{"order_number": created["order_number"]}, order)
+	if order.ID.Hex() != id {
+		t.Fatalf("stored order %s, response order %s", order.ID.Hex(), id)
+	}
+	if order.Status != models.OrderStatusPending || order.PaymentStatus != models.PaymentStatusPending {
+		t.Fatalf("new order is %s/%s, want pending/pending", order.Status, order.PaymentStatus)
+	}
+	if order.TotalPrice != 800000 || order.Quantity != 12 {
+		t.Fatalf("totals = %.0f for %d items, want 800000 for 12", order.TotalPrice, order.Quantity)
+	}
+	if order.Items[0].Unit != "pcs" || order.Items[1].Unit != "m3" {
+		t.Fatalf("units = %q, %q, want pcs and m3", order.Items[0].Unit, order.Items[1].Unit)
+	}
+	if order.Items[1].BaseQuantity != 2 || order.Items[1].BaseUnit != "m3" {
+		t.Fatalf("base quantity = %v %s, want 2 m3", order.Items[1].BaseQuantity, order.Items[1].BaseUnit)
+	}
+	if order.InvoiceToken == "" {
+		t.Fatal("order has no invoice token")
+	}
+
+	// The invoice goes out to the sales rep through the outbox
+	h.Eventually(func() bool { return len(h.WhatsApp.MessagesTo(sales.Phone)) > 0 },
+		"no invoice message sent to %s", sales.Phone)
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status string
+	}{
+		{"marks it paid", "PUT", "/api/v1/orders/" + id, map[string]interface{}{"status": models.OrderStatusPaid}, models.OrderStatusPaid},
+		{"cancels it", "DELETE", "/api/v1/orders/" + id, nil, models.OrderStatusCancelled},
+	}
+	for _, step := range steps {
+		resp := h.Request(step.method, step.path, step.body, admin)
+		if resp.Status != 200 || resp.ErrorCode() != "" {
+			t.Fatalf("%s: status = %d: %s", step.name, resp.Status, resp.Raw)
+		}
+
+		order := &models.Order{}
+		h.Find("orders", bson.M{"order_number": created["order_number"]}, order)
+		if order.Status != step.status {
+			t.Fatalf("%s: status = %s, want %s", step.name, order.Status, step.status)
+		}
+		last := order.StatusHistory[len(order.StatusHistory)-1]
+		if last.Status != step.status {
+			t.Fatalf("%s: last status change is %s, want %s", step.name, last.Status, step.status)
+		}
+	}
+
+	resp = h.Request("GET", "/api/v1/orders/"+id, nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("detail: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if status := resp.Data()["status"]; status != models.OrderStatusCancelled {
+		t.Fatalf("detail: status = %v, want %s", status, models.OrderStatusCancelled)
+	}
+}
+
+func TestOrderNotFound(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		code   response.Code
+	}{
+		{"invalid id", "GET", "/api/v1/orders/not-an-id", 400, response.CodeInvalidID},
+		{"unknown order", "PUT", "/api/v1/orders/" + models.NewOrder().ID.Hex(), 200, response.CodeOrderNotFound},
+		{"unknown order to cancel", "DELETE", "/api/v1/orders/" + models.NewOrder().ID.Hex(), 200, response.CodeOrderNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := h.Request(tt.method, tt.path, map[string]interface{}{}, admin)
+			if resp.Status != tt.status || resp.ErrorCode() != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", resp.Status, resp.ErrorCode(), tt.status, tt.code, resp.Raw)
+			}
+		})
+	}
+}
+
+func TestPriceCheck(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	adminID := primitive.NewObjectID().Hex()
+	admin := h.TokenFor(adminID, models.RoleAdmin)
+
+	product := models.NewProduct()
+	product.Name = "Semen"
+	product.Price = 50000
+	h.Insert("products", product)
+
+	resp := h.Request("PUT", "/api/v1/settings/price-check", map[string]interface{}{"max_deviation_percent": 10}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("policy: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	create := func(unitPrice float64, note string) *testutil.Response {
+		return h.Request("POST", "/api/v1/orders", map[string]interface{}{
+			"sales_id": sales.ID.Hex(),
+			"items": []map[string]interface{}{
+				{"product_name": "Pasir", "quantity": 1, "unit_price": 150000},
+				{"product_name": "semen ", "quantity": 10, "unit_price": unitPrice, "price_note": note},
+			},
+		}, admin)
+	}
+
+	if resp := create(54000, ""); resp.Status != 201 {
+		t.Fatalf("within the deviation: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if n := h.Count("price_overrides", bson.M{}); n != 0 {
+		t.Fatalf("%d price overrides recorded for a price within the deviation", n)
+	}
+
+	resp = create(40000, "")
+	if resp.Status != 400 || resp.ErrorCode() != response.CodePriceNoteRequired {
+		t.Fatalf("without a note: got %d %q: %s", resp.Status, resp.ErrorCode(), resp.Raw)
+	}
+	warnings, _ := resp.Data()["price_warnings"].([]interface{})
+	if len(warnings) != 1 || warnings[0].(map[string]interface{})["index"] != float64(1) {
+		t.Fatalf("price_warnings = %v, want the second item", warnings)
+	}
+
+	resp = create(40000, "Stok lama")
+	if resp.Status != 201 {
+		t.Fatalf("with a note: status = %d: %s", resp.Status, resp.Raw)
+	}
+	override := &models.PriceOverride{}
+	h.Find("price_overrides", bson.M{}, override)
+	if override.AdminID != adminID || override.DeviationPercent != -20 || override.Note != "Stok lama" {
+		t.Fatalf("override = %+v, want -20%% by %s with the note", override, adminID)
+	}
+
+	resp = h.Request("GET", "/api/v1/reports/price-overrides", nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("report: status = %d: %s", resp.Status, resp.Raw)
+	}
+	admins, _ := resp.Data()["admins"].([]interface{})
+	if len(admins) != 1 {
+		t.Fatalf("report admins = %v, want one", admins)
+	}
+	row := admins[0].(map[string]interface{})
+	if row["admin_id"] != adminID || row["overrides"] != float64(1) || row["amount_difference"] != float64(-100000) {
+		t.Fatalf("report row = %v, want one override of -100000 by %s", row, adminID)
+	}
+
+	// Without the catalog or the policy the check cannot run, so orders are refused
+	orders := h.Count("orders", bson.M{})
+	for _, collection := range []string{"products", "price_check_policy"} {
+		h.Mongo.Fail(collection)
+		if resp := create(40000, ""); resp.Status != 500 {
+			t.Fatalf("%s unavailable: status = %d: %s", collection, resp.Status, resp.Raw)
+		}
+		h.Mongo.Recover(collection)
+	}
+	if n := h.Count("orders", bson.M{}); n != orders {
+		t.Fatalf("%d orders created while the price check was unavailable", n-orders)
+	}
+}
+
+func TestCreditLimit(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	seedOrder(h, sales, nil)
+	seedOrder(h, sales, func(o *models.Order) { o.Status = models.OrderStatusCancelled })
+
+	resp := h.Request("PUT", "/api/v1/sales/"+sales.ID.Hex()+"/credit", map[string]interface{}{
+		"credit_limit": 1000000, "payment_term_days": 14,
+	}, admin)
+	if resp.Status != 200 || resp.Data()["outstanding"] != float64(500000) {
+		t.Fatalf("credit terms: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	order := map[string]interface{}{
+		"sales_id": sales.ID.Hex(),
+		"items":    []map[string]interface{}{{"product_name": "Semen", "quantity": 12, "unit_price": 50000}},
+	}
+	resp = h.Request("POST", "/api/v1/orders", order, admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeCreditLimitExceeded {
+		t.Fatalf("over the limit: got %d %q: %s", resp.Status, resp.ErrorCode(), resp.Raw)
+	}
+
+	h.Request("PUT", "/api/v1/sales/"+sales.ID.Hex()+"/credit", map[string]interface{}{
+		"credit_limit": 1000000, "payment_term_days": 14, "credit_check": models.CreditCheckWarn,
+	}, admin)
+	resp = h.Request("POST", "/api/v1/orders", order, admin)
+	if resp.Status != 201 || resp.Data()["credit_exceeded"] != true {
+		t.Fatalf("warn only: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("GET", "/api/v1/reports/receivables-aging", nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("aging: status = %d: %s", resp.Status, resp.Raw)
+	}
+	rows, _ := resp.Data()["sales"].([]interface{})
+	if len(rows) != 1 {
+		t.Fatalf("aging rows = %v, want one sales", rows)
+	}
+	row := rows[0].(map[string]interface{})
+	buckets, _ := row["buckets"].(map[string]interface{})
+	if row["total"] != float64(1100000) || row["orders"] != float64(2) || buckets["current"] != float64(1100000) {
+		t.Fatalf("aging row = %v, want 1100000 over 2 current orders", row)
+	}
+
+	resp = h.Request("GET", "/api/v1/reports/receivables-aging?as_of=01-02-2026", nil, admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("invalid as_of: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestOverdueOrders(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	h.Request("PUT", "/api/v1/sales/"+sales.ID.Hex()+"/credit", map[string]interface{}{"payment_term_days": 14}, admin)
+
+	resp := h.Request("POST", "/api/v1/orders", map[string]interface{}{
+		"sales_id": sales.ID.Hex(),
+		"items":    []map[string]interface{}{{"product_name": "Semen", "quantity": 10, "unit_price": 50000}},
+	}, admin)
+	if resp.Status != 201 {
+		t.Fatalf("create: status = %d: %s", resp.Status, resp.Raw)
+	}
+	var created models.Order
+	h.Find("orders", bson.M{}, &created)
+	if created.DueDate == nil || created.DueDate.Sub(created.CreatedAt) != 14*24*time.Hour {
+		t.Fatalf("due_date = %v, want 14 days after %v", created.DueDate, created.CreatedAt)
+	}
+
+	past := time.Now().AddDate(0, 0, -3)
+	late := seedOrder(h, sales, func(o *models.Order) { o.DueDate = &past })
+	seedOrder(h, sales, func(o *models.Order) {
+		o.DueDate = &past
+		o.PaymentStatus = models.PaymentStatusVerified
+	})
+
+	notification.CheckOverdue()
+
+	resp = h.Request("GET", "/api/v1/orders?overdue=true", nil, admin)
+	orders, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(orders) != 1 || orders[0].(map[string]interface{})["id"] != late.ID.Hex() {
+		t.Fatalf("overdue orders = %s, want only %s", resp.Raw, late.OrderNumber)
+	}
+
+	h.Request("PUT", "/api/v1/orders/"+late.ID.Hex(), map[string]interface{}{"due_date": time.Now().AddDate(0, 0, 7).Format("2006-01-02")}, admin)
+	notification.CheckOverdue()
+	if n := h.Count("orders", bson.M{"overdue": true}); n != 0 {
+		t.Fatalf("%d orders still overdue after moving the due date", n)
+	}
+}
+
+func TestResendInvoiceLink(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	order := seedOrder(h, sales, nil)
+
+	// Details that do not match get the same answer, and nothing is sent
+	resp := h.Request("POST", "/api/v1/client/link/request", map[string]string{"phone": "0899999999", "order_number": order.OrderNumber}, "")
+	if resp.Status != 200 || len(h.WhatsApp.MessagesTo(sales.Phone)) != 0 {
+		t.Fatalf("unknown phone: status = %d, %d messages", resp.Status, len(h.WhatsApp.MessagesTo(sales.Phone)))
+	}
+
+	details := map[string]string{"phone": "0812-3456-7890", "order_number": order.OrderNumber}
+	if resp = h.Request("POST", "/api/v1/client/link/request", details, ""); resp.Status != 200 {
+		t.Fatalf("request code: status = %d: %s", resp.Status, resp.Raw)
+	}
+	messages := h.WhatsApp.MessagesTo(sales.Phone)
+	if len(messages) != 1 {
+		t.Fatalf("want one code message, got %d", len(messages))
+	}
+	code := regexp.MustCompile(`\d{6}`).FindString(messages[0].Text)
+
+	details["code"] = "000000"
+	if code == details["code"] {
+		details["code"] = "111111"
+	}
+	resp = h.Request("POST", "/api/v1/client/link/resend", details, "")
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeOTPInvalid {
+		t.Fatalf("wrong code: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	details["code"] = code
+	if resp = h.Request("POST", "/api/v1/client/link/resend", details, ""); resp.Status != 200 {
+		t.Fatalf("resend: status = %d: %s", resp.Status, resp.Raw)
+	}
+	messages = h.WhatsApp.MessagesTo(sales.Phone)
+	if len(messages) != 2 || !strings.Contains(messages[1].Text, order.InvoiceToken) {
+		t.Fatalf("want the invoice link sent to the sales rep: %+v", messages)
+	}
+
+	// The code is used up
+	if resp = h.Request("POST", "/api/v1/client/link/resend", details, ""); resp.ErrorCode() != response.CodeOTPInvalid {
+		t.Fatalf("reused code: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	// A phone that matches no order is limited like one that does
+	unknown := map[string]string{"phone": "0899999999", "order_number": "ORD-000000-0000"}
+	for i := 1; i < otp.MaxPerHour; i++ {
+		if resp = h.Request("POST", "/api/v1/client/link/request", unknown, ""); resp.Status != 200 {
+			t.Fatalf("unmatched request %d: status = %d: %s", i+1, resp.Status, resp.Raw)
+		}
+	}
+	if resp = h.Request("POST", "/api/v1/client/link/request", unknown, ""); resp.Status != 429 {
+		t.Fatalf("unmatched request over the limit: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestSalesTargets(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token("ADMIN")
+	sales := seedSales(h)
+	now := time.Now()
+	month := now.Format("2006-01")
+
+	seedOrder(h, sales, func(order *models.Order) {
+		order.Status = models.OrderStatusCompleted
+		order.CompletedAt = &now
+	})
+
+	path := "/api/v1/sales/" + sales.ID.Hex() + "/targets"
+	goal := map[string]interface{}{"month": month, "revenue": 2000000, "volume": 40}
+	if resp := h.Request("POST", path, goal, admin); resp.Status != 201 {
+		t.Fatalf("create target: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", path, goal, admin); resp.Status != 409 {
+		t.Fatalf("duplicate target: status = %d, want 409", resp.Status)
+	}
+
+	resp := h.Request("GET", path, nil, admin)
+	targets, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(targets) != 1 {
+		t.Fatalf("list targets: status = %d: %s", resp.Status, resp.Raw)
+	}
+	achievement := targets[0].(map[string]interface{})
+	if achievement["revenue_percent"] != 25.0 || achievement["volume_percent"] != 25.0 {
+		t.Fatalf("want 25%% of both targets achieved, got %v", achievement)
+	}
+
+	resp = h.Request("PUT", path+"/"+month, map[string]interface{}{"revenue": 1000000, "volume": 10}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("update target: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("GET", "/api/v1/sales/leaderboard?month="+month, nil, admin)
+	board, _ := resp.Data()["leaderboard"].([]interface{})
+	if resp.Status != 200 || len(board) != 1 {
+		t.Fatalf("leaderboard: status = %d: %s", resp.Status, resp.Raw)
+	}
+	first := board[0].(map[string]interface{})
+	if first["sales_name"] != sales.Name || first["revenue_percent"] != 50.0 || first["rank"] != 1.0 {
+		t.Fatalf("unexpected leaderboard entry: %v", first)
+	}
+
+	resp = h.Request("GET", "/api/v1/sales/leaderboard?month=2026-13", nil, admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("invalid month: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestCSATSurvey(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token("ADMIN")
+	sales := seedSales(h)
+	completed := time.Now().Add(-3 * time.Hour)
+	order := seedOrder(h, sales, func(order *models.Order) {
+		order.Status = models.OrderStatusCompleted
+		order.CompletedAt = &completed
+		order.CustomerName = "Toko Maju"
+		order.CustomerPhone = "6281111111111"
+	})
+
+	csat.SendDue()
+	csat.SendDue()
+	if n := h.Count(csat.Collection, bson.M{"order_id": order.ID.Hex()}); n != 1 {
+		t.Fatalf("want one survey, got %d", n)
+	}
+	messages := h.WhatsApp.MessagesTo(order.CustomerPhone)
+	if len(messages) != 1 {
+		t.Fatalf("want the rating link sent to the customer, got %d messages", len(messages))
+	}
+	var survey csat.Survey
+	h.Find(csat.Collection, bson.M{"order_id": order.ID.Hex()}, &survey)
+	if !strings.Contains(messages[0].Text, survey.Token) {
+		t.Fatalf("message has no rating link: %s", messages[0].Text)
+	}
+
+	path := "/api/v1/client/survey/" + survey.Token
+	if resp := h.Request("GET", path, nil, ""); resp.Status != 200 || resp.Data()["answered"] != false {
+		t.Fatalf("get survey: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", path, map[string]interface{}{"score": 6}, ""); resp.Status != 400 {
+		t.Fatalf("out of range score: status = %d", resp.Status)
+	}
+	rating := map[string]interface{}{"score": 2, "feedback": "Antrian terlalu lama"}
+	if resp := h.Request("POST", path, rating, ""); resp.Status != 200 {
+		t.Fatalf("rate: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", path, rating, ""); resp.ErrorCode() != response.CodeSurveyAnswered {
+		t.Fatalf("second rating: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp := h.Request("GET", "/api/v1/csat/responses?follow_up=open", nil, admin)
+	open, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(open) != 1 {
+		t.Fatalf("open follow-ups: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/csat/responses/"+survey.ID.Hex()+"/follow-up", map[string]string{"note": "Ditelepon"}, admin); resp.Status != 200 {
+		t.Fatalf("follow up: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("GET", "/api/v1/csat/responses?follow_up=open", nil, admin)
+	if open, _ = resp.Body["data"].([]interface{}); len(open) != 0 {
+		t.Fatalf("want no open follow-ups, got %d", len(open))
+	}
+
+	resp = h.Request("GET", "/api/v1/csat/summary", nil, admin)
+	overall, _ := resp.Data()["overall"].(map[string]interface{})
+	if resp.Status != 200 || overall["responses"] != 1.0 || overall["average"] != 2.0 || overall["low"] != 1.0 {
+		t.Fatalf("unexpected summary: %s", resp.Raw)
+	}
+	perSales, _ := resp.Data()["sales"].([]interface{})
+	if len(perSales) != 1 || perSales[0].(map[string]interface{})["sales_name"] != sales.Name {
+		t.Fatalf("want the sales rep's CSAT: %s", resp.Raw)
+	}
+}
+
+func TestCSATSurveyRetry(t *testing.T) {
+	h := testutil.New(t)
+	csat.EnsureIndexes()
+	sales := seedSales(h)
+	completed := time.Now().Add(-3 * time.Hour)
+	complete := func(phone string) func(order *models.Order) {
+		return func(order *models.Order) {
+			order.Status = models.OrderStatusCompleted
+			order.CompletedAt = &completed
+			order.CustomerPhone = phone
+		}
+	}
+	noPhone := seedOrder(h, sales, complete(""))
+	order := seedOrder(h, sales, complete("6281111111111"))
+
+	// A survey left by an interrupted run blocks the insert, which releases the claim
+	stale := csat.Survey{ID: primitive.NewObjectID(), Token: "stale", OrderID: order.ID.Hex()}
+	h.Insert(csat.Collection, stale)
+	csat.SendDue()
+	if n := h.Count("orders", bson.M{"_id": order.ID, "csat_sent_at": bson.M{"$exists": true}}); n != 0 {
+		t.Fatal("want the failed survey left to retry")
+	}
+
+	if _, err := database.GetMongoCollection(csat.Collection).DeleteOne(context.Background(), bson.M{"_id": stale.ID}); err != nil {
+		t.Fatal(err)
+	}
+	csat.SendDue()
+	if n := h.Count(csat.Collection, bson.M{"order_id": order.ID.Hex()}); n != 1 || len(h.WhatsApp.MessagesTo(order.CustomerPhone)) != 1 {
+		t.Fatalf("want the survey sent on the next run, got %d surveys", n)
+	}
+	if n := h.Count(csat.Collection, bson.M{"order_id": noPhone.ID.Hex()}); n != 0 || len(h.WhatsApp.MessagesTo(sales.Phone)) != 0 {
+		t.Fatal("want no survey for an order without a customer phone")
+	}
+}
+
+func TestItemSuggestions(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	h.Insert("products", &models.Product{BaseModel: models.BaseModel{ID: primitive.NewObjectID()}, Name: "Semen Gresik", Price: 51000})
+
+	withItems := func(ago time.Duration, items ...models.OrderItem) func(*models.Order) {
+		return func(o *models.Order) {
+			o.Items = items
+			o.CreatedAt = time.Now().Add(-ago)
+		}
+	}
+	gresik := func(price float64) models.OrderItem {
+		return models.OrderItem{ProductName: "Semen Gresik", Unit: "sak", Quantity: 10, UnitPrice: price}
+	}
+	seedOrder(h, sales, withItems(48*time.Hour, gresik(50000), models.OrderItem{ProductName: "Pasir", Unit: "m3", UnitPrice: 150000}))
+	seedOrder(h, sales, withItems(time.Hour, gresik(52000)))
+	seedOrder(h, sales, withItems(2*time.Hour, models.OrderItem{ProductName: "semen tiga roda", Unit: "sak", UnitPrice: 49000}))
+	seedOrder(h, sales, withItems(400*24*time.Hour, models.OrderItem{ProductName: "Semen Lama", Unit: "sak"}))
+	seedOrder(h, sales, func(o *models.Order) {
+		withItems(time.Minute, gresik(52000))(o)
+		o.ShipmentOf = primitive.NewObjectID().Hex()
+	})
+
+	resp := h.Request("GET", "/api/v1/orders/item-suggestions?q=SEMEN", nil, h.Token(models.RoleAdmin))
+	rows, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(rows) != 2 {
+		t.Fatalf("got %d with %d suggestions, want 2: %s", resp.Status, len(rows), resp.Raw)
+	}
+	first, second := rows[0].(map[string]interface{}), rows[1].(map[string]interface{})
+	if first["product_name"] != "Semen Gresik" || first["uses"] != float64(2) || first["last_price"] != float64(52000) ||
+		first["list_price"] != float64(51000) || first["in_catalog"] != true {
+		t.Fatalf("first suggestion = %v, want Semen Gresik used twice, last at 52000, in the catalog", first)
+	}
+	if second["product_name"] != "semen tiga roda" || second["uses"] != float64(1) || second["in_catalog"] != false {
+		t.Fatalf("second suggestion = %v, want semen tiga roda", second)
+	}
+
+	if resp := h.Request("GET", "/api/v1/orders/item-suggestions?limit=500", nil, h.Token(models.RoleAdmin)); resp.Status != 400 {
+		t.Fatalf("limit=500: status %d, want 400", resp.Status)
+	}
+}
+
+func TestUniqueSalesPhone(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	active := seedSales(h)
+	inactive := models.NewSales()
+	inactive.Name = "Joko"
+	inactive.Phone = "6289999999999"
+	inactive.IsActive = false
+	h.Insert("sales", inactive)
+	legacy := models.NewSales()
+	legacy.Name = "Sari"
+	legacy.Phone = "0811-2222-3333"
+	h.Insert("sales", legacy)
+
+	// Phones stored before normalization are rewritten before the index is built
+	database.EnsureUniqueIndexes()
+	stored := &models.Sales{}
+	h.Find("sales", bson.M{"_id": legacy.ID}, stored)
+	if stored.Phone != "6281122223333" {
+		t.Fatalf("legacy phone = %q, want it normalized", stored.Phone)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]interface{}
+		status int
+		code   response.Code
+	}{
+		{"refuses the phone of an active rep", "POST", "/api/v1/sales", map[string]interface{}{"name": "Budi 2", "phone": active.Phone}, 409, response.CodeSalesPhoneTaken},
+		{"refuses the phone in local format", "POST", "/api/v1/sales", map[string]interface{}{"name": "Budi 2", "phone": "0812-3456-7890"}, 409, response.CodeSalesPhoneTaken},
+		{"refuses the phone with a plus", "POST", "/api/v1/sales", map[string]interface{}{"name": "Budi 2", "phone": "+62 812 3456 7890"}, 409, response.CodeSalesPhoneTaken},
+		{"refuses an invalid phone", "POST", "/api/v1/sales", map[string]interface{}{"name": "Budi 2", "phone": "12345"}, 400, response.CodeValidationFailed},
+		{"refuses moving to a taken phone in local format", "PUT", "/api/v1/sales/" + legacy.ID.Hex(), map[string]interface{}{"phone": "08123456789 0"}, 409, response.CodeSalesPhoneTaken},
+		{"reuses the phone of an inactive rep", "POST", "/api/v1/sales", map[string]interface{}{"name": "Joko 2", "phone": inactive.Phone}, 201, ""},
+		{"refuses reactivating onto a taken phone", "PUT", "/api/v1/sales/" + inactive.ID.Hex(), map[string]interface{}{"is_active": true}, 409, response.CodeSalesPhoneTaken},
+		{"refuses moving to a taken phone", "PUT", "/api/v1/sales/" + active.ID.Hex(), map[string]interface{}{"phone": inactive.Phone}, 409, response.CodeSalesPhoneTaken},
+		{"updates other fields", "PUT", "/api/v1/sales/" + active.ID.Hex(), map[string]interface{}{"name": "Budi Santoso"}, 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := h.Request(tt.method, tt.path, tt.body, admin)
+			if resp.Status != tt.status || resp.ErrorCode() != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", resp.Status, resp.ErrorCode(), tt.status, tt.code, resp.Raw)
+			}
+		})
+	}
+
+	if n := h.Count("sales", bson.M{"phone": active.Phone}); n != 1 {
+		t.Fatalf("%d reps with phone %s, want 1", n, active.Phone)
+	}
+	stored = &models.Sales{}
+	h.Find("sales", bson.M{"_id": inactive.ID}, stored)
+	if stored.IsActive {
+		t.Fatal("the refused reactivation was stored")
+	}
+}
+
+func TestOrderBoard(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	seedOrder(h, sales, nil)
+	seedOrder(h, sales, nil)
+	queued := seedOrder(h, sales, func(order *models.Order) {
+		order.Status = models.OrderStatusQueued
+		order.QueueQRCode = "data:image/png;base64,qr"
+		order.BarcodeImage = "data:image/png;base64,barcode"
+	})
+	confirmed := seedOrder(h, sales, func(order *models.Order) { order.Status = models.OrderStatusConfirmed })
+
+	resp := h.Request("GET", "/api/v1/orders/board?limit=1", nil, admin)
+	if resp.Status != 200 || resp.Data()["total"] != float64(4) {
+		t.Fatalf("board: status = %d: %s", resp.Status, resp.Raw)
+	}
+	columns := resp.Data()["columns"].([]interface{})
+	if len(columns) != len(models.OrderStatuses) {
+		t.Fatalf("columns = %d", len(columns))
+	}
+	pending := columns[0].(map[string]interface{})
+	cards := pending["orders"].([]interface{})
+	if pending["status"] != "pending" || pending["count"] != float64(2) || len(cards) != 1 {
+		t.Fatalf("pending column = %v", pending)
+	}
+	if card := cards[0].(map[string]interface{}); card["sales"].(map[string]interface{})["name"] != "Budi" {
+		t.Fatalf("card = %v", card)
+	}
+
+	// Cards leave out the code images
+	for _, column := range columns {
+		column := column.(map[string]interface{})
+		if column["status"] != models.OrderStatusQueued {
+			continue
+		}
+		card := column["orders"].([]interface{})[0].(map[string]interface{})
+		if _, ok := card["queue_qrcode"]; ok || card["barcode_image"] != nil {
+			t.Fatalf("queued card has code images: %v", card)
+		}
+	}
+
+	// Transitions that need more than a status point to their action
+	resp = h.Request("POST", "/api/v1/orders/"+confirmed.ID.Hex()+"/move", map[string]string{"status": "queued"}, admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeOrderInvalidStatus {
+		t.Fatalf("move to queued: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/orders/"+confirmed.ID.Hex()+"/move", map[string]string{"status": "completed"}, admin)
+	if resp.Status != 400 || !strings.Contains(string(resp.Raw), "cannot move") {
+		t.Fatalf("skip a step: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", "/api/v1/orders/"+queued.ID.Hex()+"/move", map[string]string{"status": "loading"}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("move to loading: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/orders/"+confirmed.ID.Hex()+"/move", map[string]string{"status": "cancelled"}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("move to cancelled: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if h.Count("orders", bson.M{"status": "loading"}) != 1 || h.Count("orders", bson.M{"status": "cancelled"}) != 1 {
+		t.Fatal("orders were not moved")
+	}
+}
+
+func TestOrderChangeEscrow(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	order := seedOrder(h, sales, func(o *models.Order) {
+		o.Items = append(o.Items, models.OrderItem{ProductName: "Pasir", Quantity: 2, UnitPrice: 150000, Unit: "m3", Subtotal: 300000})
+		o.Quantity, o.TotalPrice = 12, 800000
+	})
+	admin := h.Token(models.RoleAdmin)
+	path := "/api/v1/orders/" + order.ID.Hex() + "/items"
+
+	edit := map[string]interface{}{"items": []map[string]int{{"index": 0, "quantity": 8}, {"index": 1, "quantity": 0}}, "reason": "Stok pasir habis"}
+	resp := h.Request("PUT", path, edit, admin)
+	if resp.Status != 202 {
+		t.Fatalf("stage change: status = %d: %s", resp.Status, resp.Raw)
+	}
+	messages := h.WhatsApp.MessagesTo(sales.Phone)
+	if len(messages) != 1 || !strings.Contains(messages[0].Text, "Pasir: 2 m3 dihapus") || !strings.Contains(messages[0].Text, order.InvoiceToken) {
+		t.Fatalf("want the change request sent to the sales rep: %+v", messages)
+	}
+
+	// Nothing applies until the sales rep answers, and payment waits for the answer
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.TotalPrice != 800000 || stored.PendingChange == nil || stored.PendingChange.TotalPrice != 400000 {
+		t.Fatalf("want the change staged only: total %v, pending %+v", stored.TotalPrice, stored.PendingChange)
+	}
+	resp = h.Upload("/api/v1/client/payment/"+order.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), "")
+	if resp.ErrorCode() != response.CodeOrderChangePending {
+		t.Fatalf("upload during change: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp = h.Request("POST", "/api/v1/client/changes/"+order.InvoiceToken, map[string]string{"action": "accept"}, "")
+	if resp.Status != 200 {
+		t.Fatalf("accept: status = %d: %s", resp.Status, resp.Raw)
+	}
+	stored = &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.TotalPrice != 400000 || stored.Quantity != 8 || len(stored.Items) != 1 || stored.PendingChange != nil {
+		t.Fatalf("want the change applied: %+v", stored)
+	}
+	if len(stored.ChangeHistory) != 1 || stored.ChangeHistory[0].Resolution != models.ChangeAccepted {
+		t.Fatalf("want the change in the history: %+v", stored.ChangeHistory)
+	}
+
+	// A change left unanswered past its expiry is applied by the timeout
+	edit = map[string]interface{}{"items": []map[string]int{{"index": 0, "quantity": 5}}}
+	if resp = h.Request("PUT", path, edit, admin); resp.Status != 202 {
+		t.Fatalf("stage second change: status = %d: %s", resp.Status, resp.Raw)
+	}
+	_, err := database.GetMongoCollection("orders").UpdateOne(context.Background(),
+		bson.M{"_id": order.ID}, bson.M{"$set": bson.M{"pending_change.expires_at": time.Now().Add(-time.Minute)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderchange.ResolveExpired()
+
+	stored = &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.TotalPrice != 250000 || stored.PendingChange != nil || stored.ChangeHistory[1].Resolution != models.ChangeAutoApplied {
+		t.Fatalf("want the expired change applied: total %v, history %+v", stored.TotalPrice, stored.ChangeHistory)
+	}
+	if messages = h.WhatsApp.MessagesTo(sales.Phone); len(messages) != 3 {
+		t.Fatalf("want the sales rep told of the applied change, got %d messages", len(messages))
+	}
+}
+
+func TestOrderSLA(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	uploadedAt := func(ago time.Duration) func(o *models.Order) {
+		return func(o *models.Order) {
+			withProof(o)
+			at := time.Now().Add(-ago)
+			o.PaymentUploadedAt = &at
+		}
+	}
+	breached := seedOrder(h, sales, uploadedAt(3*time.Hour))
+	seedOrder(h, sales, uploadedAt(10*time.Minute))
+	atRisk := seedOrder(h, sales, func(o *models.Order) {
+		at := time.Now().Add(-160 * time.Minute)
+		o.Status, o.QueueEnteredAt = models.OrderStatusQueued, &at
+	})
+	admin := h.Token(models.RoleAdmin)
+
+	resp := h.Request("GET", "/api/v1/orders?sla=breached", nil, admin)
+	list, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(list) != 1 {
+		t.Fatalf("breached orders: status = %d, %d orders: %s", resp.Status, len(list), resp.Raw)
+	}
+	status, _ := list[0].(map[string]interface{})["sla"].(map[string]interface{})
+	if status["stage"] != "payment_verification" || status["state"] != models.SLABreached {
+		t.Fatalf("want the breach flagged on the listing, got %v", status)
+	}
+	if resp = h.Request("GET", "/api/v1/orders?sla=late", nil, admin); resp.Status != 400 {
+		t.Fatalf("unknown state: status = %d", resp.Status)
+	}
+
+	// Each order alerts once per state
+	sla.Check()
+	sla.Check()
+	if n := h.Count(events.Collection, bson.M{"type": events.SLABreached, "resource_id": breached.ID.Hex()}); n != 1 {
+		t.Fatalf("want one breach alert, got %d", n)
+	}
+	if n := h.Count(events.Collection, bson.M{"type": events.SLAAtRisk, "resource_id": atRisk.ID.Hex(), "data.role": models.RoleAdmin}); n != 1 {
+		t.Fatalf("want one at-risk alert for admins, got %d", n)
+	}
+	if n := h.Count(events.Collection, bson.M{}); n != 2 {
+		t.Fatalf("want only the two alerts, got %d events", n)
+	}
+
+	// A new proof upload enters the stage again, so its breach alerts again
+	reentered := time.Now().Add(-4 * time.Hour)
+	if _, err := database.GetMongoCollection("orders").UpdateOne(context.Background(),
+		bson.M{"_id": breached.ID}, bson.M{"$set": bson.M{"payment_uploaded_at": reentered}}); err != nil {
+		t.Fatal(err)
+	}
+	sla.Check()
+	if n := h.Count(events.Collection, bson.M{"type": events.SLABreached, "resource_id": breached.ID.Hex()}); n != 2 {
+		t.Fatalf("want the breach alerted again after re-entering the stage, got %d", n)
+	}
+}
+
+func TestCancelRequest(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	order := seedOrder(h, sales, nil)
+	kept := seedOrder(h, sales, nil)
+	admin := h.Token(models.RoleAdmin)
+
+	path := "/api/v1/client/cancel-request/" + order.InvoiceToken
+	if resp := h.Request("POST", path, map[string]string{"reason": " "}, ""); resp.Status != 400 {
+		t.Fatalf("request without reason: status = %d", resp.Status)
+	}
+	resp := h.Request("POST", path, map[string]string{"reason": "Proyek ditunda"}, "")
+	if resp.Status != 201 {
+		t.Fatalf("request: status = %d: %s", resp.Status, resp.Raw)
+	}
+	approveID, _ := resp.Data()["id"].(string)
+	if resp = h.Request("POST", path, map[string]string{"reason": "Lagi"}, ""); resp.ErrorCode() != response.CodeCancelPending {
+		t.Fatalf("second request: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	resp = h.Request("POST", "/api/v1/client/cancel-request/"+kept.InvoiceToken, map[string]string{"reason": "Salah pesan"}, "")
+	denyID, _ := resp.Data()["id"].(string)
+
+	resp = h.Request("GET", "/api/v1/orders/cancel-requests?status=pending", nil, admin)
+	if list, _ := resp.Body["data"].([]interface{}); len(list) != 2 {
+		t.Fatalf("want two pending requests, got %d: %s", len(list), resp.Raw)
+	}
+
+	review := "/api/v1/orders/cancel-requests/"
+	if resp = h.Request("POST", review+approveID+"/review", map[string]string{"action": "approve"}, admin); resp.Status != 200 {
+		t.Fatalf("approve: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("POST", review+approveID+"/review", map[string]string{"action": "deny"}, admin); resp.Status != 409 {
+		t.Fatalf("second review: status = %d", resp.Status)
+	}
+	if resp = h.Request("POST", review+denyID+"/review", map[string]string{"action": "deny", "note": "Material sudah disiapkan"}, admin); resp.Status != 200 {
+		t.Fatalf("deny: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.Status != models.OrderStatusCancelled {
+		t.Fatalf("approved order status = %s", stored.Status)
+	}
+	h.Find("orders", bson.M{"_id": kept.ID}, stored)
+	if stored.Status != models.OrderStatusPending {
+		t.Fatalf("denied order status = %s", stored.Status)
+	}
+
+	messages := h.WhatsApp.MessagesTo(sales.Phone)
+	if len(messages) != 2 || !strings.Contains(messages[0].Text, "telah disetujui") || !strings.Contains(messages[1].Text, "Material sudah disiapkan") {
+		t.Fatalf("want both outcomes sent to the sales rep: %+v", messages)
+	}
+}
+
+func TestCancelRequestOnce(t *testing.T) {
+	h := testutil.New(t)
+	database.EnsureUniqueIndexes()
+	order := seedOrder(h, seedSales(h), nil)
+
+	// Requests racing past the check still leave one pending request
+	path := "/api/v1/client/cancel-request/" + order.InvoiceToken
+	codes := make(chan response.Code, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- h.Request("POST", path, map[string]string{"reason": "Proyek ditunda"}, "").ErrorCode()
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != "" && code != response.CodeCancelPending {
+			t.Fatalf("concurrent request: got %q", code)
+		}
+	}
+	if n := h.Count("cancel_requests", bson.M{"order_id": order.ID.Hex(), "status": models.CancelRequestPending}); n != 1 {
+		t.Fatalf("%d pending requests, want 1", n)
+	}
+}
+
+func TestOrderConversation(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	first := seedOrder(h, sales, func(order *models.Order) { order.OrderNumber = "ORD-202610-0001" })
+	second := seedOrder(h, sales, func(order *models.Order) { order.OrderNumber = "ORD-202610-0002" })
+	admin := h.Token(models.RoleAdmin)
+
+	now := time.Now()
+	h.Insert("notifications",
+		notification.Notification{ID: primitive.NewObjectID(), Type: notification.NotificationTypeInvoice, Phone: sales.Phone,
+			Message: "Invoice ORD-202610-0001", OrderID: first.ID.Hex(), Status: notification.StatusSent, CreatedAt: now.Add(-2 * time.Hour)},
+		notification.Notification{ID: primitive.NewObjectID(), Type: notification.NotificationTypeInvoice, Phone: sales.Phone,
+			Message: "Invoice ORD-202610-0002", OrderID: second.ID.Hex(), Status: notification.StatusSent, CreatedAt: now.Add(-time.Hour)},
+	)
+
+	notification.HandleInbound(sales.Phone, "Kapan dikirim?")
+	notification.HandleInbound(sales.Phone, "Untuk ord-202610-0001 sudah transfer")
+	notification.HandleInbound("6289999999999", "ORD-202610-0001")
+
+	reply := &models.InboundMessage{}
+	h.Find(notification.InboundCollection, bson.M{"text": "Kapan dikirim?"}, reply)
+	if reply.OrderID != second.ID.Hex() || reply.MatchedBy != models.InboundMatchLastNotification {
+		t.Fatalf("unreferenced reply matched %q by %q", reply.OrderNumber, reply.MatchedBy)
+	}
+	stranger := &models.InboundMessage{}
+	h.Find(notification.InboundCollection, bson.M{"phone": "6289999999999"}, stranger)
+	if stranger.OrderID != "" {
+		t.Fatalf("reply from an unnotified phone matched %q", stranger.OrderNumber)
+	}
+
+	// Notifications keep the phone as entered, e.g. with a leading 0; replies arrive in
+	// international form
+	local := seedOrder(h, sales, func(order *models.Order) { order.OrderNumber = "ORD-202610-0003" })
+	h.Insert("notifications", notification.Notification{ID: primitive.NewObjectID(), Type: notification.NotificationTypeInvoice,
+		Phone: "081311112222", Message: "Invoice ORD-202610-0003", OrderID: local.ID.Hex(), Status: notification.StatusSent, CreatedAt: now})
+	notification.HandleInbound("6281311112222", "Sudah transfer ORD-202610-0003")
+	notification.HandleInbound("6281311112222", "Terima kasih")
+	for _, text := range []string{"Sudah transfer ORD-202610-0003", "Terima kasih"} {
+		fromLocal := &models.InboundMessage{}
+		h.Find(notification.InboundCollection, bson.M{"text": text}, fromLocal)
+		if fromLocal.OrderID != local.ID.Hex() {
+			t.Fatalf("reply %q to a 08 phone matched %q", text, fromLocal.OrderNumber)
+		}
+	}
+
+	resp := h.Request("GET", "/api/v1/orders/"+first.ID.Hex()+"/conversation", nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("conversation: status = %d: %s", resp.Status, resp.Raw)
+	}
+	entries, _ := resp.Body["data"].([]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("want the invoice and one reply, got %d: %s", len(entries), resp.Raw)
+	}
+	for i, want := range []string{"outbound", "inbound"} {
+		entry, _ := entries[i].(map[string]interface{})
+		if entry["direction"] != want {
+			t.Fatalf("entry %d direction = %v, want %s", i, entry["direction"], want)
+		}
+	}
+	if entry, _ := entries[1].(map[string]interface{}); entry["matched_by"] != models.InboundMatchOrderNumber {
+		t.Fatalf("reply matched_by = %v", entry["matched_by"])
+	}
+
+	if resp = h.Request("GET", "/api/v1/orders/"+primitive.NewObjectID().Hex()+"/conversation", nil, admin); resp.ErrorCode() != response.CodeOrderNotFound {
+		t.Fatalf("unknown order: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestOrderFunnel(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	// history moves an order along the pipeline, reaching each stage after the given minutes
+	start := time.Now().Add(-3 * time.Hour)
+	pipeline := []string{models.OrderStatusPending, models.OrderStatusPaid, models.OrderStatusConfirmed}
+	history := func(minutes ...int) []models.StatusChange {
+		changes := []models.StatusChange{}
+		for i, m := range minutes {
+			changes = append(changes, models.StatusChange{Status: pipeline[i], ChangedAt: start.Add(time.Duration(m) * time.Minute)})
+		}
+		return changes
+	}
+	seedOrder(h, sales, func(o *models.Order) {
+		o.Status = models.OrderStatusConfirmed
+		o.StatusHistory = history(0, 30, 60)
+	})
+	seedOrder(h, sales, func(o *models.Order) {
+		o.Status = models.OrderStatusPaid
+		o.StatusHistory = history(0, 10)
+	})
+	// Without a history the order falls back to its timestamps
+	seedOrder(h, sales, nil)
+	seedOrder(h, sales, func(o *models.Order) {
+		o.Status = models.OrderStatusCancelled
+		o.StatusHistory = history(0)
+	})
+
+	if resp := h.Request("GET", "/api/v1/reports/funnel?from=2026-13-01", nil, admin); resp.Status != 400 {
+		t.Fatalf("invalid from: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp := h.Request("GET", "/api/v1/reports/funnel", nil, admin)
+	stages, _ := resp.Data()["stages"].([]interface{})
+	if resp.Status != 200 || resp.Data()["total"] != 4.0 || resp.Data()["cancelled"] != 1.0 || len(stages) != 6 {
+		t.Fatalf("funnel: got %d: %s", resp.Status, resp.Raw)
+	}
+	stage := func(i int) map[string]interface{} { return stages[i].(map[string]interface{}) }
+	if pending := stage(0); pending["reached"] != 4.0 || pending["current"] != 1.0 || pending["avg_dwell_minutes"] != 20.0 || pending["dwell_sample_count"] != 2.0 {
+		t.Fatalf("pending stage = %v", pending)
+	}
+	if paid := stage(1); paid["reached"] != 2.0 || paid["conversion_rate"] != 50.0 || paid["avg_dwell_minutes"] != 30.0 {
+		t.Fatalf("paid stage = %v", paid)
+	}
+	if confirmed := stage(2); confirmed["reached"] != 1.0 || confirmed["current"] != 1.0 || confirmed["overall_rate"] != 25.0 {
+		t.Fatalf("confirmed stage = %v", confirmed)
+	}
+	if queued := stage(3); queued["reached"] != 0.0 || queued["conversion_rate"] != 0.0 {
+		t.Fatalf("queued stage = %v", queued)
+	}
+}
+
+func TestOrderInvoiceOutbox(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+
+	body := map[string]interface{}{
+		"sales_id": sales.ID.Hex(),
+		"items":    []map[string]interface{}{{"product_name": "Semen", "quantity": 10, "unit_price": 50000}},
+	}
+	if resp := h.Request("POST", "/api/v1/orders", body, h.Token(models.RoleAdmin)); resp.Status != 201 {
+		t.Fatalf("create: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// The invoice is recorded with the order and sent once the transaction commits
+	h.Eventually(func() bool {
+		return h.Count("outbox", bson.M{"kind": outbox.KindWhatsApp, "status": outbox.StatusDone}) == 1
+	}, "the invoice entry was not delivered")
+	if sent := h.WhatsApp.MessagesTo(sales.Phone); len(sent) != 1 {
+		t.Fatalf("%d invoices sent, want 1", len(sent))
+	}
+	entry := &outbox.Entry{}
+	h.Find("outbox", bson.M{}, entry)
+	if h.Count("notifications", bson.M{"_id": entry.ID}) != 1 {
+		t.Fatalf("the notification does not keep the entry ID %s", entry.ID.Hex())
+	}
+
+	// A replayed entry, as after a crash between sending and saving the outcome, is not sent again
+	_, err := database.GetMongoCollection("outbox").UpdateOne(context.Background(),
+		bson.M{"_id": entry.ID}, bson.M{"$set": bson.M{"status": outbox.StatusPending}})
+	if err != nil {
+		t.Fatalf("reset entry: %v", err)
+	}
+	h.Eventually(func() bool {
+		outbox.Dispatch()
+		return h.Count("outbox", bson.M{"status": outbox.StatusDone}) == 1
+	}, "the replayed entry was not marked done")
+	if sent := h.WhatsApp.MessagesTo(sales.Phone); len(sent) != 1 {
+		t.Fatalf("%d invoices sent after a replay, want 1", len(sent))
+	}
+}
+
+func TestStatusNotificationRules(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	resp := h.Request("GET", "/api/v1/settings/notifications", nil, admin)
+	rules, _ := resp.Data()["rules"].([]interface{})
+	enabled := map[string]bool{}
+	for _, raw := range rules {
+		rule := raw.(map[string]interface{})
+		enabled[rule["status"].(string)] = rule["enabled"].(bool)
+	}
+	if resp.Status != 200 || len(rules) != len(models.OrderStatuses) || !enabled[models.OrderStatusLoading] || enabled[models.OrderStatusPaid] {
+		t.Fatalf("default rules: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	invalid := []struct {
+		status string
+		body   map[string]interface{}
+	}{
+		{"shipped", map[string]interface{}{"enabled": false}},
+		{models.OrderStatusPaid, map[string]interface{}{"enabled": true}},
+		{models.OrderStatusPaid, map[string]interface{}{"enabled": true, "template": "Hi", "recipients": []string{"owner"}}},
+	}
+	for _, tt := range invalid {
+		if resp := h.Request("PUT", "/api/v1/settings/notifications/"+tt.status, tt.body, admin); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("rule %s %v: got %d %q", tt.status, tt.body, resp.Status, resp.ErrorCode())
+		}
+	}
+
+	rule := map[string]interface{}{
+		"enabled":    true,
+		"template":   "Hai {name}, order {order_number}: {status}",
+		"recipients": []string{models.RecipientSales, models.RecipientCustomer},
+	}
+	if resp := h.Request("PUT", "/api/v1/settings/notifications/"+models.OrderStatusPaid, rule, admin); resp.Status != 200 || resp.Data()["enabled"] != true {
+		t.Fatalf("save rule: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	order := seedOrder(h, sales, func(o *models.Order) {
+		o.CustomerName = "Sari"
+		o.CustomerPhone = "6289876543210"
+	})
+	if resp := h.Request("PUT", "/api/v1/orders/"+order.ID.Hex(), map[string]string{"status": models.OrderStatusPaid}, admin); resp.Status != 200 {
+		t.Fatalf("update status: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Each recipient gets the template filled in with their own name
+	for phone, want := range map[string]string{
+		sales.Phone:         "Hai Budi, order " + order.OrderNumber + ": Pembayaran Diunggah",
+		order.CustomerPhone: "Hai Sari, order " + order.OrderNumber + ": Pembayaran Diunggah",
+	} {
+		h.Eventually(func() bool {
+			sent := h.WhatsApp.MessagesTo(phone)
+			return len(sent) == 1 && strings.Contains(sent[0].Text, want)
+		}, "no status message %q to %s", want, phone)
+	}
+}
+
+func TestOrderNotes(t *testing.T) {
+	h := testutil.New(t)
+	order := seedOrder(h, seedSales(h), nil)
+	authorID := primitive.NewObjectID().Hex()
+	author := h.TokenFor(authorID, models.RoleUser)
+
+	path := "/api/v1/orders/" + order.ID.Hex() + "/notes"
+	add := func(form url.Values, token string) *testutil.Response {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		return h.Send(req)
+	}
+	if resp := add(url.Values{"text": {"Cek ulang"}, "visibility": {"public"}}, author); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unknown visibility: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	internal := add(url.Values{"text": {"Stok gudang kurang"}}, author)
+	if internal.Status != 201 || internal.Data()["visibility"] != models.NoteVisibilityInternal {
+		t.Fatalf("internal note: got %d: %s", internal.Status, internal.Raw)
+	}
+	if resp := add(url.Values{"text": {"Pengiriman besok pagi"}, "visibility": {models.NoteVisibilityShared}}, author); resp.Status != 201 {
+		t.Fatalf("shared note: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp := h.Request("GET", path, nil, h.Token(models.RoleAdmin))
+	notes, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(notes) != 2 || notes[0].(map[string]interface{})["text"] != "Stok gudang kurang" {
+		t.Fatalf("notes: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// The invoice page only shows the notes shared with sales
+	resp = h.Request("GET", "/api/v1/client/invoice/"+order.InvoiceToken, nil, "")
+	shared, _ := resp.Data()["notes"].([]interface{})
+	if resp.Status != 200 || len(shared) != 1 || shared[0].(map[string]interface{})["text"] != "Pengiriman besok pagi" {
+		t.Fatalf("invoice notes: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	noteID, _ := internal.Data()["id"].(string)
+	if resp = h.Request("DELETE", path+"/"+noteID, nil, h.Token(models.RoleUser)); resp.ErrorCode() != response.CodeInsufficientPermissions {
+		t.Fatalf("deleting another user's note: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	if resp = h.Request("DELETE", path+"/"+noteID, nil, author); resp.Status != 200 {
+		t.Fatalf("author delete: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if n := h.Count("order_notes", bson.M{"order_id": order.ID.Hex()}); n != 1 {
+		t.Fatalf("%d notes left, want 1", n)
+	}
+}
+
+func TestArchiveOrders(t *testing.T) {
+	h := testutil.New(t)
+	previous := config.Cfg.Archive
+	t.Cleanup(func() { config.Cfg.Archive = previous })
+	config.Cfg.Archive.OrderAge = 24 * time.Hour
+	config.Cfg.Archive.BatchSize = 2
+
+	sales := seedSales(h)
+	old := time.Now().Add(-48 * time.Hour)
+	finished := func(status string, updatedAt time.Time) func(o *models.Order) {
+		return func(o *models.Order) {
+			o.Status = status
+			o.UpdatedAt = updatedAt
+		}
+	}
+	completed := seedOrder(h, sales, finished(models.OrderStatusCompleted, old))
+	seedOrder(h, sales, finished(models.OrderStatusCancelled, old))
+	copied := seedOrder(h, sales, finished(models.OrderStatusCompleted, old))
+	seedOrder(h, sales, finished(models.OrderStatusCompleted, time.Now()))
+	seedOrder(h, sales, finished(models.OrderStatusPending, old))
+	// Copied by an earlier run that stopped before deleting it from the live orders
+	h.Insert(archive.Collection, copied)
+
+	if resp := h.Request("POST", "/api/v1/orders/archive", nil, h.Token(models.RoleAdmin)); resp.Status != 403 {
+		t.Fatalf("admin archive: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp := h.Request("POST", "/api/v1/orders/archive", nil, h.Token(models.RoleSuperAdmin))
+	if resp.Status != 200 || resp.Data()["archived"] != 3.0 {
+		t.Fatalf("archive: got %d: %s", resp.Status, resp.Raw)
+	}
+	if live, archived := h.Count("orders", bson.M{}), h.Count(archive.Collection, bson.M{}); live != 2 || archived != 3 {
+		t.Fatalf("%d live and %d archived orders, want 2 and 3", live, archived)
+	}
+
+	// Lookups by number read through to the archive
+	resp = h.Request("GET", "/api/v1/orders/number/"+completed.OrderNumber, nil, h.Token(models.RoleAdmin))
+	order, _ := resp.Data()["order"].(map[string]interface{})
+	if resp.Status != 200 || resp.Data()["archived"] != true || order["id"] != completed.ID.Hex() || len(order["items"].([]interface{})) != 1 {
+		t.Fatalf("archived lookup: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestUserActivity(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+
+	cashier := models.NewUser()
+	cashier.Username = "cashier"
+	cashier.DisplayName = "Siti"
+	cashier.Role = models.RoleAdmin
+	clerk := models.NewUser()
+	clerk.Username = "clerk"
+	clerk.Role = models.RoleAdmin
+	h.Insert("users", cashier, clerk)
+
+	now := time.Now()
+	uploaded := now.Add(-20 * time.Minute)
+	order := seedOrder(h, sales, func(o *models.Order) { o.PaymentUploadedAt = &uploaded })
+	entry := func(actorID string, action string, entityID string, details map[string]interface{}) models.AuditLog {
+		return models.AuditLog{ID: primitive.NewObjectID(), ActorID: actorID, Action: action, EntityType: "order", EntityID: entityID, Details: details, CreatedAt: now.Add(-10 * time.Minute)}
+	}
+	h.Insert("audit_logs",
+		entry(cashier.ID.Hex(), audit.ActionPaymentVerify, order.ID.Hex(), map[string]interface{}{"turnaround_seconds": 120}),
+		// Entries from before turnaround was recorded fall back to the order's upload time
+		entry(cashier.ID.Hex(), audit.ActionPaymentReject, order.ID.Hex(), nil),
+		entry(clerk.ID.Hex(), audit.ActionOrderCreate, order.ID.Hex(), nil),
+		entry(clerk.ID.Hex(), audit.ActionOrderCreate, primitive.NewObjectID().Hex(), nil),
+		entry("kiosk-1", audit.ActionDeliveryCreate, order.ID.Hex(), nil),
+		entry("", audit.ActionOrderCreate, order.ID.Hex(), nil),
+	)
+
+	if resp := h.Request("GET", "/api/v1/reports/user-activity", nil, h.Token(models.RoleUser)); resp.Status != 403 {
+		t.Fatalf("user: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp := h.Request("GET", "/api/v1/reports/user-activity", nil, h.Token(models.RoleAdmin))
+	users, _ := resp.Data()["users"].([]interface{})
+	if resp.Status != 200 || len(users) != 3 {
+		t.Fatalf("activity: got %d: %s", resp.Status, resp.Raw)
+	}
+	byID := map[string]map[string]interface{}{}
+	for _, user := range users {
+		row := user.(map[string]interface{})
+		byID[row["user_id"].(string)] = row
+	}
+
+	if row := byID[cashier.ID.Hex()]; row["display_name"] != "Siti" || row["payments_verified"] != 1.0 || row["payments_rejected"] != 1.0 ||
+		row["workload_share"] != 40.0 || row["avg_verification_turnaround_seconds"] != 360.0 {
+		t.Fatalf("cashier = %v", row)
+	}
+	if row := byID[clerk.ID.Hex()]; row["orders_created"] != 2.0 || row["avg_verification_turnaround_seconds"] != nil {
+		t.Fatalf("clerk = %v", row)
+	}
+	// Actors that are not users keep their ID only
+	if row := byID["kiosk-1"]; row["delivery_notes_issued"] != 1.0 || row["username"] != "" || row["workload_share"] != 20.0 {
+		t.Fatalf("kiosk = %v", row)
+	}
+	team, _ := resp.Data()["team"].(map[string]interface{})
+	if team["total_actions"] != 5.0 || team["orders_created"] != 2.0 || team["avg_verification_turnaround_seconds"] != 360.0 {
+		t.Fatalf("team = %v", team)
+	}
+}
+
+func TestSavedReports(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	// Created a moment ago, so the run that ends now includes them
+	createdAt := time.Now().Add(-time.Minute)
+	seedOrder(h, sales, func(o *models.Order) { o.CreatedAt = createdAt })
+	seedOrder(h, sales, func(o *models.Order) {
+		o.CreatedAt = createdAt
+		o.Items = []models.OrderItem{
+			{ProductName: "Semen", Quantity: 4, UnitPrice: 50000, Unit: "sak", Subtotal: 200000},
+			{ProductName: "Pasir", Quantity: 2, UnitPrice: 100000, Unit: "m3", Subtotal: 200000},
+		}
+		o.Quantity = 6
+		o.TotalPrice = 400000
+	})
+	seedOrder(h, sales, func(o *models.Order) {
+		o.CreatedAt = createdAt
+		o.Status = models.OrderStatusCancelled
+	})
+
+	definition := map[string]interface{}{
+		"name":     "Penjualan Harian",
+		"filter":   map[string]interface{}{"statuses": []string{models.OrderStatusPending}},
+		"group_by": []string{models.ReportGroupSales, models.ReportGroupProduct},
+		"metrics":  []string{models.ReportMetricRevenue, models.ReportMetricOrders, models.ReportMetricQuantity},
+		"schedule": map[string]interface{}{"frequency": models.ReportFrequencyDaily, "hour": 7},
+		"recipients": []map[string]interface{}{
+			{"channel": models.ReportChannelWhatsApp, "address": "6281111111111"},
+			{"channel": models.ReportChannelEmail, "address": "owner@example.com"},
+		},
+	}
+
+	invalid := map[string]interface{}{"name": "Empty", "metrics": []string{}}
+	if resp := h.Request("POST", "/api/v1/reports/saved", invalid, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("no metrics: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp := h.Request("POST", "/api/v1/reports/saved", definition, admin)
+	if resp.Status != 201 {
+		t.Fatalf("create: status = %d: %s", resp.Status, resp.Raw)
+	}
+	id := resp.Data()["id"].(string)
+	if next, _ := time.Parse(time.RFC3339, resp.Data()["schedule"].(map[string]interface{})["next_run_at"].(string)); !next.After(time.Now()) {
+		t.Fatalf("next run = %v", next)
+	}
+
+	resp = h.Request("POST", "/api/v1/reports/saved/"+id+"/run?deliver=true", nil, admin)
+	rows, _ := resp.Data()["rows"].([]interface{})
+	if resp.Status != 200 || len(rows) != 2 {
+		t.Fatalf("run: got %d: %s", resp.Status, resp.Raw)
+	}
+	// Rows are per line item and sorted by group; totals count whole orders
+	row := func(i int) (map[string]interface{}, map[string]interface{}) {
+		r := rows[i].(map[string]interface{})
+		return r["group"].(map[string]interface{}), r["metrics"].(map[string]interface{})
+	}
+	if group, metrics := row(0); group["sales"] != "Budi" || group["product"] != "Pasir" || metrics["revenue"] != 200000.0 || metrics["quantity"] != 2.0 {
+		t.Fatalf("first row = %v %v", group, metrics)
+	}
+	if group, metrics := row(1); group["product"] != "Semen" || metrics["revenue"] != 700000.0 || metrics["orders"] != 2.0 || metrics["quantity"] != 14.0 {
+		t.Fatalf("second row = %v %v", group, metrics)
+	}
+	if totals := resp.Data()["totals"].(map[string]interface{}); totals["revenue"] != 900000.0 || totals["orders"] != 2.0 || totals["quantity"] != 16.0 {
+		t.Fatalf("totals = %v", totals)
+	}
+
+	// WhatsApp recipients get the summary; email fails without an SMTP server but does not stop the run
+	deliveries, _ := resp.Data()["deliveries"].([]interface{})
+	if len(deliveries) != 2 || deliveries[0].(map[string]interface{})["sent"] != true || deliveries[1].(map[string]interface{})["sent"] != false {
+		t.Fatalf("deliveries = %v", deliveries)
+	}
+	if sent := h.WhatsApp.MessagesTo("6281111111111"); len(sent) != 1 || !strings.Contains(sent[0].Text, "Budi / Semen: revenue Rp 700000, orders 2, quantity 14") {
+		t.Fatalf("whatsapp summary = %v", sent)
+	}
+
+	resp = h.Request("GET", "/api/v1/reports/saved/"+id+"/runs", nil, admin)
+	runs, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(runs) != 1 {
+		t.Fatalf("runs: got %d: %s", resp.Status, resp.Raw)
+	}
+	runID := runs[0].(map[string]interface{})["id"].(string)
+	resp = h.Request("GET", "/api/v1/reports/saved/"+id+"/runs/"+runID+"/csv", nil, admin)
+	if resp.Status != 200 || !strings.HasPrefix(string(resp.Raw), "sales,product,revenue,orders,quantity\nBudi,Pasir,200000,1,2\n") {
+		t.Fatalf("csv: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	preview := map[string]interface{}{"group_by": []string{models.ReportGroupDay}, "metrics": []string{models.ReportMetricAvgOrderValue}}
+	resp = h.Request("POST", "/api/v1/reports/preview", preview, admin)
+	rows, _ = resp.Data()["rows"].([]interface{})
+	today := createdAt.In(time.FixedZone("WIB", 7*3600)).Format("2006-01-02")
+	if group, metrics := row(0); resp.Status != 200 || len(rows) != 1 || group["day"] != today || metrics["avg_order_value"] != 466666.67 {
+		t.Fatalf("preview: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A due schedule runs once and moves to its next slot
+	objID, _ := primitive.ObjectIDFromHex(id)
+	past := time.Now().Add(-time.Minute)
+	database.GetMongoCollection(reportbuilder.Collection).UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": bson.M{"schedule.next_run_at": past}})
+	reportbuilder.RunDue()
+	reportbuilder.RunDue()
+	if n := h.Count(reportbuilder.RunsCollection, bson.M{"report_id": id, "trigger": models.ReportTriggerSchedule}); n != 1 {
+		t.Fatalf("scheduled runs = %d", n)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	start := time.Now().Add(-time.Hour)
+	verifiedAt := time.Date(2026, 10, 1, 3, 4, 5, 0, time.UTC)
+	seedOrder(h, sales, func(o *models.Order) {
+		o.OrderNumber = "ORD-202610-0002"
+		o.CreatedAt = start.Add(time.Minute)
+		o.Status = models.OrderStatusConfirmed
+		o.PaymentStatus = models.PaymentStatusVerified
+		o.PaymentVerifiedAt = &verifiedAt
+		o.QueueNumber = 7
+	})
+	seedOrder(h, sales, func(o *models.Order) {
+		o.OrderNumber = "ORD-202610-0001"
+		o.CreatedAt = start
+		o.SalesID = "unknown"
+		o.CustomerName = "Toko \"Maju\", Jaya"
+	})
+
+	// records reads a streamed export, checking the BOM spreadsheets need
+	records := func(resp *testutil.Response) [][]string {
+		t.Helper()
+		if resp.Status != 200 || !strings.HasPrefix(string(resp.Raw), "\ufeff") {
+			t.Fatalf("export: got %d: %s", resp.Status, resp.Raw)
+		}
+		rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(resp.Raw), "\ufeff"))).ReadAll()
+		if err != nil {
+			t.Fatalf("parse csv: %v", err)
+		}
+		return rows
+	}
+
+	if resp := h.Request("GET", "/api/v1/orders/export/csv", nil, h.Token(models.RoleUser)); resp.Status != 403 {
+		t.Fatalf("user: status = %d", resp.Status)
+	}
+
+	rows := records(h.Request("GET", "/api/v1/orders/export/csv", nil, admin))
+	if len(rows) != 3 || rows[0][0] != "order_number" || len(rows[0]) != 16 {
+		t.Fatalf("rows = %v", rows)
+	}
+	// Oldest first; an unknown sales rep leaves the column empty
+	if first := rows[1]; first[0] != "ORD-202610-0001" || first[4] != "" || first[5] != `Toko "Maju", Jaya` || first[12] != "" {
+		t.Fatalf("first row = %v", first)
+	}
+	if second := rows[2]; second[4] != "Budi" || second[7] != "Semen x10 sak" || second[9] != "500000" || second[12] != "7" || second[13] != "2026-10-01 10:04:05" {
+		t.Fatalf("second row = %v", second)
+	}
+
+	rows = records(h.Request("GET", "/api/v1/orders/export/csv?status="+models.OrderStatusConfirmed, nil, admin))
+	if len(rows) != 2 || rows[1][0] != "ORD-202610-0002" {
+		t.Fatalf("filtered rows = %v", rows)
+	}
+
+	whole := models.NewDeliveryNote()
+	whole.NoteNumber = "SJ-202610-0001"
+	whole.ProductName, whole.ProductQty, whole.ProductUnit = "Semen", 10, "sak"
+	mixed := models.NewDeliveryNote()
+	mixed.NoteNumber = "SJ-202610-0002"
+	mixed.Revision = 2
+	mixed.CreatedAt = whole.CreatedAt.Add(time.Second)
+	mixed.Items = []models.DeliveryNoteItem{{ProductName: "Semen", Quantity: 4, Unit: "sak"}, {ProductName: "Pasir", Quantity: 2, Unit: "m3"}}
+	old := models.NewDeliveryNote()
+	old.NoteNumber = "SJ-202610-0002"
+	old.Superseded = true
+	h.Insert("delivery_notes", whole, mixed, old)
+
+	rows = records(h.Request("GET", "/api/v1/delivery/export/csv", nil, admin))
+	if len(rows) != 3 || rows[1][0] != "SJ-202610-0001" || rows[1][6] != "Semen" || rows[1][7] != "10" {
+		t.Fatalf("delivery rows = %v", rows)
+	}
+	if mixed := rows[2]; mixed[1] != "2" || mixed[6] != "Semen; Pasir" || mixed[7] != "4; 2" || mixed[8] != "sak; m3" {
+		t.Fatalf("mixed note = %v", mixed)
+	}
+}
+
+func TestSalesImport(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	existing := seedSales(h)
+
+	file := []byte("Nama,No HP,Email\n" +
+		"Budi Santoso,0812 3456 7890,budi@example.com\n" +
+		"Siti,+62 813 1111 2222,\n" +
+		"Siti Dua,081311112222,\n" +
+		",081399998888,\n" +
+		"Ani,12345,\n" +
+		",,\n")
+
+	// statuses lists the status of each reported row
+	statuses := func(resp *testutil.Response) []string {
+		t.Helper()
+		if resp.Status != 200 {
+			t.Fatalf("import: status = %d: %s", resp.Status, resp.Raw)
+		}
+		list := []string{}
+		for _, row := range resp.Data()["rows"].([]interface{}) {
+			list = append(list, row.(map[string]interface{})["status"].(string))
+		}
+		return list
+	}
+
+	if resp := h.Upload("/api/v1/sales/import", "file", "sales.csv", []byte("name,email\nBudi,b@example.com\n"), admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("no phone column: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Upload("/api/v1/sales/import", "file", "sales.txt", file, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unsupported file: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A dry run reports without saving; the blank line is not reported
+	want := "duplicate created duplicate invalid invalid"
+	resp := h.Upload("/api/v1/sales/import?dry_run=true", "file", "sales.csv", file, admin)
+	if got := strings.Join(statuses(resp), " "); got != want || h.Count("sales", bson.M{}) != 1 {
+		t.Fatalf("dry run: %s, %d sales", got, h.Count("sales", bson.M{}))
+	}
+
+	resp = h.Upload("/api/v1/sales/import", "file", "sales.csv", file, admin)
+	if got := strings.Join(statuses(resp), " "); got != want {
+		t.Fatalf("import: %s", got)
+	}
+	rows := resp.Data()["rows"].([]interface{})
+	if dup := rows[2].(map[string]interface{}); dup["message"] != "Same phone as row 3 of this file" {
+		t.Fatalf("duplicate in file = %v", dup)
+	}
+	if h.Count("sales", bson.M{"name": "Siti", "phone": "6281311112222"}) != 1 {
+		t.Fatal("the new rep was not saved with a normalized phone")
+	}
+
+	// In update mode the existing reps take the file's values
+	resp = h.Upload("/api/v1/sales/import?mode=update", "file", "sales.csv", file, admin)
+	if summary := resp.Data()["summary"].(map[string]interface{}); summary["updated"] != 2.0 || summary["created"] != 0.0 {
+		t.Fatalf("update summary = %v", summary)
+	}
+	updated := &models.Sales{}
+	h.Find("sales", bson.M{"_id": existing.ID}, updated)
+	if updated.Name != "Budi Santoso" || updated.Email != "budi@example.com" {
+		t.Fatalf("existing rep = %+v", updated)
+	}
+}
+
+func TestSalesMerge(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	source := seedSales(h)
+	target := models.NewSales()
+	target.Name = "Budi Santoso"
+	target.Phone = "6281299990000"
+	target.IsActive = true
+	h.Insert("sales", target)
+	other := models.NewSales()
+	other.Name = "Joko"
+	h.Insert("sales", other)
+
+	order := seedOrder(h, source, nil)
+	archived := seedOrder(h, source, nil)
+	database.GetMongoCollection("orders").DeleteOne(context.Background(), bson.M{"_id": archived.ID})
+	h.Insert(archive.Collection, archived)
+	untouched := seedOrder(h, other, nil)
+
+	note := models.NewDeliveryNote()
+	note.OrderID = order.ID.Hex()
+	note.SalesName, note.SalesPhone = source.Name, source.Phone
+	h.Insert("delivery_notes", note)
+	complaint := models.NewReturnRequest()
+	complaint.OrderID = archived.ID.Hex()
+	complaint.SalesName, complaint.SalesPhone = source.Name, source.Phone
+	h.Insert("returns", complaint)
+	pending := notification.Notification{ID: primitive.NewObjectID(), Phone: source.Phone, Message: "Invoice", OrderID: order.ID.Hex(), Status: notification.StatusPending}
+	toCustomer := notification.Notification{ID: primitive.NewObjectID(), Phone: "6285555555555", Message: "Invoice", OrderID: order.ID.Hex(), Status: notification.StatusSent}
+	h.Insert("notifications", pending, toCustomer)
+	report := models.NewReportDefinition()
+	report.Filter.SalesIDs = []string{other.ID.Hex(), source.ID.Hex()}
+	h.Insert(reportbuilder.Collection, report)
+
+	path := "/api/v1/sales/" + source.ID.Hex() + "/merge-into/"
+	if resp := h.Request("POST", path+source.ID.Hex(), nil, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("into itself: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp := h.Request("POST", path+target.ID.Hex(), nil, admin)
+	moved, _ := resp.Data()["moved"].(map[string]interface{})
+	if resp.Status != 200 || moved["orders"] != 1.0 || moved["archived_orders"] != 1.0 || moved["delivery_notes"] != 1.0 ||
+		moved["returns"] != 1.0 || moved["notifications"] != 1.0 || moved["reports"] != 1.0 {
+		t.Fatalf("merge: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	if h.Count("orders", bson.M{"sales_id": target.ID.Hex()}) != 1 || h.Count(archive.Collection, bson.M{"sales_id": target.ID.Hex()}) != 1 ||
+		h.Count("orders", bson.M{"_id": untouched.ID, "sales_id": other.ID.Hex()}) != 1 {
+		t.Fatal("orders were not reassigned to the target only")
+	}
+	// Snapshots take the target's name and phone; pending notifications get a new manual link
+	if h.Count("delivery_notes", bson.M{"sales_name": target.Name, "sales_phone": target.Phone}) != 1 || h.Count("returns", bson.M{"sales_phone": target.Phone}) != 1 {
+		t.Fatal("snapshots were not updated")
+	}
+	movedNotification := &notification.Notification{}
+	h.Find("notifications", bson.M{"_id": pending.ID}, movedNotification)
+	if movedNotification.Phone != target.Phone || !strings.Contains(movedNotification.FallbackLink, target.Phone) {
+		t.Fatalf("notification = %+v", movedNotification)
+	}
+	if h.Count("notifications", bson.M{"_id": toCustomer.ID, "phone": toCustomer.Phone}) != 1 {
+		t.Fatal("a notification to the customer was moved")
+	}
+	saved := &models.ReportDefinition{}
+	h.Find(reportbuilder.Collection, bson.M{"_id": report.ID}, saved)
+	if ids := saved.Filter.SalesIDs; len(ids) != 2 || ids[0] != other.ID.Hex() || ids[1] != target.ID.Hex() {
+		t.Fatalf("report sales filter = %v", ids)
+	}
+
+	merged := &models.Sales{}
+	h.Find("sales", bson.M{"_id": source.ID}, merged)
+	if merged.IsActive || merged.MergedInto != target.ID.Hex() || merged.MergedAt == nil {
+		t.Fatalf("source = %+v", merged)
+	}
+	if resp := h.Request("POST", path+target.ID.Hex(), nil, admin); resp.ErrorCode() != response.CodeSalesAlreadyMerged {
+		t.Fatalf("merging again: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/sales/"+other.ID.Hex()+"/merge-into/"+source.ID.Hex(), nil, admin); resp.ErrorCode() != response.CodeSalesAlreadyMerged {
+		t.Fatalf("merging into a merged rep: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestOrderTags(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	first := seedOrder(h, sales, nil)
+	second := seedOrder(h, sales, nil)
+	seedOrder(h, sales, nil)
+
+	if resp := h.Request("POST", "/api/v1/tags", map[string]interface{}{"name": "urgent", "color": "red"}, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("invalid color: got %d: %s", resp.Status, resp.Raw)
+	}
+	resp := h.Request("POST", "/api/v1/tags", map[string]interface{}{"name": " Urgent ", "color": "#e53935"}, admin)
+	if resp.Status != 201 || resp.Data()["name"] != "urgent" || resp.Data()["color"] != "#E53935" {
+		t.Fatalf("create: got %d: %s", resp.Status, resp.Raw)
+	}
+	tagID := resp.Data()["id"].(string)
+	if resp := h.Request("POST", "/api/v1/tags", map[string]interface{}{"name": "URGENT"}, admin); resp.ErrorCode() != response.CodeTagExists {
+		t.Fatalf("duplicate: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Tags are normalized and deduped; free-form tags get a stable palette color
+	resp = h.Request("PUT", "/api/v1/orders/"+first.ID.Hex()+"/tags", map[string]interface{}{"tags": []string{"Urgent", "urgent", " VIP   Customer"}}, admin)
+	hints, _ := resp.Data()["tag_hints"].([]interface{})
+	if resp.Status != 200 || len(hints) != 2 || hints[0].(map[string]interface{})["color"] != "#E53935" {
+		t.Fatalf("set tags: got %d: %s", resp.Status, resp.Raw)
+	}
+	free := hints[1].(map[string]interface{})
+	if free["name"] != "vip customer" || free["color"] == "" {
+		t.Fatalf("free-form hint = %v", free)
+	}
+	h.Request("PUT", "/api/v1/orders/"+second.ID.Hex()+"/tags", map[string]interface{}{"tags": []string{"urgent", "rush"}}, admin)
+	tooMany := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}
+	if resp := h.Request("PUT", "/api/v1/orders/"+second.ID.Hex()+"/tags", map[string]interface{}{"tags": tooMany}, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("too many tags: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	count := func(query string) int {
+		t.Helper()
+		resp := h.Request("GET", "/api/v1/orders?"+query, nil, admin)
+		orders, _ := resp.Body["data"].([]interface{})
+		if resp.Status != 200 {
+			t.Fatalf("list %s: got %d: %s", query, resp.Status, resp.Raw)
+		}
+		return len(orders)
+	}
+	if n := count("tags=urgent,vip%20customer"); n != 2 {
+		t.Fatalf("any tag: %d orders", n)
+	}
+	if n := count("tags=Urgent,rush&tag_mode=all"); n != 1 {
+		t.Fatalf("all tags: %d orders", n)
+	}
+
+	resp = h.Request("GET", "/api/v1/dashboard/stats", nil, admin)
+	stats, _ := resp.Data()["tags"].([]interface{})
+	if len(stats) != 3 || stats[0].(map[string]interface{})["name"] != "urgent" || stats[0].(map[string]interface{})["open_count"] != 2.0 {
+		t.Fatalf("dashboard tags = %v", stats)
+	}
+
+	// Renaming onto a tag an order already has does not duplicate it
+	if resp := h.Request("PUT", "/api/v1/tags/"+tagID, map[string]interface{}{"name": "rush"}, admin); resp.Status != 200 {
+		t.Fatalf("rename: got %d: %s", resp.Status, resp.Raw)
+	}
+	renamed, other := &models.Order{}, &models.Order{}
+	h.Find("orders", bson.M{"_id": first.ID}, renamed)
+	h.Find("orders", bson.M{"_id": second.ID}, other)
+	if strings.Join(renamed.Tags, ",") != "rush,vip customer" || strings.Join(other.Tags, ",") != "rush" {
+		t.Fatalf("tags after rename: %v, %v", renamed.Tags, other.Tags)
+	}
+
+	resp = h.Request("GET", "/api/v1/tags", nil, admin)
+	entries, _ := resp.Body["data"].([]interface{})
+	if len(entries) != 2 || entries[0].(map[string]interface{})["in_catalog"] != true || entries[0].(map[string]interface{})["orders"] != 2.0 {
+		t.Fatalf("catalog: %s", resp.Raw)
+	}
+
+	if resp := h.Request("DELETE", "/api/v1/tags/"+tagID+"?remove_from_orders=true", nil, admin); resp.Status != 200 {
+		t.Fatalf("delete: got %d: %s", resp.Status, resp.Raw)
+	}
+	if n := h.Count("orders", bson.M{"tags": "rush"}); n != 0 {
+		t.Fatalf("%d orders still tagged", n)
+	}
+}
+
+func TestSavedViews(t *testing.T) {
+	h := testutil.New(t)
+	owner := h.TokenFor("admin-1", models.RoleAdmin)
+	other := h.TokenFor("admin-2", models.RoleAdmin)
+	sales := seedSales(h)
+	small := seedOrder(h, sales, nil)
+	large := seedOrder(h, sales, func(order *models.Order) { order.TotalPrice = 900000 })
+	completed := seedOrder(h, sales, func(order *models.Order) { order.Status = models.OrderStatusCompleted })
+
+	for _, body := range []map[string]interface{}{
+		{"name": "Pending", "resource": "orders", "filter": map[string]string{"bank": "bca"}},
+		{"name": "Pending", "resource": "orders", "sort": "-price"},
+		{"name": "Pending", "resource": "invoices"},
+		{"name": " ", "resource": "orders"},
+	} {
+		if resp := h.Request("POST", "/api/v1/views", body, owner); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+
+	resp := h.Request("POST", "/api/v1/views", map[string]interface{}{
+		"name": "Pending by value", "resource": "orders", "filter": map[string]string{"status": "pending"}, "sort": "-total_price",
+	}, owner)
+	if resp.Status != 201 || resp.Data()["owner_id"] != "admin-1" {
+		t.Fatalf("create: got %d: %s", resp.Status, resp.Raw)
+	}
+	viewID := resp.Data()["id"].(string)
+
+	list := func(query string) []string {
+		t.Helper()
+		resp := h.Request("GET", "/api/v1/orders?"+query, nil, owner)
+		if resp.Status != 200 {
+			t.Fatalf("list %s: got %d: %s", query, resp.Status, resp.Raw)
+		}
+		ids := []string{}
+		for _, order := range resp.Body["data"].([]interface{}) {
+			ids = append(ids, order.(map[string]interface{})["id"].(string))
+		}
+		return ids
+	}
+	if ids := list("view=" + viewID); len(ids) != 2 || ids[0] != large.ID.Hex() || ids[1] != small.ID.Hex() {
+		t.Fatalf("view applied: %v", ids)
+	}
+	// Parameters in the request win over the view's
+	if ids := list("view=" + viewID + "&status=completed"); len(ids) != 1 || ids[0] != completed.ID.Hex() {
+		t.Fatalf("explicit status: %v", ids)
+	}
+
+	if resp := h.Request("GET", "/api/v1/payments/pending?view="+viewID, nil, owner); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("wrong resource: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/orders?view=nope", nil, owner); resp.ErrorCode() != response.CodeInvalidID {
+		t.Fatalf("invalid view: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/orders?view="+primitive.NewObjectID().Hex(), nil, owner); resp.ErrorCode() != response.CodeViewNotFound {
+		t.Fatalf("missing view: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A private view is only visible to its owner
+	if resp := h.Request("GET", "/api/v1/views/"+viewID, nil, other); resp.ErrorCode() != response.CodeViewNotFound {
+		t.Fatalf("private view: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/orders?view="+viewID, nil, other); resp.ErrorCode() != response.CodeViewNotFound {
+		t.Fatalf("private view applied: got %d: %s", resp.Status, resp.Raw)
+	}
+	share := map[string]interface{}{
+		"name": "Pending by value", "resource": "orders", "filter": map[string]string{"status": "pending"}, "sort": "-total_price", "shared": true,
+	}
+	if resp := h.Request("PUT", "/api/v1/views/"+viewID, share, owner); resp.Status != 200 || resp.Data()["shared"] != true {
+		t.Fatalf("share: got %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("GET", "/api/v1/views?resource=orders", nil, other)
+	views, _ := resp.Data()["views"].([]interface{})
+	if resp.Status != 200 || len(views) != 1 {
+		t.Fatalf("shared list: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Shared views stay editable by the owner or a superadmin only
+	if resp := h.Request("PUT", "/api/v1/views/"+viewID, share, other); resp.Status != 403 || resp.ErrorCode() != response.CodeInsufficientPermissions {
+		t.Fatalf("edit by another admin: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("DELETE", "/api/v1/views/"+viewID, nil, other); resp.Status != 403 || resp.ErrorCode() != response.CodeInsufficientPermissions {
+		t.Fatalf("delete by another admin: got %d: %s", resp.Status, resp.Raw)
+	}
+	share["name"] = "Open orders"
+	if resp := h.Request("PUT", "/api/v1/views/"+viewID, share, h.Token(models.RoleSuperAdmin)); resp.Status != 200 || resp.Data()["name"] != "Open orders" {
+		t.Fatalf("edit by superadmin: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("DELETE", "/api/v1/views/"+viewID, nil, owner); resp.Status != 200 || h.Count("views", bson.M{}) != 0 {
+		t.Fatalf("delete: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	for i := 0; i < 3; i++ {
+		seedOrder(h, sales, nil)
+	}
+
+	list := func(query string) ([]interface{}, map[string]interface{}) {
+		t.Helper()
+		resp := h.Request("GET", "/api/v1/orders?"+query, nil, admin)
+		orders, _ := resp.Body["data"].([]interface{})
+		pagination, _ := resp.Body["pagination"].(map[string]interface{})
+		if resp.Status != 200 || pagination == nil {
+			t.Fatalf("list %s: got %d: %s", query, resp.Status, resp.Raw)
+		}
+		return orders, pagination
+	}
+
+	orders, p := list("limit=2")
+	if len(orders) != 2 || p["total_items"] != 3.0 || p["total_pages"] != 2.0 || p["has_next"] != true {
+		t.Fatalf("with total: %d orders, %v", len(orders), p)
+	}
+
+	// Without a total, the extra document fetched only tells whether a next page exists
+	orders, p = list("limit=2&with_total=false")
+	if len(orders) != 2 || p["total_items"] != -1.0 || p["total_pages"] != -1.0 || p["has_next"] != true || p["has_prev"] != false {
+		t.Fatalf("without total, first page: %d orders, %v", len(orders), p)
+	}
+	orders, p = list("limit=2&page=2&with_total=false")
+	if len(orders) != 1 || p["has_next"] != false || p["has_prev"] != true {
+		t.Fatalf("without total, last page: %d orders, %v", len(orders), p)
+	}
+	orders, p = list("limit=3&with_total=false")
+	if len(orders) != 3 || p["has_next"] != false {
+		t.Fatalf("without total, exact page: %d orders, %v", len(orders), p)
+	}
+
+	// Page sizes are capped per list and out of range values fall back
+	if _, p = list("limit=1000"); p["per_page"] != 100.0 {
+		t.Fatalf("capped limit: %v", p)
+	}
+	if _, p = list("limit=0&page=-1"); p["per_page"] != 10.0 || p["current_page"] != 1.0 {
+		t.Fatalf("default limit: %v", p)
+	}
+	resp := h.Request("GET", "/api/v1/queue?limit=1000", nil, admin)
+	if p, _ := resp.Body["pagination"].(map[string]interface{}); p["per_page"] != 200.0 {
+		t.Fatalf("queue limit: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestDashboardTrends(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	day := func(ago int) time.Time { return today.AddDate(0, 0, -ago).Add(3 * time.Hour) }
+
+	seedOrder(h, sales, func(order *models.Order) {
+		entered, called := day(2).Add(time.Hour), day(2).Add(80*time.Minute)
+		order.CreatedAt = day(2)
+		order.Status = models.OrderStatusCompleted
+		order.QueueEnteredAt, order.QueueCalledAt = &entered, &called
+	})
+	seedOrder(h, sales, func(order *models.Order) {
+		order.CreatedAt = day(2)
+		order.Status = models.OrderStatusCancelled
+		order.TotalPrice = 300000
+	})
+	seedOrder(h, sales, func(order *models.Order) {
+		order.CreatedAt = day(1)
+		order.Status = models.OrderStatusDeclined
+	})
+	archived := seedOrder(h, sales, func(order *models.Order) { order.CreatedAt = day(1) })
+	database.GetMongoCollection("orders").DeleteOne(context.Background(), bson.M{"_id": archived.ID})
+	h.Insert(archive.Collection, archived)
+	seedOrder(h, sales, func(order *models.Order) { order.CreatedAt = today.Add(time.Second) })
+
+	// A stored snapshot is served as it is, not recomputed
+	h.Insert(dailystats.Collection, &models.DailyStats{Date: today.AddDate(0, 0, -3).Format("2006-01-02"), Orders: 42})
+
+	if resp := h.Request("GET", "/api/v1/dashboard/trends?days=400", nil, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("too many days: got %d: %s", resp.Status, resp.Raw)
+	}
+	resp := h.Request("GET", "/api/v1/dashboard/trends?days=4", nil, admin)
+	series, _ := resp.Data()["series"].([]interface{})
+	if resp.Status != 200 || len(series) != 4 {
+		t.Fatalf("trends: got %d: %s", resp.Status, resp.Raw)
+	}
+	point := func(i int) map[string]interface{} { return series[i].(map[string]interface{}) }
+	if point(0)["orders"] != 42.0 || point(0)["date"] != today.AddDate(0, 0, -3).Format("2006-01-02") {
+		t.Fatalf("stored point = %v", point(0))
+	}
+	// Cancelled and declined orders count as orders but not as revenue
+	two := point(1)
+	if two["orders"] != 2.0 || two["completed"] != 1.0 || two["cancelled"] != 1.0 || two["revenue"] != 500000.0 {
+		t.Fatalf("two days ago = %v", two)
+	}
+	if two["queue_entered"] != 1.0 || two["queue_served"] != 1.0 || two["avg_wait_minutes"] != 20.0 {
+		t.Fatalf("two days ago queue = %v", two)
+	}
+	if one := point(2); one["orders"] != 2.0 || one["revenue"] != 500000.0 || one["live"] != nil {
+		t.Fatalf("yesterday = %v", one)
+	}
+	if live := point(3); live["orders"] != 1.0 || live["live"] != true {
+		t.Fatalf("today = %v", live)
+	}
+
+	// Missing past days were stored on the way, today is never stored
+	if n := h.Count(dailystats.Collection, bson.M{}); n != 3 {
+		t.Fatalf("%d snapshots stored", n)
+	}
+	dailystats.Run()
+	if n := h.Count(dailystats.Collection, bson.M{}); n != 7 {
+		t.Fatalf("%d snapshots after the backfill", n)
+	}
+	if n := h.Count(dailystats.Collection, bson.M{"_id": today.Format("2006-01-02")}); n != 0 {
+		t.Fatal("today was snapshotted")
+	}
+}
+
+func TestOrderAcceptance(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	previous := config.Cfg.Client
+	t.Cleanup(func() { config.Cfg.Client = previous })
+	config.Cfg.Client.RequireAcceptance = true
+
+	sales := seedSales(h)
+	accepted := seedOrder(h, sales, nil)
+	declined := seedOrder(h, sales, func(order *models.Order) { order.TotalPrice = 300000 })
+	h.Insert("status_notifications", &models.StatusNotificationRule{
+		BaseModel:  models.BaseModel{ID: primitive.NewObjectID()},
+		Status:     models.OrderStatusDeclined,
+		Enabled:    true,
+		Template:   "Penolakan order {order_number} diterima",
+		Recipients: []string{models.RecipientSales},
+	})
+
+	resp := h.Request("GET", "/api/v1/client/invoice/"+accepted.InvoiceToken, nil, "")
+	if resp.Status != 200 || resp.Data()["needs_accept"] != true {
+		t.Fatalf("invoice: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Upload("/api/v1/client/payment/"+accepted.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), ""); resp.ErrorCode() != response.CodeOrderNotAccepted {
+		t.Fatalf("upload before accepting: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	for _, body := range []map[string]string{{"action": "maybe"}, {"action": "decline", "reason": " "}} {
+		if resp := h.Request("POST", "/api/v1/client/confirm/"+accepted.InvoiceToken, body, ""); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+	if resp := h.Request("POST", "/api/v1/client/confirm/unknown", map[string]string{"action": "accept"}, ""); resp.ErrorCode() != response.CodeOrderNotFound {
+		t.Fatalf("unknown token: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", "/api/v1/client/confirm/"+accepted.InvoiceToken, map[string]string{"action": "accept"}, "")
+	if resp.Status != 200 || resp.Data()["status"] != models.OrderStatusPending || resp.Data()["accepted_at"] == nil {
+		t.Fatalf("accept: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/client/confirm/"+accepted.InvoiceToken, map[string]string{"action": "decline", "reason": "Salah"}, ""); resp.Status != 409 || resp.ErrorCode() != response.CodeConflict {
+		t.Fatalf("answer twice: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/client/invoice/"+accepted.InvoiceToken, nil, ""); resp.Data()["needs_accept"] != nil {
+		t.Fatalf("invoice after accepting: %s", resp.Raw)
+	}
+	if resp := h.Upload("/api/v1/client/payment/"+accepted.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), ""); resp.Status != 200 {
+		t.Fatalf("upload after accepting: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", "/api/v1/client/confirm/"+declined.InvoiceToken, map[string]string{"action": "decline", "reason": " Harga berubah "}, "")
+	if resp.Status != 200 || resp.Data()["status"] != models.OrderStatusDeclined || resp.Data()["decline_reason"] != "Harga berubah" {
+		t.Fatalf("decline: got %d: %s", resp.Status, resp.Raw)
+	}
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": declined.ID}, stored)
+	last := stored.StatusHistory[len(stored.StatusHistory)-1]
+	if stored.Status != models.OrderStatusDeclined || stored.DeclinedAt == nil || last.Status != models.OrderStatusDeclined {
+		t.Fatalf("declined order = %+v", stored)
+	}
+	h.Eventually(func() bool {
+		for _, message := range h.WhatsApp.MessagesTo(sales.Phone) {
+			if strings.Contains(message.Text, "Penolakan order "+declined.OrderNumber+" diterima") {
+				return true
+			}
+		}
+		return false
+	}, "decline not confirmed to the sales rep")
+	if resp := h.Request("POST", "/api/v1/client/confirm/"+declined.InvoiceToken, map[string]string{"action": "accept"}, ""); resp.ErrorCode() != response.CodeOrderInvalidStatus {
+		t.Fatalf("accept after declining: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Declined orders are not revenue
+	resp = h.Request("GET", "/api/v1/dashboard/stats", nil, admin)
+	orders, _ := resp.Data()["orders"].(map[string]interface{})
+	if orders["revenue"] != 500000.0 || orders["today_revenue"] != 500000.0 {
+		t.Fatalf("dashboard revenue: %s", resp.Raw)
+	}
+}
+
+// placeGeocoder places a fixed set of addresses
+type placeGeocoder map[string]geocode.Result
+
+func (g placeGeocoder) Geocode(ctx context.Context, address string) (*geocode.Result, error) {
+	place, ok := g[address]
+	if !ok {
+		return nil, geocode.ErrNotFound
+	}
+	return &place, nil
+}
+
+func TestDeliveryFee(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	// Points on the equator east of the depot, 1.2 road factor: 1.3, 6.7, 20.0 and 66.7 km
+	geocode.Register("places", func(cfg config.GeocodeConfig) geocode.Provider {
+		return placeGeocoder{
+			"Depot":  {Lat: 0, Lng: 0, Address: "Depot"},
+			"Dekat":  {Lat: 0, Lng: 0.01, Address: "Jl. Dekat"},
+			"Sedang": {Lat: 0, Lng: 0.05, Address: "Jl. Sedang"},
+			"Jauh":   {Lat: 0, Lng: 0.15, Address: "Jl. Jauh"},
+			"Luar":   {Lat: 0, Lng: 0.5, Address: "Luar Kota"},
+		}
+	})
+	previous := config.Cfg.Geocode
+	t.Cleanup(func() { config.Cfg.Geocode = previous })
+	config.Cfg.Geocode.Provider = "places"
+
+	resp := h.Request("GET", "/api/v1/settings/delivery-fee", nil, admin)
+	if resp.Status != 200 || resp.Data()["geocoding_enabled"] != true || resp.Data()["policy"].(map[string]interface{})["enabled"] != false {
+		t.Fatalf("default policy: got %d: %s", resp.Status, resp.Raw)
+	}
+	quote := func(address string) *testutil.Response {
+		return h.Request("POST", "/api/v1/orders/delivery-fee/quote", map[string]string{"address": address}, admin)
+	}
+	if resp := quote("Dekat"); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("quote while disabled: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	tiers := []map[string]float64{{"up_to_km": 30, "per_km": 4000}, {"up_to_km": 10, "per_km": 5000}}
+	for _, body := range []map[string]interface{}{
+		{"enabled": true, "depot_address": "Depot"},
+		{"enabled": true, "depot_address": "Depot", "tiers": []map[string]float64{{"up_to_km": 10, "per_km": 1}, {"up_to_km": 10, "per_km": 2}}},
+		{"enabled": true, "depot_address": "Depot", "tiers": tiers, "road_factor": 0.5},
+		{"enabled": true, "depot_lat": 91, "depot_lng": 0, "tiers": tiers},
+	} {
+		if resp := h.Request("PUT", "/api/v1/settings/delivery-fee", body, admin); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+	if resp := h.Request("PUT", "/api/v1/settings/delivery-fee", map[string]interface{}{"enabled": true, "depot_address": "Gudang", "tiers": tiers}, admin); resp.ErrorCode() != response.CodeAddressNotFound {
+		t.Fatalf("unknown depot: got %d: %s", resp.Status, resp.Raw)
+	}
+	// The depot address is geocoded and the tiers are sorted
+	resp = h.Request("PUT", "/api/v1/settings/delivery-fee", map[string]interface{}{
+		"enabled": true, "depot_address": "Depot", "tiers": tiers, "road_factor": 1.2, "minimum_fee": 20000, "round_to": 1000,
+	}, admin)
+	if resp.Status != 200 || resp.Data()["tiers"].([]interface{})[0].(map[string]interface{})["up_to_km"] != 10.0 {
+		t.Fatalf("update policy: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	for address, want := range map[string][2]float64{"Dekat": {1.3, 20000}, "Sedang": {6.7, 34000}, "Jauh": {20, 80000}} {
+		resp := quote(address)
+		if resp.Status != 200 || resp.Data()["distance_km"] != want[0] || resp.Data()["fee"] != want[1] {
+			t.Fatalf("quote %s: got %d: %s", address, resp.Status, resp.Raw)
+		}
+	}
+	if resp := quote("Luar"); resp.ErrorCode() != response.CodeDeliveryOutOfArea {
+		t.Fatalf("out of area: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := quote("Entah"); resp.ErrorCode() != response.CodeAddressNotFound {
+		t.Fatalf("unknown address: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	create := func(extra map[string]interface{}) *testutil.Response {
+		body := map[string]interface{}{
+			"sales_id": sales.ID.Hex(),
+			"items":    []map[string]interface{}{{"product_name": "Semen", "quantity": 10, "unit_price": 50000}},
+		}
+		for key, value := range extra {
+			body[key] = value
+		}
+		return h.Request("POST", "/api/v1/orders", body, admin)
+	}
+	resp = create(map[string]interface{}{"delivery_address": "Sedang"})
+	order, _ := resp.Data()["order"].(map[string]interface{})
+	if resp.Status != 201 || order["subtotal"] != 500000.0 || order["delivery_fee"] != 34000.0 || order["total_price"] != 534000.0 {
+		t.Fatalf("create with address: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := create(map[string]interface{}{"delivery_address": "Luar"}); resp.ErrorCode() != response.CodeDeliveryOutOfArea {
+		t.Fatalf("create out of area: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := create(map[string]interface{}{"delivery_address": "Luar", "delivery_fee": -1}); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("negative fee: got %d: %s", resp.Status, resp.Raw)
+	}
+	// A fee given with the order wins over the policy
+	resp = create(map[string]interface{}{"delivery_address": "Luar", "delivery_fee": 150000})
+	if given, _ := resp.Data()["order"].(map[string]interface{}); resp.Status != 201 || given["total_price"] != 650000.0 {
+		t.Fatalf("given fee: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Moving an unpaid order prices its delivery again
+	resp = h.Request("PUT", "/api/v1/orders/"+order["id"].(string)+"/shipment", map[string]string{"delivery_address": "Jauh"}, admin)
+	if resp.Status != 200 || resp.Data()["delivery_fee"] != 80000.0 {
+		t.Fatalf("move order: got %d: %s", resp.Status, resp.Raw)
+	}
+	orderID, _ := primitive.ObjectIDFromHex(order["id"].(string))
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": orderID}, stored)
+	if stored.TotalPrice != 580000 || stored.DeliveryDistanceKm != 20 || stored.DeliveryLocation == nil || stored.DeliveryLocation.Lng != 0.15 {
+		t.Fatalf("moved order = %+v", stored)
+	}
+}
+
+func TestNumberingGaps(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	month := numbering.Month(time.Now())
+	number := func(seq int) string { return numbering.Format(numbering.SeriesOrder, month, seq) }
+
+	// Orders are numbered in sequence within the month
+	for seq := 1; seq <= 2; seq++ {
+		resp := h.Request("POST", "/api/v1/orders", map[string]interface{}{
+			"sales_id":      sales.ID.Hex(),
+			"customer_name": "Toko Maju",
+			"items":         []map[string]interface{}{{"product_name": "Semen", "quantity": 10, "unit_price": 50000}},
+		}, admin)
+		if order, _ := resp.Data()["order"].(map[string]interface{}); resp.Status != 201 || order["order_number"] != number(seq) {
+			t.Fatalf("create %d: status = %d: %s", seq, resp.Status, resp.Raw)
+		}
+	}
+
+	// 0003 was released when its save failed, 0004 was lost, 0005 is used twice
+	ctx := context.Background()
+	for seq := 3; seq <= 5; seq++ {
+		if allocated, err := numbering.Next(ctx, numbering.SeriesOrder); err != nil || allocated != number(seq) {
+			t.Fatalf("next = %q (%v)", allocated, err)
+		}
+	}
+	if err := numbering.Release(ctx, number(3), "save failed"); err != nil {
+		t.Fatal(err)
+	}
+	seedOrder(h, sales, func(o *models.Order) {
+		o.OrderNumber = number(5)
+		o.Status = models.OrderStatusCancelled
+	})
+	archived := models.NewOrder()
+	archived.OrderNumber = number(5)
+	archived.Status = models.OrderStatusCompleted
+	h.Insert(archive.Collection, archived)
+	seedOrder(h, sales, func(o *models.Order) { o.OrderNumber = "ORD-" + month + "14120000" })
+
+	path := "/api/v1/reports/numbering-gaps?series=ORD&from=" + time.Now().Format("2006-01") + "&to=" + time.Now().Format("2006-01")
+	check := func() map[string]interface{} {
+		t.Helper()
+		resp := h.Request("GET", path, nil, admin)
+		if resp.Status != 200 || resp.Data()["series"] != numbering.SeriesOrder {
+			t.Fatalf("report: status = %d: %s", resp.Status, resp.Raw)
+		}
+		months, _ := resp.Data()["months"].([]interface{})
+		if len(months) != 1 {
+			t.Fatalf("months = %v", resp.Data()["months"])
+		}
+		result := months[0].(map[string]interface{})
+		result["summary"] = resp.Data()["summary"]
+		return result
+	}
+
+	result := check()
+	if result["allocated"] != 5.0 || result["used"] != 3.0 || result["cancelled"] != 1.0 || result["legacy"] != 1.0 ||
+		result["first"] != number(1) || result["last"] != number(5) {
+		t.Fatalf("month = %v", result)
+	}
+	gaps, _ := result["gaps"].([]interface{})
+	if len(gaps) != 1 {
+		t.Fatalf("gaps = %v", result["gaps"])
+	}
+	gap := gaps[0].(map[string]interface{})
+	if gap["from"] != number(3) || gap["to"] != number(4) || gap["missing"] != 2.0 || gap["explained"] != false || len(gap["voids"].([]interface{})) != 1 {
+		t.Fatalf("gap = %v", gap)
+	}
+	duplicates, _ := result["duplicates"].([]interface{})
+	if len(duplicates) != 1 || duplicates[0].(map[string]interface{})["number"] != number(5) || len(duplicates[0].(map[string]interface{})["ids"].([]interface{})) != 2 {
+		t.Fatalf("duplicates = %v", result["duplicates"])
+	}
+	if summary := result["summary"].(map[string]interface{}); summary["gaps"] != 1.0 || summary["unexplained"] != 1.0 || summary["duplicates"] != 1.0 {
+		t.Fatalf("summary = %v", summary)
+	}
+
+	// Only allocated, unused numbers can be voided, and only once
+	for _, tc := range []struct {
+		body   map[string]interface{}
+		status int
+		code   response.Code
+	}{
+		{map[string]interface{}{"number": number(4)}, 400, response.CodeValidationFailed},
+		{map[string]interface{}{"number": "ORD-1", "reason": "spoiled"}, 400, response.CodeValidationFailed},
+		{map[string]interface{}{"number": number(6), "reason": "spoiled"}, 400, response.CodeValidationFailed},
+		{map[string]interface{}{"number": number(1), "reason": "spoiled"}, 409, response.CodeConflict},
+		{map[string]interface{}{"number": number(3), "reason": "spoiled"}, 409, response.CodeConflict},
+	} {
+		if resp := h.Request("POST", "/api/v1/reports/numbering-gaps/voids", tc.body, admin); resp.Status != tc.status || resp.ErrorCode() != tc.code {
+			t.Fatalf("void %v: status = %d: %s", tc.body, resp.Status, resp.Raw)
+		}
+	}
+	if resp := h.Request("POST", "/api/v1/reports/numbering-gaps/voids", map[string]interface{}{"number": " " + number(4) + " ", "reason": " lost in testing "}, admin); resp.Status != 201 {
+		t.Fatalf("void: status = %d: %s", resp.Status, resp.Raw)
+	}
+	void := &numbering.Void{}
+	h.Find(numbering.VoidsCollection, bson.M{"number": number(4)}, void)
+	if void.Seq != 4 || void.Month != month || !strings.HasPrefix(void.Reason, "lost in testing (by ") {
+		t.Fatalf("void = %+v", void)
+	}
+
+	// The voids explain the gap
+	result = check()
+	gap = result["gaps"].([]interface{})[0].(map[string]interface{})
+	if gap["explained"] != true || len(gap["voids"].([]interface{})) != 2 || result["unexplained"] != 0.0 {
+		t.Fatalf("explained gap = %v", result)
+	}
+
+	// Delivery notes are the default series, over the last 12 months
+	resp := h.Request("GET", "/api/v1/reports/numbering-gaps", nil, admin)
+	if months, _ := resp.Data()["months"].([]interface{}); resp.Status != 200 || resp.Data()["series"] != numbering.SeriesDeliveryNote || len(months) != 12 {
+		t.Fatalf("default report: status = %d: %s", resp.Status, resp.Raw)
+	}
+	for _, query := range []string{"series=XX", "from=2026-13", "from=2026-10&to=2026-09", "from=2020-01&to=2026-10"} {
+		if resp := h.Request("GET", "/api/v1/reports/numbering-gaps?"+query, nil, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%s: status = %d: %s", query, resp.Status, resp.Raw)
+		}
+	}
+
+	// Without the counters the number of allocated numbers is unknown
+	h.Mongo.Fail(numbering.CountersCollection)
+	if resp := h.Request("GET", "/api/v1/reports/numbering-gaps", nil, admin); resp.Status != 500 {
+		t.Fatalf("counters unavailable: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestInvoiceViews(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	order := seedOrder(h, sales, nil)
+
+	// Each open of the invoice link is logged with its browser
+	for i, agent := range []string{"Mozilla/5.0 (Android)", "Mozilla/5.0 (iPhone)"} {
+		req := httptest.NewRequest("GET", "/api/v1/client/invoice/"+order.InvoiceToken, nil)
+		req.Header.Set("User-Agent", agent)
+		if resp := h.Send(req); resp.Status != 200 {
+			t.Fatalf("invoice: status = %d: %s", resp.Status, resp.Raw)
+		}
+		h.Eventually(func() bool {
+			stored := &models.Order{}
+			h.Find("orders", bson.M{"_id": order.ID}, stored)
+			return stored.InvoiceViewCount == i+1 && stored.InvoiceFirstViewedAt != nil
+		}, "the invoice view was not recorded")
+		time.Sleep(time.Millisecond)
+	}
+
+	resp := h.Request("GET", "/api/v1/orders/"+order.ID.Hex()+"/invoice-views", nil, admin)
+	views, _ := resp.Data()["views"].([]interface{})
+	if resp.Status != 200 || resp.Data()["view_count"] != 2.0 || resp.Data()["first_viewed_at"] == nil || resp.Data()["last_viewed_at"] == nil || len(views) != 2 {
+		t.Fatalf("views: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if latest := views[0].(map[string]interface{}); latest["user_agent"] != "Mozilla/5.0 (iPhone)" || latest["order_number"] != order.OrderNumber {
+		t.Fatalf("latest view = %v", latest)
+	}
+	if resp := h.Request("GET", "/api/v1/orders/bad/invoice-views", nil, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeInvalidID {
+		t.Fatalf("bad id: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/orders/"+primitive.NewObjectID().Hex()+"/invoice-views", nil, admin); resp.ErrorCode() != response.CodeOrderNotFound {
+		t.Fatalf("missing order: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestInvoiceUnviewedEvent(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	saved := config.Cfg.Client.InvoiceUnviewedAfter
+	config.Cfg.Client.InvoiceUnviewedAfter = time.Hour
+	t.Cleanup(func() { config.Cfg.Client.InvoiceUnviewedAfter = saved })
+
+	old := time.Now().Add(-2 * time.Hour)
+	viewedAt := time.Now().Add(-90 * time.Minute)
+	unviewed := seedOrder(h, sales, func(o *models.Order) { o.CreatedAt = old })
+	seedOrder(h, sales, func(o *models.Order) {
+		o.CreatedAt = old
+		o.InvoiceFirstViewedAt = &viewedAt
+	})
+	seedOrder(h, sales, func(o *models.Order) {
+		o.CreatedAt = old
+		o.Status = models.OrderStatusPaid
+	})
+	seedOrder(h, sales, nil)
+
+	// Only the old, pending, unopened invoice is reported, and only once
+	invoiceview.CheckUnviewed()
+	invoiceview.CheckUnviewed()
+	if count := h.Count(events.Collection, bson.M{"type": events.InvoiceUnviewed}); count != 1 {
+		t.Fatalf("%d invoice.unviewed events, want 1", count)
+	}
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": unviewed.ID}, stored)
+	if stored.UnviewedEventAt == nil {
+		t.Fatalf("unviewed order = %+v", stored)
+	}
+
+	resp := h.Request("GET", "/api/v1/events?type="+events.InvoiceUnviewed, nil, admin)
+	list, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(list) != 1 {
+		t.Fatalf("events: status = %d: %s", resp.Status, resp.Raw)
+	}
+	event := list[0].(map[string]interface{})
+	data, _ := event["data"].(map[string]interface{})
+	if event["resource_id"] != unviewed.ID.Hex() || data["order_number"] != unviewed.OrderNumber || data["sales_id"] != sales.ID.Hex() {
+		t.Fatalf("event = %v", event)
+	}
+	if resp := h.Request("GET", "/api/v1/events", nil, h.Token(models.RoleUser)); resp.Status != 403 {
+		t.Fatalf("user: status = %d", resp.Status)
+	}
+
+	// A zero delay turns the check off
+	config.Cfg.Client.InvoiceUnviewedAfter = 0
+	seedOrder(h, sales, func(o *models.Order) { o.CreatedAt = old })
+	invoiceview.CheckUnviewed()
+	if count := h.Count(events.Collection, bson.M{"type": events.InvoiceUnviewed}); count != 1 {
+		t.Fatalf("disabled check: %d events", count)
+	}
+}
+
+func TestUnits(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	resp := h.Request("GET", "/api/v1/units", nil, admin)
+	units, _ := resp.Data()["units"].([]interface{})
+	if resp.Status != 200 || len(units) != 7 {
+		t.Fatalf("default units: status = %d, %d units", resp.Status, len(units))
+	}
+
+	resp = h.Request("POST", "/api/v1/units", map[string]interface{}{
+		"code": " Dus", "name": "Dus", "dimension": models.UnitDimensionCount, "factor": 24, "aliases": []string{"Karton", "dus"},
+	}, admin)
+	if resp.Status != 201 || resp.Data()["code"] != "dus" {
+		t.Fatalf("create: status = %d: %s", resp.Status, resp.Raw)
+	}
+	id, _ := resp.Data()["id"].(string)
+	if n := h.Count("units", bson.M{}); n != 8 {
+		t.Fatalf("%d units stored, want the defaults and dus", n)
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"taken alias":       {"code": "karung", "dimension": models.UnitDimensionMass, "factor": 50, "aliases": []string{"zak"}},
+		"unknown dimension": {"code": "karung", "dimension": "area", "factor": 1},
+		"zero factor":       {"code": "karung", "dimension": models.UnitDimensionMass},
+	} {
+		if resp := h.Request("POST", "/api/v1/units", body, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%s: got %d %q", name, resp.Status, resp.ErrorCode())
+		}
+	}
+
+	resp = h.Request("GET", "/api/v1/units/check?unit=KARTON", nil, admin)
+	if resp.Data()["known"] != true || resp.Data()["code"] != "dus" || resp.Data()["factor"] != float64(24) {
+		t.Fatalf("check alias = %s", resp.Raw)
+	}
+	resp = h.Request("GET", "/api/v1/units/check?unit=duss", nil, admin)
+	suggestions, _ := resp.Data()["suggestions"].([]interface{})
+	if resp.Data()["known"] != false || len(suggestions) == 0 || suggestions[0] != "dus" {
+		t.Fatalf("check typo = %s, want dus suggested", resp.Raw)
+	}
+
+	resp = h.Request("PUT", "/api/v1/units/"+id, map[string]interface{}{"code": "box", "dimension": models.UnitDimensionCount, "factor": 20}, admin)
+	if resp.Status != 200 || resp.Data()["code"] != "dus" || resp.Data()["factor"] != float64(20) {
+		t.Fatalf("update: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("DELETE", "/api/v1/units/"+id, nil, admin); resp.Status != 200 {
+		t.Fatalf("delete: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("DELETE", "/api/v1/units/"+id, nil, admin); resp.ErrorCode() != response.CodeUnitNotFound {
+		t.Fatalf("delete again: got %q", resp.ErrorCode())
+	}
+	if resp := h.Request("GET", "/api/v1/units/check?unit=karton", nil, admin); resp.Data()["known"] != false {
+		t.Fatalf("deleted unit still known: %s", resp.Raw)
+	}
+
+	// A failed read is an error, not the default registry
+	h.Mongo.Fail("units")
+	if resp := h.Request("GET", "/api/v1/units", nil, admin); resp.Status != 500 {
+		t.Fatalf("units unavailable: status = %d", resp.Status)
+	}
+}
+
+func TestShippedQuantities(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	note := func(createdAt time.Time, edit func(*models.DeliveryNote)) {
+		n := models.NewDeliveryNote()
+		n.CreatedAt = createdAt
+		edit(n)
+		h.Insert("delivery_notes", n)
+	}
+	// 01:00 WIB on 1 February, still January in UTC
+	note(time.Date(2026, 1, 31, 18, 0, 0, 0, time.UTC), func(n *models.DeliveryNote) {
+		n.Items = []models.DeliveryNoteItem{{ProductName: "Semen", Quantity: 10, Unit: "sak", BaseQuantity: 500, BaseUnit: "kg"}}
+	})
+	note(time.Date(2026, 2, 10, 3, 0, 0, 0, time.UTC), func(n *models.DeliveryNote) {
+		n.Items = []models.DeliveryNoteItem{
+			{ProductName: "Pasir", Quantity: 2, Unit: "m3"},
+			{ProductName: "Besi", Quantity: 5, Unit: "batang"},
+		}
+	})
+	// From before multi-item orders
+	note(time.Date(2026, 2, 11, 3, 0, 0, 0, time.UTC), func(n *models.DeliveryNote) {
+		n.ProductQty, n.ProductUnit = 1, "ton"
+	})
+	note(time.Date(2026, 2, 12, 3, 0, 0, 0, time.UTC), func(n *models.DeliveryNote) {
+		n.Superseded = true
+		n.Items = []models.DeliveryNoteItem{{ProductName: "Semen", Quantity: 99, Unit: "sak", BaseQuantity: 4950, BaseUnit: "kg"}}
+	})
+
+	resp := h.Request("GET", "/api/v1/reports/shipped-quantities?from=2026-01-01&to=2026-02-28&unit=ton", nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("report: status = %d: %s", resp.Status, resp.Raw)
+	}
+	months, _ := resp.Data()["months"].([]interface{})
+	if len(months) != 2 {
+		t.Fatalf("months = %v, want mass and volume in February", months)
+	}
+	mass, volume := months[0].(map[string]interface{}), months[1].(map[string]interface{})
+	if mass["month"] != "2026-02" || mass["quantity"] != float64(1500) || mass["quantity_in_unit"] != 1.5 || mass["delivery_notes"] != float64(2) {
+		t.Fatalf("mass row = %v, want 1500 kg (1.5 ton) over 2 notes in February", mass)
+	}
+	if volume["month"] != "2026-02" || volume["quantity"] != float64(2) || volume["unit"] != nil {
+		t.Fatalf("volume row = %v, want 2 m3 in February", volume)
+	}
+	if unconverted, _ := resp.Data()["unconverted"].(map[string]interface{}); unconverted["batang"] != float64(5) {
+		t.Fatalf("unconverted = %v, want 5 batang", unconverted)
+	}
+
+	if resp := h.Request("GET", "/api/v1/reports/shipped-quantities?unit=crate", nil, admin); resp.ErrorCode() != response.CodeUnitUnknown {
+		t.Fatalf("unknown display unit: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	h.Mongo.Fail("delivery_notes")
+	if resp := h.Request("GET", "/api/v1/reports/shipped-quantities", nil, admin); resp.Status != 500 {
+		t.Fatalf("notes unavailable: status = %d", resp.Status)
+	}
+}
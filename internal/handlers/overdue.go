@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// parseDueDate parses an entered due date (YYYY-MM-DD); the order is due until the end
+// of that day
+func parseDueDate(v string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid due_date, use YYYY-MM-DD")
+	}
+	return t.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// dueDate returns the due date of a new order: the entered one, or the sales' payment
+// term days after the order was created. Orders of sales without payment terms have no
+// due date and never go overdue.
+func dueDate(entered string, sales *models.Sales, createdAt time.Time) (*time.Time, error) {
+	if entered != "" {
+		due, err := parseDueDate(entered)
+		if err != nil {
+			return nil, err
+		}
+		return &due, nil
+	}
+	if sales.PaymentTermDays <= 0 {
+		return nil, nil
+	}
+	due := createdAt.AddDate(0, 0, sales.PaymentTermDays)
+	return &due, nil
+}
+
+// GetOverduePolicy returns the overdue reminder policy and the template placeholders
+func (h *SettingsHandler) GetOverduePolicy(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	return response.Success(c, 200, fiber.Map{
+		"policy":       notification.LoadOverduePolicy(ctx),
+		"placeholders": notification.OverduePlaceholders,
+	})
+}
+
+// UpdateOverduePolicy saves the overdue reminder policy
+func (h *SettingsHandler) UpdateOverduePolicy(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		Enabled            bool   `json:"enabled"`
+		EveryDays          int    `json:"every_days"`
+		MaxReminders       int    `json:"max_reminders"`
+		Template           string `json:"template"`
+		EscalateAfterDays  int    `json:"escalate_after_days"`
+		ManagerName        string `json:"manager_name"`
+		ManagerPhone       string `json:"manager_phone"`
+		EscalationTemplate string `json:"escalation_template"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.EveryDays < 0 || req.EscalateAfterDays < 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "every_days and escalate_after_days cannot be negative")
+	}
+	if req.MaxReminders < 1 || req.MaxReminders > maxReminders {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "max_reminders must be between 1 and 10")
+	}
+	if req.Template == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "A reminder template is required")
+	}
+	if req.EscalateAfterDays > 0 && (req.ManagerPhone == "" || req.EscalationTemplate == "") {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Escalation needs manager_phone and escalation_template")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"enabled":             req.Enabled,
+			"every_days":          req.EveryDays,
+			"max_reminders":       req.MaxReminders,
+			"template":            req.Template,
+			"escalate_after_days": req.EscalateAfterDays,
+			"manager_name":        req.ManagerName,
+			"manager_phone":       req.ManagerPhone,
+			"escalation_template": req.EscalationTemplate,
+			"updated_by":          middleware.GetUserID(c),
+			"updated_at":          now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection("overdue_policy")
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save overdue policy")
+	}
+
+	audit.Log(c, audit.ActionOverduePolicy, "overdue_policy", "", map[string]interface{}{
+		"enabled":             req.Enabled,
+		"every_days":          req.EveryDays,
+		"max_reminders":       req.MaxReminders,
+		"escalate_after_days": req.EscalateAfterDays,
+	})
+
+	return response.Success(c, 200, notification.LoadOverduePolicy(ctx))
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Maximum page sizes per list, so a single request cannot read a whole collection
+const (
+	maxPageLimit      = 100
+	maxQueuePageLimit = 200
+	maxLogPageLimit   = 200
+)
+
+// pageQuery holds the pagination parameters of a list request
+type pageQuery struct {
+	Page      int
+	Limit     int
+	WithTotal bool
+}
+
+// parsePage reads page, limit and with_total. Page is at least 1 and limit is kept
+// between 1 and maxLimit. with_total=false skips the count of matching documents.
+func parsePage(c *fiber.Ctx, defaultLimit, maxLimit int) pageQuery {
+	p := pageQuery{
+		Page:      c.QueryInt("page", 1),
+		Limit:     c.QueryInt("limit", defaultLimit),
+		WithTotal: c.QueryBool("with_total", true),
+	}
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Limit < 1 {
+		p.Limit = defaultLimit
+	}
+	if p.Limit > maxLimit {
+		p.Limit = maxLimit
+	}
+	return p
+}
+
+// findOptions returns find options selecting the page. Without a total one extra
+// document is fetched to tell whether a next page exists.
+func (p pageQuery) findOptions() *options.FindOptions {
+	limit := p.Limit
+	if !p.WithTotal {
+		limit++
+	}
+	return options.Find().
+		SetSkip(int64((p.Page - 1) * p.Limit)).
+		SetLimit(int64(limit))
+}
+
+// count counts the documents matching filter, or returns 0 when the total was opted out of
+func (p pageQuery) count(ctx context.Context, collection *mongo.Collection, filter interface{}) int64 {
+	if !p.WithTotal {
+		return 0
+	}
+	total, _ := collection.CountDocuments(ctx, filter)
+	return total
+}
+
+// trimPage drops the extra document fetched without a total and reports whether there was one
+func trimPage[T any](p pageQuery, items []T) ([]T, bool) {
+	if !p.WithTotal && len(items) > p.Limit {
+		return items[:p.Limit], true
+	}
+	return items, false
+}
+
+// pagination builds the pagination metadata of the page
+func (p pageQuery) pagination(total int64, more bool) *response.Pagination {
+	if !p.WithTotal {
+		return response.CalculatePaginationWithoutTotal(int64(p.Page), int64(p.Limit), more)
+	}
+	return response.CalculatePagination(int64(p.Page), int64(p.Limit), total)
+}
@@ -1,19 +1,21 @@
 package handlers
 
 import (
-	"context"
 	"time"
 
 	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
 	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/notification"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/screening"
 	"bg-go/internal/middleware"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // PaymentHandler handles payment routes
@@ -26,26 +28,29 @@ func NewPaymentHandler() *PaymentHandler {
 
 // ListPending returns all orders with pending payments
 func (h *PaymentHandler) ListPending(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
+	if code, err := applyView(c, models.ViewResourcePayments); err != nil {
+		return viewFailed(c, code, err)
+	}
 
-	skip := (page - 1) * limit
+	pq := parsePage(c, 10, maxPageLimit)
 
 	filter := bson.M{
 		"payment_status": models.PaymentStatusPending,
 		"payment_proof":  bson.M{"$ne": nil},
 	}
+	applyOrderFilters(c, filter)
+	applyScreeningFilter(c, filter)
+	applyModerationFilter(c, filter)
+	applyMatchFilter(c, filter)
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
-		SetSort(bson.D{{Key: "payment_uploaded_at", Value: -1}})
+	findOptions := pq.findOptions().
+		SetSort(listSort(c, models.ViewResourcePayments, bson.D{{Key: "payment_uploaded_at", Value: -1}}))
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
@@ -55,12 +60,14 @@ func (h *PaymentHandler) ListPending(c *fiber.Ctx) error {
 
 	var orders []models.Order
 	cursor.All(ctx, &orders)
+	orders, more := trimPage(pq, orders)
 
 	// Populate sales and product data
 	salesCollection := database.GetMongoCollection("sales")
 	productCollection := database.GetMongoCollection("products")
 
 	for i := range orders {
+		media.SignOrder(&orders[i])
 		if orders[i].SalesID != "" {
 			salesObjID, _ := primitive.ObjectIDFromHex(orders[i].SalesID)
 			sales := &models.Sales{}
@@ -75,7 +82,29 @@ func (h *PaymentHandler) ListPending(c *fiber.Ctx) error {
 		}
 	}
 
-	return response.SuccessWithPagination(c, 200, orders, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, orders, pq.pagination(total, more))
+}
+
+// applyScreeningFilter filters a payment list by the fraud-screening verdict of the proof:
+// screening=clean, suspicious, error or pending, or none for proofs never screened
+func applyScreeningFilter(c *fiber.Ctx, filter bson.M) {
+	switch status := c.Query("screening"); status {
+	case "":
+	case "none":
+		filter["payment_screening"] = bson.M{"$exists": false}
+	default:
+		filter["payment_screening.status"] = status
+	}
+}
+
+// applyModerationFilter hides payment proofs rejected by image moderation, unless the
+// moderation query asks for a moderation status
+func applyModerationFilter(c *fiber.Ctx, filter bson.M) {
+	if status := c.Query("moderation"); status != "" {
+		filter["payment_proof.moderation"] = status
+		return
+	}
+	filter["payment_proof.moderation"] = bson.M{"$ne": models.ModerationRejected}
 }
 
 // Verify verifies a payment
@@ -84,48 +113,38 @@ func (h *PaymentHandler) Verify(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
-	userID := middleware.GetUserID(c)
-
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Check if order exists and has payment proof
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	if order.PaymentProof == nil {
-		return response.BadRequest(c, "No payment proof uploaded")
+		return response.ErrorCode(c, 400, response.CodePaymentProofMissing, "No payment proof uploaded")
 	}
 
 	if order.PaymentStatus != models.PaymentStatusPending {
-		return response.BadRequest(c, "Payment is not in pending status")
+		return response.ErrorCode(c, 400, response.CodePaymentNotPending, "Payment is not in pending status")
 	}
 
-	now := time.Now()
-	update := bson.M{
-		"payment_status":      models.PaymentStatusVerified,
-		"payment_verified_at": now,
-		"payment_verified_by": userID,
-		"status":              models.OrderStatusConfirmed,
-		"updated_at":          now,
-	}
-
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	// Payments above the approval threshold need a second approver
+	second, err := needsSecondApproval(ctx, c, order)
 	if err != nil {
-		return response.Error(c, 500, "Failed to verify payment")
+		return approvalPolicyUnavailable(c)
 	}
-	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Order not found")
+	if second {
+		return requestSecondApproval(ctx, c, order)
 	}
 
-	return response.SuccessWithMessage(c, 200, "Payment verified successfully")
+	return confirmPayment(ctx, c, order)
 }
 
 // Reject rejects a payment
@@ -134,7 +153,7 @@ func (h *PaymentHandler) Reject(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	userID := middleware.GetUserID(c)
@@ -145,22 +164,23 @@ func (h *PaymentHandler) Reject(c *fiber.Ctx) error {
 
 	var req RejectRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Check if order exists
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
-	if order.PaymentStatus != models.PaymentStatusPending {
-		return response.BadRequest(c, "Payment is not in pending status")
+	// A payment waiting for its second approval can be rejected by the second approver
+	if order.PaymentStatus != models.PaymentStatusPending && order.PaymentStatus != models.PaymentStatusApproval {
+		return response.ErrorCode(c, 400, response.CodePaymentNotPending, "Payment is not in pending status")
 	}
 
 	now := time.Now()
@@ -172,13 +192,28 @@ func (h *PaymentHandler) Reject(c *fiber.Ctx) error {
 		"updated_at":           now,
 	}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	// The matched bank mutation did not pay this order, so both are unmatched again
+	unset := bson.M{"payment_match": ""}
+	for field := range releaseClaim {
+		unset[field] = ""
+	}
+
+	// Only a payment still under review is rejected, so a racing verification is kept
+	reviewable := bson.M{"$in": []string{models.PaymentStatusPending, models.PaymentStatusApproval}}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID, "payment_status": reviewable}, bson.M{"$set": update, "$unset": unset})
 	if err != nil {
 		return response.Error(c, 500, "Failed to reject payment")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Order not found")
+		return response.ErrorCode(c, 409, response.CodePaymentNotPending, "Payment was reviewed by someone else")
 	}
+	releaseMutationMatch(ctx, order)
+
+	audit.Log(c, audit.ActionPaymentReject, "order", id, map[string]interface{}{
+		"order_number":       order.OrderNumber,
+		"reason":             req.Reason,
+		"turnaround_seconds": reviewTurnaround(order, now),
+	})
 
 	return response.SuccessWithMessage(c, 200, "Payment rejected")
 }
@@ -188,39 +223,39 @@ func (h *PaymentHandler) UploadProof(c *fiber.Ctx) error {
 	token := c.Params("token")
 
 	if token == "" {
-		return response.BadRequest(c, "Token is required")
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
 	}
 
 	formFile, err := c.FormFile("proof")
 	if err != nil {
-		return response.BadRequest(c, "No file provided")
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No file provided")
 	}
 
-	uploadResult, err := file.UploadFile(formFile)
+	uploadResult, err := file.UploadFile(formFile, file.CategoryPaymentProof)
 	if err != nil {
-		return response.Error(c, 500, "Failed to upload file")
+		return uploadFailed(c, err, "Failed to upload file")
 	}
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Find order by invoice token
 	order := &models.Order{}
 	err = collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order)
 	if err != nil {
-		return response.NotFound(c, "Order not found")
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
 	}
 
 	if order.Status != models.OrderStatusPending {
-		return response.BadRequest(c, "Order is not in pending status")
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order is not in pending status")
+	}
+	if order.PendingChange != nil {
+		return response.ErrorCode(c, 400, response.CodeOrderChangePending, "Confirm the change to the order before uploading payment")
 	}
 
 	now := time.Now()
-	paymentProof := &models.Image{
-		PublicID: uploadResult.PublicID,
-		URL:      uploadResult.URL,
-	}
+	paymentProof := uploadResult.Image()
 
 	update := bson.M{
 		"payment_proof":       paymentProof,
@@ -228,42 +263,58 @@ func (h *PaymentHandler) UploadProof(c *fiber.Ctx) error {
 		"status":              models.OrderStatusPaid,
 		"updated_at":          now,
 	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusPaid, "")}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"invoice_token": token}, bson.M{"$set": update})
+	result, err := collection.UpdateOne(ctx, bson.M{
+		"invoice_token":  token,
+		"status":         models.OrderStatusPending,
+		"pending_change": bson.M{"$exists": false},
+	}, bson.M{"$set": update, "$push": statusChange})
 	if err != nil {
 		return response.Error(c, 500, "Failed to update order")
 	}
+	if result.MatchedCount == 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "The order changed while uploading, reload it and upload again")
+	}
+
+	matchUploadedProof(ctx, order)
+
+	go notification.NotifyStatusChange(order.ID, models.OrderStatusPaid)
+	go screening.Screen(order.ID)
 
 	return response.Success(c, 200, fiber.Map{
 		"message":       "Payment proof uploaded successfully",
-		"payment_proof": paymentProof,
+		"payment_proof": media.Sign(paymentProof, order.ID.Hex(), media.KindPaymentProof),
 	})
 }
 
 // ListByStatus returns payments by status
 func (h *PaymentHandler) ListByStatus(c *fiber.Ctx) error {
+	if code, err := applyView(c, models.ViewResourcePayments); err != nil {
+		return viewFailed(c, code, err)
+	}
+
 	status := c.Params("status")
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
+	pq := parsePage(c, 10, maxPageLimit)
 
-	if status != models.PaymentStatusPending && status != models.PaymentStatusVerified && status != models.PaymentStatusRejected {
-		return response.BadRequest(c, "Invalid status")
+	if status != models.PaymentStatusPending && status != models.PaymentStatusApproval && status != models.PaymentStatusVerified && status != models.PaymentStatusRejected {
+		return response.ErrorCode(c, 400, response.CodePaymentInvalidStatus, "Invalid status")
 	}
 
-	skip := (page - 1) * limit
-
 	filter := bson.M{"payment_status": status}
+	applyOrderFilters(c, filter)
+	applyScreeningFilter(c, filter)
+	applyModerationFilter(c, filter)
+	applyMatchFilter(c, filter)
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
-		SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	findOptions := pq.findOptions().
+		SetSort(listSort(c, models.ViewResourcePayments, bson.D{{Key: "updated_at", Value: -1}}))
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
@@ -273,6 +324,18 @@ func (h *PaymentHandler) ListByStatus(c *fiber.Ctx) error {
 
 	var orders []models.Order
 	cursor.All(ctx, &orders)
+	orders, more := trimPage(pq, orders)
+	for i := range orders {
+		media.SignOrder(&orders[i])
+	}
+
+	return response.SuccessWithPagination(c, 200, orders, pq.pagination(total, more))
+}
 
-	return response.SuccessWithPagination(c, 200, orders, response.CalculatePagination(int64(page), int64(limit), total))
+// reviewTurnaround returns the seconds between the proof upload and its review, or nil when unknown
+func reviewTurnaround(order *models.Order, reviewedAt time.Time) interface{} {
+	if order.PaymentUploadedAt == nil {
+		return nil
+	}
+	return int64(reviewedAt.Sub(*order.PaymentUploadedAt).Seconds())
 }
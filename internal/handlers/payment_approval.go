@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// approvalPolicyCollection stores the payment approval policy
+const approvalPolicyCollection = "payment_approval_policy"
+
+// loadApprovalPolicy returns the saved payment approval policy, or a disabled one when
+// none is saved. Other errors are returned, so a failed read never disables the policy.
+func loadApprovalPolicy(ctx context.Context) (*models.PaymentApprovalPolicy, error) {
+	policy := &models.PaymentApprovalPolicy{}
+	err := database.GetMongoCollection(approvalPolicyCollection).FindOne(ctx, bson.M{}).Decode(policy)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.PaymentApprovalPolicy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// approvalPolicyUnavailable answers a request whose approval policy could not be read
+func approvalPolicyUnavailable(c *fiber.Ctx) error {
+	return response.Error(c, fiber.StatusServiceUnavailable, "Payment approval policy is unavailable, please retry shortly")
+}
+
+// needsSecondApproval reports whether verifying the order's payment waits for a second
+// approver: the total is above the threshold and the verifier is not a SUPERADMIN
+func needsSecondApproval(ctx context.Context, c *fiber.Ctx, order *models.Order) (bool, error) {
+	policy, err := loadApprovalPolicy(ctx)
+	if err != nil {
+		return false, err
+	}
+	return policy.Threshold > 0 && order.TotalPrice > policy.Threshold &&
+		middleware.GetUserRole(c) != models.RoleSuperAdmin, nil
+}
+
+// requestSecondApproval records the first approval of a payment above the threshold
+func requestSecondApproval(ctx context.Context, c *fiber.Ctx, order *models.Order) error {
+	if approved, err := approveFirst(ctx, c, order); !approved {
+		return err
+	}
+
+	return response.Success(c, 202, fiber.Map{
+		"message":        "Payment approved, waiting for a second approval",
+		"payment_status": models.PaymentStatusApproval,
+	})
+}
+
+// approveFirst records the first approval of the order's payment. On failure the request
+// is answered and false returned.
+func approveFirst(ctx context.Context, c *fiber.Ctx, order *models.Order) (bool, error) {
+	now := time.Now()
+	approverID := middleware.GetActorID(c)
+
+	result, err := database.GetMongoCollection("orders").UpdateOne(ctx,
+		bson.M{"_id": order.ID, "payment_status": models.PaymentStatusPending},
+		bson.M{"$set": bson.M{
+			"payment_status":      models.PaymentStatusApproval,
+			"payment_approved_at": now,
+			"payment_approved_by": approverID,
+			"updated_at":          now,
+		}},
+	)
+	if err != nil {
+		return false, response.Error(c, 500, "Failed to approve payment")
+	}
+	if result.ModifiedCount == 0 {
+		return false, response.ErrorCode(c, 409, response.CodePaymentNotPending, "Payment was reviewed by someone else")
+	}
+
+	audit.Log(c, audit.ActionPaymentApprove, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"total_price":  order.TotalPrice,
+		"step":         1,
+	})
+	return true, nil
+}
+
+// confirmPayment marks the order's payment verified and confirms the order
+func confirmPayment(ctx context.Context, c *fiber.Ctx, order *models.Order) error {
+	if verified, err := verifyPayment(ctx, c, order); !verified {
+		return err
+	}
+
+	return response.SuccessWithMessage(c, 200, "Payment verified successfully")
+}
+
+// verifyPayment marks the order's payment verified and confirms the order. The update
+// only applies while the payment still has the status it was loaded with, so two
+// reviewers cannot both confirm it. On failure the request is answered and false returned.
+func verifyPayment(ctx context.Context, c *fiber.Ctx, order *models.Order) (bool, error) {
+	userID := middleware.GetActorID(c)
+
+	now := time.Now()
+	update := bson.M{
+		"payment_status":      models.PaymentStatusVerified,
+		"payment_verified_at": now,
+		"payment_verified_by": userID,
+		"status":              models.OrderStatusConfirmed,
+		"updated_at":          now,
+	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusConfirmed, userID)}
+
+	result, err := database.GetMongoCollection("orders").UpdateOne(ctx,
+		bson.M{"_id": order.ID, "payment_status": order.PaymentStatus},
+		bson.M{"$set": update, "$push": statusChange, "$unset": releaseClaim},
+	)
+	if err != nil {
+		return false, response.Error(c, 500, "Failed to verify payment")
+	}
+	if result.MatchedCount == 0 {
+		return false, response.ErrorCode(c, 409, response.CodePaymentNotPending, "Payment was reviewed by someone else")
+	}
+
+	go notification.NotifyStatusChange(order.ID, models.OrderStatusConfirmed)
+
+	details := map[string]interface{}{
+		"order_number":       order.OrderNumber,
+		"total_price":        order.TotalPrice,
+		"turnaround_seconds": reviewTurnaround(order, now),
+	}
+	if order.PaymentApprovedBy != "" {
+		details["first_approved_by"] = order.PaymentApprovedBy
+	}
+	if order.PaymentMatch != nil {
+		details["bank_mutation"] = order.PaymentMatch.MutationID
+		markMutationVerified(ctx, order)
+	}
+	audit.Log(c, audit.ActionPaymentVerify, "order", order.ID.Hex(), details)
+	return true, nil
+}
+
+// Approve gives the second approval of a payment above the approval threshold. The first
+// approver cannot give it.
+func (h *PaymentHandler) Approve(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	err = database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": objID}).Decode(order)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	if order.PaymentStatus != models.PaymentStatusApproval {
+		return response.ErrorCode(c, 400, response.CodePaymentNotAwaiting, "Payment is not waiting for a second approval")
+	}
+	if order.PaymentApprovedBy == middleware.GetActorID(c) {
+		return response.ErrorCode(c, 400, response.CodePaymentSameApprover, "The second approval must come from a different admin")
+	}
+
+	return confirmPayment(ctx, c, order)
+}
+
+// GetApprovalPolicy returns the payment approval policy
+func (h *SettingsHandler) GetApprovalPolicy(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	policy, err := loadApprovalPolicy(ctx)
+	if err != nil {
+		return approvalPolicyUnavailable(c)
+	}
+	return response.Success(c, 200, policy)
+}
+
+// UpdateApprovalPolicy saves the payment approval policy. Only a SUPERADMIN may change
+// it, since it limits what admins can verify alone.
+func (h *SettingsHandler) UpdateApprovalPolicy(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		Threshold float64 `json:"threshold"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Threshold < 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "threshold cannot be negative")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"threshold":  req.Threshold,
+			"updated_by": middleware.GetUserID(c),
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection(approvalPolicyCollection)
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save approval policy")
+	}
+
+	audit.Log(c, audit.ActionApprovalPolicy, approvalPolicyCollection, "", map[string]interface{}{
+		"threshold": req.Threshold,
+	})
+
+	policy, err := loadApprovalPolicy(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load approval policy")
+	}
+	return response.Success(c, 200, policy)
+}
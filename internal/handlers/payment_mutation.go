@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/bankstatement"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bankMutationsCollection keeps the imported bank statement lines
+const bankMutationsCollection = "bank_mutations"
+
+// Match scoring: a mutation only matches an order with the same amount; the order number
+// in the transfer description, a transfer date close to the proof upload and the sales
+// rep's name as sender raise the confidence
+const (
+	matchAmountScore    = 60
+	matchReferenceScore = 30
+	matchDateScore      = 10 // Within a day of the proof upload, half within matchDateDays
+	matchSenderScore    = 10
+	matchDateDays       = 3
+	matchVerifiable     = 90 // Confidence from which the payment can be verified in one click
+)
+
+// MutationImportRow is the outcome of one statement line
+type MutationImportRow struct {
+	Row         int       `json:"row"`
+	Date        time.Time `json:"date"`
+	Amount      float64   `json:"amount"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"` // matched, unmatched, ignored or duplicate
+	OrderID     string    `json:"order_id,omitempty"`
+	OrderNumber string    `json:"order_number,omitempty"`
+	Confidence  int       `json:"confidence,omitempty"`
+	Reasons     []string  `json:"reasons,omitempty"`
+}
+
+// matchCandidate is an order waiting for payment verification, as a mutation may match it
+type matchCandidate struct {
+	order     models.Order
+	reference string // Order number, letters and digits only
+	sender    string // First name of the sales rep, upper case
+}
+
+// compact keeps the letters and digits of s, upper case, so an order number still matches
+// when a bank drops its dashes from the transfer description
+func compact(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+// scoreMatch rates how likely a credit mutation pays an order; 0 when the amounts differ
+func scoreMatch(m bankstatement.Mutation, candidate *matchCandidate) (int, []string) {
+	if math.Abs(m.Amount-candidate.order.TotalPrice) >= 0.5 {
+		return 0, nil
+	}
+	score, reasons := matchAmountScore, []string{"amount"}
+
+	if text := compact(m.Description + m.Reference); candidate.reference != "" && strings.Contains(text, candidate.reference) {
+		score += matchReferenceScore
+		reasons = append(reasons, "reference")
+	}
+	if uploaded := candidate.order.PaymentUploadedAt; uploaded != nil {
+		day := time.Date(uploaded.Year(), uploaded.Month(), uploaded.Day(), 0, 0, 0, 0, m.Date.Location())
+		switch days := math.Abs(m.Date.Sub(day).Hours() / 24); {
+		case days <= 1:
+			score += matchDateScore
+			reasons = append(reasons, "date")
+		case days <= matchDateDays:
+			score += matchDateScore / 2
+			reasons = append(reasons, "date")
+		}
+	}
+	if candidate.sender != "" && strings.Contains(strings.ToUpper(m.Description), candidate.sender) {
+		score += matchSenderScore
+		reasons = append(reasons, "sender")
+	}
+	if score > 100 {
+		score = 100
+	}
+	return score, reasons
+}
+
+// matchCandidates loads the orders whose proof waits for verification and has no
+// matched mutation yet
+func matchCandidates(ctx context.Context) ([]*matchCandidate, error) {
+	cursor, err := database.GetMongoCollection("orders").Find(ctx, bson.M{
+		"payment_status": models.PaymentStatusPending,
+		"payment_proof":  bson.M{"$ne": nil},
+		"payment_match":  bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	salesIDs := []primitive.ObjectID{}
+	for _, order := range orders {
+		if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+			salesIDs = append(salesIDs, salesObjID)
+		}
+	}
+	names := map[string]string{}
+	if len(salesIDs) > 0 {
+		salesCursor, err := database.GetMongoCollection("sales").Find(ctx, bson.M{"_id": bson.M{"$in": salesIDs}})
+		if err != nil {
+			return nil, err
+		}
+		var sales []models.Sales
+		if err := salesCursor.All(ctx, &sales); err != nil {
+			return nil, err
+		}
+		for _, s := range sales {
+			if fields := strings.Fields(s.Name); len(fields) > 0 && len(fields[0]) >= 3 {
+				names[s.ID.Hex()] = strings.ToUpper(fields[0])
+			}
+		}
+	}
+
+	candidates := []*matchCandidate{}
+	for _, order := range orders {
+		candidates = append(candidates, &matchCandidate{
+			order:     order,
+			reference: compact(order.OrderNumber),
+			sender:    names[order.SalesID],
+		})
+	}
+	return candidates, nil
+}
+
+// bestCandidate returns the index, score and reasons of the only candidate scoring best
+// for a mutation; the index is -1 when no candidate matches or several tie
+func bestCandidate(m bankstatement.Mutation, candidates []*matchCandidate) (int, int, []string) {
+	best, bestScore, tied := -1, 0, false
+	var bestReasons []string
+	for j, candidate := range candidates {
+		if candidate == nil {
+			continue
+		}
+		score, reasons := scoreMatch(m, candidate)
+		switch {
+		case score > bestScore:
+			best, bestScore, bestReasons, tied = j, score, reasons, false
+		case score > 0 && score == bestScore:
+			tied = true
+		}
+	}
+	if tied {
+		return -1, 0, nil
+	}
+	return best, bestScore, bestReasons
+}
+
+// newPaymentMatch describes a mutation matched to an order's payment
+func newPaymentMatch(mutation *models.BankMutation, confidence int, reasons []string, now time.Time) *models.PaymentMatch {
+	return &models.PaymentMatch{
+		MutationID:  mutation.ID.Hex(),
+		Bank:        mutation.Bank,
+		Date:        mutation.Date,
+		Amount:      mutation.Amount,
+		Description: mutation.Description,
+		Confidence:  confidence,
+		Reasons:     reasons,
+		Verifiable:  confidence >= matchVerifiable,
+		MatchedAt:   now,
+	}
+}
+
+// applyPaymentMatch sets a match on an order still waiting for verification without
+// one; false when the payment was reviewed or matched meanwhile
+func applyPaymentMatch(ctx context.Context, orderID primitive.ObjectID, match *models.PaymentMatch) bool {
+	result, err := database.GetMongoCollection("orders").UpdateOne(ctx, bson.M{
+		"_id":            orderID,
+		"payment_status": models.PaymentStatusPending,
+		"payment_match":  bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"payment_match": match}})
+	return err == nil && result.ModifiedCount > 0
+}
+
+// rematchMutations scores the saved unmatched mutations of filter, oldest first, against
+// the orders waiting for verification, and records the matches. It returns how many
+// mutations were matched.
+func rematchMutations(ctx context.Context, filter bson.M) (int, error) {
+	filter["status"] = models.MutationUnmatched
+	collection := database.GetMongoCollection(bankMutationsCollection)
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	var mutations []models.BankMutation
+	if err := cursor.All(ctx, &mutations); err != nil {
+		return 0, err
+	}
+	if len(mutations) == 0 {
+		return 0, nil
+	}
+
+	candidates, err := matchCandidates(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	matched := 0
+	for i := range mutations {
+		mutation := &mutations[i]
+		m := bankstatement.Mutation{
+			Date:        mutation.Date,
+			Description: mutation.Description,
+			Reference:   mutation.Reference,
+			Amount:      mutation.Amount,
+			Credit:      mutation.Credit,
+		}
+		best, score, reasons := bestCandidate(m, candidates)
+		if best < 0 {
+			continue
+		}
+		order := candidates[best].order
+		candidates[best] = nil
+		if !applyPaymentMatch(ctx, order.ID, newPaymentMatch(mutation, score, reasons, now)) {
+			continue
+		}
+		collection.UpdateOne(ctx, bson.M{"_id": mutation.ID, "status": models.MutationUnmatched}, bson.M{"$set": bson.M{
+			"status":       models.MutationMatched,
+			"order_id":     order.ID.Hex(),
+			"order_number": order.OrderNumber,
+			"confidence":   score,
+		}})
+		matched++
+	}
+	return matched, nil
+}
+
+// matchUploadedProof scores the unmatched mutations of an order's amount once its proof
+// is uploaded, for transfers imported before the proof. A failure only leaves the
+// payment to be matched by the next import.
+func matchUploadedProof(ctx context.Context, order *models.Order) {
+	if _, err := rematchMutations(ctx, bson.M{
+		"credit": true,
+		"amount": bson.M{"$gt": order.TotalPrice - 0.5, "$lt": order.TotalPrice + 0.5},
+	}); err != nil {
+		log.Printf("[Payment] Failed to match mutations for order %s: %v", order.OrderNumber, err)
+	}
+}
+
+// ImportMutations imports a bank statement CSV (form field "file") of BCA or Mandiri and
+// matches its credits to the orders waiting for payment verification. bank picks the
+// format, detected from the header when empty. Lines of earlier imports are skipped, so
+// overlapping statements can be imported. A match is only made when exactly one order
+// scores best; matches of matchVerifiable confidence or more are marked verifiable in
+// the verification list. Unmatched lines of earlier imports found in the file are scored
+// again. Pass dry_run=true to see the matches of the new lines without saving them.
+func (h *PaymentHandler) ImportMutations(c *fiber.Ctx) error {
+	formFile, err := c.FormFile("file")
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No file provided")
+	}
+	bank := strings.ToLower(c.FormValue("bank", c.Query("bank")))
+	if bank != "" && bank != bankstatement.BankBCA && bank != bankstatement.BankMandiri {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Bank must be bca or mandiri")
+	}
+	dryRun := c.FormValue("dry_run", c.Query("dry_run")) == "true"
+
+	src, err := formFile.Open()
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Failed to read file")
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Failed to read file")
+	}
+
+	now := time.Now()
+	mutations, bank, err := bankstatement.Parse(data, bank, now)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	if len(mutations) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "The file has no mutations")
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	collection := database.GetMongoCollection(bankMutationsCollection)
+	keys := []string{}
+	for _, m := range mutations {
+		keys = append(keys, m.Key(bank))
+	}
+	seen := map[string]bool{}
+	cursor, err := collection.Find(ctx, bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return response.Error(c, 500, "Failed to load imported mutations")
+	}
+	var imported []models.BankMutation
+	if err := cursor.All(ctx, &imported); err != nil {
+		return response.Error(c, 500, "Failed to load imported mutations")
+	}
+	for _, m := range imported {
+		seen[m.Key] = true
+	}
+
+	candidates, err := matchCandidates(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load pending payments")
+	}
+
+	importID := primitive.NewObjectID().Hex()
+	userID := middleware.GetUserID(c)
+	rows := []MutationImportRow{}
+	records := []interface{}{}
+	matches := map[primitive.ObjectID]*models.PaymentMatch{}
+	counts := map[string]int{}
+
+	for i, m := range mutations {
+		row := MutationImportRow{Row: m.Row, Date: m.Date, Amount: m.Amount, Description: m.Description}
+		key := keys[i]
+		switch {
+		case seen[key]:
+			row.Status = "duplicate"
+		case !m.Credit:
+			row.Status = models.MutationIgnored
+		default:
+			row.Status = models.MutationUnmatched
+			if best, bestScore, bestReasons := bestCandidate(m, candidates); best >= 0 {
+				order := candidates[best].order
+				candidates[best] = nil // An order is paid by one mutation
+				row.Status = models.MutationMatched
+				row.OrderID, row.OrderNumber = order.ID.Hex(), order.OrderNumber
+				row.Confidence, row.Reasons = bestScore, bestReasons
+			}
+		}
+		seen[key] = true
+		counts[row.Status]++
+		rows = append(rows, row)
+		if row.Status == "duplicate" {
+			continue
+		}
+
+		mutation := models.BankMutation{
+			ID:          primitive.NewObjectID(),
+			Key:         key,
+			Bank:        bank,
+			ImportID:    importID,
+			Date:        m.Date,
+			Description: m.Description,
+			Reference:   m.Reference,
+			Amount:      m.Amount,
+			Credit:      m.Credit,
+			Balance:     m.Balance,
+			Status:      row.Status,
+			OrderID:     row.OrderID,
+			OrderNumber: row.OrderNumber,
+			Confidence:  row.Confidence,
+			ImportedBy:  userID,
+			CreatedAt:   now,
+		}
+		records = append(records, mutation)
+		if row.Status == models.MutationMatched {
+			orderID, _ := primitive.ObjectIDFromHex(row.OrderID)
+			matches[orderID] = newPaymentMatch(&mutation, row.Confidence, row.Reasons, now)
+			if row.Confidence >= matchVerifiable {
+				counts["verifiable"]++
+			}
+		}
+	}
+
+	summary := fiber.Map{
+		"dry_run":    dryRun,
+		"import_id":  importID,
+		"bank":       bank,
+		"mutations":  len(mutations),
+		"matched":    counts[models.MutationMatched],
+		"verifiable": counts["verifiable"],
+		"unmatched":  counts[models.MutationUnmatched],
+		"ignored":    counts[models.MutationIgnored],
+		"duplicates": counts["duplicate"],
+		"rows":       rows,
+	}
+	if dryRun {
+		return response.Success(c, 200, summary)
+	}
+
+	if len(records) > 0 {
+		if _, err := collection.InsertMany(ctx, records); err != nil {
+			return response.Error(c, 500, "Failed to save mutations")
+		}
+	}
+	for orderID, match := range matches {
+		applyPaymentMatch(ctx, orderID, match)
+	}
+
+	// Lines of earlier imports that found no order are scored again, as their order
+	// may have uploaded its proof or had a tie resolved since
+	rematched, err := rematchMutations(ctx, bson.M{
+		"key":       bson.M{"$in": keys},
+		"import_id": bson.M{"$ne": importID},
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to match earlier mutations")
+	}
+	summary["rematched"] = rematched
+
+	audit.Log(c, audit.ActionPaymentImport, "bank_mutation", importID, map[string]interface{}{
+		"bank":       bank,
+		"filename":   formFile.Filename,
+		"mutations":  len(mutations),
+		"matched":    counts[models.MutationMatched],
+		"duplicates": counts["duplicate"],
+		"rematched":  rematched,
+	})
+
+	return response.Success(c, 200, summary)
+}
+
+// ListMutations returns imported bank mutations, newest first; status, bank and
+// import_id filter them
+func (h *PaymentHandler) ListMutations(c *fiber.Ctx) error {
+	pq := parsePage(c, 20, maxPageLimit)
+
+	filter := bson.M{}
+	for _, field := range []string{"status", "bank", "import_id"} {
+		if value := c.Query(field); value != "" {
+			filter[field] = value
+		}
+	}
+
+	collection := database.GetMongoCollection(bankMutationsCollection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch mutations")
+	}
+	defer cursor.Close(ctx)
+
+	var mutations []models.BankMutation
+	if err := cursor.All(ctx, &mutations); err != nil {
+		return response.Error(c, 500, "Failed to fetch mutations")
+	}
+	mutations, more := trimPage(pq, mutations)
+
+	return response.SuccessWithPagination(c, 200, mutations, pq.pagination(total, more))
+}
+
+// applyMatchFilter filters a payment list by its bank mutation match: match=verifiable
+// for payments that can be verified in one click, matched for any match, or unmatched
+func applyMatchFilter(c *fiber.Ctx, filter bson.M) {
+	switch c.Query("match") {
+	case "verifiable":
+		filter["payment_match.verifiable"] = true
+	case "matched":
+		filter["payment_match"] = bson.M{"$exists": true}
+	case "unmatched":
+		filter["payment_match"] = bson.M{"$exists": false}
+	}
+}
+
+// releaseMutationMatch returns the mutation matched to an order whose payment was
+// rejected to the unmatched mutations, so it can match another order
+func releaseMutationMatch(ctx context.Context, order *models.Order) {
+	if order.PaymentMatch == nil {
+		return
+	}
+	mutationID, err := primitive.ObjectIDFromHex(order.PaymentMatch.MutationID)
+	if err != nil {
+		return
+	}
+	database.GetMongoCollection(bankMutationsCollection).UpdateOne(ctx,
+		bson.M{"_id": mutationID, "order_id": order.ID.Hex(), "status": models.MutationMatched},
+		bson.M{
+			"$set":   bson.M{"status": models.MutationUnmatched},
+			"$unset": bson.M{"order_id": "", "order_number": "", "confidence": ""},
+		},
+	)
+}
+
+// markMutationVerified records on the matched mutation that the order's payment was
+// verified
+func markMutationVerified(ctx context.Context, order *models.Order) {
+	if order.PaymentMatch == nil {
+		return
+	}
+	mutationID, err := primitive.ObjectIDFromHex(order.PaymentMatch.MutationID)
+	if err != nil {
+		return
+	}
+	database.GetMongoCollection(bankMutationsCollection).UpdateOne(ctx,
+		bson.M{"_id": mutationID},
+		bson.M{"$set": bson.M{"status": models.MutationVerified}},
+	)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxReminders limits how many reminders a policy may send per order
+const maxReminders = 10
+
+// GetReminderPolicy returns the payment reminder policy and the template placeholders
+func (h *SettingsHandler) GetReminderPolicy(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	return response.Success(c, 200, fiber.Map{
+		"policy":       notification.LoadReminderPolicy(ctx),
+		"placeholders": notification.ReminderPlaceholders,
+	})
+}
+
+// UpdateReminderPolicy saves the payment reminder policy
+func (h *SettingsHandler) UpdateReminderPolicy(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		Enabled      bool                  `json:"enabled"`
+		Steps        []models.ReminderStep `json:"steps"`
+		RepeatHours  int                   `json:"repeat_hours"`
+		MaxReminders int                   `json:"max_reminders"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.Enabled && len(req.Steps) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least one step is required when enabled")
+	}
+	previous := 0
+	for _, step := range req.Steps {
+		if step.AfterHours <= previous {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Step hours must be positive and increasing")
+		}
+		if step.Template == "" {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Every step needs a template")
+		}
+		previous = step.AfterHours
+	}
+	if req.RepeatHours < 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "repeat_hours cannot be negative")
+	}
+	if req.MaxReminders < 1 || req.MaxReminders > maxReminders {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "max_reminders must be between 1 and 10")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"enabled":       req.Enabled,
+			"steps":         req.Steps,
+			"repeat_hours":  req.RepeatHours,
+			"max_reminders": req.MaxReminders,
+			"updated_by":    middleware.GetUserID(c),
+			"updated_at":    now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection("reminder_policy")
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save reminder policy")
+	}
+
+	audit.Log(c, audit.ActionReminderUpdate, "reminder_policy", "", map[string]interface{}{
+		"enabled":       req.Enabled,
+		"steps":         len(req.Steps),
+		"max_reminders": req.MaxReminders,
+	})
+
+	return response.Success(c, 200, notification.LoadReminderPolicy(ctx))
+}
@@ -0,0 +1,840 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/breaker"
+	"bg-go/internal/lib/chatalert"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/presence"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/screening"
+	"bg-go/internal/models"
+	"bg-go/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// withProof gives an order an uploaded payment proof
+func withProof(order *models.Order) {
+	order.Status = models.OrderStatusPaid
+	order.PaymentProof = &models.Image{PublicID: "payment-proof/1", URL: "https://cdn.test/payment-proof/1"}
+}
+
+func TestUploadPaymentProof(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(order *models.Order)
+		storageErr error
+		status     int
+		code       response.Code
+		wantStatus string
+	}{
+		{name: "stores the proof", status: 200, wantStatus: models.OrderStatusPaid},
+		{
+			name:       "rejects a verified payment",
+			edit:       func(o *models.Order) { o.PaymentStatus = models.PaymentStatusVerified },
+			status:     400,
+			code:       response.CodePaymentAlreadyVerified,
+			wantStatus: models.OrderStatusPending,
+		},
+		{
+			name:       "rejects an order past payment",
+			edit:       func(o *models.Order) { o.Status = models.OrderStatusQueued },
+			status:     400,
+			code:       response.CodeOrderInvalidStatus,
+			wantStatus: models.OrderStatusQueued,
+		},
+		{
+			name:       "reports a storage failure",
+			storageErr: errors.New("cdn unavailable"),
+			status:     500,
+			code:       response.CodeInternal,
+			wantStatus: models.OrderStatusPending,
+		},
+		{
+			name:       "asks to retry while storage is shed",
+			storageErr: breaker.ErrOpen,
+			status:     503,
+			code:       response.CodeServiceUnavailable,
+			wantStatus: models.OrderStatusPending,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testutil.New(t)
+			order := seedOrder(h, seedSales(h), tt.edit)
+			h.Storage.Fail(tt.storageErr)
+
+			resp := h.Upload("/api/v1/client/payment/"+order.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), "")
+			if resp.Status != tt.status || resp.ErrorCode() != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", resp.Status, resp.ErrorCode(), tt.status, tt.code, resp.Raw)
+			}
+
+			stored := &models.Order{}
+			h.Find("orders", bson.M{"_id": order.ID}, stored)
+			if stored.Status != tt.wantStatus {
+				t.Fatalf("order status = %s, want %s", stored.Status, tt.wantStatus)
+			}
+			if tt.status == 200 {
+				uploads := h.Storage.Uploads()
+				if len(uploads) != 1 || stored.PaymentProof == nil || stored.PaymentProof.PublicID != uploads[0] {
+					t.Fatalf("payment proof %+v does not match uploads %v", stored.PaymentProof, uploads)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyPayment(t *testing.T) {
+	tests := []struct {
+		name        string
+		role        string
+		threshold   float64
+		edit        func(order *models.Order)
+		status      int
+		code        response.Code
+		wantStatus  string
+		wantPayment string
+	}{
+		{
+			name:        "verifies a pending payment",
+			role:        models.RoleAdmin,
+			edit:        withProof,
+			status:      200,
+			wantStatus:  models.OrderStatusConfirmed,
+			wantPayment: models.PaymentStatusVerified,
+		},
+		{
+			name:        "requires an admin",
+			role:        models.RoleUser,
+			edit:        withProof,
+			status:      403,
+			code:        response.CodeInsufficientPermissions,
+			wantStatus:  models.OrderStatusPaid,
+			wantPayment: models.PaymentStatusPending,
+		},
+		{
+			name:        "requires a payment proof",
+			role:        models.RoleAdmin,
+			status:      400,
+			code:        response.CodePaymentProofMissing,
+			wantStatus:  models.OrderStatusPending,
+			wantPayment: models.PaymentStatusPending,
+		},
+		{
+			name: "rejects a payment that is not pending",
+			role: models.RoleAdmin,
+			edit: func(o *models.Order) {
+				withProof(o)
+				o.PaymentStatus = models.PaymentStatusRejected
+			},
+			status:      400,
+			code:        response.CodePaymentNotPending,
+			wantStatus:  models.OrderStatusPaid,
+			wantPayment: models.PaymentStatusRejected,
+		},
+		{
+			name:        "waits for a second approval above the threshold",
+			role:        models.RoleAdmin,
+			threshold:   100000,
+			edit:        withProof,
+			status:      202,
+			wantStatus:  models.OrderStatusPaid,
+			wantPayment: models.PaymentStatusApproval,
+		},
+		{
+			name:        "lets a superadmin verify above the threshold",
+			role:        models.RoleSuperAdmin,
+			threshold:   100000,
+			edit:        withProof,
+			status:      200,
+			wantStatus:  models.OrderStatusConfirmed,
+			wantPayment: models.PaymentStatusVerified,
+		},
+		{
+			name:        "verifies alone below the threshold",
+			role:        models.RoleAdmin,
+			threshold:   1000000,
+			edit:        withProof,
+			status:      200,
+			wantStatus:  models.OrderStatusConfirmed,
+			wantPayment: models.PaymentStatusVerified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testutil.New(t)
+			order := seedOrder(h, seedSales(h), tt.edit)
+			if tt.threshold > 0 {
+				h.Insert("payment_approval_policy", bson.M{"threshold": tt.threshold})
+			}
+
+			resp := h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/verify", nil, h.Token(tt.role))
+			if resp.Status != tt.status || resp.ErrorCode() != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", resp.Status, resp.ErrorCode(), tt.status, tt.code, resp.Raw)
+			}
+
+			stored := &models.Order{}
+			h.Find("orders", bson.M{"_id": order.ID}, stored)
+			if stored.Status != tt.wantStatus || stored.PaymentStatus != tt.wantPayment {
+				t.Fatalf("order is %s/%s, want %s/%s", stored.Status, stored.PaymentStatus, tt.wantStatus, tt.wantPayment)
+			}
+			if tt.wantPayment == models.PaymentStatusVerified && stored.PaymentVerifiedAt == nil {
+				t.Fatal("verified payment has no payment_verified_at")
+			}
+		})
+	}
+}
+
+func TestSecondApproval(t *testing.T) {
+	h := testutil.New(t)
+	order := seedOrder(h, seedSales(h), withProof)
+	h.Insert("payment_approval_policy", bson.M{"threshold": 100000})
+
+	first := models.NewOrder().ID.Hex()
+	firstToken := h.TokenFor(first, models.RoleAdmin)
+	path := "/api/v1/payments/" + order.ID.Hex()
+
+	steps := []struct {
+		name        string
+		path        string
+		token       string
+		status      int
+		code        response.Code
+		wantPayment string
+	}{
+		{"first approval", "/verify", firstToken, 202, "", models.PaymentStatusApproval},
+		{"a second verify is refused", "/verify", h.Token(models.RoleAdmin), 400, response.CodePaymentNotPending, models.PaymentStatusApproval},
+		{"the first approver cannot approve again", "/approve", h.TokenFor(first, models.RoleAdmin), 400, response.CodePaymentSameApprover, models.PaymentStatusApproval},
+		{"another admin approves", "/approve", h.Token(models.RoleAdmin), 200, "", models.PaymentStatusVerified},
+		{"an approved payment cannot be approved again", "/approve", h.Token(models.RoleAdmin), 400, response.CodePaymentNotAwaiting, models.PaymentStatusVerified},
+	}
+	for _, step := range steps {
+		resp := h.Request("POST", path+step.path, nil, step.token)
+		if resp.Status != step.status || resp.ErrorCode() != step.code {
+			t.Fatalf("%s: got %d %q, want %d %q: %s", step.name, resp.Status, resp.ErrorCode(), step.status, step.code, resp.Raw)
+		}
+
+		stored := &models.Order{}
+		h.Find("orders", bson.M{"_id": order.ID}, stored)
+		if stored.PaymentStatus != step.wantPayment {
+			t.Fatalf("%s: payment status = %s, want %s", step.name, stored.PaymentStatus, step.wantPayment)
+		}
+	}
+
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.Status != models.OrderStatusConfirmed || stored.PaymentApprovedBy != first {
+		t.Fatalf("order is %s approved by %q, want confirmed approved by %s", stored.Status, stored.PaymentApprovedBy, first)
+	}
+}
+
+func TestRejectPayment(t *testing.T) {
+	tests := []struct {
+		name        string
+		edit        func(order *models.Order)
+		status      int
+		code        response.Code
+		wantPayment string
+	}{
+		{name: "rejects a pending payment", edit: withProof, status: 200, wantPayment: models.PaymentStatusRejected},
+		{
+			name:        "rejects a payment waiting for approval",
+			edit:        func(o *models.Order) { withProof(o); o.PaymentStatus = models.PaymentStatusApproval },
+			status:      200,
+			wantPayment: models.PaymentStatusRejected,
+		},
+		{
+			name:        "refuses a verified payment",
+			edit:        func(o *models.Order) { withProof(o); o.PaymentStatus = models.PaymentStatusVerified },
+			status:      400,
+			code:        response.CodePaymentNotPending,
+			wantPayment: models.PaymentStatusVerified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testutil.New(t)
+			order := seedOrder(h, seedSales(h), tt.edit)
+
+			resp := h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/reject",
+				map[string]interface{}{"reason": "Nominal tidak sesuai"}, h.Token(models.RoleAdmin))
+			if resp.Status != tt.status || resp.ErrorCode() != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", resp.Status, resp.ErrorCode(), tt.status, tt.code, resp.Raw)
+			}
+
+			stored := &models.Order{}
+			h.Find("orders", bson.M{"_id": order.ID}, stored)
+			if stored.PaymentStatus != tt.wantPayment {
+				t.Fatalf("payment status = %s, want %s", stored.PaymentStatus, tt.wantPayment)
+			}
+		})
+	}
+}
+
+// alertSink records the alerts posted to a channel
+type alertSink struct{ alerts []chatalert.Alert }
+
+func (s *alertSink) Send(_ context.Context, alert chatalert.Alert) error {
+	s.alerts = append(s.alerts, alert)
+	return nil
+}
+
+func TestPaymentBacklogAlert(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sink := &alertSink{}
+	chatalert.Register("recorder", func(string, *http.Client) chatalert.Sink { return sink })
+
+	resp := h.Request("PUT", "/api/v1/settings/alerts", map[string]interface{}{
+		"channels": []map[string]interface{}{{"name": "ops", "kind": "recorder", "webhook_url": "https://hooks.test/ops"}},
+		"rules":    []map[string]interface{}{{"type": chatalert.TypePaymentBacklog, "enabled": true, "threshold": 2, "cooldown_minutes": 60}},
+	}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("save policy: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	sales := seedSales(h)
+	seedOrder(h, sales, withProof)
+	chatalert.Check()
+	if len(sink.alerts) != 0 {
+		t.Fatalf("alerted below the threshold: %v", sink.alerts)
+	}
+
+	seedOrder(h, sales, withProof)
+	chatalert.Check()
+	chatalert.Check()
+	if len(sink.alerts) != 1 || sink.alerts[0].Type != chatalert.TypePaymentBacklog {
+		t.Fatalf("alerts = %v, want one payment backlog alert within the cooldown", sink.alerts)
+	}
+}
+
+func TestPaymentPresenceAndClaims(t *testing.T) {
+	h := testutil.New(t)
+	user := models.NewUser()
+	user.Username = "verifier"
+	user.Role = models.RoleAdmin
+	h.Insert("users", user)
+	admin := h.TokenFor(user.ID.Hex(), models.RoleAdmin)
+	other := h.Token(models.RoleAdmin)
+	order := seedOrder(h, seedSales(h), withProof)
+
+	resp := h.Request("POST", "/api/v1/presence/heartbeat", map[string]string{"view": presence.ViewPayments}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("heartbeat: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp = h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/claim", nil, admin); resp.Status != 200 {
+		t.Fatalf("claim: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/claim", nil, other)
+	if resp.Status != 409 || resp.ErrorCode() != response.CodePaymentClaimed {
+		t.Fatalf("claim by another admin: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp = h.Request("GET", "/api/v1/presence", nil, other)
+	admins, _ := resp.Data()["admins"].([]interface{})
+	if len(admins) != 1 || resp.Data()["watching"] != float64(1) || admins[0].(map[string]interface{})["claims"] != float64(1) {
+		t.Fatalf("presence: want the verifier watching with one claim: %s", resp.Raw)
+	}
+
+	// An old claim of an admin without heartbeats returns to the queue, the online one stays
+	stale := time.Now().Add(-time.Hour)
+	abandoned := seedOrder(h, seedSales(h), func(o *models.Order) {
+		withProof(o)
+		o.PaymentClaimedBy = "gone-offline"
+		o.PaymentClaimedAt = &stale
+	})
+	presence.ReleaseStaleClaims()
+	if h.Count("orders", bson.M{"_id": abandoned.ID, "payment_claimed_by": bson.M{"$exists": true}}) != 0 {
+		t.Fatal("the claim of the offline admin was kept")
+	}
+	if h.Count("orders", bson.M{"_id": order.ID, "payment_claimed_by": user.ID.Hex()}) != 1 {
+		t.Fatal("the claim of the online admin was released")
+	}
+}
+
+func TestSignedPaymentProofLinks(t *testing.T) {
+	h := testutil.New(t)
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("proof-bytes"))
+	}))
+	defer cdn.Close()
+
+	order := seedOrder(h, seedSales(h), func(o *models.Order) {
+		withProof(o)
+		o.PaymentProof.URL = cdn.URL + "/payment-proof/1.jpg"
+	})
+
+	// The invoice page gets a signed link instead of the CDN URL
+	resp := h.Request("GET", "/api/v1/client/invoice/"+order.InvoiceToken, nil, "")
+	proof, _ := resp.Data()["payment_proof"].(map[string]interface{})
+	link, _ := proof["url"].(string)
+	if strings.Contains(string(resp.Raw), cdn.URL) || !strings.HasPrefix(link, "/api/v1/media/orders/"+order.ID.Hex()+"/") {
+		t.Fatalf("invoice: want only a signed proof link: %s", resp.Raw)
+	}
+
+	if resp = h.Request("GET", link, nil, ""); resp.Status != 200 || string(resp.Raw) != "proof-bytes" {
+		t.Fatalf("signed link: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("GET", strings.Replace(link, "payment-proof", "vehicle-photo", 1), nil, "")
+	if resp.Status != 403 || resp.ErrorCode() != response.CodeMediaLinkInvalid {
+		t.Fatalf("link signed for another image: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestPaymentProofVariants(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+
+	// Uploads store the resized copies Cloudinary derives from the original
+	uploaded := seedOrder(h, sales, nil)
+	if resp := h.Upload("/api/v1/client/payment/"+uploaded.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), ""); resp.Status != 200 {
+		t.Fatalf("upload: status = %d: %s", resp.Status, resp.Raw)
+	}
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": uploaded.ID}, stored)
+	if thumb := stored.PaymentProof.Variants["thumb"]; !strings.Contains(thumb, "/image/upload/c_limit,w_200,h_200,q_auto/") {
+		t.Fatalf("thumb variant = %q", thumb)
+	}
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer cdn.Close()
+
+	order := seedOrder(h, sales, func(o *models.Order) {
+		withProof(o)
+		o.PaymentProof.URL = cdn.URL + "/full.jpg"
+		o.PaymentProof.Variants = map[string]string{"thumb": cdn.URL + "/thumb.jpg"}
+	})
+
+	// The queue lists signed links to the variants, never their CDN URLs
+	resp := h.Request("GET", "/api/v1/payments/pending", nil, h.Token(models.RoleAdmin))
+	link := ""
+	items, _ := resp.Body["data"].([]interface{})
+	for _, item := range items {
+		listed, _ := item.(map[string]interface{})
+		if listed["id"] != order.ID.Hex() {
+			continue
+		}
+		proof, _ := listed["payment_proof"].(map[string]interface{})
+		variants, _ := proof["variants"].(map[string]interface{})
+		link, _ = variants["thumb"].(string)
+	}
+	if link == "" || strings.Contains(string(resp.Raw), cdn.URL) {
+		t.Fatalf("want a signed thumb link: %s", resp.Raw)
+	}
+
+	if resp = h.Request("GET", link, nil, ""); resp.Status != 200 || string(resp.Raw) != "/thumb.jpg" {
+		t.Fatalf("thumb link: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("GET", strings.Replace(link, "variant=thumb", "variant=medium", 1), nil, "")
+	if resp.Status != 403 || resp.ErrorCode() != response.CodeMediaLinkInvalid {
+		t.Fatalf("link signed for another variant: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestPaymentScreening(t *testing.T) {
+	h := testutil.New(t)
+
+	requests := make(chan screening.Request, 1)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-Screening-Signature"); got != screening.Sign(body, "shh") {
+			t.Errorf("signature = %q, want the HMAC of the payload", got)
+		}
+		var req screening.Request
+		json.Unmarshal(body, &req)
+		requests <- req
+		w.Write([]byte(`{"verdict":"Suspicious","score":0.91,"reasons":["edited amount"],"reference":"chk_1"}`))
+	}))
+	defer service.Close()
+
+	previous := config.Cfg.Screening
+	t.Cleanup(func() { config.Cfg.Screening = previous })
+	config.Cfg.Screening = config.ScreeningConfig{URL: service.URL, Secret: "shh", Timeout: 5 * time.Second}
+
+	order := seedOrder(h, seedSales(h), nil)
+	if resp := h.Upload("/api/v1/client/payment/"+order.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), ""); resp.Status != 200 {
+		t.Fatalf("upload: %d %s", resp.Status, resp.Raw)
+	}
+
+	select {
+	case req := <-requests:
+		if req.OrderID != order.ID.Hex() || req.TotalPrice != order.TotalPrice || !strings.Contains(req.ImageURL, "sig=") {
+			t.Fatalf("screening request %+v does not describe the order with a signed proof link", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the proof was not sent for screening")
+	}
+
+	stored := &models.Order{}
+	h.Eventually(func() bool {
+		h.Find("orders", bson.M{"_id": order.ID}, stored)
+		return stored.PaymentScreening != nil && stored.PaymentScreening.CheckedAt != nil
+	}, "no verdict stored on the order")
+	if s := stored.PaymentScreening; s.Status != models.ScreeningSuspicious || s.Score != 0.91 || s.Reference != "chk_1" || len(s.Reasons) != 1 {
+		t.Fatalf("screening = %+v, want the suspicious verdict", s)
+	}
+
+	admin := h.Token(models.RoleAdmin)
+	for query, want := range map[string]int{"suspicious": 1, "clean": 0, "none": 0} {
+		resp := h.Request("GET", "/api/v1/payments/pending?screening="+query, nil, admin)
+		items, _ := resp.Body["data"].([]interface{})
+		if resp.Status != 200 || len(items) != want {
+			t.Fatalf("screening=%s: got %d orders, want %d: %s", query, len(items), want, resp.Raw)
+		}
+		if want == 1 && !strings.Contains(string(resp.Raw), `"payment_screening":{"status":"suspicious"`) {
+			t.Fatalf("verdict missing from the verification list: %s", resp.Raw)
+		}
+	}
+}
+
+func TestCloudinaryModerationWebhook(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	order := seedOrder(h, seedSales(h), withProof)
+
+	previous := config.Cfg.CDN
+	t.Cleanup(func() { config.Cfg.CDN = previous })
+	config.Cfg.CDN.APISecret = "cdn-secret"
+	config.Cfg.CDN.WebhookMaxAge = time.Hour
+
+	notify := func(body string, timestamp time.Time, secret string) *testutil.Response {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		sum := sha1.Sum([]byte(body + ts + secret))
+		req := httptest.NewRequest("POST", "/api/v1/integrations/cloudinary/webhook", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Cld-Timestamp", ts)
+		req.Header.Set("X-Cld-Signature", hex.EncodeToString(sum[:]))
+		return h.Send(req)
+	}
+	pending := func(query string) int {
+		resp := h.Request("GET", "/api/v1/payments/pending"+query, nil, admin)
+		data, _ := resp.Body["data"].([]interface{})
+		return len(data)
+	}
+	rejected := `{"notification_type":"moderation","public_id":"payment-proof/1","moderation_status":"rejected","moderation_kind":"aws_rek"}`
+
+	if resp := notify(rejected, time.Now(), "wrong-secret"); resp.Status != 401 || resp.ErrorCode() != response.CodeSignatureInvalid {
+		t.Fatalf("wrong signature: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := notify(rejected, time.Now().Add(-2*time.Hour), "cdn-secret"); resp.Status != 401 {
+		t.Fatalf("replayed call: status = %d", resp.Status)
+	}
+
+	if n := pending(""); n != 1 {
+		t.Fatalf("pending before moderation = %d", n)
+	}
+	resp := notify(rejected, time.Now(), "cdn-secret")
+	if resp.Status != 200 || resp.Data()["updated"] != float64(1) {
+		t.Fatalf("webhook: status = %d: %s", resp.Status, resp.Raw)
+	}
+	var stored models.Order
+	h.Find("orders", bson.M{"_id": order.ID}, &stored)
+	if stored.PaymentProof.Moderation != models.ModerationRejected || stored.PaymentProof.ModeratedAt == nil {
+		t.Fatalf("payment proof = %+v", stored.PaymentProof)
+	}
+
+	// Rejected proofs leave the verification list, but can still be listed on purpose
+	if n := pending(""); n != 0 {
+		t.Fatalf("pending after rejection = %d", n)
+	}
+	if n := pending("?moderation=rejected"); n != 1 {
+		t.Fatalf("rejected proofs = %d", n)
+	}
+}
+
+func TestBankMutationImport(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	uploaded := time.Date(2026, 10, 1, 14, 0, 0, 0, time.Local)
+	seedProof := func(number string, total float64) *models.Order {
+		return seedOrder(h, sales, func(o *models.Order) {
+			withProof(o)
+			o.OrderNumber, o.TotalPrice, o.PaymentUploadedAt = number, total, &uploaded
+		})
+	}
+	paid := seedProof("ORD-20261001-0001", 1500000)
+	first := seedProof("ORD-20261001-0002", 750000)
+	seedProof("ORD-20261001-0003", 750000)
+
+	bca := []byte(`Informasi Rekening - Mutasi Rekening
+No. rekening : ,'1234567890
+Periode : ,01/10/2026 - 14/10/2026
+
+Tanggal Transaksi,Keterangan,Cabang,Jumlah,,Saldo
+'01/10,'TRSF E-BANKING CR 0110/FTSCY/WS95031 ORD20261001 0001 BUDI SANTOSO,'0000,"1,500,000.00",CR,"11,500,000.00"
+'02/10,'TRSF E-BANKING CR 0210/FTSCY/WS95031 TRANSFER,'0000,"750,000.00",CR,"12,250,000.00"
+'03/10,'BIAYA ADM,'0000,"15,000.00",DB,"12,235,000.00"
+Saldo Awal,"10,000,000.00"
+`)
+
+	resp := h.Upload("/api/v1/payments/mutations/import", "file", "mutasi.csv", []byte("not,a,statement\n"), admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unknown format: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp = h.Upload("/api/v1/payments/mutations/import?dry_run=true", "file", "mutasi.csv", bca, admin)
+	data := resp.Data()
+	if resp.Status != 200 || data["bank"] != "bca" || data["matched"] != float64(1) || data["verifiable"] != float64(1) ||
+		data["unmatched"] != float64(1) || data["ignored"] != float64(1) {
+		t.Fatalf("dry run: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if h.Count("bank_mutations", bson.M{}) != 0 || h.Count("orders", bson.M{"payment_match": bson.M{"$exists": true}}) != 0 {
+		t.Fatal("the dry run saved mutations or matches")
+	}
+
+	// The 750.000 transfer fits two orders equally, so only the referenced one matches
+	resp = h.Upload("/api/v1/payments/mutations/import", "file", "mutasi.csv", bca, admin)
+	if resp.Status != 200 || h.Count("bank_mutations", bson.M{}) != 3 {
+		t.Fatalf("import: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("GET", "/api/v1/payments/pending?match=verifiable", nil, admin)
+	orders, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(orders) != 1 || orders[0].(map[string]interface{})["id"] != paid.ID.Hex() {
+		t.Fatalf("verifiable payments: %s", resp.Raw)
+	}
+	match := orders[0].(map[string]interface{})["payment_match"].(map[string]interface{})
+	if match["confidence"] != float64(100) || fmt.Sprint(match["reasons"]) != "[amount reference date sender]" {
+		t.Fatalf("payment match = %v", match)
+	}
+
+	resp = h.Upload("/api/v1/payments/mutations/import", "file", "mutasi.csv", bca, admin)
+	if resp.Status != 200 || resp.Data()["duplicates"] != float64(3) || h.Count("bank_mutations", bson.M{}) != 3 {
+		t.Fatalf("re-import: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Mandiri: separate debit and credit columns, Indonesian number format
+	mandiri := []byte("Account No,Date,Val. Date,Transaction Code,Description,Reference No.,Debit,Credit\n" +
+		`1234567890,03/10/2026,03/10/2026,,TRANSFER DARI BUDI,ORD-20261001-0002,,"750.000,00"` + "\n")
+	resp = h.Upload("/api/v1/payments/mutations/import", "file", "mandiri.csv", mandiri, admin)
+	rows, _ := resp.Data()["rows"].([]interface{})
+	if resp.Status != 200 || resp.Data()["bank"] != "mandiri" || len(rows) != 1 || rows[0].(map[string]interface{})["order_id"] != first.ID.Hex() {
+		t.Fatalf("mandiri import: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", "/api/v1/payments/"+paid.ID.Hex()+"/verify", nil, admin)
+	if resp.Status != 200 || h.Count("bank_mutations", bson.M{"order_id": paid.ID.Hex(), "status": models.MutationVerified}) != 1 {
+		t.Fatalf("verify: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Rejecting the payment releases its mutation, and a re-import matches the 750.000
+	// transfer to the order it no longer ties with
+	resp = h.Request("POST", "/api/v1/payments/"+first.ID.Hex()+"/reject", map[string]interface{}{"reason": "Bukan transfer ini"}, admin)
+	if resp.Status != 200 || h.Count("orders", bson.M{"_id": first.ID, "payment_match": bson.M{"$exists": true}}) != 0 ||
+		h.Count("bank_mutations", bson.M{"bank": "mandiri", "status": models.MutationUnmatched, "order_id": bson.M{"$exists": false}}) != 1 {
+		t.Fatalf("reject: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Upload("/api/v1/payments/mutations/import", "file", "mutasi.csv", bca, admin)
+	if resp.Status != 200 || resp.Data()["rematched"] != float64(1) ||
+		h.Count("bank_mutations", bson.M{"bank": "bca", "amount": 750000, "status": models.MutationMatched}) != 1 {
+		t.Fatalf("re-import after reject: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A proof uploaded after its transfer was imported matches the released mutation
+	late := seedOrder(h, sales, func(o *models.Order) { o.TotalPrice = 750000 })
+	if resp = h.Upload("/api/v1/client/payment/"+late.InvoiceToken, "proof", "transfer.jpg", []byte("jpeg"), ""); resp.Status != 200 {
+		t.Fatalf("upload: status = %d: %s", resp.Status, resp.Raw)
+	}
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": late.ID}, stored)
+	if stored.PaymentMatch == nil || h.Count("bank_mutations", bson.M{"bank": "mandiri", "order_id": late.ID.Hex(), "status": models.MutationMatched}) != 1 {
+		t.Fatalf("proof upload did not match the imported transfer: %+v", stored.PaymentMatch)
+	}
+
+	resp = h.Upload("/api/v1/payments/mutations/import", "file", "notes.csv", []byte("name,phone\nBudi,0812\n"), admin)
+	if resp.Status != 400 {
+		t.Fatalf("unknown format: status = %d", resp.Status)
+	}
+}
+
+func TestPaymentReminders(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	resp := h.Request("GET", "/api/v1/settings/payment-reminders", nil, admin)
+	policy, _ := resp.Data()["policy"].(map[string]interface{})
+	if resp.Status != 200 || policy["enabled"] != false || len(policy["steps"].([]interface{})) != 2 {
+		t.Fatalf("default policy: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	step := func(hours int, template string) map[string]interface{} {
+		return map[string]interface{}{"after_hours": hours, "template": template}
+	}
+	for _, body := range []map[string]interface{}{
+		{"enabled": true, "max_reminders": 2},
+		{"steps": []interface{}{step(4, "a"), step(4, "b")}, "max_reminders": 2},
+		{"steps": []interface{}{step(4, "")}, "max_reminders": 2},
+		{"steps": []interface{}{step(4, "a")}, "repeat_hours": -1, "max_reminders": 2},
+		{"steps": []interface{}{step(4, "a")}, "max_reminders": 11},
+	} {
+		if resp := h.Request("PUT", "/api/v1/settings/payment-reminders", body, admin); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+	resp = h.Request("PUT", "/api/v1/settings/payment-reminders", map[string]interface{}{
+		"enabled":       true,
+		"steps":         []interface{}{step(1, "First {name} {order_number} {total} {hours}h"), step(5, "Second {order_number}")},
+		"repeat_hours":  10,
+		"max_reminders": 4,
+	}, admin)
+	if resp.Status != 200 || resp.Data()["enabled"] != true {
+		t.Fatalf("update policy: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	created := func(ago time.Duration, count int) func(order *models.Order) {
+		return func(order *models.Order) {
+			order.CreatedAt = time.Now().Add(-ago)
+			order.PaymentReminderCount = count
+		}
+	}
+	first := seedOrder(h, sales, created(2*time.Hour, 0))
+	late := seedOrder(h, sales, created(6*time.Hour, 0))
+	seedOrder(h, sales, created(30*time.Minute, 0))
+	repeated := seedOrder(h, sales, created(20*time.Hour, 2))
+	seedOrder(h, sales, created(100*time.Hour, 4))
+	seedOrder(h, sales, func(order *models.Order) {
+		order.CreatedAt = time.Now().Add(-10 * time.Hour)
+		order.Status = models.OrderStatusPaid
+	})
+
+	// One reminder per order and run: the next one waits for the following run
+	notification.SendPaymentReminders()
+	messages := h.WhatsApp.MessagesTo(sales.Phone)
+	if len(messages) != 3 {
+		t.Fatalf("first run sent %d reminders: %v", len(messages), messages)
+	}
+	texts := map[string]bool{}
+	for _, message := range messages {
+		texts[message.Text] = true
+	}
+	if !texts["First Budi "+first.OrderNumber+" 500000 2h"] || !texts["First Budi "+late.OrderNumber+" 500000 6h"] {
+		t.Fatalf("step reminders = %v", texts)
+	}
+	// After the steps the last one repeats every repeat_hours: the third is due at 5+10 hours
+	if !texts["Second "+repeated.OrderNumber] {
+		t.Fatalf("repeated reminder missing: %v", texts)
+	}
+
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": first.ID}, stored)
+	if stored.PaymentReminderCount != 1 || len(stored.PaymentReminders) != 1 || stored.PaymentReminders[0].Phone != sales.Phone {
+		t.Fatalf("reminders of the order = %+v", stored.PaymentReminders)
+	}
+	if n := h.Count("notifications", bson.M{"type": string(notification.NotificationTypePaymentReminder), "order_id": first.ID.Hex()}); n != 1 {
+		t.Fatalf("%d reminder notifications", n)
+	}
+
+	notification.SendPaymentReminders()
+	messages = h.WhatsApp.MessagesTo(sales.Phone)
+	if len(messages) != 4 || messages[3].Text != "Second "+late.OrderNumber {
+		t.Fatalf("second run: %v", messages)
+	}
+	notification.SendPaymentReminders()
+	if n := len(h.WhatsApp.MessagesTo(sales.Phone)); n != 4 {
+		t.Fatalf("third run sent %d more", n-4)
+	}
+}
+
+func TestApprovalPolicy(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	superadmin := h.Token(models.RoleSuperAdmin)
+
+	// Without a saved policy every admin verifies alone
+	resp := h.Request("GET", "/api/v1/settings/payment-approval", nil, admin)
+	if resp.Status != 200 || resp.Data()["threshold"] != 0.0 {
+		t.Fatalf("default policy: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp := h.Request("PUT", "/api/v1/settings/payment-approval", map[string]interface{}{"threshold": 100000}, admin); resp.Status != 403 {
+		t.Fatalf("admin update: status = %d", resp.Status)
+	}
+	if resp := h.Request("PUT", "/api/v1/settings/payment-approval", map[string]interface{}{"threshold": -1}, superadmin); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("negative threshold: status = %d: %s", resp.Status, resp.Raw)
+	}
+	for _, threshold := range []float64{50000, 100000} {
+		resp = h.Request("PUT", "/api/v1/settings/payment-approval", map[string]interface{}{"threshold": threshold}, superadmin)
+		if resp.Status != 200 || resp.Data()["threshold"] != threshold || resp.Data()["updated_by"] == "" {
+			t.Fatalf("update: status = %d: %s", resp.Status, resp.Raw)
+		}
+	}
+	if h.Count("payment_approval_policy", bson.M{}) != 1 || h.Count("audit_logs", bson.M{"action": audit.ActionApprovalPolicy}) != 2 {
+		t.Fatal("the policy must be saved once and every change audited")
+	}
+
+	// The saved policy holds back a large payment for a second approver
+	order := seedOrder(h, seedSales(h), withProof)
+	first := models.NewOrder().ID.Hex()
+	if resp := h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/verify", nil, h.TokenFor(first, models.RoleAdmin)); resp.Status != 202 || resp.Data()["payment_status"] != models.PaymentStatusApproval {
+		t.Fatalf("verify: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/approve", nil, admin); resp.Status != 200 {
+		t.Fatalf("approve: status = %d: %s", resp.Status, resp.Raw)
+	}
+	var step models.AuditLog
+	h.Find("audit_logs", bson.M{"action": audit.ActionPaymentApprove}, &step)
+	var verified models.AuditLog
+	h.Find("audit_logs", bson.M{"action": audit.ActionPaymentVerify}, &verified)
+	if step.EntityID != order.ID.Hex() || step.Details["step"] != int32(1) || verified.Details["first_approved_by"] != first {
+		t.Fatalf("audit: approve %+v, verify %+v", step, verified)
+	}
+
+	if resp := h.Request("POST", "/api/v1/payments/bad/approve", nil, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeInvalidID {
+		t.Fatalf("bad id: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/payments/"+primitive.NewObjectID().Hex()+"/approve", nil, admin); resp.ErrorCode() != response.CodeOrderNotFound {
+		t.Fatalf("missing order: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/approve", nil, h.Token(models.RoleUser)); resp.Status != 403 {
+		t.Fatalf("user approve: status = %d", resp.Status)
+	}
+
+	// A verified payment can no longer be rejected
+	if resp := h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/reject", map[string]interface{}{"reason": "late"}, admin); resp.Status != 400 || resp.ErrorCode() != response.CodePaymentNotPending {
+		t.Fatalf("reject verified: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Without a readable policy nothing is verified alone
+	pending := seedOrder(h, seedSales(h), withProof)
+	h.Mongo.Fail("payment_approval_policy")
+	if resp := h.Request("POST", "/api/v1/payments/"+pending.ID.Hex()+"/verify", nil, admin); resp.Status != 503 || resp.ErrorCode() != response.CodeServiceUnavailable {
+		t.Fatalf("verify without policy: status = %d: %s", resp.Status, resp.Raw)
+	}
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": pending.ID}, stored)
+	if stored.PaymentStatus != models.PaymentStatusPending {
+		t.Fatalf("payment status without policy = %s", stored.PaymentStatus)
+	}
+	if resp := h.Request("GET", "/api/v1/settings/payment-approval", nil, admin); resp.Status != 503 {
+		t.Fatalf("policy read failure: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/presence"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// releaseClaim unsets the payment claim of an order
+var releaseClaim = bson.M{"payment_claimed_by": "", "payment_claimed_at": ""}
+
+// PresenceHandler reports which admins are online and what they are watching
+type PresenceHandler struct{}
+
+// NewPresenceHandler creates a new presence handler
+func NewPresenceHandler() *PresenceHandler {
+	return &PresenceHandler{}
+}
+
+// reviewableFilter matches the payments waiting in the verification queue
+func reviewableFilter() bson.M {
+	return bson.M{
+		"payment_status": bson.M{"$in": []string{models.PaymentStatusPending, models.PaymentStatusApproval}},
+		"payment_proof":  bson.M{"$ne": nil},
+	}
+}
+
+// Heartbeat records that the admin is online on a view; clients send it every
+// interval_seconds while the page is open
+func (h *PresenceHandler) Heartbeat(c *fiber.Ctx) error {
+	type HeartbeatRequest struct {
+		View string `json:"view"`
+	}
+
+	var req HeartbeatRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.View == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "view is required")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	// Impersonating admins count as themselves, not as the impersonated user
+	userID, _ := primitive.ObjectIDFromHex(middleware.GetActorID(c))
+	user := &models.User{}
+	if err := database.GetMongoCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(user); err != nil {
+		return response.NotFoundCode(c, response.CodeUserNotFound, "User not found")
+	}
+
+	current, err := presence.Beat(ctx, user, req.View)
+	if err != nil {
+		return response.Error(c, 500, "Failed to record heartbeat")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"presence":         current,
+		"interval_seconds": int(presence.Interval.Seconds()),
+		"timeout_seconds":  int(presence.Timeout.Seconds()),
+	})
+}
+
+// List returns the online admins with the payments each has claimed, and how many
+// payments wait in the queue
+func (h *PresenceHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	online, err := presence.Online(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch presence")
+	}
+
+	collection := database.GetMongoCollection("orders")
+	pending, err := collection.CountDocuments(ctx, reviewableFilter())
+	if err != nil {
+		return response.Error(c, 500, "Failed to count pending payments")
+	}
+
+	claimFilter := reviewableFilter()
+	claimFilter["payment_claimed_by"] = bson.M{"$exists": true}
+	cursor, err := collection.Find(ctx, claimFilter,
+		options.Find().SetProjection(bson.M{"payment_claimed_by": 1}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch payment claims")
+	}
+	var claimedOrders []models.Order
+	err = cursor.All(ctx, &claimedOrders)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode payment claims")
+	}
+
+	claims := map[string]int{}
+	for _, order := range claimedOrders {
+		claims[order.PaymentClaimedBy]++
+	}
+
+	type Admin struct {
+		presence.Presence
+		Claims int `json:"claims"`
+	}
+
+	admins := []Admin{}
+	watching := 0
+	for _, p := range online {
+		admins = append(admins, Admin{Presence: p, Claims: claims[p.UserID]})
+		if p.View == presence.ViewPayments {
+			watching++
+		}
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"admins":           admins,
+		"watching":         watching,
+		"pending_payments": pending,
+		"claimed_payments": len(claimedOrders),
+	})
+}
+
+// Coverage returns, for every hour of a day, how many admins watched the payment queue;
+// hours nobody watched are listed as gaps
+func (h *PresenceHandler) Coverage(c *fiber.Ctx) error {
+	day, err := time.ParseInLocation("2006-01-02", c.Query("date", time.Now().Format("2006-01-02")), time.Local)
+	if err != nil {
+		return response.BadRequest(c, "Invalid date, use YYYY-MM-DD")
+	}
+	view := c.Query("view", presence.ViewPayments)
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	sessions, err := presence.Sessions(ctx, view, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch presence sessions")
+	}
+
+	type Hour struct {
+		Hour   int      `json:"hour"`
+		Admins []string `json:"admins"`
+	}
+
+	hours := make([]Hour, 24)
+	gaps := []int{}
+	for i := range hours {
+		from := day.Add(time.Duration(i) * time.Hour)
+		to := from.Add(time.Hour)
+
+		seen := map[string]bool{}
+		hours[i] = Hour{Hour: i, Admins: []string{}}
+		for _, session := range sessions {
+			// A session's last heartbeat still covers the timeout after it
+			if session.StartedAt.Before(to) && !session.LastSeenAt.Add(presence.Timeout).Before(from) && !seen[session.UserID] {
+				seen[session.UserID] = true
+				hours[i].Admins = append(hours[i].Admins, session.Username)
+			}
+		}
+		if len(hours[i].Admins) == 0 && from.Before(time.Now()) {
+			gaps = append(gaps, i)
+		}
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"date":  day.Format("2006-01-02"),
+		"view":  view,
+		"hours": hours,
+		"gaps":  gaps,
+	})
+}
+
+// Claim marks a queued payment as being reviewed by the admin, so others pick another
+// one. Claims of admins who go offline are released by the presence-claims job.
+func (h *PaymentHandler) Claim(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	userID := middleware.GetActorID(c)
+	filter := reviewableFilter()
+	filter["_id"] = objID
+	filter["$or"] = []bson.M{
+		{"payment_claimed_by": bson.M{"$exists": false}},
+		{"payment_claimed_by": userID},
+	}
+
+	now := time.Now()
+	collection := database.GetMongoCollection("orders")
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"payment_claimed_by": userID,
+		"payment_claimed_at": now,
+	}})
+	if err != nil {
+		return response.Error(c, 500, "Failed to claim payment")
+	}
+
+	if result.MatchedCount == 0 {
+		order := &models.Order{}
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+			return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+		}
+		if order.PaymentClaimedBy != "" && order.PaymentClaimedBy != userID {
+			return response.ErrorCodeWithData(c, 409, response.CodePaymentClaimed, "Another admin is reviewing this payment", fiber.Map{
+				"claimed_by": order.PaymentClaimedBy,
+				"claimed_at": order.PaymentClaimedAt,
+			})
+		}
+		return response.ErrorCode(c, 400, response.CodePaymentNotPending, "Payment is not waiting for review")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"order_id":   objID.Hex(),
+		"claimed_by": userID,
+		"claimed_at": now,
+	})
+}
+
+// Release returns a claimed payment to the shared queue
+func (h *PaymentHandler) Release(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	filter := bson.M{"_id": objID, "payment_claimed_by": middleware.GetActorID(c)}
+	if _, err := database.GetMongoCollection("orders").UpdateOne(ctx, filter, bson.M{"$unset": releaseClaim}); err != nil {
+		return response.Error(c, 500, "Failed to release payment")
+	}
+
+	return response.SuccessWithMessage(c, 200, "Payment released")
+}
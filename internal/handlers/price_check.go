@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// priceCheckPolicyCollection stores the price check policy
+	priceCheckPolicyCollection = "price_check_policy"
+
+	// priceOverrideCollection records the items entered beyond the allowed deviation
+	priceOverrideCollection = "price_overrides"
+)
+
+// PriceWarning is an order item whose unit price deviates from its catalog price by more
+// than the policy allows
+type PriceWarning struct {
+	Index            int     `json:"index"` // Position in the request's items
+	ProductName      string  `json:"product_name"`
+	ListPrice        float64 `json:"list_price"`
+	UnitPrice        float64 `json:"unit_price"`
+	DeviationPercent float64 `json:"deviation_percent"` // Negative below the list price
+	HasNote          bool    `json:"has_note"`
+}
+
+// loadPriceCheckPolicy returns the saved price check policy, or a disabled one when none is
+// saved. Read failures are returned so callers fail closed instead of skipping the check.
+func loadPriceCheckPolicy(ctx context.Context) (*models.PriceCheckPolicy, error) {
+	policy := &models.PriceCheckPolicy{}
+	err := database.GetMongoCollection(priceCheckPolicyCollection).FindOne(ctx, bson.M{}).Decode(policy)
+	if err == mongo.ErrNoDocuments {
+		return &models.PriceCheckPolicy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// catalogProducts maps lowercased product names to the catalog product of that name, so
+// manually entered order items pick up their product's loading duration category and price
+func catalogProducts(ctx context.Context, names []string) (map[string]models.Product, error) {
+	products := map[string]models.Product{}
+	if len(names) == 0 {
+		return products, nil
+	}
+
+	patterns := bson.A{}
+	for _, name := range names {
+		patterns = append(patterns, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.TrimSpace(name)) + "$", Options: "i"})
+	}
+	cursor, err := database.GetMongoCollection("products").Find(ctx,
+		bson.M{"name": bson.M{"$in": patterns}},
+		options.Find().SetProjection(bson.M{"name": 1, "category": 1, "price": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Product
+	err = cursor.All(ctx, &found)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, product := range found {
+		products[strings.ToLower(strings.TrimSpace(product.Name))] = product
+	}
+	return products, nil
+}
+
+// checkPrice compares an item with its catalog price. It reports a warning when the
+// policy is enabled and the deviation is beyond it.
+func checkPrice(policy *models.PriceCheckPolicy, index int, item models.OrderItem) (PriceWarning, bool) {
+	if policy.MaxDeviationPercent <= 0 || item.ListPrice <= 0 {
+		return PriceWarning{}, false
+	}
+
+	deviation := math.Round((item.UnitPrice-item.ListPrice)/item.ListPrice*1000) / 10
+	if math.Abs(deviation) <= policy.MaxDeviationPercent {
+		return PriceWarning{}, false
+	}
+	return PriceWarning{
+		Index:            index,
+		ProductName:      item.ProductName,
+		ListPrice:        item.ListPrice,
+		UnitPrice:        item.UnitPrice,
+		DeviationPercent: deviation,
+		HasNote:          item.PriceNote != "",
+	}, true
+}
+
+// missingPriceNotes returns the warnings of items without a price note
+func missingPriceNotes(warnings []PriceWarning) []PriceWarning {
+	missing := []PriceWarning{}
+	for _, warning := range warnings {
+		if !warning.HasNote {
+			missing = append(missing, warning)
+		}
+	}
+	return missing
+}
+
+// priceOverrides builds the override records of a new order's price warnings; items are
+// the order items the warnings are about, in the same order
+func priceOverrides(c *fiber.Ctx, order *models.Order, items []models.OrderItem, warnings []PriceWarning) []interface{} {
+	now := time.Now()
+	docs := make([]interface{}, 0, len(warnings))
+	for i, warning := range warnings {
+		item := items[i]
+		docs = append(docs, models.PriceOverride{
+			BaseModel:        models.BaseModel{ID: primitive.NewObjectID(), CreatedAt: now, UpdatedAt: now},
+			OrderID:          order.ID.Hex(),
+			OrderNumber:      order.OrderNumber,
+			ProductName:      item.ProductName,
+			Quantity:         item.Quantity,
+			ListPrice:        item.ListPrice,
+			UnitPrice:        item.UnitPrice,
+			DeviationPercent: warning.DeviationPercent,
+			Note:             item.PriceNote,
+			AdminID:          middleware.GetActorID(c),
+		})
+	}
+	return docs
+}
+
+// CheckPrices compares the items of an order being entered with their catalog prices, so
+// the form can warn about deviations and ask for price notes before submitting
+func (h *OrderHandler) CheckPrices(c *fiber.Ctx) error {
+	type CheckRequest struct {
+		Items []CreateItem `json:"items"`
+	}
+
+	var req CheckRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	names := []string{}
+	for _, item := range req.Items {
+		if item.ProductName != "" {
+			names = append(names, item.ProductName)
+		}
+	}
+	catalog, err := catalogProducts(ctx, names)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load catalog prices")
+	}
+	policy, err := loadPriceCheckPolicy(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load price check policy")
+	}
+
+	items := []fiber.Map{}
+	warnings := []PriceWarning{}
+	for i, item := range req.Items {
+		product, found := catalog[strings.ToLower(strings.TrimSpace(item.ProductName))]
+		if !found || product.Price <= 0 {
+			continue
+		}
+		items = append(items, fiber.Map{"index": i, "product_name": product.Name, "list_price": product.Price})
+
+		entered := models.OrderItem{
+			ProductName: item.ProductName,
+			UnitPrice:   item.UnitPrice,
+			ListPrice:   product.Price,
+			PriceNote:   strings.TrimSpace(item.PriceNote),
+		}
+		if warning, deviates := checkPrice(policy, i, entered); deviates {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"max_deviation_percent": policy.MaxDeviationPercent,
+		"catalog_prices":        items,
+		"price_warnings":        warnings,
+	})
+}
+
+// PriceOverrideSummary is one admin's price overrides over a period
+type PriceOverrideSummary struct {
+	AdminID             string    `json:"admin_id"`
+	Username            string    `json:"username"`
+	DisplayName         string    `json:"display_name"`
+	Overrides           int       `json:"overrides"`
+	Orders              int       `json:"orders"`
+	AvgDeviationPercent float64   `json:"avg_deviation_percent"` // Average of the absolute deviations
+	AmountDifference    float64   `json:"amount_difference"`     // Billed minus list price, over all overridden items
+	LastOverrideAt      time.Time `json:"last_override_at"`
+	orders              map[string]bool
+	deviationSum        float64
+}
+
+// PriceOverrides reports price overrides per admin over a date range, with the overrides
+// themselves, optionally of one admin
+func (h *ReportHandler) PriceOverrides(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	filter := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+	if adminID := c.Query("admin_id"); adminID != "" {
+		filter["admin_id"] = adminID
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection(priceOverrideCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch price overrides")
+	}
+	overrides := []models.PriceOverride{}
+	err = cursor.All(ctx, &overrides)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode price overrides")
+	}
+
+	admins := map[string]*PriceOverrideSummary{}
+	for _, override := range overrides {
+		admin := admins[override.AdminID]
+		if admin == nil {
+			admin = &PriceOverrideSummary{AdminID: override.AdminID, orders: map[string]bool{}}
+			admins[override.AdminID] = admin
+		}
+		admin.Overrides++
+		admin.orders[override.OrderID] = true
+		admin.deviationSum += math.Abs(override.DeviationPercent)
+		admin.AmountDifference += (override.UnitPrice - override.ListPrice) * float64(override.Quantity)
+		if override.CreatedAt.After(admin.LastOverrideAt) {
+			admin.LastOverrideAt = override.CreatedAt
+		}
+	}
+
+	objIDs := []primitive.ObjectID{}
+	for adminID := range admins {
+		if objID, err := primitive.ObjectIDFromHex(adminID); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	if len(objIDs) > 0 {
+		cursor, err := database.GetMongoCollection("users").Find(ctx, bson.M{"_id": bson.M{"$in": objIDs}},
+			options.Find().SetProjection(bson.M{"username": 1, "display_name": 1}))
+		if err != nil {
+			return response.Error(c, 500, "Failed to fetch override admins")
+		}
+		users := []models.User{}
+		err = cursor.All(ctx, &users)
+		cursor.Close(ctx)
+		if err != nil {
+			return response.Error(c, 500, "Failed to decode override admins")
+		}
+		for _, user := range users {
+			if admin := admins[user.ID.Hex()]; admin != nil {
+				admin.Username = user.Username
+				admin.DisplayName = user.DisplayName
+			}
+		}
+	}
+
+	rows := make([]*PriceOverrideSummary, 0, len(admins))
+	for _, admin := range admins {
+		admin.Orders = len(admin.orders)
+		admin.AvgDeviationPercent = math.Round(admin.deviationSum/float64(admin.Overrides)*10) / 10
+		rows = append(rows, admin)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Overrides != rows[j].Overrides {
+			return rows[i].Overrides > rows[j].Overrides
+		}
+		return rows[i].AdminID < rows[j].AdminID
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"from":      from,
+		"to":        to,
+		"admins":    rows,
+		"overrides": overrides,
+	})
+}
+
+// GetPriceCheckPolicy returns the price check policy
+func (h *SettingsHandler) GetPriceCheckPolicy(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	policy, err := loadPriceCheckPolicy(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load price check policy")
+	}
+	return response.Success(c, 200, policy)
+}
+
+// UpdatePriceCheckPolicy saves the price check policy
+func (h *SettingsHandler) UpdatePriceCheckPolicy(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		MaxDeviationPercent float64 `json:"max_deviation_percent"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.MaxDeviationPercent < 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "max_deviation_percent cannot be negative")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"max_deviation_percent": req.MaxDeviationPercent,
+			"updated_by":            middleware.GetUserID(c),
+			"updated_at":            now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection(priceCheckPolicyCollection)
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save price check policy")
+	}
+
+	audit.Log(c, audit.ActionPriceCheckPolicy, priceCheckPolicyCollection, "", map[string]interface{}{
+		"max_deviation_percent": req.MaxDeviationPercent,
+	})
+
+	policy, err := loadPriceCheckPolicy(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to load price check policy")
+	}
+	return response.Success(c, 200, policy)
+}
@@ -1,18 +1,18 @@
 package handlers
 
 import (
-	"context"
+	"strings"
 	"time"
 
 	"bg-go/internal/database"
 	"bg-go/internal/lib/file"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/uom"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ProductHandler handles product routes
@@ -25,13 +25,10 @@ func NewProductHandler() *ProductHandler {
 
 // List returns all products with pagination
 func (h *ProductHandler) List(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
+	pq := parsePage(c, 10, maxPageLimit)
 	search := c.Query("search")
 	activeOnly := c.QueryBool("active_only", false)
 
-	skip := (page - 1) * limit
-
 	filter := bson.M{}
 	if search != "" {
 		filter = bson.M{
@@ -46,14 +43,12 @@ func (h *ProductHandler) List(c *fiber.Ctx) error {
 	}
 
 	collection := database.GetMongoCollection("products")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
+	findOptions := pq.findOptions().
 		SetSort(bson.D{{Key: "created_at", Value: -1}})
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
@@ -64,8 +59,9 @@ func (h *ProductHandler) List(c *fiber.Ctx) error {
 
 	var products []models.Product
 	cursor.All(ctx, &products)
+	products, more := trimPage(pq, products)
 
-	return response.SuccessWithPagination(c, 200, products, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, products, pq.pagination(total, more))
 }
 
 // Detail returns a single product by ID
@@ -74,17 +70,17 @@ func (h *ProductHandler) Detail(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	collection := database.GetMongoCollection("products")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	product := &models.Product{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(product)
 	if err != nil {
-		return response.NotFound(c, "Product not found")
+		return response.NotFoundCode(c, response.CodeProductNotFound, "Product not found")
 	}
 
 	return response.Success(c, 200, product)
@@ -98,15 +94,32 @@ func (h *ProductHandler) Create(c *fiber.Ctx) error {
 		Price       float64 `json:"price"`
 		Unit        string  `json:"unit"`
 		Stock       int     `json:"stock"`
+		Category    string  `json:"category,omitempty"`
 	}
 
 	var req CreateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	if req.Name == "" || req.Price <= 0 {
-		return response.BadRequest(c, "Name and price are required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Name and price are required")
+	}
+
+	collection := database.GetMongoCollection("products")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if req.Unit != "" {
+		units, err := uom.Load(ctx)
+		if err != nil {
+			return unitsUnavailable(c)
+		}
+		unit, known := units.Lookup(req.Unit)
+		if !known {
+			return unknownUnit(c, units, 0, req.Unit)
+		}
+		req.Unit = unit.Code
 	}
 
 	product := models.NewProduct()
@@ -115,10 +128,7 @@ func (h *ProductHandler) Create(c *fiber.Ctx) error {
 	product.Price = req.Price
 	product.Unit = req.Unit
 	product.Stock = req.Stock
-
-	collection := database.GetMongoCollection("products")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	product.Category = strings.TrimSpace(req.Category)
 
 	_, err := collection.InsertOne(ctx, product)
 	if err != nil {
@@ -134,7 +144,7 @@ func (h *ProductHandler) Update(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	type UpdateRequest struct {
@@ -143,14 +153,19 @@ func (h *ProductHandler) Update(c *fiber.Ctx) error {
 		Price       float64 `json:"price,omitempty"`
 		Unit        string  `json:"unit,omitempty"`
 		Stock       *int    `json:"stock,omitempty"`
+		Category    *string `json:"category,omitempty"`
 		IsActive    *bool   `json:"is_active,omitempty"`
 	}
 
 	var req UpdateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
+	collection := database.GetMongoCollection("products")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
 	update := bson.M{"updated_at": time.Now()}
 	if req.Name != "" {
 		update["name"] = req.Name
@@ -162,25 +177,32 @@ func (h *ProductHandler) Update(c *fiber.Ctx) error {
 		update["price"] = req.Price
 	}
 	if req.Unit != "" {
-		update["unit"] = req.Unit
+		units, err := uom.Load(ctx)
+		if err != nil {
+			return unitsUnavailable(c)
+		}
+		unit, known := units.Lookup(req.Unit)
+		if !known {
+			return unknownUnit(c, units, 0, req.Unit)
+		}
+		update["unit"] = unit.Code
 	}
 	if req.Stock != nil {
 		update["stock"] = *req.Stock
 	}
+	if req.Category != nil {
+		update["category"] = strings.TrimSpace(*req.Category)
+	}
 	if req.IsActive != nil {
 		update["is_active"] = *req.IsActive
 	}
 
-	collection := database.GetMongoCollection("products")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
 	if err != nil {
 		return response.Error(c, 500, "Failed to update product")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Product not found")
+		return response.NotFoundCode(c, response.CodeProductNotFound, "Product not found")
 	}
 
 	return response.SuccessWithMessage(c, 200, "Successfully updated")
@@ -192,11 +214,11 @@ func (h *ProductHandler) Delete(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	collection := database.GetMongoCollection("products")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	result, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
@@ -204,7 +226,7 @@ func (h *ProductHandler) Delete(c *fiber.Ctx) error {
 		return response.Error(c, 500, "Failed to delete product")
 	}
 	if result.DeletedCount == 0 {
-		return response.NotFound(c, "Product not found")
+		return response.NotFoundCode(c, response.CodeProductNotFound, "Product not found")
 	}
 
 	return response.SuccessWithMessage(c, 200, "Successfully deleted")
@@ -216,27 +238,24 @@ func (h *ProductHandler) UploadImage(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	formFile, err := c.FormFile("image")
 	if err != nil {
-		return response.BadRequest(c, "No image provided")
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No image provided")
 	}
 
-	uploadResult, err := file.UploadFile(formFile)
+	uploadResult, err := file.UploadFile(formFile, file.CategoryProductImage)
 	if err != nil {
-		return response.Error(c, 500, "Failed to upload image")
+		return uploadFailed(c, err, "Failed to upload image")
 	}
 
 	collection := database.GetMongoCollection("products")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
-	image := &models.Image{
-		PublicID: uploadResult.PublicID,
-		URL:      uploadResult.URL,
-	}
+	image := uploadResult.Image()
 
 	update := bson.M{
 		"updated_at": time.Now(),
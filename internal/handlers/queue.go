@@ -8,12 +8,19 @@ import (
 	"time"
 
 	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/barcode"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/queuetime"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -34,11 +41,12 @@ func generateQueueToken() string {
 
 // List returns all orders in queue
 func (h *QueueHandler) List(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
-	status := c.Query("status") // queued, loading, completed
+	if code, err := applyView(c, models.ViewResourceQueue); err != nil {
+		return viewFailed(c, code, err)
+	}
 
-	skip := (page - 1) * limit
+	pq := parsePage(c, 10, maxQueuePageLimit)
+	status := c.Query("status") // queued, loading, completed
 
 	filter := bson.M{}
 	if status == "queued" {
@@ -51,17 +59,16 @@ func (h *QueueHandler) List(c *fiber.Ctx) error {
 		// Default: show queued and loading
 		filter["status"] = bson.M{"$in": []string{models.OrderStatusQueued, models.OrderStatusLoading}}
 	}
+	applyOrderFilters(c, filter)
 
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
-		SetSort(bson.D{{Key: "queue_number", Value: 1}})
+	findOptions := pq.findOptions().
+		SetSort(listSort(c, models.ViewResourceQueue, bson.D{{Key: "queue_number", Value: 1}}))
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
@@ -71,12 +78,14 @@ func (h *QueueHandler) List(c *fiber.Ctx) error {
 
 	var orders []models.Order
 	cursor.All(ctx, &orders)
+	orders, more := trimPage(pq, orders)
 
 	// Populate sales and product data
 	salesCollection := database.GetMongoCollection("sales")
 	productCollection := database.GetMongoCollection("products")
 
 	for i := range orders {
+		media.SignOrder(&orders[i])
 		if orders[i].SalesID != "" {
 			salesObjID, _ := primitive.ObjectIDFromHex(orders[i].SalesID)
 			sales := &models.Sales{}
@@ -91,7 +100,7 @@ func (h *QueueHandler) List(c *fiber.Ctx) error {
 		}
 	}
 
-	return response.SuccessWithPagination(c, 200, orders, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, orders, pq.pagination(total, more))
 }
 
 // Scan scans a barcode and creates queue entry
@@ -102,36 +111,66 @@ func (h *QueueHandler) Scan(c *fiber.Ctx) error {
 
 	var req ScanRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	if req.Barcode == "" {
-		return response.BadRequest(c, "Barcode is required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Barcode is required")
 	}
 
-	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
+	order, rejected := enterQueue(c, ctx, req.Barcode, time.Now())
+	if rejected != nil {
+		return rejected.send(c)
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"message":        "Queue entry created successfully",
+		"queue_number":   order.QueueNumber,
+		"estimated_time": order.EstimatedTime,
+		"order":          order,
+	})
+}
+
+// enterQueue puts the order with the given barcode into the queue of the day it was scanned.
+// On failure it returns the rejection to respond with.
+func enterQueue(c *fiber.Ctx, ctx context.Context, code string, scannedAt time.Time) (*models.Order, *rejection) {
+	collection := database.GetMongoCollection("orders")
+
 	// Find order by queue barcode
 	order := &models.Order{}
-	err := collection.FindOne(ctx, bson.M{"queue_barcode": req.Barcode}).Decode(order)
+	err := collection.FindOne(ctx, bson.M{"queue_barcode": barcode.Normalize(code)}).Decode(order)
+	if err == mongo.ErrNoDocuments {
+		return nil, &rejection{404, response.CodeOrderNotFound, "Invalid barcode or order not found"}
+	}
 	if err != nil {
-		return response.NotFound(c, "Invalid barcode or order not found")
+		return nil, &rejection{500, response.CodeInternal, "Failed to look up barcode"}
 	}
 
 	// Check if order is in confirmed status (driver data filled)
 	if order.Status != models.OrderStatusConfirmed {
-		return response.BadRequest(c, fmt.Sprintf("Order is not ready for queue. Current status: %s", order.Status))
+		return nil, &rejection{400, response.CodeOrderInvalidStatus, fmt.Sprintf("Order is not ready for queue. Current status: %s", order.Status)}
+	}
+
+	// A split order is loaded through its shipments
+	if order.ShipmentCount > 0 {
+		return nil, &rejection{400, response.CodeOrderSplit, "Order is split into shipments, scan a shipment instead"}
+	}
+
+	// Orders sharing a truck are queued through the order carrying them
+	if order.LoadedWith != "" {
+		return nil, &rejection{400, response.CodeOrderInvalidStatus, "Order is loaded with another order, scan that order's barcode instead"}
 	}
 
 	// Check if driver data is complete
 	if order.DriverName == "" || order.DriverPhone == "" || order.VehiclePlate == "" {
-		return response.BadRequest(c, "Driver data is incomplete")
+		return nil, &rejection{400, response.CodeValidationFailed, "Driver data is incomplete"}
 	}
 
-	// Get current max queue number for today
-	today := time.Now().Format("2006-01-02")
+	// Get current max queue number for the scan day
+	today := scannedAt.Format("2006-01-02")
 	todayStart, _ := time.Parse("2006-01-02", today)
 	todayEnd := todayStart.Add(24 * time.Hour)
 
@@ -141,11 +180,18 @@ func (h *QueueHandler) Scan(c *fiber.Ctx) error {
 			"$lt":  todayEnd,
 		},
 	}
-	
+
 	maxQueue := 0
-	queueCursor, _ := collection.Find(ctx, queueFilter)
+	queueCursor, err := collection.Find(ctx, queueFilter, options.Find().SetProjection(bson.M{"queue_number": 1}))
+	if err != nil {
+		return nil, &rejection{500, response.CodeInternal, "Failed to read today's queue"}
+	}
 	var todayOrders []models.Order
-	queueCursor.All(ctx, &todayOrders)
+	err = queueCursor.All(ctx, &todayOrders)
+	queueCursor.Close(ctx)
+	if err != nil {
+		return nil, &rejection{500, response.CodeInternal, "Failed to read today's queue"}
+	}
 	for _, o := range todayOrders {
 		if o.QueueNumber > maxQueue {
 			maxQueue = o.QueueNumber
@@ -157,41 +203,48 @@ func (h *QueueHandler) Scan(c *fiber.Ctx) error {
 	queueToken := generateQueueToken()
 	now := time.Now()
 
-	// Calculate estimated time (30 min per queue ahead)
-	ordersAhead := queueNumber - 1
-	estimatedMinutes := ordersAhead * models.QueueDurationMinutes
+	// Calculate estimated time from the loading durations of the active queue ahead
+	ahead, _ := queuetime.Ahead(ctx, scannedAt, 0)
+	estimatedMinutes := queuetime.Load(ctx).Total(ahead)
 	estimatedTime := now.Add(time.Duration(estimatedMinutes) * time.Minute)
 
 	update := bson.M{
 		"queue_number":     queueNumber,
 		"queue_token":      queueToken,
 		"queue_barcode":    "", // Clear barcode after scanning
-		"queue_entered_at": now,
+		"queue_entered_at": scannedAt,
 		"estimated_time":   estimatedTime.Format("15:04"),
 		"status":           models.OrderStatusQueued,
 		"updated_at":       now,
 	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusQueued, middleware.GetUserID(c))}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": update})
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": update, "$push": statusChange})
+	if _, duplicate := database.DuplicateKeyIndex(err); duplicate {
+		return nil, &rejection{409, response.CodeConflict, "Generated queue token already exists, please retry"}
+	}
 	if err != nil {
-		return response.Error(c, 500, "Failed to create queue entry")
+		return nil, &rejection{500, response.CodeInternal, "Failed to create queue entry"}
 	}
 
+	go notification.NotifyStatusChange(order.ID, models.OrderStatusQueued)
+
+	audit.Log(c, audit.ActionQueueScan, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"queue_number": queueNumber,
+		"device_id":    middleware.GetDeviceID(c),
+	})
+
 	// Get updated order
 	collection.FindOne(ctx, bson.M{"_id": order.ID}).Decode(order)
 
-	return response.Success(c, 200, fiber.Map{
-		"message":        "Queue entry created successfully",
-		"queue_number":   queueNumber,
-		"estimated_time": estimatedTime.Format("15:04"),
-		"order":          order,
-	})
+	return order, nil
 }
 
 // GetEstimate returns queue estimation for an order
 func (h *QueueHandler) GetEstimate(c *fiber.Ctx) error {
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	// Get current loading order
@@ -202,18 +255,18 @@ func (h *QueueHandler) GetEstimate(c *fiber.Ctx) error {
 		options.FindOne().SetSort(bson.D{{Key: "queue_number", Value: 1}}),
 	).Decode(loadingOrder)
 
-	// Count orders in queue ahead
-	queueCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusQueued})
-
-	// Calculate current estimated wait
-	estimatedWait := 0
-	if err == nil {
-		// Someone is loading, add their remaining time + queue ahead
-		estimatedWait = int(queueCount) * models.QueueDurationMinutes
-	} else {
-		// No one loading, just queue ahead
-		estimatedWait = int(queueCount) * models.QueueDurationMinutes
+	// Load the orders waiting in queue
+	active, _ := queuetime.Ahead(ctx, time.Now(), 0)
+	queued := []models.Order{}
+	for _, order := range active {
+		if order.Status == models.OrderStatusQueued {
+			queued = append(queued, order)
+		}
 	}
+	queueCount := len(queued)
+
+	// Calculate current estimated wait from the loading durations of the queue
+	estimatedWait := queuetime.Load(ctx).Total(queued)
 
 	return response.Success(c, 200, fiber.Map{
 		"current_loading": loadingOrder,
@@ -226,7 +279,7 @@ func (h *QueueHandler) GetEstimate(c *fiber.Ctx) error {
 // GetCurrent returns currently loading order
 func (h *QueueHandler) GetCurrent(c *fiber.Ctx) error {
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	order := &models.Order{}
@@ -267,14 +320,25 @@ func (h *QueueHandler) GetCurrent(c *fiber.Ctx) error {
 
 // CallNext calls the next order in queue
 func (h *QueueHandler) CallNext(c *fiber.Ctx) error {
+	type CallRequest struct {
+		Bay string `json:"bay"`
+	}
+
+	var req CallRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+		}
+	}
+
 	collection := database.GetMongoCollection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
 	// Check if there's already an order loading
 	loadingCount, _ := collection.CountDocuments(ctx, bson.M{"status": models.OrderStatusLoading})
 	if loadingCount > 0 {
-		return response.BadRequest(c, "There is already an order being loaded")
+		return response.ErrorCode(c, 400, response.CodeQueueBusy, "There is already an order being loaded")
 	}
 
 	// Get next order in queue
@@ -286,7 +350,7 @@ func (h *QueueHandler) CallNext(c *fiber.Ctx) error {
 	).Decode(order)
 
 	if err != nil {
-		return response.NotFound(c, "No orders in queue")
+		return response.NotFoundCode(c, response.CodeQueueEmpty, "No orders in queue")
 	}
 
 	now := time.Now()
@@ -296,12 +360,26 @@ func (h *QueueHandler) CallNext(c *fiber.Ctx) error {
 		"queue_called_at":    now,
 		"updated_at":         now,
 	}
+	if req.Bay != "" {
+		update["loading_bay"] = req.Bay
+	}
+	statusChange := bson.M{"status_history": models.NewStatusChange(models.OrderStatusLoading, middleware.GetUserID(c))}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": update})
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": update, "$push": statusChange})
 	if err != nil {
 		return response.Error(c, 500, "Failed to call next order")
 	}
 
+	// Message the driver (and anyone else configured) that their turn is called
+	links := notification.NotifyStatusChange(order.ID, models.OrderStatusLoading)
+
+	audit.Log(c, audit.ActionQueueCallNext, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number": order.OrderNumber,
+		"queue_number": order.QueueNumber,
+		"bay":          req.Bay,
+		"device_id":    middleware.GetDeviceID(c),
+	})
+
 	// Get updated order
 	collection.FindOne(ctx, bson.M{"_id": order.ID}).Decode(order)
 
@@ -322,7 +400,8 @@ func (h *QueueHandler) CallNext(c *fiber.Ctx) error {
 	}
 
 	return response.Success(c, 200, fiber.Map{
-		"message": "Next order called",
-		"order":   order,
+		"message":              "Next order called",
+		"order":                order,
+		"driver_whatsapp_link": links[models.RecipientDriver],
 	})
 }
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/barcode"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxBatchScans limits how many scans a kiosk may sync in one request
+const maxBatchScans = 100
+
+// ScanBatch processes scans collected by a kiosk while it was offline.
+// Every scan carries a client-generated ID; resubmitting a scan returns its original result.
+// Scans are processed in the order they were taken so queue numbers follow arrival at the gate.
+func (h *QueueHandler) ScanBatch(c *fiber.Ctx) error {
+	type ScanItem struct {
+		ClientID  string    `json:"client_id"`
+		Barcode   string    `json:"barcode"`
+		ScannedAt time.Time `json:"scanned_at"`
+	}
+	type BatchRequest struct {
+		Scans []ScanItem `json:"scans"`
+	}
+	type ScanResult struct {
+		ClientID    string `json:"client_id"`
+		Result      string `json:"result"`
+		Message     string `json:"message,omitempty"`
+		Duplicate   bool   `json:"duplicate"`
+		Retry       bool   `json:"retry"`
+		OrderID     string `json:"order_id,omitempty"`
+		QueueNumber int    `json:"queue_number,omitempty"`
+	}
+
+	var req BatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if len(req.Scans) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Scans are required")
+	}
+	if len(req.Scans) > maxBatchScans {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, fmt.Sprintf("At most %d scans per batch", maxBatchScans))
+	}
+
+	sort.SliceStable(req.Scans, func(i, j int) bool {
+		return req.Scans[i].ScannedAt.Before(req.Scans[j].ScannedAt)
+	})
+
+	collection := database.GetMongoCollection("queue_scans")
+	ctx, cancel := requestContext(c, bulkTimeout)
+	defer cancel()
+
+	deviceID := middleware.GetDeviceID(c)
+	results := []ScanResult{}
+	counts := map[string]int{}
+
+	for _, item := range req.Scans {
+		if item.ClientID == "" || item.Barcode == "" {
+			results = append(results, ScanResult{
+				ClientID: item.ClientID,
+				Result:   models.ScanResultRejected,
+				Message:  "Client ID and barcode are required",
+			})
+			counts[models.ScanResultRejected]++
+			continue
+		}
+
+		now := time.Now()
+		scannedAt := item.ScannedAt
+		if scannedAt.IsZero() || scannedAt.After(now) {
+			scannedAt = now
+		}
+
+		// Claim the client ID; a duplicate key means this scan was already submitted
+		scan := &models.QueueScan{
+			ClientID:   item.ClientID,
+			DeviceID:   deviceID,
+			Barcode:    barcode.Normalize(item.Barcode),
+			ScannedAt:  scannedAt,
+			ReceivedAt: now,
+			Result:     models.ScanResultProcessing,
+		}
+		if _, err := collection.InsertOne(ctx, scan); err != nil {
+			if !mongo.IsDuplicateKeyError(err) {
+				results = append(results, ScanResult{ClientID: item.ClientID, Result: "error", Message: "Failed to record scan", Retry: true})
+				continue
+			}
+
+			existing := &models.QueueScan{}
+			collection.FindOne(ctx, bson.M{"_id": item.ClientID}).Decode(existing)
+			results = append(results, ScanResult{
+				ClientID:    item.ClientID,
+				Result:      existing.Result,
+				Message:     existing.Message,
+				Duplicate:   true,
+				Retry:       existing.Result == models.ScanResultProcessing,
+				OrderID:     existing.OrderID,
+				QueueNumber: existing.QueueNumber,
+			})
+			continue
+		}
+
+		order, rejected := enterQueue(c, ctx, item.Barcode, scannedAt)
+		switch {
+		case rejected == nil:
+			scan.Result = models.ScanResultQueued
+			scan.OrderID = order.ID.Hex()
+			scan.QueueNumber = order.QueueNumber
+		case rejected.Status == 404:
+			// The barcode is cleared once scanned, so check whether another scan used it
+			previous := &models.QueueScan{}
+			prevFilter := bson.M{"barcode": scan.Barcode, "result": models.ScanResultQueued}
+			if collection.FindOne(ctx, prevFilter).Decode(previous) == nil {
+				scan.Result = models.ScanResultConflict
+				scan.Message = fmt.Sprintf("Barcode already scanned at %s as queue #%d", previous.ScannedAt.Format("15:04"), previous.QueueNumber)
+				scan.OrderID = previous.OrderID
+			} else {
+				scan.Result = models.ScanResultRejected
+				scan.Message = rejected.Message
+			}
+		case rejected.Status == 400:
+			scan.Result = models.ScanResultConflict
+			scan.Message = rejected.Message
+		default:
+			// Release the claim so the kiosk can retry this scan
+			collection.DeleteOne(ctx, bson.M{"_id": item.ClientID})
+			results = append(results, ScanResult{ClientID: item.ClientID, Result: "error", Message: rejected.Message, Retry: true})
+			continue
+		}
+
+		collection.UpdateOne(ctx, bson.M{"_id": item.ClientID}, bson.M{"$set": bson.M{
+			"result":       scan.Result,
+			"message":      scan.Message,
+			"order_id":     scan.OrderID,
+			"queue_number": scan.QueueNumber,
+		}})
+
+		counts[scan.Result]++
+		results = append(results, ScanResult{
+			ClientID:    scan.ClientID,
+			Result:      scan.Result,
+			Message:     scan.Message,
+			OrderID:     scan.OrderID,
+			QueueNumber: scan.QueueNumber,
+		})
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"processed": len(results),
+		"queued":    counts[models.ScanResultQueued],
+		"conflicts": counts[models.ScanResultConflict],
+		"rejected":  counts[models.ScanResultRejected],
+		"results":   results,
+	})
+}
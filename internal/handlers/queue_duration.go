@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/queuetime"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxQueueMinutes bounds a single loading duration to one working day
+const maxQueueMinutes = 12 * 60
+
+// GetQueueDurations returns the loading durations used for queue ETAs, with the product
+// categories in use so every category can be given a duration
+func (h *SettingsHandler) GetQueueDurations(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	categories, _ := database.GetMongoCollection("products").Distinct(ctx, "category", bson.M{"category": bson.M{"$nin": bson.A{nil, ""}}})
+
+	return response.Success(c, 200, fiber.Map{
+		"settings":           queuetime.LoadSettings(ctx),
+		"product_categories": categories,
+	})
+}
+
+// UpdateQueueDurations saves the loading durations. A default of 0 falls back to the
+// global duration of models.QueueDurationMinutes.
+func (h *SettingsHandler) UpdateQueueDurations(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		DefaultMinutes int                       `json:"default_minutes"`
+		Categories     []models.CategoryDuration `json:"categories"`
+		Brackets       []models.QuantityBracket  `json:"brackets"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.DefaultMinutes == 0 {
+		req.DefaultMinutes = models.QueueDurationMinutes
+	}
+	if req.DefaultMinutes < 0 || req.DefaultMinutes > maxQueueMinutes {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "default_minutes must be between 1 and 720")
+	}
+
+	seen := map[string]bool{}
+	categories := []models.CategoryDuration{}
+	for _, category := range req.Categories {
+		category.Category = strings.Join(strings.Fields(category.Category), " ")
+		key := queuetime.NormalizeCategory(category.Category)
+		if key == "" || category.Minutes <= 0 || category.Minutes > maxQueueMinutes {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Categories need a name and minutes between 1 and 720")
+		}
+		if seen[key] {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Duplicate category: "+category.Category)
+		}
+		seen[key] = true
+		categories = append(categories, category)
+	}
+
+	if req.Brackets == nil {
+		req.Brackets = []models.QuantityBracket{}
+	}
+	sort.Slice(req.Brackets, func(i, j int) bool { return req.Brackets[i].MinQuantity < req.Brackets[j].MinQuantity })
+	for i, bracket := range req.Brackets {
+		if bracket.MinQuantity <= 0 || bracket.Minutes <= 0 || bracket.Minutes > maxQueueMinutes ||
+			(i > 0 && bracket.MinQuantity == req.Brackets[i-1].MinQuantity) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Brackets need distinct positive min_quantity and minutes between 1 and 720")
+		}
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"default_minutes": req.DefaultMinutes,
+			"categories":      categories,
+			"brackets":        req.Brackets,
+			"updated_by":      middleware.GetUserID(c),
+			"updated_at":      now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection(queuetime.Collection)
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save queue durations")
+	}
+
+	audit.Log(c, audit.ActionQueueDurations, queuetime.Collection, "", map[string]interface{}{
+		"default_minutes": req.DefaultMinutes,
+		"categories":      len(categories),
+		"brackets":        len(req.Brackets),
+	})
+
+	return response.Success(c, 200, queuetime.LoadSettings(ctx))
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/queueday"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// History lists the stored queue day snapshots between from and to (YYYY-MM-DD,
+// default the last 30 days), without the per-order entries
+func (h *QueueHandler) History(c *fiber.Ctx) error {
+	to := c.Query("to", time.Now().Format("2006-01-02"))
+	from := c.Query("from", time.Now().AddDate(0, 0, -30).Format("2006-01-02"))
+	if _, err := queueday.DayStart(from); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid from date, use YYYY-MM-DD")
+	}
+	if _, err := queueday.DayStart(to); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid to date, use YYYY-MM-DD")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	findOptions := options.Find().
+		SetProjection(bson.M{"entries": 0}).
+		SetSort(bson.D{{Key: "_id", Value: -1}})
+
+	cursor, err := database.GetMongoCollection(queueday.Collection).Find(ctx, bson.M{"_id": bson.M{"$gte": from, "$lte": to}}, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch queue history")
+	}
+	defer cursor.Close(ctx)
+
+	days := []models.QueueDay{}
+	if err := cursor.All(ctx, &days); err != nil {
+		return response.Error(c, 500, "Failed to decode queue history")
+	}
+
+	return response.Success(c, 200, days)
+}
+
+// HistoryDay returns the snapshot of one queue day. Days without a snapshot (today, or
+// days the job has not reached yet) are replayed from the orders and marked as live.
+// Pass refresh=true to rebuild and store the snapshot of a day that has ended.
+func (h *QueueHandler) HistoryDay(c *fiber.Ctx) error {
+	date := c.Params("date")
+	if _, err := queueday.DayStart(date); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid date, use YYYY-MM-DD")
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	if c.QueryBool("refresh", false) {
+		if date >= time.Now().Format("2006-01-02") {
+			return response.BadRequest(c, "Only past days can be snapshotted")
+		}
+		day, err := queueday.Snapshot(ctx, date)
+		if err != nil {
+			return response.Error(c, 500, "Failed to snapshot queue day")
+		}
+		return response.Success(c, 200, fiber.Map{"live": false, "day": day})
+	}
+
+	day := &models.QueueDay{}
+	err := database.GetMongoCollection(queueday.Collection).FindOne(ctx, bson.M{"_id": date}).Decode(day)
+	if err == nil {
+		return response.Success(c, 200, fiber.Map{"live": false, "day": day})
+	}
+
+	day, err = queueday.Build(ctx, date)
+	if err != nil {
+		return response.Error(c, 500, "Failed to replay queue day")
+	}
+	return response.Success(c, 200, fiber.Map{"live": true, "day": day})
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/barcode"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeviceLookupOrder is the order a kiosk device is shown on lookup: what the yard needs,
+// without tokens that would open the client pages of the order
+type DeviceLookupOrder struct {
+	ID                 primitive.ObjectID `json:"id"`
+	OrderNumber        string             `json:"order_number"`
+	Status             string             `json:"status"`
+	PaymentStatus      string             `json:"payment_status"`
+	CustomerName       string             `json:"customer_name,omitempty"`
+	SalesName          string             `json:"sales_name,omitempty"`
+	Items              []models.OrderItem `json:"items"`
+	Quantity           int                `json:"quantity"`
+	Tags               []string           `json:"tags,omitempty"`
+	TagHints           []models.TagHint   `json:"tag_hints,omitempty"`
+	WalkIn             bool               `json:"walk_in,omitempty"`
+	QueueNumber        int                `json:"queue_number,omitempty"`
+	QueueEnteredAt     *time.Time         `json:"queue_entered_at,omitempty"`
+	EstimatedTime      string             `json:"estimated_time,omitempty"`
+	LoadingBay         string             `json:"loading_bay,omitempty"`
+	DeliveryNoteNumber string             `json:"delivery_note_number,omitempty"`
+}
+
+// deviceLookupOrder returns the device view of a populated order
+func deviceLookupOrder(order *models.Order) *DeviceLookupOrder {
+	view := &DeviceLookupOrder{
+		ID:                 order.ID,
+		OrderNumber:        order.OrderNumber,
+		Status:             order.Status,
+		PaymentStatus:      order.PaymentStatus,
+		CustomerName:       order.CustomerName,
+		Items:              order.Items,
+		Quantity:           order.Quantity,
+		Tags:               order.Tags,
+		TagHints:           order.TagHints,
+		WalkIn:             order.WalkIn,
+		QueueNumber:        order.QueueNumber,
+		QueueEnteredAt:     order.QueueEnteredAt,
+		EstimatedTime:      order.EstimatedTime,
+		LoadingBay:         order.LoadingBay,
+		DeliveryNoteNumber: order.DeliveryNoteNumber,
+	}
+	if order.Sales != nil {
+		view.SalesName = order.Sales.Name
+	}
+	return view
+}
+
+// Lookup returns the populated order of a scanned code without changing its state, so
+// supervisors can inspect a truck in the yard. The code may be the queue barcode, a
+// client link token (or the whole link) or the order number. Kiosk devices get a
+// DeviceLookupOrder instead of the full order.
+func (h *QueueHandler) Lookup(c *fiber.Ctx) error {
+	code := strings.TrimSpace(c.Query("barcode"))
+	if code == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Barcode is required")
+	}
+	// QR codes of client links carry the whole URL; the token is its last segment
+	if i := strings.LastIndex(code, "/"); i >= 0 && i < len(code)-1 {
+		code = code[i+1:]
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{
+		{"queue_barcode": barcode.Normalize(code)},
+		{"queue_token": code},
+		{"invoice_token": code},
+		{"order_number": strings.ToUpper(code)},
+	}}
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, filter).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Invalid barcode or order not found")
+	}
+
+	if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+		sales := &models.Sales{}
+		if database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales) == nil {
+			order.Sales = sales
+		}
+	}
+	order.TagHints = tagHints(order.Tags, tagColors(ctx))
+	media.SignOrder(order)
+	for j := range order.Items {
+		order.Items[j].Product = &models.Product{
+			Name:  order.Items[j].ProductName,
+			Price: order.Items[j].UnitPrice,
+			Unit:  order.Items[j].Unit,
+		}
+	}
+
+	// Orders ahead in the same day's queue, for trucks still waiting
+	ahead := int64(0)
+	if order.Status == models.OrderStatusQueued && order.QueueEnteredAt != nil {
+		dayStart, _ := time.Parse("2006-01-02", order.QueueEnteredAt.Format("2006-01-02"))
+		ahead, _ = collection.CountDocuments(ctx, bson.M{
+			"status":           models.OrderStatusQueued,
+			"queue_entered_at": bson.M{"$gte": dayStart, "$lt": dayStart.Add(24 * time.Hour)},
+			"queue_number":     bson.M{"$lt": order.QueueNumber},
+		})
+	}
+
+	// Kiosk devices get the order without its invoice and queue tokens
+	var view interface{} = order
+	if middleware.GetUserRole(c) == models.RoleDevice {
+		view = deviceLookupOrder(order)
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"order": view,
+		"driver": fiber.Map{
+			"name":          order.DriverName,
+			"phone":         order.DriverPhone,
+			"vehicle_plate": order.VehiclePlate,
+			"vehicle_photo": order.VehiclePhoto,
+			"filled_at":     order.DriverFilledAt,
+		},
+		"items":         order.Items,
+		"queue_ahead":   ahead,
+		"loading_bay":   order.LoadingBay,
+		"delivery_note": order.DeliveryNoteNumber,
+	})
+}
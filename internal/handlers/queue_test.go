@@ -0,0 +1,1510 @@
+package handlers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/device"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/polling"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+	"bg-go/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// seedDevice stores a kiosk device and returns its token
+func seedDevice(h *testutil.Harness, active bool) string {
+	token, hash := device.GenerateToken()
+	dev := models.NewDevice()
+	dev.Name = "Gate 1"
+	dev.TokenHash = hash
+	dev.TokenHint = device.TokenHint(token)
+	dev.Active = active
+	h.Insert("devices", dev)
+	return token
+}
+
+// readyForQueue makes an order verified with its driver data filled in
+func readyForQueue(barcode string) func(order *models.Order) {
+	return func(order *models.Order) {
+		withProof(order)
+		order.Status = models.OrderStatusConfirmed
+		order.PaymentStatus = models.PaymentStatusVerified
+		order.DriverName = "Joko"
+		order.DriverPhone = "6289876543210"
+		order.VehiclePlate = "B 1234 XYZ"
+		order.QueueBarcode = barcode
+	}
+}
+
+func TestQueueFlow(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	order := seedOrder(h, sales, func(o *models.Order) {
+		withProof(o)
+		o.Status = models.OrderStatusConfirmed
+		o.PaymentStatus = models.PaymentStatusVerified
+	})
+	admin := h.Token(models.RoleAdmin)
+	kiosk := seedDevice(h, true)
+
+	// The sales rep fills in the driver on the client page and gets a queue barcode
+	resp := h.Request("POST", "/api/v1/client/driver/"+order.InvoiceToken, map[string]interface{}{
+		"driver_name":   "Joko",
+		"driver_phone":  "6289876543210",
+		"vehicle_plate": "B 1234 XYZ",
+	}, "")
+	if resp.Status != 200 {
+		t.Fatalf("submit driver: status = %d: %s", resp.Status, resp.Raw)
+	}
+	barcode, _ := resp.Data()["queue_barcode"].(string)
+	if barcode == "" {
+		t.Fatalf("submit driver: no queue barcode: %s", resp.Raw)
+	}
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		body       interface{}
+		token      string
+		wantStatus string
+	}{
+		{"the kiosk scans the barcode", "POST", "/api/v1/queue/scan", map[string]interface{}{"barcode": barcode}, kiosk, models.OrderStatusQueued},
+		{"the kiosk calls the next order", "POST", "/api/v1/queue/call-next", map[string]interface{}{"bay": "B2"}, kiosk, models.OrderStatusLoading},
+		{"an admin finishes loading", "POST", "/api/v1/orders/" + order.ID.Hex() + "/finish-loading", nil, admin, models.OrderStatusCompleted},
+	}
+	for _, step := range steps {
+		resp := h.Request(step.method, step.path, step.body, step.token)
+		if resp.Status != 200 || resp.ErrorCode() != "" {
+			t.Fatalf("%s: status = %d: %s", step.name, resp.Status, resp.Raw)
+		}
+
+		stored := &models.Order{}
+		h.Find("orders", bson.M{"_id": order.ID}, stored)
+		if stored.Status != step.wantStatus {
+			t.Fatalf("%s: status = %s, want %s", step.name, stored.Status, step.wantStatus)
+		}
+	}
+
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.QueueNumber != 1 || stored.LoadingBay != "B2" {
+		t.Fatalf("queue number %d at bay %q, want 1 at B2", stored.QueueNumber, stored.LoadingBay)
+	}
+	if stored.QueueBarcode != "" {
+		t.Fatal("queue barcode was not cleared after the scan")
+	}
+
+	note := &models.DeliveryNote{}
+	h.Find("delivery_notes", bson.M{"order_id": order.ID.Hex()}, note)
+	if note.DriverName != "Joko" || note.VehiclePlate != "B 1234 XYZ" || stored.DeliveryNoteID != note.ID.Hex() {
+		t.Fatalf("delivery note %+v does not match the order", note)
+	}
+
+	// The queue is empty once the order finished loading
+	resp = h.Request("POST", "/api/v1/queue/call-next", nil, kiosk)
+	if resp.ErrorCode() != response.CodeQueueEmpty {
+		t.Fatalf("call next on an empty queue: got %d %q: %s", resp.Status, resp.ErrorCode(), resp.Raw)
+	}
+}
+
+func TestScanQueue(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(order *models.Order)
+		barcode    string
+		token      func(h *testutil.Harness) string
+		status     int
+		code       response.Code
+		wantStatus string
+	}{
+		{
+			name:       "queues a ready order",
+			edit:       readyForQueue("qb-1"),
+			barcode:    "qb-1",
+			token:      func(h *testutil.Harness) string { return seedDevice(h, true) },
+			status:     200,
+			wantStatus: models.OrderStatusQueued,
+		},
+		{
+			name:       "accepts an admin",
+			edit:       readyForQueue("qb-1"),
+			barcode:    "qb-1",
+			token:      func(h *testutil.Harness) string { return h.Token(models.RoleAdmin) },
+			status:     200,
+			wantStatus: models.OrderStatusQueued,
+		},
+		{
+			name:       "refuses a revoked device",
+			edit:       readyForQueue("qb-1"),
+			barcode:    "qb-1",
+			token:      func(h *testutil.Harness) string { return seedDevice(h, false) },
+			status:     401,
+			code:       response.CodeDeviceTokenInvalid,
+			wantStatus: models.OrderStatusConfirmed,
+		},
+		{
+			name:       "refuses a user",
+			edit:       readyForQueue("qb-1"),
+			barcode:    "qb-1",
+			token:      func(h *testutil.Harness) string { return h.Token(models.RoleUser) },
+			status:     403,
+			code:       response.CodeInsufficientPermissions,
+			wantStatus: models.OrderStatusConfirmed,
+		},
+		{
+			name:       "requires a barcode",
+			edit:       readyForQueue("qb-1"),
+			token:      func(h *testutil.Harness) string { return seedDevice(h, true) },
+			status:     400,
+			code:       response.CodeValidationFailed,
+			wantStatus: models.OrderStatusConfirmed,
+		},
+		{
+			name:       "rejects an unknown barcode",
+			edit:       readyForQueue("qb-1"),
+			barcode:    "qb-2",
+			token:      func(h *testutil.Harness) string { return seedDevice(h, true) },
+			status:     404,
+			code:       response.CodeOrderNotFound,
+			wantStatus: models.OrderStatusConfirmed,
+		},
+		{
+			name: "rejects an unconfirmed order",
+			edit: func(o *models.Order) {
+				readyForQueue("qb-1")(o)
+				o.Status = models.OrderStatusPaid
+			},
+			barcode:    "qb-1",
+			token:      func(h *testutil.Harness) string { return seedDevice(h, true) },
+			status:     400,
+			code:       response.CodeOrderInvalidStatus,
+			wantStatus: models.OrderStatusPaid,
+		},
+		{
+			name: "rejects missing driver data",
+			edit: func(o *models.Order) {
+				readyForQueue("qb-1")(o)
+				o.VehiclePlate = ""
+			},
+			barcode:    "qb-1",
+			token:      func(h *testutil.Harness) string { return seedDevice(h, true) },
+			status:     400,
+			code:       response.CodeValidationFailed,
+			wantStatus: models.OrderStatusConfirmed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testutil.New(t)
+			order := seedOrder(h, seedSales(h), tt.edit)
+
+			resp := h.Request("POST", "/api/v1/queue/scan", map[string]interface{}{"barcode": tt.barcode}, tt.token(h))
+			if resp.Status != tt.status || resp.ErrorCode() != tt.code {
+				t.Fatalf("got %d %q, want %d %q: %s", resp.Status, resp.ErrorCode(), tt.status, tt.code, resp.Raw)
+			}
+
+			stored := &models.Order{}
+			h.Find("orders", bson.M{"_id": order.ID}, stored)
+			if stored.Status != tt.wantStatus {
+				t.Fatalf("order status = %s, want %s", stored.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestQueueNumbers(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	kiosk := seedDevice(h, true)
+
+	barcodes := []string{"qb-1", "qb-2", "qb-3"}
+	orders := make([]*models.Order, len(barcodes))
+	for i, barcode := range barcodes {
+		orders[i] = seedOrder(h, sales, readyForQueue(barcode))
+		resp := h.Request("POST", "/api/v1/queue/scan", map[string]interface{}{"barcode": barcode}, kiosk)
+		if resp.Status != 200 {
+			t.Fatalf("scan %s: status = %d: %s", barcode, resp.Status, resp.Raw)
+		}
+	}
+
+	// Orders are called in queue order, one loading at a time
+	for i, order := range orders {
+		resp := h.Request("POST", "/api/v1/queue/call-next", nil, kiosk)
+		if resp.Status != 200 {
+			t.Fatalf("call %d: status = %d: %s", i+1, resp.Status, resp.Raw)
+		}
+		called, _ := resp.Data()["order"].(map[string]interface{})
+		if called["id"] != order.ID.Hex() {
+			t.Fatalf("call %d: called %v, want %s", i+1, called["id"], order.ID.Hex())
+		}
+		if number, _ := called["queue_number"].(float64); int(number) != i+1 {
+			t.Fatalf("call %d: queue number %v, want %d", i+1, called["queue_number"], i+1)
+		}
+
+		resp = h.Request("POST", "/api/v1/queue/call-next", nil, kiosk)
+		if resp.ErrorCode() != response.CodeQueueBusy {
+			t.Fatalf("call while loading: got %d %q, want %q", resp.Status, resp.ErrorCode(), response.CodeQueueBusy)
+		}
+
+		resp = h.Request("POST", "/api/v1/orders/"+order.ID.Hex()+"/finish-loading", nil, h.Token(models.RoleAdmin))
+		if resp.Status != 200 {
+			t.Fatalf("finish %d: status = %d: %s", i+1, resp.Status, resp.Raw)
+		}
+	}
+
+	// The driver of each called order is messaged that their turn came
+	h.Eventually(func() bool { return len(h.WhatsApp.MessagesTo("6289876543210")) >= len(orders) },
+		"drivers were messaged %d times, want at least %d", len(h.WhatsApp.MessagesTo("6289876543210")), len(orders))
+
+	if n := h.Count("delivery_notes", bson.M{"created_at": bson.M{"$lte": time.Now()}}); n != len(orders) {
+		t.Fatalf("%d delivery notes, want %d", n, len(orders))
+	}
+}
+
+func TestDriverCode(t *testing.T) {
+	h := testutil.New(t)
+	config.Cfg.Client.DriverOTP = true
+	t.Cleanup(func() { config.Cfg.Client.DriverOTP = false })
+
+	sales := seedSales(h)
+	order := seedOrder(h, sales, func(o *models.Order) {
+		withProof(o)
+		o.Status = models.OrderStatusConfirmed
+		o.PaymentStatus = models.PaymentStatusVerified
+	})
+	driver := map[string]interface{}{
+		"driver_name":   "Joko",
+		"driver_phone":  "6289876543210",
+		"vehicle_plate": "B 1234 XYZ",
+	}
+
+	resp := h.Request("POST", "/api/v1/client/driver/"+order.InvoiceToken, driver, "")
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeOTPRequired {
+		t.Fatalf("submit without a code: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	if resp = h.Request("POST", "/api/v1/client/driver/"+order.InvoiceToken+"/code", nil, ""); resp.Status != 200 {
+		t.Fatalf("request code: status = %d: %s", resp.Status, resp.Raw)
+	}
+	messages := h.WhatsApp.MessagesTo(sales.Phone)
+	if len(messages) != 1 {
+		t.Fatalf("want one code message to the sales rep, got %d", len(messages))
+	}
+
+	driver["code"] = regexp.MustCompile(`\d{6}`).FindString(messages[0].Text)
+	resp = h.Request("POST", "/api/v1/client/driver/"+order.InvoiceToken, driver, "")
+	if resp.Status != 200 || resp.Data()["queue_barcode"] == "" {
+		t.Fatalf("submit with the code: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+// exifJPEG returns a 2x1 JPEG whose EXIF data says it must be turned 90 degrees clockwise
+// and was taken at capturedAt
+func exifJPEG(t *testing.T, capturedAt string) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 1)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+
+	le := binary.LittleEndian
+	var tiff bytes.Buffer
+	tiff.WriteString("II")
+	binary.Write(&tiff, le, []uint16{42})
+	binary.Write(&tiff, le, []uint32{8})
+	binary.Write(&tiff, le, []uint16{2, 0x0112, 3})
+	binary.Write(&tiff, le, []uint32{1})
+	binary.Write(&tiff, le, []uint16{6, 0, 0x8769, 4})
+	binary.Write(&tiff, le, []uint32{1, 38, 0})
+	binary.Write(&tiff, le, []uint16{1, 0x9003, 2})
+	binary.Write(&tiff, le, []uint32{20, 56, 0})
+	tiff.WriteString(capturedAt + "\x00")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}
+	out = append(out, segment...)
+	return append(out, img.Bytes()[2:]...)
+}
+
+func TestVehiclePhotoExif(t *testing.T) {
+	h := testutil.New(t)
+	order := seedOrder(h, seedSales(h), nil)
+
+	resp := h.Upload("/api/v1/client/driver/"+order.InvoiceToken+"/photo", "photo", "truck.jpg", exifJPEG(t, "2026:05:01 08:30:00"), "")
+	if resp.Status != 200 {
+		t.Fatalf("upload: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	stored := h.Storage.Content(h.Storage.Uploads()[0])
+	if bytes.Contains(stored, []byte("Exif")) {
+		t.Fatal("the stored photo still has its EXIF data")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stored))
+	if err != nil || img.Bounds().Dx() != 1 || img.Bounds().Dy() != 2 {
+		t.Fatalf("stored photo: want it turned upright to 1x2, got %v (%v)", img.Bounds(), err)
+	}
+
+	saved := &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, saved)
+	if saved.VehiclePhoto == nil || saved.VehiclePhoto.CapturedAt == nil ||
+		saved.VehiclePhoto.CapturedAt.Format("2006-01-02 15:04") != "2026-05-01 08:30" {
+		t.Fatalf("vehicle photo: want the capture time kept, got %+v", saved.VehiclePhoto)
+	}
+}
+
+func TestVehiclePhotoTooLarge(t *testing.T) {
+	h := testutil.New(t)
+	order := seedOrder(h, seedSales(h), nil)
+
+	// The frame header claims 20000x20000 pixels, which must not be decoded to turn it
+	photo := exifJPEG(t, "2026:05:01 08:30:00")
+	sof := bytes.Index(photo, []byte{0xFF, 0xC0})
+	binary.BigEndian.PutUint16(photo[sof+5:], 20000)
+	binary.BigEndian.PutUint16(photo[sof+7:], 20000)
+
+	resp := h.Upload("/api/v1/client/driver/"+order.InvoiceToken+"/photo", "photo", "truck.jpg", photo, "")
+	if resp.Status != 400 {
+		t.Fatalf("upload: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if len(h.Storage.Uploads()) != 0 {
+		t.Fatal("the oversized photo was stored")
+	}
+}
+
+func TestLookupDeviceView(t *testing.T) {
+	h := testutil.New(t)
+	order := seedOrder(h, seedSales(h), func(o *models.Order) {
+		readyForQueue("qb-lookup")(o)
+		o.QueueToken = "queue-token-lookup"
+	})
+
+	// Admins see the whole order
+	resp := h.Request("GET", "/api/v1/queue/lookup?barcode=qb-lookup", nil, h.Token(models.RoleAdmin))
+	if resp.Status != 200 {
+		t.Fatalf("admin lookup: status = %d: %s", resp.Status, resp.Raw)
+	}
+	full, _ := resp.Data()["order"].(map[string]interface{})
+	if full["invoice_token"] != order.InvoiceToken {
+		t.Fatalf("admin lookup: invoice_token = %v", full["invoice_token"])
+	}
+
+	// Kiosk devices do not get the tokens that open the client pages
+	resp = h.Request("GET", "/api/v1/queue/lookup?barcode=qb-lookup", nil, seedDevice(h, true))
+	if resp.Status != 200 {
+		t.Fatalf("device lookup: status = %d: %s", resp.Status, resp.Raw)
+	}
+	view, _ := resp.Data()["order"].(map[string]interface{})
+	if view["order_number"] != order.OrderNumber || view["sales_name"] != "Budi" {
+		t.Fatalf("device lookup: order = %v", view)
+	}
+	for _, field := range []string{"invoice_token", "invoice_url", "queue_token", "delivery_note_token"} {
+		if _, ok := view[field]; ok {
+			t.Fatalf("device lookup returned %s", field)
+		}
+	}
+	if strings.Contains(string(resp.Raw), order.InvoiceToken) || strings.Contains(string(resp.Raw), order.QueueToken) {
+		t.Fatalf("device lookup leaks a token: %s", resp.Raw)
+	}
+}
+
+func TestWalkIn(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	admin := h.Token(models.RoleAdmin)
+
+	seedOrder(h, sales, readyForQueue("qb-walk"))
+	if resp := h.Request("POST", "/api/v1/queue/scan", map[string]interface{}{"barcode": "qb-walk"}, admin); resp.Status != 200 {
+		t.Fatalf("scan: status = %d: %s", resp.Status, resp.Raw)
+	}
+	product := models.NewProduct()
+	product.Name = "Pasir"
+	product.Price = 150000
+	h.Insert("products", product)
+	free := models.NewProduct()
+	free.Name = "Sampel"
+	h.Insert("products", free)
+
+	// Walk-ins are verified on the spot, so only priced catalog products can be sold
+	for _, item := range []map[string]interface{}{
+		{"product_name": "Kerikil", "quantity": 2},
+		{"product_name": "Kerikil", "quantity": 2, "unit_price": 90000},
+		{"product_name": "Sampel", "quantity": 1},
+	} {
+		resp := h.Request("POST", "/api/v1/queue/walk-in", map[string]interface{}{
+			"sales_id":      sales.ID.Hex(),
+			"items":         []map[string]interface{}{item},
+			"driver_name":   "Budi",
+			"driver_phone":  "6281111111111",
+			"vehicle_plate": "B 9999 WI",
+		}, admin)
+		if resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d %q", item, resp.Status, resp.ErrorCode())
+		}
+	}
+	if n := h.Count("orders", bson.M{"walk_in": true}); n != 0 {
+		t.Fatalf("%d walk-in orders created for unpriced products", n)
+	}
+
+	walkIn := map[string]interface{}{
+		"sales_id":      sales.ID.Hex(),
+		"items":         []map[string]interface{}{{"product_name": "Pasir", "quantity": 2, "unit_price": 150000}},
+		"driver_name":   "Budi",
+		"driver_phone":  "6281111111111",
+		"vehicle_plate": "",
+	}
+	resp := h.Request("POST", "/api/v1/queue/walk-in", walkIn, admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("without a plate: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	// The walk-in is paid and takes the next queue number in one step
+	walkIn["vehicle_plate"] = "B 9999 WI"
+	resp = h.Request("POST", "/api/v1/queue/walk-in", walkIn, admin)
+	if resp.Status != 201 {
+		t.Fatalf("walk-in: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if number, _ := resp.Data()["queue_number"].(float64); number != 2 {
+		t.Fatalf("queue number = %v, want 2", resp.Data()["queue_number"])
+	}
+
+	created, _ := resp.Data()["order"].(map[string]interface{})
+	objID, _ := primitive.ObjectIDFromHex(created["id"].(string))
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": objID}, stored)
+	if !stored.WalkIn || stored.Status != models.OrderStatusQueued || stored.PaymentStatus != models.PaymentStatusVerified || stored.TotalPrice != 300000 {
+		t.Fatalf("walk-in order: walk_in=%v status=%s payment=%s total=%v", stored.WalkIn, stored.Status, stored.PaymentStatus, stored.TotalPrice)
+	}
+
+	// Reports can tell walk-ins apart
+	resp = h.Request("GET", "/api/v1/queue?walk_in=true", nil, admin)
+	if items, _ := resp.Body["data"].([]interface{}); len(items) != 1 {
+		t.Fatalf("walk-in queue: %s", resp.Raw)
+	}
+}
+
+func TestWalkInPaymentChecks(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	admin := h.TokenFor(primitive.NewObjectID().Hex(), models.RoleAdmin)
+
+	product := models.NewProduct()
+	product.Name = "Semen"
+	product.Price = 50000
+	h.Insert("products", product)
+	h.Insert("price_check_policy", bson.M{"max_deviation_percent": 10})
+	h.Insert("payment_approval_policy", bson.M{"threshold": 1000000})
+
+	walkIn := func(plate string, quantity int, unitPrice float64, note string) *testutil.Response {
+		return h.Request("POST", "/api/v1/queue/walk-in", map[string]interface{}{
+			"sales_id":      sales.ID.Hex(),
+			"items":         []map[string]interface{}{{"product_name": "Semen", "quantity": quantity, "unit_price": unitPrice, "price_note": note}},
+			"driver_name":   "Budi",
+			"driver_phone":  "6281111111111",
+			"vehicle_plate": plate,
+		}, admin)
+	}
+
+	// Walk-ins go through the same price check as other orders
+	if resp := walkIn("B 1 WI", 2, 40000, ""); resp.Status != 400 || resp.ErrorCode() != response.CodePriceNoteRequired {
+		t.Fatalf("without a price note: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	resp := walkIn("B 1 WI", 2, 40000, "Stok lama")
+	if resp.Status != 201 {
+		t.Fatalf("with a price note: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if n := h.Count("price_overrides", bson.M{}); n != 1 {
+		t.Fatalf("%d price overrides recorded, want 1", n)
+	}
+
+	// Above the approval threshold the payment waits for a second approver and the
+	// truck is not queued yet
+	resp = walkIn("B 2 WI", 30, 0, "")
+	if resp.Status != 202 {
+		t.Fatalf("above the threshold: status = %d: %s", resp.Status, resp.Raw)
+	}
+	created, _ := resp.Data()["order"].(map[string]interface{})
+	objID, _ := primitive.ObjectIDFromHex(created["id"].(string))
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": objID}, stored)
+	if stored.PaymentStatus != models.PaymentStatusApproval || stored.Status != models.OrderStatusPending || stored.QueueNumber != 0 {
+		t.Fatalf("above the threshold: status=%s payment=%s queue=%d", stored.Status, stored.PaymentStatus, stored.QueueNumber)
+	}
+
+	// Once approved by someone else the truck is scanned in like any other
+	second := h.TokenFor(primitive.NewObjectID().Hex(), models.RoleAdmin)
+	if resp := h.Request("POST", "/api/v1/payments/"+objID.Hex()+"/approve", nil, second); resp.Status != 200 {
+		t.Fatalf("second approval: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/queue/scan", map[string]interface{}{"barcode": stored.QueueBarcode}, admin); resp.Status != 200 {
+		t.Fatalf("scan after approval: status = %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestSplitOrder(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	order := seedOrder(h, seedSales(h), func(o *models.Order) {
+		withProof(o)
+		o.Status = models.OrderStatusConfirmed
+		o.PaymentStatus = models.PaymentStatusVerified
+	})
+	path := "/api/v1/orders/" + order.ID.Hex()
+
+	split := func(quantities ...int) *testutil.Response {
+		shipments := []map[string]interface{}{}
+		for i, quantity := range quantities {
+			shipment := map[string]interface{}{"items": []map[string]interface{}{{"index": 0, "quantity": quantity}}}
+			if i == 0 {
+				shipment["driver_name"], shipment["driver_phone"], shipment["vehicle_plate"] = "Joko", "6289876543210", "B 1 ONE"
+			}
+			shipments = append(shipments, shipment)
+		}
+		return h.Request("POST", path+"/split", map[string]interface{}{"shipments": shipments}, admin)
+	}
+
+	if resp := split(6, 5); resp.Status != 400 || resp.ErrorCode() != response.CodeSplitExceeded {
+		t.Fatalf("more than ordered: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	if resp := split(6, 4); resp.Status != 201 {
+		t.Fatalf("split: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// The split order itself no longer takes drivers; its shipments do
+	driver := map[string]interface{}{"driver_name": "Budi", "driver_phone": "6281111111111", "vehicle_plate": "B 2 TWO"}
+	if resp := h.Request("POST", path+"/driver", driver, admin); resp.ErrorCode() != response.CodeOrderSplit {
+		t.Fatalf("driver on the split order: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	shipments := make([]models.Order, 2)
+	for i := range shipments {
+		h.Find("orders", bson.M{"shipment_of": order.ID.Hex(), "shipment_number": i + 1}, &shipments[i])
+	}
+	if resp := h.Request("POST", "/api/v1/orders/"+shipments[1].ID.Hex()+"/driver", driver, admin); resp.Status != 200 {
+		t.Fatalf("driver on a shipment: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Each shipment is queued and loaded on its own, the last one finished by issuing its
+	// delivery note; the order completes with the last one
+	for i := range shipments {
+		shipment := &models.Order{}
+		h.Find("orders", bson.M{"_id": shipments[i].ID}, shipment)
+		if resp := h.Request("POST", "/api/v1/queue/scan", map[string]interface{}{"barcode": shipment.QueueBarcode}, admin); resp.Status != 200 {
+			t.Fatalf("scan shipment %d: status = %d: %s", i+1, resp.Status, resp.Raw)
+		}
+		if resp := h.Request("POST", "/api/v1/queue/call-next", nil, admin); resp.Status != 200 {
+			t.Fatalf("call shipment %d: status = %d: %s", i+1, resp.Status, resp.Raw)
+		}
+		finish := h.Request("POST", "/api/v1/orders/"+shipment.ID.Hex()+"/finish-loading", nil, admin)
+		if i == 1 {
+			finish = h.Request("POST", "/api/v1/delivery", map[string]string{"order_id": shipment.ID.Hex()}, admin)
+		}
+		if finish.Status != 200 {
+			t.Fatalf("finish shipment %d: status = %d: %s", i+1, finish.Status, finish.Raw)
+		}
+
+		resp := h.Request("GET", path+"/shipments", nil, admin)
+		progress, _ := resp.Data()["progress"].([]interface{})
+		item, _ := progress[0].(map[string]interface{})
+		wantRemaining, wantStatus := 4.0, models.OrderStatusConfirmed
+		if i == 1 {
+			wantRemaining, wantStatus = 0, models.OrderStatusCompleted
+		}
+		if item["remaining"] != wantRemaining || resp.Data()["status"] != wantStatus {
+			t.Fatalf("after shipment %d: remaining %v status %v, want %v %s", i+1, item["remaining"], resp.Data()["status"], wantRemaining, wantStatus)
+		}
+	}
+
+	for i, shipment := range shipments {
+		if n := h.Count("delivery_notes", bson.M{"order_id": shipment.ID.Hex()}); n != 1 {
+			t.Fatalf("shipment %d has %d delivery notes, want 1", i+1, n)
+		}
+	}
+
+	// The shipments repeat the split order, which alone counts in the stats
+	for _, stats := range []string{"/api/v1/orders/stats", "/api/v1/dashboard/stats"} {
+		resp := h.Request("GET", stats, nil, admin)
+		data := resp.Data()
+		completed := data["completed"]
+		if orders, ok := data["orders"].(map[string]interface{}); ok {
+			completed = orders["completed"]
+		}
+		if resp.Status != 200 || completed != 1.0 {
+			t.Fatalf("%s: status = %d: %s", stats, resp.Status, resp.Raw)
+		}
+	}
+}
+
+func TestMergeLoading(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	confirmed := func(o *models.Order) {
+		withProof(o)
+		o.Status = models.OrderStatusConfirmed
+		o.PaymentStatus = models.PaymentStatusVerified
+	}
+	first := seedOrder(h, sales, confirmed)
+	second := seedOrder(h, sales, confirmed)
+	other := seedOrder(h, seedSales(h), confirmed)
+
+	merge := func(orders ...*models.Order) *testutil.Response {
+		ids := []string{}
+		for _, order := range orders {
+			ids = append(ids, order.ID.Hex())
+		}
+		return h.Request("POST", "/api/v1/orders/merge-loading", map[string]interface{}{
+			"order_ids":     ids,
+			"driver_name":   "Joko",
+			"driver_phone":  "6289876543210",
+			"vehicle_plate": "B 1234 XYZ",
+		}, admin)
+	}
+
+	if resp := merge(first, other); resp.Status != 400 || resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("orders of another sales: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	resp := merge(first, second)
+	if resp.Status != 200 {
+		t.Fatalf("merge: status = %d: %s", resp.Status, resp.Raw)
+	}
+	code, _ := resp.Data()["queue_barcode"].(string)
+
+	// The truck is queued and loaded once, under one note for both orders
+	if resp := h.Request("POST", "/api/v1/queue/scan", map[string]interface{}{"barcode": code}, admin); resp.Status != 200 {
+		t.Fatalf("scan: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", "/api/v1/queue/call-next", nil, admin); resp.Status != 200 {
+		t.Fatalf("call: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", "/api/v1/orders/"+first.ID.Hex()+"/finish-loading", nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("finish: status = %d: %s", resp.Status, resp.Raw)
+	}
+	note, _ := resp.Data()["delivery_note"].(map[string]interface{})
+	if numbers, _ := note["order_numbers"].([]interface{}); len(numbers) != 2 || numbers[1] != second.OrderNumber {
+		t.Fatalf("note order numbers = %v", note["order_numbers"])
+	}
+	if items, _ := note["items"].([]interface{}); len(items) != 2 {
+		t.Fatalf("note items = %v, want the items of both orders", note["items"])
+	}
+
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": second.ID}, stored)
+	if stored.Status != models.OrderStatusCompleted || stored.DeliveryNoteID != note["id"] {
+		t.Fatalf("merged order: status %s note %q, want completed with note %v", stored.Status, stored.DeliveryNoteID, note["id"])
+	}
+}
+
+func TestCancelMergedOrders(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	confirmed := func(o *models.Order) {
+		withProof(o)
+		o.Status = models.OrderStatusConfirmed
+		o.PaymentStatus = models.PaymentStatusVerified
+	}
+	carrier := seedOrder(h, sales, confirmed)
+	second := seedOrder(h, sales, confirmed)
+	third := seedOrder(h, sales, confirmed)
+
+	resp := h.Request("POST", "/api/v1/orders/merge-loading", map[string]interface{}{
+		"order_ids":     []string{carrier.ID.Hex(), second.ID.Hex(), third.ID.Hex()},
+		"driver_name":   "Joko",
+		"driver_phone":  "6289876543210",
+		"vehicle_plate": "B 1234 XYZ",
+	}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("merge: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// A merged order that is cancelled leaves the truck
+	if resp := h.Request("DELETE", "/api/v1/orders/"+third.ID.Hex(), nil, admin); resp.Status != 200 || resp.ErrorCode() != "" {
+		t.Fatalf("cancel merged order: status = %d: %s", resp.Status, resp.Raw)
+	}
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": carrier.ID}, stored)
+	if len(stored.MergedOrders) != 1 || stored.MergedOrders[0] != second.ID.Hex() {
+		t.Fatalf("carrier merged orders = %v, want only the second order", stored.MergedOrders)
+	}
+
+	// Cancelling the carrier releases the orders it carried
+	resp = h.Request("POST", "/api/v1/orders/"+carrier.ID.Hex()+"/move", map[string]string{"status": "cancelled"}, admin)
+	if resp.Status != 200 || resp.ErrorCode() != "" {
+		t.Fatalf("cancel carrier: status = %d: %s", resp.Status, resp.Raw)
+	}
+	released := &models.Order{}
+	h.Find("orders", bson.M{"_id": second.ID}, released)
+	if released.LoadedWith != "" || released.Status != models.OrderStatusConfirmed {
+		t.Fatalf("released order: loaded_with=%q status=%s", released.LoadedWith, released.Status)
+	}
+	if resp := h.Request("DELETE", "/api/v1/orders/"+carrier.ID.Hex(), nil, admin); resp.ErrorCode() != response.CodeOrderInvalidStatus {
+		t.Fatalf("cancel twice: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestCancelSplitOrder(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	order := seedOrder(h, seedSales(h), func(o *models.Order) {
+		withProof(o)
+		o.Status = models.OrderStatusConfirmed
+		o.PaymentStatus = models.PaymentStatusVerified
+	})
+	path := "/api/v1/orders/" + order.ID.Hex()
+
+	resp := h.Request("POST", path+"/split", map[string]interface{}{"shipments": []map[string]interface{}{
+		{"items": []map[string]interface{}{{"index": 0, "quantity": 6}}},
+		{"items": []map[string]interface{}{{"index": 0, "quantity": 4}}},
+	}}, admin)
+	if resp.Status != 201 {
+		t.Fatalf("split: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp := h.Request("DELETE", path, nil, admin); resp.Status != 400 || resp.ErrorCode() != response.CodeOrderSplit {
+		t.Fatalf("cancel with open shipments: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	// A cancelled shipment gives its quantity back to be shipped again
+	shipment := &models.Order{}
+	h.Find("orders", bson.M{"shipment_of": order.ID.Hex(), "shipment_number": 2}, shipment)
+	if resp := h.Request("DELETE", "/api/v1/orders/"+shipment.ID.Hex(), nil, admin); resp.Status != 200 || resp.ErrorCode() != "" {
+		t.Fatalf("cancel shipment: status = %d: %s", resp.Status, resp.Raw)
+	}
+	parent := &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, parent)
+	if parent.Items[0].Shipped != 6 {
+		t.Fatalf("shipped after cancelling a shipment = %d, want 6", parent.Items[0].Shipped)
+	}
+}
+
+func TestClientJournal(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	previous := config.Cfg.Journal.Enabled
+	config.Cfg.Journal.Enabled = true
+	t.Cleanup(func() { config.Cfg.Journal.Enabled = previous })
+
+	sales := seedSales(h)
+	order := seedOrder(h, sales, func(o *models.Order) {
+		withProof(o)
+		o.Status = models.OrderStatusPaid
+	})
+
+	// Submitted before the payment was verified, with the code the sales rep forwarded
+	driver := map[string]interface{}{
+		"driver_name":   "Joko",
+		"driver_phone":  "6289876543210",
+		"vehicle_plate": "B 1234 XYZ",
+		"code":          "123456",
+	}
+	resp := h.Request("POST", "/api/v1/client/driver/"+order.InvoiceToken, driver, "")
+	if resp.ErrorCode() != response.CodePaymentNotVerified {
+		t.Fatalf("submit before verification: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	filter := bson.M{"token": order.InvoiceToken}
+	h.Eventually(func() bool { return h.Count(middleware.JournalCollection, filter) == 1 }, "want the submission journaled")
+	var submission models.ClientSubmission
+	h.Find(middleware.JournalCollection, filter, &submission)
+	if submission.ErrorCode != string(response.CodePaymentNotVerified) || submission.Route != "/api/v1/client/driver/:token" {
+		t.Fatalf("unexpected journal entry: %+v", submission)
+	}
+	if strings.Contains(submission.Body, "123456") || !strings.Contains(submission.Body, "6289876543210") {
+		t.Fatalf("want the code redacted and the phone kept: %s", submission.Body)
+	}
+
+	resp = h.Request("GET", "/api/v1/request-logs/submissions?failed=true&token="+order.InvoiceToken, nil, admin)
+	if listed, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(listed) != 1 {
+		t.Fatalf("list submissions: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	replayPath := "/api/v1/request-logs/submissions/" + submission.ID.Hex() + "/replay"
+	resp = h.Request("POST", replayPath, nil, admin)
+	replay, _ := resp.Data()["replay"].(map[string]interface{})
+	if resp.Status != 200 || replay["valid"] != false || replay["error_code"] != string(response.CodePaymentNotVerified) {
+		t.Fatalf("replay before verification: %s", resp.Raw)
+	}
+
+	// Once the payment is verified the same body passes, so the frontend was not at fault
+	if resp = h.Request("POST", "/api/v1/payments/"+order.ID.Hex()+"/verify", nil, admin); resp.Status != 200 {
+		t.Fatalf("verify payment: status = %d: %s", resp.Status, resp.Raw)
+	}
+	resp = h.Request("POST", replayPath, nil, admin)
+	if replay, _ = resp.Data()["replay"].(map[string]interface{}); replay["valid"] != true {
+		t.Fatalf("replay after verification: %s", resp.Raw)
+	}
+	var stored models.Order
+	h.Find("orders", bson.M{"_id": order.ID}, &stored)
+	if stored.DriverName != "" {
+		t.Fatalf("replay must not save the driver, got %q", stored.DriverName)
+	}
+}
+
+func TestClientPollInterval(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	pending := seedOrder(h, sales, nil)
+	queued := seedOrder(h, sales, func(o *models.Order) { o.Status = models.OrderStatusQueued })
+	completed := seedOrder(h, sales, func(o *models.Order) { o.Status = models.OrderStatusCompleted })
+
+	tests := []struct {
+		order    *models.Order
+		interval float64
+	}{
+		{pending, 60},
+		{queued, 10},
+		{completed, 0},
+	}
+	for _, tt := range tests {
+		resp := h.Request("GET", "/api/v1/client/status/"+tt.order.InvoiceToken, nil, "")
+		if resp.Status != 200 {
+			t.Fatalf("%s status: %d: %s", tt.order.Status, resp.Status, resp.Raw)
+		}
+		if got := resp.Data()["poll_interval"]; got != tt.interval {
+			t.Fatalf("%s poll_interval = %v, want %v", tt.order.Status, got, tt.interval)
+		}
+	}
+
+	resp := h.Request("GET", "/api/v1/client/status/"+pending.InvoiceToken, nil, "")
+	if resp.Status != 429 || resp.ErrorCode() != response.CodeTooManyRequests {
+		t.Fatalf("immediate repoll: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	t.Cleanup(func() { config.Cfg.Client.PollEnforce = true })
+	config.Cfg.Client.PollEnforce = false
+	if resp = h.Request("GET", "/api/v1/client/status/"+pending.InvoiceToken, nil, ""); resp.Status != 200 {
+		t.Fatalf("repoll without enforcement: status = %d", resp.Status)
+	}
+
+	// A poll a quarter of the interval later is allowed again
+	tracker := polling.NewTracker()
+	now := time.Now()
+	tracker.Record("token", 60*time.Second, now)
+	if wait := tracker.Check("token", now.Add(5*time.Second)); wait != 55*time.Second {
+		t.Fatalf("wait after 5s = %s", wait)
+	}
+	if wait := tracker.Check("token", now.Add(15*time.Second)); wait != 0 {
+		t.Fatalf("wait after 15s = %s", wait)
+	}
+
+	// Viewers of one invoice from different addresses poll independently
+	tracker.Record(polling.Key("shared", "10.0.0.1"), 10*time.Second, now)
+	if wait := tracker.Check(polling.Key("shared", "10.0.0.2"), now); wait != 0 {
+		t.Fatalf("second viewer must wait %s", wait)
+	}
+}
+
+func TestAmendDeliveryNoteOnce(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	note := models.NewDeliveryNote()
+	note.NoteNumber = "SJ-202610-0001"
+	note.Revision = 1
+	note.DriverName = "Andi"
+	h.Insert("delivery_notes", note)
+
+	// Concurrent amends of one revision must not fork the chain
+	statuses := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			body := map[string]string{"reason": "Salah sopir", "driver_name": "Sopir " + strconv.Itoa(i)}
+			statuses <- h.Request("PUT", "/api/v1/delivery/"+note.ID.Hex(), body, admin).Status
+		}(i)
+	}
+	amended := 0
+	for i := 0; i < 4; i++ {
+		if <-statuses == 200 {
+			amended++
+		}
+	}
+	if amended != 1 {
+		t.Fatalf("%d amends succeeded, want 1", amended)
+	}
+	if n := h.Count("delivery_notes", bson.M{"root_id": note.ID.Hex(), "revision": 2}); n != 1 {
+		t.Fatalf("%d second revisions, want 1", n)
+	}
+
+	if resp := h.Request("PUT", "/api/v1/delivery/"+note.ID.Hex(), map[string]string{"reason": "Lagi"}, admin); resp.Status == 200 {
+		t.Fatalf("amending a superseded revision: status = %d", resp.Status)
+	}
+}
+
+func TestQueueDayUnservedWait(t *testing.T) {
+	h := testutil.New(t)
+	date := time.Now().AddDate(0, 0, -3).Format("2006-01-02")
+	start, _ := time.Parse("2006-01-02", date)
+	enteredAt := start.Add(22 * time.Hour)
+
+	seedOrder(h, seedSales(h), func(o *models.Order) {
+		readyForQueue("qb-unserved")(o)
+		o.Status = models.OrderStatusQueued
+		o.QueueNumber = 1
+		o.QueueEnteredAt = &enteredAt
+	})
+
+	// The order was never called, so it waited until the end of its day, not until now
+	resp := h.Request("GET", "/api/v1/queue/history/"+date, nil, h.Token(models.RoleAdmin))
+	if resp.Status != 200 {
+		t.Fatalf("history day: status = %d: %s", resp.Status, resp.Raw)
+	}
+	day, _ := resp.Data()["day"].(map[string]interface{})
+	entries, _ := day["entries"].([]interface{})
+	if len(entries) != 1 {
+		t.Fatalf("want one entry, got %v", day)
+	}
+	entry := entries[0].(map[string]interface{})
+	if entry["outcome"] != models.QueueOutcomeUnserved || entry["wait_minutes"] != 120.0 {
+		t.Fatalf("unserved entry = %v", entry)
+	}
+}
+
+func TestQueueLeftoversFromEarlierDay(t *testing.T) {
+	h := testutil.New(t)
+	previous := config.Cfg.Notification
+	t.Cleanup(func() { config.Cfg.Notification = previous })
+	config.Cfg.Notification.WaitThreshold = time.Minute
+	config.Cfg.Notification.WaitInterval = time.Hour
+
+	sales := seedSales(h)
+	queued := func(barcode string, number int, enteredAt time.Time) *models.Order {
+		return seedOrder(h, sales, func(o *models.Order) {
+			readyForQueue(barcode)(o)
+			o.Status = models.OrderStatusQueued
+			o.QueueNumber = number
+			o.QueueEnteredAt = &enteredAt
+		})
+	}
+	dayStart, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	stale := queued("qb-stale", 1, dayStart.Add(-12*time.Hour))
+	queued("qb-first", 1, dayStart.Add(time.Minute))
+	second := queued("qb-second", 2, dayStart.Add(2*time.Minute))
+
+	// The order left in yesterday's queue is not counted in today's
+	resp := h.Request("GET", "/api/v1/queue/estimate", nil, h.Token(models.RoleAdmin))
+	if resp.Status != 200 || resp.Data()["queue_count"] != 2.0 {
+		t.Fatalf("estimate: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	notification.NotifyLongWaits()
+	if n := h.Count("notifications", bson.M{"type": "queue_wait", "order_id": second.ID.Hex()}); n == 0 {
+		t.Fatal("the order waiting behind today's queue got no revised ETA")
+	}
+	if n := h.Count("notifications", bson.M{"type": "queue_wait", "order_id": stale.ID.Hex()}); n != 0 {
+		t.Fatalf("the order left from yesterday got %d revised ETAs", n)
+	}
+}
+
+func TestLendAssetsConcurrently(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	order := seedOrder(h, seedSales(h), nil)
+	note := models.NewDeliveryNote()
+	note.NoteNumber = "SJ-202610-0002"
+	note.OrderID = order.ID.Hex()
+	h.Insert("delivery_notes", note)
+
+	// Every lend adds to the note's totals; none overwrites another
+	const lends = 8
+	statuses := make(chan int, lends)
+	for i := 0; i < lends; i++ {
+		go func() {
+			body := map[string]interface{}{
+				"delivery_note_id": note.ID.Hex(),
+				"items":            []map[string]interface{}{{"asset_type": "palet", "quantity": 2}, {"asset_type": "jeriken", "quantity": 1}},
+			}
+			statuses <- h.Request("POST", "/api/v1/assets/lend", body, admin).Status
+		}()
+	}
+	for i := 0; i < lends; i++ {
+		if status := <-statuses; status != 201 {
+			t.Fatalf("lend: status = %d", status)
+		}
+	}
+
+	saved := &models.DeliveryNote{}
+	h.Find("delivery_notes", bson.M{"_id": note.ID}, saved)
+	totals := map[string]int{}
+	for _, asset := range saved.Assets {
+		totals[asset.AssetType] += asset.Quantity
+	}
+	if len(saved.Assets) != 2 || totals["palet"] != 2*lends || totals["jeriken"] != lends {
+		t.Fatalf("note assets = %+v", saved.Assets)
+	}
+}
+
+func TestDeliveryNoteRecipients(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	order := seedOrder(h, sales, nil)
+
+	path := "/api/v1/sales/" + sales.ID.Hex() + "/recipients"
+	resp := h.Request("PUT", path, map[string]interface{}{"recipients": []map[string]string{{"name": "Gudang"}}}, admin)
+	if resp.Status != 400 {
+		t.Fatalf("recipient without an address: status = %d", resp.Status)
+	}
+	resp = h.Request("PUT", path, map[string]interface{}{"recipients": []map[string]string{{"name": "Gudang", "phone": "0811-1111-1111"}}}, admin)
+	if resp.Status != 200 {
+		t.Fatalf("set recipients: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	note := models.NewDeliveryNote()
+	note.NoteNumber = "SJ-202610-0003"
+	note.OrderID = order.ID.Hex()
+	note.Token = "note-token-recipients"
+	h.Insert("delivery_notes", note)
+
+	if resp = h.Request("POST", "/api/v1/delivery/"+note.ID.Hex()+"/send", nil, admin); resp.Status != 202 {
+		t.Fatalf("send: status = %d: %s", resp.Status, resp.Raw)
+	}
+	h.Eventually(func() bool { return len(h.WhatsApp.MessagesTo("6281111111111")) == 1 }, "the note was not sent to the recipient")
+	h.Eventually(func() bool {
+		return h.Count("delivery_notes", bson.M{"_id": note.ID, "recipients.0.notification_id": bson.M{"$exists": true}}) == 1
+	}, "the recipient delivery was not saved")
+
+	resp = h.Request("GET", "/api/v1/delivery/"+note.ID.Hex()+"/recipients", nil, admin)
+	recipients, _ := resp.Data()["recipients"].([]interface{})
+	if resp.Status != 200 || len(recipients) != 1 || recipients[0].(map[string]interface{})["status"] != models.NoteRecipientSent {
+		t.Fatalf("recipients: status = %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Only the latest revision can be sent
+	old := models.NewDeliveryNote()
+	old.OrderID = order.ID.Hex()
+	old.Superseded = true
+	h.Insert("delivery_notes", old)
+	resp = h.Request("POST", "/api/v1/delivery/"+old.ID.Hex()+"/send", nil, admin)
+	if resp.Status != 400 || resp.ErrorCode() != response.CodeDeliveryNoteSuperseded {
+		t.Fatalf("send a superseded note: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestReturnComplaint(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	note := models.NewDeliveryNote()
+	note.NoteNumber = "SJ-202610-0004"
+	note.OrderID = primitive.NewObjectID().Hex()
+	note.SalesName = "Budi"
+	note.SalesPhone = "0812-2222-3333"
+	note.Token = "note-token-returns"
+	h.Insert("delivery_notes", note)
+
+	submit := func(form url.Values) *testutil.Response {
+		req := httptest.NewRequest("POST", "/api/v1/client/returns/"+note.Token, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return h.Send(req)
+	}
+	if resp := submit(url.Values{"quantity_affected": {"2"}}); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("complaint without a description: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp := submit(url.Values{"description": {"Kemasan sobek"}, "product_name": {"Kardus"}, "quantity_affected": {"2"}})
+	id, _ := resp.Data()["id"].(string)
+	if resp.Status != 201 || id == "" || resp.Data()["status"] != models.ReturnStatusOpen {
+		t.Fatalf("submit: got %d: %s", resp.Status, resp.Raw)
+	}
+	h.Eventually(func() bool { return len(h.WhatsApp.MessagesTo(note.SalesPhone)) == 1 }, "the sales was not told of the complaint")
+
+	if resp = h.Request("POST", "/api/v1/returns/"+id+"/review", nil, admin); resp.Status != 200 {
+		t.Fatalf("review: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("POST", "/api/v1/returns/"+id+"/resolve", map[string]string{"resolution": "discount"}, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unknown resolution: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	resolve := map[string]string{"resolution": models.ReturnResolutionReplace, "note": "Dikirim besok"}
+	if resp = h.Request("POST", "/api/v1/returns/"+id+"/resolve", resolve, admin); resp.Status != 200 {
+		t.Fatalf("resolve: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("POST", "/api/v1/returns/"+id+"/resolve", resolve, admin); resp.ErrorCode() != response.CodeComplaintClosed {
+		t.Fatalf("resolving a closed complaint: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	h.Eventually(func() bool { return len(h.WhatsApp.MessagesTo(note.SalesPhone)) == 3 }, "the sales was not told of each status")
+
+	// The client sees the outcome on the delivery note link
+	resp = h.Request("GET", "/api/v1/client/returns/"+note.Token, nil, "")
+	returns, _ := resp.Body["data"].([]interface{})
+	if resp.Status != 200 || len(returns) != 1 || returns[0].(map[string]interface{})["status"] != models.ReturnStatusResolved {
+		t.Fatalf("client returns: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestQueueHeatmap(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	// Monday 08:xx and Tuesday 14:00 in Jakarta, stored in UTC
+	jakarta, _ := time.LoadLocation("Asia/Jakarta")
+	arrivals := []time.Time{
+		time.Date(2026, 10, 12, 8, 5, 0, 0, jakarta),
+		time.Date(2026, 10, 12, 8, 40, 0, 0, jakarta),
+		time.Date(2026, 10, 13, 14, 0, 0, 0, jakarta),
+	}
+	for _, at := range arrivals {
+		entered := at.UTC()
+		seedOrder(h, sales, func(o *models.Order) { o.QueueEnteredAt = &entered })
+	}
+
+	path := "/api/v1/reports/queue-heatmap?from=2026-10-01&to=2026-10-31"
+	if resp := h.Request("GET", path+"&tz=Mars/Olympus", nil, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unknown timezone: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	resp := h.Request("GET", path, nil, admin)
+	grid, _ := resp.Data()["grid"].([]interface{})
+	if resp.Status != 200 || resp.Data()["total"] != 3.0 || len(grid) != 7 {
+		t.Fatalf("heatmap: got %d: %s", resp.Status, resp.Raw)
+	}
+	if monday := grid[1].([]interface{}); monday[8] != 2.0 {
+		t.Fatalf("monday = %v", monday)
+	}
+	peak, _ := resp.Data()["peak"].(map[string]interface{})
+	if peak["weekday"] != 1.0 || peak["hour"] != 8.0 || peak["count"] != 2.0 {
+		t.Fatalf("peak = %v", peak)
+	}
+
+	// The same arrivals bucketed in UTC fall seven hours earlier
+	resp = h.Request("GET", path+"&tz=UTC", nil, admin)
+	byHour, _ := resp.Data()["by_hour"].([]interface{})
+	if resp.Status != 200 || len(byHour) != 24 || byHour[1] != 2.0 || byHour[7] != 1.0 {
+		t.Fatalf("heatmap in UTC: got %d: %s", resp.Status, resp.Raw)
+	}
+}
+
+func TestExportDeliveryNotes(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	first := models.NewDeliveryNote()
+	first.NoteNumber = "SJ-202610-0005"
+	amended := models.NewDeliveryNote()
+	amended.NoteNumber = "SJ-202610-0006"
+	amended.Revision = 2
+	old := models.NewDeliveryNote()
+	old.NoteNumber = "SJ-202610-0006"
+	old.Superseded = true
+	h.Insert("delivery_notes", first, amended, old)
+
+	// entries lists the files of a ZIP archive
+	entries := func(raw []byte) []string {
+		archive, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+		if err != nil {
+			t.Fatalf("read archive: %v", err)
+		}
+		names := []string{}
+		for _, f := range archive.File {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+	want := "SJ-202610-0005.pdf SJ-202610-0006-rev2.pdf"
+
+	resp := h.Request("GET", "/api/v1/delivery/export", nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("export: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if names := strings.Join(entries(resp.Raw), " "); names != want {
+		t.Fatalf("streamed archive = %s, want %s", names, want)
+	}
+
+	resp = h.Request("GET", "/api/v1/delivery/export?async=true", nil, admin)
+	jobID, _ := resp.Data()["id"].(string)
+	if resp.Status != 202 || jobID == "" {
+		t.Fatalf("async export: got %d: %s", resp.Status, resp.Raw)
+	}
+	h.Eventually(func() bool {
+		resp := h.Request("GET", "/api/v1/delivery/export/jobs/"+jobID, nil, admin)
+		job, _ := resp.Data()["job"].(map[string]interface{})
+		return job["status"] == models.ExportStatusDone && resp.Data()["progress"] == 100.0
+	}, "the export job did not finish")
+
+	resp = h.Request("GET", "/api/v1/delivery/export/jobs/"+jobID+"/download", nil, admin)
+	if resp.Status != 200 {
+		t.Fatalf("download: status = %d: %s", resp.Status, resp.Raw)
+	}
+	if names := strings.Join(entries(resp.Raw), " "); names != want {
+		t.Fatalf("job archive = %s, want %s", names, want)
+	}
+
+	if resp = h.Request("GET", "/api/v1/delivery/export?from=2020-01-01&to=2020-01-31", nil, admin); resp.ErrorCode() != response.CodeNotFound {
+		t.Fatalf("empty range: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestCallNextMessagesDriver(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	kiosk := seedDevice(h, true)
+
+	entered := time.Now().Add(-10 * time.Minute)
+	order := seedOrder(h, sales, func(o *models.Order) {
+		readyForQueue("qb-driver")(o)
+		o.Status = models.OrderStatusQueued
+		o.QueueNumber = 7
+		o.QueueToken = "abcdef1234567890"
+		o.QueueEnteredAt = &entered
+	})
+
+	resp := h.Request("POST", "/api/v1/queue/call-next", map[string]interface{}{"bay": "B2"}, kiosk)
+	link, _ := resp.Data()["driver_whatsapp_link"].(string)
+	if resp.Status != 200 || link == "" {
+		t.Fatalf("call next: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// The driver gets the bay, queue number, plate and QR reference to show at the gate
+	sent := h.WhatsApp.MessagesTo(order.DriverPhone)
+	if len(sent) != 1 {
+		t.Fatalf("%d messages to the driver, want 1", len(sent))
+	}
+	for _, want := range []string{"loading bay B2", "#7", "B 1234 XYZ", order.OrderNumber, "Ref QR: ABCDEF12"} {
+		if !strings.Contains(sent[0].Text, want) {
+			t.Fatalf("driver message has no %q: %s", want, sent[0].Text)
+		}
+	}
+	if len(h.WhatsApp.MessagesTo(sales.Phone)) != 0 {
+		t.Fatalf("the sales was messaged on the call")
+	}
+}
+
+func TestQueueTicket(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+
+	waiting := seedOrder(h, sales, readyForQueue("qb-ticket-1"))
+	queued := seedOrder(h, sales, func(o *models.Order) {
+		readyForQueue("qb-ticket-2")(o)
+		o.Status = models.OrderStatusQueued
+		o.QueueNumber = 12
+		o.QueueToken = "ticket-token-12"
+		o.DriverName = "Jöko ^XZ"
+	})
+	path := "/api/v1/queue/" + queued.ID.Hex() + "/ticket"
+
+	if resp := h.Request("GET", "/api/v1/queue/"+waiting.ID.Hex()+"/ticket", nil, admin); resp.ErrorCode() != response.CodeOrderInvalidStatus {
+		t.Fatalf("ticket before the queue: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	if resp := h.Request("GET", path+"?format=png", nil, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("unknown format: got %d %q", resp.Status, resp.ErrorCode())
+	}
+
+	// A driver name can not end the label early or break the receipt code page
+	zpl := string(h.Request("GET", path+"?format=zpl", nil, admin).Raw)
+	if !strings.HasPrefix(zpl, "^XA") || !strings.Contains(zpl, "^FD#12^FS") || !strings.Contains(zpl, "^FDQA,ticket-token-12^FS") || strings.Count(zpl, "^XZ") != 1 {
+		t.Fatalf("zpl ticket:\n%s", zpl)
+	}
+	escpos := h.Request("GET", path+"?format=escpos", nil, admin).Raw
+	if !bytes.HasPrefix(escpos, []byte{0x1b, 0x40}) || !bytes.Contains(escpos, []byte("J?ko ^XZ\n")) || !bytes.Contains(escpos, []byte("ticket-token-12")) {
+		t.Fatalf("escpos ticket: %q", escpos)
+	}
+	if resp := h.Request("GET", path, nil, admin); resp.Status != 200 || !bytes.HasPrefix(resp.Raw, []byte("%PDF")) {
+		t.Fatalf("pdf ticket: got %d: %.40q", resp.Status, resp.Raw)
+	}
+}
+
+func TestDeviceManagement(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+
+	if resp := h.Request("POST", "/api/v1/devices", map[string]string{"location": "Gerbang"}, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("device without a name: got %d %q", resp.Status, resp.ErrorCode())
+	}
+	resp := h.Request("POST", "/api/v1/devices", map[string]string{"name": "Kiosk 1", "location": "Gerbang"}, admin)
+	token, _ := resp.Data()["token"].(string)
+	dev, _ := resp.Data()["device"].(map[string]interface{})
+	deviceID, _ := dev["id"].(string)
+	if resp.Status != 201 || token == "" || deviceID == "" || !strings.HasSuffix(token, strings.TrimPrefix(dev["token_hint"].(string), "…")) {
+		t.Fatalf("create: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// accepted reports whether a kiosk token gets past the device guard
+	accepted := func(token string) bool {
+		return h.Request("POST", "/api/v1/queue/call-next", nil, token).ErrorCode() == response.CodeQueueEmpty
+	}
+	if !accepted(token) {
+		t.Fatalf("the new device token was refused")
+	}
+	resp = h.Request("GET", "/api/v1/devices/"+deviceID+"/scans", nil, admin)
+	if scans, _ := resp.Body["data"].([]interface{}); resp.Status != 200 || len(scans) != 0 {
+		t.Fatalf("scans: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	resp = h.Request("POST", "/api/v1/devices/"+deviceID+"/token", nil, admin)
+	rotated, _ := resp.Data()["token"].(string)
+	if resp.Status != 200 || rotated == "" || accepted(token) || !accepted(rotated) {
+		t.Fatalf("after rotating, the old token must stop and the new one work: %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp = h.Request("PUT", "/api/v1/devices/"+deviceID, map[string]bool{"active": false}, admin); resp.Status != 200 || accepted(rotated) {
+		t.Fatalf("a disabled device is still accepted: %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("PUT", "/api/v1/devices/"+deviceID, map[string]bool{"active": true}, admin); resp.Status != 200 || !accepted(rotated) {
+		t.Fatalf("a re-enabled device is refused: %d: %s", resp.Status, resp.Raw)
+	}
+
+	if resp = h.Request("DELETE", "/api/v1/devices/"+deviceID, nil, admin); resp.Status != 200 || accepted(rotated) {
+		t.Fatalf("a deleted device is still accepted: %d: %s", resp.Status, resp.Raw)
+	}
+	if resp = h.Request("DELETE", "/api/v1/devices/"+deviceID, nil, admin); resp.ErrorCode() != response.CodeDeviceNotFound {
+		t.Fatalf("deleting twice: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestScanBatch(t *testing.T) {
+	h := testutil.New(t)
+	sales := seedSales(h)
+	kiosk := seedDevice(h, true)
+	first := seedOrder(h, sales, readyForQueue("qb-offline-1"))
+	second := seedOrder(h, sales, readyForQueue("qb-offline-2"))
+
+	// Submitted out of order; the earlier scan at the gate gets the earlier number
+	now := time.Now()
+	scans := []map[string]interface{}{
+		{"client_id": "scan-2", "barcode": "qb-offline-2", "scanned_at": now.Add(-5 * time.Minute)},
+		{"client_id": "scan-1", "barcode": "qb-offline-1", "scanned_at": now.Add(-10 * time.Minute)},
+		{"client_id": "scan-3", "barcode": "qb-offline-1", "scanned_at": now.Add(-2 * time.Minute)},
+		{"client_id": "scan-4", "barcode": "qb-unknown", "scanned_at": now.Add(-time.Minute)},
+		{"client_id": "scan-5", "scanned_at": now.Add(-time.Minute)},
+	}
+	resp := h.Request("POST", "/api/v1/queue/scan/batch", map[string]interface{}{"scans": scans}, kiosk)
+	if resp.Status != 200 || resp.Data()["queued"] != 2.0 || resp.Data()["conflicts"] != 1.0 || resp.Data()["rejected"] != 2.0 {
+		t.Fatalf("batch: got %d: %s", resp.Status, resp.Raw)
+	}
+	results := map[string]map[string]interface{}{}
+	for _, raw := range resp.Data()["results"].([]interface{}) {
+		result := raw.(map[string]interface{})
+		results[result["client_id"].(string)] = result
+	}
+	if results["scan-1"]["queue_number"] != 1.0 || results["scan-2"]["queue_number"] != 2.0 {
+		t.Fatalf("queue numbers: %v", results)
+	}
+	if results["scan-3"]["result"] != models.ScanResultConflict || results["scan-3"]["order_id"] != first.ID.Hex() {
+		t.Fatalf("second scan of a barcode: %v", results["scan-3"])
+	}
+
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": second.ID}, stored)
+	if stored.QueueEnteredAt == nil || stored.QueueEnteredAt.Sub(now.Add(-5*time.Minute)).Abs() > time.Second {
+		t.Fatalf("queue entered at %v, want the scan time", stored.QueueEnteredAt)
+	}
+
+	// A resubmitted scan returns its original result without queueing again
+	resp = h.Request("POST", "/api/v1/queue/scan/batch", map[string]interface{}{"scans": scans[1:2]}, kiosk)
+	replay, _ := resp.Data()["results"].([]interface{})
+	if resp.Status != 200 || len(replay) != 1 || replay[0].(map[string]interface{})["duplicate"] != true || replay[0].(map[string]interface{})["queue_number"] != 1.0 {
+		t.Fatalf("resubmit: got %d: %s", resp.Status, resp.Raw)
+	}
+	if n := h.Count("orders", bson.M{"status": models.OrderStatusQueued}); n != 2 {
+		t.Fatalf("%d orders queued, want 2", n)
+	}
+
+	if resp = h.Request("POST", "/api/v1/queue/scan/batch", map[string]interface{}{"scans": []interface{}{}}, kiosk); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("empty batch: got %d %q", resp.Status, resp.ErrorCode())
+	}
+}
+
+func TestShipmentTracking(t *testing.T) {
+	h := testutil.New(t)
+	admin := h.Token(models.RoleAdmin)
+	sales := seedSales(h)
+	order := seedOrder(h, sales, func(order *models.Order) {
+		order.Status = models.OrderStatusLoading
+		order.PaymentStatus = models.PaymentStatusVerified
+		order.DeliveryAddress = "Jl. Mawar 1, Bekasi"
+		order.DriverName, order.DriverPhone, order.VehiclePlate = "Andi", "6289876543210", "B 1234 CD"
+	})
+	pickup := seedOrder(h, sales, func(order *models.Order) { order.Status = models.OrderStatusLoading })
+	locationPath := "/api/v1/client/driver/" + order.InvoiceToken + "/location"
+	ping := map[string]interface{}{"lat": -6.24, "lng": 106.99, "accuracy": 12}
+
+	if resp := h.Request("POST", locationPath, ping, ""); resp.ErrorCode() != response.CodeOrderInvalidStatus {
+		t.Fatalf("ping before dispatch: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	// Orders delivered to site are dispatched once loaded, pickups are not
+	for _, loaded := range []*models.Order{order, pickup} {
+		if resp := h.Request("POST", "/api/v1/orders/"+loaded.ID.Hex()+"/finish-loading", nil, admin); resp.Status != 200 {
+			t.Fatalf("finish loading: got %d: %s", resp.Status, resp.Raw)
+		}
+	}
+	stored := &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.DispatchedAt == nil {
+		t.Fatal("delivery order not dispatched")
+	}
+	stored = &models.Order{}
+	h.Find("orders", bson.M{"_id": pickup.ID}, stored)
+	if stored.DispatchedAt != nil {
+		t.Fatal("pickup order dispatched")
+	}
+
+	shipmentPath := "/api/v1/orders/" + order.ID.Hex() + "/shipment"
+	if resp := h.Request("PUT", shipmentPath, map[string]string{"delivery_eta": "tomorrow"}, admin); resp.ErrorCode() != response.CodeValidationFailed {
+		t.Fatalf("invalid eta: got %d: %s", resp.Status, resp.Raw)
+	}
+	eta := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+	resp := h.Request("PUT", shipmentPath, map[string]string{"delivery_eta": eta.Format(time.RFC3339)}, admin)
+	if resp.Status != 200 || resp.Data()["delivery_eta"] != eta.Format(time.RFC3339) || resp.Data()["delivery_address"] != "Jl. Mawar 1, Bekasi" {
+		t.Fatalf("set eta: got %d: %s", resp.Status, resp.Raw)
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"lat": -6.24},
+		{"lat": -96.0, "lng": 106.99},
+		{"lat": -6.24, "lng": 106.99, "eta_minutes": -5},
+	} {
+		if resp := h.Request("POST", locationPath, body, ""); resp.ErrorCode() != response.CodeValidationFailed {
+			t.Fatalf("%v: got %d: %s", body, resp.Status, resp.Raw)
+		}
+	}
+	ping["eta_minutes"] = 30
+	resp = h.Request("POST", locationPath, ping, "")
+	location, _ := resp.Data()["driver_location"].(map[string]interface{})
+	if resp.Status != 200 || location["lat"] != -6.24 || location["accuracy"] != 12.0 {
+		t.Fatalf("ping: got %d: %s", resp.Status, resp.Raw)
+	}
+	stored = &models.Order{}
+	h.Find("orders", bson.M{"_id": order.ID}, stored)
+	if stored.DeliveryETA == nil || stored.DeliveryETA.Sub(time.Now()) < 29*time.Minute || stored.DeliveryETA.Sub(time.Now()) > 31*time.Minute {
+		t.Fatalf("revised eta = %v", stored.DeliveryETA)
+	}
+
+	resp = h.Request("GET", "/api/v1/client/status/"+order.InvoiceToken, nil, "")
+	shipment, _ := resp.Data()["shipment"].(map[string]interface{})
+	if resp.Status != 200 || shipment["driver_location"] == nil || shipment["dispatched_at"] == nil {
+		t.Fatalf("tracking page: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("GET", "/api/v1/client/status/"+pickup.InvoiceToken, nil, ""); resp.Data()["shipment"] != nil {
+		t.Fatalf("pickup tracking: %s", resp.Raw)
+	}
+
+	ping["arrived"] = true
+	if resp := h.Request("POST", locationPath, ping, ""); resp.Status != 200 || resp.Data()["arrived_at"] == nil {
+		t.Fatalf("arrived: got %d: %s", resp.Status, resp.Raw)
+	}
+	if resp := h.Request("POST", locationPath, ping, ""); resp.ErrorCode() != response.CodeOrderInvalidStatus {
+		t.Fatalf("ping after arrival: got %d: %s", resp.Status, resp.Raw)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/ticket"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ticket renders the queue ticket of an order for printing (?format=zpl|escpos|pdf)
+func (h *QueueHandler) Ticket(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	format := c.Query("format", ticket.FormatPDF)
+	if format != ticket.FormatZPL && format != ticket.FormatESCPOS && format != ticket.FormatPDF {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Format must be zpl, escpos or pdf")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if order.QueueNumber == 0 || order.QueueToken == "" {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order has not entered the queue")
+	}
+
+	enteredAt := time.Now()
+	if order.QueueEnteredAt != nil {
+		enteredAt = *order.QueueEnteredAt
+	}
+
+	t := ticket.Ticket{
+		Company:      loadCompanySettings(ctx).Name,
+		QueueNumber:  order.QueueNumber,
+		OrderNumber:  order.OrderNumber,
+		VehiclePlate: order.VehiclePlate,
+		DriverName:   order.DriverName,
+		QRContent:    order.QueueToken,
+		EnteredAt:    enteredAt,
+	}
+
+	fileName := fmt.Sprintf("antrian-%d", order.QueueNumber)
+
+	switch format {
+	case ticket.FormatZPL:
+		c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.zpl"`, fileName))
+		return c.SendString(ticket.ZPL(t))
+	case ticket.FormatESCPOS:
+		c.Set(fiber.HeaderContentType, "application/octet-stream")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.bin"`, fileName))
+		return c.Send(ticket.ESCPOS(t))
+	}
+
+	body, err := ticket.PDF(t)
+	if err != nil {
+		return response.Error(c, 500, "Failed to render ticket")
+	}
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.pdf"`, fileName))
+	return c.Send(body)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WalkInRequest is a truck that arrives for a cash purchase without an order
+type WalkInRequest struct {
+	SalesID       string       `json:"sales_id"`
+	CustomerName  string       `json:"customer_name"`
+	CustomerPhone string       `json:"customer_phone"`
+	Items         []CreateItem `json:"items"` // Catalog products; unit price defaults to the catalog price
+
+	DriverName   string `json:"driver_name"`
+	DriverPhone  string `json:"driver_phone"`
+	VehiclePlate string `json:"vehicle_plate"`
+}
+
+// WalkIn creates the order of a walk-in truck, paid in cash at the counter, verifies the
+// payment and puts it in the queue in one step. A payment above the approval threshold
+// waits for a second approval instead, and the truck is scanned in afterwards. The order
+// is flagged walk_in for reporting.
+func (h *QueueHandler) WalkIn(c *fiber.Ctx) error {
+	var req WalkInRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.SalesID == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Sales is required")
+	}
+	if len(req.Items) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "At least one item is required")
+	}
+	req.DriverName = strings.TrimSpace(req.DriverName)
+	req.DriverPhone = strings.TrimSpace(req.DriverPhone)
+	req.VehiclePlate = strings.TrimSpace(req.VehiclePlate)
+	if req.DriverName == "" || req.DriverPhone == "" || req.VehiclePlate == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Driver name, phone, and vehicle plate are required")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	salesObjID, _ := primitive.ObjectIDFromHex(req.SalesID)
+	sales := &models.Sales{}
+	if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales); err != nil {
+		return response.ErrorCode(c, 400, response.CodeSalesNotFound, "Sales not found")
+	}
+
+	built, err := buildOrderItems(c, ctx, req.Items, true)
+	if built == nil {
+		return err
+	}
+	// The payment is verified on the spot, so every item must be a catalog product sold
+	// at a price; a name missing from the catalog would be priced at 0
+	for _, item := range built.Items {
+		if item.ListPrice <= 0 || item.UnitPrice <= 0 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed,
+				fmt.Sprintf("%s is not a priced catalog product", item.ProductName))
+		}
+	}
+
+	userID := middleware.GetUserID(c)
+	now := time.Now()
+
+	order := models.NewOrder()
+	order.SalesID = req.SalesID
+	order.CustomerName = req.CustomerName
+	order.CustomerPhone = req.CustomerPhone
+	order.WalkIn = true
+	order.StatusHistory[0].ChangedBy = userID
+	order.Items = built.Items
+	order.Quantity = built.TotalQuantity
+	order.UnitPrice = built.TotalPrice / float64(built.TotalQuantity)
+	order.Subtotal = built.TotalPrice
+	order.TotalPrice = built.TotalPrice
+
+	// Paid in cash at the counter, so the order skips the invoice; the payment is still
+	// verified below, under the approval threshold like any other
+	order.Status = models.OrderStatusPending
+	order.PaymentStatus = models.PaymentStatusPending
+	order.DriverName = req.DriverName
+	order.DriverPhone = req.DriverPhone
+	order.VehiclePlate = req.VehiclePlate
+	order.DriverFilledAt = &now
+	order.InvoiceToken = generateToken(32)
+	order.InvoiceURL = fmt.Sprintf("%s/order/%s", config.Cfg.Client.URL, order.InvoiceToken)
+
+	// The barcode lets the truck be scanned in later, once a second approval is given or
+	// should queueing fail below
+	fields, err := queueBarcodeFields(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to generate QR code")
+	}
+	order.QueueBarcode = fields["queue_barcode"].(string)
+	order.QueueQRCode = fields["queue_qrcode"].(string)
+	order.BarcodeFormat = fields["barcode_format"].(string)
+	order.BarcodeImage = fields["barcode_image"].(string)
+
+	order.OrderNumber, err = generateOrderNumber(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to allocate order number")
+	}
+	err = database.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := database.GetMongoCollection("orders").InsertOne(txCtx, order); err != nil {
+			return err
+		}
+		if len(built.Warnings) > 0 {
+			overrides := priceOverrides(c, order, built.Overridden, built.Warnings)
+			if _, err := database.GetMongoCollection(priceOverrideCollection).InsertMany(txCtx, overrides); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		releaseNumber(order.OrderNumber, err)
+		return writeFailed(c, err, "Failed to create order")
+	}
+
+	audit.Log(c, audit.ActionQueueWalkIn, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number":  order.OrderNumber,
+		"total_price":   order.TotalPrice,
+		"vehicle_plate": order.VehiclePlate,
+	})
+	if len(built.Warnings) > 0 {
+		audit.Log(c, audit.ActionPriceOverride, "order", order.ID.Hex(), map[string]interface{}{
+			"order_number":   order.OrderNumber,
+			"price_warnings": built.Warnings,
+		})
+	}
+	media.SignOrder(order)
+	order.Sales = sales
+
+	// Above the approval threshold the truck waits for a second approver before queueing.
+	// Without the policy the order stays pending payment instead of being verified.
+	second, err := needsSecondApproval(ctx, c, order)
+	if err != nil {
+		return approvalPolicyUnavailable(c)
+	}
+	if second {
+		if approved, err := approveFirst(ctx, c, order); !approved {
+			return err
+		}
+		order.PaymentStatus = models.PaymentStatusApproval
+		return response.Success(c, 202, fiber.Map{
+			"message":        "Walk-in order created, waiting for a second payment approval",
+			"order":          order,
+			"price_warnings": built.Warnings,
+		})
+	}
+	if verified, err := verifyPayment(ctx, c, order); !verified {
+		return err
+	}
+	order.Status = models.OrderStatusConfirmed
+	order.PaymentStatus = models.PaymentStatusVerified
+
+	queued, rejected := enterQueue(c, ctx, order.QueueBarcode, now)
+	if rejected != nil {
+		return response.ErrorCodeWithData(c, rejected.Status, rejected.Code, rejected.Message, fiber.Map{
+			"order": order,
+		})
+	}
+	media.SignOrder(queued)
+	queued.Sales = sales
+
+	return response.Success(c, 201, fiber.Map{
+		"message":        "Walk-in order created and queued",
+		"queue_number":   queued.QueueNumber,
+		"estimated_time": queued.EstimatedTime,
+		"order":          queued,
+		"price_warnings": built.Warnings,
+	})
+}
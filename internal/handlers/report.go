@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReportHandler handles management report routes
+type ReportHandler struct{}
+
+// NewReportHandler creates a new report handler
+func NewReportHandler() *ReportHandler {
+	return &ReportHandler{}
+}
+
+// funnelStages is the happy path an order moves through
+var funnelStages = []string{
+	models.OrderStatusPending,
+	models.OrderStatusPaid,
+	models.OrderStatusConfirmed,
+	models.OrderStatusQueued,
+	models.OrderStatusLoading,
+	models.OrderStatusCompleted,
+}
+
+// stageTimes returns when an order first reached each status.
+// Orders created before status history existed fall back to their timestamps.
+func stageTimes(order *models.Order) map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, change := range order.StatusHistory {
+		if _, ok := times[change.Status]; !ok {
+			times[change.Status] = change.ChangedAt
+		}
+	}
+
+	fallback := map[string]*time.Time{
+		models.OrderStatusPending:   &order.CreatedAt,
+		models.OrderStatusPaid:      order.PaymentUploadedAt,
+		models.OrderStatusConfirmed: order.PaymentVerifiedAt,
+		models.OrderStatusQueued:    order.QueueEnteredAt,
+		models.OrderStatusLoading:   order.LoadingStartedAt,
+		models.OrderStatusCompleted: order.CompletedAt,
+	}
+	if order.LoadingStartedAt == nil {
+		fallback[models.OrderStatusLoading] = order.QueueCalledAt
+	}
+	for status, t := range fallback {
+		if _, ok := times[status]; !ok && t != nil && !t.IsZero() {
+			times[status] = *t
+		}
+	}
+
+	return times
+}
+
+// Funnel returns how far orders created in a date range got through the pipeline
+func (h *ReportHandler) Funnel(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	filter := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+	projection := bson.M{"items": 0, "queue_qrcode": 0, "sales": 0, "product": 0}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch orders")
+	}
+	defer cursor.Close(ctx)
+
+	reached := make([]int, len(funnelStages))
+	current := make([]int, len(funnelStages))
+	dwellTotal := make([]float64, len(funnelStages))
+	dwellCount := make([]int, len(funnelStages))
+	total := 0
+	cancelled := 0
+
+	for cursor.Next(ctx) {
+		order := &models.Order{}
+		if err := cursor.Decode(order); err != nil {
+			continue
+		}
+		total++
+
+		if order.Status == models.OrderStatusCancelled {
+			cancelled++
+		}
+
+		times := stageTimes(order)
+		for i, stage := range funnelStages {
+			at, ok := times[stage]
+			if !ok {
+				continue
+			}
+			reached[i]++
+			if order.Status == stage {
+				current[i]++
+			}
+			if i+1 < len(funnelStages) {
+				if next, ok := times[funnelStages[i+1]]; ok && next.After(at) {
+					dwellTotal[i] += next.Sub(at).Minutes()
+					dwellCount[i]++
+				}
+			}
+		}
+	}
+
+	type Stage struct {
+		Status           string  `json:"status"`
+		Reached          int     `json:"reached"`
+		Current          int     `json:"current"`
+		ConversionRate   float64 `json:"conversion_rate"`
+		OverallRate      float64 `json:"overall_rate"`
+		AvgDwellMinutes  float64 `json:"avg_dwell_minutes"`
+		DwellSampleCount int     `json:"dwell_sample_count"`
+	}
+
+	stages := make([]Stage, len(funnelStages))
+	for i, status := range funnelStages {
+		stage := Stage{
+			Status:           status,
+			Reached:          reached[i],
+			Current:          current[i],
+			DwellSampleCount: dwellCount[i],
+		}
+		if i == 0 {
+			if reached[0] > 0 {
+				stage.ConversionRate = 100
+			}
+		} else if reached[i-1] > 0 {
+			stage.ConversionRate = percent(reached[i], reached[i-1])
+		}
+		if reached[0] > 0 {
+			stage.OverallRate = percent(reached[i], reached[0])
+		}
+		if dwellCount[i] > 0 {
+			stage.AvgDwellMinutes = float64(int(dwellTotal[i]/float64(dwellCount[i])*10)) / 10
+		}
+		stages[i] = stage
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"from":      from,
+		"to":        to,
+		"total":     total,
+		"cancelled": cancelled,
+		"stages":    stages,
+	})
+}
+
+// QueueHeatmap counts queue arrivals by weekday and hour of day over a date range.
+// Hours are bucketed in the "tz" query timezone (default Asia/Jakarta).
+func (h *ReportHandler) QueueHeatmap(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	tz := c.Query("tz", "Asia/Jakarta")
+	if _, err := time.LoadLocation(tz); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid timezone")
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"queue_entered_at": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"weekday": bson.M{"$dayOfWeek": bson.M{"date": "$queue_entered_at", "timezone": tz}},
+				"hour":    bson.M{"$hour": bson.M{"date": "$queue_entered_at", "timezone": tz}},
+			},
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return response.Error(c, 500, "Failed to aggregate queue arrivals")
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID struct {
+			Weekday int `bson:"weekday"`
+			Hour    int `bson:"hour"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return response.Error(c, 500, "Failed to aggregate queue arrivals")
+	}
+
+	// grid[weekday][hour], weekday 0 = Sunday to match time.Weekday
+	grid := make([][]int, 7)
+	for i := range grid {
+		grid[i] = make([]int, 24)
+	}
+	byWeekday := make([]int, 7)
+	byHour := make([]int, 24)
+	total := 0
+	peakDay, peakHour, peakCount := 0, 0, 0
+
+	for _, row := range rows {
+		// $dayOfWeek is 1 (Sunday) to 7 (Saturday)
+		day := row.ID.Weekday - 1
+		if day < 0 || day > 6 || row.ID.Hour < 0 || row.ID.Hour > 23 {
+			continue
+		}
+		grid[day][row.ID.Hour] += row.Count
+		byWeekday[day] += row.Count
+		byHour[row.ID.Hour] += row.Count
+		total += row.Count
+		if row.Count > peakCount {
+			peakDay, peakHour, peakCount = day, row.ID.Hour, row.Count
+		}
+	}
+
+	weekdays := make([]string, 7)
+	for i := range weekdays {
+		weekdays[i] = time.Weekday(i).String()
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"from":       from,
+		"to":         to,
+		"timezone":   tz,
+		"total":      total,
+		"weekdays":   weekdays,
+		"grid":       grid,
+		"by_weekday": byWeekday,
+		"by_hour":    byHour,
+		"peak":       fiber.Map{"weekday": peakDay, "hour": peakHour, "count": peakCount},
+	})
+}
+
+// percent returns part/whole as a percentage rounded to one decimal
+func percent(part int, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(int(float64(part)/float64(whole)*1000)) / 10
+}
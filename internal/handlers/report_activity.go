@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserActivity is one admin's workload over a period
+type UserActivity struct {
+	UserID               string   `json:"user_id"`
+	Username             string   `json:"username"`
+	DisplayName          string   `json:"display_name"`
+	Role                 string   `json:"role"`
+	OrdersCreated        int      `json:"orders_created"`
+	PaymentsVerified     int      `json:"payments_verified"`
+	PaymentsRejected     int      `json:"payments_rejected"`
+	DeliveryNotesIssued  int      `json:"delivery_notes_issued"`
+	TotalActions         int      `json:"total_actions"`
+	WorkloadShare        float64  `json:"workload_share"` // Percentage of all actions in the period
+	AvgTurnaroundSeconds *float64 `json:"avg_verification_turnaround_seconds"`
+	turnaroundSum        float64
+	turnaroundCount      int
+}
+
+// UserActivity summarizes audit log actions per admin over a date range:
+// orders created, payments reviewed, delivery notes issued and how long payment reviews took
+func (h *ReportHandler) UserActivity(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	collection := database.GetMongoCollection("audit_logs")
+	ctx, cancel := requestContext(c, 15*time.Second)
+	defer cancel()
+
+	match := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}, "actor_id": bson.M{"$ne": ""}}
+
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":   bson.M{"actor_id": "$actor_id", "action": "$action"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to aggregate user activity")
+	}
+	var counts []struct {
+		ID struct {
+			ActorID string `bson:"actor_id"`
+			Action  string `bson:"action"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return response.Error(c, 500, "Failed to decode user activity")
+	}
+
+	// Turnaround is recorded on review entries; older entries fall back to the order's upload time
+	cursor, err = collection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"created_at": match["created_at"],
+			"action":     bson.M{"$in": []string{audit.ActionPaymentVerify, audit.ActionPaymentReject}},
+		}},
+		{"$lookup": bson.M{
+			"from": "orders",
+			"let":  bson.M{"order_id": bson.M{"$convert": bson.M{"input": "$entity_id", "to": "objectId", "onError": nil, "onNull": nil}}},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []string{"$_id", "$$order_id"}}}},
+				{"$project": bson.M{"payment_uploaded_at": 1}},
+			},
+			"as": "order",
+		}},
+		{"$project": bson.M{
+			"actor_id": 1,
+			"seconds": bson.M{"$ifNull": []interface{}{
+				"$details.turnaround_seconds",
+				bson.M{"$divide": []interface{}{
+					bson.M{"$subtract": []interface{}{"$created_at", bson.M{"$arrayElemAt": []interface{}{"$order.payment_uploaded_at", 0}}}},
+					1000,
+				}},
+			}},
+		}},
+		{"$match": bson.M{"seconds": bson.M{"$gte": 0}}},
+		{"$group": bson.M{
+			"_id":   "$actor_id",
+			"sum":   bson.M{"$sum": "$seconds"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to aggregate verification turnaround")
+	}
+	var turnarounds []struct {
+		ActorID string  `bson:"_id"`
+		Sum     float64 `bson:"sum"`
+		Count   int     `bson:"count"`
+	}
+	if err := cursor.All(ctx, &turnarounds); err != nil {
+		return response.Error(c, 500, "Failed to decode verification turnaround")
+	}
+
+	activity := map[string]*UserActivity{}
+	get := func(userID string) *UserActivity {
+		if activity[userID] == nil {
+			activity[userID] = &UserActivity{UserID: userID}
+		}
+		return activity[userID]
+	}
+
+	totalActions := 0
+	for _, row := range counts {
+		user := get(row.ID.ActorID)
+		switch row.ID.Action {
+		case audit.ActionOrderCreate:
+			user.OrdersCreated += row.Count
+		case audit.ActionPaymentVerify:
+			user.PaymentsVerified += row.Count
+		case audit.ActionPaymentReject:
+			user.PaymentsRejected += row.Count
+		case audit.ActionDeliveryCreate:
+			user.DeliveryNotesIssued += row.Count
+		}
+		user.TotalActions += row.Count
+		totalActions += row.Count
+	}
+
+	var teamSum float64
+	teamCount := 0
+	for _, row := range turnarounds {
+		user := get(row.ActorID)
+		user.turnaroundSum, user.turnaroundCount = row.Sum, row.Count
+		teamSum += row.Sum
+		teamCount += row.Count
+	}
+
+	// Attach names; actors that are not users (e.g. kiosk devices) keep their ID only
+	objIDs := []primitive.ObjectID{}
+	for userID := range activity {
+		if objID, err := primitive.ObjectIDFromHex(userID); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	if len(objIDs) > 0 {
+		users := []models.User{}
+		cursor, err := database.GetMongoCollection("users").Find(ctx, bson.M{"_id": bson.M{"$in": objIDs}})
+		if err != nil {
+			return response.Error(c, 500, "Failed to fetch users")
+		}
+		if err := cursor.All(ctx, &users); err != nil {
+			return response.Error(c, 500, "Failed to decode users")
+		}
+		for _, user := range users {
+			if entry := activity[user.ID.Hex()]; entry != nil {
+				entry.Username = user.Username
+				entry.DisplayName = user.DisplayName
+				entry.Role = user.Role
+			}
+		}
+	}
+
+	rows := make([]*UserActivity, 0, len(activity))
+	for _, user := range activity {
+		user.WorkloadShare = percent(user.TotalActions, totalActions)
+		user.AvgTurnaroundSeconds = average(user.turnaroundSum, user.turnaroundCount)
+		rows = append(rows, user)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].TotalActions != rows[j].TotalActions {
+			return rows[i].TotalActions > rows[j].TotalActions
+		}
+		return rows[i].UserID < rows[j].UserID
+	})
+
+	team := UserActivity{TotalActions: totalActions, WorkloadShare: 100, AvgTurnaroundSeconds: average(teamSum, teamCount)}
+	for _, user := range rows {
+		team.OrdersCreated += user.OrdersCreated
+		team.PaymentsVerified += user.PaymentsVerified
+		team.PaymentsRejected += user.PaymentsRejected
+		team.DeliveryNotesIssued += user.DeliveryNotesIssued
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"from":  from,
+		"to":    to,
+		"users": rows,
+		"team":  team,
+	})
+}
+
+// average returns sum/count rounded to one decimal, or nil when there is nothing to average
+func average(sum float64, count int) *float64 {
+	if count == 0 {
+		return nil
+	}
+	value := float64(int(sum/float64(count)*10)) / 10
+	return &value
+}
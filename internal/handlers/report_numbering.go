@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/numbering"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxGapMonths limits how many months one numbering gaps report covers
+const maxGapMonths = 24
+
+// NumberGap is a run of missing sequential numbers. It is explained when every missing
+// number has a void record.
+type NumberGap struct {
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	Missing   int              `json:"missing"`
+	Voids     []numbering.Void `json:"voids"`
+	Explained bool             `json:"explained"`
+}
+
+// NumberDuplicate is a number used by more than one document
+type NumberDuplicate struct {
+	Number string   `json:"number"`
+	IDs    []string `json:"ids"`
+}
+
+// MonthNumbering is the numbering check of one series in one month
+type MonthNumbering struct {
+	Month       string            `json:"month"` // YYYY-MM
+	First       string            `json:"first,omitempty"`
+	Last        string            `json:"last,omitempty"`
+	Allocated   int               `json:"allocated"`
+	Used        int               `json:"used"`
+	Cancelled   int               `json:"cancelled,omitempty"` // Orders that keep their number but were cancelled or declined
+	Legacy      int               `json:"legacy"`              // Timestamp numbers from before sequential numbering
+	Gaps        []NumberGap       `json:"gaps"`
+	Unexplained int               `json:"unexplained"`
+	Duplicates  []NumberDuplicate `json:"duplicates"`
+}
+
+// numberedDoc is a document holding a number of the series
+type numberedDoc struct {
+	ID     string
+	Number string
+	Status string
+}
+
+// parseMonthRange parses "from" and "to" query params (YYYY-MM), both inclusive.
+// Defaults to the last 12 months. Months are Jakarta months, as numbers are allocated in.
+func parseMonthRange(c *fiber.Ctx) ([]time.Time, error) {
+	loc := utils.Jakarta()
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	from := to.AddDate(0, -11, 0)
+
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01", v, loc)
+		if err != nil {
+			return nil, errors.New("Invalid from month, use YYYY-MM")
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01", v, loc)
+		if err != nil {
+			return nil, errors.New("Invalid to month, use YYYY-MM")
+		}
+		to = t
+	}
+	if to.Before(from) {
+		return nil, errors.New("from must be before to")
+	}
+
+	months := []time.Time{}
+	for m := from; !m.After(to); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+		if len(months) > maxGapMonths {
+			return nil, errors.New("The range is limited to 24 months")
+		}
+	}
+	return months, nil
+}
+
+// numberedDocs loads the documents whose number matches the prefix pattern. Delivery note
+// revisions share their number, so they count once per root; archived orders are included.
+func numberedDocs(ctx context.Context, series, prefix string) ([]numberedDoc, error) {
+	docs := []numberedDoc{}
+	seen := map[string]bool{}
+	filter := bson.M{}
+
+	if series == numbering.SeriesDeliveryNote {
+		filter["note_number"] = bson.M{"$regex": "^" + prefix}
+		cursor, err := database.GetMongoCollection("delivery_notes").Find(ctx, filter,
+			options.Find().SetProjection(bson.M{"note_number": 1, "root_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var notes []models.DeliveryNote
+		err = cursor.All(ctx, &notes)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, note := range notes {
+			root := note.RootID
+			if root == "" {
+				root = note.ID.Hex()
+			}
+			if !seen[root] {
+				seen[root] = true
+				docs = append(docs, numberedDoc{ID: root, Number: note.NoteNumber})
+			}
+		}
+		return docs, nil
+	}
+
+	filter["order_number"] = bson.M{"$regex": "^" + prefix}
+	for _, name := range []string{"orders", archive.Collection} {
+		cursor, err := database.GetMongoCollection(name).Find(ctx, filter,
+			options.Find().SetProjection(bson.M{"order_number": 1, "status": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var orders []models.Order
+		err = cursor.All(ctx, &orders)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// An order being archived can briefly exist in both collections
+		for _, order := range orders {
+			if id := order.ID.Hex(); !seen[id] {
+				seen[id] = true
+				docs = append(docs, numberedDoc{ID: id, Number: order.OrderNumber, Status: order.Status})
+			}
+		}
+	}
+	return docs, nil
+}
+
+// checkMonth finds the gaps and duplicates of a series in one month
+func checkMonth(ctx context.Context, series string, month time.Time) (*MonthNumbering, error) {
+	key := month.Format("200601")
+	docs, err := numberedDocs(ctx, series, series+"-"+key)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MonthNumbering{
+		Month:      month.Format("2006-01"),
+		Gaps:       []NumberGap{},
+		Duplicates: []NumberDuplicate{},
+	}
+
+	bySeq := map[int][]string{}
+	byLegacy := map[string][]string{}
+	maxSeq := 0
+	for _, doc := range docs {
+		if doc.Status == models.OrderStatusCancelled || doc.Status == models.OrderStatusDeclined {
+			result.Cancelled++
+		}
+		_, numberMonth, seq, ok := numbering.Parse(doc.Number)
+		if !ok {
+			result.Legacy++
+			byLegacy[doc.Number] = append(byLegacy[doc.Number], doc.ID)
+			continue
+		}
+		if numberMonth != key {
+			continue
+		}
+		bySeq[seq] = append(bySeq[seq], doc.ID)
+		maxSeq = max(maxSeq, seq)
+	}
+
+	allocated, err := numbering.Allocated(ctx, series, key)
+	if err != nil {
+		return nil, err
+	}
+	result.Used = len(bySeq)
+	result.Allocated = max(allocated, maxSeq)
+	if result.Used > 0 {
+		seqs := make([]int, 0, len(bySeq))
+		for seq := range bySeq {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		result.First = numbering.Format(series, key, seqs[0])
+		result.Last = numbering.Format(series, key, seqs[len(seqs)-1])
+	}
+
+	// Gaps run up to the last allocated number, so trailing unused numbers count too
+	recorded, err := numbering.Voids(ctx, series, key)
+	if err != nil {
+		return nil, err
+	}
+	voids := map[int]numbering.Void{}
+	for _, void := range recorded {
+		voids[void.Seq] = void
+	}
+	for seq := 1; seq <= result.Allocated; seq++ {
+		if len(bySeq[seq]) > 0 {
+			continue
+		}
+		start := seq
+		for seq+1 <= result.Allocated && len(bySeq[seq+1]) == 0 {
+			seq++
+		}
+		gap := NumberGap{
+			From:      numbering.Format(series, key, start),
+			To:        numbering.Format(series, key, seq),
+			Missing:   seq - start + 1,
+			Voids:     []numbering.Void{},
+			Explained: true,
+		}
+		for missing := start; missing <= seq; missing++ {
+			if void, ok := voids[missing]; ok {
+				gap.Voids = append(gap.Voids, void)
+			} else {
+				gap.Explained = false
+				result.Unexplained++
+			}
+		}
+		result.Gaps = append(result.Gaps, gap)
+	}
+
+	for seq, ids := range bySeq {
+		if len(ids) > 1 {
+			result.Duplicates = append(result.Duplicates, NumberDuplicate{Number: numbering.Format(series, key, seq), IDs: ids})
+		}
+	}
+	for number, ids := range byLegacy {
+		if len(ids) > 1 {
+			result.Duplicates = append(result.Duplicates, NumberDuplicate{Number: number, IDs: ids})
+		}
+	}
+	sort.Slice(result.Duplicates, func(i, j int) bool { return result.Duplicates[i].Number < result.Duplicates[j].Number })
+
+	return result, nil
+}
+
+// NumberingGaps checks each month of a document series (series=SJ, the default, or ORD)
+// for missing and duplicate numbers. Gaps with a void record are explained.
+func (h *ReportHandler) NumberingGaps(c *fiber.Ctx) error {
+	series := c.Query("series", numbering.SeriesDeliveryNote)
+	if series != numbering.SeriesDeliveryNote && series != numbering.SeriesOrder {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Series must be SJ or ORD")
+	}
+	months, err := parseMonthRange(c)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	results := []*MonthNumbering{}
+	gaps, unexplained, duplicates := 0, 0, 0
+	for _, month := range months {
+		result, err := checkMonth(ctx, series, month)
+		if err != nil {
+			return response.Error(c, 500, "Failed to check numbering")
+		}
+		gaps += len(result.Gaps)
+		unexplained += result.Unexplained
+		duplicates += len(result.Duplicates)
+		results = append(results, result)
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"series": series,
+		"months": results,
+		"summary": fiber.Map{
+			"gaps":        gaps,
+			"unexplained": unexplained,
+			"duplicates":  duplicates,
+		},
+	})
+}
+
+// VoidNumber records an allocated but unused number as void with the reason, e.g. a
+// spoiled printed delivery note, so the gap it leaves is explained
+func (h *ReportHandler) VoidNumber(c *fiber.Ctx) error {
+	type VoidRequest struct {
+		Number string `json:"number"`
+		Reason string `json:"reason"`
+	}
+
+	var req VoidRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Reason is required")
+	}
+	series, month, seq, ok := numbering.Parse(strings.TrimSpace(req.Number))
+	if !ok || (series != numbering.SeriesDeliveryNote && series != numbering.SeriesOrder) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Number must look like SJ-YYYYMM-0001 or ORD-YYYYMM-0001")
+	}
+	number := numbering.Format(series, month, seq)
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	allocated, err := numbering.Allocated(ctx, series, month)
+	if err != nil {
+		return response.Error(c, 500, "Failed to check number")
+	}
+	if seq > allocated {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Number has not been allocated yet")
+	}
+	docs, err := numberedDocs(ctx, series, number+"$")
+	if err != nil {
+		return response.Error(c, 500, "Failed to check number")
+	}
+	if len(docs) > 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "Number is used by a document")
+	}
+	voids, err := numbering.Voids(ctx, series, month)
+	if err != nil {
+		return response.Error(c, 500, "Failed to check number")
+	}
+	for _, void := range voids {
+		if void.Seq == seq {
+			return response.ErrorCode(c, 409, response.CodeConflict, "Number is already void")
+		}
+	}
+
+	reason := req.Reason + " (by " + middleware.GetUserID(c) + ")"
+	if err := numbering.Release(ctx, number, reason); err != nil {
+		return response.Error(c, 500, "Failed to record void number")
+	}
+
+	audit.Log(c, audit.ActionNumberVoid, "numbering", number, map[string]interface{}{
+		"reason": req.Reason,
+	})
+
+	return response.SuccessWithMessage(c, 201, "Number recorded as void")
+}
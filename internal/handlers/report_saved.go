@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/reportbuilder"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReportDefinitionRequest is the body for saving or previewing a report definition
+type ReportDefinitionRequest struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Filter      models.ReportFilter      `json:"filter"`
+	GroupBy     []string                 `json:"group_by"`
+	Metrics     []string                 `json:"metrics"`
+	PeriodDays  int                      `json:"period_days"`
+	Schedule    *models.ReportSchedule   `json:"schedule"`
+	Recipients  []models.ReportRecipient `json:"recipients"`
+}
+
+// apply copies the request onto a definition and validates the result
+func (req *ReportDefinitionRequest) apply(def *models.ReportDefinition) error {
+	def.Name = req.Name
+	def.Description = req.Description
+	def.Filter = req.Filter
+	def.GroupBy = req.GroupBy
+	def.Metrics = req.Metrics
+	def.Schedule = req.Schedule
+	def.Recipients = req.Recipients
+	if req.PeriodDays > 0 {
+		def.PeriodDays = req.PeriodDays
+	}
+	if def.GroupBy == nil {
+		def.GroupBy = []string{}
+	}
+	if def.Recipients == nil {
+		def.Recipients = []models.ReportRecipient{}
+	}
+
+	if err := reportbuilder.Validate(def); err != nil {
+		return err
+	}
+	if def.Schedule != nil {
+		def.Schedule.NextRunAt = reportbuilder.NextRun(def.Schedule, time.Now())
+	}
+	return nil
+}
+
+// ListSaved returns all saved report definitions
+func (h *ReportHandler) ListSaved(c *fiber.Ctx) error {
+	collection := database.GetMongoCollection(reportbuilder.Collection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch reports")
+	}
+	defer cursor.Close(ctx)
+
+	defs := []models.ReportDefinition{}
+	if err := cursor.All(ctx, &defs); err != nil {
+		return response.Error(c, 500, "Failed to decode reports")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"reports":  defs,
+		"group_by": reportbuilder.GroupBys,
+		"metrics":  reportbuilder.Metrics,
+	})
+}
+
+// GetSaved returns a saved report definition
+func (h *ReportHandler) GetSaved(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	def := &models.ReportDefinition{}
+	if err := database.GetMongoCollection(reportbuilder.Collection).FindOne(ctx, bson.M{"_id": objID}).Decode(def); err != nil {
+		return response.NotFoundCode(c, response.CodeReportNotFound, "Report not found")
+	}
+
+	return response.Success(c, 200, def)
+}
+
+// CreateSaved saves a new report definition
+func (h *ReportHandler) CreateSaved(c *fiber.Ctx) error {
+	var req ReportDefinitionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	def := models.NewReportDefinition()
+	if err := req.apply(def); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	def.CreatedBy = middleware.GetActorID(c)
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if _, err := database.GetMongoCollection(reportbuilder.Collection).InsertOne(ctx, def); err != nil {
+		return response.Error(c, 500, "Failed to save report")
+	}
+
+	audit.Log(c, audit.ActionReportCreate, "report", def.ID.Hex(), map[string]interface{}{
+		"name": def.Name,
+	})
+
+	return response.Success(c, 201, def)
+}
+
+// UpdateSaved replaces a saved report definition
+func (h *ReportHandler) UpdateSaved(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req ReportDefinitionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	collection := database.GetMongoCollection(reportbuilder.Collection)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	def := &models.ReportDefinition{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(def); err != nil {
+		return response.NotFoundCode(c, response.CodeReportNotFound, "Report not found")
+	}
+
+	if err := req.apply(def); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	def.UpdatedAt = time.Now()
+
+	if _, err := collection.ReplaceOne(ctx, bson.M{"_id": objID}, def); err != nil {
+		return response.Error(c, 500, "Failed to update report")
+	}
+
+	audit.Log(c, audit.ActionReportUpdate, "report", objID.Hex(), map[string]interface{}{
+		"name": def.Name,
+	})
+
+	return response.Success(c, 200, def)
+}
+
+// DeleteSaved deletes a report definition and its stored runs
+func (h *ReportHandler) DeleteSaved(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	result, err := database.GetMongoCollection(reportbuilder.Collection).DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return response.Error(c, 500, "Failed to delete report")
+	}
+	if result.DeletedCount == 0 {
+		return response.NotFoundCode(c, response.CodeReportNotFound, "Report not found")
+	}
+
+	database.GetMongoCollection(reportbuilder.RunsCollection).DeleteMany(ctx, bson.M{"report_id": objID.Hex()})
+
+	audit.Log(c, audit.ActionReportDelete, "report", objID.Hex(), nil)
+
+	return response.SuccessWithMessage(c, 200, "Successfully deleted")
+}
+
+// RunSaved runs a saved report now and stores the result.
+// The period defaults to the definition's; pass from/to to override it and deliver=true to send it.
+func (h *ReportHandler) RunSaved(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, bulkTimeout)
+	defer cancel()
+
+	def := &models.ReportDefinition{}
+	if err := database.GetMongoCollection(reportbuilder.Collection).FindOne(ctx, bson.M{"_id": objID}).Decode(def); err != nil {
+		return response.NotFoundCode(c, response.CodeReportNotFound, "Report not found")
+	}
+
+	from, to := reportbuilder.Period(def, time.Now())
+	if c.Query("from") != "" || c.Query("to") != "" {
+		if from, to, err = parseDateRange(c); err != nil {
+			return response.BadRequest(c, err.Error())
+		}
+	}
+
+	run, err := reportbuilder.Execute(ctx, def, models.ReportTriggerManual, middleware.GetActorID(c), from, to, c.QueryBool("deliver", false))
+	if err != nil {
+		return response.Error(c, 500, "Failed to run report")
+	}
+
+	return response.Success(c, 200, run)
+}
+
+// ListRuns returns the stored runs of a report, newest first
+func (h *ReportHandler) ListRuns(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	filter := bson.M{"report_id": c.Params("id")}
+
+	collection := database.GetMongoCollection(reportbuilder.RunsCollection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	findOptions := pq.findOptions().
+		SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch report runs")
+	}
+	defer cursor.Close(ctx)
+
+	runs := []models.ReportRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return response.Error(c, 500, "Failed to decode report runs")
+	}
+	runs, more := trimPage(pq, runs)
+
+	return response.SuccessWithPagination(c, 200, runs, pq.pagination(total, more))
+}
+
+// RunCSV downloads a stored run as CSV
+func (h *ReportHandler) RunCSV(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("run_id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	run := &models.ReportRun{}
+	filter := bson.M{"_id": objID, "report_id": c.Params("id")}
+	if err := database.GetMongoCollection(reportbuilder.RunsCollection).FindOne(ctx, filter).Decode(run); err != nil {
+		return response.NotFoundCode(c, response.CodeReportNotFound, "Report run not found")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="report-`+run.CreatedAt.Format("20060102-1504")+`.csv"`)
+	return c.Send(reportbuilder.CSV(run))
+}
+
+// Preview runs an unsaved definition over from/to (default: the definition's period)
+func (h *ReportHandler) Preview(c *fiber.Ctx) error {
+	var req ReportDefinitionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Name == "" {
+		req.Name = "Preview"
+	}
+
+	def := models.NewReportDefinition()
+	if err := req.apply(def); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	from, to := reportbuilder.Period(def, time.Now())
+	if c.Query("from") != "" || c.Query("to") != "" {
+		var err error
+		if from, to, err = parseDateRange(c); err != nil {
+			return response.BadRequest(c, err.Error())
+		}
+	}
+
+	ctx, cancel := requestContext(c, bulkTimeout)
+	defer cancel()
+
+	run, err := reportbuilder.Build(ctx, def, from, to)
+	if err != nil {
+		return response.Error(c, 500, "Failed to build report")
+	}
+
+	return response.Success(c, 200, run)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RequestLogHandler handles captured request log routes
+type RequestLogHandler struct{}
+
+// NewRequestLogHandler creates a new request log handler
+func NewRequestLogHandler() *RequestLogHandler {
+	return &RequestLogHandler{}
+}
+
+// Search returns captured requests, newest first.
+// Filters: method, path (prefix), status, min_status, user_id, q (text in bodies), from/to.
+func (h *RequestLogHandler) Search(c *fiber.Ctx) error {
+	pq := parsePage(c, 20, maxLogPageLimit)
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	filter := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+	if method := c.Query("method"); method != "" {
+		filter["method"] = strings.ToUpper(method)
+	}
+	if path := c.Query("path"); path != "" {
+		filter["path"] = bson.M{"$regex": "^" + regexp.QuoteMeta(path)}
+	}
+	if status := c.QueryInt("status", 0); status > 0 {
+		filter["status"] = status
+	} else if minStatus := c.QueryInt("min_status", 0); minStatus > 0 {
+		filter["status"] = bson.M{"$gte": minStatus}
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		filter["user_id"] = userID
+	}
+	if q := c.Query("q"); q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+		filter["$or"] = []bson.M{
+			{"request_body": pattern},
+			{"response_body": pattern},
+			{"query": pattern},
+		}
+	}
+
+	collection := database.GetMongoCollection(middleware.RequestLogCollection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	findOptions := pq.findOptions().
+		SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch request logs")
+	}
+	defer cursor.Close(ctx)
+
+	logs := []models.RequestLog{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		return response.Error(c, 500, "Failed to fetch request logs")
+	}
+	logs, more := trimPage(pq, logs)
+
+	return response.SuccessWithPagination(c, 200, logs, pq.pagination(total, more))
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/retention"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetRetention returns the data retention policy
+func (h *SettingsHandler) GetRetention(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	return response.Success(c, 200, retention.LoadPolicy(ctx))
+}
+
+// UpdateRetention saves the data retention policy
+func (h *SettingsHandler) UpdateRetention(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		Enabled bool                   `json:"enabled"`
+		Fields  []models.RetentionRule `json:"fields"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	for _, rule := range req.Fields {
+		if !retention.IsField(rule.Field) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid field: "+rule.Field)
+		}
+		if rule.Enabled && rule.AfterDays < 30 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Retention period must be at least 30 days")
+		}
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	// Keep saved rules for fields not included in the request
+	policy := retention.LoadPolicy(ctx)
+	for i, rule := range policy.Fields {
+		for _, updated := range req.Fields {
+			if updated.Field == rule.Field {
+				policy.Fields[i] = updated
+			}
+		}
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"enabled":    req.Enabled,
+			"fields":     policy.Fields,
+			"updated_by": middleware.GetUserID(c),
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection("retention_policy")
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save retention policy")
+	}
+
+	audit.Log(c, audit.ActionRetentionUpdate, "retention_policy", "", map[string]interface{}{
+		"enabled": req.Enabled,
+		"fields":  policy.Fields,
+	})
+
+	return response.Success(c, 200, retention.LoadPolicy(ctx))
+}
+
+// PreviewRetention reports how many records the policy would anonymize, without changing anything
+func (h *SettingsHandler) PreviewRetention(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	report, err := retention.Apply(ctx, retention.LoadPolicy(ctx), true)
+	if err != nil {
+		return response.Error(c, 500, "Failed to build retention report")
+	}
+
+	return response.Success(c, 200, report)
+}
+
+// RunRetention applies the policy immediately, even when scheduled runs are disabled
+func (h *SettingsHandler) RunRetention(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, 5*time.Minute)
+	defer cancel()
+
+	report, err := retention.Apply(ctx, retention.LoadPolicy(ctx), false)
+	if err != nil {
+		return response.Error(c, 500, "Retention run failed")
+	}
+
+	audit.Log(c, audit.ActionRetentionRun, "retention_policy", "", map[string]interface{}{
+		"total": report.Total,
+	})
+
+	return response.Success(c, 200, report)
+}
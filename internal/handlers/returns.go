@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReturnHandler handles returns / complaint routes
+type ReturnHandler struct{}
+
+// NewReturnHandler creates a new return handler
+func NewReturnHandler() *ReturnHandler {
+	return &ReturnHandler{}
+}
+
+// generateReturnNumber generates return number
+func generateReturnNumber() string {
+	return fmt.Sprintf("RT-%s", time.Now().Format("20060102150405"))
+}
+
+// returnStatusText returns the customer-facing status label
+func returnStatusText(r *models.ReturnRequest) string {
+	switch r.Status {
+	case models.ReturnStatusOpen:
+		return "Diterima, menunggu pengecekan"
+	case models.ReturnStatusReviewing:
+		return "Sedang ditinjau"
+	case models.ReturnStatusResolved:
+		if r.Resolution == models.ReturnResolutionReplace {
+			return "Disetujui - barang akan diganti"
+		}
+		return "Disetujui - nota refund diterbitkan"
+	case models.ReturnStatusRejected:
+		return "Ditolak"
+	}
+	return r.Status
+}
+
+// notifyReturn sends the return status update to the sales phone
+func notifyReturn(r *models.ReturnRequest, deliveryToken string) {
+	if r.SalesPhone == "" {
+		return
+	}
+	notification.SendReturnNotification(
+		r.SalesPhone,
+		r.SalesName,
+		r.ReturnNumber,
+		r.NoteNumber,
+		returnStatusText(r),
+		r.ResolutionNote,
+		deliveryToken,
+	)
+}
+
+// Submit opens a complaint for a delivery note (client-side, by delivery token)
+func (h *ReturnHandler) Submit(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	if token == "" {
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
+	}
+
+	description := c.FormValue("description")
+	productName := c.FormValue("product_name")
+	quantity := 0
+	fmt.Sscanf(c.FormValue("quantity_affected"), "%d", &quantity)
+
+	if description == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Description is required")
+	}
+	if quantity <= 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Quantity affected must be greater than zero")
+	}
+
+	deliveryCollection := database.GetMongoCollection("delivery_notes")
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	note := &models.DeliveryNote{}
+	err := deliveryCollection.FindOne(ctx, bson.M{"token": token}).Decode(note)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
+	}
+
+	if note.Superseded {
+		return response.ErrorCode(c, 400, response.CodeDeliveryNoteSuperseded, "Delivery note has been superseded")
+	}
+
+	// Upload photos (optional, multiple)
+	photos := []models.Image{}
+	if form, err := c.MultipartForm(); err == nil {
+		for _, formFile := range form.File["photos"] {
+			uploadResult, err := file.UploadFile(formFile, file.CategoryReturnPhoto)
+			if err != nil {
+				return uploadFailed(c, err, "Failed to upload photo")
+			}
+			photos = append(photos, *uploadResult.Image())
+		}
+	}
+
+	ret := models.NewReturnRequest()
+	ret.ReturnNumber = generateReturnNumber()
+	ret.DeliveryNoteID = note.ID.Hex()
+	ret.NoteNumber = note.NoteNumber
+	ret.OrderID = note.OrderID
+	ret.SalesName = note.SalesName
+	ret.SalesPhone = note.SalesPhone
+	ret.ProductName = productName
+	ret.Description = description
+	ret.QuantityAffected = quantity
+	ret.Photos = photos
+
+	collection := database.GetMongoCollection("returns")
+	_, err = collection.InsertOne(ctx, ret)
+	if err != nil {
+		return response.Error(c, 500, "Failed to submit complaint")
+	}
+
+	notifyReturn(ret, token)
+
+	return response.Success(c, 201, ret)
+}
+
+// ListByToken returns complaints for a delivery note (client-side)
+func (h *ReturnHandler) ListByToken(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	if token == "" {
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
+	}
+
+	deliveryCollection := database.GetMongoCollection("delivery_notes")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	note := &models.DeliveryNote{}
+	err := deliveryCollection.FindOne(ctx, bson.M{"token": token}).Decode(note)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeDeliveryNoteNotFound, "Delivery note not found")
+	}
+
+	collection := database.GetMongoCollection("returns")
+	cursor, err := collection.Find(
+		ctx,
+		bson.M{"delivery_note_id": note.ID.Hex()},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch complaints")
+	}
+	defer cursor.Close(ctx)
+
+	returns := []models.ReturnRequest{}
+	if err := cursor.All(ctx, &returns); err != nil {
+		return response.Error(c, 500, "Failed to fetch complaints")
+	}
+
+	return response.Success(c, 200, returns)
+}
+
+// List returns all complaints with pagination
+func (h *ReturnHandler) List(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+	status := c.Query("status")
+	search := c.Query("search")
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if search != "" {
+		filter["$or"] = []bson.M{
+			{"return_number": bson.M{"$regex": search, "$options": "i"}},
+			{"note_number": bson.M{"$regex": search, "$options": "i"}},
+			{"sales_name": bson.M{"$regex": search, "$options": "i"}},
+		}
+	}
+
+	collection := database.GetMongoCollection("returns")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	findOptions := pq.findOptions().
+		SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch complaints")
+	}
+	defer cursor.Close(ctx)
+
+	var returns []models.ReturnRequest
+	if err := cursor.All(ctx, &returns); err != nil {
+		return response.Error(c, 500, "Failed to decode complaints")
+	}
+	returns, more := trimPage(pq, returns)
+
+	return response.SuccessWithPagination(c, 200, returns, pq.pagination(total, more))
+}
+
+// Detail returns a single complaint by ID
+func (h *ReturnHandler) Detail(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("returns")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	ret := &models.ReturnRequest{}
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(ret)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeComplaintNotFound, "Complaint not found")
+	}
+
+	return response.Success(c, 200, ret)
+}
+
+// Review moves a complaint into triage
+func (h *ReturnHandler) Review(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("returns")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	ret := &models.ReturnRequest{}
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(ret)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeComplaintNotFound, "Complaint not found")
+	}
+
+	if ret.Status != models.ReturnStatusOpen {
+		return response.ErrorCode(c, 400, response.CodeComplaintClosed, "Complaint is not in open status")
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"status":      models.ReturnStatusReviewing,
+		"reviewed_by": middleware.GetUserID(c),
+		"reviewed_at": now,
+		"updated_at":  now,
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	if err != nil {
+		return response.Error(c, 500, "Failed to update complaint")
+	}
+
+	ret.Status = models.ReturnStatusReviewing
+	notifyReturn(ret, h.deliveryToken(ctx, ret.DeliveryNoteID))
+
+	return response.SuccessWithMessage(c, 200, "Complaint is under review")
+}
+
+// Resolve resolves a complaint (replace, refund note, or reject)
+func (h *ReturnHandler) Resolve(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type ResolveRequest struct {
+		Resolution string `json:"resolution"`
+		Note       string `json:"note"`
+	}
+
+	var req ResolveRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	status := models.ReturnStatusResolved
+	switch req.Resolution {
+	case models.ReturnResolutionReplace, models.ReturnResolutionRefundNote:
+	case models.ReturnResolutionReject:
+		status = models.ReturnStatusRejected
+	default:
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid resolution")
+	}
+
+	collection := database.GetMongoCollection("returns")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	ret := &models.ReturnRequest{}
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(ret)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeComplaintNotFound, "Complaint not found")
+	}
+
+	if ret.Status == models.ReturnStatusResolved || ret.Status == models.ReturnStatusRejected {
+		return response.ErrorCode(c, 400, response.CodeComplaintClosed, "Complaint is already closed")
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"status":          status,
+		"resolution":      req.Resolution,
+		"resolution_note": req.Note,
+		"resolved_by":     middleware.GetUserID(c),
+		"resolved_at":     now,
+		"updated_at":      now,
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	if err != nil {
+		return response.Error(c, 500, "Failed to resolve complaint")
+	}
+
+	audit.Log(c, audit.ActionReturnResolve, "return", id, map[string]interface{}{
+		"return_number": ret.ReturnNumber,
+		"resolution":    req.Resolution,
+	})
+
+	ret.Status = status
+	ret.Resolution = req.Resolution
+	ret.ResolutionNote = req.Note
+	notifyReturn(ret, h.deliveryToken(ctx, ret.DeliveryNoteID))
+
+	return response.SuccessWithMessage(c, 200, "Complaint resolved")
+}
+
+// Report returns complaint statistics over a date range
+func (h *ReturnHandler) Report(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	collection := database.GetMongoCollection("returns")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	match := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+
+	type Bucket struct {
+		Key      string `json:"key" bson:"_id"`
+		Count    int    `json:"count" bson:"count"`
+		Quantity int    `json:"quantity" bson:"quantity"`
+	}
+
+	groupBy := func(field string) ([]Bucket, error) {
+		pipeline := []bson.M{
+			{"$match": match},
+			{"$group": bson.M{
+				"_id":      "$" + field,
+				"count":    bson.M{"$sum": 1},
+				"quantity": bson.M{"$sum": "$quantity_affected"},
+			}},
+			{"$sort": bson.M{"count": -1}},
+		}
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		defer cursor.Close(ctx)
+		buckets := []Bucket{}
+		if err := cursor.All(ctx, &buckets); err != nil {
+			return nil, err
+		}
+		return buckets, nil
+	}
+
+	total, err := collection.CountDocuments(ctx, match)
+	if err != nil {
+		return response.Error(c, 500, "Failed to count complaints")
+	}
+
+	report := fiber.Map{
+		"from":  from,
+		"to":    to,
+		"total": total,
+	}
+	for key, field := range map[string]string{
+		"by_status":     "status",
+		"by_resolution": "resolution",
+		"by_product":    "product_name",
+		"by_sales":      "sales_name",
+	} {
+		buckets, err := groupBy(field)
+		if err != nil {
+			return response.Error(c, 500, "Failed to aggregate complaints")
+		}
+		report[key] = buckets
+	}
+
+	return response.Success(c, 200, report)
+}
+
+// deliveryToken returns the client token of a delivery note
+func (h *ReturnHandler) deliveryToken(ctx context.Context, deliveryNoteID string) string {
+	objID, err := primitive.ObjectIDFromHex(deliveryNoteID)
+	if err != nil {
+		return ""
+	}
+	note := &models.DeliveryNote{}
+	database.GetMongoCollection("delivery_notes").FindOne(ctx, bson.M{"_id": objID}).Decode(note)
+	return note.Token
+}
+
+// parseDateRange parses "from" and "to" query params (YYYY-MM-DD).
+// Defaults to the last 30 days; "to" is inclusive.
+func parseDateRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	from := today.AddDate(0, 0, -29)
+	to := today.Add(24 * time.Hour)
+
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fmt.Errorf("Invalid from date, use YYYY-MM-DD")
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fmt.Errorf("Invalid to date, use YYYY-MM-DD")
+		}
+		to = t.Add(24 * time.Hour)
+	}
+
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+
+	return from, to, nil
+}
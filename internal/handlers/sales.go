@@ -1,17 +1,16 @@
 package handlers
 
 import (
-	"context"
 	"time"
 
 	"bg-go/internal/database"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // SalesHandler handles sales routes
@@ -24,13 +23,10 @@ func NewSalesHandler() *SalesHandler {
 
 // List returns all sales with pagination
 func (h *SalesHandler) List(c *fiber.Ctx) error {
-	page := c.QueryInt("page", 1)
-	limit := c.QueryInt("limit", 10)
+	pq := parsePage(c, 10, maxPageLimit)
 	search := c.Query("search")
 	activeOnly := c.QueryBool("active_only", false)
 
-	skip := (page - 1) * limit
-
 	filter := bson.M{}
 	if search != "" {
 		filter = bson.M{
@@ -46,14 +42,12 @@ func (h *SalesHandler) List(c *fiber.Ctx) error {
 	}
 
 	collection := database.GetMongoCollection("sales")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := requestContext(c, listTimeout)
 	defer cancel()
 
-	total, _ := collection.CountDocuments(ctx, filter)
+	total := pq.count(ctx, collection, filter)
 
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
+	findOptions := pq.findOptions().
 		SetSort(bson.D{{Key: "created_at", Value: -1}})
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
@@ -64,8 +58,9 @@ func (h *SalesHandler) List(c *fiber.Ctx) error {
 
 	var sales []models.Sales
 	cursor.All(ctx, &sales)
+	sales, more := trimPage(pq, sales)
 
-	return response.SuccessWithPagination(c, 200, sales, response.CalculatePagination(int64(page), int64(limit), total))
+	return response.SuccessWithPagination(c, 200, sales, pq.pagination(total, more))
 }
 
 // Detail returns a single sales by ID
@@ -74,17 +69,17 @@ func (h *SalesHandler) Detail(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	collection := database.GetMongoCollection("sales")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	sales := &models.Sales{}
 	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(sales)
 	if err != nil {
-		return response.NotFound(c, "Sales not found")
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
 	}
 
 	return response.Success(c, 200, sales)
@@ -101,26 +96,33 @@ func (h *SalesHandler) Create(c *fiber.Ctx) error {
 
 	var req CreateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	if req.Name == "" || req.Phone == "" {
-		return response.BadRequest(c, "Name and phone are required")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Name and phone are required")
+	}
+
+	// Phones are stored normalized, so "0812..." and "62812..." are the same phone to the
+	// unique phone index
+	phone, err := utils.NormalizePhone(req.Phone)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid phone number")
 	}
 
 	sales := models.NewSales()
 	sales.Name = req.Name
-	sales.Phone = req.Phone
+	sales.Phone = phone
 	sales.Email = req.Email
 	sales.Address = req.Address
 
 	collection := database.GetMongoCollection("sales")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
-	_, err := collection.InsertOne(ctx, sales)
+	_, err = collection.InsertOne(ctx, sales)
 	if err != nil {
-		return response.Error(c, 500, "Failed to create sales")
+		return writeFailed(c, err, "Failed to create sales")
 	}
 
 	return response.Success(c, 201, sales)
@@ -132,7 +134,7 @@ func (h *SalesHandler) Update(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	type UpdateRequest struct {
@@ -145,7 +147,7 @@ func (h *SalesHandler) Update(c *fiber.Ctx) error {
 
 	var req UpdateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	update := bson.M{"updated_at": time.Now()}
@@ -153,7 +155,11 @@ func (h *SalesHandler) Update(c *fiber.Ctx) error {
 		update["name"] = req.Name
 	}
 	if req.Phone != "" {
-		update["phone"] = req.Phone
+		phone, err := utils.NormalizePhone(req.Phone)
+		if err != nil {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid phone number")
+		}
+		update["phone"] = phone
 	}
 	if req.Email != "" {
 		update["email"] = req.Email
@@ -166,15 +172,15 @@ func (h *SalesHandler) Update(c *fiber.Ctx) error {
 	}
 
 	collection := database.GetMongoCollection("sales")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
 	if err != nil {
-		return response.Error(c, 500, "Failed to update sales")
+		return writeFailed(c, err, "Failed to update sales")
 	}
 	if result.MatchedCount == 0 {
-		return response.NotFound(c, "Sales not found")
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
 	}
 
 	return response.SuccessWithMessage(c, 200, "Successfully updated")
@@ -186,11 +192,11 @@ func (h *SalesHandler) Delete(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return response.BadRequest(c, "Invalid ID format")
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
 	}
 
 	collection := database.GetMongoCollection("sales")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	result, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
@@ -198,7 +204,7 @@ func (h *SalesHandler) Delete(c *fiber.Ctx) error {
 		return response.Error(c, 500, "Failed to delete sales")
 	}
 	if result.DeletedCount == 0 {
-		return response.NotFound(c, "Sales not found")
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
 	}
 
 	return response.SuccessWithMessage(c, 200, "Successfully deleted")
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/spreadsheet"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// salesImportMaxRows caps the data rows accepted in one import
+const salesImportMaxRows = 2000
+
+// Sales import row statuses
+const (
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importDuplicate = "duplicate" // Phone already exists and update mode is off
+	importInvalid   = "invalid"
+	importError     = "error"
+)
+
+// salesImportColumns maps accepted header names to fields
+var salesImportColumns = map[string]string{
+	"name":     "name",
+	"nama":     "name",
+	"phone":    "phone",
+	"telepon":  "phone",
+	"no_hp":    "phone",
+	"no hp":    "phone",
+	"hp":       "phone",
+	"whatsapp": "phone",
+	"email":    "email",
+	"address":  "address",
+	"alamat":   "address",
+}
+
+// SalesImportRow is the outcome of one spreadsheet row
+type SalesImportRow struct {
+	Row     int    `json:"row"` // 1-based, as shown in the spreadsheet
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Status  string `json:"status"`
+	SalesID string `json:"sales_id,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Import creates sales reps from a CSV or XLSX file (form field "file").
+// The header row needs name and phone columns; email and address are optional.
+// Phones are normalized and matched against existing reps: with mode=update the
+// existing rep is updated, otherwise the row is reported as a duplicate.
+// Pass dry_run=true to get the report without saving anything.
+func (h *SalesHandler) Import(c *fiber.Ctx) error {
+	formFile, err := c.FormFile("file")
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No file provided")
+	}
+
+	mode := c.FormValue("mode", c.Query("mode", "skip"))
+	updateExisting := mode == "update"
+	dryRun := c.FormValue("dry_run", c.Query("dry_run")) == "true"
+
+	src, err := formFile.Open()
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Failed to read file")
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Failed to read file")
+	}
+
+	rows, err := spreadsheet.Read(formFile.Filename, data)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	if len(rows) < 2 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "The file has no data rows")
+	}
+	if len(rows)-1 > salesImportMaxRows {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Too many rows, the limit is 2000 per import")
+	}
+
+	columns := map[string]int{}
+	for i, cell := range rows[0] {
+		if field, ok := salesImportColumns[strings.ToLower(strings.TrimSpace(cell))]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Missing name column")
+	}
+	if _, ok := columns["phone"]; !ok {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Missing phone column")
+	}
+	cell := func(row []string, field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	collection := database.GetMongoCollection("sales")
+	ctx, cancel := requestContext(c, bulkTimeout)
+	defer cancel()
+
+	// Existing phones may have been saved in any format, so compare normalized values
+	existing := map[string]models.Sales{}
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "phone": 1}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to load existing sales")
+	}
+	var current []models.Sales
+	if err := cursor.All(ctx, &current); err != nil {
+		return response.Error(c, 500, "Failed to decode existing sales")
+	}
+	for _, sales := range current {
+		if phone, err := utils.NormalizePhone(sales.Phone); err == nil {
+			existing[phone] = sales
+		}
+	}
+
+	results := []SalesImportRow{}
+	counts := map[string]int{}
+	seenInFile := map[string]int{}
+
+	for i, row := range rows[1:] {
+		result := SalesImportRow{Row: i + 2, Name: cell(row, "name"), Phone: cell(row, "phone")}
+		report := func(status string, message string) {
+			result.Status = status
+			result.Message = message
+			results = append(results, result)
+			counts[status]++
+		}
+
+		// Skip blank lines at the end of a sheet
+		if strings.Join(row, "") == "" {
+			continue
+		}
+		if result.Name == "" {
+			report(importInvalid, "Name is required")
+			continue
+		}
+		phone, err := utils.NormalizePhone(result.Phone)
+		if err != nil {
+			report(importInvalid, "Invalid phone number")
+			continue
+		}
+		result.Phone = phone
+
+		if firstRow, ok := seenInFile[phone]; ok {
+			report(importDuplicate, "Same phone as row "+strconv.Itoa(firstRow)+" of this file")
+			continue
+		}
+		seenInFile[phone] = result.Row
+
+		email, address := cell(row, "email"), cell(row, "address")
+		now := time.Now()
+
+		if match, ok := existing[phone]; ok {
+			result.SalesID = match.ID.Hex()
+			if !updateExisting {
+				report(importDuplicate, "Phone already belongs to "+match.Name)
+				continue
+			}
+
+			if !dryRun {
+				update := bson.M{"name": result.Name, "phone": phone, "updated_at": now}
+				if email != "" {
+					update["email"] = email
+				}
+				if address != "" {
+					update["address"] = address
+				}
+				if _, err := collection.UpdateOne(ctx, bson.M{"_id": match.ID}, bson.M{"$set": update}); err != nil {
+					importWriteFailed(report, err, "Failed to update sales")
+					continue
+				}
+			}
+			report(importUpdated, "")
+			continue
+		}
+
+		sales := models.NewSales()
+		sales.Name = result.Name
+		sales.Phone = phone
+		sales.Email = email
+		sales.Address = address
+
+		if !dryRun {
+			if _, err := collection.InsertOne(ctx, sales); err != nil {
+				importWriteFailed(report, err, "Failed to create sales")
+				continue
+			}
+			result.SalesID = sales.ID.Hex()
+		}
+		report(importCreated, "")
+	}
+
+	if !dryRun {
+		audit.Log(c, audit.ActionSalesImport, "sales", "", map[string]interface{}{
+			"file":    formFile.Filename,
+			"mode":    mode,
+			"created": counts[importCreated],
+			"updated": counts[importUpdated],
+		})
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"dry_run": dryRun,
+		"total":   len(results),
+		"summary": fiber.Map{
+			importCreated:   counts[importCreated],
+			importUpdated:   counts[importUpdated],
+			importDuplicate: counts[importDuplicate],
+			importInvalid:   counts[importInvalid],
+			importError:     counts[importError],
+		},
+		"rows": results,
+	})
+}
+
+// importWriteFailed reports a row whose write failed, as a duplicate when another active
+// sales took the phone since the import loaded the existing ones
+func importWriteFailed(report func(status string, message string), err error, message string) {
+	if _, duplicate := database.DuplicateKeyIndex(err); duplicate {
+		report(importDuplicate, "Phone already belongs to another active sales")
+		return
+	}
+	report(importError, message)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SalesMergeResult counts the records moved by a merge
+type SalesMergeResult struct {
+	Orders         int64 `json:"orders"`
+	ArchivedOrders int64 `json:"archived_orders"`
+	DeliveryNotes  int64 `json:"delivery_notes"`
+	Returns        int64 `json:"returns"`
+	Notifications  int64 `json:"notifications"`
+	Reports        int64 `json:"reports"`
+}
+
+// Merge moves everything that belongs to one sales rep onto another and deactivates the
+// source. Used to clean up duplicate reps, e.g. the same person saved with two phone formats.
+// Orders are reassigned by sales_id; delivery notes, returns and notifications only hold a
+// snapshot of the rep, so those of the moved orders get the target's name and phone.
+func (h *SalesHandler) Merge(c *fiber.Ctx) error {
+	sourceID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+	targetID, err := primitive.ObjectIDFromHex(c.Params("target"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid target ID format")
+	}
+	if sourceID == targetID {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Cannot merge a sales into itself")
+	}
+
+	salesCollection := database.GetMongoCollection("sales")
+	ctx, cancel := requestContext(c, bulkTimeout)
+	defer cancel()
+
+	var source, target models.Sales
+	if err := salesCollection.FindOne(ctx, bson.M{"_id": sourceID}).Decode(&source); err != nil {
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
+	}
+	if err := salesCollection.FindOne(ctx, bson.M{"_id": targetID}).Decode(&target); err != nil {
+		return response.NotFoundCode(c, response.CodeSalesNotFound, "Target sales not found")
+	}
+	if source.MergedInto != "" {
+		return response.ErrorCode(c, 400, response.CodeSalesAlreadyMerged, "Sales was already merged into another sales")
+	}
+	if target.MergedInto != "" {
+		return response.ErrorCode(c, 400, response.CodeSalesAlreadyMerged, "Target sales was merged into another sales")
+	}
+
+	// The snapshot collections are keyed by order, so collect the source's orders first
+	orderIDs := []string{}
+	for _, name := range []string{"orders", archive.Collection} {
+		cursor, err := database.GetMongoCollection(name).Find(ctx, bson.M{"sales_id": sourceID.Hex()}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return response.Error(c, 500, "Failed to fetch orders")
+		}
+		var orders []models.Order
+		if err := cursor.All(ctx, &orders); err != nil {
+			return response.Error(c, 500, "Failed to decode orders")
+		}
+		for _, order := range orders {
+			orderIDs = append(orderIDs, order.ID.Hex())
+		}
+	}
+
+	now := time.Now()
+	result := SalesMergeResult{}
+
+	err = database.WithTransaction(ctx, func(ctx context.Context) error {
+		result = SalesMergeResult{}
+		bySales := bson.M{"sales_id": sourceID.Hex()}
+		reassign := bson.M{"$set": bson.M{"sales_id": targetID.Hex(), "updated_at": now}}
+
+		res, err := database.GetMongoCollection("orders").UpdateMany(ctx, bySales, reassign)
+		if err != nil {
+			return err
+		}
+		result.Orders = res.ModifiedCount
+
+		if res, err = database.GetMongoCollection(archive.Collection).UpdateMany(ctx, bySales, reassign); err != nil {
+			return err
+		}
+		result.ArchivedOrders = res.ModifiedCount
+
+		if len(orderIDs) > 0 {
+			byOrder := bson.M{"order_id": bson.M{"$in": orderIDs}}
+			snapshot := bson.M{"$set": bson.M{"sales_name": target.Name, "sales_phone": target.Phone, "updated_at": now}}
+
+			if res, err = database.GetMongoCollection("delivery_notes").UpdateMany(ctx, byOrder, snapshot); err != nil {
+				return err
+			}
+			result.DeliveryNotes = res.ModifiedCount
+
+			if res, err = database.GetMongoCollection("returns").UpdateMany(ctx, byOrder, snapshot); err != nil {
+				return err
+			}
+			result.Returns = res.ModifiedCount
+
+			moved, err := h.moveNotifications(ctx, orderIDs, source.Phone, target.Phone)
+			if err != nil {
+				return err
+			}
+			result.Notifications = moved
+		}
+
+		if res, err = database.GetMongoCollection("reports").UpdateMany(ctx,
+			bson.M{"filter.sales_ids": sourceID.Hex()},
+			bson.M{"$set": bson.M{"filter.sales_ids.$": targetID.Hex(), "updated_at": now}},
+		); err != nil {
+			return err
+		}
+		result.Reports = res.ModifiedCount
+
+		_, err = salesCollection.UpdateOne(ctx, bson.M{"_id": sourceID}, bson.M{"$set": bson.M{
+			"is_active":   false,
+			"merged_into": targetID.Hex(),
+			"merged_at":   now,
+			"updated_at":  now,
+		}})
+		return err
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to merge sales")
+	}
+
+	audit.Log(c, audit.ActionSalesMerge, "sales", sourceID.Hex(), map[string]interface{}{
+		"source_name":    source.Name,
+		"target_id":      targetID.Hex(),
+		"target_name":    target.Name,
+		"orders":         result.Orders + result.ArchivedOrders,
+		"delivery_notes": result.DeliveryNotes,
+		"returns":        result.Returns,
+		"notifications":  result.Notifications,
+		"saved_reports":  result.Reports,
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"source_id": sourceID.Hex(),
+		"target_id": targetID.Hex(),
+		"moved":     result,
+	})
+}
+
+// moveNotifications points the notifications of orderIDs that were addressed to the source
+// rep at the target. Pending ones get a new wa.me link so manual resends reach the target.
+func (h *SalesHandler) moveNotifications(ctx context.Context, orderIDs []string, sourcePhone string, targetPhone string) (int64, error) {
+	collection := database.GetMongoCollection("notifications")
+
+	filter := bson.M{"order_id": bson.M{"$in": orderIDs}, "phone": sourcePhone}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "message": 1, "status": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var notifications []notification.Notification
+	err = cursor.All(ctx, &notifications)
+	cursor.Close(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var moved int64
+	for _, n := range notifications {
+		update := bson.M{"phone": targetPhone}
+		if n.Status == notification.StatusPending {
+			update["fallback_link"] = notification.GenerateWhatsAppLink(targetPhone, n.Message)
+		}
+		res, err := collection.UpdateOne(ctx, bson.M{"_id": n.ID}, bson.M{"$set": update})
+		if err != nil {
+			return moved, err
+		}
+		moved += res.ModifiedCount
+	}
+	return moved, nil
+}
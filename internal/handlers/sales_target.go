@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/target"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TargetRequest is a sales rep's goal for a month
+type TargetRequest struct {
+	Month   string  `json:"month"` // YYYY-MM
+	Revenue float64 `json:"revenue"`
+	Volume  int     `json:"volume"`
+}
+
+// validate checks the goal; the month is only read when creating
+func (r *TargetRequest) validate(withMonth bool) string {
+	if withMonth {
+		r.Month = strings.TrimSpace(r.Month)
+		if _, _, err := target.MonthRange(r.Month); err != nil {
+			return "month must be YYYY-MM"
+		}
+	}
+	if r.Revenue < 0 || r.Volume < 0 {
+		return "revenue and volume cannot be negative"
+	}
+	if r.Revenue == 0 && r.Volume == 0 {
+		return "A revenue or volume target is required"
+	}
+	return ""
+}
+
+// findSales loads the sales rep of the :id param
+func findSales(c *fiber.Ctx) (*models.Sales, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	sales := &models.Sales{}
+	if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": objID}).Decode(sales); err != nil {
+		return nil, response.NotFoundCode(c, response.CodeSalesNotFound, "Sales not found")
+	}
+	return sales, nil
+}
+
+// Targets lists a sales rep's monthly targets, newest first, with what was achieved
+func (h *SalesHandler) Targets(c *fiber.Ctx) error {
+	sales, err := findSales(c)
+	if sales == nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection(target.Collection).Find(ctx,
+		bson.M{"sales_id": sales.ID.Hex()}, options.Find().SetSort(bson.M{"month": -1}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch targets")
+	}
+	var targets []target.Target
+	if err := cursor.All(ctx, &targets); err != nil {
+		return response.Error(c, 500, "Failed to fetch targets")
+	}
+
+	achievements := make([]target.Achievement, 0, len(targets))
+	for i := range targets {
+		actuals, err := target.Actuals(ctx, targets[i].Month)
+		if err != nil {
+			return response.Error(c, 500, "Failed to compute achievement")
+		}
+		achievement := target.Achieve(sales.ID.Hex(), targets[i].Month, &targets[i], actuals[sales.ID.Hex()])
+		achievement.SalesName = sales.Name
+		achievements = append(achievements, achievement)
+	}
+	return response.Success(c, 200, achievements)
+}
+
+// CreateTarget sets a sales rep's target for a month that has none yet
+func (h *SalesHandler) CreateTarget(c *fiber.Ctx) error {
+	sales, err := findSales(c)
+	if sales == nil {
+		return err
+	}
+
+	var req TargetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if msg := req.validate(true); msg != "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, msg)
+	}
+
+	collection := database.GetMongoCollection(target.Collection)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	// The unique index covers concurrent requests; the lookup gives the memory store the same answer
+	if n, _ := collection.CountDocuments(ctx, bson.M{"sales_id": sales.ID.Hex(), "month": req.Month}); n > 0 {
+		return response.ErrorCode(c, 409, response.CodeConflict, "A target for this month already exists")
+	}
+
+	now := time.Now()
+	t := target.Target{
+		ID:        primitive.NewObjectID(),
+		SalesID:   sales.ID.Hex(),
+		Month:     req.Month,
+		Revenue:   req.Revenue,
+		Volume:    req.Volume,
+		CreatedBy: middleware.GetUserID(c),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := collection.InsertOne(ctx, t); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return response.ErrorCode(c, 409, response.CodeConflict, "A target for this month already exists")
+		}
+		return response.Error(c, 500, "Failed to create target")
+	}
+
+	audit.Log(c, audit.ActionTargetCreate, "sales", sales.ID.Hex(), map[string]interface{}{
+		"month":   t.Month,
+		"revenue": t.Revenue,
+		"volume":  t.Volume,
+	})
+	return response.Success(c, 201, t)
+}
+
+// UpdateTarget changes a sales rep's target for a month
+func (h *SalesHandler) UpdateTarget(c *fiber.Ctx) error {
+	sales, err := findSales(c)
+	if sales == nil {
+		return err
+	}
+
+	var req TargetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if msg := req.validate(false); msg != "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, msg)
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	t := &target.Target{}
+	err = database.GetMongoCollection(target.Collection).FindOneAndUpdate(ctx,
+		bson.M{"sales_id": sales.ID.Hex(), "month": c.Params("month")},
+		bson.M{"$set": bson.M{"revenue": req.Revenue, "volume": req.Volume, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(t)
+	if err != nil {
+		return response.NotFound(c, "Target not found")
+	}
+
+	audit.Log(c, audit.ActionTargetUpdate, "sales", sales.ID.Hex(), map[string]interface{}{
+		"month":   t.Month,
+		"revenue": t.Revenue,
+		"volume":  t.Volume,
+	})
+	return response.Success(c, 200, t)
+}
+
+// DeleteTarget removes a sales rep's target for a month
+func (h *SalesHandler) DeleteTarget(c *fiber.Ctx) error {
+	sales, err := findSales(c)
+	if sales == nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	month := c.Params("month")
+	result, err := database.GetMongoCollection(target.Collection).DeleteOne(ctx, bson.M{"sales_id": sales.ID.Hex(), "month": month})
+	if err != nil {
+		return response.Error(c, 500, "Failed to delete target")
+	}
+	if result.DeletedCount == 0 {
+		return response.NotFound(c, "Target not found")
+	}
+
+	audit.Log(c, audit.ActionTargetDelete, "sales", sales.ID.Hex(), map[string]interface{}{"month": month})
+	return response.SuccessWithMessage(c, 200, "Target deleted")
+}
+
+// Leaderboard ranks the sales reps of a month (default: the current one) by how much
+// of their revenue target they achieved. Reps with completed orders but no target rank last.
+func (h *SalesHandler) Leaderboard(c *fiber.Ctx) error {
+	month := c.Query("month", time.Now().Format(target.MonthFormat))
+	if _, _, err := target.MonthRange(month); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "month must be YYYY-MM")
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	board, err := target.Leaderboard(ctx, month)
+	if err != nil {
+		return response.Error(c, 500, "Failed to compute the leaderboard")
+	}
+
+	cursor, err := database.GetMongoCollection("sales").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch sales")
+	}
+	var sales []models.Sales
+	if err := cursor.All(ctx, &sales); err != nil {
+		return response.Error(c, 500, "Failed to fetch sales")
+	}
+	names := map[string]string{}
+	for _, s := range sales {
+		names[s.ID.Hex()] = s.Name
+	}
+	for i := range board {
+		board[i].SalesName = names[board[i].SalesID]
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"month":       month,
+		"leaderboard": board,
+	})
+}
@@ -1,15 +1,20 @@
 package handlers
 
 import (
-	"context"
 	"time"
 
 	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/barcode"
+	"bg-go/internal/lib/notification"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
 	"bg-go/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // SettingsHandler handles settings routes
@@ -23,7 +28,7 @@ func NewSettingsHandler() *SettingsHandler {
 // Get returns company settings
 func (h *SettingsHandler) Get(c *fiber.Ctx) error {
 	collection := database.GetMongoCollection("company_settings")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	settings := &models.CompanySettings{}
@@ -52,15 +57,28 @@ func (h *SettingsHandler) Update(c *fiber.Ctx) error {
 		BankAccount2   string `json:"bank_account_2"`
 		BankHolder2    string `json:"bank_holder_2"`
 		WhatsAppNumber string `json:"whatsapp_number"`
+
+		// Left out of a request, these keep their saved values
+		BarcodeFormat *string `json:"barcode_format"`
+		SenderName    *string `json:"sender_name"`
+		Signature     *string `json:"signature"`
+		Footer        *string `json:"footer"`
 	}
 
 	var req UpdateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.BarcodeFormat != nil && *req.BarcodeFormat == "" {
+		*req.BarcodeFormat = barcode.FormatQR
+	}
+	if req.BarcodeFormat != nil && !barcode.IsValidFormat(*req.BarcodeFormat) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Barcode format must be qr, code128 or ean13")
 	}
 
 	collection := database.GetMongoCollection("company_settings")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	// Check if settings exists
@@ -83,16 +101,33 @@ func (h *SettingsHandler) Update(c *fiber.Ctx) error {
 		settings.BankAccount2 = req.BankAccount2
 		settings.BankHolder2 = req.BankHolder2
 		settings.WhatsAppNumber = req.WhatsAppNumber
+		settings.BarcodeFormat = barcode.FormatQR
+		if req.BarcodeFormat != nil {
+			settings.BarcodeFormat = *req.BarcodeFormat
+		}
+		if req.SenderName != nil {
+			settings.SenderName = *req.SenderName
+		}
+		if req.Signature != nil {
+			settings.Signature = *req.Signature
+		}
+		if req.Footer != nil {
+			settings.Footer = *req.Footer
+		}
 
 		_, err = collection.InsertOne(ctx, settings)
 		if err != nil {
 			return response.Error(c, 500, "Failed to create settings")
 		}
 
+		recordSettingsRevision(ctx, c, &models.CompanySettings{}, settings, 0)
+		audit.Log(c, audit.ActionSettingsUpdate, "company_settings", settings.ID.Hex(), nil)
+
 		return response.Success(c, 200, settings)
 	}
 
 	// Update existing settings
+	before := *existing
 	update := bson.M{
 		"name":            req.Name,
 		"address":         req.Address,
@@ -107,6 +142,18 @@ func (h *SettingsHandler) Update(c *fiber.Ctx) error {
 		"whatsapp_number": req.WhatsAppNumber,
 		"updated_at":      now,
 	}
+	if req.BarcodeFormat != nil {
+		update["barcode_format"] = *req.BarcodeFormat
+	}
+	if req.SenderName != nil {
+		update["sender_name"] = *req.SenderName
+	}
+	if req.Signature != nil {
+		update["signature"] = *req.Signature
+	}
+	if req.Footer != nil {
+		update["footer"] = *req.Footer
+	}
 
 	_, err = collection.UpdateOne(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": update})
 	if err != nil {
@@ -116,13 +163,18 @@ func (h *SettingsHandler) Update(c *fiber.Ctx) error {
 	// Get updated settings
 	collection.FindOne(ctx, bson.M{"_id": existing.ID}).Decode(existing)
 
+	changes := recordSettingsRevision(ctx, c, &before, existing, 0)
+	audit.Log(c, audit.ActionSettingsUpdate, "company_settings", existing.ID.Hex(), map[string]interface{}{
+		"changed": settingsChangedFields(changes),
+	})
+
 	return response.Success(c, 200, existing)
 }
 
 // GetPublic returns public company settings (for client)
 func (h *SettingsHandler) GetPublic(c *fiber.Ctx) error {
 	collection := database.GetMongoCollection("company_settings")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestContext(c, readTimeout)
 	defer cancel()
 
 	settings := &models.CompanySettings{}
@@ -145,3 +197,82 @@ func (h *SettingsHandler) GetPublic(c *fiber.Ctx) error {
 		"whatsapp_number": settings.WhatsAppNumber,
 	})
 }
+
+// GetNotificationRules returns the auto-notification rule of every order status
+func (h *SettingsHandler) GetNotificationRules(c *fiber.Ctx) error {
+	rules, err := notification.StatusRules()
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch notification rules")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"rules":        rules,
+		"placeholders": notification.TemplatePlaceholders,
+		"recipients":   []string{models.RecipientSales, models.RecipientCustomer, models.RecipientDriver},
+	})
+}
+
+// UpdateNotificationRule saves the auto-notification rule of one order status
+func (h *SettingsHandler) UpdateNotificationRule(c *fiber.Ctx) error {
+	status := c.Params("status")
+	if _, ok := notification.StatusLabels[status]; !ok {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid status")
+	}
+
+	type UpdateRequest struct {
+		Enabled    bool     `json:"enabled"`
+		Template   string   `json:"template"`
+		Recipients []string `json:"recipients"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	if req.Enabled && req.Template == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Template is required when enabled")
+	}
+	for _, recipient := range req.Recipients {
+		switch recipient {
+		case models.RecipientSales, models.RecipientCustomer, models.RecipientDriver:
+		default:
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid recipient: "+recipient)
+		}
+	}
+	if req.Recipients == nil {
+		req.Recipients = []string{}
+	}
+
+	collection := database.GetMongoCollection("status_notifications")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"enabled":    req.Enabled,
+			"template":   req.Template,
+			"recipients": req.Recipients,
+			"updated_by": middleware.GetUserID(c),
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"status": status}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return response.Error(c, 500, "Failed to save notification rule")
+	}
+
+	audit.Log(c, audit.ActionSettingsUpdate, "status_notification", status, map[string]interface{}{
+		"enabled":    req.Enabled,
+		"recipients": req.Recipients,
+	})
+
+	rule := notification.StatusRule(ctx, status)
+	return response.Success(c, 200, rule)
+}
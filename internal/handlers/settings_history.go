@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// settingsHistoryCollection keeps every saved version of the company settings
+const settingsHistoryCollection = "company_settings_history"
+
+// revisionAttempts is how often a settings version is recorded again after a concurrent
+// save took its number
+const revisionAttempts = 5
+
+// settingsField is an editable company settings field with its bson name
+type settingsField struct {
+	name  string
+	value func(s *models.CompanySettings) string
+}
+
+// settingsFields lists the editable company settings fields, in form order
+var settingsFields = []settingsField{
+	{"name", func(s *models.CompanySettings) string { return s.Name }},
+	{"address", func(s *models.CompanySettings) string { return s.Address }},
+	{"phone", func(s *models.CompanySettings) string { return s.Phone }},
+	{"email", func(s *models.CompanySettings) string { return s.Email }},
+	{"bank_name", func(s *models.CompanySettings) string { return s.BankName }},
+	{"bank_account", func(s *models.CompanySettings) string { return s.BankAccount }},
+	{"bank_holder", func(s *models.CompanySettings) string { return s.BankHolder }},
+	{"bank_name_2", func(s *models.CompanySettings) string { return s.BankName2 }},
+	{"bank_account_2", func(s *models.CompanySettings) string { return s.BankAccount2 }},
+	{"bank_holder_2", func(s *models.CompanySettings) string { return s.BankHolder2 }},
+	{"whatsapp_number", func(s *models.CompanySettings) string { return s.WhatsAppNumber }},
+	{"barcode_format", func(s *models.CompanySettings) string { return s.BarcodeFormat }},
+	{"sender_name", func(s *models.CompanySettings) string { return s.SenderName }},
+	{"signature", func(s *models.CompanySettings) string { return s.Signature }},
+	{"footer", func(s *models.CompanySettings) string { return s.Footer }},
+}
+
+// diffSettings returns the fields that differ between two versions of the settings
+func diffSettings(before, after *models.CompanySettings) []models.SettingsFieldChange {
+	changes := []models.SettingsFieldChange{}
+	for _, field := range settingsFields {
+		from, to := field.value(before), field.value(after)
+		if from != to {
+			changes = append(changes, models.SettingsFieldChange{Field: field.name, From: from, To: to})
+		}
+	}
+	return changes
+}
+
+// settingsChangedFields returns the names of the changed fields, for audit logs
+func settingsChangedFields(changes []models.SettingsFieldChange) []string {
+	fields := make([]string, 0, len(changes))
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	return fields
+}
+
+// recordSettingsRevision saves a new version of the settings when any field changed and
+// returns the changes. Saving the history never fails the settings update itself.
+func recordSettingsRevision(ctx context.Context, c *fiber.Ctx, before, after *models.CompanySettings, revertedFrom int) []models.SettingsFieldChange {
+	changes := diffSettings(before, after)
+	if len(changes) == 0 {
+		return changes
+	}
+
+	// A concurrent save can take the next version first; the unique index rejects the
+	// second insert, which then retries on top of the newer version
+	for attempt := 0; attempt < revisionAttempts; attempt++ {
+		err := insertSettingsRevision(ctx, c, before, after, changes, revertedFrom)
+		if _, duplicate := database.DuplicateKeyIndex(err); duplicate {
+			continue
+		}
+		if err != nil {
+			log.Printf("[Settings] Failed to record settings version: %v", err)
+		}
+		return changes
+	}
+	log.Printf("[Settings] Gave up recording a settings version after %d conflicting saves", revisionAttempts)
+	return changes
+}
+
+// insertSettingsRevision records the next settings version after the latest one
+func insertSettingsRevision(ctx context.Context, c *fiber.Ctx, before, after *models.CompanySettings, changes []models.SettingsFieldChange, revertedFrom int) error {
+	collection := database.GetMongoCollection(settingsHistoryCollection)
+	latest := &models.SettingsRevision{}
+	err := collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(latest)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	// Settings saved before the history existed become version 1, so the first
+	// recorded change can be reverted too
+	if errors.Is(err, mongo.ErrNoDocuments) && !before.ID.IsZero() {
+		latest = &models.SettingsRevision{
+			ID:        primitive.NewObjectID(),
+			Version:   1,
+			Changes:   []models.SettingsFieldChange{},
+			Snapshot:  *before,
+			ChangedAt: before.UpdatedAt,
+		}
+		if _, err := collection.InsertOne(ctx, latest); err != nil {
+			return err
+		}
+	}
+
+	revision := &models.SettingsRevision{
+		ID:           primitive.NewObjectID(),
+		Version:      latest.Version + 1,
+		Changes:      changes,
+		Snapshot:     *after,
+		RevertedFrom: revertedFrom,
+		ChangedBy:    middleware.GetUserID(c),
+		ChangedAt:    time.Now(),
+	}
+	_, err = collection.InsertOne(ctx, revision)
+	return err
+}
+
+// GetHistory lists the saved versions of the company settings, newest first
+func (h *SettingsHandler) GetHistory(c *fiber.Ctx) error {
+	collection := database.GetMongoCollection(settingsHistoryCollection)
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if field := c.Query("field"); field != "" {
+		filter["changes.field"] = field
+	}
+
+	pq := parsePage(c, 20, maxPageLimit)
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "version", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch settings history")
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []models.SettingsRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return response.Error(c, 500, "Failed to decode settings history")
+	}
+	revisions, more := trimPage(pq, revisions)
+
+	return response.SuccessWithPagination(c, 200, revisions, pq.pagination(total, more))
+}
+
+// RevertSettings restores the company settings to a saved version. The revert is saved
+// as a new version, so it can itself be reverted.
+func (h *SettingsHandler) RevertSettings(c *fiber.Ctx) error {
+	version, err := strconv.Atoi(c.Params("version"))
+	if err != nil || version <= 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid version")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	revision := &models.SettingsRevision{}
+	err = database.GetMongoCollection(settingsHistoryCollection).FindOne(ctx, bson.M{"version": version}).Decode(revision)
+	if err != nil {
+		return response.NotFoundCode(c, response.CodeSettingsRevisionNotFound, "Settings version not found")
+	}
+
+	collection := database.GetMongoCollection("company_settings")
+	current := &models.CompanySettings{}
+	if err := collection.FindOne(ctx, bson.M{}).Decode(current); err != nil {
+		return response.Error(c, 500, "Failed to fetch settings")
+	}
+
+	changes := diffSettings(current, &revision.Snapshot)
+	if len(changes) == 0 {
+		return response.Success(c, 200, current)
+	}
+
+	update := bson.M{"updated_at": time.Now()}
+	for _, field := range settingsFields {
+		update[field.name] = field.value(&revision.Snapshot)
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": current.ID}, bson.M{"$set": update}); err != nil {
+		return response.Error(c, 500, "Failed to revert settings")
+	}
+
+	before := *current
+	collection.FindOne(ctx, bson.M{"_id": current.ID}).Decode(current)
+	recordSettingsRevision(ctx, c, &before, current, version)
+
+	audit.Log(c, audit.ActionSettingsRevert, "company_settings", current.ID.Hex(), map[string]interface{}{
+		"version": version,
+		"changed": settingsChangedFields(changes),
+	})
+
+	return response.Success(c, 200, current)
+}
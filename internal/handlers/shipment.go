@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/deliveryfee"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShipmentRequest is the body for updating an order's shipment; omitted fields are kept
+type ShipmentRequest struct {
+	DeliveryAddress *string `json:"delivery_address"`
+	DeliveryETA     *string `json:"delivery_eta"` // RFC 3339, empty to clear
+}
+
+// UpdateShipment sets the destination address and ETA of an order delivered to site.
+// Changing the address of an unpaid order prices its delivery again.
+func (h *OrderHandler) UpdateShipment(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req ShipmentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	unset := bson.M{}
+	if req.DeliveryAddress != nil {
+		if address := strings.TrimSpace(*req.DeliveryAddress); address != "" {
+			set["delivery_address"] = address
+		} else {
+			unset["delivery_address"] = ""
+		}
+	}
+	if req.DeliveryETA != nil {
+		if *req.DeliveryETA == "" {
+			unset["delivery_eta"] = ""
+		} else {
+			eta, err := time.Parse(time.RFC3339, *req.DeliveryETA)
+			if err != nil {
+				return response.ErrorCode(c, 400, response.CodeValidationFailed, "Delivery ETA must be an RFC 3339 time")
+			}
+			set["delivery_eta"] = eta
+		}
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	if req.DeliveryAddress != nil && order.Status == models.OrderStatusPending {
+		order.DeliveryAddress = strings.TrimSpace(*req.DeliveryAddress)
+		err := priceDelivery(ctx, order)
+		if err != nil && !errors.Is(err, deliveryfee.ErrDisabled) {
+			return deliveryFeeFailed(c, err)
+		}
+		if err == nil {
+			set["subtotal"] = order.Subtotal
+			set["delivery_fee"] = order.DeliveryFee
+			set["delivery_distance_km"] = order.DeliveryDistanceKm
+			set["total_price"] = order.TotalPrice
+			if order.DeliveryLocation != nil {
+				set["delivery_location"] = order.DeliveryLocation
+			} else {
+				unset["delivery_location"] = ""
+			}
+		}
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, update); err != nil {
+		return response.Error(c, 500, "Failed to update shipment")
+	}
+
+	audit.Log(c, audit.ActionOrderUpdate, "order", objID.Hex(), set)
+
+	collection.FindOne(ctx, bson.M{"_id": objID}).Decode(order)
+
+	return response.Success(c, 200, shipmentStatus(order))
+}
+
+// LocationRequest is a driver's location ping
+type LocationRequest struct {
+	Lat        *float64 `json:"lat"`
+	Lng        *float64 `json:"lng"`
+	Accuracy   float64  `json:"accuracy"`    // Meters, optional
+	ETAMinutes *int     `json:"eta_minutes"` // Optional revised ETA from the driver's navigation
+	Arrived    bool     `json:"arrived"`     // Marks the order as arrived and ends tracking
+}
+
+// PingLocation records the driver's latest position for a dispatched order by token
+func (h *ClientHandler) PingLocation(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	if token == "" {
+		return response.ErrorCode(c, 400, response.CodeLinkTokenRequired, "Token is required")
+	}
+
+	var req LocationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if req.Lat == nil || req.Lng == nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Lat and lng are required")
+	}
+	if *req.Lat < -90 || *req.Lat > 90 || *req.Lng < -180 || *req.Lng > 180 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Lat or lng is out of range")
+	}
+	if req.ETAMinutes != nil && *req.ETAMinutes < 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "ETA minutes cannot be negative")
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"invoice_token": token}).Decode(order); err != nil {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+	if order.DispatchedAt == nil || order.ArrivedAt != nil {
+		return response.ErrorCode(c, 400, response.CodeOrderInvalidStatus, "Order is not on its way")
+	}
+
+	now := time.Now()
+	location := &models.GeoPoint{
+		Lat:        *req.Lat,
+		Lng:        *req.Lng,
+		Accuracy:   req.Accuracy,
+		RecordedAt: now,
+	}
+	set := bson.M{"driver_location": location}
+	if req.ETAMinutes != nil {
+		set["delivery_eta"] = now.Add(time.Duration(*req.ETAMinutes) * time.Minute)
+	}
+	if req.Arrived {
+		set["arrived_at"] = now
+		set["updated_at"] = now
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": set}); err != nil {
+		return response.Error(c, 500, "Failed to save location")
+	}
+
+	collection.FindOne(ctx, bson.M{"_id": order.ID}).Decode(order)
+
+	return response.Success(c, 200, shipmentStatus(order))
+}
+
+// shipmentStatus is the tracking part of an order shown on the client tracking page
+func shipmentStatus(order *models.Order) fiber.Map {
+	return fiber.Map{
+		"delivery_address": order.DeliveryAddress,
+		"dispatched_at":    order.DispatchedAt,
+		"delivery_eta":     order.DeliveryETA,
+		"arrived_at":       order.ArrivedAt,
+		"driver_location":  order.DriverLocation,
+		"delivery_fee":     order.DeliveryFee,
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/shortlink"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ShortLinkHandler handles short link redirects
+type ShortLinkHandler struct{}
+
+// NewShortLinkHandler creates a new short link handler
+func NewShortLinkHandler() *ShortLinkHandler {
+	return &ShortLinkHandler{}
+}
+
+// Redirect sends the visitor of a short link to its client page
+func (h *ShortLinkHandler) Redirect(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	target, err := shortlink.Resolve(ctx, c.Params("code"))
+	switch {
+	case errors.Is(err, shortlink.ErrNotFound):
+		return response.ErrorCode(c, 404, response.CodeShortLinkNotFound, "Link not found")
+	case errors.Is(err, shortlink.ErrExpired):
+		return response.ErrorCode(c, 410, response.CodeShortLinkExpired, "Link has expired")
+	case err != nil:
+		return response.Error(c, 500, "Failed to open link")
+	}
+
+	return c.Redirect(target, fiber.StatusFound)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/sla"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetSLAPolicy returns the SLA of every operational stage
+func (h *SettingsHandler) GetSLAPolicy(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	return response.Success(c, 200, sla.LoadPolicy(ctx))
+}
+
+// UpdateSLAPolicy saves the SLA of the stages in the request; other stages keep theirs
+func (h *SettingsHandler) UpdateSLAPolicy(c *fiber.Ctx) error {
+	type UpdateRequest struct {
+		Rules []models.SLARule `json:"rules"`
+	}
+
+	var req UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	stages := map[string]bool{}
+	for _, rule := range req.Rules {
+		if !sla.IsStage(rule.Stage) || stages[rule.Stage] {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown or repeated stage: "+rule.Stage)
+		}
+		stages[rule.Stage] = true
+		if rule.Enabled && rule.TargetMinutes <= 0 {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "target_minutes must be positive for "+rule.Stage)
+		}
+		if rule.WarnMinutes < 0 || (rule.TargetMinutes > 0 && rule.WarnMinutes >= rule.TargetMinutes) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "warn_minutes must be between 0 and target_minutes for "+rule.Stage)
+		}
+		if rule.Role != models.RoleAdmin && rule.Role != models.RoleSuperAdmin && rule.Role != models.RoleUser {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "role must be SUPERADMIN, ADMIN or USER for "+rule.Stage)
+		}
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	policy := sla.LoadPolicy(ctx)
+	for i, rule := range policy.Rules {
+		for _, updated := range req.Rules {
+			if updated.Stage == rule.Stage {
+				policy.Rules[i] = updated
+			}
+		}
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"rules":      policy.Rules,
+			"updated_by": middleware.GetUserID(c),
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": now,
+		},
+	}
+
+	collection := database.GetMongoCollection(sla.Collection)
+	if _, err := collection.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return response.Error(c, 500, "Failed to save SLA policy")
+	}
+
+	audit.Log(c, audit.ActionSLAPolicy, sla.Collection, "", map[string]interface{}{
+		"rules": policy.Rules,
+	})
+
+	return response.Success(c, 200, sla.LoadPolicy(ctx))
+}
+
+// applySLAFilter narrows an order listing to the orders in the SLA state of ?sla=
+// (on_track, at_risk or breached)
+func applySLAFilter(c *fiber.Ctx, policy *models.SLAPolicy, filter bson.M, now time.Time) bool {
+	state := c.Query("sla")
+	switch state {
+	case "":
+		return true
+	case models.SLAOnTrack, models.SLAAtRisk, models.SLABreached:
+		// Kept apart from the search condition, which also uses $or
+		filter["$and"] = []bson.M{sla.Filter(policy, state, now)}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxOrderTags caps the tags on a single order
+const maxOrderTags = 10
+
+// maxTagLength caps the length of a tag name
+const maxTagLength = 32
+
+// tagPalette gives tags without a catalog color a stable color
+var tagPalette = []string{"#E53935", "#8E24AA", "#3949AB", "#039BE5", "#00897B", "#7CB342", "#FDD835", "#FB8C00", "#6D4C41", "#546E7A"}
+
+var hexColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// TagHandler handles the order tag catalog
+type TagHandler struct{}
+
+// NewTagHandler creates a new tag handler
+func NewTagHandler() *TagHandler {
+	return &TagHandler{}
+}
+
+// TagRequest is the body for creating or updating a catalog tag
+type TagRequest struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Description string `json:"description"`
+}
+
+// normalizeTag lowercases and trims a tag so "Urgent " and "urgent" are the same tag
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// normalizeTags normalizes and dedupes tags, skipping empty ones
+func normalizeTags(tags []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// defaultTagColor picks a palette color from the tag name, so it never changes between requests
+func defaultTagColor(tag string) string {
+	h := fnv.New32a()
+	h.Write([]byte(tag))
+	return tagPalette[h.Sum32()%uint32(len(tagPalette))]
+}
+
+// tagColors returns the catalog color of every catalog tag
+func tagColors(ctx context.Context) map[string]string {
+	colors := map[string]string{}
+	cursor, err := database.GetMongoCollection("tags").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "color": 1}))
+	if err != nil {
+		return colors
+	}
+	defer cursor.Close(ctx)
+
+	var tags []models.Tag
+	if err := cursor.All(ctx, &tags); err != nil {
+		return colors
+	}
+	for _, tag := range tags {
+		colors[tag.Name] = tag.Color
+	}
+	return colors
+}
+
+// tagHints resolves the display color of each tag
+func tagHints(tags []string, colors map[string]string) []models.TagHint {
+	if len(tags) == 0 {
+		return nil
+	}
+	hints := make([]models.TagHint, len(tags))
+	for i, tag := range tags {
+		color, ok := colors[tag]
+		if !ok || color == "" {
+			color = defaultTagColor(tag)
+		}
+		hints[i] = models.TagHint{Name: tag, Color: color}
+	}
+	return hints
+}
+
+// validate normalizes the request and checks the name and color
+func (req *TagRequest) validate() error {
+	req.Name = normalizeTag(req.Name)
+	if req.Name == "" {
+		return errors.New("Tag name is required")
+	}
+	if len(req.Name) > maxTagLength {
+		return errors.New("Tag name is too long, the limit is 32 characters")
+	}
+	if req.Color == "" {
+		req.Color = defaultTagColor(req.Name)
+	}
+	if !hexColor.MatchString(req.Color) {
+		return errors.New("Color must be a hex color such as #E53935")
+	}
+	req.Color = strings.ToUpper(req.Color)
+	return nil
+}
+
+// List returns the tag catalog together with every tag used on orders, with usage counts
+func (h *TagHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("tags").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch tags")
+	}
+	catalog := []models.Tag{}
+	if err := cursor.All(ctx, &catalog); err != nil {
+		return response.Error(c, 500, "Failed to decode tags")
+	}
+
+	usage, err := tagUsage(ctx, bson.M{})
+	if err != nil {
+		return response.Error(c, 500, "Failed to count tag usage")
+	}
+
+	type TagEntry struct {
+		models.Tag
+		InCatalog bool  `json:"in_catalog"`
+		Orders    int64 `json:"orders"`
+	}
+	entries := []TagEntry{}
+	listed := map[string]bool{}
+	for _, tag := range catalog {
+		listed[tag.Name] = true
+		entries = append(entries, TagEntry{Tag: tag, InCatalog: true, Orders: usage[tag.Name]})
+	}
+	// Free-form tags that are used but not in the catalog
+	for name, count := range usage {
+		if !listed[name] {
+			entries = append(entries, TagEntry{Tag: models.Tag{Name: name, Color: defaultTagColor(name)}, Orders: count})
+		}
+	}
+
+	return response.Success(c, 200, entries)
+}
+
+// tagUsage counts the orders matching filter per tag
+func tagUsage(ctx context.Context, filter bson.M) (map[string]int64, error) {
+	cursor, err := database.GetMongoCollection("orders").Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$unwind": "$tags"},
+		{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	usage := map[string]int64{}
+	for _, row := range rows {
+		usage[row.ID] = row.Count
+	}
+	return usage, nil
+}
+
+// Create adds a tag to the catalog
+func (h *TagHandler) Create(c *fiber.Ctx) error {
+	var req TagRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if err := req.validate(); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	collection := database.GetMongoCollection("tags")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if n, _ := collection.CountDocuments(ctx, bson.M{"name": req.Name}); n > 0 {
+		return response.ErrorCode(c, 400, response.CodeTagExists, "Tag already exists")
+	}
+
+	tag := models.NewTag()
+	tag.Name = req.Name
+	tag.Color = req.Color
+	tag.Description = req.Description
+
+	if _, err := collection.InsertOne(ctx, tag); err != nil {
+		return response.Error(c, 500, "Failed to create tag")
+	}
+
+	audit.Log(c, audit.ActionTagCreate, "tag", tag.ID.Hex(), map[string]interface{}{
+		"name":  tag.Name,
+		"color": tag.Color,
+	})
+
+	return response.Success(c, 201, tag)
+}
+
+// Update changes a catalog tag. Renaming it also renames the tag on every order.
+func (h *TagHandler) Update(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req TagRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	if err := req.validate(); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	collection := database.GetMongoCollection("tags")
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	tag := &models.Tag{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(tag); err != nil {
+		return response.NotFoundCode(c, response.CodeTagNotFound, "Tag not found")
+	}
+	if req.Name != tag.Name {
+		if n, _ := collection.CountDocuments(ctx, bson.M{"name": req.Name}); n > 0 {
+			return response.ErrorCode(c, 400, response.CodeTagExists, "Tag already exists")
+		}
+	}
+
+	oldName := tag.Name
+	var renamed int64
+	err = database.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+			"name":        req.Name,
+			"color":       req.Color,
+			"description": req.Description,
+			"updated_at":  time.Now(),
+		}})
+		if err != nil || req.Name == oldName {
+			return err
+		}
+
+		orders := database.GetMongoCollection("orders")
+		// Orders that already carry the new name only lose the old one
+		if _, err := orders.UpdateMany(ctx, bson.M{"tags": bson.M{"$all": []string{oldName, req.Name}}}, bson.M{"$pull": bson.M{"tags": oldName}}); err != nil {
+			return err
+		}
+		result, err := orders.UpdateMany(ctx, bson.M{"tags": oldName}, bson.M{"$set": bson.M{"tags.$": req.Name}})
+		if err != nil {
+			return err
+		}
+		renamed = result.ModifiedCount
+		return nil
+	})
+	if err != nil {
+		return response.Error(c, 500, "Failed to update tag")
+	}
+
+	audit.Log(c, audit.ActionTagUpdate, "tag", objID.Hex(), map[string]interface{}{
+		"old_name":       oldName,
+		"name":           req.Name,
+		"color":          req.Color,
+		"orders_renamed": renamed,
+	})
+
+	tag.Name, tag.Color, tag.Description = req.Name, req.Color, req.Description
+	return response.Success(c, 200, tag)
+}
+
+// Delete removes a tag from the catalog. Orders keep the tag unless remove_from_orders=true.
+func (h *TagHandler) Delete(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("tags")
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	tag := &models.Tag{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(tag); err != nil {
+		return response.NotFoundCode(c, response.CodeTagNotFound, "Tag not found")
+	}
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": objID}); err != nil {
+		return response.Error(c, 500, "Failed to delete tag")
+	}
+
+	var removed int64
+	if c.QueryBool("remove_from_orders", false) {
+		result, err := database.GetMongoCollection("orders").UpdateMany(ctx, bson.M{"tags": tag.Name}, bson.M{"$pull": bson.M{"tags": tag.Name}})
+		if err != nil {
+			return response.Error(c, 500, "Failed to remove tag from orders")
+		}
+		removed = result.ModifiedCount
+	}
+
+	audit.Log(c, audit.ActionTagDelete, "tag", objID.Hex(), map[string]interface{}{
+		"name":           tag.Name,
+		"orders_removed": removed,
+	})
+
+	return response.SuccessWithMessage(c, 200, "Successfully deleted")
+}
+
+// SetTags replaces the tags of an order
+func (h *OrderHandler) SetTags(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	type TagsRequest struct {
+		Tags []string `json:"tags"`
+	}
+
+	var req TagsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	tags := normalizeTags(req.Tags)
+	if len(tags) > maxOrderTags {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Too many tags, the limit is 10 per order")
+	}
+	for _, tag := range tags {
+		if len(tag) > maxTagLength {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Tag \""+tag+"\" is too long, the limit is 32 characters")
+		}
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"tags": tags, "updated_at": time.Now()}})
+	if err != nil {
+		return response.Error(c, 500, "Failed to update tags")
+	}
+	if result.MatchedCount == 0 {
+		return response.NotFoundCode(c, response.CodeOrderNotFound, "Order not found")
+	}
+
+	audit.Log(c, audit.ActionOrderTags, "order", objID.Hex(), map[string]interface{}{
+		"tags": tags,
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"tags":      tags,
+		"tag_hints": tagHints(tags, tagColors(ctx)),
+	})
+}
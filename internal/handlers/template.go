@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"unicode/utf8"
+
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TemplateHandler handles notification template previews
+type TemplateHandler struct{}
+
+// NewTemplateHandler creates a new template handler
+func NewTemplateHandler() *TemplateHandler {
+	return &TemplateHandler{}
+}
+
+// Preview renders a notification template with sample values and the sender branding,
+// exactly as a recipient would read it. template previews a draft, status the saved
+// template of an order status; sender_name, signature and footer preview branding that
+// is not saved yet.
+func (h *TemplateHandler) Preview(c *fiber.Ctx) error {
+	type PreviewRequest struct {
+		Template   string  `json:"template"`
+		Status     string  `json:"status"`
+		SenderName *string `json:"sender_name"`
+		Signature  *string `json:"signature"`
+		Footer     *string `json:"footer"`
+	}
+
+	var req PreviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	template := req.Template
+	if template == "" && req.Status != "" {
+		if !validOrderStatus(req.Status) {
+			return response.ErrorCode(c, 400, response.CodeValidationFailed, "Unknown status: "+req.Status)
+		}
+		template = notification.StatusRule(ctx, req.Status).Template
+	}
+	if template == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Template or status is required")
+	}
+
+	branding := notification.LoadBranding(ctx)
+	if req.SenderName != nil {
+		branding.SenderName = *req.SenderName
+	}
+	if req.Signature != nil {
+		branding.Signature = *req.Signature
+	}
+	if req.Footer != nil {
+		branding.Footer = *req.Footer
+	}
+
+	message := notification.RenderPreview(template, branding)
+	return response.Success(c, 200, fiber.Map{
+		"message":  message,
+		"length":   utf8.RuneCountInString(message),
+		"branding": branding,
+	})
+}
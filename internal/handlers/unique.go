@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// duplicateError is the response to a write violating a unique index
+type duplicateError struct {
+	code    response.Code
+	message string
+}
+
+// duplicateErrors maps the unique indexes of the core collections to their responses.
+// Generated values like tokens only collide by chance, so the client is asked to retry.
+var duplicateErrors = map[string]duplicateError{
+	database.IndexUsername:      {response.CodeUsernameTaken, "Username already exists"},
+	database.IndexSalesPhone:    {response.CodeSalesPhoneTaken, "Another active sales already uses this phone number"},
+	database.IndexOrderNumber:   {response.CodeOrderNumberTaken, "Order number already exists"},
+	database.IndexInvoiceToken:  {response.CodeConflict, "Generated invoice link already exists, please retry"},
+	database.IndexQueueToken:    {response.CodeConflict, "Generated queue token already exists, please retry"},
+	database.IndexPendingCancel: {response.CodeCancelPending, "A cancellation request is already waiting for review"},
+}
+
+// writeFailed responds to a failed insert or update, with 409 when it violated a unique
+// index so a request racing past the handler's own check still gets a friendly error
+func writeFailed(c *fiber.Ctx, err error, message string) error {
+	index, duplicate := database.DuplicateKeyIndex(err)
+	if !duplicate {
+		return response.Error(c, 500, message)
+	}
+	if dup, known := duplicateErrors[index]; known {
+		return response.ErrorCode(c, fiber.StatusConflict, dup.code, dup.message)
+	}
+	return response.ErrorCode(c, fiber.StatusConflict, response.CodeConflict, "A record with the same value already exists")
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/uom"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UnitHandler handles the unit of measure registry
+type UnitHandler struct{}
+
+// NewUnitHandler creates a new unit handler
+func NewUnitHandler() *UnitHandler {
+	return &UnitHandler{}
+}
+
+// UnitRequest is the body for creating or updating a unit
+type UnitRequest struct {
+	Code      string   `json:"code"`
+	Name      string   `json:"name"`
+	Dimension string   `json:"dimension"`
+	Factor    float64  `json:"factor"`
+	Aliases   []string `json:"aliases"`
+}
+
+// validate normalizes the request and checks it against the other units of the registry
+func (req *UnitRequest) validate(registry *uom.Registry, self string) error {
+	req.Code = uom.Normalize(req.Code)
+	if req.Code == "" {
+		return errors.New("Unit code is required")
+	}
+	if _, ok := uom.BaseUnits[req.Dimension]; !ok {
+		return errors.New("Dimension must be count, mass, volume or length")
+	}
+	if req.Factor <= 0 {
+		return errors.New("Factor must be positive")
+	}
+	if req.Name == "" {
+		req.Name = req.Code
+	}
+
+	aliases := []string{}
+	seen := map[string]bool{req.Code: true}
+	for _, alias := range req.Aliases {
+		alias = uom.Normalize(alias)
+		if alias == "" || seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		aliases = append(aliases, alias)
+	}
+	req.Aliases = aliases
+
+	for name := range seen {
+		if unit, taken := registry.Lookup(name); taken && unit.Code != self {
+			return fmt.Errorf("%s is already used by the unit %s", name, unit.Code)
+		}
+	}
+	return nil
+}
+
+// unknownUnit rejects an entered unit that is not in the registry, suggesting the
+// closest registered units
+func unknownUnit(c *fiber.Ctx, registry *uom.Registry, index int, unit string) error {
+	return response.ErrorCodeWithData(c, 400, response.CodeUnitUnknown,
+		fmt.Sprintf("Unknown unit %q", unit), fiber.Map{
+			"index":       index,
+			"unit":        unit,
+			"suggestions": registry.Suggest(unit),
+		})
+}
+
+// unitsUnavailable answers a request when the unit registry cannot be read
+func unitsUnavailable(c *fiber.Ctx) error {
+	return response.Error(c, 500, "Failed to load units")
+}
+
+// List returns the unit registry
+func (h *UnitHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	units, err := uom.LoadUnits(ctx)
+	if err != nil {
+		return unitsUnavailable(c)
+	}
+	return response.Success(c, 200, fiber.Map{
+		"units":      units,
+		"base_units": uom.BaseUnits,
+	})
+}
+
+// Check looks up an entered unit, so forms can offer suggestions for typos before saving
+func (h *UnitHandler) Check(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	registry, err := uom.Load(ctx)
+	if err != nil {
+		return unitsUnavailable(c)
+	}
+	entered := c.Query("unit")
+	unit, known := registry.Lookup(entered)
+	if !known {
+		return response.Success(c, 200, fiber.Map{
+			"known":       false,
+			"unit":        entered,
+			"suggestions": registry.Suggest(entered),
+		})
+	}
+	return response.Success(c, 200, fiber.Map{
+		"known":     true,
+		"unit":      entered,
+		"code":      unit.Code,
+		"dimension": unit.Dimension,
+		"factor":    unit.Factor,
+		"base_unit": uom.BaseUnits[unit.Dimension],
+	})
+}
+
+// Create adds a unit to the registry
+func (h *UnitHandler) Create(c *fiber.Ctx) error {
+	var req UnitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	collection := database.GetMongoCollection(uom.Collection)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	// Saving the first unit replaces the defaults, so keep them
+	if n, _ := collection.CountDocuments(ctx, bson.M{}); n == 0 {
+		uom.EnsureDefaults()
+	}
+
+	registry, err := uom.Load(ctx)
+	if err != nil {
+		return unitsUnavailable(c)
+	}
+	if err := req.validate(registry, ""); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	unit := models.NewUnit()
+	unit.Code = req.Code
+	unit.Name = req.Name
+	unit.Dimension = req.Dimension
+	unit.Factor = req.Factor
+	unit.Aliases = req.Aliases
+
+	if _, err := collection.InsertOne(ctx, unit); err != nil {
+		return response.Error(c, 500, "Failed to create unit")
+	}
+
+	audit.Log(c, audit.ActionUnitCreate, "unit", unit.ID.Hex(), map[string]interface{}{
+		"code":   unit.Code,
+		"factor": unit.Factor,
+	})
+
+	return response.Success(c, 201, unit)
+}
+
+// Update changes a unit. Its code cannot change, and items entered before keep the base
+// quantity they were converted to.
+func (h *UnitHandler) Update(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req UnitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	collection := database.GetMongoCollection(uom.Collection)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	unit := &models.Unit{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(unit); err != nil {
+		return response.NotFoundCode(c, response.CodeUnitNotFound, "Unit not found")
+	}
+	req.Code = unit.Code
+	registry, err := uom.Load(ctx)
+	if err != nil {
+		return unitsUnavailable(c)
+	}
+	if err := req.validate(registry, unit.Code); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"name":       req.Name,
+		"dimension":  req.Dimension,
+		"factor":     req.Factor,
+		"aliases":    req.Aliases,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		return response.Error(c, 500, "Failed to update unit")
+	}
+
+	audit.Log(c, audit.ActionUnitUpdate, "unit", objID.Hex(), map[string]interface{}{
+		"code":       unit.Code,
+		"old_factor": unit.Factor,
+		"factor":     req.Factor,
+	})
+
+	unit.Name, unit.Dimension, unit.Factor, unit.Aliases = req.Name, req.Dimension, req.Factor, req.Aliases
+	return response.Success(c, 200, unit)
+}
+
+// Delete removes a unit from the registry. Items already entered in it keep their unit
+// and base quantity.
+func (h *UnitHandler) Delete(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection(uom.Collection)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	unit := &models.Unit{}
+	if err := collection.FindOneAndDelete(ctx, bson.M{"_id": objID}).Decode(unit); err != nil {
+		return response.NotFoundCode(c, response.CodeUnitNotFound, "Unit not found")
+	}
+
+	audit.Log(c, audit.ActionUnitDelete, "unit", objID.Hex(), map[string]interface{}{
+		"code": unit.Code,
+	})
+
+	return response.SuccessWithMessage(c, 200, "Successfully deleted")
+}
+
+// ShippedQuantity is the quantity shipped in a month in one dimension
+type ShippedQuantity struct {
+	Month          string   `json:"month"` // YYYY-MM
+	Dimension      string   `json:"dimension"`
+	BaseUnit       string   `json:"base_unit"`
+	Quantity       float64  `json:"quantity"`                   // In the base unit
+	Unit           string   `json:"unit,omitempty"`             // The requested display unit, for its dimension
+	QuantityInUnit *float64 `json:"quantity_in_unit,omitempty"` // Quantity converted to Unit
+	DeliveryNotes  int      `json:"delivery_notes"`
+	notes          map[string]bool
+}
+
+// ShippedQuantities totals the quantities on current delivery notes per month and
+// dimension in base units, e.g. the tonnage shipped per month with unit=ton. Items in
+// units that are not registered are listed separately.
+func (h *ReportHandler) ShippedQuantities(c *fiber.Ctx) error {
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	ctx, cancel := requestContext(c, reportTimeout)
+	defer cancel()
+
+	registry, err := uom.Load(ctx)
+	if err != nil {
+		return unitsUnavailable(c)
+	}
+	display, hasDisplay := models.Unit{}, false
+	if v := c.Query("unit"); v != "" {
+		if display, hasDisplay = registry.Lookup(v); !hasDisplay {
+			return unknownUnit(c, registry, 0, v)
+		}
+	}
+
+	// Total per month, unit and stored base unit in the database; notes from before
+	// multi-item orders carry only the product quantity
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": from, "$lt": to}, "superseded": bson.M{"$ne": true}}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$items", "preserveNullAndEmptyArrays": true}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"month":     bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$created_at", "timezone": utils.Timezone}},
+				"unit":      bson.M{"$ifNull": bson.A{"$items.unit", "$product_unit"}},
+				"base_unit": bson.M{"$ifNull": bson.A{"$items.base_unit", ""}},
+			},
+			"quantity":      bson.M{"$sum": bson.M{"$ifNull": bson.A{"$items.quantity", "$product_qty"}}},
+			"base_quantity": bson.M{"$sum": "$items.base_quantity"},
+			"notes":         bson.M{"$addToSet": "$_id"},
+		}}},
+	}
+	cursor, err := database.GetMongoCollection("delivery_notes").Aggregate(ctx, pipeline)
+	if err != nil {
+		return response.Error(c, 500, "Failed to aggregate shipped quantities")
+	}
+	var groups []struct {
+		ID struct {
+			Month    string `bson:"month"`
+			Unit     string `bson:"unit"`
+			BaseUnit string `bson:"base_unit"`
+		} `bson:"_id"`
+		Quantity     float64              `bson:"quantity"`
+		BaseQuantity float64              `bson:"base_quantity"`
+		Notes        []primitive.ObjectID `bson:"notes"`
+	}
+	err = cursor.All(ctx, &groups)
+	cursor.Close(ctx)
+	if err != nil {
+		return response.Error(c, 500, "Failed to decode shipped quantities")
+	}
+
+	totals := map[string]*ShippedQuantity{}
+	unconverted := map[string]float64{}
+	for _, group := range groups {
+		quantity, baseUnit := group.BaseQuantity, group.ID.BaseUnit
+		if baseUnit == "" {
+			var known bool
+			if quantity, baseUnit, known = registry.ToBase(group.Quantity, group.ID.Unit); !known {
+				unconverted[uom.Normalize(group.ID.Unit)] += group.Quantity
+				continue
+			}
+		}
+		dimension := dimensionOf(baseUnit)
+
+		key := group.ID.Month + "/" + dimension
+		row := totals[key]
+		if row == nil {
+			row = &ShippedQuantity{Month: group.ID.Month, Dimension: dimension, BaseUnit: baseUnit, notes: map[string]bool{}}
+			totals[key] = row
+		}
+		row.Quantity += quantity
+		for _, id := range group.Notes {
+			row.notes[id.Hex()] = true
+		}
+	}
+
+	rows := make([]*ShippedQuantity, 0, len(totals))
+	for _, row := range totals {
+		row.Quantity = math.Round(row.Quantity*1000) / 1000
+		row.DeliveryNotes = len(row.notes)
+		if hasDisplay && display.Dimension == row.Dimension {
+			converted := math.Round(row.Quantity/display.Factor*1000) / 1000
+			row.Unit, row.QuantityInUnit = display.Code, &converted
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Month != rows[j].Month {
+			return rows[i].Month < rows[j].Month
+		}
+		return rows[i].Dimension < rows[j].Dimension
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"from":        from,
+		"to":          to,
+		"months":      rows,
+		"unconverted": unconverted,
+	})
+}
+
+// dimensionOf returns the dimension of a base unit
+func dimensionOf(baseUnit string) string {
+	for dimension, unit := range uom.BaseUnits {
+		if unit == baseUnit {
+			return dimension
+		}
+	}
+	return ""
+}
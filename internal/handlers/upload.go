@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/breaker"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadFailed responds to a failed upload, with 503 when the CDN breaker shed it
+// so clients know to retry instead of showing a generic error
+func uploadFailed(c *fiber.Ctx, err error, message string) error {
+	if breaker.IsUnavailable(err) {
+		return response.Error(c, fiber.StatusServiceUnavailable, "File storage is busy, please retry shortly")
+	}
+	if errors.Is(err, file.ErrImageTooLarge) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "The photo is too large, send one of at most 50 megapixels")
+	}
+	return response.Error(c, 500, message)
+}
+
+// UploadHandler handles routes for browsing and cleaning up uploaded files
+type UploadHandler struct{}
+
+// NewUploadHandler creates a new upload handler
+func NewUploadHandler() *UploadHandler {
+	return &UploadHandler{}
+}
+
+// List returns a page of the uploaded images of one category, by CDN cursor
+func (h *UploadHandler) List(c *fiber.Ctx) error {
+	category := c.Query("category")
+	if !file.IsCategory(category) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Category must be one of: "+strings.Join(file.Categories, ", "))
+	}
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > maxPageLimit {
+		limit = 50
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	assets, next, err := file.ListCategory(ctx, category, c.Query("cursor"), limit)
+	if err != nil {
+		return uploadFailed(c, err, "Failed to list uploads")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"category":    category,
+		"assets":      assets,
+		"next_cursor": next,
+	})
+}
+
+// Categories returns the upload categories
+func (h *UploadHandler) Categories(c *fiber.Ctx) error {
+	return response.Success(c, 200, file.Categories)
+}
+
+// CleanupOrphans lists CDN files that no document references and deletes them.
+// With dry_run=true (the default) nothing is deleted.
+func (h *UploadHandler) CleanupOrphans(c *fiber.Ctx) error {
+	dryRun := c.QueryBool("dry_run", true)
+
+	ctx, cancel := requestContext(c, bulkTimeout)
+	defer cancel()
+
+	report, err := file.FindOrphans(ctx, dryRun)
+	if err != nil {
+		return uploadFailed(c, err, "Failed to clean up orphaned uploads")
+	}
+
+	if !dryRun {
+		audit.Log(c, audit.ActionUploadCleanup, "uploads", "", map[string]interface{}{
+			"deleted": report.Deleted,
+			"failed":  report.Failed,
+		})
+	}
+
+	return response.Success(c, 200, report)
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// viewFilterKeys lists the query parameters a view may set per resource
+var viewFilterKeys = map[string][]string{
+	models.ViewResourceOrders:   {"search", "status", "sales_id", "tags", "tag_mode", "overdue", "walk_in"},
+	models.ViewResourcePayments: {"search", "sales_id", "tags", "tag_mode", "overdue"},
+	models.ViewResourceQueue:    {"status", "sales_id", "tags", "tag_mode", "overdue", "walk_in"},
+}
+
+// viewSortFields lists the fields a list can be sorted by per resource
+var viewSortFields = map[string][]string{
+	models.ViewResourceOrders:   {"created_at", "updated_at", "order_number", "total_price", "status", "due_date"},
+	models.ViewResourcePayments: {"payment_uploaded_at", "updated_at", "created_at", "total_price"},
+	models.ViewResourceQueue:    {"queue_number", "queue_entered_at", "queue_called_at"},
+}
+
+// ViewHandler handles saved list views
+type ViewHandler struct{}
+
+// NewViewHandler creates a new view handler
+func NewViewHandler() *ViewHandler {
+	return &ViewHandler{}
+}
+
+// ViewRequest is the body for saving a view
+type ViewRequest struct {
+	Name     string            `json:"name"`
+	Resource string            `json:"resource"`
+	Filter   map[string]string `json:"filter"`
+	Sort     string            `json:"sort"`
+	Shared   bool              `json:"shared"`
+}
+
+// apply validates the request and copies it onto a view
+func (req *ViewRequest) apply(view *models.SavedView) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("Name is required")
+	}
+	keys, ok := viewFilterKeys[req.Resource]
+	if !ok {
+		return errors.New("Resource must be orders, payments or queue")
+	}
+
+	filter := map[string]string{}
+	for key, value := range req.Filter {
+		if !containsString(keys, key) {
+			return errors.New("Filter " + key + " is not supported for " + req.Resource + ", use one of: " + strings.Join(keys, ", "))
+		}
+		if value != "" {
+			filter[key] = value
+		}
+	}
+	if req.Sort != "" && !containsString(viewSortFields[req.Resource], strings.TrimPrefix(req.Sort, "-")) {
+		return errors.New("Sort must be one of: " + strings.Join(viewSortFields[req.Resource], ", "))
+	}
+
+	view.Name = req.Name
+	view.Resource = req.Resource
+	view.Filter = filter
+	view.Sort = req.Sort
+	view.Shared = req.Shared
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleViews matches the views the current user owns or that are shared
+func visibleViews(c *fiber.Ctx) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"owner_id": middleware.GetUserID(c)},
+		{"shared": true},
+	}}
+}
+
+// List returns the user's own views and the shared ones, optionally for one resource
+func (h *ViewHandler) List(c *fiber.Ctx) error {
+	filter := visibleViews(c)
+	if resource := c.Query("resource"); resource != "" {
+		filter["resource"] = resource
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("views").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch views")
+	}
+	defer cursor.Close(ctx)
+
+	views := []models.SavedView{}
+	if err := cursor.All(ctx, &views); err != nil {
+		return response.Error(c, 500, "Failed to decode views")
+	}
+
+	return response.Success(c, 200, fiber.Map{
+		"views":       views,
+		"filter_keys": viewFilterKeys,
+		"sort_fields": viewSortFields,
+	})
+}
+
+// Detail returns a single view
+func (h *ViewHandler) Detail(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	filter := visibleViews(c)
+	filter["_id"] = objID
+
+	view := &models.SavedView{}
+	if err := database.GetMongoCollection("views").FindOne(ctx, filter).Decode(view); err != nil {
+		return response.NotFoundCode(c, response.CodeViewNotFound, "View not found")
+	}
+
+	return response.Success(c, 200, view)
+}
+
+// Create saves a new view owned by the current user
+func (h *ViewHandler) Create(c *fiber.Ctx) error {
+	var req ViewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	view := models.NewSavedView()
+	if err := req.apply(view); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	view.OwnerID = middleware.GetUserID(c)
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if _, err := database.GetMongoCollection("views").InsertOne(ctx, view); err != nil {
+		return response.Error(c, 500, "Failed to save view")
+	}
+
+	return response.Success(c, 201, view)
+}
+
+// Update replaces a view; only its owner or a superadmin may change it
+func (h *ViewHandler) Update(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	var req ViewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	collection := database.GetMongoCollection("views")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	view := &models.SavedView{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(view); err != nil {
+		return response.NotFoundCode(c, response.CodeViewNotFound, "View not found")
+	}
+	if !canEditView(c, view) {
+		return response.ErrorCode(c, 403, response.CodeInsufficientPermissions, "Only the owner can change this view")
+	}
+
+	if err := req.apply(view); err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	view.UpdatedAt = time.Now()
+
+	if _, err := collection.ReplaceOne(ctx, bson.M{"_id": objID}, view); err != nil {
+		return response.Error(c, 500, "Failed to update view")
+	}
+
+	return response.Success(c, 200, view)
+}
+
+// Delete deletes a view; only its owner or a superadmin may delete it
+func (h *ViewHandler) Delete(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("views")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	view := &models.SavedView{}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(view); err != nil {
+		return response.NotFoundCode(c, response.CodeViewNotFound, "View not found")
+	}
+	if !canEditView(c, view) {
+		return response.ErrorCode(c, 403, response.CodeInsufficientPermissions, "Only the owner can delete this view")
+	}
+
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": objID}); err != nil {
+		return response.Error(c, 500, "Failed to delete view")
+	}
+
+	return response.SuccessWithMessage(c, 200, "Successfully deleted")
+}
+
+func canEditView(c *fiber.Ctx, view *models.SavedView) bool {
+	return view.OwnerID == middleware.GetUserID(c) || middleware.GetUserRole(c) == models.RoleSuperAdmin
+}
+
+// applyView loads the view in the "view" query parameter and sets its filter and sort
+// as query parameters of the request, so the list handler reads them like any other.
+// Parameters given explicitly in the request win over the view's.
+// On failure it returns the error code to respond with through viewFailed.
+func applyView(c *fiber.Ctx, resource string) (response.Code, error) {
+	id := c.Query("view")
+	if id == "" {
+		return "", nil
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return response.CodeInvalidID, errors.New("Invalid view ID")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	filter := visibleViews(c)
+	filter["_id"] = objID
+
+	view := &models.SavedView{}
+	if err := database.GetMongoCollection("views").FindOne(ctx, filter).Decode(view); err != nil {
+		return response.CodeViewNotFound, errors.New("View not found")
+	}
+	if view.Resource != resource {
+		return response.CodeValidationFailed, errors.New("View is for " + view.Resource + ", not " + resource)
+	}
+
+	args := c.Request().URI().QueryArgs()
+	for key, value := range view.Filter {
+		if c.Query(key) == "" {
+			args.Set(key, value)
+		}
+	}
+	if view.Sort != "" && c.Query("sort") == "" {
+		args.Set("sort", view.Sort)
+	}
+	return "", nil
+}
+
+// viewFailed responds with the error of applyView
+func viewFailed(c *fiber.Ctx, code response.Code, err error) error {
+	if code == response.CodeViewNotFound {
+		return response.NotFoundCode(c, code, err.Error())
+	}
+	return response.ErrorCode(c, 400, code, err.Error())
+}
+
+// listSort returns the sort from the "sort" query parameter ("field" or "-field"),
+// or fallback when it is missing or not allowed for the resource
+func listSort(c *fiber.Ctx, resource string, fallback bson.D) bson.D {
+	sort := c.Query("sort")
+	field := strings.TrimPrefix(sort, "-")
+	if sort == "" || !containsString(viewSortFields[resource], field) {
+		return fallback
+	}
+	order := 1
+	if strings.HasPrefix(sort, "-") {
+		order = -1
+	}
+	return bson.D{{Key: field, Value: order}, {Key: "_id", Value: order}}
+}
+
+// applyOrderFilters adds the filters shared by the order based lists to filter:
+// search (order number), sales_id, tags (any of them; tag_mode=all for every one) and
+// overdue (true for orders flagged by the overdue job, false for the others) and walk_in
+func applyOrderFilters(c *fiber.Ctx, filter bson.M) {
+	if search := c.Query("search"); search != "" {
+		filter["$or"] = []bson.M{
+			{"order_number": bson.M{"$regex": search, "$options": "i"}},
+		}
+	}
+	if salesID := c.Query("sales_id"); salesID != "" {
+		filter["sales_id"] = salesID
+	}
+	if tags := normalizeTags(strings.Split(c.Query("tags"), ",")); len(tags) > 0 {
+		if c.Query("tag_mode") == "all" {
+			filter["tags"] = bson.M{"$all": tags}
+		} else {
+			filter["tags"] = bson.M{"$in": tags}
+		}
+	}
+	switch c.Query("overdue") {
+	case "true":
+		filter["overdue"] = true
+	case "false":
+		filter["overdue"] = bson.M{"$ne": true}
+	}
+	switch c.Query("walk_in") {
+	case "true":
+		filter["walk_in"] = true
+	case "false":
+		filter["walk_in"] = bson.M{"$ne": true}
+	}
+}
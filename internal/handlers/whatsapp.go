@@ -15,8 +15,15 @@ func NewWhatsAppHandler() *WhatsAppHandler {
 	return &WhatsAppHandler{}
 }
 
-// GetStatus returns WhatsApp connection status
+// GetStatus returns WhatsApp connection status. The top level describes the whatsmeow
+// session, which pairing works with; providers holds the status of every configured
+// provider and active the one sending messages right now.
 func (h *WhatsAppHandler) GetStatus(c *fiber.Ctx) error {
+	active := ""
+	if provider := whatsapp.Active(); provider != nil {
+		active = provider.Name()
+	}
+
 	if whatsapp.WhatsApp == nil {
 		return response.Success(c, 200, fiber.Map{
 			"connected":     false,
@@ -24,17 +31,21 @@ func (h *WhatsAppHandler) GetStatus(c *fiber.Ctx) error {
 			"qr_code":       "",
 			"qr_code_image": "",
 			"message":       "WhatsApp not initialized",
+			"active":        active,
+			"providers":     whatsapp.Statuses(),
 		})
 	}
 
 	status := whatsapp.WhatsApp.GetStatus()
+	status["active"] = active
+	status["providers"] = whatsapp.Statuses()
 	return response.Success(c, 200, status)
 }
 
 // Connect starts WhatsApp connection
 func (h *WhatsAppHandler) Connect(c *fiber.Ctx) error {
 	if whatsapp.WhatsApp == nil {
-		return response.Error(c, 500, "WhatsApp not initialized")
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, "WhatsApp not initialized")
 	}
 
 	err := whatsapp.WhatsApp.Connect()
@@ -50,7 +61,7 @@ func (h *WhatsAppHandler) Connect(c *fiber.Ctx) error {
 // Disconnect disconnects WhatsApp
 func (h *WhatsAppHandler) Disconnect(c *fiber.Ctx) error {
 	if whatsapp.WhatsApp == nil {
-		return response.Error(c, 500, "WhatsApp not initialized")
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, "WhatsApp not initialized")
 	}
 
 	whatsapp.WhatsApp.Disconnect()
@@ -62,7 +73,7 @@ func (h *WhatsAppHandler) Disconnect(c *fiber.Ctx) error {
 // Logout logs out and clears session
 func (h *WhatsAppHandler) Logout(c *fiber.Ctx) error {
 	if whatsapp.WhatsApp == nil {
-		return response.Error(c, 500, "WhatsApp not initialized")
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, "WhatsApp not initialized")
 	}
 
 	err := whatsapp.WhatsApp.Logout()
@@ -78,7 +89,7 @@ func (h *WhatsAppHandler) Logout(c *fiber.Ctx) error {
 // Restart restarts WhatsApp connection
 func (h *WhatsAppHandler) Restart(c *fiber.Ctx) error {
 	if whatsapp.WhatsApp == nil {
-		return response.Error(c, 500, "WhatsApp not initialized")
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, "WhatsApp not initialized")
 	}
 
 	err := whatsapp.WhatsApp.Restart()
@@ -100,29 +111,27 @@ func (h *WhatsAppHandler) SendMessage(c *fiber.Ctx) error {
 
 	var req SendRequest
 	if err := c.BodyParser(&req); err != nil {
-		return response.BadRequest(c, "Invalid request body")
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
 	}
 
 	if req.Phone == "" || req.Message == "" {
-		return response.BadRequest(c, "Phone and message are required")
-	}
-
-	if whatsapp.WhatsApp == nil {
-		return response.Error(c, 500, "WhatsApp not initialized")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Phone and message are required")
 	}
 
-	if !whatsapp.WhatsApp.IsLoggedIn() {
-		return response.Error(c, 500, "WhatsApp not logged in")
+	provider := whatsapp.Active()
+	if provider == nil {
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, "No WhatsApp provider is logged in")
 	}
 
-	err := whatsapp.WhatsApp.SendMessage(req.Phone, req.Message)
+	err := provider.SendMessage(req.Phone, req.Message)
 	if err != nil {
 		return response.Error(c, 500, "Failed to send message: "+err.Error())
 	}
 
 	return response.Success(c, 200, fiber.Map{
-		"message": "Message sent successfully",
-		"phone":   req.Phone,
+		"message":  "Message sent successfully",
+		"phone":    req.Phone,
+		"provider": provider.Name(),
 	})
 }
 
@@ -130,25 +139,23 @@ func (h *WhatsAppHandler) SendMessage(c *fiber.Ctx) error {
 func (h *WhatsAppHandler) SendTestMessage(c *fiber.Ctx) error {
 	phone := c.Query("phone")
 	if phone == "" {
-		return response.BadRequest(c, "Phone parameter is required")
-	}
-
-	if whatsapp.WhatsApp == nil {
-		return response.Error(c, 500, "WhatsApp not initialized")
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Phone parameter is required")
 	}
 
-	if !whatsapp.WhatsApp.IsLoggedIn() {
-		return response.Error(c, 500, "WhatsApp not logged in")
+	provider := whatsapp.Active()
+	if provider == nil {
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, "No WhatsApp provider is logged in")
 	}
 
 	message := "Test message from LabaLaba Nusantara - " + `{{date}}`
-	err := whatsapp.WhatsApp.SendMessage(phone, message)
+	err := provider.SendMessage(phone, message)
 	if err != nil {
 		return response.Error(c, 500, "Failed to send test message: "+err.Error())
 	}
 
 	return response.Success(c, 200, fiber.Map{
-		"message": "Test message sent successfully",
-		"phone":   phone,
+		"message":  "Test message sent successfully",
+		"phone":    phone,
+		"provider": provider.Name(),
 	})
 }
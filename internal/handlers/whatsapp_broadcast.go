@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/whatsapp"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BroadcastRequest is the body of a broadcast. Targets are the selected groups, every
+// joined group (all_groups), and/or every active sales rep (all_sales).
+type BroadcastRequest struct {
+	Message   string   `json:"message"`
+	GroupJIDs []string `json:"group_jids"`
+	AllGroups bool     `json:"all_groups"`
+	AllSales  bool     `json:"all_sales"`
+}
+
+// whatsAppReady returns why WhatsApp cannot send group messages right now, or nil
+func whatsAppReady() error {
+	sender := whatsapp.Groups()
+	if sender == nil {
+		return errors.New("WhatsApp not initialized")
+	}
+	if !sender.IsLoggedIn() {
+		return errors.New("WhatsApp not logged in")
+	}
+	return nil
+}
+
+// Groups lists the WhatsApp groups the connected account participates in
+func (h *WhatsAppHandler) Groups(c *fiber.Ctx) error {
+	if err := whatsAppReady(); err != nil {
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, err.Error())
+	}
+
+	groups, err := whatsapp.Groups().JoinedGroups()
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch groups: "+err.Error())
+	}
+
+	return response.Success(c, 200, groups)
+}
+
+// Broadcast sends an announcement to groups and/or sales reps. Messages go out in the
+// background, one every WHATSAPP_BROADCAST_INTERVAL; poll the returned broadcast for progress.
+func (h *WhatsAppHandler) Broadcast(c *fiber.Ctx) error {
+	var req BroadcastRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Message is required")
+	}
+	if len(req.GroupJIDs) == 0 && !req.AllGroups && !req.AllSales {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Select groups, all_groups or all_sales")
+	}
+	if err := whatsAppReady(); err != nil {
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, err.Error())
+	}
+
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	targets, err := broadcastTargets(ctx, &req)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, err.Error())
+	}
+	if len(targets) == 0 {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "No recipients matched")
+	}
+
+	broadcast := &models.Broadcast{
+		ID:        primitive.NewObjectID(),
+		Message:   req.Message,
+		Status:    models.BroadcastStatusRunning,
+		Targets:   targets,
+		CreatedBy: middleware.GetUserID(c),
+		CreatedAt: time.Now(),
+	}
+	if _, err := database.GetMongoCollection("whatsapp_broadcasts").InsertOne(ctx, broadcast); err != nil {
+		return response.Error(c, 500, "Failed to create broadcast")
+	}
+
+	audit.Log(c, audit.ActionBroadcastSend, "whatsapp_broadcast", broadcast.ID.Hex(), map[string]interface{}{
+		"targets": len(targets),
+	})
+
+	go runBroadcast(broadcast.ID, broadcast.Message, targets)
+
+	return response.Success(c, 202, broadcast)
+}
+
+// broadcastTargets resolves the request into recipients; selected groups must be joined
+func broadcastTargets(ctx context.Context, req *BroadcastRequest) ([]models.BroadcastTarget, error) {
+	targets := []models.BroadcastTarget{}
+
+	if req.AllGroups || len(req.GroupJIDs) > 0 {
+		groups, err := whatsapp.Groups().JoinedGroups()
+		if err != nil {
+			return nil, errors.New("Failed to fetch groups: " + err.Error())
+		}
+		joined := map[string]whatsapp.Group{}
+		for _, group := range groups {
+			joined[group.JID] = group
+		}
+
+		selected := req.GroupJIDs
+		if req.AllGroups {
+			selected = []string{}
+			for _, group := range groups {
+				selected = append(selected, group.JID)
+			}
+		}
+		seen := map[string]bool{}
+		for _, jid := range selected {
+			group, ok := joined[jid]
+			if !ok {
+				return nil, errors.New("Not a member of group " + jid)
+			}
+			if seen[jid] {
+				continue
+			}
+			// Only admins can post in announcement groups; skip them unless picked explicitly
+			if group.AnnounceOnly && !group.IsAdmin {
+				if req.AllGroups {
+					continue
+				}
+				return nil, errors.New("Only admins can send to group " + group.Name)
+			}
+			seen[jid] = true
+			targets = append(targets, models.BroadcastTarget{
+				Kind:   models.BroadcastTargetGroup,
+				To:     jid,
+				Name:   group.Name,
+				Status: models.BroadcastTargetPending,
+			})
+		}
+	}
+
+	if req.AllSales {
+		cursor, err := database.GetMongoCollection("sales").Find(ctx,
+			bson.M{"is_active": true, "phone": bson.M{"$ne": ""}},
+			options.Find().SetSort(bson.D{{Key: "name", Value: 1}}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		var sales []models.Sales
+		err = cursor.All(ctx, &sales)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range sales {
+			targets = append(targets, models.BroadcastTarget{
+				Kind:   models.BroadcastTargetSales,
+				To:     s.Phone,
+				Name:   s.Name,
+				Status: models.BroadcastTargetPending,
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// optedOut reports whether a phone opted out of automated messages
+func optedOut(phone string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return notification.OptedOut(ctx, phone)
+}
+
+// runBroadcast sends a broadcast's messages one by one, pausing between them.
+// Phones that opted out of automated messages are skipped.
+func runBroadcast(id primitive.ObjectID, message string, targets []models.BroadcastTarget) {
+	collection := database.GetMongoCollection("whatsapp_broadcasts")
+	sender := whatsapp.Groups()
+	interval := config.Cfg.WhatsApp.BroadcastInterval
+	sent, failed := 0, 0
+
+	for i, target := range targets {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+
+		prefix := "targets." + strconv.Itoa(i) + "."
+		if target.Kind != models.BroadcastTargetGroup && optedOut(target.To) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+				prefix + "status": models.BroadcastTargetSkipped,
+				prefix + "error":  "Opted out of automated messages",
+			}})
+			cancel()
+			continue
+		}
+
+		var err error
+		if target.Kind == models.BroadcastTargetGroup {
+			err = sender.SendGroupMessage(target.To, message)
+		} else {
+			err = sender.SendMessage(target.To, message)
+		}
+
+		update := bson.M{}
+		if err != nil {
+			failed++
+			update[prefix+"status"] = models.BroadcastTargetFailed
+			update[prefix+"error"] = err.Error()
+		} else {
+			sent++
+			update[prefix+"status"] = models.BroadcastTargetSent
+			update[prefix+"sent_at"] = time.Now()
+		}
+		update["sent"] = sent
+		update["failed"] = failed
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": update})
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":      models.BroadcastStatusDone,
+		"finished_at": time.Now(),
+	}})
+	log.Printf("[WhatsApp] Broadcast %s finished: %d sent, %d failed", id.Hex(), sent, failed)
+}
+
+// ListBroadcasts returns past broadcasts, newest first
+func (h *WhatsAppHandler) ListBroadcasts(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	collection := database.GetMongoCollection("whatsapp_broadcasts")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, bson.M{})
+
+	cursor, err := collection.Find(ctx, bson.M{}, pq.findOptions().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch broadcasts")
+	}
+	defer cursor.Close(ctx)
+
+	broadcasts := []models.Broadcast{}
+	if err := cursor.All(ctx, &broadcasts); err != nil {
+		return response.Error(c, 500, "Failed to decode broadcasts")
+	}
+	broadcasts, more := trimPage(pq, broadcasts)
+
+	return response.SuccessWithPagination(c, 200, broadcasts, pq.pagination(total, more))
+}
+
+// BroadcastDetail returns a broadcast with the status of every recipient
+func (h *WhatsAppHandler) BroadcastDetail(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	broadcast := &models.Broadcast{}
+	if err := database.GetMongoCollection("whatsapp_broadcasts").FindOne(ctx, bson.M{"_id": objID}).Decode(broadcast); err != nil {
+		return response.NotFound(c, "Broadcast not found")
+	}
+
+	return response.Success(c, 200, broadcast)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxScheduleAhead limits how far in the future a message can be scheduled
+const maxScheduleAhead = 90 * 24 * time.Hour
+
+// ScheduleRequest is the body for scheduling a message; send_at is RFC 3339
+type ScheduleRequest struct {
+	Phone   string    `json:"phone"`
+	Message string    `json:"message"`
+	SendAt  time.Time `json:"send_at"`
+}
+
+// Schedule stores a one-off message that the scheduler sends at send_at
+func (h *WhatsAppHandler) Schedule(c *fiber.Ctx) error {
+	var req ScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidBody, "Invalid request body")
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Phone == "" || req.Message == "" || req.SendAt.IsZero() {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Phone, message and send_at are required")
+	}
+	phone, err := utils.NormalizePhone(req.Phone)
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "Invalid phone number")
+	}
+	now := time.Now()
+	if !req.SendAt.After(now) {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "send_at must be in the future")
+	}
+	if req.SendAt.Sub(now) > maxScheduleAhead {
+		return response.ErrorCode(c, 400, response.CodeValidationFailed, "send_at must be within 90 days")
+	}
+
+	message := models.NewScheduledMessage()
+	message.Phone = phone
+	message.Message = req.Message
+	message.SendAt = req.SendAt
+	message.CreatedBy = middleware.GetUserID(c)
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if _, err := database.GetMongoCollection("scheduled_messages").InsertOne(ctx, message); err != nil {
+		return response.Error(c, 500, "Failed to schedule message")
+	}
+
+	return response.Success(c, 201, message)
+}
+
+// ListScheduled returns scheduled messages by send time, optionally filtered by status and phone
+func (h *WhatsAppHandler) ListScheduled(c *fiber.Ctx) error {
+	pq := parsePage(c, 10, maxPageLimit)
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if phone := c.Query("phone"); phone != "" {
+		if normalized, err := utils.NormalizePhone(phone); err == nil {
+			phone = normalized
+		}
+		filter["phone"] = phone
+	}
+
+	collection := database.GetMongoCollection("scheduled_messages")
+	ctx, cancel := requestContext(c, listTimeout)
+	defer cancel()
+
+	total := pq.count(ctx, collection, filter)
+
+	cursor, err := collection.Find(ctx, filter, pq.findOptions().SetSort(bson.D{{Key: "send_at", Value: 1}}))
+	if err != nil {
+		return response.Error(c, 500, "Failed to fetch scheduled messages")
+	}
+	defer cursor.Close(ctx)
+
+	messages := []models.ScheduledMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return response.Error(c, 500, "Failed to decode scheduled messages")
+	}
+	messages, more := trimPage(pq, messages)
+
+	return response.SuccessWithPagination(c, 200, messages, pq.pagination(total, more))
+}
+
+// CancelScheduled cancels a message that has not been sent yet
+func (h *WhatsAppHandler) CancelScheduled(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeInvalidID, "Invalid ID format")
+	}
+
+	collection := database.GetMongoCollection("scheduled_messages")
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	now := time.Now()
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "status": models.ScheduledStatusScheduled},
+		bson.M{"$set": bson.M{"status": models.ScheduledStatusCancelled, "cancelled_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return response.Error(c, 500, "Failed to cancel message")
+	}
+	if result.MatchedCount == 0 {
+		message := &models.ScheduledMessage{}
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(message); err != nil {
+			return response.NotFound(c, "Scheduled message not found")
+		}
+		return response.ErrorCode(c, 409, response.CodeConflict, "Message is already "+message.Status)
+	}
+
+	return response.SuccessWithMessage(c, 200, "Scheduled message cancelled")
+}
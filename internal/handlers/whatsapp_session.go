@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"bg-go/internal/lib/audit"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/whatsapp"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ExportSession downloads the WhatsApp session database, to move it to another
+// deployment. It is sealed with the session key when one is set, so only a deployment
+// with the same key can import it.
+func (h *WhatsAppHandler) ExportSession(c *fiber.Ctx) error {
+	if whatsapp.WhatsApp == nil {
+		return response.ErrorCode(c, 500, response.CodeWhatsAppNotReady, "WhatsApp not initialized")
+	}
+
+	data, sealed, err := whatsapp.Export()
+	if err != nil {
+		return response.Error(c, 500, "Failed to export session: "+err.Error())
+	}
+
+	audit.Log(c, audit.ActionSessionExport, "whatsapp_session", "", map[string]interface{}{
+		"sealed": sealed,
+		"bytes":  len(data),
+	})
+
+	filename := whatsapp.SessionFile
+	if sealed {
+		filename = whatsapp.SealedSessionFile
+	}
+	stamp := time.Now().Format("20060102-1504")
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+stamp+"-"+filename+`"`)
+	return c.Send(data)
+}
+
+// ImportSession replaces the WhatsApp session with an exported one (form field
+// "session") and reconnects with it
+func (h *WhatsAppHandler) ImportSession(c *fiber.Ctx) error {
+	formFile, err := c.FormFile("session")
+	if err != nil {
+		return response.ErrorCode(c, 400, response.CodeFileRequired, "No file provided")
+	}
+	src, err := formFile.Open()
+	if err != nil {
+		return response.BadRequest(c, "Failed to read file")
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		return response.BadRequest(c, "Failed to read file")
+	}
+
+	if err := whatsapp.Import(data); err != nil {
+		if errors.Is(err, whatsapp.ErrInvalidSession) {
+			return response.ErrorCode(c, 400, response.CodeWhatsAppSessionInvalid, err.Error())
+		}
+		return response.Error(c, 500, "Failed to import session: "+err.Error())
+	}
+
+	audit.Log(c, audit.ActionSessionImport, "whatsapp_session", "", map[string]interface{}{
+		"bytes": len(data),
+	})
+
+	return response.Success(c, 200, fiber.Map{
+		"message": "Session imported, connecting...",
+	})
+}
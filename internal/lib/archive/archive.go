@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/outbox"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds orders moved out of the hot orders collection
+const Collection = "orders_archive"
+
+// Run archives old orders; it is registered as a cron job
+func Run() {
+	moved, err := ArchiveOrders()
+	if err != nil {
+		log.Printf("[Archive] Failed after moving %d order(s): %v", moved, err)
+		return
+	}
+	if moved > 0 {
+		log.Printf("[Archive] Moved %d order(s) to %s", moved, Collection)
+	}
+}
+
+// ArchiveOrders moves completed and cancelled orders older than the configured age
+// to the archive collection in batches. Documents are copied as-is so no field is lost.
+func ArchiveOrders() (int, error) {
+	cfg := config.Cfg.Archive
+	orders := database.GetMongoCollection("orders")
+	archived := database.GetMongoCollection(Collection)
+	if orders == nil || archived == nil {
+		return 0, errors.New("MongoDB not connected")
+	}
+
+	filter := bson.M{
+		"status":     bson.M{"$in": []string{models.OrderStatusCompleted, models.OrderStatusCancelled, models.OrderStatusDeclined}},
+		"updated_at": bson.M{"$lt": time.Now().Add(-cfg.OrderAge)},
+	}
+
+	moved := 0
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+
+		cursor, err := orders.Find(ctx, filter, options.Find().SetLimit(int64(cfg.BatchSize)))
+		if err != nil {
+			cancel()
+			return moved, err
+		}
+		var docs []bson.M
+		if err := cursor.All(ctx, &docs); err != nil {
+			cancel()
+			return moved, err
+		}
+
+		if len(docs) == 0 {
+			cancel()
+			return moved, nil
+		}
+
+		now := time.Now()
+		ids := make([]interface{}, len(docs))
+		batch := make([]interface{}, len(docs))
+		for i, doc := range docs {
+			doc["archived_at"] = now
+			ids[i] = doc["_id"]
+			batch[i] = doc
+		}
+
+		err = database.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := archived.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+			// Orders copied by an earlier interrupted run are already in the archive
+			if err != nil && !onlyDuplicates(err) {
+				return err
+			}
+			_, err = orders.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+			return err
+		})
+		cancel()
+		if err != nil {
+			return moved, err
+		}
+
+		moved += len(docs)
+	}
+}
+
+// onlyDuplicates reports whether every failed insert of a bulk write hit an existing document
+func onlyDuplicates(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// FindOrder looks up an archived order
+func FindOrder(ctx context.Context, filter bson.M) (*models.Order, error) {
+	order := &models.Order{}
+	if err := database.GetMongoCollection(Collection).FindOne(ctx, filter).Decode(order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// EnsureIndexes creates the archive lookup index and the TTL indexes that expire
+// sent notifications and delivered outbox entries
+func EnsureIndexes() {
+	cfg := config.Cfg.Archive
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ensureIndex(ctx, Collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "order_number", Value: 1}},
+		Options: options.Index().SetName("order_number"),
+	})
+
+	if cfg.NotificationTTL > 0 {
+		ensureIndex(ctx, "notifications", mongo.IndexModel{
+			Keys: bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().
+				SetName("ttl_sent").
+				SetExpireAfterSeconds(int32(cfg.NotificationTTL.Seconds())).
+				SetPartialFilterExpression(bson.M{"status": notification.StatusSent}),
+		})
+	}
+
+	if cfg.OutboxTTL > 0 {
+		ensureIndex(ctx, "outbox", mongo.IndexModel{
+			Keys: bson.D{{Key: "processed_at", Value: 1}},
+			Options: options.Index().
+				SetName("ttl_done").
+				SetExpireAfterSeconds(int32(cfg.OutboxTTL.Seconds())).
+				SetPartialFilterExpression(bson.M{"status": outbox.StatusDone}),
+		})
+	}
+}
+
+// ensureIndex creates an index, replacing an existing one with the same name
+// when its options (e.g. the TTL) were changed in the configuration
+func ensureIndex(ctx context.Context, collectionName string, index mongo.IndexModel) {
+	collection := database.GetMongoCollection(collectionName)
+	if collection == nil {
+		return
+	}
+
+	_, err := collection.Indexes().CreateOne(ctx, index)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Code == 86) {
+		name := *index.Options.Name
+		collection.Indexes().DropOne(ctx, name)
+		_, err = collection.Indexes().CreateOne(ctx, index)
+	}
+	if err != nil {
+		log.Printf("[Archive] Failed to create index on %s: %v", collectionName, err)
+	}
+}
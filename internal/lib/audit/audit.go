@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/middleware"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit actions
+const (
+	ActionImpersonate      = "auth.impersonate"
+	ActionSessionRevokeAll = "auth.sessions.revoke_all"
+	ActionUserCreate       = "user.create"
+	ActionUserUpdate       = "user.update"
+	ActionProfileUpdate    = "user.profile_update"
+	ActionPasswordChange   = "user.password_change"
+	ActionUserInvite       = "user.invite"
+	ActionInviteAccept     = "user.invite_accept"
+	ActionUserDelete       = "user.delete"
+	ActionOrderCreate      = "order.create"
+	ActionOrderSplit       = "order.split"
+	ActionOrderMerge       = "order.merge_loading"
+	ActionOrderUpdate      = "order.update"
+	ActionOrderCancel      = "order.cancel"
+	ActionOrderChange      = "order.change_request"
+	ActionCancelReview     = "order.cancel_review"
+	ActionOrderNoteDelete  = "order.note.delete"
+	ActionPaymentVerify    = "payment.verify"
+	ActionPaymentReject    = "payment.reject"
+	ActionPaymentApprove   = "payment.approve"
+	ActionPaymentImport    = "payment.mutation_import"
+	ActionApprovalPolicy   = "approval_policy.update"
+	ActionDeliveryCreate   = "delivery.create"
+	ActionDeliveryAmend    = "delivery.amend"
+	ActionSettingsUpdate   = "settings.update"
+	ActionSettingsRevert   = "settings.revert"
+	ActionReturnResolve    = "return.resolve"
+	ActionCSATFollowUp     = "csat.follow_up"
+	ActionRetentionUpdate  = "retention.update"
+	ActionRetentionRun     = "retention.run"
+	ActionMessageSearch    = "notification.search"
+	ActionMessageExport    = "notification.export"
+	ActionQueueScan        = "queue.scan"
+	ActionQueueCallNext    = "queue.call_next"
+	ActionQueueWalkIn      = "queue.walk_in"
+	ActionDeviceCreate     = "device.create"
+	ActionDeviceUpdate     = "device.update"
+	ActionDeviceRotate     = "device.rotate_token"
+	ActionDeviceDelete     = "device.delete"
+	ActionReportCreate     = "report.create"
+	ActionReportUpdate     = "report.update"
+	ActionReportDelete     = "report.delete"
+	ActionSalesImport      = "sales.import"
+	ActionSalesMerge       = "sales.merge"
+	ActionTargetCreate     = "sales.target.create"
+	ActionTargetUpdate     = "sales.target.update"
+	ActionTargetDelete     = "sales.target.delete"
+	ActionIntegrityRepair  = "migration.integrity_repair"
+	ActionMigrationRun     = "migration.run"
+	ActionTagCreate        = "tag.create"
+	ActionTagUpdate        = "tag.update"
+	ActionTagDelete        = "tag.delete"
+	ActionOrderTags        = "order.tags"
+	ActionBroadcastSend    = "whatsapp.broadcast"
+	ActionSessionExport    = "whatsapp.session.export"
+	ActionSessionImport    = "whatsapp.session.import"
+	ActionReminderUpdate   = "reminder_policy.update"
+	ActionFeePolicyUpdate  = "delivery_fee_policy.update"
+	ActionQueueDurations   = "queue_durations.update"
+	ActionAssetLend        = "asset.lend"
+	ActionAssetReturn      = "asset.return"
+	ActionNumberVoid       = "numbering.void"
+	ActionRecipientsSet    = "delivery.recipients.set"
+	ActionDeliverySend     = "delivery.send"
+	ActionOptOutAdd        = "notification.opt_out"
+	ActionOptOutRemove     = "notification.opt_in"
+	ActionNotifyBroadcast  = "notification.broadcast"
+	ActionNotifyCancel     = "notification.broadcast_cancel"
+	ActionUploadCleanup    = "upload.orphan_cleanup"
+	ActionImageModerate    = "upload.moderation"
+	ActionPriceOverride    = "order.price_override"
+	ActionPriceCheckPolicy = "price_check_policy.update"
+	ActionUnitCreate       = "unit.create"
+	ActionUnitUpdate       = "unit.update"
+	ActionUnitDelete       = "unit.delete"
+	ActionCreditTerms      = "sales.credit_terms"
+	ActionOverduePolicy    = "overdue_policy.update"
+	ActionHookSubscribe    = "rest_hook.subscribe"
+	ActionHookUnsubscribe  = "rest_hook.unsubscribe"
+	ActionAlertPolicy      = "alert_policy.update"
+	ActionSLAPolicy        = "sla_policy.update"
+	ActionLinkCodeRequest  = "invoice_link.code_request"
+	ActionLinkResend       = "invoice_link.resend"
+)
+
+// Log records an audit entry for the current request.
+// Actions are always attributed to the real actor, even while impersonating.
+func Log(c *fiber.Ctx, action string, entityType string, entityID string, details map[string]interface{}) {
+	entry := models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		ActorID:    middleware.GetActorID(c),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Details:    details,
+		IP:         c.IP(),
+		CreatedAt:  time.Now(),
+	}
+
+	if middleware.IsImpersonating(c) {
+		entry.OnBehalfOf = middleware.GetUserID(c)
+	}
+
+	collection := database.GetMongoCollection("audit_logs")
+	if collection == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, entry); err != nil {
+		log.Printf("[Audit] Failed to record %s: %v", action, err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package bankstatement parses the account mutation exports of Indonesian internet
+// banking: BCA (KlikBCA/myBCA, one amount column flagged CR or DB) and Mandiri
+// (Livin'/MCM, separate debit and credit columns).
+package bankstatement
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/lib/spreadsheet"
+)
+
+// Supported banks
+const (
+	BankBCA     = "bca"
+	BankMandiri = "mandiri"
+)
+
+// Banks lists the supported banks
+var Banks = []string{BankBCA, BankMandiri}
+
+// ErrUnknownFormat is returned for a file whose header matches no supported bank
+var ErrUnknownFormat = errors.New("no mutation header found, export the statement as CSV from BCA or Mandiri")
+
+// Mutation is one line of a statement
+type Mutation struct {
+	Row         int       // 1-based line of the file
+	Date        time.Time // Transaction date, midnight in the server's time zone
+	Description string
+	Reference   string
+	Amount      float64 // Always positive; Credit tells the direction
+	Credit      bool
+	Balance     float64
+}
+
+// Key identifies a mutation across imports of overlapping statements
+func (m Mutation) Key(bank string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%.2f|%t|%.2f|%s|%s",
+		bank, m.Date.Format("2006-01-02"), m.Amount, m.Credit, m.Balance, m.Description, m.Reference)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// columns maps header names of both exports to fields
+var columns = map[string]string{
+	"tanggal":           "date",
+	"tanggal transaksi": "date",
+	"tgl":               "date",
+	"date":              "date",
+	"posting date":      "date",
+	"transaction date":  "date",
+	"keterangan":        "description",
+	"description":       "description",
+	"deskripsi":         "description",
+	"remark":            "description",
+	"reference no.":     "reference",
+	"reference no":      "reference",
+	"reference":         "reference",
+	"no. referensi":     "reference",
+	"referensi":         "reference",
+	"jumlah":            "amount", // BCA, followed by a CR/DB column
+	"mutasi":            "amount",
+	"debit":             "debit",
+	"credit":            "credit",
+	"kredit":            "credit",
+	"saldo":             "balance",
+	"balance":           "balance",
+}
+
+// periodPattern finds the statement period of a BCA export, whose dates have no year
+var periodPattern = regexp.MustCompile(`(\d{2})/(\d{2})/(\d{4})\s*-\s*(\d{2})/(\d{2})/(\d{4})`)
+
+// Parse reads the mutations of a CSV statement. bank may be empty to detect it from the
+// header: one amount column is BCA, debit and credit columns are Mandiri. It returns the
+// bank the file was read as.
+func Parse(data []byte, bank string, now time.Time) ([]Mutation, string, error) {
+	rows, err := spreadsheet.ReadCSV(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Exports start with account details; the header is the first row naming a date and
+	// an amount column
+	header := -1
+	fields := map[string]int{}
+	var periodEnd time.Time
+	for i, row := range rows {
+		if m := periodPattern.FindStringSubmatch(strings.Join(row, " ")); m != nil {
+			periodEnd, _ = time.ParseInLocation("02/01/2006", m[4]+"/"+m[5]+"/"+m[6], time.Local)
+		}
+		found := map[string]int{}
+		for j, cell := range row {
+			if field, ok := columns[strings.ToLower(strings.TrimSpace(strings.Trim(cell, "'\"")))]; ok {
+				if _, seen := found[field]; !seen {
+					found[field] = j
+				}
+			}
+		}
+		_, hasDate := found["date"]
+		_, hasAmount := found["amount"]
+		_, hasCredit := found["credit"]
+		if hasDate && (hasAmount || hasCredit) {
+			header, fields = i, found
+			break
+		}
+	}
+	if header < 0 {
+		return nil, "", ErrUnknownFormat
+	}
+	if bank == "" {
+		bank = BankMandiri
+		if _, ok := fields["amount"]; ok {
+			bank = BankBCA
+		}
+	}
+	if periodEnd.IsZero() {
+		periodEnd = now
+	}
+
+	cell := func(row []string, field string) string {
+		i, ok := fields[field]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(strings.Trim(strings.TrimSpace(row[i]), "'"))
+	}
+
+	mutations := []Mutation{}
+	for i, row := range rows[header+1:] {
+		raw := cell(row, "date")
+		if raw == "" || strings.EqualFold(raw, "PEND") {
+			continue // Blank lines, totals and transactions not booked yet
+		}
+		date, ok := parseDate(raw, periodEnd)
+		if !ok {
+			continue // Summary lines such as Saldo Awal
+		}
+
+		m := Mutation{
+			Row:         header + i + 2,
+			Date:        date,
+			Description: strings.Join(strings.Fields(cell(row, "description")), " "),
+			Reference:   cell(row, "reference"),
+		}
+		m.Balance, _ = parseAmount(cell(row, "balance"))
+		if _, ok := fields["amount"]; ok {
+			// BCA: the amount, then CR or DB in the next column (or a suffix)
+			amount := cell(row, "amount")
+			direction := ""
+			if j := fields["amount"] + 1; j < len(row) {
+				direction = strings.ToUpper(strings.TrimSpace(row[j]))
+			}
+			if upper := strings.ToUpper(amount); strings.HasSuffix(upper, "CR") || strings.HasSuffix(upper, "DB") {
+				direction = upper[len(upper)-2:]
+				amount = strings.TrimSpace(amount[:len(amount)-2])
+			}
+			m.Amount, ok = parseAmount(amount)
+			m.Credit = direction == "CR"
+		} else {
+			credit, _ := parseAmount(cell(row, "credit"))
+			debit, _ := parseAmount(cell(row, "debit"))
+			m.Amount, m.Credit, ok = credit, true, credit > 0
+			if credit == 0 {
+				m.Amount, m.Credit, ok = debit, false, debit > 0
+			}
+		}
+		if !ok || m.Amount <= 0 {
+			continue
+		}
+		mutations = append(mutations, m)
+	}
+	return mutations, bank, nil
+}
+
+// dateLayouts are the date formats of the exports
+var dateLayouts = []string{"02/01/2006", "02/01/06", "02-01-2006", "2006-01-02", "02 Jan 2006", "02 Jan 06", "02-Jan-2006", "02-Jan-06"}
+
+// parseDate reads a statement date. BCA leaves out the year (dd/mm); it is the year of
+// the statement period, or the year before for a date after the period's end.
+func parseDate(raw string, periodEnd time.Time) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) > 10 {
+		raw = strings.Fields(raw)[0] // Drop a time of day
+	}
+	for _, layout := range dateLayouts {
+		if date, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return date, true
+		}
+	}
+	date, err := time.ParseInLocation("02/01/2006", raw+"/"+strconv.Itoa(periodEnd.Year()), time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if date.After(periodEnd) {
+		date = date.AddDate(-1, 0, 0)
+	}
+	return date, true
+}
+
+// parseAmount reads an amount written with either separator convention: 1,500,000.00
+// (BCA) or 1.500.000,00 (Indonesian locale)
+func parseAmount(raw string) (float64, bool) {
+	raw = strings.NewReplacer("Rp", "", " ", "", "'", "").Replace(raw)
+	if raw == "" {
+		return 0, false
+	}
+	lastComma, lastDot := strings.LastIndex(raw, ","), strings.LastIndex(raw, ".")
+	switch {
+	case lastComma > lastDot && len(raw)-lastComma-1 != 3:
+		// Decimal comma
+		raw = strings.ReplaceAll(raw, ".", "")
+		raw = strings.Replace(raw, ",", ".", 1)
+	case lastComma < 0 && lastDot >= 0 && (strings.Count(raw, ".") > 1 || len(raw)-lastDot-1 == 3):
+		// Thousands dots without decimals, e.g. 1.500.000
+		raw = strings.ReplaceAll(raw, ".", "")
+	default:
+		raw = strings.ReplaceAll(raw, ",", "")
+	}
+	amount, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, false
+	}
+	return amount, true
+}
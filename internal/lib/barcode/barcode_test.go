@@ -0,0 +1,182 @@
+package barcode
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// runs returns the widths of the alternating bars and spaces of modules, e.g. "211214"
+func runs(modules []bool) string {
+	var b strings.Builder
+	for i := 0; i < len(modules); {
+		j := i
+		for j < len(modules) && modules[j] == modules[i] {
+			j++
+		}
+		b.WriteString(strconv.Itoa(j - i))
+		i = j
+	}
+	return b.String()
+}
+
+// bits renders modules as 1 for a dark module and 0 for a light one
+func bits(modules []bool) string {
+	var b strings.Builder
+	for _, dark := range modules {
+		if dark {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func TestEncodeCode128(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string // Start B, the symbols, the checksum and stop
+	}{
+		// (104 + 33*1 + 17*2) % 103 = 68
+		{"A1", "211214" + "111323" + "123221" + "141221" + "2331112"},
+		// (104 + 0*1) % 103 = 1
+		{" ", "211214" + "212222" + "222122" + "2331112"},
+	}
+	for _, test := range tests {
+		modules, err := encodeCode128(test.content)
+		if err != nil {
+			t.Fatalf("%q: %v", test.content, err)
+		}
+		if got := runs(modules); got != test.want {
+			t.Fatalf("%q: widths %s, want %s", test.content, got, test.want)
+		}
+		if len(modules) != 11*(len(test.content)+3)+2 {
+			t.Fatalf("%q: %d modules", test.content, len(modules))
+		}
+	}
+
+	for _, content := range []string{"", "caf\u00e9", "tab\there", "line\n"} {
+		if _, err := encodeCode128(content); err == nil {
+			t.Fatalf("%q: want an error", content)
+		}
+	}
+}
+
+func TestEAN13CheckDigit(t *testing.T) {
+	for digits, want := range map[string]int{
+		"400638133393": 1,
+		"590123412345": 7,
+		"978030640615": 7,
+		"200000000000": 8,
+		"000000000000": 0,
+	} {
+		if got := EAN13CheckDigit(digits); got != want {
+			t.Fatalf("EAN13CheckDigit(%s) = %d, want %d", digits, got, want)
+		}
+	}
+}
+
+func TestEncodeEAN13(t *testing.T) {
+	// 4006381333931: first digit 4 selects LGLLGG for 006381, then R codes for 333931
+	want := "101" +
+		"0001101" + "0100111" + "0101111" + "0111101" + "0001001" + "0110011" +
+		"01010" +
+		"1000010" + "1000010" + "1000010" + "1110100" + "1000010" + "1100110" +
+		"101"
+	for _, content := range []string{"4006381333931", "400638133393"} {
+		modules, err := encodeEAN13(content)
+		if err != nil {
+			t.Fatalf("%s: %v", content, err)
+		}
+		if got := bits(modules); got != want {
+			t.Fatalf("%s:\n got %s\nwant %s", content, got, want)
+		}
+	}
+
+	for _, content := range []string{"4006381333932", "40063813339", "40063813339311", "40063813339a", ""} {
+		if _, err := encodeEAN13(content); err == nil {
+			t.Fatalf("%q: want an error", content)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for input, want := range map[string]string{
+		"  4006381333931\n": "4006381333931",
+		"400638133393":      "4006381333931", // Check digit dropped by the scanner
+		" A1B2C3D4E5F6A7B8": "a1b2c3d4e5f6a7b8",
+		"40063813339a":      "40063813339a", // Not numeric, so not an EAN-13 without its check digit
+		"   ":               "",
+	} {
+		if got := Normalize(input); got != want {
+			t.Fatalf("Normalize(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestGenerateBytes(t *testing.T) {
+	for _, format := range Formats {
+		if _, err := GenerateBytes(format, NewCode(format)); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+	}
+	if _, err := GenerateBytes("pdf417", "x"); err == nil {
+		t.Fatal("unsupported format: want an error")
+	}
+	if _, err := GenerateBytes(FormatEAN13, "4006381333932"); err == nil {
+		t.Fatal("wrong EAN-13 check digit: want an error")
+	}
+	code := NewCode(FormatEAN13)
+	if len(code) != 13 || code[0] != '2' || int(code[12]-'0') != EAN13CheckDigit(code) {
+		t.Fatalf("NewCode(ean13) = %s, want 13 digits with the 2 prefix and a valid check digit", code)
+	}
+}
+
+// Barcodes are rendered for every driver submission and invoice PDF. Run with:
+//
+//	go test -run '^$' -bench . -benchmem ./internal/lib/barcode/
+
+func BenchmarkGenerate(b *testing.B) {
+	for _, format := range Formats {
+		b.Run(format, func(b *testing.B) {
+			content := NewCode(format)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Generate(format, content); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGenerateQRCodeLink(b *testing.B) {
+	link := "https://example.com/client/status/" + NewCode(FormatCode128) + NewCode(FormatCode128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := GenerateQRCode(link); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQRMatrix(b *testing.B) {
+	content := NewCode(FormatQR)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := QRMatrix(content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewCode(b *testing.B) {
+	for _, format := range Formats {
+		b.Run(format, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewCode(format)
+			}
+		})
+	}
+}
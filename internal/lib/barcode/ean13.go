@@ -0,0 +1,90 @@
+package barcode
+
+import "fmt"
+
+// ean13L are the left-hand odd parity digit patterns; even parity and right-hand
+// patterns are derived from them
+var ean13L = [10]string{
+	"0001101", "0011001", "0010011", "0111101", "0100011",
+	"0110001", "0101111", "0111011", "0110111", "0001011",
+}
+
+// ean13Parity selects odd (L) or even (G) parity for the left half, keyed by the first digit
+var ean13Parity = [10]string{
+	"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG",
+	"LGGLLG", "LGGGLG", "LGLGGL", "LGLGLG", "LGGLGL",
+}
+
+// EAN13CheckDigit computes the check digit of the first 12 digits
+func EAN13CheckDigit(digits string) int {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(digits[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// encodeEAN13 encodes 12 digits (check digit appended) or 13 digits (check digit verified)
+func encodeEAN13(content string) ([]bool, error) {
+	if !isNumeric(content) {
+		return nil, fmt.Errorf("EAN-13 content must be numeric")
+	}
+
+	switch len(content) {
+	case 12:
+		content += fmt.Sprintf("%d", EAN13CheckDigit(content))
+	case 13:
+		if int(content[12]-'0') != EAN13CheckDigit(content) {
+			return nil, fmt.Errorf("invalid EAN-13 check digit")
+		}
+	default:
+		return nil, fmt.Errorf("EAN-13 content must be 12 or 13 digits")
+	}
+
+	pattern := "101"
+	parity := ean13Parity[content[0]-'0']
+	for i := 1; i <= 6; i++ {
+		l := ean13L[content[i]-'0']
+		if parity[i-1] == 'G' {
+			pattern += reverse(invert(l))
+		} else {
+			pattern += l
+		}
+	}
+	pattern += "01010"
+	for i := 7; i <= 12; i++ {
+		pattern += invert(ean13L[content[i]-'0'])
+	}
+	pattern += "101"
+
+	modules := make([]bool, len(pattern))
+	for i := range pattern {
+		modules[i] = pattern[i] == '1'
+	}
+
+	return modules, nil
+}
+
+func invert(pattern string) string {
+	b := []byte(pattern)
+	for i := range b {
+		if b[i] == '0' {
+			b[i] = '1'
+		} else {
+			b[i] = '0'
+		}
+	}
+	return string(b)
+}
+
+func reverse(pattern string) string {
+	b := []byte(pattern)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
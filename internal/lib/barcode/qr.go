@@ -1,4 +1,4 @@
-package qrcode
+package barcode
 
 import (
 	"encoding/base64"
@@ -22,3 +22,12 @@ func GenerateQRCode(content string) (string, error) {
 func GenerateQRCodeBytes(content string) ([]byte, error) {
 	return qrcode.Encode(content, qrcode.Medium, 256)
 }
+
+// QRMatrix returns the QR code modules (true = dark), including the quiet zone
+func QRMatrix(content string) ([][]bool, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate QR code: %v", err)
+	}
+	return code.Bitmap(), nil
+}
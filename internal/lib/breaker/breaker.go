@@ -0,0 +1,250 @@
+package breaker
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"bg-go/internal/config"
+)
+
+// Breaker states
+const (
+	StateClosed   = "closed"    // Calls go through
+	StateOpen     = "open"      // Calls fail fast until the cooldown has passed
+	StateHalfOpen = "half_open" // One probe call is allowed through
+)
+
+// Breaker names
+const (
+	CDN      = "cloudinary"
+	Database = "mongodb"
+)
+
+var (
+	// ErrOpen is returned while a breaker is open
+	ErrOpen = errors.New("service temporarily unavailable")
+	// ErrOverloaded is returned when a breaker has no free call slots
+	ErrOverloaded = errors.New("too many concurrent requests")
+)
+
+// Breaker stops calling a dependency after repeated failures, and limits
+// how many calls can wait on it at the same time
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	slots     chan struct{} // nil when concurrency is unlimited
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+	rejected  int64
+}
+
+// Status is a snapshot of a breaker
+type Status struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	Threshold int        `json:"threshold"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	InFlight  int        `json:"in_flight"`
+	MaxFlight int        `json:"max_in_flight"`
+	Rejected  int64      `json:"rejected"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Breaker{}
+)
+
+// New creates and registers a breaker. maxConcurrent <= 0 means unlimited.
+func New(name string, threshold int, cooldown time.Duration, maxConcurrent int) *Breaker {
+	b := newBreaker(name, threshold, cooldown, maxConcurrent)
+
+	registryMu.Lock()
+	registry[name] = b
+	registryMu.Unlock()
+
+	return b
+}
+
+// Get returns the named breaker, creating it from the configuration on first use
+func Get(name string) *Breaker {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if b, ok := registry[name]; ok {
+		return b
+	}
+
+	cfg := config.Cfg.Breaker
+	maxConcurrent := 0
+	if name == CDN {
+		maxConcurrent = cfg.CDNMaxConcurrent
+	}
+	b := newBreaker(name, cfg.FailureThreshold, cfg.Cooldown, maxConcurrent)
+	registry[name] = b
+	return b
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration, maxConcurrent int) *Breaker {
+	b := &Breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     StateClosed,
+	}
+	if maxConcurrent > 0 {
+		b.slots = make(chan struct{}, maxConcurrent)
+	}
+	return b
+}
+
+// Statuses returns a snapshot of every registered breaker, sorted by name
+func Statuses() []Status {
+	// Known breakers are listed even before their first call
+	Get(CDN)
+	Get(Database)
+
+	registryMu.Lock()
+	breakers := make([]*Breaker, 0, len(registry))
+	for _, b := range registry {
+		breakers = append(breakers, b)
+	}
+	registryMu.Unlock()
+
+	statuses := make([]Status, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// IsUnavailable reports whether err is a fast-fail from a breaker
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrOpen) || errors.Is(err, ErrOverloaded)
+}
+
+// Do runs fn when the breaker allows it and records the outcome
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+			defer func() { <-b.slots }()
+		default:
+			b.reject()
+			return ErrOverloaded
+		}
+	}
+
+	err := fn()
+	b.Record(err)
+	return err
+}
+
+// Allow reports whether a call may be made now. Once the cooldown has passed,
+// a single probe is let through to decide whether the breaker closes again.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.rejected++
+			return ErrOpen
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			b.rejected++
+			return ErrOpen
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+// Record records the outcome of a call
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if err == nil {
+		if b.state != StateClosed {
+			log.Printf("[Breaker] %s closed", b.name)
+		}
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+
+	if b.state == StateHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		if b.state != StateOpen {
+			log.Printf("[Breaker] %s opened after %d failure(s): %v", b.name, b.failures, err)
+		}
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the current state
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Status returns a snapshot of the breaker
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := Status{
+		Name:      b.name,
+		State:     b.state,
+		Failures:  b.failures,
+		Threshold: b.threshold,
+		MaxFlight: cap(b.slots),
+		InFlight:  len(b.slots),
+		Rejected:  b.rejected,
+		LastError: b.lastError,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.cooldown)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// reject counts a call turned away for lack of slots, giving up any probe it held
+func (b *Breaker) reject() {
+	b.mu.Lock()
+	b.rejected++
+	b.probing = false
+	b.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	b := New("test", 2, 50*time.Millisecond, 0)
+	failing := func() error { return errors.New("timeout") }
+
+	b.Do(failing)
+	if b.State() != StateClosed {
+		t.Fatalf("state after one failure = %s", b.State())
+	}
+	b.Do(failing)
+	if err := b.Do(func() error { return nil }); err != ErrOpen || b.State() != StateOpen {
+		t.Fatalf("call while open: %v, state %s", err, b.State())
+	}
+
+	// After the cooldown a single probe goes through; a failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil || b.State() != StateHalfOpen {
+		t.Fatalf("probe: %v, state %s", err, b.State())
+	}
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("second call while probing: %v", err)
+	}
+	b.Record(errors.New("timeout"))
+	if b.State() != StateOpen {
+		t.Fatalf("state after a failed probe = %s", b.State())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Do(func() error { return nil }); err != nil || b.State() != StateClosed {
+		t.Fatalf("successful probe: %v, state %s", err, b.State())
+	}
+	if status := b.Status(); status.Failures != 0 || status.Rejected != 2 || status.OpenedAt != nil {
+		t.Fatalf("status = %+v", status)
+	}
+}
+
+func TestBreakerShedsLoad(t *testing.T) {
+	b := New("test-slots", 5, time.Minute, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.Do(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+	if err := b.Do(func() error { return nil }); err != ErrOverloaded || !IsUnavailable(err) {
+		t.Fatalf("call without a free slot: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	if err := b.Do(func() error { return nil }); err != nil || b.State() != StateClosed {
+		t.Fatalf("call after the slot was freed: %v, state %s", err, b.State())
+	}
+}
@@ -0,0 +1,73 @@
+// Package buildinfo holds the identity of the running build, stamped at link time:
+//
+//	go build -ldflags "-X bg-go/internal/lib/buildinfo.Version=v1.4.0 \
+//		-X bg-go/internal/lib/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X bg-go/internal/lib/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ) \
+//		-X bg-go/internal/lib/buildinfo.Features=driver-otp,broadcasts" ./cmd/server
+package buildinfo
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// Set with -ldflags "-X bg-go/internal/lib/buildinfo.<Name>=<value>"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+	Features  = "" // Comma separated feature flags the build enables
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildTime string   `json:"build_time"`
+	GoVersion string   `json:"go_version"`
+	Features  []string `json:"features"`
+}
+
+// Get returns the running build. Without stamped values, the commit and build time come
+// from the VCS data go build embeds when built inside the git checkout.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		Features:  EnabledFeatures(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = build.GoVersion
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// EnabledFeatures returns the feature flags stamped into the build
+func EnabledFeatures() []string {
+	features := []string{}
+	for _, feature := range strings.Split(Features, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// Enabled reports whether the build enables a feature flag
+func Enabled(feature string) bool {
+	for _, f := range EnabledFeatures() {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
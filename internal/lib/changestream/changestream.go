@@ -0,0 +1,323 @@
+package changestream
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection keeps the position of the order feed, so a restart resumes where it stopped
+const Collection = "change_stream_state"
+
+// Feed modes
+const (
+	ModeOff    = "off"
+	ModeStream = "stream" // MongoDB change stream on a replica set
+	ModePoll   = "poll"   // Polling updated_at on a standalone server
+)
+
+const (
+	// feedID identifies the orders feed in the state collection
+	feedID = "orders"
+	// pollBatch bounds how many orders one poll hands out
+	pollBatch = 500
+	// maxBackoff is the longest wait before a failed change stream is reopened
+	maxBackoff = time.Minute
+)
+
+// Error codes after which a saved resume token can never be used again
+var staleTokenCodes = []int{
+	260, // InvalidResumeToken
+	280, // ChangeStreamFatalError
+	286, // ChangeStreamHistoryLost
+}
+
+// state is the saved position of the feed
+type state struct {
+	ID          string             `bson:"_id"`
+	ResumeToken bson.Raw           `bson:"resume_token,omitempty"`
+	PolledUntil time.Time          `bson:"polled_until,omitempty"`
+	PolledID    primitive.ObjectID `bson:"polled_id,omitempty"` // Last order handed out at PolledUntil
+	UpdatedAt   time.Time          `bson:"updated_at"`
+}
+
+// Status describes the order feed, for health checks
+type Status struct {
+	Mode      string     `json:"mode"`
+	Events    int64      `json:"events"`
+	LastEvent *time.Time `json:"last_event,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+var (
+	mu     sync.Mutex
+	status = Status{Mode: ModeOff}
+)
+
+// Start feeds order changes to event subscribers as events.OrderCreated, OrderUpdated
+// and OrderDeleted. On a replica set the orders collection is watched with a change
+// stream; on a standalone server it is polled every pollInterval instead, which cannot
+// see deletes.
+func Start(pollInterval time.Duration) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		watchable := database.SupportsChangeStreams(ctx)
+		cancel()
+
+		if watchable {
+			setMode(ModeStream)
+			log.Println("[ChangeStream] Watching orders")
+			watchLoop()
+			return
+		}
+		setMode(ModePoll)
+		log.Printf("[ChangeStream] MongoDB cannot be watched, polling orders every %s", pollInterval)
+		pollLoop(pollInterval)
+	}()
+}
+
+// GetStatus returns the current state of the order feed
+func GetStatus() Status {
+	mu.Lock()
+	defer mu.Unlock()
+	return status
+}
+
+func setMode(mode string) {
+	mu.Lock()
+	status.Mode = mode
+	mu.Unlock()
+}
+
+func recordError(err error) {
+	mu.Lock()
+	status.LastError = err.Error()
+	mu.Unlock()
+}
+
+// emit hands an order change to the event subscribers
+func emit(eventType string, order *models.Order, orderID string, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if order != nil {
+		data["order"] = order
+		data["order_number"] = order.OrderNumber
+		data["status"] = order.Status
+	}
+	events.Broadcast(events.Event{
+		Type:       eventType,
+		Resource:   "order",
+		ResourceID: orderID,
+		Data:       data,
+	})
+
+	now := time.Now()
+	mu.Lock()
+	status.Events++
+	status.LastEvent = &now
+	mu.Unlock()
+}
+
+// loadState returns the saved position of the feed, or an empty one
+func loadState(ctx context.Context) *state {
+	st := &state{}
+	if err := database.GetMongoCollection(Collection).FindOne(ctx, bson.M{"_id": feedID}).Decode(st); err != nil {
+		return &state{ID: feedID}
+	}
+	return st
+}
+
+// saveState stores the position of the feed
+func saveState(fields bson.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields["updated_at"] = time.Now()
+	_, err := database.GetMongoCollection(Collection).UpdateOne(ctx, bson.M{"_id": feedID},
+		bson.M{"$set": fields}, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("[ChangeStream] Failed to save feed position: %v", err)
+	}
+}
+
+// watchLoop keeps a change stream open, reopening it with backoff after errors
+func watchLoop() {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := watch(context.Background())
+		if err == nil {
+			err = errors.New("change stream closed")
+		}
+		recordError(err)
+		log.Printf("[ChangeStream] %v, reopening in %s", err, backoff)
+
+		// A stream that ran for a while failed on its own, not on reopening
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// watch streams order changes from the saved resume token until the stream fails
+func watch(ctx context.Context) error {
+	st := loadState(ctx)
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if len(st.ResumeToken) > 0 {
+		opts.SetStartAfter(st.ResumeToken)
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+	}}}}
+
+	stream, err := database.GetMongoCollection("orders").Watch(ctx, pipeline, opts)
+	if err != nil {
+		dropStaleToken(err)
+		return err
+	}
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		var change struct {
+			OperationType string `bson:"operationType"`
+			DocumentKey   struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+			FullDocument      *models.Order `bson:"fullDocument"`
+			UpdateDescription struct {
+				UpdatedFields bson.M   `bson:"updatedFields"`
+				RemovedFields []string `bson:"removedFields"`
+			} `bson:"updateDescription"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			log.Printf("[ChangeStream] Failed to decode change: %v", err)
+		} else {
+			orderID := change.DocumentKey.ID.Hex()
+			switch change.OperationType {
+			case "insert":
+				emit(events.OrderCreated, change.FullDocument, orderID, nil)
+			case "update", "replace":
+				fields := make([]string, 0, len(change.UpdateDescription.UpdatedFields)+len(change.UpdateDescription.RemovedFields))
+				for field := range change.UpdateDescription.UpdatedFields {
+					fields = append(fields, field)
+				}
+				fields = append(fields, change.UpdateDescription.RemovedFields...)
+				emit(events.OrderUpdated, change.FullDocument, orderID, map[string]interface{}{"updated_fields": fields})
+			case "delete":
+				emit(events.OrderDeleted, nil, orderID, nil)
+			}
+		}
+
+		saveState(bson.M{"resume_token": stream.ResumeToken()})
+	}
+
+	err = stream.Err()
+	dropStaleToken(err)
+	return err
+}
+
+// dropStaleToken forgets a resume token the server no longer accepts, so the next
+// stream starts from now instead of failing forever
+func dropStaleToken(err error) {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return
+	}
+	for _, code := range staleTokenCodes {
+		if serverErr.HasErrorCode(code) {
+			log.Printf("[ChangeStream] Resume token no longer valid, changes since the last event are skipped")
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			database.GetMongoCollection(Collection).UpdateOne(ctx, bson.M{"_id": feedID}, bson.M{"$unset": bson.M{"resume_token": ""}})
+			return
+		}
+	}
+}
+
+// pollLoop hands out orders updated since the last poll. Orders are reported as created
+// when they were never updated after being inserted.
+func pollLoop(interval time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	_, err := database.GetMongoCollection("orders").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("updated_at_id"),
+	})
+	if err != nil {
+		log.Printf("[ChangeStream] Failed to create index: %v", err)
+	}
+	st := loadState(ctx)
+	cancel()
+
+	// A first run starts from now rather than replaying every order
+	if st.PolledUntil.IsZero() {
+		st.PolledUntil = time.Now()
+		saveState(bson.M{"polled_until": st.PolledUntil})
+	}
+
+	since, sinceID := st.PolledUntil, st.PolledID
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		next, nextID, err := poll(since, sinceID)
+		if err != nil {
+			recordError(err)
+			log.Printf("[ChangeStream] Failed to poll orders: %v", err)
+			continue
+		}
+		if !next.Equal(since) || nextID != sinceID {
+			since, sinceID = next, nextID
+			saveState(bson.M{"polled_until": since, "polled_id": sinceID})
+		}
+	}
+}
+
+// poll hands out one batch of orders updated after the position (since, sinceID) and
+// returns the position of the last one. Orders are paged on updated_at and _id, so a
+// batch ending among orders updated at the same instant resumes with the rest of them.
+func poll(since time.Time, sinceID primitive.ObjectID) (time.Time, primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("orders").Find(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"updated_at": bson.M{"$gt": since}},
+			bson.M{"updated_at": since, "_id": bson.M{"$gt": sinceID}},
+		}},
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(pollBatch),
+	)
+	if err != nil {
+		return since, sinceID, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return since, sinceID, err
+	}
+
+	for i := range orders {
+		order := &orders[i]
+		eventType := events.OrderUpdated
+		if order.UpdatedAt.Sub(order.CreatedAt) < time.Second {
+			eventType = events.OrderCreated
+		}
+		emit(eventType, order, order.ID.Hex(), nil)
+		since, sinceID = order.UpdatedAt, order.ID
+	}
+	return since, sinceID, nil
+}
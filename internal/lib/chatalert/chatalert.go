@@ -0,0 +1,203 @@
+package chatalert
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the alert policy
+const Collection = "alert_policy"
+
+// stateCollection remembers when each alert type last fired, for the cooldown
+const stateCollection = "alert_state"
+
+// Alert types
+const (
+	TypeWhatsAppDown   = "whatsapp_down"   // The WhatsApp session is disconnected or logged out
+	TypePaymentBacklog = "payment_backlog" // Payments waiting for verification over the threshold
+	TypeQueueEmpty     = "queue_empty"     // Nobody in the queue during operating hours
+	TypeExportFailed   = "export_failed"   // A background export or scheduled report failed
+	TypeSLABreach      = "sla_breach"      // An order is about to breach, or breached, a stage SLA
+)
+
+// Channel kinds
+const (
+	KindSlack   = "slack"
+	KindDiscord = "discord"
+)
+
+// sendTimeout bounds one post to a channel
+const sendTimeout = 10 * time.Second
+
+// DefaultRules are the rules of alert types the saved policy does not mention; every
+// alert starts disabled
+var DefaultRules = []models.AlertRule{
+	{Type: TypeWhatsAppDown, Threshold: 5, CooldownMinutes: 60},
+	{Type: TypePaymentBacklog, Threshold: 20, CooldownMinutes: 120},
+	{Type: TypeQueueEmpty, Threshold: 30, CooldownMinutes: 120},
+	{Type: TypeExportFailed, CooldownMinutes: 15},
+	{Type: TypeSLABreach},
+}
+
+// Field is a labelled value shown under the alert text
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Alert is an operational alert posted to the chat channels
+type Alert struct {
+	Type   string
+	Title  string
+	Text   string
+	Fields []Field
+}
+
+// Sink posts alerts to a chat service
+type Sink interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Factory creates a sink for a channel's webhook
+type Factory func(webhookURL string, client *http.Client) Sink
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		KindSlack:   newSlack,
+		KindDiscord: newDiscord,
+	}
+	client = &http.Client{Timeout: sendTimeout}
+)
+
+// Register adds a channel kind, or replaces one
+func Register(kind string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[kind] = factory
+}
+
+// IsKind reports whether channels of a kind can be configured
+func IsKind(kind string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := factories[kind]
+	return ok
+}
+
+// IsType reports whether an alert type exists
+func IsType(alertType string) bool {
+	for _, rule := range DefaultRules {
+		if rule.Type == alertType {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadPolicy returns the saved alert policy with a rule for every alert type
+func LoadPolicy(ctx context.Context) *models.AlertPolicy {
+	policy := &models.AlertPolicy{}
+	if err := database.GetMongoCollection(Collection).FindOne(ctx, bson.M{}).Decode(policy); err != nil {
+		*policy = models.AlertPolicy{}
+	}
+	if policy.Channels == nil {
+		policy.Channels = []models.AlertChannel{}
+	}
+
+	rules := make([]models.AlertRule, 0, len(DefaultRules))
+	for _, rule := range DefaultRules {
+		if saved, ok := findRule(policy, rule.Type); ok {
+			rule = saved
+		}
+		if rule.Channels == nil {
+			rule.Channels = []string{}
+		}
+		rules = append(rules, rule)
+	}
+	policy.Rules = rules
+	return policy
+}
+
+// findRule returns the rule of an alert type
+func findRule(policy *models.AlertPolicy, alertType string) (models.AlertRule, bool) {
+	for _, rule := range policy.Rules {
+		if rule.Type == alertType {
+			return rule, true
+		}
+	}
+	return models.AlertRule{}, false
+}
+
+// Fire posts an alert to the channels of its rule, unless the rule is disabled or the
+// alert fired within the cooldown. It is safe to call from any instance; the cooldown is
+// claimed in the database.
+func Fire(alert Alert) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fire(ctx, LoadPolicy(ctx), alert)
+}
+
+// fire posts an alert under a loaded policy
+func fire(ctx context.Context, policy *models.AlertPolicy, alert Alert) {
+	rule, _ := findRule(policy, alert.Type)
+	if !rule.Enabled || !claim(ctx, rule) {
+		return
+	}
+
+	for _, channel := range policy.Channels {
+		if len(rule.Channels) > 0 && !contains(rule.Channels, channel.Name) {
+			continue
+		}
+		if err := Send(channel, alert); err != nil {
+			log.Printf("[ChatAlert] Failed to post %s to %s: %v", alert.Type, channel.Name, err)
+		}
+	}
+}
+
+// claim records that an alert type fires now; false means it fired within the cooldown
+func claim(ctx context.Context, rule models.AlertRule) bool {
+	now := time.Now()
+	cutoff := now.Add(-time.Duration(rule.CooldownMinutes) * time.Minute)
+	_, err := database.GetMongoCollection(stateCollection).UpdateOne(ctx,
+		bson.M{"_id": rule.Type, "fired_at": bson.M{"$lte": cutoff}},
+		bson.M{"$set": bson.M{"fired_at": now}},
+		options.Update().SetUpsert(true),
+	)
+	// Within the cooldown the filter misses the stored state and the upsert collides with it
+	return err == nil
+}
+
+// Send posts an alert to one channel
+func Send(channel models.AlertChannel, alert Alert) error {
+	mu.RLock()
+	factory, ok := factories[channel.Kind]
+	mu.RUnlock()
+	if !ok {
+		return errors.New("unknown channel kind: " + channel.Kind)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return factory(channel.WebhookURL, client).Send(ctx, alert)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package chatalert
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/lib/whatsapp"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	stateMu sync.Mutex
+	// downSince and emptySince are when the WhatsApp session went down and the queue
+	// went empty, zero while it is up or has orders
+	downSince  time.Time
+	emptySince time.Time
+)
+
+// Check raises the alerts that watch the state of the system: WhatsApp down, payment
+// backlog and empty queue. It is registered as a cron job and should run every minute
+// or so, as the minute thresholds are measured between runs.
+func Check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	policy := LoadPolicy(ctx)
+	now := time.Now()
+	checkWhatsApp(ctx, policy, now)
+	checkPaymentBacklog(ctx, policy)
+	checkQueueEmpty(ctx, policy, now)
+}
+
+// enabled returns the rule of an alert type when it is enabled
+func enabled(policy *models.AlertPolicy, alertType string) (models.AlertRule, bool) {
+	rule, _ := findRule(policy, alertType)
+	return rule, rule.Enabled
+}
+
+// checkWhatsApp alerts when no WhatsApp provider could send past the threshold
+func checkWhatsApp(ctx context.Context, policy *models.AlertPolicy, now time.Time) {
+	rule, on := enabled(policy, TypeWhatsAppDown)
+	providers := whatsapp.Providers()
+	if !on || len(providers) == 0 {
+		return
+	}
+
+	stateMu.Lock()
+	if active := whatsapp.Active(); active != nil && active.IsConnected() {
+		downSince = time.Time{}
+	} else if downSince.IsZero() {
+		downSince = now
+	}
+	since := downSince
+	stateMu.Unlock()
+
+	if since.IsZero() || now.Sub(since) < time.Duration(rule.Threshold)*time.Minute {
+		return
+	}
+	fire(ctx, policy, Alert{
+		Type:  TypeWhatsAppDown,
+		Title: "WhatsApp session down",
+		Text:  "WhatsApp notifications cannot be sent. Reconnect the session from the WhatsApp settings.",
+		Fields: []Field{
+			{"Down since", since.In(utils.Jakarta()).Format("02/01/2006 15:04")},
+			{"Last error", fmt.Sprint(providers[0].GetStatus()["last_error"])},
+		},
+	})
+}
+
+// checkPaymentBacklog alerts when more payments than the threshold wait for verification
+func checkPaymentBacklog(ctx context.Context, policy *models.AlertPolicy) {
+	rule, on := enabled(policy, TypePaymentBacklog)
+	if !on || rule.Threshold <= 0 {
+		return
+	}
+
+	filter := bson.M{
+		"payment_status": bson.M{"$in": []string{models.PaymentStatusPending, models.PaymentStatusApproval}},
+		"payment_proof":  bson.M{"$ne": nil},
+	}
+	collection := database.GetMongoCollection("orders")
+	waiting, err := collection.CountDocuments(ctx, filter)
+	if err != nil || waiting < int64(rule.Threshold) {
+		return
+	}
+
+	fields := []Field{{"Waiting", fmt.Sprintf("%d payments", waiting)}}
+	oldest := &models.Order{}
+	if err := collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "payment_uploaded_at", Value: 1}})).Decode(oldest); err == nil && oldest.PaymentUploadedAt != nil {
+		fields = append(fields, Field{"Oldest", oldest.OrderNumber + ", uploaded " + oldest.PaymentUploadedAt.In(utils.Jakarta()).Format("02/01/2006 15:04")})
+	}
+	fire(ctx, policy, Alert{
+		Type:   TypePaymentBacklog,
+		Title:  "Payment verification backlog",
+		Text:   fmt.Sprintf("%d payments are waiting for verification (threshold %d).", waiting, rule.Threshold),
+		Fields: fields,
+	})
+}
+
+// checkQueueEmpty alerts when nobody was queued or loading for the threshold during
+// operating hours
+func checkQueueEmpty(ctx context.Context, policy *models.AlertPolicy, now time.Time) {
+	rule, on := enabled(policy, TypeQueueEmpty)
+	if !on || !operating(policy, now) {
+		stateMu.Lock()
+		emptySince = time.Time{}
+		stateMu.Unlock()
+		return
+	}
+
+	queued, err := database.GetMongoCollection("orders").CountDocuments(ctx, bson.M{
+		"status": bson.M{"$in": []string{models.OrderStatusQueued, models.OrderStatusLoading}},
+	})
+	if err != nil {
+		return
+	}
+
+	stateMu.Lock()
+	if queued > 0 {
+		emptySince = time.Time{}
+	} else if emptySince.IsZero() {
+		emptySince = now
+	}
+	since := emptySince
+	stateMu.Unlock()
+
+	if since.IsZero() || now.Sub(since) < time.Duration(rule.Threshold)*time.Minute {
+		return
+	}
+	fire(ctx, policy, Alert{
+		Type:   TypeQueueEmpty,
+		Title:  "Queue empty",
+		Text:   fmt.Sprintf("No trucks have been queued or loading for %d minutes during operating hours.", int(now.Sub(since).Minutes())),
+		Fields: []Field{{"Operating hours", policy.OpenFrom + " - " + policy.OpenUntil}},
+	})
+}
+
+// operating reports whether now is within the operating hours, which are Jakarta time;
+// without operating hours the queue is never expected to be busy
+func operating(policy *models.AlertPolicy, now time.Time) bool {
+	from, errFrom := ParseClock(policy.OpenFrom)
+	until, errUntil := ParseClock(policy.OpenUntil)
+	if errFrom != nil || errUntil != nil {
+		return false
+	}
+	local := now.In(utils.Jakarta())
+	minute := local.Hour()*60 + local.Minute()
+	return minute >= from && minute < until
+}
+
+// ParseClock parses an HH:MM time of day into minutes after midnight
+func ParseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
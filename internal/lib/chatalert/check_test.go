@@ -0,0 +1,28 @@
+package chatalert
+
+import (
+	"testing"
+	"time"
+
+	"bg-go/internal/models"
+)
+
+func TestOperating(t *testing.T) {
+	policy := &models.AlertPolicy{OpenFrom: "08:00", OpenUntil: "17:00"}
+	for at, want := range map[time.Time]bool{
+		time.Date(2026, 3, 2, 0, 30, 0, 0, time.UTC): false, // 07:30 WIB
+		time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC):  true,  // 08:00 WIB
+		time.Date(2026, 3, 2, 9, 59, 0, 0, time.UTC): true,  // 16:59 WIB
+		time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC): false, // 17:00 WIB
+		time.Date(2026, 3, 2, 0, 59, 0, 0, time.UTC): false, // 07:59 WIB
+		time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC): false, // 21:00 WIB
+	} {
+		if got := operating(policy, at); got != want {
+			t.Fatalf("operating at %v = %v, want %v", at, got, want)
+		}
+	}
+
+	if operating(&models.AlertPolicy{}, time.Now()) {
+		t.Fatal("operating without operating hours")
+	}
+}
@@ -0,0 +1,77 @@
+package chatalert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// slack posts to a Slack incoming webhook
+type slack struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func newSlack(webhookURL string, client *http.Client) Sink {
+	return &slack{webhookURL: webhookURL, client: client}
+}
+
+func (s *slack) Send(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, s.client, s.webhookURL, map[string]string{
+		"text": "*" + alert.Title + "*\n" + alert.body(),
+	})
+}
+
+// discord posts to a Discord channel webhook
+type discord struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func newDiscord(webhookURL string, client *http.Client) Sink {
+	return &discord{webhookURL: webhookURL, client: client}
+}
+
+func (d *discord) Send(ctx context.Context, alert Alert) error {
+	// Discord rejects messages over 2000 characters
+	content := "**" + alert.Title + "**\n" + alert.body()
+	if len(content) > 2000 {
+		content = content[:1997] + "..."
+	}
+	return postJSON(ctx, d.client, d.webhookURL, map[string]string{"content": content})
+}
+
+// body is the alert text followed by its fields, one per line
+func (a Alert) body() string {
+	lines := []string{a.Text}
+	for _, field := range a.Fields {
+		lines = append(lines, field.Name+": "+field.Value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// postJSON posts a JSON body to a webhook
+func postJSON(ctx context.Context, client *http.Client, webhookURL string, body interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package clientview
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one view per order, keyed by the order ID
+const Collection = "client_views"
+
+// pruneBatch bounds how many views one prune query checks
+const pruneBatch = 500
+
+// activeStatuses are the statuses queue ETAs are computed over
+var activeStatuses = []string{models.OrderStatusQueued, models.OrderStatusLoading}
+
+// enabled is set once views are kept in sync from the order feed
+var enabled atomic.Bool
+
+// Enabled reports whether views are kept in sync. Without the order feed views would go
+// stale, so they are neither read nor stored.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Start keeps the views in sync from the order feed. Call it before the feed starts.
+func Start() {
+	ensureIndexes()
+	events.Subscribe(events.OrderCreated, onChange)
+	events.Subscribe(events.OrderUpdated, onChange)
+	events.Subscribe(events.OrderDeleted, onDelete)
+	enabled.Store(true)
+
+	go backfill()
+}
+
+// QueueCollection returns the collection queue ETAs for the client pages are computed
+// from: the views when they are kept in sync, the orders otherwise
+func QueueCollection() *mongo.Collection {
+	if Enabled() {
+		return database.GetMongoCollection(Collection)
+	}
+	return database.GetMongoCollection("orders")
+}
+
+// ByToken returns the view of the order with an invoice token. A missing view is built
+// from the order, so links sent before the views existed keep working.
+func ByToken(ctx context.Context, token string) (*models.ClientView, error) {
+	if Enabled() {
+		view := &models.ClientView{}
+		err := database.GetMongoCollection(Collection).FindOne(ctx, bson.M{"invoice_token": token}).Decode(view)
+		if err == nil {
+			return view, nil
+		}
+	}
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"invoice_token": token}).Decode(order); err != nil {
+		return nil, err
+	}
+	view := Project(ctx, order)
+	if Enabled() {
+		save(ctx, view)
+	}
+	return view, nil
+}
+
+// Project builds the view of an order, looking up its sales and product names
+func Project(ctx context.Context, order *models.Order) *models.ClientView {
+	view := &models.ClientView{
+		ID:              order.ID,
+		InvoiceToken:    order.InvoiceToken,
+		OrderNumber:     order.OrderNumber,
+		Status:          order.Status,
+		PaymentStatus:   order.PaymentStatus,
+		Accepted:        order.AcceptedAt != nil,
+		CustomerName:    order.CustomerName,
+		SalesID:         order.SalesID,
+		ProductID:       order.ProductID,
+		Items:           order.Items,
+		Quantity:        order.Quantity,
+		TotalPrice:      order.TotalPrice,
+		QueueNumber:     order.QueueNumber,
+		QueueEnteredAt:  order.QueueEnteredAt,
+		QueueBarcode:    order.QueueBarcode,
+		QueueQRCode:     order.QueueQRCode,
+		BarcodeFormat:   order.BarcodeFormat,
+		BarcodeImage:    order.BarcodeImage,
+		EstimatedTime:   order.EstimatedTime,
+		LoadingBay:      order.LoadingBay,
+		DriverName:      order.DriverName,
+		VehiclePlate:    order.VehiclePlate,
+		DeliveryNoteID:  order.DeliveryNoteID,
+		DeliveryNoteURL: order.DeliveryNoteURL,
+		UpdatedAt:       order.UpdatedAt,
+	}
+	if view.Items == nil {
+		view.Items = []models.OrderItem{}
+	}
+	if order.DeliveryAddress != "" {
+		view.Shipment = &models.ClientShipment{
+			DeliveryAddress: order.DeliveryAddress,
+			DispatchedAt:    order.DispatchedAt,
+			DeliveryETA:     order.DeliveryETA,
+			ArrivedAt:       order.ArrivedAt,
+			DriverLocation:  order.DriverLocation,
+			DeliveryFee:     order.DeliveryFee,
+		}
+	}
+
+	if objID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+		sales := &models.Sales{}
+		if database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": objID}).Decode(sales) == nil {
+			view.SalesName = sales.Name
+		}
+	}
+	if objID, err := primitive.ObjectIDFromHex(order.ProductID); err == nil {
+		product := &models.Product{}
+		if database.GetMongoCollection("products").FindOne(ctx, bson.M{"_id": objID}).Decode(product) == nil {
+			view.ProductName = product.Name
+		}
+	}
+	return view
+}
+
+// save stores a view unless a newer one is already stored. Subscribers run
+// concurrently, so an older change may arrive after a newer one.
+func save(ctx context.Context, view *models.ClientView) {
+	_, err := database.GetMongoCollection(Collection).UpdateOne(ctx,
+		bson.M{"_id": view.ID, "updated_at": bson.M{"$lte": view.UpdatedAt}},
+		bson.M{"$set": view},
+		options.Update().SetUpsert(true),
+	)
+	// The upsert collides with the newer view, which is the one to keep
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("[ClientView] Failed to save view of %s: %v", view.OrderNumber, err)
+	}
+}
+
+// onChange refreshes the view of a created or updated order
+func onChange(event events.Event) {
+	order, ok := event.Data["order"].(*models.Order)
+	if !ok || order == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	save(ctx, Project(ctx, order))
+}
+
+// onDelete removes the view of a deleted or archived order
+func onDelete(event events.Event) {
+	objID, err := primitive.ObjectIDFromHex(event.ResourceID)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	database.GetMongoCollection(Collection).DeleteOne(ctx, bson.M{"_id": objID})
+}
+
+// Prune drops the views whose order was deleted or archived. A change stream reports
+// those as deletes, but a polled feed cannot see them and a stream misses them while it
+// is down, so the views are checked against the orders. It is registered as a cron job.
+func Prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	views := database.GetMongoCollection(Collection)
+	orders := database.GetMongoCollection("orders")
+	idsOnly := bson.M{"_id": 1}
+	pruned := int64(0)
+	after := primitive.NilObjectID
+	for {
+		cursor, err := views.Find(ctx, bson.M{"_id": bson.M{"$gt": after}},
+			options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(pruneBatch).SetProjection(idsOnly))
+		if err != nil {
+			log.Printf("[ClientView] Failed to load views to prune: %v", err)
+			return
+		}
+		var batch []models.ClientView
+		if err := cursor.All(ctx, &batch); err != nil {
+			log.Printf("[ClientView] Failed to load views to prune: %v", err)
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+		ids := make([]primitive.ObjectID, 0, len(batch))
+		for _, view := range batch {
+			ids = append(ids, view.ID)
+		}
+
+		cursor, err = orders.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(idsOnly))
+		if err != nil {
+			log.Printf("[ClientView] Failed to check views against orders: %v", err)
+			return
+		}
+		var existing []models.Order
+		if err := cursor.All(ctx, &existing); err != nil {
+			log.Printf("[ClientView] Failed to check views against orders: %v", err)
+			return
+		}
+		found := make(map[primitive.ObjectID]bool, len(existing))
+		for _, order := range existing {
+			found[order.ID] = true
+		}
+		missing := []primitive.ObjectID{}
+		for _, id := range ids {
+			if !found[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			result, err := views.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": missing}})
+			if err != nil {
+				log.Printf("[ClientView] Failed to prune views: %v", err)
+				return
+			}
+			pruned += result.DeletedCount
+		}
+
+		if len(batch) < pruneBatch {
+			break
+		}
+		after = ids[len(ids)-1]
+	}
+	if pruned > 0 {
+		log.Printf("[ClientView] Pruned %d views of deleted orders", pruned)
+	}
+}
+
+// ensureIndexes creates the token lookup and queue indexes of the views
+func ensureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := database.GetMongoCollection(Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "invoice_token", Value: 1}},
+			Options: options.Index().SetName("invoice_token"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "queue_number", Value: 1}},
+			Options: options.Index().SetName("status_queue_number"),
+		},
+	})
+	if err != nil {
+		log.Printf("[ClientView] Failed to create indexes: %v", err)
+	}
+}
+
+// backfill catches the views up with orders changed while they were not kept in sync,
+// and rebuilds the active queue, which queue ETAs depend on. Queue views with no active
+// order behind them are dropped and rebuilt on their next read.
+func backfill() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	collection := database.GetMongoCollection(Collection)
+	filter := bson.M{"status": bson.M{"$in": activeStatuses}}
+	latest := &models.ClientView{}
+	err := collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: -1}})).Decode(latest)
+	if err == nil {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"updated_at": bson.M{"$gt": latest.UpdatedAt}}}}
+	}
+
+	cursor, err := database.GetMongoCollection("orders").Find(ctx, filter)
+	if err != nil {
+		log.Printf("[ClientView] Failed to load orders to rebuild: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	rebuilt := 0
+	active := []primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		order := &models.Order{}
+		if err := cursor.Decode(order); err != nil {
+			continue
+		}
+		save(ctx, Project(ctx, order))
+		rebuilt++
+		if order.Status == models.OrderStatusQueued || order.Status == models.OrderStatusLoading {
+			active = append(active, order.ID)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("[ClientView] Failed to rebuild views: %v", err)
+		return
+	}
+
+	result, err := collection.DeleteMany(ctx, bson.M{
+		"status": bson.M{"$in": activeStatuses},
+		"_id":    bson.M{"$nin": active},
+	})
+	if err != nil {
+		log.Printf("[ClientView] Failed to drop stale views: %v", err)
+		return
+	}
+	log.Printf("[ClientView] Rebuilt %d views, dropped %d stale queue views", rebuilt, result.DeletedCount)
+}
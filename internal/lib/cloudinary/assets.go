@@ -0,0 +1,136 @@
+package cloudinary
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bg-go/internal/lib/breaker"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// Asset is a file stored on the CDN
+type Asset struct {
+	PublicID     string    `json:"public_id"`
+	URL          string    `json:"url"`
+	ResourceType string    `json:"resource_type"`
+	Format       string    `json:"format"`
+	Bytes        int       `json:"bytes"`
+	Tags         []string  `json:"tags,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// folderFor returns the CDN folder of an upload category; uncategorized uploads
+// go to the base folder
+func (c *CDNClient) folderFor(category string) string {
+	if category == "" {
+		return c.folder
+	}
+	return c.folder + "/" + category
+}
+
+// categoryTags tags an upload with its category so it can be listed by category
+func categoryTags(category string) api.CldAPIArray {
+	if category == "" {
+		return nil
+	}
+	return api.CldAPIArray{category}
+}
+
+// ensureClient initializes the client on first use
+func ensureClient() error {
+	if CDN == nil {
+		return Init()
+	}
+	return nil
+}
+
+// ListByTag returns a page of the image assets with the tag; cursor is empty for the first
+// page and the returned cursor is empty after the last one
+func ListByTag(ctx context.Context, tag string, cursor string, limit int) ([]Asset, string, error) {
+	if err := ensureClient(); err != nil {
+		return nil, "", err
+	}
+
+	var result *admin.AssetsResult
+	err := breaker.Get(breaker.CDN).Do(func() error {
+		var listErr error
+		result, listErr = CDN.client.Admin.AssetsByTag(ctx, admin.AssetsByTagParams{
+			Tag:        tag,
+			NextCursor: cursor,
+			MaxResults: limit,
+			Tags:       api.Bool(true),
+		})
+		return listErr
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if result.Error.Message != "" {
+		return nil, "", fmt.Errorf("failed to list assets: %s", result.Error.Message)
+	}
+	return toAssets(result.Assets), result.NextCursor, nil
+}
+
+// ListFolder returns a page of the assets of one resource type under the base folder,
+// including its category subfolders
+func ListFolder(ctx context.Context, resourceType api.AssetType, cursor string, limit int) ([]Asset, string, error) {
+	if err := ensureClient(); err != nil {
+		return nil, "", err
+	}
+
+	var result *admin.AssetsResult
+	err := breaker.Get(breaker.CDN).Do(func() error {
+		var listErr error
+		result, listErr = CDN.client.Admin.Assets(ctx, admin.AssetsParams{
+			AssetType:    resourceType,
+			DeliveryType: "upload",
+			Prefix:       CDN.folder + "/",
+			NextCursor:   cursor,
+			MaxResults:   limit,
+		})
+		return listErr
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if result.Error.Message != "" {
+		return nil, "", fmt.Errorf("failed to list assets: %s", result.Error.Message)
+	}
+	return toAssets(result.Assets), result.NextCursor, nil
+}
+
+// DestroyAsset deletes a listed asset; unlike Destroy it also removes raw files
+func DestroyAsset(ctx context.Context, asset Asset) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+
+	return breaker.Get(breaker.CDN).Do(func() error {
+		_, err := CDN.client.Upload.Destroy(ctx, uploader.DestroyParams{
+			PublicID:     asset.PublicID,
+			ResourceType: asset.ResourceType,
+		})
+		return err
+	})
+}
+
+// toAssets converts listed CDN assets
+func toAssets(results []api.BriefAssetResult) []Asset {
+	assets := make([]Asset, 0, len(results))
+	for _, r := range results {
+		assets = append(assets, Asset{
+			PublicID:     r.PublicID,
+			URL:          r.SecureURL,
+			ResourceType: r.AssetType,
+			Format:       r.Format,
+			Bytes:        r.Bytes,
+			Tags:         r.Tags,
+			CreatedAt:    r.CreatedAt,
+		})
+	}
+	return assets
+}
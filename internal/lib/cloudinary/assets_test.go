@@ -0,0 +1,43 @@
+package cloudinary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+)
+
+func TestCategoryFolders(t *testing.T) {
+	c := &CDNClient{folder: "lln"}
+	if got := c.folderFor("payment-proofs"); got != "lln/payment-proofs" {
+		t.Fatalf("category folder = %q", got)
+	}
+	if got := c.folderFor(""); got != "lln" {
+		t.Fatalf("uncategorized folder = %q", got)
+	}
+	if tags := categoryTags("avatars"); len(tags) != 1 || tags[0] != "avatars" {
+		t.Fatalf("tags = %v", tags)
+	}
+	if tags := categoryTags(""); tags != nil {
+		t.Fatalf("uncategorized tags = %v", tags)
+	}
+}
+
+func TestToAssets(t *testing.T) {
+	created := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	assets := toAssets([]api.BriefAssetResult{{
+		PublicID:  "lln/avatars/1",
+		SecureURL: "https://res.cloudinary.com/x/image/upload/lln/avatars/1.jpg",
+		AssetType: "image",
+		Format:    "jpg",
+		Bytes:     2048,
+		Tags:      []string{"avatars"},
+		CreatedAt: created,
+	}})
+	if len(assets) != 1 || assets[0].URL == "" || assets[0].ResourceType != "image" || assets[0].Bytes != 2048 || !assets[0].CreatedAt.Equal(created) {
+		t.Fatalf("assets = %+v", assets)
+	}
+	if assets := toAssets(nil); assets == nil || len(assets) != 0 {
+		t.Fatalf("no results = %#v", assets)
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"mime/multipart"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"bg-go/internal/config"
+	"bg-go/internal/lib/breaker"
+	"bg-go/internal/lib/tracing"
 
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // CDNClient holds Cloudinary client
@@ -27,6 +29,7 @@ type UploadResult struct {
 	URL      string `json:"url"`
 	PublicID  string `json:"public_id"`
 	ResourceType string `json:"resource_type"`
+	Moderation   string `json:"moderation,omitempty"` // Moderation status when CDN_MODERATION is on, usually pending
 }
 
 // CDN instance
@@ -53,8 +56,8 @@ func Init() error {
 	return nil
 }
 
-// Upload uploads a file to Cloudinary
-func Upload(file *multipart.FileHeader) (*UploadResult, error) {
+// Upload uploads a file to Cloudinary, into the folder of its category and tagged with it
+func Upload(file *multipart.FileHeader, category string) (*UploadResult, error) {
 	if CDN == nil {
 		if err := Init(); err != nil {
 			return nil, err
@@ -80,14 +83,36 @@ func Upload(file *multipart.FileHeader) (*UploadResult, error) {
 	if ext == ".pdf" || ext == ".doc" || ext == ".docx" {
 		resourceType = "raw"
 	}
+	params := uploader.UploadParams{
+		Folder:       CDN.folderFor(category),
+		ResourceType: resourceType,
+		Tags:         categoryTags(category),
+	}
+	if resourceType != "raw" {
+		// Derive the image variants in the background; until then they are made on request
+		eagerAsync := true
+		params.Eager = eagerVariants()
+		params.EagerAsync = &eagerAsync
+		moderate(&params)
+	}
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), config.Cfg.Breaker.CDNTimeout)
 	defer cancel()
 	
-	result, err := CDN.client.Upload.Upload(ctx, bytes.NewReader(fileBytes), uploader.UploadParams{
-		Folder:       CDN.folder,
-		ResourceType: resourceType,
+	ctx, span := tracing.Start(ctx, "cloudinary.upload",
+		attribute.String("file.name", file.Filename),
+		attribute.Int("file.size", len(fileBytes)),
+	)
+	var result *uploader.UploadResult
+	err = breaker.Get(breaker.CDN).Do(func() error {
+		var uploadErr error
+		result, uploadErr = CDN.client.Upload.Upload(ctx, bytes.NewReader(fileBytes), params)
+		return uploadErr
 	})
+	tracing.End(span, err)
+	if breaker.IsUnavailable(err) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload: %v", err)
 	}
@@ -96,11 +121,12 @@ func Upload(file *multipart.FileHeader) (*UploadResult, error) {
 		URL:          result.SecureURL,
 		PublicID:     result.PublicID,
 		ResourceType: result.ResourceType,
+		Moderation:   moderationStatus(result),
 	}, nil
 }
 
-// UploadBytes uploads file bytes directly
-func UploadBytes(fileBytes []byte, filename string) (*UploadResult, error) {
+// UploadBytes uploads file bytes directly, into the folder of its category
+func UploadBytes(fileBytes []byte, filename string, category string) (*UploadResult, error) {
 	if CDN == nil {
 		if err := Init(); err != nil {
 			return nil, err
@@ -112,14 +138,36 @@ func UploadBytes(fileBytes []byte, filename string) (*UploadResult, error) {
 	if ext == ".pdf" || ext == ".doc" || ext == ".docx" {
 		resourceType = "raw"
 	}
+	params := uploader.UploadParams{
+		Folder:       CDN.folderFor(category),
+		ResourceType: resourceType,
+		Tags:         categoryTags(category),
+	}
+	if resourceType != "raw" {
+		// Derive the image variants in the background; until then they are made on request
+		eagerAsync := true
+		params.Eager = eagerVariants()
+		params.EagerAsync = &eagerAsync
+		moderate(&params)
+	}
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), config.Cfg.Breaker.CDNTimeout)
 	defer cancel()
 	
-	result, err := CDN.client.Upload.Upload(ctx, bytes.NewReader(fileBytes), uploader.UploadParams{
-		Folder:       CDN.folder,
-		ResourceType: resourceType,
+	ctx, span := tracing.Start(ctx, "cloudinary.upload",
+		attribute.String("file.name", filename),
+		attribute.Int("file.size", len(fileBytes)),
+	)
+	var result *uploader.UploadResult
+	err := breaker.Get(breaker.CDN).Do(func() error {
+		var uploadErr error
+		result, uploadErr = CDN.client.Upload.Upload(ctx, bytes.NewReader(fileBytes), params)
+		return uploadErr
 	})
+	tracing.End(span, err)
+	if breaker.IsUnavailable(err) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload: %v", err)
 	}
@@ -128,6 +176,7 @@ func UploadBytes(fileBytes []byte, filename string) (*UploadResult, error) {
 		URL:          result.SecureURL,
 		PublicID:     result.PublicID,
 		ResourceType: result.ResourceType,
+		Moderation:   moderationStatus(result),
 	}, nil
 }
 
@@ -143,12 +192,20 @@ func Destroy(publicID string) error {
 		}
 	}
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), config.Cfg.Breaker.CDNTimeout)
 	defer cancel()
 	
-	_, err := CDN.client.Upload.Destroy(ctx, uploader.DestroyParams{
-		PublicID: publicID,
+	ctx, span := tracing.Start(ctx, "cloudinary.destroy", attribute.String("cloudinary.public_id", publicID))
+	err := breaker.Get(breaker.CDN).Do(func() error {
+		_, destroyErr := CDN.client.Upload.Destroy(ctx, uploader.DestroyParams{
+			PublicID: publicID,
+		})
+		return destroyErr
 	})
+	tracing.End(span, err)
+	if breaker.IsUnavailable(err) {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete: %v", err)
 	}
@@ -0,0 +1,73 @@
+package cloudinary
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"bg-go/internal/config"
+
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// ErrInvalidSignature is returned for a notification that is unsigned, wrongly signed
+// or signed too long ago
+var ErrInvalidSignature = errors.New("invalid notification signature")
+
+// moderate sends an image upload to the configured moderation add-on, which reports its
+// verdict to CDN_NOTIFICATION_URL
+func moderate(params *uploader.UploadParams) {
+	cfg := config.Cfg.CDN
+	if cfg.Moderation == "" {
+		return
+	}
+	params.Moderation = cfg.Moderation
+	params.NotificationURL = cfg.NotificationURL
+}
+
+// moderationStatus returns the moderation status of an upload, empty when it is not moderated
+func moderationStatus(result *uploader.UploadResult) string {
+	if len(result.Moderation) == 0 {
+		return ""
+	}
+	return string(result.Moderation[0].Status)
+}
+
+// VerifyNotification checks the X-Cld-Signature of a notification: the hex SHA-1 (or
+// SHA-256, depending on the account's signature algorithm) of the raw body, the
+// X-Cld-Timestamp and the API secret. Notifications older than CDN_WEBHOOK_MAX_AGE are
+// rejected so a captured one cannot be replayed.
+func VerifyNotification(body []byte, timestamp string, signature string, now time.Time) error {
+	cfg := config.Cfg.CDN
+	if cfg.APISecret == "" || timestamp == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > cfg.WebhookMaxAge || age < -cfg.WebhookMaxAge {
+		return ErrInvalidSignature
+	}
+
+	payload := append(append(append([]byte{}, body...), timestamp...), cfg.APISecret...)
+	var expected string
+	switch len(signature) {
+	case sha1.Size * 2:
+		sum := sha1.Sum(payload)
+		expected = hex.EncodeToString(sum[:])
+	case sha256.Size * 2:
+		sum := sha256.Sum256(payload)
+		expected = hex.EncodeToString(sum[:])
+	default:
+		return ErrInvalidSignature
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
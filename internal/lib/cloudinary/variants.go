@@ -0,0 +1,41 @@
+package cloudinary
+
+import (
+	"sort"
+	"strings"
+)
+
+// Variants are the sizes of an uploaded image besides the original, by name. Each is a
+// Cloudinary transformation that fits the image into a box without upscaling it.
+var Variants = map[string]string{
+	"thumb":  "c_limit,w_200,h_200,q_auto",
+	"medium": "c_limit,w_800,h_800,q_auto",
+}
+
+// eagerVariants lists the variant transformations for the upload's eager parameter, so
+// Cloudinary derives them at upload instead of on their first request
+func eagerVariants() string {
+	transformations := make([]string, 0, len(Variants))
+	for _, transformation := range Variants {
+		transformations = append(transformations, transformation)
+	}
+	sort.Strings(transformations)
+	return strings.Join(transformations, "|")
+}
+
+// VariantURLs returns the URL of every variant of an image delivered from url. URLs
+// that are not Cloudinary image delivery URLs have no variants.
+func VariantURLs(url string) map[string]string {
+	const marker = "/image/upload/"
+	i := strings.Index(url, marker)
+	if i < 0 {
+		return nil
+	}
+
+	base, rest := url[:i+len(marker)], url[i+len(marker):]
+	urls := make(map[string]string, len(Variants))
+	for name, transformation := range Variants {
+		urls[name] = base + transformation + "/" + rest
+	}
+	return urls
+}
@@ -0,0 +1,94 @@
+package cron
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a function run on a fixed interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func()
+}
+
+var (
+	mu      sync.Mutex
+	jobs    []Job
+	stop    chan struct{}
+	running bool
+)
+
+// Register adds a job; jobs registered after Start begin immediately
+func Register(name string, interval time.Duration, run func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	job := Job{Name: name, Interval: interval, Run: run}
+	jobs = append(jobs, job)
+
+	if running {
+		go loop(job, stop)
+	}
+}
+
+// Start runs every registered job on its interval
+func Start() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if running {
+		return
+	}
+	running = true
+	stop = make(chan struct{})
+
+	for _, job := range jobs {
+		go loop(job, stop)
+	}
+
+	log.Printf("[Cron] Started %d job(s)", len(jobs))
+}
+
+// Stop stops all running jobs
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !running {
+		return
+	}
+	close(stop)
+	running = false
+}
+
+func loop(job Job, stop chan struct{}) {
+	if job.Interval <= 0 {
+		log.Printf("[Cron] Job %s has no interval, skipping", job.Name)
+		return
+	}
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			runSafe(job)
+		}
+	}
+}
+
+// runSafe runs a job and keeps the scheduler alive if it panics
+func runSafe(job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Cron] Job %s panicked: %v", job.Name, r)
+		}
+	}()
+
+	job.Run()
+}
@@ -0,0 +1,225 @@
+package csat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one satisfaction survey per completed order
+const Collection = "csat_surveys"
+
+// MinScore and MaxScore bound a rating
+const (
+	MinScore = 1
+	MaxScore = 5
+)
+
+// ErrAnswered is returned when a survey was already rated
+var ErrAnswered = errors.New("survey already answered")
+
+// Survey is the one-question rating link sent after an order completes
+type Survey struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Token       string             `json:"-" bson:"token"`
+	OrderID     string             `json:"order_id" bson:"order_id"`
+	OrderNumber string             `json:"order_number" bson:"order_number"`
+	SalesID     string             `json:"sales_id" bson:"sales_id"`
+	Phone       string             `json:"phone" bson:"phone"`
+	SentAt      time.Time          `json:"sent_at" bson:"sent_at"`
+
+	// Rating (1-5) and optional free-text feedback
+	Score       int        `json:"score,omitempty" bson:"score,omitempty"`
+	Feedback    string     `json:"feedback,omitempty" bson:"feedback,omitempty"`
+	RespondedAt *time.Time `json:"responded_at,omitempty" bson:"responded_at,omitempty"`
+
+	// Follow-up of low scores
+	Low          bool       `json:"low,omitempty" bson:"low,omitempty"`
+	FollowedUpAt *time.Time `json:"followed_up_at,omitempty" bson:"followed_up_at,omitempty"`
+	FollowedUpBy string     `json:"followed_up_by,omitempty" bson:"followed_up_by,omitempty"`
+	FollowUpNote string     `json:"follow_up_note,omitempty" bson:"follow_up_note,omitempty"`
+	SalesName    string     `json:"sales_name,omitempty" bson:"-"` // Populated in lists
+}
+
+// ByToken returns the survey of a rating link
+func ByToken(ctx context.Context, token string) (*Survey, error) {
+	survey := &Survey{}
+	if err := database.GetMongoCollection(Collection).FindOne(ctx, bson.M{"token": token}).Decode(survey); err != nil {
+		return nil, err
+	}
+	return survey, nil
+}
+
+// Respond records the rating of a survey, once. Scores at or below the configured low
+// score are flagged for follow-up and published as csat.low_score.
+func Respond(ctx context.Context, survey *Survey, score int, feedback string) error {
+	now := time.Now()
+	low := score <= config.Cfg.CSAT.LowScore
+	set := bson.M{
+		"score":        score,
+		"feedback":     feedback,
+		"responded_at": now,
+	}
+	if low {
+		set["low"] = true
+	}
+
+	result, err := database.GetMongoCollection(Collection).UpdateOne(ctx,
+		bson.M{"_id": survey.ID, "responded_at": bson.M{"$exists": false}},
+		bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrAnswered
+	}
+	survey.Score, survey.Feedback, survey.RespondedAt, survey.Low = score, feedback, &now, low
+
+	if low {
+		events.Publish(ctx, events.CSATLowScore, "order", survey.OrderID, map[string]interface{}{
+			"order_number": survey.OrderNumber,
+			"sales_id":     survey.SalesID,
+			"score":        score,
+			"feedback":     feedback,
+		})
+	}
+	return nil
+}
+
+// SendDue sends the survey of every order with a customer phone that completed at least
+// the configured delay ago, once; it is registered as a cron job. Orders completed before
+// the window are skipped so enabling surveys does not message every past customer. A
+// survey that could not be sent is tried again on the next run.
+func SendDue() {
+	after := config.Cfg.CSAT.After
+	if after <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	completed := bson.M{"$lte": now.Add(-after)}
+	if window := config.Cfg.CSAT.Window; window > 0 {
+		completed["$gte"] = now.Add(-after - window)
+	}
+
+	orders := database.GetMongoCollection("orders")
+	cursor, err := orders.Find(ctx, bson.M{
+		"status":         models.OrderStatusCompleted,
+		"completed_at":   completed,
+		"csat_sent_at":   bson.M{"$exists": false},
+		"customer_phone": bson.M{"$nin": bson.A{nil, ""}},
+		"shipment_of":    bson.M{"$exists": false}, // The split order gets the survey
+	}, options.Find().SetLimit(200))
+	if err != nil {
+		log.Printf("[CSAT] Failed to load completed orders: %v", err)
+		return
+	}
+	var due []models.Order
+	err = cursor.All(ctx, &due)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[CSAT] Failed to load completed orders: %v", err)
+		return
+	}
+
+	for _, order := range due {
+		// Claim the order first so concurrent runs send the survey once
+		result, err := orders.UpdateOne(ctx,
+			bson.M{"_id": order.ID, "csat_sent_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"csat_sent_at": now}})
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+		if err := send(ctx, &order, now); err != nil {
+			log.Printf("[CSAT] Failed to send the survey of %s: %v", order.OrderNumber, err)
+			orders.UpdateOne(ctx,
+				bson.M{"_id": order.ID, "csat_sent_at": now},
+				bson.M{"$unset": bson.M{"csat_sent_at": ""}})
+		}
+	}
+}
+
+// send creates the survey of an order and sends its link to the customer. The survey is
+// removed again when the link could not be queued, so the order can be retried.
+func send(ctx context.Context, order *models.Order, now time.Time) error {
+	if order.CustomerPhone == "" {
+		return errors.New("the order has no customer phone")
+	}
+	surveyToken, err := token()
+	if err != nil {
+		return err
+	}
+
+	survey := &Survey{
+		ID:          primitive.NewObjectID(),
+		Token:       surveyToken,
+		OrderID:     order.ID.Hex(),
+		OrderNumber: order.OrderNumber,
+		SalesID:     order.SalesID,
+		Phone:       order.CustomerPhone,
+		SentAt:      now,
+	}
+	collection := database.GetMongoCollection(Collection)
+	if _, err := collection.InsertOne(ctx, survey); err != nil {
+		return err
+	}
+	if _, err := notification.SendCSATSurvey(survey.Phone, order.CustomerName, survey.OrderID, survey.OrderNumber, survey.Token); err != nil {
+		collection.DeleteOne(ctx, bson.M{"_id": survey.ID})
+		return err
+	}
+	return nil
+}
+
+// token returns a random rating link token
+func token() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// EnsureIndexes creates the lookups of rating links, orders and reports
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := database.GetMongoCollection(Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetName("token").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "order_id", Value: 1}},
+			Options: options.Index().SetName("order_id").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "responded_at", Value: -1}},
+			Options: options.Index().SetName("responded_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "low", Value: 1}, {Key: "followed_up_at", Value: 1}},
+			Options: options.Index().SetName("low_follow_up").SetSparse(true),
+		},
+	})
+	if err != nil {
+		log.Printf("[CSAT] Failed to create indexes: %v", err)
+	}
+}
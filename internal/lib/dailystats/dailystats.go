@@ -0,0 +1,124 @@
+package dailystats
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/queueday"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one stats snapshot per day
+const Collection = "daily_stats"
+
+// backfillDays is how many past days the job checks for a missing snapshot
+const backfillDays = 7
+
+// Run snapshots every recent day that has ended and has no snapshot yet;
+// it is registered as a cron job, so a missed run is caught up on the next one
+func Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	collection := database.GetMongoCollection(Collection)
+	today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+
+	for i := backfillDays; i >= 1; i-- {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		if n, _ := collection.CountDocuments(ctx, bson.M{"_id": date}); n > 0 {
+			continue
+		}
+		stats, err := Snapshot(ctx, date)
+		if err != nil {
+			log.Printf("[DailyStats] Failed to snapshot %s: %v", date, err)
+			continue
+		}
+		if stats.Orders > 0 {
+			log.Printf("[DailyStats] Snapshotted %s: %d orders, revenue %.0f", date, stats.Orders, stats.Revenue)
+		}
+	}
+}
+
+// Snapshot computes the stats of a day and stores them, replacing an earlier snapshot
+func Snapshot(ctx context.Context, date string) (*models.DailyStats, error) {
+	stats, err := Build(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = database.GetMongoCollection(Collection).ReplaceOne(ctx, bson.M{"_id": date}, stats, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Build computes the stats of a day from the orders (and archived orders) created that
+// day and the queue day of the same date
+func Build(ctx context.Context, date string) (*models.DailyStats, error) {
+	start, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, err
+	}
+	end := start.Add(24 * time.Hour)
+
+	stats := &models.DailyStats{Date: date, SnapshotAt: time.Now()}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"created_at":  bson.M{"$gte": start, "$lt": end},
+			"shipment_of": bson.M{"$exists": false}, // The split order counts as the order
+		}},
+		{"$group": bson.M{
+			"_id":     "$status",
+			"count":   bson.M{"$sum": 1},
+			"revenue": bson.M{"$sum": "$total_price"},
+		}},
+	}
+	for _, name := range []string{"orders", archive.Collection} {
+		cursor, err := database.GetMongoCollection(name).Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		var groups []struct {
+			Status  string  `bson:"_id"`
+			Count   int     `bson:"count"`
+			Revenue float64 `bson:"revenue"`
+		}
+		err = cursor.All(ctx, &groups)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range groups {
+			stats.Orders += group.Count
+			switch group.Status {
+			case models.OrderStatusCompleted:
+				stats.Completed += group.Count
+			case models.OrderStatusCancelled:
+				stats.Cancelled += group.Count
+				continue
+			case models.OrderStatusDeclined:
+				continue
+			}
+			stats.Revenue += group.Revenue
+		}
+	}
+
+	day, err := queueday.Build(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+	stats.QueueEntered = day.Total
+	stats.QueueServed = day.Served
+	stats.AvgWaitMinutes = day.AvgWaitMinutes
+
+	return stats, nil
+}
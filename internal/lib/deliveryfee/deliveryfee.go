@@ -0,0 +1,87 @@
+package deliveryfee
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/geocode"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Collection stores the delivery fee policy
+const Collection = "delivery_fee_policy"
+
+var (
+	// ErrDisabled is returned when the delivery fee policy is off
+	ErrDisabled = errors.New("delivery fee is not enabled")
+	// ErrOutOfArea is returned when an address is beyond the last tier
+	ErrOutOfArea = errors.New("address is outside the delivery area")
+)
+
+// LoadPolicy returns the saved policy, or a disabled empty one
+func LoadPolicy(ctx context.Context) *models.DeliveryFeePolicy {
+	policy := &models.DeliveryFeePolicy{}
+	if err := database.GetMongoCollection(Collection).FindOne(ctx, bson.M{}).Decode(policy); err != nil {
+		*policy = models.DeliveryFeePolicy{RoadFactor: 1}
+	}
+	if policy.Tiers == nil {
+		policy.Tiers = []models.DeliveryFeeTier{}
+	}
+	return policy
+}
+
+// Enabled reports whether fees are computed: the policy is on and an address can be geocoded
+func Enabled(policy *models.DeliveryFeePolicy) bool {
+	return policy.Enabled && geocode.Enabled()
+}
+
+// Fee prices a road distance with the policy's tiers
+func Fee(policy *models.DeliveryFeePolicy, distanceKm float64) (float64, error) {
+	for _, tier := range policy.Tiers {
+		if distanceKm > tier.UpToKm {
+			continue
+		}
+		fee := math.Max(distanceKm*tier.PerKm, policy.MinimumFee)
+		if policy.RoundTo > 0 {
+			fee = math.Ceil(fee/policy.RoundTo) * policy.RoundTo
+		}
+		return fee, nil
+	}
+	return 0, ErrOutOfArea
+}
+
+// Quote geocodes an address and prices its delivery from the depot
+func Quote(ctx context.Context, policy *models.DeliveryFeePolicy, address string) (*models.DeliveryQuote, error) {
+	if !Enabled(policy) {
+		return nil, ErrDisabled
+	}
+
+	place, err := geocode.Geocode(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	roadFactor := policy.RoadFactor
+	if roadFactor <= 0 {
+		roadFactor = 1
+	}
+	distance := geocode.DistanceKm(policy.DepotLat, policy.DepotLng, place.Lat, place.Lng) * roadFactor
+	distance = math.Round(distance*10) / 10
+
+	fee, err := Fee(policy, distance)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DeliveryQuote{
+		Address:    place.Address,
+		Location:   models.GeoPoint{Lat: place.Lat, Lng: place.Lng, RecordedAt: time.Now()},
+		DistanceKm: distance,
+		Fee:        fee,
+	}, nil
+}
@@ -0,0 +1,35 @@
+package deliveryfee
+
+import (
+	"errors"
+	"testing"
+
+	"bg-go/internal/models"
+)
+
+func TestFee(t *testing.T) {
+	policy := &models.DeliveryFeePolicy{
+		Tiers:      []models.DeliveryFeeTier{{UpToKm: 10, PerKm: 5000}, {UpToKm: 30, PerKm: 4000}},
+		MinimumFee: 20000,
+		RoundTo:    1000,
+	}
+	for distance, want := range map[float64]float64{
+		1.3:  20000, // Below the minimum
+		6.7:  34000, // 33500 rounded up
+		10:   50000, // A tier includes its upper bound
+		20.1: 81000, // 80400 at the second tier's rate
+		30:   120000,
+	} {
+		if fee, err := Fee(policy, distance); err != nil || fee != want {
+			t.Fatalf("%v km: fee %v (%v), want %v", distance, fee, err, want)
+		}
+	}
+	if _, err := Fee(policy, 30.1); !errors.Is(err, ErrOutOfArea) {
+		t.Fatalf("past the last tier: %v", err)
+	}
+
+	policy.RoundTo = 0
+	if fee, _ := Fee(policy, 6.7); fee != 33500 {
+		t.Fatalf("unrounded fee = %v", fee)
+	}
+}
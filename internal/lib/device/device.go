@@ -0,0 +1,68 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TokenPrefix marks kiosk tokens so they can be told apart from JWTs
+const TokenPrefix = "kiosk_"
+
+// ErrDeviceInvalid is returned when a device token is unknown or the device is disabled
+var ErrDeviceInvalid = errors.New("device token is invalid or revoked")
+
+// IsToken reports whether token looks like a kiosk token
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, TokenPrefix)
+}
+
+// GenerateToken returns a new kiosk token together with the hash to store.
+// Only the hash is persisted; the token itself is shown once when issued.
+func GenerateToken() (token string, hash string) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	token = TokenPrefix + hex.EncodeToString(b)
+	return token, HashToken(token)
+}
+
+// HashToken hashes a kiosk token for storage and lookup
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenHint returns the last characters of a token for display
+func TokenHint(token string) string {
+	if len(token) <= 4 {
+		return token
+	}
+	return "…" + token[len(token)-4:]
+}
+
+// Authenticate resolves an active device from its token and records the activity
+func Authenticate(token string, ip string) (*models.Device, error) {
+	collection := database.GetMongoCollection("devices")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dev := &models.Device{}
+	err := collection.FindOne(ctx, bson.M{"token_hash": HashToken(token), "active": true}).Decode(dev)
+	if err != nil {
+		return nil, ErrDeviceInvalid
+	}
+
+	now := time.Now()
+	collection.UpdateOne(ctx, bson.M{"_id": dev.ID}, bson.M{"$set": bson.M{"last_seen_at": now, "last_ip": ip}})
+
+	return dev, nil
+}
@@ -0,0 +1,266 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// magic starts every sealed file
+var magic = []byte("BGSEALED")
+
+// version of the sealed format
+const version = 1
+
+// kdfSalt is the fixed scrypt salt of passphrase keys; keys differ per deployment
+const kdfSalt = "bg-go/envelope/v1"
+
+var (
+	ErrNotSealed  = errors.New("the data is not sealed")
+	ErrUnknownKey = errors.New("the data was sealed with a key that is not configured")
+)
+
+// Key is a 256-bit key encryption key. ID identifies it in sealed files without
+// revealing it.
+type Key struct {
+	ID  string
+	key []byte
+}
+
+// ParseKey reads a key given as base64 for 32 random bytes (openssl rand -base64 32) or
+// derives one from a passphrase
+func ParseKey(secret string) (Key, error) {
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return Key{}, errors.New("empty key")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(key) != 32 {
+		key, err = scrypt.Key([]byte(secret), []byte(kdfSalt), 1<<15, 8, 1, 32)
+		if err != nil {
+			return Key{}, err
+		}
+	}
+	sum := sha256.Sum256(append([]byte("key-id:"), key...))
+	return Key{ID: hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+// Keyring seals with its current key and opens data sealed with the current or any old
+// key, so keys can be rotated without losing sealed data
+type Keyring struct {
+	current Key
+	old     []Key
+}
+
+// NewKeyring returns a keyring of the current key and the previous ones
+func NewKeyring(current string, old []string) (*Keyring, error) {
+	key, err := ParseKey(current)
+	if err != nil {
+		return nil, fmt.Errorf("current key: %v", err)
+	}
+	ring := &Keyring{current: key}
+	for i, secret := range old {
+		if strings.TrimSpace(secret) == "" {
+			continue
+		}
+		key, err := ParseKey(secret)
+		if err != nil {
+			return nil, fmt.Errorf("old key %d: %v", i+1, err)
+		}
+		ring.old = append(ring.old, key)
+	}
+	return ring, nil
+}
+
+// CurrentID returns the ID of the key new data is sealed with
+func (k *Keyring) CurrentID() string {
+	return k.current.ID
+}
+
+// header is stored in front of the ciphertext. The data is encrypted with a random data
+// key, which is stored encrypted with the key encryption key; rotating only re-encrypts
+// the data key.
+type header struct {
+	KeyID      string    `json:"key_id"`
+	WrappedKey []byte    `json:"wrapped_key"` // Nonce followed by the sealed data key
+	Nonce      []byte    `json:"nonce"`
+	SealedAt   time.Time `json:"sealed_at"`
+}
+
+// IsSealed reports whether data starts like sealed data
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// KeyID returns the ID of the key sealed data was sealed with
+func KeyID(data []byte) (string, error) {
+	h, _, err := parse(data)
+	if err != nil {
+		return "", err
+	}
+	return h.KeyID, nil
+}
+
+// Seal encrypts plaintext with a new data key wrapped by the current key
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.current.key, dataKey, []byte(k.current.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	h := header{KeyID: k.current.ID, WrappedKey: wrapped, Nonce: nonce, SealedAt: time.Now().UTC()}
+	return encode(h, aead.Seal(nil, nonce, plaintext, magic))
+}
+
+// Open decrypts sealed data. current is false when it was sealed with an old key, so the
+// caller can seal it again with the current one.
+func (k *Keyring) Open(data []byte) (plaintext []byte, current bool, err error) {
+	h, ciphertext, err := parse(data)
+	if err != nil {
+		return nil, false, err
+	}
+	dataKey, current, err := k.unwrap(h)
+	if err != nil {
+		return nil, false, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, false, err
+	}
+	plaintext, err = aead.Open(nil, h.Nonce, ciphertext, magic)
+	if err != nil {
+		return nil, false, errors.New("the sealed data is corrupt")
+	}
+	return plaintext, current, nil
+}
+
+// Rewrap wraps the data key of sealed data with the current key, leaving the ciphertext
+// as it is. changed is false when the data already uses the current key.
+func (k *Keyring) Rewrap(data []byte) (rewrapped []byte, changed bool, err error) {
+	h, ciphertext, err := parse(data)
+	if err != nil {
+		return nil, false, err
+	}
+	dataKey, current, err := k.unwrap(h)
+	if err != nil {
+		return nil, false, err
+	}
+	if current {
+		return data, false, nil
+	}
+	if h.WrappedKey, err = seal(k.current.key, dataKey, []byte(k.current.ID)); err != nil {
+		return nil, false, err
+	}
+	h.KeyID = k.current.ID
+	rewrapped, err = encode(h, ciphertext)
+	return rewrapped, true, err
+}
+
+// unwrap decrypts the data key of a header with the key it names
+func (k *Keyring) unwrap(h header) ([]byte, bool, error) {
+	keys := append([]Key{k.current}, k.old...)
+	for i, key := range keys {
+		if key.ID != h.KeyID {
+			continue
+		}
+		dataKey, err := open(key.key, h.WrappedKey, []byte(key.ID))
+		if err != nil {
+			return nil, false, errors.New("the data key does not open with the configured key")
+		}
+		return dataKey, i == 0, nil
+	}
+	return nil, false, fmt.Errorf("%w (key %s)", ErrUnknownKey, h.KeyID)
+}
+
+// encode lays out magic, version, header length, header and ciphertext
+func encode(h header, ciphertext []byte) ([]byte, error) {
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(magic)+5+len(raw)+len(ciphertext))
+	out = append(out, magic...)
+	out = append(out, version)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(raw)))
+	out = append(out, raw...)
+	return append(out, ciphertext...), nil
+}
+
+// parse splits sealed data into its header and ciphertext
+func parse(data []byte) (header, []byte, error) {
+	var h header
+	if !IsSealed(data) || len(data) < len(magic)+5 {
+		return h, nil, ErrNotSealed
+	}
+	rest := data[len(magic):]
+	if rest[0] != version {
+		return h, nil, fmt.Errorf("unsupported sealed format version %d", rest[0])
+	}
+	size := binary.BigEndian.Uint32(rest[1:5])
+	rest = rest[5:]
+	if uint64(size) > uint64(len(rest)) {
+		return h, nil, errors.New("the sealed data is truncated")
+	}
+	if err := json.Unmarshal(rest[:size], &h); err != nil {
+		return h, nil, fmt.Errorf("invalid sealed header: %v", err)
+	}
+	return h, rest[size:], nil
+}
+
+// seal encrypts a small value with AES-GCM, prefixing the nonce
+func seal(key []byte, plaintext []byte, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts a value sealed by seal
+func open(key []byte, sealed []byte, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
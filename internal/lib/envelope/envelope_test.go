@@ -0,0 +1,115 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Base64 keys skip the passphrase derivation
+const (
+	keyA = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	keyB = "HyAhIiMkJSYnKCkqKywtLi8wMTIzNDU2Nzg5Ojs8PT4="
+)
+
+func mustKeyring(t *testing.T, current string, old ...string) *Keyring {
+	t.Helper()
+	ring, err := NewKeyring(current, old)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	return ring
+}
+
+func TestSealOpen(t *testing.T) {
+	ring := mustKeyring(t, keyA)
+	plaintext := []byte("SQLite format 3\x00session")
+
+	sealed, err := ring.Seal(plaintext)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if !IsSealed(sealed) || bytes.Contains(sealed, plaintext) {
+		t.Fatalf("sealed data is not sealed or holds the plaintext")
+	}
+	if id, err := KeyID(sealed); err != nil || id != ring.CurrentID() {
+		t.Fatalf("key id = %q, %v, want %q", id, err, ring.CurrentID())
+	}
+
+	opened, current, err := ring.Open(sealed)
+	if err != nil || !current || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("open = %q, current=%v, %v", opened, current, err)
+	}
+
+	// Each seal uses a new data key and nonce
+	again, _ := ring.Seal(plaintext)
+	if bytes.Equal(again, sealed) {
+		t.Fatalf("sealing twice gave the same output")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	ring := mustKeyring(t, keyA)
+	sealed, err := ring.Seal([]byte("session"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+
+	if _, _, err := ring.Open([]byte("SQLite format 3")); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("plaintext: err = %v, want ErrNotSealed", err)
+	}
+	if _, _, err := mustKeyring(t, keyB).Open(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("other key: err = %v, want ErrUnknownKey", err)
+	}
+	if _, _, err := ring.Open(sealed[:len(magic)+8]); err == nil {
+		t.Fatalf("truncated data opened")
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, _, err := ring.Open(tampered); err == nil {
+		t.Fatalf("tampered data opened")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	sealed, err := mustKeyring(t, keyA).Seal([]byte("session"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+
+	// After rotating, data sealed with the old key still opens, flagged for resealing
+	rotated := mustKeyring(t, keyB, keyA)
+	opened, current, err := rotated.Open(sealed)
+	if err != nil || current || string(opened) != "session" {
+		t.Fatalf("open with the old key = %q, current=%v, %v", opened, current, err)
+	}
+
+	rewrapped, changed, err := rotated.Rewrap(sealed)
+	if err != nil || !changed {
+		t.Fatalf("rewrap: changed=%v, %v", changed, err)
+	}
+	if id, _ := KeyID(rewrapped); id != rotated.CurrentID() {
+		t.Fatalf("rewrapped key id = %q, want %q", id, rotated.CurrentID())
+	}
+	if _, _, err := mustKeyring(t, keyB).Open(rewrapped); err != nil {
+		t.Fatalf("rewrapped data does not open without the old key: %v", err)
+	}
+	if _, changed, _ := rotated.Rewrap(rewrapped); changed {
+		t.Fatalf("rewrapping current data changed it")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("  "); err == nil {
+		t.Fatalf("empty key accepted")
+	}
+	a, _ := ParseKey("a passphrase")
+	b, _ := ParseKey("a passphrase")
+	if a.ID == "" || a.ID != b.ID {
+		t.Fatalf("passphrase ids %q and %q differ", a.ID, b.ID)
+	}
+	if _, err := NewKeyring(keyA, []string{"", keyB}); err != nil {
+		t.Fatalf("blank old keys are skipped: %v", err)
+	}
+}
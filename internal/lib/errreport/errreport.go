@@ -0,0 +1,188 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/buildinfo"
+)
+
+// sendTimeout bounds one report to a sink
+const sendTimeout = 5 * time.Second
+
+var client = &http.Client{Timeout: sendTimeout}
+
+// Levels of a report
+const (
+	LevelError = "error"
+	LevelFatal = "fatal" // Panics
+)
+
+// Event is an error report in the Sentry event format, so the same payload goes to a
+// Sentry DSN and to the generic webhook sink
+type Event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Transaction string            `json:"transaction,omitempty"` // Matched route, e.g. POST /api/v1/orders/:id/split
+	Exception   *Exceptions       `json:"exception,omitempty"`
+	Request     *Request          `json:"request,omitempty"`
+	User        *User             `json:"user,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// Exceptions wraps the exception of an event as Sentry expects
+type Exceptions struct {
+	Values []Exception `json:"values"`
+}
+
+// Exception is a panic or error. Its goroutine stack goes in the event's extra "stack",
+// raw stacks do not fit Sentry's frames.
+type Exception struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Request is the request that failed, already redacted by the caller
+type Request struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Data        string            `json:"data,omitempty"`
+}
+
+// User identifies the user of the failed request
+type User struct {
+	ID        string `json:"id,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	Role      string `json:"role,omitempty"`
+}
+
+// Enabled reports whether any sink is configured
+func Enabled() bool {
+	cfg := config.Cfg.ErrorReport
+	return cfg.DSN != "" || cfg.WebhookURL != ""
+}
+
+// Capture fills in the event's ID, time, release and environment and sends it to every
+// configured sink in the background. Failures are only logged; reporting must never
+// fail the request.
+func Capture(event Event) {
+	if !Enabled() {
+		return
+	}
+
+	cfg := config.Cfg
+	event.EventID = eventID()
+	event.Timestamp = time.Now().UTC()
+	event.Platform = "go"
+	event.Logger = "bg-go"
+	event.ServerName = cfg.App.Name
+	event.Release = cfg.ErrorReport.Release
+	if event.Release == "" {
+		event.Release = buildinfo.Get().Commit
+	}
+	event.Environment = cfg.App.Env
+
+	go send(event)
+}
+
+// send posts an event to the configured DSN and webhook
+func send(event Event) {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[ErrorReport] Failed to encode event: %v", err)
+		return
+	}
+
+	cfg := config.Cfg.ErrorReport
+	if cfg.DSN != "" {
+		endpoint, auth, err := parseDSN(cfg.DSN)
+		if err != nil {
+			log.Printf("[ErrorReport] Invalid DSN: %v", err)
+		} else if err := post(endpoint, raw, map[string]string{"X-Sentry-Auth": auth}); err != nil {
+			log.Printf("[ErrorReport] Failed to send event %s to Sentry: %v", event.EventID, err)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		if err := post(cfg.WebhookURL, raw, nil); err != nil {
+			log.Printf("[ErrorReport] Failed to send event %s to the webhook: %v", event.EventID, err)
+		}
+	}
+}
+
+// post sends a JSON payload
+func post(target string, body []byte, headers map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// parseDSN returns the store endpoint and auth header of a DSN of the form
+// https://<key>@<host>/<project>
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	key := u.User.Username()
+	project := strings.Trim(u.Path, "/")
+	if key == "" || project == "" || u.Host == "" {
+		return "", "", fmt.Errorf("want https://<key>@<host>/<project>")
+	}
+
+	// Self-hosted installs may serve Sentry under a path prefix
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project)
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=bg-go/1.0, sentry_key=%s", key)
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return endpoint, auth, nil
+}
+
+// eventID returns a random 32 character hex ID, the format Sentry requires
+func eventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,93 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"bg-go/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Collection keeps every published event
+const Collection = "events"
+
+// Event types
+const (
+	InvoiceUnviewed = "invoice.unviewed" // An invoice link stayed unopened past the configured delay
+	OrderCreated    = "order.created"    // An order was inserted, seen on the order change feed
+	OrderUpdated    = "order.updated"    // An order was updated or replaced, seen on the order change feed
+	OrderDeleted    = "order.deleted"    // An order was deleted, only seen on a change stream
+	OrderOverdue    = "order.overdue"    // An order went unpaid past its due date
+	CSATLowScore    = "csat.low_score"   // A customer rated an order at or below the low score
+	SLAAtRisk       = "sla.at_risk"      // An order is about to breach the SLA of its stage
+	SLABreached     = "sla.breached"     // An order stayed in its stage past the SLA
+)
+
+// All subscribes a handler to every event type
+const All = "*"
+
+// Event is something that happened in the system that other parts may react to
+type Event struct {
+	ID         primitive.ObjectID     `json:"id" bson:"_id"`
+	Type       string                 `json:"type" bson:"type"`
+	Resource   string                 `json:"resource" bson:"resource"`
+	ResourceID string                 `json:"resource_id" bson:"resource_id"`
+	Data       map[string]interface{} `json:"data,omitempty" bson:"data,omitempty"`
+	CreatedAt  time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// Handler reacts to an event; it runs in its own goroutine
+type Handler func(Event)
+
+var (
+	mu       sync.RWMutex
+	handlers = map[string][]Handler{}
+)
+
+// Subscribe registers a handler for an event type, or for every type with All
+func Subscribe(eventType string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[eventType] = append(handlers[eventType], handler)
+}
+
+// Publish saves an event and hands it to its subscribers
+func Publish(ctx context.Context, eventType, resource, resourceID string, data map[string]interface{}) (Event, error) {
+	event := Event{
+		ID:         primitive.NewObjectID(),
+		Type:       eventType,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Data:       data,
+		CreatedAt:  time.Now(),
+	}
+
+	if _, err := database.GetMongoCollection(Collection).InsertOne(ctx, event); err != nil {
+		return event, err
+	}
+	log.Printf("[Events] %s %s/%s", eventType, resource, resourceID)
+
+	Broadcast(event)
+	return event, nil
+}
+
+// Broadcast hands an event to its subscribers without saving it. It is meant for
+// high-volume feeds such as order changes, which already live in their own collection.
+func Broadcast(event Event) {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	mu.RLock()
+	subscribers := append(append([]Handler{}, handlers[event.Type]...), handlers[All]...)
+	mu.RUnlock()
+	for _, handler := range subscribers {
+		go handler(event)
+	}
+}
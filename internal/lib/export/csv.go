@@ -0,0 +1,87 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"time"
+
+	"bg-go/internal/middleware"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// flushEvery is how many rows are buffered before a chunk is sent to the client
+const flushEvery = 200
+
+// MaxDuration bounds how long a single streamed export may run
+const MaxDuration = 30 * time.Minute
+
+// RowFunc converts the current cursor document into a CSV record
+type RowFunc func(cursor *mongo.Cursor) ([]string, error)
+
+// Context returns the context to open an export cursor with. It outlives the handler,
+// because the body is written after the handler returns; StreamCSV cancels it when done.
+func Context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), MaxDuration)
+}
+
+// StreamCSV streams the documents of cursor to the client as CSV using chunked transfer
+// encoding, so memory use stays flat regardless of the export size. The cursor is closed
+// and ctx cancelled, and the concurrency slot of the request released, when the export
+// finishes or the client disconnects.
+func StreamCSV(ctx context.Context, cancel context.CancelFunc, c *fiber.Ctx, fileName string, header []string, cursor *mongo.Cursor, row RowFunc) error {
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, fileName))
+
+	release := middleware.HoldConcurrencySlot(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer release()
+		defer cancel()
+		defer cursor.Close(context.Background())
+
+		// BOM so spreadsheet apps detect UTF-8
+		w.WriteString("\ufeff")
+		writer := csv.NewWriter(w)
+		writer.Write(header)
+
+		rows := 0
+		for cursor.Next(ctx) {
+			record, err := row(cursor)
+			if err != nil {
+				log.Printf("[Export] Skipping row of %s: %v", fileName, err)
+				continue
+			}
+			writer.Write(record)
+
+			rows++
+			if rows%flushEvery == 0 {
+				writer.Flush()
+				// A failed flush means the client went away; stop reading from Mongo
+				if err := w.Flush(); err != nil {
+					log.Printf("[Export] %s cancelled after %d rows: %v", fileName, rows, err)
+					return
+				}
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			log.Printf("[Export] %s stopped after %d rows: %v", fileName, rows, err)
+		}
+
+		writer.Flush()
+		w.Flush()
+	})
+
+	return nil
+}
+
+// FormatTime formats an optional timestamp in the given location, empty when unset
+func FormatTime(t *time.Time, loc *time.Location) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.In(loc).Format("2006-01-02 15:04:05")
+}
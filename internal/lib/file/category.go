@@ -0,0 +1,35 @@
+package file
+
+// Upload categories; each has its own CDN folder and tag
+const (
+	CategoryPaymentProof = "payment-proofs"
+	CategoryVehiclePhoto = "vehicle-photos"
+	CategoryLoadingPhoto = "loading-photos"
+	CategorySignature    = "signatures"
+	CategoryProductImage = "product-images"
+	CategoryReturnPhoto  = "return-photos"
+	CategoryAttachment   = "attachments" // Order note attachments
+	CategoryAvatar       = "avatars"     // User profile pictures
+)
+
+// Categories lists every upload category
+var Categories = []string{
+	CategoryPaymentProof,
+	CategoryVehiclePhoto,
+	CategoryLoadingPhoto,
+	CategorySignature,
+	CategoryProductImage,
+	CategoryReturnPhoto,
+	CategoryAttachment,
+	CategoryAvatar,
+}
+
+// IsCategory reports whether name is an upload category
+func IsCategory(name string) bool {
+	for _, category := range Categories {
+		if category == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,308 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/models"
+)
+
+// jpegQuality is used when a photo has to be re-encoded to fix its orientation
+const jpegQuality = 90
+
+// maxReorientPixels bounds the photos decoded to fix their orientation, as a decoded
+// photo takes 4 bytes per pixel
+const maxReorientPixels = 50_000_000
+
+// ErrImageTooLarge is returned for a photo with more pixels than can be turned upright
+var ErrImageTooLarge = errors.New("image has too many pixels")
+
+// Photo is what is kept of a photo's EXIF data once it is stripped from the file
+type Photo struct {
+	CapturedAt *time.Time
+	Location   *models.GeoPoint // Only kept when Upload.KeepPhotoGPS is set
+}
+
+// Image returns the stored image of an upload with its variants and the EXIF data kept from it
+func (r *UploadResult) Image() *models.Image {
+	image := &models.Image{PublicID: r.PublicID, URL: r.URL, Variants: r.Variants, Moderation: r.Moderation}
+	if r.Photo != nil {
+		image.CapturedAt = r.Photo.CapturedAt
+		image.Location = r.Photo.Location
+	}
+	return image
+}
+
+// exifData is the part of the EXIF data that is read
+type exifData struct {
+	orientation int
+	capturedAt  *time.Time
+	lat, lng    float64
+	hasLocation bool
+}
+
+// Sanitize removes the EXIF, XMP and IPTC metadata of a JPEG and turns it upright. Only
+// photos whose EXIF orientation is not upright are re-encoded; the others keep their
+// pixels. Other files, and JPEGs that cannot be parsed, are returned unchanged. A photo
+// to turn with more than maxReorientPixels is rejected with ErrImageTooLarge.
+func Sanitize(content []byte, filename string) ([]byte, *Photo, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext != ".jpg" && ext != ".jpeg" {
+		return content, nil, nil
+	}
+
+	stripped, exif, ok := stripJPEGMetadata(content)
+	if !ok {
+		return content, nil, nil
+	}
+
+	if exif.orientation > 1 && exif.orientation <= 8 {
+		upright, err := reorient(stripped, exif.orientation)
+		if errors.Is(err, ErrImageTooLarge) {
+			return nil, nil, err
+		}
+		if err == nil {
+			stripped = upright
+		}
+	}
+
+	photo := &Photo{CapturedAt: exif.capturedAt}
+	if exif.hasLocation && config.Cfg.Upload.KeepPhotoGPS {
+		recordedAt := time.Now()
+		if exif.capturedAt != nil {
+			recordedAt = *exif.capturedAt
+		}
+		photo.Location = &models.GeoPoint{Lat: exif.lat, Lng: exif.lng, RecordedAt: recordedAt}
+	}
+	return stripped, photo, nil
+}
+
+// stripJPEGMetadata copies a JPEG without its APP1 (EXIF, XMP) and APP13 (IPTC) segments
+// and returns the EXIF data it found. The ICC profile (APP2) and JFIF header are kept.
+func stripJPEGMetadata(content []byte) ([]byte, exifData, bool) {
+	exif := exifData{}
+	if len(content) < 4 || content[0] != 0xFF || content[1] != 0xD8 {
+		return nil, exif, false
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(content)))
+	out.Write(content[:2])
+	pos := 2
+	for pos+4 <= len(content) {
+		if content[pos] != 0xFF {
+			return nil, exif, false
+		}
+		marker := content[pos+1]
+		if marker == 0xFF {
+			pos++ // Fill byte before a marker
+			continue
+		}
+
+		// Start of scan: the compressed image data follows up to the end of the file
+		if marker == 0xDA {
+			out.Write(content[pos:])
+			return out.Bytes(), exif, true
+		}
+
+		length := int(binary.BigEndian.Uint16(content[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(content) {
+			return nil, exif, false
+		}
+		segment := content[pos+4 : end]
+
+		switch marker {
+		case 0xE1:
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				exif = parseEXIF(segment[6:])
+			}
+		case 0xED:
+			// IPTC, dropped like the EXIF and XMP segments
+		default:
+			out.Write(content[pos:end])
+		}
+		pos = end
+	}
+	return nil, exif, false
+}
+
+// parseEXIF reads the orientation, capture time and GPS position of a TIFF structure.
+// Malformed data ends the parse with what was read so far.
+func parseEXIF(tiff []byte) exifData {
+	exif := exifData{orientation: 1}
+	if len(tiff) < 8 {
+		return exif
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return exif
+	}
+
+	ifd0 := readIFD(tiff, order, int(order.Uint32(tiff[4:8])))
+	if v, ok := ifd0[0x0112]; ok {
+		exif.orientation = int(entryShort(tiff, order, v))
+	}
+
+	if v, ok := ifd0[0x8769]; ok {
+		sub := readIFD(tiff, order, int(order.Uint32(v.value)))
+		for _, tag := range []uint16{0x9003, 0x9004} { // DateTimeOriginal, DateTimeDigitized
+			if raw, ok := sub[tag]; ok {
+				if t, err := time.ParseInLocation("2006:01:02 15:04:05", entryASCII(tiff, order, raw), time.Local); err == nil {
+					exif.capturedAt = &t
+					break
+				}
+			}
+		}
+	}
+
+	if v, ok := ifd0[0x8825]; ok {
+		gps := readIFD(tiff, order, int(order.Uint32(v.value)))
+		lat, latOK := entryDegrees(tiff, order, gps[0x0002])
+		lng, lngOK := entryDegrees(tiff, order, gps[0x0004])
+		if latOK && lngOK {
+			if strings.HasPrefix(entryASCII(tiff, order, gps[0x0001]), "S") {
+				lat = -lat
+			}
+			if strings.HasPrefix(entryASCII(tiff, order, gps[0x0003]), "W") {
+				lng = -lng
+			}
+			exif.lat, exif.lng, exif.hasLocation = lat, lng, true
+		}
+	}
+	return exif
+}
+
+// ifdEntry is one tag of an image file directory
+type ifdEntry struct {
+	kind  uint16
+	count uint32
+	value []byte // The 4 value bytes, holding the value or its offset
+}
+
+// readIFD returns the entries of the directory at offset by tag
+func readIFD(tiff []byte, order binary.ByteOrder, offset int) map[uint16]ifdEntry {
+	entries := map[uint16]ifdEntry{}
+	if offset < 8 || offset+2 > len(tiff) {
+		return entries
+	}
+	n := int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < n; i++ {
+		start := offset + 2 + i*12
+		if start+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[start:start+2])] = ifdEntry{
+			kind:  order.Uint16(tiff[start+2 : start+4]),
+			count: order.Uint32(tiff[start+4 : start+8]),
+			value: tiff[start+8 : start+12],
+		}
+	}
+	return entries
+}
+
+// entryData returns the bytes of an entry's value, inline or at its offset
+func entryData(tiff []byte, order binary.ByteOrder, entry ifdEntry, size int) []byte {
+	total := size * int(entry.count)
+	if entry.value == nil || total <= 0 {
+		return nil
+	}
+	if total <= 4 {
+		return entry.value[:total]
+	}
+	offset := int(order.Uint32(entry.value))
+	if offset < 0 || offset+total > len(tiff) {
+		return nil
+	}
+	return tiff[offset : offset+total]
+}
+
+func entryShort(tiff []byte, order binary.ByteOrder, entry ifdEntry) uint16 {
+	if data := entryData(tiff, order, entry, 2); len(data) >= 2 {
+		return order.Uint16(data)
+	}
+	return 1
+}
+
+func entryASCII(tiff []byte, order binary.ByteOrder, entry ifdEntry) string {
+	return strings.TrimRight(string(entryData(tiff, order, entry, 1)), "\x00 ")
+}
+
+// entryDegrees converts a GPS degrees, minutes, seconds triple of rationals
+func entryDegrees(tiff []byte, order binary.ByteOrder, entry ifdEntry) (float64, bool) {
+	data := entryData(tiff, order, entry, 8)
+	if entry.kind != 5 || len(data) < 24 {
+		return 0, false
+	}
+	degrees := 0.0
+	for i, scale := range []float64{1, 60, 3600} {
+		num := float64(order.Uint32(data[i*8 : i*8+4]))
+		den := float64(order.Uint32(data[i*8+4 : i*8+8]))
+		if den == 0 {
+			return 0, false
+		}
+		degrees += num / den / scale
+	}
+	return degrees, true
+}
+
+// reorient decodes a JPEG, applies an EXIF orientation and encodes it again
+func reorient(content []byte, orientation int) ([]byte, error) {
+	// The header gives the size before a decompression bomb is decoded
+	size, err := jpeg.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if int64(size.Width)*int64(size.Height) > maxReorientPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, err := jpeg.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// at maps a pixel of the upright image to the stored one
+	at := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return w - 1 - x, y },
+		3: func(x, y int) (int, int) { return w - 1 - x, h - 1 - y },
+		4: func(x, y int) (int, int) { return x, h - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return y, h - 1 - x },
+		7: func(x, y int) (int, int) { return w - 1 - y, h - 1 - x },
+		8: func(x, y int) (int, int) { return w - 1 - y, x },
+	}[orientation]
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := at(x, y)
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -1,6 +1,8 @@
 package file
 
 import (
+	"fmt"
+	"io"
 	"mime/multipart"
 	"path/filepath"
 	"strings"
@@ -11,26 +13,50 @@ import (
 
 // UploadResult holds upload result
 type UploadResult struct {
-	URL      string `json:"url"`
-	PublicID string `json:"public_id"`
+	URL        string            `json:"url"`
+	PublicID   string            `json:"public_id"`
+	Variants   map[string]string `json:"variants,omitempty"`   // Resized copies of an image, by variant name
+	Photo      *Photo            `json:"-"`                    // EXIF data kept from a JPEG before it was stripped
+	Moderation string            `json:"moderation,omitempty"` // Moderation status, when CDN_MODERATION is on
 }
 
-// UploadFile uploads a file to CDN
-func UploadFile(file *multipart.FileHeader) (*UploadResult, error) {
-	result, err := cloudinary.Upload(file)
+// UploadFile uploads a file to CDN under one of the upload categories. JPEGs are
+// turned upright and stripped of their metadata first.
+func UploadFile(file *multipart.FileHeader, category string) (*UploadResult, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	content, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	content, photo, err := Sanitize(content, file.Filename)
+	if err != nil {
+		return nil, err
+	}
+	result, err := storage.Upload(content, file.Filename, category)
 	if err != nil {
 		return nil, err
 	}
 	
-	return &UploadResult{
-		URL:      result.URL,
-		PublicID: result.PublicID,
-	}, nil
+	uploaded := &UploadResult{
+		URL:        result.URL,
+		PublicID:   result.PublicID,
+		Photo:      photo,
+		Moderation: result.Moderation,
+	}
+	if result.ResourceType == "image" {
+		uploaded.Variants = cloudinary.VariantURLs(result.URL)
+	}
+	return uploaded, nil
 }
 
 // DeleteFile deletes a file from CDN
 func DeleteFile(publicID string) error {
-	return cloudinary.Destroy(publicID)
+	return storage.Destroy(publicID)
 }
 
 // IsAllowedFileType checks if file type is allowed
@@ -67,12 +93,12 @@ func ValidateFile(file *multipart.FileHeader) error {
 }
 
 // UpdateFile updates a file (delete old, upload new)
-func UpdateFile(oldPublicID string, newFile *multipart.FileHeader) (*UploadResult, error) {
+func UpdateFile(oldPublicID string, newFile *multipart.FileHeader, category string) (*UploadResult, error) {
 	// Delete old file if exists
 	if oldPublicID != "" {
-		cloudinary.Destroy(oldPublicID)
+		storage.Destroy(oldPublicID)
 	}
 	
 	// Upload new file
-	return UploadFile(newFile)
+	return UploadFile(newFile, category)
 }
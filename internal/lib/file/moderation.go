@@ -0,0 +1,39 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// imageLists are the image fields of imageFields that hold a list of images
+var imageLists = map[string]bool{"photos": true}
+
+// SetModeration records the moderation verdict of an uploaded file on every document
+// that references it and returns how many documents were updated
+func SetModeration(ctx context.Context, publicID string, status string, at time.Time) (int64, error) {
+	var updated int64
+	for collectionName, fields := range imageFields {
+		collection := database.GetMongoCollection(collectionName)
+		for _, field := range fields {
+			image := strings.TrimSuffix(field, ".public_id")
+			if imageLists[image] {
+				image += ".$" // The matched element of the list
+			}
+			result, err := collection.UpdateMany(ctx, bson.M{field: publicID}, bson.M{"$set": bson.M{
+				image + ".moderation":   status,
+				image + ".moderated_at": at,
+			}})
+			if err != nil {
+				return updated, fmt.Errorf("%s.%s: %w", collectionName, field, err)
+			}
+			updated += result.ModifiedCount
+		}
+	}
+	return updated, nil
+}
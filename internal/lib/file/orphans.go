@@ -0,0 +1,128 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/cloudinary"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxOrphanDeletes caps the deletions of one cleanup run, so a bug in the reference
+// scan cannot wipe the whole folder at once
+const maxOrphanDeletes = 500
+
+// imageFields lists where documents reference uploaded files, by collection
+var imageFields = map[string][]string{
+	"orders":           {"payment_proof.public_id", "vehicle_photo.public_id"},
+	archive.Collection: {"payment_proof.public_id", "vehicle_photo.public_id"},
+	"products":         {"image.public_id"},
+	"order_notes":      {"attachment.public_id"},
+	"returns":          {"photos.public_id"},
+	"users":            {"avatar.public_id"},
+}
+
+// OrphanReport is the result of an orphan cleanup run
+type OrphanReport struct {
+	Scanned    int                `json:"scanned"`
+	Referenced int                `json:"referenced"`
+	Orphans    []cloudinary.Asset `json:"orphans"`
+	Deleted    int                `json:"deleted"`
+	Failed     int                `json:"failed"`
+	DryRun     bool               `json:"dry_run"`
+	Truncated  bool               `json:"truncated,omitempty"` // More orphans than one run deletes
+}
+
+// referencedFiles returns the public IDs of every file a document references.
+// Any failed lookup aborts, since a partial set would make referenced files look orphaned.
+func referencedFiles(ctx context.Context) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	for collectionName, fields := range imageFields {
+		collection := database.GetMongoCollection(collectionName)
+		for _, field := range fields {
+			ids, err := collection.Distinct(ctx, field, bson.M{})
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", collectionName, field, err)
+			}
+			for _, id := range ids {
+				if publicID, ok := id.(string); ok && publicID != "" {
+					referenced[publicID] = true
+				}
+			}
+		}
+	}
+	return referenced, nil
+}
+
+// FindOrphans lists the CDN files under the upload folder that no document references,
+// and deletes them unless dryRun is set
+func FindOrphans(ctx context.Context, dryRun bool) (*OrphanReport, error) {
+	referenced, err := referencedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OrphanReport{Referenced: len(referenced), Orphans: []cloudinary.Asset{}, DryRun: dryRun}
+	cutoff := time.Now().Add(-config.Cfg.Upload.OrphanGrace)
+
+	for _, resourceType := range []api.AssetType{api.Image, api.File} {
+		cursor := ""
+		for {
+			assets, next, err := storage.ListFolder(ctx, resourceType, cursor, 500)
+			if err != nil {
+				return nil, err
+			}
+			for _, asset := range assets {
+				report.Scanned++
+				if referenced[asset.PublicID] || asset.CreatedAt.After(cutoff) {
+					continue
+				}
+				if len(report.Orphans) >= maxOrphanDeletes {
+					report.Truncated = true
+					continue
+				}
+				report.Orphans = append(report.Orphans, asset)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	for _, asset := range report.Orphans {
+		if err := storage.DestroyAsset(ctx, asset); err != nil {
+			log.Printf("[Upload] Failed to delete orphan %s: %v", asset.PublicID, err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+// CleanupOrphans deletes orphaned CDN files; it is registered as a cron job when
+// orphan cleanup is enabled
+func CleanupOrphans() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := FindOrphans(ctx, false)
+	if err != nil {
+		log.Printf("[Upload] Orphan cleanup failed: %v", err)
+		return
+	}
+	if report.Deleted > 0 || report.Failed > 0 {
+		log.Printf("[Upload] Orphan cleanup: %d scanned, %d deleted, %d failed", report.Scanned, report.Deleted, report.Failed)
+	}
+}
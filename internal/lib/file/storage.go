@@ -0,0 +1,59 @@
+package file
+
+import (
+	"context"
+
+	"bg-go/internal/lib/cloudinary"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+)
+
+// Storage stores uploaded files. Cloudinary is used unless another storage is set,
+// which tests use to avoid network calls.
+type Storage interface {
+	Upload(content []byte, filename string, category string) (*cloudinary.UploadResult, error)
+	Destroy(publicID string) error
+	ListByTag(ctx context.Context, tag string, cursor string, limit int) ([]cloudinary.Asset, string, error)
+	ListFolder(ctx context.Context, resourceType api.AssetType, cursor string, limit int) ([]cloudinary.Asset, string, error)
+	DestroyAsset(ctx context.Context, asset cloudinary.Asset) error
+}
+
+// cloudinaryStorage stores files on Cloudinary
+type cloudinaryStorage struct{}
+
+func (cloudinaryStorage) Upload(content []byte, filename string, category string) (*cloudinary.UploadResult, error) {
+	return cloudinary.UploadBytes(content, filename, category)
+}
+
+func (cloudinaryStorage) Destroy(publicID string) error {
+	return cloudinary.Destroy(publicID)
+}
+
+func (cloudinaryStorage) ListByTag(ctx context.Context, tag string, cursor string, limit int) ([]cloudinary.Asset, string, error) {
+	return cloudinary.ListByTag(ctx, tag, cursor, limit)
+}
+
+func (cloudinaryStorage) ListFolder(ctx context.Context, resourceType api.AssetType, cursor string, limit int) ([]cloudinary.Asset, string, error) {
+	return cloudinary.ListFolder(ctx, resourceType, cursor, limit)
+}
+
+func (cloudinaryStorage) DestroyAsset(ctx context.Context, asset cloudinary.Asset) error {
+	return cloudinary.DestroyAsset(ctx, asset)
+}
+
+// storage is where UploadFile, DeleteFile and UpdateFile store files
+var storage Storage = cloudinaryStorage{}
+
+// SetStorage replaces the file storage, nil restores Cloudinary
+func SetStorage(s Storage) {
+	if s == nil {
+		s = cloudinaryStorage{}
+	}
+	storage = s
+}
+
+// ListCategory returns a page of the uploaded images of a category; cursor is empty for
+// the first page and the returned cursor is empty after the last one
+func ListCategory(ctx context.Context, category string, cursor string, limit int) ([]cloudinary.Asset, string, error) {
+	return storage.ListByTag(ctx, category, cursor, limit)
+}
@@ -0,0 +1,80 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+
+	"bg-go/internal/config"
+)
+
+var (
+	// ErrDisabled is returned when no geocoding provider is configured
+	ErrDisabled = errors.New("geocoding is not configured")
+	// ErrNotFound is returned when the provider cannot place the address
+	ErrNotFound = errors.New("address not found")
+)
+
+// Result is a geocoded address
+type Result struct {
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+	Address string  `json:"address"` // The address as the provider formats it
+}
+
+// Provider turns an address into coordinates
+type Provider interface {
+	Geocode(ctx context.Context, address string) (*Result, error)
+}
+
+// Factory creates a provider from the configuration
+type Factory func(cfg config.GeocodeConfig) Provider
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		"nominatim": newNominatim,
+		"google":    newGoogle,
+	}
+)
+
+// Register adds a provider that GEOCODE_PROVIDER can select
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[name] = factory
+}
+
+// Enabled reports whether a geocoding provider is configured
+func Enabled() bool {
+	return config.Cfg.Geocode.Provider != ""
+}
+
+// Geocode places an address with the configured provider
+func Geocode(ctx context.Context, address string) (*Result, error) {
+	cfg := config.Cfg.Geocode
+	if cfg.Provider == "" {
+		return nil, ErrDisabled
+	}
+
+	mu.RLock()
+	factory, ok := factories[cfg.Provider]
+	mu.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown geocoding provider: " + cfg.Provider)
+	}
+	return factory(cfg).Geocode(ctx, address)
+}
+
+// DistanceKm returns the great-circle distance between two points in kilometers
+func DistanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,73 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bg-go/internal/config"
+)
+
+func TestDistanceKm(t *testing.T) {
+	// Monas, Jakarta to Gedung Sate, Bandung
+	if d := DistanceKm(-6.1754, 106.8272, -6.9025, 107.6187); math.Abs(d-118.5) > 1 {
+		t.Fatalf("distance = %.1f km", d)
+	}
+	if d := DistanceKm(-6.2, 106.8, -6.2, 106.8); d != 0 {
+		t.Fatalf("same point: %v km", d)
+	}
+}
+
+func serve(t *testing.T, body string, status int) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test-app" {
+			t.Errorf("user agent = %q", r.Header.Get("User-Agent"))
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestProviders(t *testing.T) {
+	saved := config.Cfg
+	config.Cfg = &config.Config{App: config.AppConfig{Name: "test-app"}}
+	t.Cleanup(func() { config.Cfg = saved })
+	ctx := context.Background()
+
+	place, err := newNominatim(config.GeocodeConfig{URL: serve(t, `[{"lat":"-6.2","lon":"106.8","display_name":"Jakarta"}]`, 200)}).Geocode(ctx, "Jakarta")
+	if err != nil || place.Lat != -6.2 || place.Lng != 106.8 || place.Address != "Jakarta" {
+		t.Fatalf("nominatim = %+v (%v)", place, err)
+	}
+	if _, err := newNominatim(config.GeocodeConfig{URL: serve(t, `[]`, 200)}).Geocode(ctx, "Nowhere"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("nominatim without results: %v", err)
+	}
+	if _, err := newNominatim(config.GeocodeConfig{URL: serve(t, ``, 429)}).Geocode(ctx, "Jakarta"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("nominatim rate limited: %v", err)
+	}
+
+	ok := `{"status":"OK","results":[{"formatted_address":"Bandung","geometry":{"location":{"lat":-6.9,"lng":107.6}}}]}`
+	place, err = newGoogle(config.GeocodeConfig{URL: serve(t, ok, 200), APIKey: "key"}).Geocode(ctx, "Bandung")
+	if err != nil || place.Lat != -6.9 || place.Address != "Bandung" {
+		t.Fatalf("google = %+v (%v)", place, err)
+	}
+	if _, err := newGoogle(config.GeocodeConfig{URL: serve(t, `{"status":"ZERO_RESULTS"}`, 200)}).Geocode(ctx, "Nowhere"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("google without results: %v", err)
+	}
+	if _, err := newGoogle(config.GeocodeConfig{URL: serve(t, `{"status":"REQUEST_DENIED","error_message":"bad key"}`, 200)}).Geocode(ctx, "Bandung"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("google denied: %v", err)
+	}
+
+	if _, err := Geocode(ctx, "Jakarta"); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("without a provider: %v", err)
+	}
+	config.Cfg.Geocode.Provider = "unknown"
+	if _, err := Geocode(ctx, "Jakarta"); err == nil {
+		t.Fatal("unknown provider: want an error")
+	}
+}
@@ -0,0 +1,127 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"bg-go/internal/config"
+)
+
+// nominatim geocodes with OpenStreetMap's Nominatim, which needs no API key
+type nominatim struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newNominatim(cfg config.GeocodeConfig) Provider {
+	baseURL := cfg.URL
+	if baseURL == "" {
+		baseURL = "https://nominatim.openstreetmap.org"
+	}
+	return &nominatim{baseURL: baseURL, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (p *nominatim) Geocode(ctx context.Context, address string) (*Result, error) {
+	query := url.Values{"q": {address}, "format": {"json"}, "limit": {"1"}}
+
+	var places []struct {
+		Lat         string `json:"lat"`
+		Lon         string `json:"lon"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := getJSON(ctx, p.client, p.baseURL+"/search?"+query.Encode(), &places); err != nil {
+		return nil, err
+	}
+	if len(places) == 0 {
+		return nil, ErrNotFound
+	}
+
+	lat, err := strconv.ParseFloat(places[0].Lat, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latitude from nominatim: %w", err)
+	}
+	lng, err := strconv.ParseFloat(places[0].Lon, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid longitude from nominatim: %w", err)
+	}
+	return &Result{Lat: lat, Lng: lng, Address: places[0].DisplayName}, nil
+}
+
+// google geocodes with the Google Maps Geocoding API
+type google struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newGoogle(cfg config.GeocodeConfig) Provider {
+	baseURL := cfg.URL
+	if baseURL == "" {
+		baseURL = "https://maps.googleapis.com/maps/api/geocode"
+	}
+	return &google{baseURL: baseURL, apiKey: cfg.APIKey, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (p *google) Geocode(ctx context.Context, address string) (*Result, error) {
+	query := url.Values{"address": {address}, "key": {p.apiKey}}
+
+	var body struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+		Results      []struct {
+			FormattedAddress string `json:"formatted_address"`
+			Geometry         struct {
+				Location struct {
+					Lat float64 `json:"lat"`
+					Lng float64 `json:"lng"`
+				} `json:"location"`
+			} `json:"geometry"`
+		} `json:"results"`
+	}
+	if err := getJSON(ctx, p.client, p.baseURL+"/json?"+query.Encode(), &body); err != nil {
+		return nil, err
+	}
+	switch body.Status {
+	case "OK":
+	case "ZERO_RESULTS":
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("google geocoding failed: %s %s", body.Status, body.ErrorMessage)
+	}
+	if len(body.Results) == 0 {
+		return nil, ErrNotFound
+	}
+
+	result := body.Results[0]
+	return &Result{
+		Lat:     result.Geometry.Location.Lat,
+		Lng:     result.Geometry.Location.Lng,
+		Address: result.FormattedAddress,
+	}, nil
+}
+
+// getJSON fetches a URL and decodes its JSON body into out
+func getJSON(ctx context.Context, client *http.Client, rawURL string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	// Nominatim's usage policy requires an identifying User-Agent
+	req.Header.Set("User-Agent", config.Cfg.App.Name)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("geocoding request failed with status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
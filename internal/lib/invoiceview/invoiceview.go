@@ -0,0 +1,118 @@
+package invoiceview
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection is the access log of invoice links
+const Collection = "invoice_views"
+
+// Record logs an open of the order's invoice link and updates its view fields
+func Record(orderID primitive.ObjectID, orderNumber, ip, userAgent string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	view := &models.InvoiceView{
+		ID:          primitive.NewObjectID(),
+		OrderID:     orderID.Hex(),
+		OrderNumber: orderNumber,
+		IP:          ip,
+		UserAgent:   userAgent,
+		ViewedAt:    now,
+	}
+	if _, err := database.GetMongoCollection(Collection).InsertOne(ctx, view); err != nil {
+		log.Printf("[InvoiceView] Failed to log view of %s: %v", orderNumber, err)
+		return
+	}
+
+	orders := database.GetMongoCollection("orders")
+	orders.UpdateOne(ctx, bson.M{"_id": orderID}, bson.M{
+		"$set": bson.M{"last_viewed_at": now},
+		"$inc": bson.M{"invoice_view_count": 1},
+	})
+	// Only the first view sets first_viewed_at
+	orders.UpdateOne(ctx, bson.M{"_id": orderID, "first_viewed_at": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"first_viewed_at": now},
+	})
+}
+
+// List returns the logged views of an order, newest first
+func List(ctx context.Context, orderID string, limit int64) ([]models.InvoiceView, error) {
+	views := []models.InvoiceView{}
+	cursor, err := database.GetMongoCollection(Collection).Find(ctx,
+		bson.M{"order_id": orderID},
+		options.Find().SetSort(bson.D{{Key: "viewed_at", Value: -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &views); err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+// CheckUnviewed publishes invoice.unviewed once for every pending order whose invoice
+// link was not opened within the configured delay; it is registered as a cron job
+func CheckUnviewed() {
+	after := config.Cfg.Client.InvoiceUnviewedAfter
+	if after <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	collection := database.GetMongoCollection("orders")
+	filter := bson.M{
+		"status":            models.OrderStatusPending,
+		"first_viewed_at":   bson.M{"$exists": false},
+		"unviewed_event_at": bson.M{"$exists": false},
+		"created_at":        bson.M{"$lte": time.Now().Add(-after)},
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetLimit(200))
+	if err != nil {
+		log.Printf("[InvoiceView] Failed to load unviewed invoices: %v", err)
+		return
+	}
+	var orders []models.Order
+	err = cursor.All(ctx, &orders)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[InvoiceView] Failed to decode unviewed invoices: %v", err)
+		return
+	}
+
+	for _, order := range orders {
+		// Claim the order first so concurrent runs publish the event once
+		now := time.Now()
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": order.ID, "unviewed_event_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"unviewed_event_at": now}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+
+		events.Publish(ctx, events.InvoiceUnviewed, "order", order.ID.Hex(), map[string]interface{}{
+			"order_number": order.OrderNumber,
+			"sales_id":     order.SalesID,
+			"created_at":   order.CreatedAt,
+			"unviewed_for": now.Sub(order.CreatedAt).Round(time.Minute).String(),
+		})
+	}
+}
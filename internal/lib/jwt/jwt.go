@@ -14,6 +14,12 @@ type Claims struct {
 	UserID string `json:"user_id"`
 	Role   string `json:"role"`
 	Email  string `json:"email,omitempty"`
+
+	// ActorID is set when a SUPERADMIN impersonates another user
+	ActorID string `json:"actor_id,omitempty"`
+
+	// SessionID links the token to a record in the sessions store
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -25,12 +31,13 @@ type TokenPair struct {
 }
 
 // GenerateAccessToken generates a new access token
-func GenerateAccessToken(userID, role string) (string, error) {
+func GenerateAccessToken(userID, role, sessionID string) (string, error) {
 	cfg := config.Cfg
 	
 	claims := Claims{
-		UserID: userID,
-		Role:   role,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.JWT.AccessExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -43,12 +50,13 @@ func GenerateAccessToken(userID, role string) (string, error) {
 }
 
 // GenerateRefreshToken generates a new refresh token
-func GenerateRefreshToken(userID, role string) (string, error) {
+func GenerateRefreshToken(userID, role, sessionID string) (string, error) {
 	cfg := config.Cfg
 	
 	claims := Claims{
-		UserID: userID,
-		Role:   role,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.JWT.RefreshExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -61,13 +69,13 @@ func GenerateRefreshToken(userID, role string) (string, error) {
 }
 
 // GenerateTokenPair generates both access and refresh tokens
-func GenerateTokenPair(userID, role string) (*TokenPair, error) {
-	accessToken, err := GenerateAccessToken(userID, role)
+func GenerateTokenPair(userID, role, sessionID string) (*TokenPair, error) {
+	accessToken, err := GenerateAccessToken(userID, role, sessionID)
 	if err != nil {
 		return nil, err
 	}
 	
-	refreshToken, err := GenerateRefreshToken(userID, role)
+	refreshToken, err := GenerateRefreshToken(userID, role, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +89,26 @@ func GenerateTokenPair(userID, role string) (*TokenPair, error) {
 	}, nil
 }
 
+// GenerateImpersonationToken generates a short-lived access token for userID
+// that also carries the real actor performing the impersonation
+func GenerateImpersonationToken(userID, role, actorID string) (string, error) {
+	cfg := config.Cfg
+
+	claims := Claims{
+		UserID:  userID,
+		Role:    role,
+		ActorID: actorID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.JWT.ImpersonationExpiry)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    cfg.App.Name,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(cfg.JWT.AccessSecret))
+}
+
 // VerifyAccessToken verifies and parses an access token
 func VerifyAccessToken(tokenString string) (*Claims, error) {
 	cfg := config.Cfg
@@ -103,6 +131,11 @@ func VerifyAccessToken(tokenString string) (*Claims, error) {
 	return nil, errors.New("invalid token")
 }
 
+// IsExpired reports whether a verify error is caused by an expired token
+func IsExpired(err error) bool {
+	return errors.Is(err, jwt.ErrTokenExpired)
+}
+
 // VerifyRefreshToken verifies and parses a refresh token
 func VerifyRefreshToken(tokenString string) (*Claims, error) {
 	cfg := config.Cfg
@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/sandbox"
+)
+
+// ErrDisabled is returned when no SMTP server is configured
+var ErrDisabled = errors.New("email delivery is not configured")
+
+// Attachment is a file sent along with a message
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Enabled reports whether an SMTP server is configured
+func Enabled() bool {
+	return config.Cfg.Mail.Host != ""
+}
+
+// Send sends a plain text email with optional attachments
+func Send(to []string, subject string, body string, attachments ...Attachment) error {
+	cfg := config.Cfg.Mail
+	if cfg.Host == "" {
+		return ErrDisabled
+	}
+	if len(to) == 0 {
+		return errors.New("no recipients")
+	}
+
+	// In sandbox mode only allowlisted recipients get the real email
+	deliver := []string{}
+	for _, recipient := range to {
+		if !sandbox.Intercepts(recipient) {
+			deliver = append(deliver, recipient)
+			continue
+		}
+		names := make([]string, len(attachments))
+		for i, attachment := range attachments {
+			names[i] = attachment.Name
+		}
+		if err := sandbox.Capture(sandbox.ChannelEmail, recipient, subject, body, names...); err != nil {
+			return err
+		}
+	}
+	if len(deliver) == 0 {
+		return nil
+	}
+	to = deliver
+
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	message := build(from, to, subject, body, attachments)
+	return smtp.SendMail(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), auth, from, to, message)
+}
+
+// build encodes a MIME message; attachments make it multipart/mixed
+func build(from string, to []string, subject string, body string, attachments []Attachment) []byte {
+	var buf bytes.Buffer
+	boundary := fmt.Sprintf("bg-%d", time.Now().UnixNano())
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(body)
+		return buf.Bytes()
+	}
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, body)
+
+	for _, attachment := range attachments {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s\r\n", attachment.ContentType)
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+		fmt.Fprintf(&buf, "Content-Disposition: attachment; filename=%q\r\n\r\n", attachment.Name)
+
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			buf.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		buf.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes()
+}
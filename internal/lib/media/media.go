@@ -0,0 +1,139 @@
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/models"
+)
+
+// Kinds of sensitive order images, served only through signed links
+const (
+	KindPaymentProof = "payment-proof"
+	KindVehiclePhoto = "vehicle-photo"
+)
+
+// fetchTimeout bounds the request to the CDN behind a signed link
+const fetchTimeout = 30 * time.Second
+
+// Errors returned by Verify
+var (
+	ErrInvalid = errors.New("the image link is invalid")
+	ErrExpired = errors.New("the image link has expired")
+)
+
+var client = &http.Client{Timeout: fetchTimeout}
+
+// IsKind reports whether kind is a signed image kind
+func IsKind(kind string) bool {
+	return kind == KindPaymentProof || kind == KindVehiclePhoto
+}
+
+// URL returns a link to an order image that works until the configured TTL passes.
+// Expiry is rounded up to the minute so repeated responses share a cacheable link.
+func URL(orderID string, kind string) string {
+	return VariantURL(orderID, kind, "")
+}
+
+// VariantURL returns a link to a variant of an order image, the original when variant
+// is empty
+func VariantURL(orderID string, kind string, variant string) string {
+	expires := time.Now().Add(config.Cfg.Media.TTL).Truncate(time.Minute).Add(time.Minute).Unix()
+	link := fmt.Sprintf("%s/api/v1/media/orders/%s/%s?expires=%d&sig=%s",
+		config.Cfg.Media.BaseURL, orderID, kind, expires, signature(orderID, kind, variant, expires))
+	if variant != "" {
+		link += "&variant=" + url.QueryEscape(variant)
+	}
+	return link
+}
+
+// Verify checks the expiry and signature of a link; it returns when the link expires
+func Verify(orderID string, kind string, variant string, expires string, sig string) (time.Time, error) {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(signature(orderID, kind, variant, unix))) {
+		return time.Time{}, ErrInvalid
+	}
+	expiresAt := time.Unix(unix, 0)
+	if time.Now().After(expiresAt) {
+		return time.Time{}, ErrExpired
+	}
+	return expiresAt, nil
+}
+
+// SignOrder replaces the CDN URLs of an order's sensitive images with signed links and
+// drops their public IDs. Only call it on orders about to be sent in a response.
+func SignOrder(order *models.Order) {
+	order.PaymentProof = Sign(order.PaymentProof, order.ID.Hex(), KindPaymentProof)
+	order.VehiclePhoto = Sign(order.VehiclePhoto, order.ID.Hex(), KindVehiclePhoto)
+}
+
+// Sign returns a copy of an order image with signed links instead of its CDN URLs
+func Sign(image *models.Image, orderID string, kind string) *models.Image {
+	if image == nil || image.URL == "" {
+		return image
+	}
+	signed := &models.Image{URL: URL(orderID, kind), CapturedAt: image.CapturedAt}
+	if len(image.Variants) > 0 {
+		signed.Variants = make(map[string]string, len(image.Variants))
+		for variant := range image.Variants {
+			signed.Variants[variant] = VariantURL(orderID, kind, variant)
+		}
+	}
+	return signed
+}
+
+// Source returns the stored image of an order for a kind
+func Source(order *models.Order, kind string) *models.Image {
+	switch kind {
+	case KindPaymentProof:
+		return order.PaymentProof
+	case KindVehiclePhoto:
+		return order.VehiclePhoto
+	}
+	return nil
+}
+
+// SourceURL returns the CDN URL of a variant of an image, or of the original when
+// variant is empty; it is empty when the image has no such variant
+func SourceURL(image *models.Image, variant string) string {
+	if image == nil {
+		return ""
+	}
+	if variant == "" {
+		return image.URL
+	}
+	return image.Variants[variant]
+}
+
+// Fetch requests an image from the CDN; the caller closes the body. The body is streamed
+// after the handler returns, so only the client timeout bounds the request.
+func Fetch(rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("CDN responded %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// signature signs an image link with the media secret
+func signature(orderID string, kind string, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(config.Cfg.Media.Secret))
+	fmt.Fprintf(mac, "%s:%s:%s:%d", orderID, kind, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,97 @@
+package notification
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Branding is the sender identity the company settings put on every notification
+type Branding struct {
+	SenderName string `json:"sender_name"`
+	Signature  string `json:"signature"`
+	Footer     string `json:"footer"`
+	Company    string `json:"company"` // Company name, for messages naming the sender in the text
+}
+
+// LoadBranding returns the branding of the company settings. Lookup errors return no
+// branding so a database hiccup does not block sending.
+func LoadBranding(ctx context.Context) Branding {
+	settings := &models.CompanySettings{}
+	database.GetMongoCollection("company_settings").FindOne(ctx, bson.M{}).Decode(settings)
+	return Branding{
+		SenderName: strings.TrimSpace(settings.SenderName),
+		Signature:  strings.TrimSpace(settings.Signature),
+		Footer:     strings.TrimSpace(settings.Footer),
+		Company:    strings.TrimSpace(settings.Name),
+	}
+}
+
+// SenderName returns the name messages introduce their sender with: the configured
+// sender name, else the company name, else the application name
+func SenderName(ctx context.Context) string {
+	branding := LoadBranding(ctx)
+	switch {
+	case branding.SenderName != "":
+		return branding.SenderName
+	case branding.Company != "":
+		return branding.Company
+	}
+	return config.Cfg.App.Name
+}
+
+// Apply puts the sender name above a message, in WhatsApp bold, and the signature and
+// footer (in italics) below it
+func (b Branding) Apply(message string) string {
+	if b.SenderName != "" {
+		message = "*" + b.SenderName + "*\n\n" + message
+	}
+	if b.Signature != "" {
+		message += "\n" + b.Signature
+	}
+	if b.Footer != "" {
+		message += "\n\n_" + b.Footer + "_"
+	}
+	return message
+}
+
+// brand applies the saved branding to a message about to be sent
+func brand(message string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return LoadBranding(ctx).Apply(message)
+}
+
+// previewOrder is the sample order template previews are rendered with
+var previewOrder = &models.Order{
+	OrderNumber:  "ORD-20260101-0001",
+	Status:       models.OrderStatusQueued,
+	QueueNumber:  12,
+	DriverName:   "Joko",
+	VehiclePlate: "B 1234 XYZ",
+	LoadingBay:   "2",
+	QueueToken:   "a1b2c3d4e5f6",
+	InvoiceToken: "preview",
+}
+
+// previewValues fill the placeholders that are not order fields in a template preview
+var previewValues = strings.NewReplacer(
+	"{total}", "1.500.000",
+	"{hours}", "24",
+	"{due_date}", "01/01/2026",
+	"{days}", "3",
+	"{sales}", "Budi",
+)
+
+// RenderPreview fills a template with sample values for every placeholder of the
+// status, reminder and overdue templates, then brands it as it would be sent
+func RenderPreview(template string, branding Branding) string {
+	message := previewValues.Replace(RenderStatusTemplate(template, previewOrder, "Budi"))
+	return branding.Apply(message)
+}
@@ -0,0 +1,243 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotificationTypeBroadcast is used for the messages of notification broadcasts
+const NotificationTypeBroadcast NotificationType = "broadcast"
+
+// BroadcastCollection holds the notification broadcasts
+const BroadcastCollection = "notification_broadcasts"
+
+// Audience segments of a broadcast
+const (
+	SegmentActiveSales      = "active_sales"      // Every active sales rep with a phone
+	SegmentSalesThisMonth   = "sales_this_month"  // Active sales reps with an order this month
+	SegmentOverdueCustomers = "overdue_customers" // Customers of orders unpaid past their due date
+)
+
+// BroadcastSegments lists the segments a broadcast can target
+var BroadcastSegments = []string{SegmentActiveSales, SegmentSalesThisMonth, SegmentOverdueCustomers}
+
+// broadcastStale is how long a sending broadcast may go without a heartbeat before
+// another run resumes it
+const broadcastStale = 2 * time.Minute
+
+// ErrUnknownSegment is returned for a segment that is not in BroadcastSegments
+var ErrUnknownSegment = errors.New("unknown segment")
+
+// SegmentRecipients resolves a segment into recipients, one per phone, sorted by name
+func SegmentRecipients(ctx context.Context, segment string, now time.Time) ([]models.BroadcastRecipient, error) {
+	switch segment {
+	case SegmentActiveSales:
+		return salesRecipients(ctx, bson.M{"is_active": true, "phone": bson.M{"$ne": ""}})
+
+	case SegmentSalesThisMonth:
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		ids, err := database.GetMongoCollection("orders").Distinct(ctx, "sales_id", bson.M{
+			"created_at": bson.M{"$gte": monthStart},
+			"status":     bson.M{"$nin": models.UnrealizedOrderStatuses},
+		})
+		if err != nil {
+			return nil, err
+		}
+		objIDs := []primitive.ObjectID{}
+		for _, id := range ids {
+			if hex, ok := id.(string); ok {
+				if objID, err := primitive.ObjectIDFromHex(hex); err == nil {
+					objIDs = append(objIDs, objID)
+				}
+			}
+		}
+		if len(objIDs) == 0 {
+			return []models.BroadcastRecipient{}, nil
+		}
+		return salesRecipients(ctx, bson.M{"_id": bson.M{"$in": objIDs}, "is_active": true, "phone": bson.M{"$ne": ""}})
+
+	case SegmentOverdueCustomers:
+		filter := overdueFilter(now)
+		filter["customer_phone"] = bson.M{"$ne": ""}
+		cursor, err := database.GetMongoCollection("orders").Find(ctx, filter,
+			options.Find().SetProjection(bson.M{"customer_phone": 1, "customer_name": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var orders []models.Order
+		err = cursor.All(ctx, &orders)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients := []models.BroadcastRecipient{}
+		for _, order := range orders {
+			recipients = append(recipients, models.BroadcastRecipient{Phone: order.CustomerPhone, Name: order.CustomerName})
+		}
+		return uniqueRecipients(recipients), nil
+	}
+	return nil, ErrUnknownSegment
+}
+
+// salesRecipients returns the sales reps matching a filter as recipients
+func salesRecipients(ctx context.Context, filter bson.M) ([]models.BroadcastRecipient, error) {
+	cursor, err := database.GetMongoCollection("sales").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var sales []models.Sales
+	err = cursor.All(ctx, &sales)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := []models.BroadcastRecipient{}
+	for _, s := range sales {
+		recipients = append(recipients, models.BroadcastRecipient{Phone: s.Phone, Name: s.Name})
+	}
+	return uniqueRecipients(recipients), nil
+}
+
+// uniqueRecipients keeps the first recipient of every phone and sorts them by name
+func uniqueRecipients(recipients []models.BroadcastRecipient) []models.BroadcastRecipient {
+	seen := map[string]bool{}
+	unique := []models.BroadcastRecipient{}
+	for _, recipient := range recipients {
+		key := strings.TrimSpace(recipient.Phone)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, recipient)
+	}
+	sort.SliceStable(unique, func(i, j int) bool { return unique[i].Name < unique[j].Name })
+	return unique
+}
+
+// SendBroadcasts sends the broadcasts that are due, and resumes those whose run stopped
+// halfway. A broadcast is claimed before sending and its recipients are resolved once,
+// with a notification ID each, so a resumed run never messages anyone twice. It is
+// registered as a cron job and run right away for broadcasts sent now.
+func SendBroadcasts() {
+	collection := database.GetMongoCollection(BroadcastCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := collection.Find(ctx,
+		bson.M{"$or": []bson.M{
+			{"status": models.ScheduledStatusScheduled, "send_at": bson.M{"$lte": now}},
+			{"status": models.ScheduledStatusSending, "heartbeat_at": bson.M{"$lt": now.Add(-broadcastStale)}},
+		}},
+		options.Find().SetSort(bson.D{{Key: "send_at", Value: 1}}).SetLimit(10),
+	)
+	if err != nil {
+		log.Printf("[Notification] Failed to load due broadcasts: %v", err)
+		return
+	}
+	var due []models.NotificationBroadcast
+	err = cursor.All(ctx, &due)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[Notification] Failed to load due broadcasts: %v", err)
+		return
+	}
+
+	for i := range due {
+		broadcast := &due[i]
+		claim := bson.M{"_id": broadcast.ID, "status": broadcast.Status}
+		if broadcast.HeartbeatAt != nil {
+			claim["heartbeat_at"] = *broadcast.HeartbeatAt
+		}
+		set := bson.M{"status": models.ScheduledStatusSending, "heartbeat_at": now, "updated_at": now}
+		if broadcast.StartedAt == nil {
+			set["started_at"] = now
+		}
+		result, err := collection.UpdateOne(ctx, claim, bson.M{"$set": set})
+		if err != nil || result.ModifiedCount == 0 {
+			continue // Cancelled or claimed by another run meanwhile
+		}
+		runBroadcast(broadcast)
+	}
+}
+
+// runBroadcast sends a claimed broadcast to its recipients, pausing between messages
+// like WhatsApp broadcasts, and stops when it is cancelled
+func runBroadcast(broadcast *models.NotificationBroadcast) {
+	collection := database.GetMongoCollection(BroadcastCollection)
+
+	if len(broadcast.Recipients) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		recipients, err := SegmentRecipients(ctx, broadcast.Segment, time.Now())
+		if err == nil {
+			for i := range recipients {
+				recipients[i].NotificationID = primitive.NewObjectID().Hex()
+			}
+			_, err = collection.UpdateOne(ctx, bson.M{"_id": broadcast.ID}, bson.M{"$set": bson.M{
+				"recipients": recipients,
+				"audience":   len(recipients),
+			}})
+		}
+		cancel()
+		if err != nil {
+			log.Printf("[Notification] Failed to resolve broadcast %s: %v", broadcast.ID.Hex(), err)
+			return // Resumed once the heartbeat is stale
+		}
+		broadcast.Recipients = recipients
+	}
+
+	interval := config.Cfg.WhatsApp.BroadcastInterval
+	for i, recipient := range broadcast.Recipients {
+		notificationID, _ := primitive.ObjectIDFromHex(recipient.NotificationID)
+		delivered, err := Deliver(Notification{
+			ID:          notificationID,
+			Type:        NotificationTypeBroadcast,
+			Phone:       recipient.Phone,
+			Message:     strings.ReplaceAll(broadcast.Message, "{name}", recipient.Name),
+			BroadcastID: broadcast.ID.Hex(),
+		})
+		if err != nil {
+			log.Printf("[Notification] Failed to send broadcast %s to %s: %v", broadcast.ID.Hex(), recipient.Phone, err)
+		}
+
+		// Renew the claim; a cancelled broadcast no longer matches
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		now := time.Now()
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": broadcast.ID, "status": models.ScheduledStatusSending},
+			bson.M{"$set": bson.M{"heartbeat_at": now, "updated_at": now}},
+		)
+		cancel()
+		if err == nil && result.MatchedCount == 0 {
+			log.Printf("[Notification] Broadcast %s cancelled after %d of %d recipients", broadcast.ID.Hex(), i+1, len(broadcast.Recipients))
+			return
+		}
+		if !delivered && interval > 0 && i < len(broadcast.Recipients)-1 {
+			time.Sleep(interval)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now()
+	collection.UpdateOne(ctx, bson.M{"_id": broadcast.ID, "status": models.ScheduledStatusSending}, bson.M{"$set": bson.M{
+		"status":      models.ScheduledStatusSent,
+		"finished_at": now,
+		"updated_at":  now,
+	}})
+	log.Printf("[Notification] Broadcast %s sent to %d recipients", broadcast.ID.Hex(), len(broadcast.Recipients))
+}
@@ -0,0 +1,51 @@
+package notification
+
+import (
+	"strings"
+
+	"bg-go/internal/models"
+)
+
+// NotificationTypeCancelRequest tells the requester the outcome of a cancellation request
+const NotificationTypeCancelRequest NotificationType = "cancel_request"
+
+const cancelApprovedTemplate = `Halo {name},
+
+Permintaan pembatalan order {order_number} telah disetujui. Order telah dibatalkan.
+{note}
+Terima kasih.`
+
+const cancelDeniedTemplate = `Halo {name},
+
+Permintaan pembatalan order {order_number} tidak dapat disetujui, order tetap berjalan.
+{note}
+Detail order dapat dilihat melalui link:
+{link}
+
+Terima kasih.`
+
+// SendCancelRequestOutcome tells the requester whether a cancellation request was approved
+func SendCancelRequestOutcome(phone string, order *models.Order, request *models.CancelRequest) (string, error) {
+	template := cancelDeniedTemplate
+	if request.Status == models.CancelRequestApproved {
+		template = cancelApprovedTemplate
+	}
+	note := ""
+	if request.ReviewNote != "" {
+		note = "\nCatatan: " + request.ReviewNote + "\n"
+	}
+
+	link := orderLink(order)
+	return dispatch(Notification{
+		Type:  NotificationTypeCancelRequest,
+		Phone: phone,
+		Message: strings.NewReplacer(
+			"{name}", request.SalesName,
+			"{order_number}", order.OrderNumber,
+			"{note}", note,
+			"{link}", link,
+		).Replace(template),
+		Link:    link,
+		OrderID: order.ID.Hex(),
+	})
+}
@@ -0,0 +1,97 @@
+package notification
+
+import (
+	"fmt"
+	"strings"
+
+	"bg-go/internal/models"
+)
+
+// NotificationTypeOrderChange asks the sales rep to confirm an edit of an order's items
+const NotificationTypeOrderChange NotificationType = "order_change"
+
+const orderChangeTemplate = `Halo {name},
+
+Ada perubahan pada order {order_number} yang perlu Anda konfirmasi:
+
+{changes}
+
+Total: Rp {previous_total} menjadi Rp {total}
+
+Setujui atau tolak perubahan melalui link berikut:
+{link}
+{timeout}
+Terima kasih.`
+
+const orderChangeAppliedTemplate = `Halo {name},
+
+Perubahan pada order {order_number} telah diterapkan karena tidak dikonfirmasi hingga batas waktu.
+
+{changes}
+
+Total baru: Rp {total}
+
+Detail order dapat dilihat melalui link:
+{link}
+
+Terima kasih.`
+
+// describeChanges lists the item changes, one per line
+func describeChanges(changes []models.ItemChange) string {
+	lines := []string{}
+	for _, change := range changes {
+		if change.To == 0 {
+			lines = append(lines, fmt.Sprintf("- %s: %d %s dihapus", change.ProductName, change.From, change.Unit))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %d menjadi %d %s", change.ProductName, change.From, change.To, change.Unit))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SendOrderChangeRequest sends the sales rep the re-confirmation link of a staged change.
+// autoApply tells whether the timeout applies the change rather than discarding it.
+func SendOrderChangeRequest(phone string, name string, order *models.Order, change *models.OrderChange, autoApply bool) (string, error) {
+	link := orderLink(order)
+	timeout := ""
+	if change.ExpiresAt != nil {
+		action := "dibatalkan"
+		if autoApply {
+			action = "otomatis diterapkan"
+		}
+		timeout = fmt.Sprintf("\nTanpa konfirmasi hingga %s, perubahan akan %s.\n", change.ExpiresAt.Format("02/01/2006 15:04"), action)
+	}
+	return dispatch(Notification{
+		Type:  NotificationTypeOrderChange,
+		Phone: phone,
+		Message: strings.NewReplacer(
+			"{name}", name,
+			"{order_number}", order.OrderNumber,
+			"{changes}", describeChanges(change.Changes),
+			"{previous_total}", fmt.Sprintf("%.0f", change.PreviousTotal),
+			"{total}", fmt.Sprintf("%.0f", change.TotalPrice),
+			"{link}", link,
+			"{timeout}", timeout,
+		).Replace(orderChangeTemplate),
+		Link:    link,
+		OrderID: order.ID.Hex(),
+	})
+}
+
+// SendOrderChangeApplied tells the sales rep that the timeout applied a staged change
+func SendOrderChangeApplied(phone string, name string, order *models.Order, change *models.OrderChange) (string, error) {
+	link := orderLink(order)
+	return dispatch(Notification{
+		Type:  NotificationTypeOrderChange,
+		Phone: phone,
+		Message: strings.NewReplacer(
+			"{name}", name,
+			"{order_number}", order.OrderNumber,
+			"{changes}", describeChanges(change.Changes),
+			"{total}", fmt.Sprintf("%.0f", change.TotalPrice),
+			"{link}", link,
+		).Replace(orderChangeAppliedTemplate),
+		Link:    link,
+		OrderID: order.ID.Hex(),
+	})
+}
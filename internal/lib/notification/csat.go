@@ -0,0 +1,33 @@
+package notification
+
+import (
+	"strings"
+)
+
+// NotificationTypeCSAT is the satisfaction survey sent after an order completes
+const NotificationTypeCSAT NotificationType = "csat"
+
+const csatTemplate = `Halo {name},
+
+Terima kasih telah mengambil order {order_number}. Seberapa puas Anda dengan layanan kami?
+
+Beri nilai 1-5 melalui link berikut, hanya butuh beberapa detik:
+{link}
+
+Terima kasih.`
+
+// SendCSATSurvey sends the one-question rating link of an order's survey
+func SendCSATSurvey(phone string, name string, orderID string, orderNumber string, token string) (string, error) {
+	link := clientLink("survey", token)
+	return dispatch(Notification{
+		Type:  NotificationTypeCSAT,
+		Phone: phone,
+		Message: strings.NewReplacer(
+			"{name}", name,
+			"{order_number}", orderNumber,
+			"{link}", link,
+		).Replace(csatTemplate),
+		Link:    link,
+		OrderID: orderID,
+	})
+}
@@ -0,0 +1,230 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deadLetterAlertCooldown limits how often admins are alerted about dead letters
+const deadLetterAlertCooldown = time.Hour
+
+var (
+	alertMu     sync.Mutex
+	lastAlertAt time.Time
+)
+
+// scheduleRetry sets the next attempt with exponential backoff,
+// or dead-letters the notification once max attempts are exhausted
+func scheduleRetry(notification *Notification, now time.Time) {
+	cfg := config.Cfg.Notification
+
+	if notification.Attempts >= cfg.MaxAttempts {
+		notification.Status = StatusFailed
+		notification.FailedAt = &now
+		notification.NextAttemptAt = nil
+		return
+	}
+
+	backoff := cfg.RetryInterval * time.Duration(1<<(notification.Attempts-1))
+	next := now.Add(backoff)
+	notification.Status = StatusPending
+	notification.NextAttemptAt = &next
+}
+
+// retryBatch is the most notifications one sweep re-sends
+const retryBatch = 50
+
+// retryLease is how long a claimed retry is hidden from other sweeps; a sweep that dies
+// mid-send leaves the notification to be retried once it runs out
+const retryLease = 5 * time.Minute
+
+// claimRetry takes the most overdue pending notification by pushing its next attempt past
+// the lease, so concurrent sweeps (the cron job and a requeue) never send it twice. The
+// returned notification keeps the due time it had before the claim.
+func claimRetry(ctx context.Context) (*Notification, error) {
+	now := time.Now()
+	notification := &Notification{}
+	err := database.GetMongoCollection("notifications").FindOneAndUpdate(ctx,
+		bson.M{
+			"status":          StatusPending,
+			"next_attempt_at": bson.M{"$lte": now},
+		},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(retryLease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}),
+	).Decode(notification)
+	if err != nil {
+		return nil, err
+	}
+	return notification, nil
+}
+
+// RetryDue re-sends pending notifications whose next attempt is due.
+// Nothing is attempted while WhatsApp is disconnected so retries are not wasted.
+func RetryDue() {
+	if activeSender() == nil {
+		return
+	}
+
+	collection := database.GetMongoCollection("notifications")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	deadLettered := false
+	for i := 0; i < retryBatch; i++ {
+		notification, err := claimRetry(ctx)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.Printf("[Notification] Failed to claim a due retry: %v", err)
+			}
+			break
+		}
+		if OptedOut(ctx, notification.Phone) {
+			// Opted out after the first attempt failed
+			collection.UpdateOne(ctx, bson.M{"_id": notification.ID}, bson.M{"$set": bson.M{
+				"status":          StatusSuppressed,
+				"next_attempt_at": nil,
+			}})
+			continue
+		}
+
+		sent, provider, err := sendViaWhatsApp(notification.Phone, notification.Message)
+		if !sent && err == nil {
+			// Disconnected mid-run; release the claim and try again on the next tick
+			collection.UpdateOne(ctx, bson.M{"_id": notification.ID}, bson.M{"$set": bson.M{"next_attempt_at": notification.NextAttemptAt}})
+			break
+		}
+
+		now := time.Now()
+		notification.Attempts++
+		notification.LastAttemptAt = &now
+
+		update := bson.M{
+			"attempts":        notification.Attempts,
+			"last_attempt_at": now,
+		}
+
+		if sent {
+			update["status"] = StatusSent
+			update["sent_via"] = sentVia(notification.Phone)
+			if provider != "" {
+				update["provider"] = provider
+			}
+			update["sent_at"] = now
+			update["next_attempt_at"] = nil
+		} else {
+			notification.LastError = err.Error()
+			scheduleRetry(notification, now)
+			update["last_error"] = notification.LastError
+			if smsFallback(notification, err, now) {
+				update["status"] = StatusSent
+				update["sent_via"] = SentViaSMS
+				update["sent_at"] = now
+				update["next_attempt_at"] = nil
+				update["sms"] = notification.SMS
+				update["sms_error"] = ""
+			} else {
+				update["status"] = notification.Status
+				update["next_attempt_at"] = notification.NextAttemptAt
+				if notification.SMSError != "" {
+					update["sms_error"] = notification.SMSError
+				}
+				if notification.Status == StatusFailed {
+					update["failed_at"] = now
+					deadLettered = true
+				}
+			}
+		}
+
+		collection.UpdateOne(ctx, bson.M{"_id": notification.ID}, bson.M{"$set": update})
+	}
+
+	if deadLettered {
+		checkDeadLetters()
+	}
+}
+
+// Requeue moves dead-lettered notifications back to pending for immediate retry.
+// An empty ids list requeues every failed notification.
+func Requeue(ids []primitive.ObjectID) (int64, error) {
+	collection := database.GetMongoCollection("notifications")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"status": StatusFailed}
+	if len(ids) > 0 {
+		filter["_id"] = bson.M{"$in": ids}
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":          StatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		},
+		"$unset": bson.M{"failed_at": ""},
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	if result.ModifiedCount > 0 {
+		go RetryDue()
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// checkDeadLetters alerts the company WhatsApp number when dead letters pile up
+func checkDeadLetters() {
+	threshold := config.Cfg.Notification.DeadLetterThreshold
+	if threshold <= 0 {
+		return
+	}
+
+	alertMu.Lock()
+	defer alertMu.Unlock()
+
+	if time.Since(lastAlertAt) < deadLetterAlertCooldown {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := database.GetMongoCollection("notifications").CountDocuments(ctx, bson.M{"status": StatusFailed})
+	if err != nil || count < int64(threshold) {
+		return
+	}
+
+	lastAlertAt = time.Now()
+	log.Printf("[Notification] ALERT: %d notifications in dead-letter state (threshold %d)", count, threshold)
+
+	var settings struct {
+		WhatsAppNumber string `bson:"whatsapp_number"`
+	}
+	database.GetMongoCollection("company_settings").FindOne(ctx, bson.M{}).Decode(&settings)
+	if settings.WhatsAppNumber == "" {
+		return
+	}
+
+	message := fmt.Sprintf(`Peringatan sistem notifikasi:
+
+%d notifikasi gagal terkirim setelah beberapa kali percobaan.
+Silakan cek menu notifikasi gagal dan kirim ulang.`, count)
+
+	sendViaWhatsApp(settings.WhatsAppNumber, message)
+}
@@ -0,0 +1,95 @@
+package notification
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InboundCollection holds the direct WhatsApp messages received from clients
+const InboundCollection = "inbound_messages"
+
+// replyWindow is how long after a notification an unreferenced reply is still
+// taken to be about the notification's order
+const replyWindow = 7 * 24 * time.Hour
+
+// inboundOrderNumber finds order numbers such as ORD-202610-0001 in a reply
+var inboundOrderNumber = regexp.MustCompile(`\b[A-Z]+-\d{6}-\d+\b`)
+
+// RecordInbound saves a received message and links it to an order: one the text names
+// that was notified to the phone, else the order of the latest notification to the phone
+// within the reply window. A message matching neither is saved without an order.
+func RecordInbound(ctx context.Context, phone string, text string) (*models.InboundMessage, error) {
+	message := &models.InboundMessage{
+		ID:         primitive.NewObjectID(),
+		Phone:      optOutKey(phone),
+		Text:       text,
+		ReceivedAt: time.Now(),
+	}
+
+	// Notifications keep the phone as it was entered, so every form of it is matched
+	phones := bson.M{"$in": utils.PhoneVariants(phone)}
+	notifications := database.GetMongoCollection("notifications")
+	orders := database.GetMongoCollection("orders")
+
+	for _, number := range inboundOrderNumber.FindAllString(strings.ToUpper(text), -1) {
+		order := &models.Order{}
+		if orders.FindOne(ctx, bson.M{"order_number": number}).Decode(order) != nil {
+			continue
+		}
+		if notifications.FindOne(ctx, bson.M{"phone": phones, "order_id": order.ID.Hex()}).Err() != nil {
+			continue
+		}
+		message.OrderID = order.ID.Hex()
+		message.OrderNumber = order.OrderNumber
+		message.MatchedBy = models.InboundMatchOrderNumber
+		break
+	}
+
+	if message.OrderID == "" {
+		latest := &Notification{}
+		err := notifications.FindOne(ctx,
+			bson.M{
+				"phone":      phones,
+				"order_id":   bson.M{"$ne": ""},
+				"created_at": bson.M{"$gte": message.ReceivedAt.Add(-replyWindow)},
+			},
+			options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+		).Decode(latest)
+		if err == nil {
+			message.OrderID = latest.OrderID
+			message.MatchedBy = models.InboundMatchLastNotification
+			if orderObjID, err := primitive.ObjectIDFromHex(latest.OrderID); err == nil {
+				order := &models.Order{}
+				if orders.FindOne(ctx, bson.M{"_id": orderObjID}).Decode(order) == nil {
+					message.OrderNumber = order.OrderNumber
+				}
+			}
+		}
+	}
+
+	if _, err := database.GetMongoCollection(InboundCollection).InsertOne(ctx, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// recordInbound saves a received message for HandleInbound, which must not fail on it
+func recordInbound(phone string, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := RecordInbound(ctx, phone, text); err != nil {
+		log.Printf("[Notification] Failed to record message from %s: %v", phone, err)
+	}
+}
@@ -0,0 +1,37 @@
+package notification
+
+import (
+	"strings"
+)
+
+// NotificationTypeInvite is used for user invites
+const NotificationTypeInvite NotificationType = "invite"
+
+const inviteTemplate = `Halo {name},
+
+Anda diundang untuk menggunakan {app}. Buat password Anda melalui link berikut:
+{link}
+
+Link berlaku sampai {expires}.
+
+Terima kasih.`
+
+// RenderInvite fills the invite message, used for both WhatsApp and email
+func RenderInvite(name, app, link, expires string) string {
+	return strings.NewReplacer(
+		"{name}", name,
+		"{app}", app,
+		"{link}", link,
+		"{expires}", expires,
+	).Replace(inviteTemplate)
+}
+
+// SendInviteNotification sends a user invite over WhatsApp. The link carries the invite
+// token, so it is never shortened.
+func SendInviteNotification(phone string, message string) (string, error) {
+	return dispatch(Notification{
+		Type:    NotificationTypeInvite,
+		Phone:   phone,
+		Message: message,
+	})
+}
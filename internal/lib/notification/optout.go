@@ -0,0 +1,137 @@
+package notification
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/utils"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OptOutsCollection holds the phones that opted out of automated messages
+const OptOutsCollection = "notification_optouts"
+
+// Inbound keywords, matched case-insensitively against the whole message
+var (
+	stopKeywords  = map[string]bool{"STOP": true, "BERHENTI": true, "UNSUBSCRIBE": true}
+	startKeywords = map[string]bool{"START": true, "MULAI": true}
+)
+
+// optOutKey normalizes a phone so every format of a number maps to one registry entry
+func optOutKey(phone string) string {
+	if normalized, err := utils.NormalizePhone(phone); err == nil {
+		return normalized
+	}
+	return strings.TrimSpace(phone)
+}
+
+// OptedOut reports whether the phone opted out of automated messages.
+// Lookup errors count as not opted out so a database hiccup does not block sending.
+func OptedOut(ctx context.Context, phone string) bool {
+	count, err := database.GetMongoCollection(OptOutsCollection).CountDocuments(ctx, bson.M{"phone": optOutKey(phone)})
+	return err == nil && count > 0
+}
+
+// OptOut adds the phone to the registry; an existing entry keeps its counters
+func OptOut(ctx context.Context, phone, source, reason, createdBy string) (*models.NotificationOptOut, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"source":     source,
+			"reason":     reason,
+			"created_by": createdBy,
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"suppressed": 0,
+			"created_at": now,
+		},
+	}
+
+	optOut := &models.NotificationOptOut{}
+	err := database.GetMongoCollection(OptOutsCollection).FindOneAndUpdate(ctx,
+		bson.M{"phone": optOutKey(phone)},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(optOut)
+	if err != nil {
+		return nil, err
+	}
+	return optOut, nil
+}
+
+// OptIn removes the phone from the registry; false when it was not opted out
+func OptIn(ctx context.Context, phone string) (bool, error) {
+	result, err := database.GetMongoCollection(OptOutsCollection).DeleteOne(ctx, bson.M{"phone": optOutKey(phone)})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// suppress saves a notification to an opted out phone without sending it, so the
+// notification log shows what was withheld, and counts it on the opt-out entry
+func suppress(notification Notification) error {
+	now := time.Now()
+	notification.Status = StatusSuppressed
+	notification.FallbackLink = ""
+	notification.CreatedAt = now
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := database.GetMongoCollection("notifications").InsertOne(ctx, notification); err != nil {
+		return err
+	}
+	database.GetMongoCollection(OptOutsCollection).UpdateOne(ctx,
+		bson.M{"phone": optOutKey(notification.Phone)},
+		bson.M{"$inc": bson.M{"suppressed": 1}, "$set": bson.M{"last_suppressed_at": now}},
+	)
+	log.Printf("[Notification] Suppressed %s message to opted out %s", notification.Type, notification.Phone)
+	return nil
+}
+
+// HandleInbound handles a direct message received on WhatsApp. Every message is recorded
+// for the conversation of its order. Replying STOP opts the sender out of automated
+// messages and START opts them back in; both are confirmed.
+func HandleInbound(phone string, text string) {
+	recordInbound(phone, text)
+
+	keyword := strings.ToUpper(strings.TrimSpace(text))
+	if !stopKeywords[keyword] && !startKeywords[keyword] {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var reply string
+	if stopKeywords[keyword] {
+		if _, err := OptOut(ctx, phone, models.OptOutSourceInbound, "Replied "+keyword, ""); err != nil {
+			log.Printf("[Notification] Failed to opt out %s: %v", phone, err)
+			return
+		}
+		reply = "Anda tidak akan menerima pesan otomatis lagi. Balas START untuk berlangganan kembali."
+	} else {
+		removed, err := OptIn(ctx, phone)
+		if err != nil {
+			log.Printf("[Notification] Failed to opt in %s: %v", phone, err)
+			return
+		}
+		if !removed {
+			return
+		}
+		reply = "Anda akan kembali menerima pesan otomatis. Balas STOP untuk berhenti."
+	}
+
+	log.Printf("[Notification] %s replied %s", phone, keyword)
+	sendViaWhatsApp(phone, reply)
+}
@@ -0,0 +1,35 @@
+package notification
+
+import (
+	"strings"
+)
+
+// NotificationTypeOTP is used for one-time verification codes
+const NotificationTypeOTP NotificationType = "otp"
+
+const otpTemplate = `Kode verifikasi {app} Anda: {code}
+
+Kode ini untuk {purpose} order {order} dan berlaku {minutes} menit. Jangan berikan kode ini kepada siapa pun.`
+
+// RenderOTP fills the verification code message
+func RenderOTP(app, code, purpose, orderNumber, minutes string) string {
+	return strings.NewReplacer(
+		"{app}", app,
+		"{code}", code,
+		"{purpose}", purpose,
+		"{order}", orderNumber,
+		"{minutes}", minutes,
+	).Replace(otpTemplate)
+}
+
+// SendOTPNotification sends a verification code over WhatsApp. The wa.me fallback link
+// contains the code, so callers must never show it to the requester.
+func SendOTPNotification(phone string, orderID string, message string) error {
+	_, err := dispatch(Notification{
+		Type:    NotificationTypeOTP,
+		Phone:   phone,
+		OrderID: orderID,
+		Message: message,
+	})
+	return err
+}
@@ -0,0 +1,234 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Overdue notification types
+const (
+	NotificationTypeOverdueReminder   NotificationType = "overdue_reminder"
+	NotificationTypeOverdueEscalation NotificationType = "overdue_escalation"
+)
+
+// OverduePlaceholders lists the placeholders an overdue template may use; {sales} is
+// only filled in the escalation template
+var OverduePlaceholders = []string{"{name}", "{order_number}", "{total}", "{due_date}", "{days}", "{link}", "{sales}"}
+
+// defaultOverduePolicy is used until a policy is saved
+var defaultOverduePolicy = models.OverduePolicy{
+	EveryDays:          3,
+	MaxReminders:       3,
+	Template:           "Halo {name},\n\nPembayaran order {order_number} sebesar Rp {total} telah jatuh tempo pada {due_date} ({days} hari yang lalu).\n\nMohon segera lakukan pembayaran melalui link berikut:\n{link}\n\nAbaikan pesan ini jika Anda sudah membayar.\n\nTerima kasih.",
+	EscalateAfterDays:  14,
+	EscalationTemplate: "Halo {name},\n\nOrder {order_number} dari sales {sales} sebesar Rp {total} belum dibayar {days} hari setelah jatuh tempo ({due_date}).\n\n{link}",
+}
+
+// LoadOverduePolicy returns the saved overdue policy, or the default one (disabled)
+func LoadOverduePolicy(ctx context.Context) *models.OverduePolicy {
+	policy := &models.OverduePolicy{}
+	if err := database.GetMongoCollection("overdue_policy").FindOne(ctx, bson.M{}).Decode(policy); err != nil {
+		*policy = defaultOverduePolicy
+	}
+	return policy
+}
+
+// overdueFilter matches the orders that are unpaid past their due date: not cancelled or
+// declined, and not yet verified as paid
+func overdueFilter(now time.Time) bson.M {
+	return bson.M{
+		"due_date":       bson.M{"$lt": now},
+		"status":         bson.M{"$nin": models.UnrealizedOrderStatuses},
+		"payment_status": bson.M{"$ne": models.PaymentStatusVerified},
+	}
+}
+
+// daysOverdue returns the whole days an order is past its due date
+func daysOverdue(order *models.Order, now time.Time) int {
+	return int(now.Sub(*order.DueDate).Hours() / 24)
+}
+
+// CheckOverdue flags the orders that went unpaid past their due date, publishing
+// order.overdue once per order, and clears the flag of orders since paid, cancelled or
+// given a later due date. When the overdue policy is enabled it then sends the overdue
+// reminders and escalations. It is registered as a cron job.
+func CheckOverdue() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	collection := database.GetMongoCollection("orders")
+	now := time.Now()
+
+	if _, err := collection.UpdateMany(ctx,
+		bson.M{"overdue": true, "$or": []bson.M{
+			{"status": bson.M{"$in": models.UnrealizedOrderStatuses}},
+			{"payment_status": models.PaymentStatusVerified},
+			{"due_date": bson.M{"$gte": now}},
+		}},
+		bson.M{"$unset": bson.M{"overdue": "", "overdue_since": ""}},
+	); err != nil {
+		log.Printf("[Notification] Failed to clear paid overdue orders: %v", err)
+	}
+
+	filter := overdueFilter(now)
+	filter["overdue"] = bson.M{"$ne": true}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("[Notification] Failed to load overdue orders: %v", err)
+		return
+	}
+	var orders []models.Order
+	err = cursor.All(ctx, &orders)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[Notification] Failed to load overdue orders: %v", err)
+		return
+	}
+
+	for _, order := range orders {
+		// Claim the order first so concurrent runs publish the event once
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": order.ID, "overdue": bson.M{"$ne": true}},
+			bson.M{"$set": bson.M{"overdue": true, "overdue_since": now}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+
+		events.Publish(ctx, events.OrderOverdue, "order", order.ID.Hex(), map[string]interface{}{
+			"order_number": order.OrderNumber,
+			"sales_id":     order.SalesID,
+			"total_price":  order.TotalPrice,
+			"due_date":     order.DueDate,
+		})
+	}
+
+	policy := LoadOverduePolicy(ctx)
+	if policy.Enabled {
+		sendOverdueReminders(ctx, policy, now)
+	}
+}
+
+// sendOverdueReminders sends the sales of every overdue order its next due reminder, and
+// tells the sales manager once about orders overdue past the escalation delay
+func sendOverdueReminders(ctx context.Context, policy *models.OverduePolicy, now time.Time) {
+	collection := database.GetMongoCollection("orders")
+	filter := overdueFilter(now)
+	filter["overdue"] = true
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("[Notification] Failed to load overdue orders: %v", err)
+		return
+	}
+	var orders []models.Order
+	err = cursor.All(ctx, &orders)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[Notification] Failed to load overdue orders: %v", err)
+		return
+	}
+
+	for i := range orders {
+		order := &orders[i]
+
+		sales := &models.Sales{}
+		if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+			database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
+		}
+
+		n := order.OverdueReminderCount
+		due := order.DueDate.AddDate(0, 0, n*policy.EveryDays)
+		if n < policy.MaxReminders && (n == 0 || policy.EveryDays > 0) && !now.Before(due) && sales.Phone != "" {
+			sendOverdueReminder(ctx, policy, order, sales, now)
+		}
+
+		if policy.EscalateAfterDays > 0 && policy.ManagerPhone != "" && order.OverdueEscalatedAt == nil &&
+			daysOverdue(order, now) >= policy.EscalateAfterDays {
+			escalateOverdue(ctx, policy, order, sales, now)
+		}
+	}
+}
+
+// overdueMessage fills an overdue template for an order
+func overdueMessage(template string, name string, order *models.Order, sales *models.Sales, now time.Time) string {
+	return strings.NewReplacer(
+		"{name}", name,
+		"{order_number}", order.OrderNumber,
+		"{total}", fmt.Sprintf("%.0f", order.TotalPrice),
+		"{due_date}", order.DueDate.In(time.Local).Format("02/01/2006"),
+		"{days}", fmt.Sprintf("%d", daysOverdue(order, now)),
+		"{link}", orderLink(order),
+		"{sales}", sales.Name,
+	).Replace(template)
+}
+
+// sendOverdueReminder sends the next overdue reminder of an order to its sales
+func sendOverdueReminder(ctx context.Context, policy *models.OverduePolicy, order *models.Order, sales *models.Sales, now time.Time) {
+	n := order.OverdueReminderCount
+
+	// Claim the reminder first so overlapping runs do not send it twice
+	reminder := models.PaymentReminder{
+		Number:         n + 1,
+		Phone:          sales.Phone,
+		NotificationID: primitive.NewObjectID().Hex(),
+		SentAt:         now,
+	}
+	claim := bson.M{"_id": order.ID, "overdue": true, "overdue_reminder_count": n}
+	if n == 0 {
+		claim["overdue_reminder_count"] = bson.M{"$in": []interface{}{nil, 0}}
+	}
+	result, err := database.GetMongoCollection("orders").UpdateOne(ctx, claim, bson.M{
+		"$set":  bson.M{"overdue_reminder_count": n + 1},
+		"$push": bson.M{"overdue_reminders": reminder},
+	})
+	if err != nil || result.ModifiedCount == 0 {
+		return
+	}
+
+	notificationID, _ := primitive.ObjectIDFromHex(reminder.NotificationID)
+	if _, err := dispatch(Notification{
+		ID:      notificationID,
+		Type:    NotificationTypeOverdueReminder,
+		Phone:   sales.Phone,
+		Message: overdueMessage(policy.Template, sales.Name, order, sales, now),
+		Link:    orderLink(order),
+		OrderID: order.ID.Hex(),
+	}); err != nil {
+		log.Printf("[Notification] Failed to record overdue reminder for %s: %v", order.OrderNumber, err)
+		return
+	}
+	log.Printf("[Notification] Sent overdue reminder %d for order %s", reminder.Number, order.OrderNumber)
+}
+
+// escalateOverdue tells the sales manager about an order long past its due date
+func escalateOverdue(ctx context.Context, policy *models.OverduePolicy, order *models.Order, sales *models.Sales, now time.Time) {
+	result, err := database.GetMongoCollection("orders").UpdateOne(ctx,
+		bson.M{"_id": order.ID, "overdue_escalated_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"overdue_escalated_at": now}},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		return
+	}
+
+	if _, err := dispatch(Notification{
+		Type:    NotificationTypeOverdueEscalation,
+		Phone:   policy.ManagerPhone,
+		Message: overdueMessage(policy.EscalationTemplate, policy.ManagerName, order, sales, now),
+		Link:    orderLink(order),
+		OrderID: order.ID.Hex(),
+	}); err != nil {
+		log.Printf("[Notification] Failed to record overdue escalation for %s: %v", order.OrderNumber, err)
+		return
+	}
+	log.Printf("[Notification] Escalated overdue order %s to the sales manager", order.OrderNumber)
+}
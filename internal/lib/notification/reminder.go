@@ -0,0 +1,164 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotificationTypePaymentReminder is used for reminders of unpaid invoices
+const NotificationTypePaymentReminder NotificationType = "payment_reminder"
+
+// ReminderPlaceholders lists the placeholders a reminder template may use
+var ReminderPlaceholders = []string{"{name}", "{order_number}", "{total}", "{hours}", "{link}"}
+
+// defaultReminderPolicy is used until a policy is saved
+var defaultReminderPolicy = models.ReminderPolicy{
+	Steps: []models.ReminderStep{
+		{
+			AfterHours: 4,
+			Template:   "Halo {name},\n\nPengingat: pembayaran order {order_number} sebesar Rp {total} belum kami terima.\n\nSilakan lakukan pembayaran melalui link berikut:\n{link}\n\nTerima kasih.",
+		},
+		{
+			AfterHours: 24,
+			Template:   "Halo {name},\n\nPembayaran order {order_number} sebesar Rp {total} masih menunggu sejak {hours} jam yang lalu. Mohon segera selesaikan pembayaran agar order dapat diproses:\n{link}\n\nAbaikan pesan ini jika Anda sudah membayar.\n\nTerima kasih.",
+		},
+	},
+	MaxReminders: 2,
+}
+
+// LoadReminderPolicy returns the saved reminder policy, or the default one (disabled)
+func LoadReminderPolicy(ctx context.Context) *models.ReminderPolicy {
+	policy := &models.ReminderPolicy{}
+	if err := database.GetMongoCollection("reminder_policy").FindOne(ctx, bson.M{}).Decode(policy); err != nil {
+		*policy = defaultReminderPolicy
+	}
+	if policy.Steps == nil {
+		policy.Steps = []models.ReminderStep{}
+	}
+	return policy
+}
+
+// reminderOffset returns how long after order creation reminder n (0-based) is due;
+// false means the policy sends no such reminder
+func reminderOffset(policy *models.ReminderPolicy, n int) (time.Duration, bool) {
+	if n >= policy.MaxReminders || len(policy.Steps) == 0 {
+		return 0, false
+	}
+	if n < len(policy.Steps) {
+		return time.Duration(policy.Steps[n].AfterHours) * time.Hour, true
+	}
+	if policy.RepeatHours <= 0 {
+		return 0, false
+	}
+	last := policy.Steps[len(policy.Steps)-1].AfterHours
+	repeats := n - len(policy.Steps) + 1
+	return time.Duration(last+repeats*policy.RepeatHours) * time.Hour, true
+}
+
+// SendPaymentReminders re-sends the invoice link of every order still waiting for
+// payment whose next reminder is due. Paid or cancelled orders leave the pending status
+// and so stop receiving reminders. It is registered as a cron job.
+func SendPaymentReminders() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	policy := LoadReminderPolicy(ctx)
+	first, ok := reminderOffset(policy, 0)
+	if !policy.Enabled || !ok {
+		return
+	}
+
+	collection := database.GetMongoCollection("orders")
+	now := time.Now()
+	cursor, err := collection.Find(ctx, bson.M{
+		"status":     models.OrderStatusPending,
+		"created_at": bson.M{"$lte": now.Add(-first)},
+		"$or": []bson.M{
+			{"payment_reminder_count": bson.M{"$exists": false}},
+			{"payment_reminder_count": bson.M{"$lt": policy.MaxReminders}},
+		},
+	})
+	if err != nil {
+		log.Printf("[Notification] Failed to load unpaid orders: %v", err)
+		return
+	}
+	var orders []models.Order
+	err = cursor.All(ctx, &orders)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[Notification] Failed to decode unpaid orders: %v", err)
+		return
+	}
+
+	for i := range orders {
+		order := &orders[i]
+		n := order.PaymentReminderCount
+		offset, ok := reminderOffset(policy, n)
+		if !ok || now.Before(order.CreatedAt.Add(offset)) {
+			continue
+		}
+
+		sales := &models.Sales{}
+		if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+			database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
+		}
+		if sales.Phone == "" {
+			continue
+		}
+
+		// Claim the reminder first so overlapping runs do not send it twice
+		reminder := models.PaymentReminder{
+			Number:         n + 1,
+			Phone:          sales.Phone,
+			NotificationID: primitive.NewObjectID().Hex(),
+			SentAt:         now,
+		}
+		claim := bson.M{"_id": order.ID, "status": models.OrderStatusPending, "payment_reminder_count": order.PaymentReminderCount}
+		if n == 0 {
+			claim["payment_reminder_count"] = bson.M{"$in": []interface{}{nil, 0}}
+		}
+		result, err := collection.UpdateOne(ctx, claim, bson.M{
+			"$set":  bson.M{"payment_reminder_count": n + 1},
+			"$push": bson.M{"payment_reminders": reminder},
+		})
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+
+		step := policy.Steps[len(policy.Steps)-1]
+		if n < len(policy.Steps) {
+			step = policy.Steps[n]
+		}
+		link := orderLink(order)
+		message := strings.NewReplacer(
+			"{name}", sales.Name,
+			"{order_number}", order.OrderNumber,
+			"{total}", fmt.Sprintf("%.0f", order.TotalPrice),
+			"{hours}", fmt.Sprintf("%.0f", now.Sub(order.CreatedAt).Hours()),
+			"{link}", link,
+		).Replace(step.Template)
+
+		notificationID, _ := primitive.ObjectIDFromHex(reminder.NotificationID)
+		if _, err := dispatch(Notification{
+			ID:      notificationID,
+			Type:    NotificationTypePaymentReminder,
+			Phone:   sales.Phone,
+			Message: message,
+			Link:    link,
+			OrderID: order.ID.Hex(),
+		}); err != nil {
+			log.Printf("[Notification] Failed to record payment reminder for %s: %v", order.OrderNumber, err)
+			continue
+		}
+		log.Printf("[Notification] Sent payment reminder %d for order %s", reminder.Number, order.OrderNumber)
+	}
+}
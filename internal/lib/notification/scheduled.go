@@ -0,0 +1,75 @@
+package notification
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotificationTypeScheduled is used for messages scheduled through the API
+const NotificationTypeScheduled NotificationType = "scheduled"
+
+// SendScheduled hands every scheduled message that is due to the notification queue.
+// A message is claimed before sending and its notification reuses the message ID, so a
+// run that dies halfway is finished by the next one without sending twice. It is
+// registered as a cron job.
+func SendScheduled() {
+	collection := database.GetMongoCollection("scheduled_messages")
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := collection.Find(ctx,
+		bson.M{
+			"status":  bson.M{"$in": []string{models.ScheduledStatusScheduled, models.ScheduledStatusSending}},
+			"send_at": bson.M{"$lte": now},
+		},
+		options.Find().SetSort(bson.D{{Key: "send_at", Value: 1}}).SetLimit(100),
+	)
+	if err != nil {
+		log.Printf("[Notification] Failed to load scheduled messages: %v", err)
+		return
+	}
+	var due []models.ScheduledMessage
+	err = cursor.All(ctx, &due)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[Notification] Failed to decode scheduled messages: %v", err)
+		return
+	}
+
+	for _, message := range due {
+		if message.Status == models.ScheduledStatusScheduled {
+			claim, err := collection.UpdateOne(ctx,
+				bson.M{"_id": message.ID, "status": models.ScheduledStatusScheduled},
+				bson.M{"$set": bson.M{"status": models.ScheduledStatusSending, "updated_at": now}},
+			)
+			if err != nil || claim.ModifiedCount == 0 {
+				continue // Cancelled or claimed by another run meanwhile
+			}
+		}
+
+		if _, err := Deliver(Notification{
+			ID:      message.ID,
+			Type:    NotificationTypeScheduled,
+			Phone:   message.Phone,
+			Message: message.Message,
+		}); err != nil {
+			log.Printf("[Notification] Failed to send scheduled message %s: %v", message.ID.Hex(), err)
+			continue
+		}
+
+		sentAt := time.Now()
+		collection.UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{"$set": bson.M{
+			"status":     models.ScheduledStatusSent,
+			"sent_at":    sentAt,
+			"updated_at": sentAt,
+		}})
+	}
+}
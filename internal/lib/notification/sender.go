@@ -0,0 +1,35 @@
+package notification
+
+import "bg-go/internal/lib/whatsapp"
+
+// Sender delivers WhatsApp messages. The configured providers are used unless another
+// sender is set, which tests use to capture messages.
+type Sender interface {
+	Name() string
+	IsLoggedIn() bool
+	SendMessage(phone string, message string) error
+}
+
+// sender replaces the providers when set
+var sender Sender
+
+// SetSender replaces the WhatsApp sender, nil restores the providers
+func SetSender(s Sender) {
+	sender = s
+}
+
+// activeSender returns the sender to deliver through, or nil when none is logged in
+func activeSender() Sender {
+	if sender != nil {
+		if sender.IsLoggedIn() {
+			return sender
+		}
+		return nil
+	}
+	// Checked separately, a nil Provider would make a non-nil Sender
+	active := whatsapp.Active()
+	if active == nil {
+		return nil
+	}
+	return active
+}
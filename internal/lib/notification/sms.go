@@ -0,0 +1,47 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/sms"
+	"bg-go/internal/lib/whatsapp"
+)
+
+// SentViaSMS is the sent_via of notifications delivered by the SMS fallback
+const SentViaSMS = "sms"
+
+// smsFallback sends a notification by SMS when WhatsApp cannot deliver it: the
+// recipient has no WhatsApp, or the WhatsApp retries are exhausted. Notification
+// types without the fallback (SMS_FALLBACK_TYPES) keep the WhatsApp outcome.
+// It reports whether the SMS was sent; the outcome is recorded on the notification.
+func smsFallback(notification *Notification, waErr error, now time.Time) bool {
+	if waErr == nil || !sms.FallbackFor(string(notification.Type)) {
+		return false
+	}
+	if !errors.Is(waErr, whatsapp.ErrNoWhatsApp) && notification.Status != StatusFailed {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Cfg.SMS.Timeout+5*time.Second)
+	defer cancel()
+	result, err := sms.Send(ctx, notification.Phone, notification.Message)
+	if err != nil {
+		log.Printf("[Notification] SMS fallback to %s failed: %v", notification.Phone, err)
+		notification.SMSError = err.Error()
+		return false
+	}
+
+	log.Printf("[Notification] Message sent via SMS (%s) to %s", result.Gateway, notification.Phone)
+	notification.SMS = result
+	notification.SMSError = ""
+	notification.Status = StatusSent
+	notification.SentVia = SentViaSMS
+	notification.SentAt = &now
+	notification.NextAttemptAt = nil
+	notification.FailedAt = nil
+	return true
+}
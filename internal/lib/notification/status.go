@@ -0,0 +1,218 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotificationTypeStatus is used for automatic status transition messages
+const NotificationTypeStatus NotificationType = "status"
+
+// StatusLabels are the customer-facing names of order statuses
+var StatusLabels = map[string]string{
+	models.OrderStatusPending:   "Menunggu Pembayaran",
+	models.OrderStatusPaid:      "Pembayaran Diunggah",
+	models.OrderStatusConfirmed: "Pembayaran Terverifikasi",
+	models.OrderStatusQueued:    "Dalam Antrian",
+	models.OrderStatusLoading:   "Proses Muat",
+	models.OrderStatusCompleted: "Selesai",
+	models.OrderStatusCancelled: "Dibatalkan",
+	models.OrderStatusDeclined:  "Ditolak",
+}
+
+// TemplatePlaceholders lists the placeholders available in status templates
+var TemplatePlaceholders = []string{
+	"{name}", "{order_number}", "{status}", "{queue_number}",
+	"{driver_name}", "{vehicle_plate}", "{bay}", "{qr_reference}", "{link}",
+}
+
+// defaultStatusRules are used for statuses without a saved rule.
+// Invoice (pending) and delivery note (completed) messages are sent separately,
+// so only the driver call is enabled out of the box.
+var defaultStatusRules = map[string]models.StatusNotificationRule{
+	models.OrderStatusPaid: {
+		Template:   "Halo {name},\n\nBukti pembayaran untuk order {order_number} telah kami terima dan sedang diverifikasi.\n\nTerima kasih.",
+		Recipients: []string{models.RecipientSales},
+	},
+	models.OrderStatusConfirmed: {
+		Template:   "Halo {name},\n\nPembayaran order {order_number} telah diverifikasi. Silakan isi data driver melalui link berikut:\n{link}\n\nTerima kasih.",
+		Recipients: []string{models.RecipientSales},
+	},
+	models.OrderStatusQueued: {
+		Template:   "Halo {name},\n\nOrder {order_number} telah masuk antrian dengan nomor #{queue_number}.\n\nPantau antrian melalui link:\n{link}\n\nTerima kasih.",
+		Recipients: []string{models.RecipientSales, models.RecipientDriver},
+	},
+	models.OrderStatusLoading: {
+		Enabled:    true,
+		Template:   "Halo {name},\n\nAnda dipanggil ke loading bay {bay}.\n\nNo. Antrian: #{queue_number}\nNo. Polisi: {vehicle_plate}\nNo. Order: {order_number}\nRef QR: {qr_reference}\n\nTunjukkan QR antrian Anda kepada petugas.\nTerima kasih.",
+		Recipients: []string{models.RecipientDriver},
+	},
+	models.OrderStatusCompleted: {
+		Template:   "Halo {name},\n\nOrder {order_number} telah selesai dimuat.\n\nTerima kasih.",
+		Recipients: []string{models.RecipientSales},
+	},
+	models.OrderStatusCancelled: {
+		Template:   "Halo {name},\n\nOrder {order_number} telah dibatalkan.\n\nTerima kasih.",
+		Recipients: []string{models.RecipientSales},
+	},
+	models.OrderStatusDeclined: {
+		Template:   "Halo {name},\n\nPenolakan order {order_number} telah kami terima.\n\nTerima kasih.",
+		Recipients: []string{models.RecipientSales},
+	},
+}
+
+// StatusRules returns the notification rule of every status, saved or default
+func StatusRules() ([]models.StatusNotificationRule, error) {
+	collection := database.GetMongoCollection("status_notifications")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var saved []models.StatusNotificationRule
+	if err := cursor.All(ctx, &saved); err != nil {
+		return nil, err
+	}
+
+	byStatus := map[string]models.StatusNotificationRule{}
+	for _, rule := range saved {
+		byStatus[rule.Status] = rule
+	}
+
+	rules := []models.StatusNotificationRule{}
+	for _, status := range models.OrderStatuses {
+		rule, ok := byStatus[status]
+		if !ok {
+			rule = defaultStatusRules[status]
+			rule.Status = status
+		}
+		if rule.Recipients == nil {
+			rule.Recipients = []string{}
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// StatusRule returns the rule for a single status, falling back to the default
+func StatusRule(ctx context.Context, status string) models.StatusNotificationRule {
+	rule := models.StatusNotificationRule{}
+	err := database.GetMongoCollection("status_notifications").FindOne(ctx, bson.M{"status": status}).Decode(&rule)
+	if err != nil {
+		rule = defaultStatusRules[status]
+		rule.Status = status
+	}
+	return rule
+}
+
+// NotifyStatusChange sends the configured messages for an order that entered status.
+// It reloads the order so the message reflects the fields set by the transition,
+// and returns the wa.me fallback link of each recipient that was notified.
+func NotifyStatusChange(orderID primitive.ObjectID, status string) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	links := map[string]string{}
+
+	rule := StatusRule(ctx, status)
+	if !rule.Enabled || rule.Template == "" || len(rule.Recipients) == 0 {
+		return links
+	}
+
+	order := &models.Order{}
+	if err := database.GetMongoCollection("orders").FindOne(ctx, bson.M{"_id": orderID}).Decode(order); err != nil {
+		log.Printf("[Notification] Status %s: order %s not found", status, orderID.Hex())
+		return links
+	}
+
+	sales := &models.Sales{}
+	if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+		database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
+	}
+
+	for _, recipient := range rule.Recipients {
+		name, phone := recipientContact(recipient, order, sales)
+		if phone == "" {
+			continue
+		}
+
+		message := RenderStatusTemplate(rule.Template, order, name)
+		link, err := dispatch(Notification{
+			Type:    NotificationTypeStatus,
+			Phone:   phone,
+			Message: message,
+			Link:    orderLink(order),
+			OrderID: order.ID.Hex(),
+		})
+		if err != nil {
+			log.Printf("[Notification] Failed to record %s notification for %s: %v", status, recipient, err)
+			continue
+		}
+		links[recipient] = link
+	}
+
+	return links
+}
+
+// recipientContact resolves a recipient kind to a name and phone number
+func recipientContact(recipient string, order *models.Order, sales *models.Sales) (string, string) {
+	switch recipient {
+	case models.RecipientSales:
+		return sales.Name, sales.Phone
+	case models.RecipientCustomer:
+		return order.CustomerName, order.CustomerPhone
+	case models.RecipientDriver:
+		return order.DriverName, order.DriverPhone
+	}
+	return "", ""
+}
+
+// RenderStatusTemplate fills the placeholders of a status template
+func RenderStatusTemplate(template string, order *models.Order, recipientName string) string {
+	queueNumber := ""
+	if order.QueueNumber > 0 {
+		queueNumber = fmt.Sprintf("%d", order.QueueNumber)
+	}
+
+	qrReference := order.QueueToken
+	if len(qrReference) > 8 {
+		qrReference = qrReference[:8]
+	}
+
+	bay := order.LoadingBay
+	if bay == "" {
+		bay = "-"
+	}
+
+	replacer := strings.NewReplacer(
+		"{name}", recipientName,
+		"{order_number}", order.OrderNumber,
+		"{status}", StatusLabels[order.Status],
+		"{queue_number}", queueNumber,
+		"{driver_name}", order.DriverName,
+		"{vehicle_plate}", order.VehiclePlate,
+		"{bay}", bay,
+		"{qr_reference}", strings.ToUpper(qrReference),
+		"{link}", orderLink(order),
+	)
+	return replacer.Replace(template)
+}
+
+// orderLink returns the client page of an order
+func orderLink(order *models.Order) string {
+	return clientLink("order", order.InvoiceToken)
+}
@@ -0,0 +1,39 @@
+package notification
+
+import (
+	"strings"
+)
+
+// NotificationTypeTargetSummary is the monthly summary of a sales rep's targets
+const NotificationTypeTargetSummary NotificationType = "target_summary"
+
+const targetSummaryTemplate = `Halo {name},
+
+Ringkasan pencapaian target Anda bulan {month}:
+
+Omzet: Rp {revenue} dari target Rp {revenue_target} ({revenue_percent}%)
+Volume: {volume} dari target {volume_target} ({volume_percent}%)
+Order selesai: {orders}
+Peringkat: {rank} dari {ranked}
+
+Terima kasih atas kerja keras Anda!`
+
+// RenderTargetSummary fills the monthly target summary; values are keyed by placeholder
+// name without braces
+func RenderTargetSummary(values map[string]string) string {
+	pairs := make([]string, 0, len(values)*2)
+	for key, value := range values {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(targetSummaryTemplate)
+}
+
+// SendTargetSummary sends a monthly target summary to a sales rep over WhatsApp
+func SendTargetSummary(phone string, message string) error {
+	_, err := dispatch(Notification{
+		Type:    NotificationTypeTargetSummary,
+		Phone:   phone,
+		Message: message,
+	})
+	return err
+}
@@ -0,0 +1,158 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/queuetime"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotificationTypeQueueWait is used for revised ETA messages of long queue waits
+const NotificationTypeQueueWait NotificationType = "queue_wait"
+
+const waitTemplate = `Halo {name},
+
+Mohon maaf, antrian order {order_number} (No. Antrian #{queue_number}) lebih lama dari perkiraan.
+
+Masih ada {ahead} antrian di depan Anda.
+Perkiraan dipanggil: pukul {eta} (sekitar {remaining} lagi)
+
+Pantau antrian melalui link:
+{link}
+
+Terima kasih.`
+
+// NotifyLongWaits sends the driver and sales of every queued order whose expected
+// total wait exceeds the configured threshold a revised ETA, at most once per
+// WaitInterval per order. It is registered as a cron job.
+func NotifyLongWaits() {
+	cfg := config.Cfg.Notification
+	if cfg.WaitThreshold <= 0 {
+		return
+	}
+
+	collection := database.GetMongoCollection("orders")
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	// The queue is short, so load today's once and count the orders ahead in memory.
+	// Orders left over from an earlier day are neither notified nor counted ahead.
+	now := time.Now()
+	dayStart, dayEnd := queuetime.DayBounds(now)
+	cursor, err := collection.Find(ctx,
+		bson.M{
+			"status":           bson.M{"$in": []string{models.OrderStatusQueued, models.OrderStatusLoading}},
+			"queue_entered_at": bson.M{"$gte": dayStart, "$lt": dayEnd},
+		},
+		options.Find().SetSort(bson.D{{Key: "queue_number", Value: 1}}),
+	)
+	if err != nil {
+		log.Printf("[Notification] Failed to load queue for wait check: %v", err)
+		return
+	}
+	var queue []models.Order
+	err = cursor.All(ctx, &queue)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[Notification] Failed to load queue for wait check: %v", err)
+		return
+	}
+
+	durations := queuetime.Load(ctx)
+	for i := range queue {
+		order := &queue[i]
+		if order.Status != models.OrderStatusQueued || order.QueueEnteredAt == nil {
+			continue
+		}
+		if order.WaitNotifiedAt != nil && now.Sub(*order.WaitNotifiedAt) < cfg.WaitInterval {
+			continue
+		}
+
+		ahead := 0
+		minutes := 0
+		for j := range queue {
+			if queue[j].QueueNumber < order.QueueNumber {
+				ahead++
+				minutes += durations.Minutes(&queue[j])
+			}
+		}
+		remaining := time.Duration(minutes) * time.Minute
+		eta := now.Add(remaining)
+		if eta.Sub(*order.QueueEnteredAt) <= cfg.WaitThreshold {
+			continue
+		}
+
+		// Claim the order first so overlapping runs do not send twice
+		claim := bson.M{"_id": order.ID, "wait_notified_at": order.WaitNotifiedAt}
+		update := bson.M{"$set": bson.M{"wait_notified_at": now, "estimated_time": eta.Format("15:04")}}
+		result, err := collection.UpdateOne(ctx, claim, update)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+		order.EstimatedTime = eta.Format("15:04")
+
+		sent := sendWaitUpdate(ctx, order, ahead, remaining)
+		log.Printf("[Notification] Order %s waits past %s, sent revised ETA %s to %d recipient(s)", order.OrderNumber, cfg.WaitThreshold, order.EstimatedTime, sent)
+	}
+}
+
+// sendWaitUpdate messages the driver and sales of an order and returns how many were sent
+func sendWaitUpdate(ctx context.Context, order *models.Order, ahead int, remaining time.Duration) int {
+	sales := &models.Sales{}
+	if salesObjID, err := primitive.ObjectIDFromHex(order.SalesID); err == nil {
+		database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
+	}
+
+	sent := 0
+	for _, recipient := range []string{models.RecipientDriver, models.RecipientSales} {
+		name, phone := recipientContact(recipient, order, sales)
+		if phone == "" {
+			continue
+		}
+
+		message := strings.NewReplacer(
+			"{name}", name,
+			"{order_number}", order.OrderNumber,
+			"{queue_number}", fmt.Sprintf("%d", order.QueueNumber),
+			"{ahead}", fmt.Sprintf("%d", ahead),
+			"{eta}", order.EstimatedTime,
+			"{remaining}", formatWait(remaining),
+			"{link}", orderLink(order),
+		).Replace(waitTemplate)
+
+		if _, err := dispatch(Notification{
+			Type:    NotificationTypeQueueWait,
+			Phone:   phone,
+			Message: message,
+			Link:    orderLink(order),
+			OrderID: order.ID.Hex(),
+		}); err != nil {
+			log.Printf("[Notification] Failed to record wait update for %s: %v", recipient, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// formatWait formats a duration as hours and minutes in Indonesian
+func formatWait(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes < 60 {
+		return fmt.Sprintf("%d menit", minutes)
+	}
+	if minutes%60 == 0 {
+		return fmt.Sprintf("%d jam", minutes/60)
+	}
+	return fmt.Sprintf("%d jam %d menit", minutes/60, minutes%60)
+}
@@ -8,6 +8,9 @@ import (
 	"time"
 
 	"bg-go/internal/database"
+	"bg-go/internal/lib/sandbox"
+	"bg-go/internal/lib/shortlink"
+	"bg-go/internal/lib/sms"
 	"bg-go/internal/lib/whatsapp"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -21,19 +24,40 @@ const (
 	NotificationTypeInvoice  NotificationType = "invoice"
 	NotificationTypeDelivery NotificationType = "delivery"
 	NotificationTypeQueue    NotificationType = "queue"
+	NotificationTypeReturn   NotificationType = "return"
+	NotificationTypeReport   NotificationType = "report"
+)
+
+// Notification status values
+const (
+	StatusPending    = "pending"    // Waiting for (re)delivery
+	StatusSent       = "sent"       // Delivered via WhatsApp or handed out as a wa.me link
+	StatusFailed     = "failed"     // Dead-lettered after exhausting retries
+	StatusSuppressed = "suppressed" // Not sent, the phone opted out of automated messages
 )
 
 // Notification represents a notification record
 type Notification struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Type      NotificationType   `json:"type" bson:"type"`
-	Phone     string             `json:"phone" bson:"phone"`
-	Message   string             `json:"message" bson:"message"`
-	Link      string             `json:"link" bson:"link"`
-	OrderID   string             `json:"order_id" bson:"order_id"`
-	Status    string             `json:"status" bson:"status"` // pending, sent, failed
-	SentAt    *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
-	SentVia   string             `json:"sent_via,omitempty" bson:"sent_via,omitempty"` // "whatsapp" or "wa.me"
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type          NotificationType   `json:"type" bson:"type"`
+	Phone         string             `json:"phone" bson:"phone"`
+	Message       string             `json:"message" bson:"message"`
+	Link          string             `json:"link" bson:"link"`
+	OrderID       string             `json:"order_id" bson:"order_id"`
+	BroadcastID   string             `json:"broadcast_id,omitempty" bson:"broadcast_id,omitempty"`
+	Status        string             `json:"status" bson:"status"` // pending, sent, failed
+	SentAt        *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+	SentVia       string             `json:"sent_via,omitempty" bson:"sent_via,omitempty"`           // "whatsapp", "sms", "wa.me" or "sandbox"
+	Provider      string             `json:"provider,omitempty" bson:"provider,omitempty"`           // WhatsApp provider that sent it: "whatsmeow" or "cloud"
+	SMS           *sms.Result        `json:"sms,omitempty" bson:"sms,omitempty"`                     // SMS fallback, with its cost
+	SMSError      string             `json:"sms_error,omitempty" bson:"sms_error,omitempty"`         // Why the SMS fallback failed
+	FallbackLink  string             `json:"fallback_link,omitempty" bson:"fallback_link,omitempty"` // wa.me link for manual sending
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	LastError     string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	LastAttemptAt *time.Time         `json:"last_attempt_at,omitempty" bson:"last_attempt_at,omitempty"`
+	NextAttemptAt *time.Time         `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	FailedAt      *time.Time         `json:"failed_at,omitempty" bson:"failed_at,omitempty"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
 }
 
 // WhatsAppConfig holds WhatsApp configuration
@@ -49,6 +73,7 @@ func Init(clientURL string) {
 	Config = WhatsAppConfig{
 		ClientURL: clientURL,
 	}
+	whatsapp.MessageHandler = HandleInbound
 }
 
 // GenerateWhatsAppLink generates a wa.me link with pre-filled message
@@ -67,30 +92,102 @@ func GenerateWhatsAppLink(phone string, message string) string {
 	return fmt.Sprintf("https://wa.me/%s?text=%s", cleanPhone, encodedMessage)
 }
 
-// sendViaWhatsApp tries to send via whatsmeow if connected
-// Returns true if sent, false if fallback to wa.me link needed
-func sendViaWhatsApp(phone string, message string) bool {
-	if whatsapp.WhatsApp == nil {
-		return false
+// clientLink returns the link of a client page for messages, shortened when short
+// links are enabled
+func clientLink(page string, token string) string {
+	return shortlink.Link(fmt.Sprintf("%s/%s/%s", Config.ClientURL, page, token))
+}
+
+// sendViaWhatsApp tries to send via the first WhatsApp provider able to.
+// Returns false with a nil error when none is (fallback to wa.me link),
+// or false with the send error when WhatsApp rejected the message.
+// provider names the provider that sent it, empty for sandboxed messages.
+func sendViaWhatsApp(phone string, message string) (sent bool, provider string, err error) {
+	// Sandboxed messages count as sent, whether or not a session is logged in
+	if sandbox.Intercepts(phone) {
+		return sandbox.Capture(sandbox.ChannelWhatsApp, phone, "", message) == nil, "", nil
 	}
 
-	if !whatsapp.WhatsApp.IsLoggedIn() {
-		return false
+	client := activeSender()
+	if client == nil {
+		return false, "", nil
 	}
 
-	err := whatsapp.WhatsApp.SendMessage(phone, message)
+	err = client.SendMessage(phone, message)
 	if err != nil {
-		log.Printf("[Notification] Failed to send via WhatsApp: %v", err)
-		return false
+		log.Printf("[Notification] Failed to send via WhatsApp (%s): %v", client.Name(), err)
+		return false, "", err
 	}
 
-	log.Printf("[Notification] Message sent via WhatsApp to %s", phone)
-	return true
+	log.Printf("[Notification] Message sent via WhatsApp (%s) to %s", client.Name(), phone)
+	return true, client.Name(), nil
 }
 
-// SendInvoiceNotification creates invoice notification and sends via WhatsApp if connected
-func SendInvoiceNotification(phone string, salesName string, orderNumber string, productName string, quantity int, unit string, totalPrice float64, invoiceToken string) (string, error) {
-	invoiceURL := fmt.Sprintf("%s/order/%s", Config.ClientURL, invoiceToken)
+// sentVia is the channel recorded for a message that sendViaWhatsApp reported as sent
+func sentVia(phone string) string {
+	if sandbox.Intercepts(phone) {
+		return "sandbox"
+	}
+	return "whatsapp"
+}
+
+// dispatch sends a notification via WhatsApp if connected and saves its record.
+// Failed sends are kept pending for retry; the wa.me link is always returned as fallback.
+// A preset ID is kept so callers (such as the outbox) can detect replays.
+// Notifications to opted out phones are saved as suppressed and return no link.
+// The message gets the sender branding of the company settings.
+func dispatch(notification Notification) (string, error) {
+	now := time.Now()
+	if notification.ID.IsZero() {
+		notification.ID = primitive.NewObjectID()
+	}
+
+	optCtx, optCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	optedOut := OptedOut(optCtx, notification.Phone)
+	optCancel()
+	if optedOut {
+		return "", suppress(notification)
+	}
+	notification.Message = brand(notification.Message)
+	notification.CreatedAt = now
+	notification.Status = StatusSent
+	notification.SentVia = "wa.me"
+	notification.FallbackLink = GenerateWhatsAppLink(notification.Phone, notification.Message)
+
+	sent, provider, err := sendViaWhatsApp(notification.Phone, notification.Message)
+	if sent {
+		notification.SentVia = sentVia(notification.Phone)
+		notification.Provider = provider
+		notification.SentAt = &now
+	}
+	if sent || err != nil {
+		notification.Attempts = 1
+		notification.LastAttemptAt = &now
+	}
+	if err != nil {
+		notification.LastError = err.Error()
+		scheduleRetry(&notification, now)
+		smsFallback(&notification, err, now)
+	}
+
+	collection := database.GetMongoCollection("notifications")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, notification); err != nil {
+		return "", err
+	}
+
+	if notification.Status == StatusFailed {
+		go checkDeadLetters()
+	}
+
+	return notification.FallbackLink, nil
+}
+
+// InvoiceNotification builds the invoice notification without sending it
+func InvoiceNotification(phone string, salesName string, orderNumber string, productName string, quantity int, unit string, totalPrice float64, invoiceToken string) Notification {
+	invoiceURL := clientLink("order", invoiceToken)
 
 	message := fmt.Sprintf(`Halo %s,
 
@@ -107,44 +204,22 @@ Silakan akses link berikut untuk melakukan pembayaran:
 Terima kasih.`,
 		salesName, orderNumber, productName, quantity, unit, totalPrice, invoiceURL)
 
-	// Try to send via WhatsApp if connected
-	sentVia := "wa.me"
-	if sendViaWhatsApp(phone, message) {
-		sentVia = "whatsapp"
-	}
-
-	// Save notification record
-	now := time.Now()
-	notification := Notification{
-		ID:      primitive.NewObjectID(),
+	return Notification{
 		Type:    NotificationTypeInvoice,
 		Phone:   phone,
 		Message: message,
 		Link:    invoiceURL,
-		Status:  "sent",
-		SentVia: sentVia,
-	}
-	
-	if sentVia == "whatsapp" {
-		notification.SentAt = &now
-	}
-
-	collection := database.GetMongoCollection("notifications")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := collection.InsertOne(ctx, notification)
-	if err != nil {
-		return "", err
 	}
+}
 
-	// Always return wa.me link as fallback
-	return GenerateWhatsAppLink(phone, message), nil
+// SendInvoiceNotification creates invoice notification and sends via WhatsApp if connected
+func SendInvoiceNotification(phone string, salesName string, orderNumber string, productName string, quantity int, unit string, totalPrice float64, invoiceToken string) (string, error) {
+	return dispatch(InvoiceNotification(phone, salesName, orderNumber, productName, quantity, unit, totalPrice, invoiceToken))
 }
 
-// SendDeliveryNotification creates delivery notification and sends via WhatsApp if connected
-func SendDeliveryNotification(phone string, salesName string, noteNumber string, productName string, qty int, unit string, driverName string, vehiclePlate string, deliveryToken string) (string, error) {
-	deliveryURL := fmt.Sprintf("%s/order/%s", Config.ClientURL, deliveryToken)
+// DeliveryNotification builds the delivery notification without sending it
+func DeliveryNotification(phone string, salesName string, noteNumber string, productName string, qty int, unit string, driverName string, vehiclePlate string, deliveryToken string) Notification {
+	deliveryURL := clientLink("order", deliveryToken)
 
 	message := fmt.Sprintf(`Halo %s,
 
@@ -162,43 +237,22 @@ Silakan akses link berikut untuk melihat surat jalan:
 Terima kasih.`,
 		salesName, noteNumber, productName, qty, unit, driverName, vehiclePlate, deliveryURL)
 
-	// Try to send via WhatsApp if connected
-	sentVia := "wa.me"
-	if sendViaWhatsApp(phone, message) {
-		sentVia = "whatsapp"
-	}
-
-	// Save notification record
-	now := time.Now()
-	notification := Notification{
-		ID:      primitive.NewObjectID(),
+	return Notification{
 		Type:    NotificationTypeDelivery,
 		Phone:   phone,
 		Message: message,
 		Link:    deliveryURL,
-		Status:  "sent",
-		SentVia: sentVia,
-	}
-	
-	if sentVia == "whatsapp" {
-		notification.SentAt = &now
-	}
-
-	collection := database.GetMongoCollection("notifications")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := collection.InsertOne(ctx, notification)
-	if err != nil {
-		return "", err
 	}
+}
 
-	return GenerateWhatsAppLink(phone, message), nil
+// SendDeliveryNotification creates delivery notification and sends via WhatsApp if connected
+func SendDeliveryNotification(phone string, salesName string, noteNumber string, productName string, qty int, unit string, driverName string, vehiclePlate string, deliveryToken string) (string, error) {
+	return dispatch(DeliveryNotification(phone, salesName, noteNumber, productName, qty, unit, driverName, vehiclePlate, deliveryToken))
 }
 
-// SendQueueNotification creates queue notification and sends via WhatsApp if connected
-func SendQueueNotification(phone string, salesName string, orderNumber string, queueNumber int, estimatedTime string, queueToken string) (string, error) {
-	queueURL := fmt.Sprintf("%s/order/%s", Config.ClientURL, queueToken)
+// QueueNotification builds the queue notification without sending it
+func QueueNotification(phone string, salesName string, orderNumber string, queueNumber int, estimatedTime string, queueToken string) Notification {
+	queueURL := clientLink("order", queueToken)
 
 	message := fmt.Sprintf(`Halo %s,
 
@@ -214,38 +268,83 @@ Silakan pantau status antrian Anda melalui link:
 Terima kasih.`,
 		salesName, orderNumber, queueNumber, estimatedTime, queueURL)
 
-	// Try to send via WhatsApp if connected
-	sentVia := "wa.me"
-	if sendViaWhatsApp(phone, message) {
-		sentVia = "whatsapp"
-	}
-
-	// Save notification record
-	now := time.Now()
-	notification := Notification{
-		ID:      primitive.NewObjectID(),
+	return Notification{
 		Type:    NotificationTypeQueue,
 		Phone:   phone,
 		Message: message,
 		Link:    queueURL,
-		Status:  "sent",
-		SentVia: sentVia,
 	}
-	
-	if sentVia == "whatsapp" {
-		notification.SentAt = &now
+}
+
+// SendQueueNotification creates queue notification and sends via WhatsApp if connected
+func SendQueueNotification(phone string, salesName string, orderNumber string, queueNumber int, estimatedTime string, queueToken string) (string, error) {
+	return dispatch(QueueNotification(phone, salesName, orderNumber, queueNumber, estimatedTime, queueToken))
+}
+
+// ReturnNotification builds the return/complaint notification without sending it
+func ReturnNotification(phone string, salesName string, returnNumber string, noteNumber string, statusText string, resolutionNote string, deliveryToken string) Notification {
+	deliveryURL := clientLink("delivery", deliveryToken)
+
+	message := fmt.Sprintf(`Halo %s,
+
+Update pengajuan retur/komplain Anda:
+
+No. Retur: %s
+No. Surat Jalan: %s
+Status: %s`,
+		salesName, returnNumber, noteNumber, statusText)
+
+	if resolutionNote != "" {
+		message += fmt.Sprintf("\nCatatan: %s", resolutionNote)
 	}
 
-	collection := database.GetMongoCollection("notifications")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	message += fmt.Sprintf(`
 
-	_, err := collection.InsertOne(ctx, notification)
-	if err != nil {
-		return "", err
+Detail dapat dilihat melalui link:
+%s
+
+Terima kasih.`, deliveryURL)
+
+	return Notification{
+		Type:    NotificationTypeReturn,
+		Phone:   phone,
+		Message: message,
+		Link:    deliveryURL,
 	}
+}
 
-	return GenerateWhatsAppLink(phone, message), nil
+// SendReturnNotification creates return/complaint notification and sends via WhatsApp if connected
+func SendReturnNotification(phone string, salesName string, returnNumber string, noteNumber string, statusText string, resolutionNote string, deliveryToken string) (string, error) {
+	return dispatch(ReturnNotification(phone, salesName, returnNumber, noteNumber, statusText, resolutionNote, deliveryToken))
+}
+
+// SendReportNotification sends the results of a saved report to an admin
+func SendReportNotification(phone string, message string) (string, error) {
+	return dispatch(Notification{
+		Type:    NotificationTypeReport,
+		Phone:   phone,
+		Message: message,
+	})
+}
+
+// Deliver sends a prepared notification unless a record with its ID already exists.
+// Returns true when the notification had already been delivered.
+func Deliver(notification Notification) (bool, error) {
+	if !notification.ID.IsZero() {
+		collection := database.GetMongoCollection("notifications")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": notification.ID})
+		cancel()
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	_, err := dispatch(notification)
+	return false, err
 }
 
 // MarkAsSent marks a notification as sent
@@ -256,7 +355,7 @@ func MarkAsSent(notificationID primitive.ObjectID) error {
 
 	now := time.Now()
 	update := bson.M{
-		"status":  "sent",
+		"status":  StatusSent,
 		"sent_at": now,
 	}
 
@@ -270,7 +369,7 @@ func GetPendingNotifications() ([]Notification, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := collection.Find(ctx, bson.M{"status": "pending"})
+	cursor, err := collection.Find(ctx, bson.M{"status": StatusPending})
 	if err != nil {
 		return nil, err
 	}
@@ -282,9 +381,20 @@ func GetPendingNotifications() ([]Notification, error) {
 	return notifications, nil
 }
 
-// WhatsAppStatus returns current WhatsApp connection status
+// WhatsAppStatus returns current WhatsApp connection status, of the provider that sends
+// notifications right now
 func WhatsAppStatus() map[string]interface{} {
-	if whatsapp.WhatsApp == nil {
+	if sender != nil {
+		return map[string]interface{}{
+			"initialized": true,
+			"connected":   sender.IsLoggedIn(),
+			"logged_in":   sender.IsLoggedIn(),
+			"provider":    sender.Name(),
+		}
+	}
+
+	providers := whatsapp.Providers()
+	if len(providers) == 0 {
 		return map[string]interface{}{
 			"initialized": false,
 			"connected":   false,
@@ -292,9 +402,14 @@ func WhatsAppStatus() map[string]interface{} {
 		}
 	}
 
+	current := whatsapp.Active()
+	if current == nil {
+		current = providers[0]
+	}
 	return map[string]interface{}{
 		"initialized": true,
-		"connected":   whatsapp.WhatsApp.IsConnected(),
-		"logged_in":   whatsapp.WhatsApp.IsLoggedIn(),
+		"connected":   current.IsConnected(),
+		"logged_in":   current.IsLoggedIn(),
+		"provider":    current.Name(),
 	}
 }
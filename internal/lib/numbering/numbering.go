@@ -0,0 +1,138 @@
+package numbering
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Document series
+const (
+	SeriesOrder        = "ORD"
+	SeriesDeliveryNote = "SJ"
+)
+
+// Collections
+const (
+	CountersCollection = "number_counters"
+	VoidsCollection    = "number_voids"
+)
+
+// numberPattern matches sequential numbers: SERIES-YYYYMM-NNNN
+var numberPattern = regexp.MustCompile(`^([A-Z]+)-(\d{6})-(\d+)$`)
+
+// Void records why an allocated number was never used, so audits can explain the gap
+type Void struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Series    string             `json:"series" bson:"series"`
+	Month     string             `json:"month" bson:"month"` // YYYYMM
+	Seq       int                `json:"seq" bson:"seq"`
+	Number    string             `json:"number" bson:"number"`
+	Reason    string             `json:"reason" bson:"reason"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Month returns the YYYYMM numbering month of a time. Months are Jakarta months, so
+// numbers issued early on the 1st start the new month whatever TZ the server runs in.
+func Month(t time.Time) string {
+	return t.In(utils.Jakarta()).Format("200601")
+}
+
+// Next allocates the next number of the series for the current month, e.g.
+// SJ-202610-0042. Numbers restart at 1 every month.
+func Next(ctx context.Context, series string) (string, error) {
+	month := Month(time.Now())
+
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := database.GetMongoCollection(CountersCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": series + "-" + month},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return "", err
+	}
+	return Format(series, month, counter.Seq), nil
+}
+
+// Format builds a number from its parts
+func Format(series, month string, seq int) string {
+	return fmt.Sprintf("%s-%s-%04d", series, month, seq)
+}
+
+// Parse splits a sequential number; ok is false for other formats (legacy timestamps)
+func Parse(number string) (series string, month string, seq int, ok bool) {
+	m := numberPattern.FindStringSubmatch(number)
+	if m == nil {
+		return "", "", 0, false
+	}
+	seq, err := strconv.Atoi(m[3])
+	if err != nil {
+		return "", "", 0, false
+	}
+	return m[1], m[2], seq, true
+}
+
+// Allocated returns the highest number allocated in a month of the series, 0 when none
+// has been
+func Allocated(ctx context.Context, series, month string) (int, error) {
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := database.GetMongoCollection(CountersCollection).FindOne(ctx, bson.M{"_id": series + "-" + month}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+// Release records an allocated number as void, e.g. when saving its document failed
+func Release(ctx context.Context, number, reason string) error {
+	series, month, seq, ok := Parse(number)
+	if !ok {
+		return nil
+	}
+	_, err := database.GetMongoCollection(VoidsCollection).InsertOne(ctx, &Void{
+		ID:        primitive.NewObjectID(),
+		Series:    series,
+		Month:     month,
+		Seq:       seq,
+		Number:    number,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// Voids returns the void numbers of a month of the series
+func Voids(ctx context.Context, series, month string) ([]Void, error) {
+	cursor, err := database.GetMongoCollection(VoidsCollection).Find(ctx,
+		bson.M{"series": series, "month": month},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	voids := []Void{}
+	if err := cursor.All(ctx, &voids); err != nil {
+		return nil, err
+	}
+	return voids, nil
+}
@@ -0,0 +1,39 @@
+package numbering
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatAndParse(t *testing.T) {
+	number := Format(SeriesDeliveryNote, "202610", 42)
+	if number != "SJ-202610-0042" {
+		t.Fatalf("format = %q", number)
+	}
+	series, month, seq, ok := Parse(number)
+	if !ok || series != SeriesDeliveryNote || month != "202610" || seq != 42 {
+		t.Fatalf("parse = %q %q %d %v", series, month, seq, ok)
+	}
+
+	// Numbers past 9999 widen instead of wrapping
+	if _, _, seq, ok := Parse(Format(SeriesOrder, "202610", 12345)); !ok || seq != 12345 {
+		t.Fatalf("wide seq = %d %v", seq, ok)
+	}
+	for _, legacy := range []string{"ORD-20261014120000", "SJ-2026-0001", "ord-202610-0001", ""} {
+		if _, _, _, ok := Parse(legacy); ok {
+			t.Fatalf("%q: want not ok", legacy)
+		}
+	}
+}
+
+func TestMonth(t *testing.T) {
+	for at, want := range map[time.Time]string{
+		time.Date(2026, 9, 30, 16, 59, 0, 0, time.UTC): "202609", // 23:59 WIB
+		time.Date(2026, 9, 30, 17, 0, 0, 0, time.UTC):  "202610", // 00:00 WIB on the 1st
+		time.Date(2026, 10, 1, 6, 59, 0, 0, time.UTC):  "202610",
+	} {
+		if got := Month(at); got != want {
+			t.Fatalf("Month(%v) = %q, want %q", at, got, want)
+		}
+	}
+}
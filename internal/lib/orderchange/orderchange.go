@@ -0,0 +1,145 @@
+// Package orderchange resolves the item edits staged on orders until the sales rep
+// confirms them: applying or discarding them on the rep's answer, an admin's withdrawal
+// or the change timeout.
+package orderchange
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotPending is returned when the order has no staged change with the given ID, or is
+// no longer pending: the change was resolved or replaced meanwhile
+var ErrNotPending = errors.New("order change is not pending")
+
+// AutoApply reports whether the timeout applies unanswered changes rather than
+// discarding them
+func AutoApply() bool {
+	return config.Cfg.Client.ChangeTimeoutAction != "discard"
+}
+
+// resolved returns the change as recorded in the order's change history
+func resolved(change models.OrderChange, resolution string, now time.Time) models.OrderChange {
+	change.Resolution = resolution
+	change.ResolvedAt = &now
+	return change
+}
+
+// Apply puts a staged change into effect: the order gets its items and totals and the
+// change moves to the history with resolution. Only a pending order still staging
+// change is updated.
+func Apply(ctx context.Context, orderID primitive.ObjectID, change *models.OrderChange, resolution string) error {
+	now := time.Now()
+	unitPrice := 0.0
+	if change.Quantity > 0 {
+		unitPrice = change.Subtotal / float64(change.Quantity)
+	}
+	result, err := database.GetMongoCollection("orders").UpdateOne(ctx,
+		bson.M{"_id": orderID, "status": models.OrderStatusPending, "pending_change.id": change.ID},
+		bson.M{
+			"$set": bson.M{
+				"items":       change.Items,
+				"quantity":    change.Quantity,
+				"unit_price":  unitPrice,
+				"subtotal":    change.Subtotal,
+				"total_price": change.TotalPrice,
+				"updated_at":  now,
+			},
+			"$unset": bson.M{"pending_change": ""},
+			"$push":  bson.M{"change_history": resolved(*change, resolution, now)},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotPending
+	}
+	return nil
+}
+
+// Discard drops a staged change, recording it in the history with resolution
+func Discard(ctx context.Context, orderID primitive.ObjectID, change *models.OrderChange, resolution string) error {
+	now := time.Now()
+	result, err := database.GetMongoCollection("orders").UpdateOne(ctx,
+		bson.M{"_id": orderID, "pending_change.id": change.ID},
+		bson.M{
+			"$set":   bson.M{"updated_at": now},
+			"$unset": bson.M{"pending_change": ""},
+			"$push":  bson.M{"change_history": resolved(*change, resolution, now)},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotPending
+	}
+	return nil
+}
+
+// ResolveExpired applies or discards, by the timeout policy, the staged changes left
+// unanswered past their expiry. Changes of orders no longer pending are withdrawn.
+func ResolveExpired() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cursor, err := database.GetMongoCollection("orders").Find(ctx, bson.M{
+		"pending_change.expires_at": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		log.Printf("[OrderChange] Failed to load expired changes: %v", err)
+		return
+	}
+	var orders []models.Order
+	err = cursor.All(ctx, &orders)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[OrderChange] Failed to load expired changes: %v", err)
+		return
+	}
+
+	for i := range orders {
+		order := &orders[i]
+		change := order.PendingChange
+		switch {
+		case order.Status != models.OrderStatusPending:
+			err = Discard(ctx, order.ID, change, models.ChangeWithdrawn)
+		case AutoApply():
+			err = Apply(ctx, order.ID, change, models.ChangeAutoApplied)
+			if err == nil {
+				notifyApplied(ctx, order, change)
+			}
+		default:
+			err = Discard(ctx, order.ID, change, models.ChangeExpired)
+		}
+		if err != nil && !errors.Is(err, ErrNotPending) {
+			log.Printf("[OrderChange] Failed to resolve change of %s: %v", order.OrderNumber, err)
+		}
+	}
+}
+
+// notifyApplied tells the order's sales rep that the timeout applied its change
+func notifyApplied(ctx context.Context, order *models.Order, change *models.OrderChange) {
+	salesObjID, err := primitive.ObjectIDFromHex(order.SalesID)
+	if err != nil {
+		return
+	}
+	sales := &models.Sales{}
+	if err := database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales); err != nil || sales.Phone == "" {
+		return
+	}
+	if _, err := notification.SendOrderChangeApplied(sales.Phone, sales.Name, order, change); err != nil {
+		log.Printf("[OrderChange] Failed to notify applied change of %s: %v", order.OrderNumber, err)
+	}
+}
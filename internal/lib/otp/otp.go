@@ -0,0 +1,213 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"bg-go/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the issued codes
+const Collection = "otp_codes"
+
+// Purposes, so a code issued for one flow cannot be used in another
+const (
+	PurposeInvoiceLink = "invoice_link" // Re-sending a lost invoice link to the sales rep
+	PurposeDriver      = "driver"       // Submitting the driver data of an order
+)
+
+const (
+	// Length is the number of digits of a code
+	Length = 6
+	// Expiry is how long a code can be used
+	Expiry = 10 * time.Minute
+	// MaxAttempts is how often a code can be entered wrong before it is void
+	MaxAttempts = 5
+	// MaxPerHour is how many codes one phone can be sent per hour and purpose
+	MaxPerHour = 3
+)
+
+// Errors returned by Issue and Verify
+var (
+	ErrRateLimited     = errors.New("too many codes requested, try again later")
+	ErrInvalid         = errors.New("the code is wrong")
+	ErrExpired         = errors.New("the code has expired, request a new one")
+	ErrTooManyAttempts = errors.New("the code was entered wrong too often, request a new one")
+)
+
+// Code is an issued one-time code. Only its hash is stored.
+type Code struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Purpose   string             `bson:"purpose"`
+	Subject   string             `bson:"subject"` // What the code unlocks, e.g. an order ID
+	Phone     string             `bson:"phone"`
+	Hash      string             `bson:"hash"`
+	Attempts  int                `bson:"attempts"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// Issue creates a code for a subject that is sent to a phone, replacing the subject's
+// earlier codes. It returns ErrRateLimited once the phone got MaxPerHour codes.
+func Issue(ctx context.Context, purpose string, subject string, phone string) (string, error) {
+	collection := database.GetMongoCollection(Collection)
+	now := time.Now()
+
+	if err := checkRate(ctx, collection, purpose, phone, now); err != nil {
+		return "", err
+	}
+
+	// Only the latest code of a subject is valid
+	if _, err := collection.UpdateMany(ctx,
+		bson.M{"purpose": purpose, "subject": subject, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	); err != nil {
+		return "", err
+	}
+
+	code := newCode()
+	_, err := collection.InsertOne(ctx, Code{
+		ID:        primitive.NewObjectID(),
+		Purpose:   purpose,
+		Subject:   subject,
+		Phone:     phone,
+		Hash:      hash(purpose, subject, code),
+		ExpiresAt: now.Add(Expiry),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Throttle records a code request that matched no subject, so it counts against the
+// phone's MaxPerHour like an issued code and callers cannot tell the two apart by the
+// limit. The record is used up and never verifies.
+func Throttle(ctx context.Context, purpose string, phone string) error {
+	collection := database.GetMongoCollection(Collection)
+	now := time.Now()
+
+	if err := checkRate(ctx, collection, purpose, phone, now); err != nil {
+		return err
+	}
+	_, err := collection.InsertOne(ctx, Code{
+		ID:        primitive.NewObjectID(),
+		Purpose:   purpose,
+		Phone:     phone,
+		ExpiresAt: now,
+		UsedAt:    &now,
+		CreatedAt: now,
+	})
+	return err
+}
+
+// checkRate returns ErrRateLimited once a phone was sent MaxPerHour codes of a purpose
+func checkRate(ctx context.Context, collection *mongo.Collection, purpose string, phone string, now time.Time) error {
+	recent, err := collection.CountDocuments(ctx, bson.M{
+		"purpose":    purpose,
+		"phone":      phone,
+		"created_at": bson.M{"$gte": now.Add(-time.Hour)},
+	})
+	if err != nil {
+		return err
+	}
+	if recent >= MaxPerHour {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// Verify checks a code entered for a subject and uses it up
+func Verify(ctx context.Context, purpose string, subject string, code string) error {
+	collection := database.GetMongoCollection(Collection)
+
+	current := &Code{}
+	err := collection.FindOne(ctx,
+		bson.M{"purpose": purpose, "subject": subject, "used_at": bson.M{"$exists": false}},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	).Decode(current)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	switch {
+	case now.After(current.ExpiresAt):
+		return ErrExpired
+	case current.Attempts >= MaxAttempts:
+		return ErrTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash(purpose, subject, code)), []byte(current.Hash)) != 1 {
+		collection.UpdateOne(ctx, bson.M{"_id": current.ID}, bson.M{"$inc": bson.M{"attempts": 1}})
+		return ErrInvalid
+	}
+
+	// Two requests with the same code: only the first one uses it up
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": current.ID, "used_at": bson.M{"$exists": false}, "attempts": bson.M{"$lt": MaxAttempts}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// EnsureIndexes creates the lookup index and expires codes a day after they were
+// issued, once they no longer count towards the hourly limit
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := database.GetMongoCollection(Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "purpose", Value: 1}, {Key: "subject", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("subject"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("expire").SetExpireAfterSeconds(int32((24 * time.Hour).Seconds())),
+		},
+	})
+	if err != nil {
+		log.Printf("[OTP] Failed to create indexes: %v", err)
+	}
+}
+
+// newCode returns a random code of Length digits
+func newCode() string {
+	max := big.NewInt(1)
+	for i := 0; i < Length; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, _ := rand.Int(rand.Reader, max)
+	return fmt.Sprintf("%0*d", Length, n.Int64())
+}
+
+// hash binds a code to its purpose and subject, so it cannot be replayed elsewhere
+func hash(purpose string, subject string, code string) string {
+	sum := sha256.Sum256([]byte(purpose + ":" + subject + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,165 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/notification"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Entry kinds
+const (
+	KindWhatsApp = "whatsapp"
+)
+
+// Entry statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusDone       = "done"
+	StatusFailed     = "failed"
+)
+
+const (
+	// lockDuration is how long a claimed entry is reserved before another worker may take it
+	lockDuration = time.Minute
+	// maxAttempts is how often an entry is retried before it is marked failed
+	maxAttempts = 5
+)
+
+// Entry is a side effect recorded together with the change that caused it
+type Entry struct {
+	ID           primitive.ObjectID         `json:"id" bson:"_id"`
+	Kind         string                     `json:"kind" bson:"kind"`
+	Notification *notification.Notification `json:"notification,omitempty" bson:"notification,omitempty"`
+	Status       string                     `json:"status" bson:"status"`
+	Attempts     int                        `json:"attempts" bson:"attempts"`
+	LastError    string                     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	AvailableAt  time.Time                  `json:"available_at" bson:"available_at"`
+	LockedUntil  *time.Time                 `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	CreatedAt    time.Time                  `json:"created_at" bson:"created_at"`
+	ProcessedAt  *time.Time                 `json:"processed_at,omitempty" bson:"processed_at,omitempty"`
+}
+
+// EnqueueNotification records a WhatsApp notification to be sent once ctx's transaction commits.
+// The notification keeps the entry ID so a replayed entry is never sent twice.
+func EnqueueNotification(ctx context.Context, n notification.Notification) (notification.Notification, error) {
+	id := primitive.NewObjectID()
+	n.ID = id
+
+	now := time.Now()
+	entry := Entry{
+		ID:           id,
+		Kind:         KindWhatsApp,
+		Notification: &n,
+		Status:       StatusPending,
+		AvailableAt:  now,
+		CreatedAt:    now,
+	}
+
+	_, err := database.GetMongoCollection("outbox").InsertOne(ctx, entry)
+	return n, err
+}
+
+var dispatching sync.Mutex
+
+// Kick delivers pending entries in the background; call it after a transaction commits
+func Kick() {
+	go Dispatch()
+}
+
+// Dispatch claims and delivers pending entries until none are left.
+// Entries locked by a crashed worker are picked up again once their lock expires.
+func Dispatch() {
+	if !dispatching.TryLock() {
+		return
+	}
+	defer dispatching.Unlock()
+
+	collection := database.GetMongoCollection("outbox")
+	if collection == nil {
+		return
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		now := time.Now()
+		lockedUntil := now.Add(lockDuration)
+
+		filter := bson.M{
+			"$or": []bson.M{
+				{"status": StatusPending, "available_at": bson.M{"$lte": now}},
+				{"status": StatusProcessing, "locked_until": bson.M{"$lt": now}},
+			},
+		}
+		claim := bson.M{
+			"$set": bson.M{"status": StatusProcessing, "locked_until": lockedUntil},
+			"$inc": bson.M{"attempts": 1},
+		}
+		claimOptions := options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After)
+
+		entry := &Entry{}
+		err := collection.FindOneAndUpdate(ctx, filter, claim, claimOptions).Decode(entry)
+		cancel()
+		if err != nil {
+			return
+		}
+
+		deliver(entry)
+	}
+}
+
+// deliver performs an entry's side effect and records the outcome
+func deliver(entry *Entry) {
+	var err error
+	switch entry.Kind {
+	case KindWhatsApp:
+		if entry.Notification != nil {
+			_, err = notification.Deliver(*entry.Notification)
+		}
+	}
+
+	now := time.Now()
+	update := bson.M{"locked_until": nil}
+	switch {
+	case err == nil:
+		update["status"] = StatusDone
+		update["processed_at"] = now
+	case entry.Attempts >= maxAttempts:
+		update["status"] = StatusFailed
+		update["last_error"] = err.Error()
+		update["processed_at"] = now
+	default:
+		update["status"] = StatusPending
+		update["last_error"] = err.Error()
+		update["available_at"] = now.Add(time.Duration(entry.Attempts) * 30 * time.Second)
+	}
+	if err != nil {
+		log.Printf("[Outbox] Failed to deliver %s (attempt %d): %v", entry.ID.Hex(), entry.Attempts, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	database.GetMongoCollection("outbox").UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{"$set": update})
+}
+
+// Start runs the dispatcher on an interval to pick up entries missed by Kick
+func Start(interval time.Duration) {
+	go func() {
+		Dispatch()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			Dispatch()
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package polling
+
+import (
+	"sync"
+	"time"
+
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Suggested poll intervals by what the client page is waiting for
+const (
+	paymentInterval = 60 * time.Second // Waiting for the sales rep to pay
+	reviewInterval  = 30 * time.Second // Waiting for verification or the driver data
+	queueInterval   = 10 * time.Second // In the queue or being loaded
+	transitInterval = 30 * time.Second // Completed, the delivery is on its way
+)
+
+// finishedInterval is the interval enforced on orders whose clients are told to stop
+const finishedInterval = time.Minute
+
+// tolerance is how many times faster than the suggested interval a client may poll
+// before it is rejected, leaving room for reloads and clock jitter
+const tolerance = 4
+
+// sweepInterval is how often polls older than any interval are forgotten
+const sweepInterval = 10 * time.Minute
+
+// Interval returns how often the status of an order should be polled; 0 means the
+// order will not change anymore and the client should stop
+func Interval(status string, shipment *models.ClientShipment) time.Duration {
+	switch status {
+	case models.OrderStatusPending:
+		return paymentInterval
+	case models.OrderStatusPaid, models.OrderStatusConfirmed:
+		return reviewInterval
+	case models.OrderStatusQueued, models.OrderStatusLoading:
+		return queueInterval
+	case models.OrderStatusCompleted:
+		if shipment != nil && shipment.DispatchedAt != nil && shipment.ArrivedAt == nil {
+			return transitInterval
+		}
+	}
+	return 0
+}
+
+// poll is the latest accepted poll of a token
+type poll struct {
+	at       time.Time
+	interval time.Duration
+}
+
+// Key identifies one poller: an invoice token opened from one address. Viewers of the same
+// invoice elsewhere poll on their own schedule.
+func Key(token string, ip string) string {
+	return token + "|" + ip
+}
+
+// Tracker remembers the latest poll of every key so polls arriving far faster than
+// the interval suggested to them are rejected before touching the database. The state is
+// per instance, so behind a load balancer each instance enforces its own share.
+type Tracker struct {
+	mu        sync.Mutex
+	polls     map[string]poll
+	lastSweep time.Time
+}
+
+// Default is the tracker of the client status endpoint
+var Default = NewTracker()
+
+// NewTracker creates an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{polls: map[string]poll{}, lastSweep: time.Now()}
+}
+
+// Check returns how long the poller of key must wait before polling again, or 0 when
+// the poll is allowed
+func (t *Tracker) Check(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.polls[key]
+	if !ok {
+		return 0
+	}
+	interval := last.interval
+	if interval == 0 {
+		interval = finishedInterval
+	}
+	if elapsed := now.Sub(last.at); elapsed < interval/tolerance {
+		return interval - elapsed
+	}
+	return 0
+}
+
+// Record stores an accepted poll of key and the interval suggested in its response. The
+// key is copied, request strings are reused by fasthttp once the handler returns.
+func (t *Tracker) Record(key string, interval time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.polls[utils.CopyString(key)] = poll{at: now, interval: interval}
+
+	if now.Sub(t.lastSweep) < sweepInterval {
+		return
+	}
+	for k, p := range t.polls {
+		if now.Sub(p.at) > sweepInterval {
+			delete(t.polls, k)
+		}
+	}
+	t.lastSweep = now
+}
@@ -0,0 +1,187 @@
+package presence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Collection holds the latest heartbeat of every admin, keyed by the user ID
+	Collection = "admin_presence"
+	// SessionCollection keeps one entry per stretch an admin was online on one view
+	SessionCollection = "presence_sessions"
+)
+
+const (
+	// Interval is how often clients should send a heartbeat
+	Interval = 30 * time.Second
+	// Timeout is how long after the last heartbeat an admin counts as offline
+	Timeout = 90 * time.Second
+)
+
+// ViewPayments is the payment verification queue
+const ViewPayments = "payments"
+
+// sessionRetention is how long sessions are kept for the coverage report
+const sessionRetention = 90 * 24 * time.Hour
+
+// Presence is the latest heartbeat of an admin
+type Presence struct {
+	UserID      string    `json:"user_id" bson:"_id"`
+	Username    string    `json:"username" bson:"username"`
+	DisplayName string    `json:"display_name" bson:"display_name"`
+	Role        string    `json:"role" bson:"role"`
+	View        string    `json:"view" bson:"view"` // The page the admin has open, e.g. payments
+	SessionID   string    `json:"-" bson:"session_id"`
+	OnlineSince time.Time `json:"online_since" bson:"online_since"`
+	LastSeenAt  time.Time `json:"last_seen_at" bson:"last_seen_at"`
+}
+
+// Session is a stretch of consecutive heartbeats of an admin on one view
+type Session struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	UserID     string             `json:"user_id" bson:"user_id"`
+	Username   string             `json:"username" bson:"username"`
+	View       string             `json:"view" bson:"view"`
+	StartedAt  time.Time          `json:"started_at" bson:"started_at"`
+	LastSeenAt time.Time          `json:"last_seen_at" bson:"last_seen_at"`
+}
+
+// Beat records a heartbeat of an admin on a view. A heartbeat after the timeout, or on
+// another view, starts a new session.
+func Beat(ctx context.Context, user *models.User, view string) (*Presence, error) {
+	now := time.Now()
+	collection := database.GetMongoCollection(Collection)
+	sessions := database.GetMongoCollection(SessionCollection)
+
+	current := &Presence{}
+	found := collection.FindOne(ctx, bson.M{"_id": user.ID.Hex()}).Decode(current) == nil
+	online := found && now.Sub(current.LastSeenAt) <= Timeout
+
+	presence := &Presence{
+		UserID:      user.ID.Hex(),
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Role:        user.Role,
+		View:        view,
+		SessionID:   current.SessionID,
+		OnlineSince: current.OnlineSince,
+		LastSeenAt:  now,
+	}
+	if online && current.View == view {
+		sessionID, _ := primitive.ObjectIDFromHex(current.SessionID)
+		sessions.UpdateOne(ctx, bson.M{"_id": sessionID}, bson.M{"$set": bson.M{"last_seen_at": now}})
+	} else {
+		session := Session{
+			ID:         primitive.NewObjectID(),
+			UserID:     presence.UserID,
+			Username:   user.Username,
+			View:       view,
+			StartedAt:  now,
+			LastSeenAt: now,
+		}
+		if _, err := sessions.InsertOne(ctx, session); err != nil {
+			return nil, err
+		}
+		presence.SessionID = session.ID.Hex()
+		if !online {
+			presence.OnlineSince = now
+		}
+	}
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": presence.UserID}, presence, options.Replace().SetUpsert(true))
+	return presence, err
+}
+
+// Online returns the admins with a heartbeat within the timeout, most recent first
+func Online(ctx context.Context) ([]Presence, error) {
+	online := []Presence{}
+	cursor, err := database.GetMongoCollection(Collection).Find(ctx,
+		bson.M{"last_seen_at": bson.M{"$gte": time.Now().Add(-Timeout)}},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}))
+	if err != nil {
+		return online, err
+	}
+	err = cursor.All(ctx, &online)
+	cursor.Close(ctx)
+	return online, err
+}
+
+// Sessions returns the sessions on a view that overlap [from, to)
+func Sessions(ctx context.Context, view string, from time.Time, to time.Time) ([]Session, error) {
+	sessions := []Session{}
+	cursor, err := database.GetMongoCollection(SessionCollection).Find(ctx, bson.M{
+		"view":         view,
+		"started_at":   bson.M{"$lt": to},
+		"last_seen_at": bson.M{"$gte": from},
+	})
+	if err != nil {
+		return sessions, err
+	}
+	err = cursor.All(ctx, &sessions)
+	cursor.Close(ctx)
+	return sessions, err
+}
+
+// ReleaseStaleClaims releases the payment claims of admins who went offline, so the
+// payments return to the shared queue. It is registered as a cron job.
+func ReleaseStaleClaims() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	online, err := Online(ctx)
+	if err != nil {
+		log.Printf("[Presence] Failed to load online admins: %v", err)
+		return
+	}
+	onlineIDs := []string{}
+	for _, p := range online {
+		onlineIDs = append(onlineIDs, p.UserID)
+	}
+
+	// Fresh claims are kept a timeout long, so a claim right after login is not lost
+	// before the first heartbeat arrives
+	result, err := database.GetMongoCollection("orders").UpdateMany(ctx,
+		bson.M{
+			"payment_claimed_by": bson.M{"$exists": true, "$nin": onlineIDs},
+			"payment_claimed_at": bson.M{"$lt": time.Now().Add(-Timeout)},
+		},
+		bson.M{"$unset": bson.M{"payment_claimed_by": "", "payment_claimed_at": ""}},
+	)
+	if err != nil {
+		log.Printf("[Presence] Failed to release claims: %v", err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("[Presence] Released %d payment claims of offline admins", result.ModifiedCount)
+	}
+}
+
+// EnsureIndexes creates the index of the coverage report and expires old sessions
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := database.GetMongoCollection(SessionCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "view", Value: 1}, {Key: "started_at", Value: 1}},
+			Options: options.Index().SetName("view_started"),
+		},
+		{
+			Keys:    bson.D{{Key: "last_seen_at", Value: 1}},
+			Options: options.Index().SetName("expire").SetExpireAfterSeconds(int32(sessionRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		log.Printf("[Presence] Failed to create indexes: %v", err)
+	}
+}
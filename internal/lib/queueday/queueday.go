@@ -0,0 +1,186 @@
+package queueday
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one snapshot per queue day
+const Collection = "queue_days"
+
+// Timezone is used to bucket the hourly throughput
+const Timezone = "Asia/Jakarta"
+
+// backfillDays is how many past days the job checks for a missing snapshot
+const backfillDays = 7
+
+// Run snapshots every recent queue day that has ended and has no snapshot yet;
+// it is registered as a cron job, so a missed run is caught up on the next one
+func Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	collection := database.GetMongoCollection(Collection)
+	today, _ := DayStart(time.Now().Format("2006-01-02"))
+
+	for i := backfillDays; i >= 1; i-- {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		if n, _ := collection.CountDocuments(ctx, bson.M{"_id": date}); n > 0 {
+			continue
+		}
+		day, err := Snapshot(ctx, date)
+		if err != nil {
+			log.Printf("[QueueDay] Failed to snapshot %s: %v", date, err)
+			continue
+		}
+		if day.Total > 0 {
+			log.Printf("[QueueDay] Snapshotted %s: %d served, %d skipped, %d unserved", date, day.Served, day.Skipped, day.Unserved)
+		}
+	}
+}
+
+// DayStart returns the start of a queue day; the day lasts 24 hours. The bounds match
+// how queue numbers are assigned on scan, so a snapshot covers one run of queue numbers.
+func DayStart(date string) (time.Time, error) {
+	return time.Parse("2006-01-02", date)
+}
+
+// Snapshot builds the state of a queue day and stores it, replacing an earlier snapshot
+func Snapshot(ctx context.Context, date string) (*models.QueueDay, error) {
+	day, err := Build(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = database.GetMongoCollection(Collection).ReplaceOne(ctx, bson.M{"_id": date}, day, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	return day, nil
+}
+
+// Build replays a queue day from the orders (and archived orders) that entered it
+func Build(ctx context.Context, date string) (*models.QueueDay, error) {
+	start, err := DayStart(date)
+	if err != nil {
+		return nil, err
+	}
+	end := start.Add(24 * time.Hour)
+
+	loc, err := time.LoadLocation(Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	now := time.Now()
+	day := &models.QueueDay{
+		Date:       date,
+		Hours:      make([]models.QueueHourStat, 24),
+		Entries:    []models.QueueDayEntry{},
+		SnapshotAt: now,
+	}
+	for hour := range day.Hours {
+		day.Hours[hour].Hour = hour
+	}
+
+	filter := bson.M{"queue_entered_at": bson.M{"$gte": start, "$lt": end}}
+	var waitSum, waitMax float64
+
+	for _, name := range []string{"orders", archive.Collection} {
+		cursor, err := database.GetMongoCollection(name).Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		for cursor.Next(ctx) {
+			var order models.Order
+			if cursor.Decode(&order) != nil || order.QueueEnteredAt == nil {
+				continue
+			}
+
+			entry := models.QueueDayEntry{
+				OrderID:      order.ID.Hex(),
+				OrderNumber:  order.OrderNumber,
+				QueueNumber:  order.QueueNumber,
+				DriverName:   order.DriverName,
+				VehiclePlate: order.VehiclePlate,
+				LoadingBay:   order.LoadingBay,
+				EnteredAt:    *order.QueueEnteredAt,
+				CalledAt:     order.QueueCalledAt,
+				FinishedAt:   order.LoadingFinishedAt,
+			}
+			if entry.FinishedAt == nil {
+				entry.FinishedAt = order.CompletedAt
+			}
+			day.Hours[order.QueueEnteredAt.In(loc).Hour()].Arrivals++
+
+			var waitedUntil time.Time
+			switch {
+			case order.QueueCalledAt != nil:
+				entry.Outcome = models.QueueOutcomeServed
+				waitedUntil = *order.QueueCalledAt
+				day.Served++
+				day.Hours[order.QueueCalledAt.In(loc).Hour()].Called++
+			case order.Status == models.OrderStatusCancelled:
+				entry.Outcome = models.QueueOutcomeSkipped
+				waitedUntil = cancelledAt(&order)
+				day.Skipped++
+			default:
+				// An order never served stops waiting when its queue day ends
+				entry.Outcome = models.QueueOutcomeUnserved
+				waitedUntil = now
+				if waitedUntil.After(end) {
+					waitedUntil = end
+				}
+				day.Unserved++
+			}
+
+			wait := waitedUntil.Sub(*order.QueueEnteredAt).Minutes()
+			if wait < 0 {
+				wait = 0
+			}
+			entry.WaitMinutes = &wait
+			if entry.Outcome == models.QueueOutcomeServed {
+				waitSum += wait
+				if wait > waitMax {
+					waitMax = wait
+				}
+			}
+
+			day.Entries = append(day.Entries, entry)
+		}
+		cursor.Close(ctx)
+	}
+
+	sort.Slice(day.Entries, func(i, j int) bool {
+		return day.Entries[i].QueueNumber < day.Entries[j].QueueNumber
+	})
+
+	day.Total = len(day.Entries)
+	if day.Served > 0 {
+		avg := waitSum / float64(day.Served)
+		day.AvgWaitMinutes = &avg
+		day.MaxWaitMinutes = &waitMax
+	}
+
+	return day, nil
+}
+
+// cancelledAt returns when the order was cancelled according to its status history
+func cancelledAt(order *models.Order) time.Time {
+	for i := len(order.StatusHistory) - 1; i >= 0; i-- {
+		if order.StatusHistory[i].Status == models.OrderStatusCancelled {
+			return order.StatusHistory[i].ChangedAt
+		}
+	}
+	return order.UpdatedAt
+}
@@ -0,0 +1,141 @@
+package queuetime
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the single queue duration settings document
+const Collection = "queue_duration_settings"
+
+// Estimator computes loading durations from the queue duration settings
+type Estimator struct {
+	settings   *models.QueueDurationSettings
+	categories map[string]int
+}
+
+// LoadSettings returns the saved settings, or settings where every order takes the
+// global duration
+func LoadSettings(ctx context.Context) *models.QueueDurationSettings {
+	settings := &models.QueueDurationSettings{}
+	if err := database.GetMongoCollection(Collection).FindOne(ctx, bson.M{}).Decode(settings); err != nil {
+		*settings = models.QueueDurationSettings{}
+	}
+	if settings.DefaultMinutes <= 0 {
+		settings.DefaultMinutes = models.QueueDurationMinutes
+	}
+	if settings.Categories == nil {
+		settings.Categories = []models.CategoryDuration{}
+	}
+	if settings.Brackets == nil {
+		settings.Brackets = []models.QuantityBracket{}
+	}
+	return settings
+}
+
+// Load returns an estimator over the saved settings. Load it once per computation,
+// not once per order.
+func Load(ctx context.Context) *Estimator {
+	return New(LoadSettings(ctx))
+}
+
+// New returns an estimator over the given settings
+func New(settings *models.QueueDurationSettings) *Estimator {
+	e := &Estimator{settings: settings, categories: map[string]int{}}
+	for _, category := range settings.Categories {
+		e.categories[NormalizeCategory(category.Category)] = category.Minutes
+	}
+	return e
+}
+
+// Minutes returns how long loading the order takes: the longest duration of its item
+// categories and its quantity bracket, falling back to the default duration
+func (e *Estimator) Minutes(order *models.Order) int {
+	minutes := 0
+	quantity := 0
+	for _, item := range order.Items {
+		quantity += item.Quantity
+		if m, ok := e.categories[NormalizeCategory(item.Category)]; ok {
+			minutes = max(minutes, m)
+		}
+	}
+	// Legacy single-product orders carry only the order quantity
+	if quantity == 0 {
+		quantity = order.Quantity
+	}
+	for _, bracket := range e.settings.Brackets {
+		if quantity >= bracket.MinQuantity {
+			minutes = max(minutes, bracket.Minutes)
+		}
+	}
+
+	if minutes <= 0 {
+		return e.settings.DefaultMinutes
+	}
+	return minutes
+}
+
+// DayBounds returns the start and end of the queue day a time falls in. The bounds match
+// how queue numbers are assigned on scan, so one day holds one run of queue numbers.
+func DayBounds(at time.Time) (time.Time, time.Time) {
+	start, _ := time.Parse("2006-01-02", at.Format("2006-01-02"))
+	return start, start.Add(24 * time.Hour)
+}
+
+// Ahead loads the queued and loading orders ahead of a queue number in the queue day of
+// at, in queue order. Orders left over from an earlier day's queue are not counted. A
+// queue number of 0 loads the day's whole active queue.
+func Ahead(ctx context.Context, at time.Time, queueNumber int) ([]models.Order, error) {
+	return AheadIn(ctx, database.GetMongoCollection("orders"), at, queueNumber)
+}
+
+// AheadIn is Ahead over a collection that shares the order's queue fields, such as the
+// client views
+func AheadIn(ctx context.Context, collection *mongo.Collection, at time.Time, queueNumber int) ([]models.Order, error) {
+	start, end := DayBounds(at)
+	filter := bson.M{
+		"status":           bson.M{"$in": []string{models.OrderStatusQueued, models.OrderStatusLoading}},
+		"queue_entered_at": bson.M{"$gte": start, "$lt": end},
+	}
+	if queueNumber > 0 {
+		filter["queue_number"] = bson.M{"$lt": queueNumber}
+	}
+
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "queue_number", Value: 1}}).
+			SetProjection(bson.M{"status": 1, "queue_number": 1, "quantity": 1, "items.quantity": 1, "items.category": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	orders := []models.Order{}
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// Total returns how long loading all the orders takes, one after another
+func (e *Estimator) Total(orders []models.Order) int {
+	total := 0
+	for i := range orders {
+		total += e.Minutes(&orders[i])
+	}
+	return total
+}
+
+// NormalizeCategory folds case and spacing so "Semen " and "semen" are one category
+func NormalizeCategory(category string) string {
+	return strings.ToLower(strings.Join(strings.Fields(category), " "))
+}
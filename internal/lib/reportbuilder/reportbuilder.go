@@ -0,0 +1,528 @@
+package reportbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/chatalert"
+	"bg-go/internal/lib/mailer"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Collections holding saved definitions and their results
+const (
+	Collection     = "reports"
+	RunsCollection = "report_runs"
+)
+
+// MaxRows caps the number of groups a run stores
+const MaxRows = 1000
+
+// Timezone is used to bucket dates and to schedule runs
+const Timezone = "Asia/Jakarta"
+
+// GroupBys lists the supported group-by fields
+var GroupBys = []string{
+	models.ReportGroupSales,
+	models.ReportGroupProduct,
+	models.ReportGroupStatus,
+	models.ReportGroupDay,
+	models.ReportGroupWeek,
+	models.ReportGroupMonth,
+}
+
+// Metrics lists the supported metrics
+var Metrics = []string{
+	models.ReportMetricRevenue,
+	models.ReportMetricOrders,
+	models.ReportMetricQuantity,
+	models.ReportMetricAvgOrderValue,
+}
+
+// dateFormats are the $dateToString formats of the date group-bys
+var dateFormats = map[string]string{
+	models.ReportGroupDay:   "%Y-%m-%d",
+	models.ReportGroupWeek:  "%G-W%V",
+	models.ReportGroupMonth: "%Y-%m",
+}
+
+func location() *time.Location {
+	loc, err := time.LoadLocation(Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Validate checks a definition before it is saved or previewed
+func Validate(def *models.ReportDefinition) error {
+	if strings.TrimSpace(def.Name) == "" {
+		return errors.New("Name is required")
+	}
+	if len(def.Metrics) == 0 {
+		return errors.New("At least one metric is required")
+	}
+	for _, metric := range def.Metrics {
+		if !contains(Metrics, metric) {
+			return fmt.Errorf("Invalid metric: %s", metric)
+		}
+	}
+	dates := 0
+	for _, group := range def.GroupBy {
+		if !contains(GroupBys, group) {
+			return fmt.Errorf("Invalid group_by: %s", group)
+		}
+		if dateFormats[group] != "" {
+			dates++
+		}
+	}
+	if dates > 1 {
+		return errors.New("Only one of day, week or month can be grouped by")
+	}
+	if def.PeriodDays <= 0 || def.PeriodDays > 366 {
+		return errors.New("period_days must be between 1 and 366")
+	}
+
+	if schedule := def.Schedule; schedule != nil {
+		switch schedule.Frequency {
+		case models.ReportFrequencyDaily, models.ReportFrequencyWeekly, models.ReportFrequencyMonthly:
+		default:
+			return errors.New("Schedule frequency must be daily, weekly or monthly")
+		}
+		if schedule.Hour < 0 || schedule.Hour > 23 {
+			return errors.New("Schedule hour must be between 0 and 23")
+		}
+		if schedule.Weekday < 0 || schedule.Weekday > 6 {
+			return errors.New("Schedule weekday must be between 0 (Sunday) and 6")
+		}
+		if schedule.Frequency == models.ReportFrequencyMonthly && (schedule.Day < 1 || schedule.Day > 28) {
+			return errors.New("Schedule day must be between 1 and 28")
+		}
+	}
+
+	for _, recipient := range def.Recipients {
+		switch recipient.Channel {
+		case models.ReportChannelEmail:
+			if !strings.Contains(recipient.Address, "@") {
+				return fmt.Errorf("Invalid email address: %s", recipient.Address)
+			}
+		case models.ReportChannelWhatsApp:
+			if strings.TrimSpace(recipient.Address) == "" {
+				return errors.New("WhatsApp recipients need a phone number")
+			}
+		default:
+			return fmt.Errorf("Invalid recipient channel: %s", recipient.Channel)
+		}
+	}
+
+	return nil
+}
+
+// NextRun returns the first scheduled time strictly after after
+func NextRun(schedule *models.ReportSchedule, after time.Time) time.Time {
+	loc := location()
+	local := after.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), schedule.Hour, 0, 0, 0, loc)
+
+	switch schedule.Frequency {
+	case models.ReportFrequencyWeekly:
+		next = next.AddDate(0, 0, (schedule.Weekday-int(next.Weekday())+7)%7)
+		if !next.After(after) {
+			next = next.AddDate(0, 0, 7)
+		}
+	case models.ReportFrequencyMonthly:
+		next = time.Date(local.Year(), local.Month(), schedule.Day, schedule.Hour, 0, 0, 0, loc)
+		if !next.After(after) {
+			next = next.AddDate(0, 1, 0)
+		}
+	default:
+		if !next.After(after) {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+
+	return next
+}
+
+// Period returns the range a run at now covers: the last PeriodDays days, up to now
+func Period(def *models.ReportDefinition, now time.Time) (time.Time, time.Time) {
+	local := now.In(location())
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	return start.AddDate(0, 0, -def.PeriodDays), now
+}
+
+// Build computes the report over [from, to) without storing it
+func Build(ctx context.Context, def *models.ReportDefinition, from time.Time, to time.Time) (*models.ReportRun, error) {
+	run := &models.ReportRun{
+		ID:        primitive.NewObjectID(),
+		ReportID:  def.ID.Hex(),
+		Name:      def.Name,
+		From:      from,
+		To:        to,
+		GroupBy:   def.GroupBy,
+		Metrics:   def.Metrics,
+		Rows:      []models.ReportRow{},
+		CreatedAt: time.Now(),
+	}
+
+	rows, err := aggregate(ctx, def, from, to, true)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := aggregate(ctx, def, from, to, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Show sales names rather than IDs
+	if contains(def.GroupBy, models.ReportGroupSales) {
+		names := salesNames(ctx, rows)
+		for _, row := range rows {
+			row.Group["sales_id"] = row.Group[models.ReportGroupSales]
+			if name, ok := names[row.Group["sales_id"]]; ok {
+				row.Group[models.ReportGroupSales] = name
+			}
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, group := range def.GroupBy {
+			if rows[i].Group[group] != rows[j].Group[group] {
+				return rows[i].Group[group] < rows[j].Group[group]
+			}
+		}
+		return false
+	})
+	if len(rows) > MaxRows {
+		rows = rows[:MaxRows]
+	}
+
+	for _, row := range rows {
+		run.Rows = append(run.Rows, *row)
+	}
+	run.Totals = map[string]float64{}
+	if len(totals) > 0 {
+		run.Totals = totals[0].Metrics
+	}
+
+	return run, nil
+}
+
+// aggregate runs the report pipeline, grouped by the definition's fields or as a single total
+func aggregate(ctx context.Context, def *models.ReportDefinition, from time.Time, to time.Time, grouped bool) ([]*models.ReportRow, error) {
+	match := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}
+	if len(def.Filter.Statuses) > 0 {
+		match["status"] = bson.M{"$in": def.Filter.Statuses}
+	}
+	if def.Filter.PaymentStatus != "" {
+		match["payment_status"] = def.Filter.PaymentStatus
+	}
+	if len(def.Filter.SalesIDs) > 0 {
+		match["sales_id"] = bson.M{"$in": def.Filter.SalesIDs}
+	}
+	if len(def.Filter.Products) > 0 {
+		match["items.product_name"] = bson.M{"$in": def.Filter.Products}
+	}
+
+	pipeline := []bson.M{{"$match": match}}
+
+	// Product reports count line items, so revenue and quantity only include matching products
+	byItem := len(def.Filter.Products) > 0 || (grouped && contains(def.GroupBy, models.ReportGroupProduct))
+	revenue, quantity := "$total_price", "$quantity"
+	if byItem {
+		pipeline = append(pipeline, bson.M{"$unwind": "$items"})
+		if len(def.Filter.Products) > 0 {
+			pipeline = append(pipeline, bson.M{"$match": bson.M{"items.product_name": bson.M{"$in": def.Filter.Products}}})
+		}
+		revenue, quantity = "$items.subtotal", "$items.quantity"
+	}
+
+	groupID := bson.M{}
+	if grouped {
+		for _, group := range def.GroupBy {
+			switch group {
+			case models.ReportGroupSales:
+				groupID[group] = "$sales_id"
+			case models.ReportGroupProduct:
+				groupID[group] = "$items.product_name"
+			case models.ReportGroupStatus:
+				groupID[group] = "$status"
+			default:
+				groupID[group] = bson.M{"$dateToString": bson.M{"format": dateFormats[group], "date": "$created_at", "timezone": Timezone}}
+			}
+		}
+	}
+
+	pipeline = append(pipeline, bson.M{"$group": bson.M{
+		"_id":      groupID,
+		"revenue":  bson.M{"$sum": revenue},
+		"quantity": bson.M{"$sum": quantity},
+		"orders":   bson.M{"$addToSet": "$_id"},
+	}})
+	pipeline = append(pipeline, bson.M{"$project": bson.M{
+		"revenue":  1,
+		"quantity": 1,
+		"orders":   bson.M{"$size": "$orders"},
+	}})
+
+	cursor, err := database.GetMongoCollection("orders").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		ID       map[string]interface{} `bson:"_id"`
+		Revenue  float64                `bson:"revenue"`
+		Quantity float64                `bson:"quantity"`
+		Orders   int                    `bson:"orders"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	rows := []*models.ReportRow{}
+	for _, result := range results {
+		row := &models.ReportRow{Group: map[string]string{}, Metrics: map[string]float64{}}
+		for key, value := range result.ID {
+			row.Group[key] = fmt.Sprint(value)
+		}
+		for _, metric := range def.Metrics {
+			switch metric {
+			case models.ReportMetricRevenue:
+				row.Metrics[metric] = result.Revenue
+			case models.ReportMetricOrders:
+				row.Metrics[metric] = float64(result.Orders)
+			case models.ReportMetricQuantity:
+				row.Metrics[metric] = result.Quantity
+			case models.ReportMetricAvgOrderValue:
+				if result.Orders > 0 {
+					row.Metrics[metric] = math.Round(result.Revenue/float64(result.Orders)*100) / 100
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func salesNames(ctx context.Context, rows []*models.ReportRow) map[string]string {
+	ids := []primitive.ObjectID{}
+	for _, row := range rows {
+		if id, err := primitive.ObjectIDFromHex(row.Group[models.ReportGroupSales]); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	names := map[string]string{}
+	if len(ids) == 0 {
+		return names
+	}
+
+	cursor, err := database.GetMongoCollection("sales").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return names
+	}
+	defer cursor.Close(ctx)
+
+	var sales []models.Sales
+	if err := cursor.All(ctx, &sales); err != nil {
+		return names
+	}
+	for _, s := range sales {
+		names[s.ID.Hex()] = s.Name
+	}
+	return names
+}
+
+// Execute builds a report, stores the run and, when deliver is set, sends it to the recipients
+func Execute(ctx context.Context, def *models.ReportDefinition, trigger string, runBy string, from time.Time, to time.Time, deliver bool) (*models.ReportRun, error) {
+	run, err := Build(ctx, def, from, to)
+	if err != nil {
+		return nil, err
+	}
+	run.Trigger = trigger
+	run.RunBy = runBy
+
+	if deliver {
+		run.Deliveries = Deliver(run, def.Recipients)
+	}
+
+	if _, err := database.GetMongoCollection(RunsCollection).InsertOne(ctx, run); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	update := bson.M{"last_run_at": now}
+	if trigger == models.ReportTriggerSchedule && def.Schedule != nil {
+		update["schedule.next_run_at"] = NextRun(def.Schedule, now)
+	}
+	database.GetMongoCollection(Collection).UpdateOne(ctx, bson.M{"_id": def.ID}, bson.M{"$set": update})
+
+	return run, nil
+}
+
+// Deliver sends a run: email recipients get a summary and the CSV, WhatsApp recipients the summary
+func Deliver(run *models.ReportRun, recipients []models.ReportRecipient) []models.ReportDelivery {
+	deliveries := []models.ReportDelivery{}
+	summary := Summary(run)
+
+	emails := []string{}
+	for _, recipient := range recipients {
+		if recipient.Channel == models.ReportChannelEmail {
+			emails = append(emails, recipient.Address)
+			continue
+		}
+
+		delivery := models.ReportDelivery{Channel: recipient.Channel, Address: recipient.Address, Sent: true}
+		if _, err := notification.SendReportNotification(recipient.Address, summary); err != nil {
+			delivery.Sent = false
+			delivery.Error = err.Error()
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if len(emails) > 0 {
+		err := mailer.Send(emails, "Laporan: "+run.Name, summary, mailer.Attachment{
+			Name:        fileName(run),
+			ContentType: "text/csv",
+			Data:        CSV(run),
+		})
+		for _, email := range emails {
+			delivery := models.ReportDelivery{Channel: models.ReportChannelEmail, Address: email, Sent: err == nil}
+			if err != nil {
+				delivery.Error = err.Error()
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return deliveries
+}
+
+// Summary renders a run as a short text message
+func Summary(run *models.ReportRun) string {
+	loc := location()
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Laporan: %s\n", run.Name)
+	fmt.Fprintf(&b, "Periode: %s - %s\n\n", run.From.In(loc).Format("02/01/2006"), run.To.In(loc).Format("02/01/2006"))
+
+	limit := 20
+	for i, row := range run.Rows {
+		if i == limit {
+			fmt.Fprintf(&b, "... dan %d baris lainnya\n", len(run.Rows)-limit)
+			break
+		}
+		labels := []string{}
+		for _, group := range run.GroupBy {
+			labels = append(labels, row.Group[group])
+		}
+		fmt.Fprintf(&b, "%s: %s\n", strings.Join(labels, " / "), formatMetrics(run.Metrics, row.Metrics))
+	}
+
+	fmt.Fprintf(&b, "\nTotal: %s", formatMetrics(run.Metrics, run.Totals))
+	return b.String()
+}
+
+// CSV renders a run with one column per group-by and metric
+func CSV(run *models.ReportRun) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	header := append(append([]string{}, run.GroupBy...), run.Metrics...)
+	writer.Write(header)
+
+	for _, row := range run.Rows {
+		record := []string{}
+		for _, group := range run.GroupBy {
+			record = append(record, row.Group[group])
+		}
+		for _, metric := range run.Metrics {
+			record = append(record, strconv.FormatFloat(row.Metrics[metric], 'f', -1, 64))
+		}
+		writer.Write(record)
+	}
+
+	writer.Flush()
+	return buf.Bytes()
+}
+
+func fileName(run *models.ReportRun) string {
+	name := strings.ToLower(strings.Join(strings.Fields(run.Name), "-"))
+	return fmt.Sprintf("%s-%s.csv", name, run.CreatedAt.In(location()).Format("20060102"))
+}
+
+func formatMetrics(metrics []string, values map[string]float64) string {
+	parts := []string{}
+	for _, metric := range metrics {
+		value := values[metric]
+		switch metric {
+		case models.ReportMetricRevenue, models.ReportMetricAvgOrderValue:
+			parts = append(parts, fmt.Sprintf("%s Rp %.0f", metric, value))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %.0f", metric, value))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RunDue runs and delivers every scheduled report whose time has come; it is registered as a cron job
+func RunDue() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := database.GetMongoCollection(Collection).Find(ctx, bson.M{"schedule.next_run_at": bson.M{"$lte": now}})
+	if err != nil {
+		log.Printf("[Reports] Failed to load due reports: %v", err)
+		return
+	}
+	var defs []models.ReportDefinition
+	if err := cursor.All(ctx, &defs); err != nil {
+		log.Printf("[Reports] Failed to decode due reports: %v", err)
+		return
+	}
+
+	for i := range defs {
+		def := &defs[i]
+		from, to := Period(def, now)
+		if _, err := Execute(ctx, def, models.ReportTriggerSchedule, "", from, to, true); err != nil {
+			log.Printf("[Reports] Scheduled run of %q failed: %v", def.Name, err)
+			go chatalert.Fire(chatalert.Alert{
+				Type:   chatalert.TypeExportFailed,
+				Title:  "Scheduled report failed",
+				Text:   fmt.Sprintf("The scheduled run of %q failed: %v", def.Name, err),
+				Fields: []chatalert.Field{{Name: "Period", Value: from.Format("02/01/2006") + " - " + to.Format("02/01/2006")}},
+			})
+			// Skip to the next slot instead of retrying on every tick
+			database.GetMongoCollection(Collection).UpdateOne(ctx, bson.M{"_id": def.ID}, bson.M{
+				"$set": bson.M{"schedule.next_run_at": NextRun(def.Schedule, now)},
+			})
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
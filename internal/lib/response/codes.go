@@ -0,0 +1,278 @@
+package response
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Code is a machine-readable error code returned in the error_code field
+type Code string
+
+// Generic codes, used when a handler does not give a more specific one
+const (
+	CodeBadRequest         Code = "BAD_REQUEST"
+	CodeUnauthorized       Code = "UNAUTHORIZED"
+	CodeForbidden          Code = "FORBIDDEN"
+	CodeNotFound           Code = "NOT_FOUND"
+	CodeConflict           Code = "CONFLICT"
+	CodeTooManyRequests    Code = "TOO_MANY_REQUESTS"
+	CodeInternal           Code = "INTERNAL_ERROR"
+	CodeServiceUnavailable Code = "SERVICE_UNAVAILABLE"
+)
+
+// Request codes
+const (
+	CodeInvalidID          Code = "INVALID_ID"
+	CodeInvalidBody        Code = "INVALID_BODY"
+	CodeValidationFailed   Code = "VALIDATION_FAILED"
+	CodeFileRequired       Code = "FILE_REQUIRED"
+	CodeFileTypeNotAllowed Code = "FILE_TYPE_NOT_ALLOWED"
+	CodeLinkTokenRequired  Code = "LINK_TOKEN_REQUIRED"
+	CodeOTPRequired        Code = "OTP_REQUIRED"
+	CodeOTPInvalid         Code = "OTP_INVALID"
+	CodeOTPExpired         Code = "OTP_EXPIRED"
+	CodeConfirmRequired    Code = "CONFIRMATION_REQUIRED"
+)
+
+// Auth codes
+const (
+	CodeTokenMissing            Code = "TOKEN_MISSING"
+	CodeTokenInvalid            Code = "TOKEN_INVALID"
+	CodeTokenExpired            Code = "TOKEN_EXPIRED"
+	CodeRefreshTokenInvalid     Code = "REFRESH_TOKEN_INVALID"
+	CodeSessionRevoked          Code = "SESSION_REVOKED"
+	CodeInvalidCredentials      Code = "INVALID_CREDENTIALS"
+	CodeAccountDeactivated      Code = "ACCOUNT_DEACTIVATED"
+	CodeUsernameTaken           Code = "USERNAME_TAKEN"
+	CodeInsufficientPermissions Code = "INSUFFICIENT_PERMISSIONS"
+	CodeDeviceTokenInvalid      Code = "DEVICE_TOKEN_INVALID"
+	CodeImpersonationNotAllowed Code = "IMPERSONATION_NOT_ALLOWED"
+	CodeUserNotFound            Code = "USER_NOT_FOUND"
+	CodeInviteInvalid           Code = "INVITE_INVALID"
+	CodeInviteExpired           Code = "INVITE_EXPIRED"
+	CodeSignatureInvalid        Code = "SIGNATURE_INVALID"
+)
+
+// Order and queue codes
+const (
+	CodeOrderNotFound       Code = "ORDER_NOT_FOUND"
+	CodeOrderInvalidStatus  Code = "ORDER_INVALID_STATUS"
+	CodeOrderNotAccepted    Code = "ORDER_NOT_ACCEPTED"
+	CodePriceNoteRequired   Code = "PRICE_NOTE_REQUIRED"
+	CodeCreditLimitExceeded Code = "CREDIT_LIMIT_EXCEEDED"
+	CodeQueueBusy           Code = "QUEUE_BUSY"
+	CodeQueueEmpty          Code = "QUEUE_EMPTY"
+	CodeOrderSplit          Code = "ORDER_SPLIT"
+	CodeSplitExceeded       Code = "SPLIT_EXCEEDED"
+	CodeOrderNumberTaken    Code = "ORDER_NUMBER_TAKEN"
+	CodeOrderChangePending  Code = "ORDER_CHANGE_PENDING"
+	CodeCancelNotFound      Code = "CANCEL_REQUEST_NOT_FOUND"
+	CodeCancelPending       Code = "CANCEL_REQUEST_PENDING"
+)
+
+// Payment codes
+const (
+	CodePaymentAlreadyVerified Code = "PAYMENT_ALREADY_VERIFIED"
+	CodePaymentNotPending      Code = "PAYMENT_NOT_PENDING"
+	CodePaymentNotVerified     Code = "PAYMENT_NOT_VERIFIED"
+	CodePaymentProofMissing    Code = "PAYMENT_PROOF_MISSING"
+	CodePaymentInvalidStatus   Code = "PAYMENT_INVALID_STATUS"
+	CodePaymentSameApprover    Code = "PAYMENT_SAME_APPROVER"
+	CodePaymentNotAwaiting     Code = "PAYMENT_NOT_AWAITING_APPROVAL"
+	CodePaymentClaimed         Code = "PAYMENT_CLAIMED"
+)
+
+// Codes of the other resources
+const (
+	CodeDeliveryNoteNotFound     Code = "DELIVERY_NOTE_NOT_FOUND"
+	CodeDeliveryNoteSuperseded   Code = "DELIVERY_NOTE_SUPERSEDED"
+	CodeDeliveryNoteNotReady     Code = "DELIVERY_NOTE_NOT_READY"
+	CodeSalesNotFound            Code = "SALES_NOT_FOUND"
+	CodeSalesAlreadyMerged       Code = "SALES_ALREADY_MERGED"
+	CodeSalesPhoneTaken          Code = "SALES_PHONE_TAKEN"
+	CodeProductNotFound          Code = "PRODUCT_NOT_FOUND"
+	CodeComplaintNotFound        Code = "COMPLAINT_NOT_FOUND"
+	CodeComplaintClosed          Code = "COMPLAINT_CLOSED"
+	CodeTagNotFound              Code = "TAG_NOT_FOUND"
+	CodeTagExists                Code = "TAG_EXISTS"
+	CodeReportNotFound           Code = "REPORT_NOT_FOUND"
+	CodeViewNotFound             Code = "VIEW_NOT_FOUND"
+	CodeDeviceNotFound           Code = "DEVICE_NOT_FOUND"
+	CodeExportNotReady           Code = "EXPORT_NOT_READY"
+	CodeWhatsAppNotReady         Code = "WHATSAPP_NOT_READY"
+	CodeWhatsAppSessionInvalid   Code = "WHATSAPP_SESSION_INVALID"
+	CodeAddressNotFound          Code = "ADDRESS_NOT_FOUND"
+	CodeDeliveryOutOfArea        Code = "DELIVERY_OUT_OF_AREA"
+	CodeShortLinkNotFound        Code = "SHORT_LINK_NOT_FOUND"
+	CodeShortLinkExpired         Code = "SHORT_LINK_EXPIRED"
+	CodeSettingsRevisionNotFound Code = "SETTINGS_REVISION_NOT_FOUND"
+	CodeUnitNotFound             Code = "UNIT_NOT_FOUND"
+	CodeUnitUnknown              Code = "UNIT_UNKNOWN"
+	CodeHookNotFound             Code = "HOOK_NOT_FOUND"
+	CodeMediaLinkInvalid         Code = "MEDIA_LINK_INVALID"
+	CodeMediaLinkExpired         Code = "MEDIA_LINK_EXPIRED"
+	CodeSurveyNotFound           Code = "SURVEY_NOT_FOUND"
+	CodeSurveyAnswered           Code = "SURVEY_ANSWERED"
+)
+
+// CodeInfo describes an error code in the catalog; Status is the HTTP status it usually comes with
+type CodeInfo struct {
+	Code        Code   `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// Catalog lists every error code the API returns
+var Catalog = []CodeInfo{
+	{CodeBadRequest, 400, "The request is invalid"},
+	{CodeUnauthorized, 401, "Authentication is required"},
+	{CodeForbidden, 403, "The action is not allowed"},
+	{CodeNotFound, 404, "The resource does not exist"},
+	{CodeConflict, 409, "The request conflicts with the current state"},
+	{CodeTooManyRequests, 429, "Too many requests, retry later"},
+	{CodeInternal, 500, "Unexpected server error"},
+	{CodeServiceUnavailable, 503, "A dependency is temporarily unavailable, retry shortly"},
+
+	{CodeInvalidID, 400, "An ID in the path or body is not a valid ID"},
+	{CodeInvalidBody, 400, "The request body could not be parsed"},
+	{CodeValidationFailed, 400, "A required field is missing or a field is invalid"},
+	{CodeFileRequired, 400, "The request has no file"},
+	{CodeFileTypeNotAllowed, 400, "The uploaded file type is not allowed"},
+	{CodeLinkTokenRequired, 400, "The client link token is missing"},
+	{CodeOTPRequired, 400, "A verification code is required, request one first"},
+	{CodeOTPInvalid, 400, "The verification code is wrong or was already used"},
+	{CodeOTPExpired, 400, "The verification code has expired, request a new one"},
+	{CodeConfirmRequired, 400, "A destructive run needs the confirm token of a dry run of the same changes"},
+
+	{CodeTokenMissing, 401, "No access token was sent"},
+	{CodeTokenInvalid, 401, "The access token is malformed or its signature is invalid"},
+	{CodeTokenExpired, 401, "The access token has expired, refresh it"},
+	{CodeRefreshTokenInvalid, 401, "The refresh token is missing, invalid or expired"},
+	{CodeSessionRevoked, 401, "The session was revoked, log in again"},
+	{CodeInvalidCredentials, 400, "Username or password is wrong"},
+	{CodeAccountDeactivated, 403, "The account is deactivated"},
+	{CodeUsernameTaken, 409, "The username is already used"},
+	{CodeInsufficientPermissions, 403, "The user's role may not use this endpoint"},
+	{CodeDeviceTokenInvalid, 401, "The device token is invalid or revoked"},
+	{CodeImpersonationNotAllowed, 400, "The user cannot be impersonated"},
+	{CodeUserNotFound, 404, "The user does not exist"},
+	{CodeInviteInvalid, 400, "The invite link is invalid or was already used"},
+	{CodeInviteExpired, 410, "The invite has expired, ask an admin to resend it"},
+	{CodeSignatureInvalid, 401, "The webhook call is unsigned, wrongly signed or too old"},
+
+	{CodeOrderNotFound, 200, "The order does not exist"},
+	{CodeOrderInvalidStatus, 400, "The order's status does not allow this action"},
+	{CodeOrderNotAccepted, 400, "The sales must accept the order before uploading payment"},
+	{CodePriceNoteRequired, 400, "An item's unit price deviates from the product price and needs a price note"},
+	{CodeCreditLimitExceeded, 400, "The order would take the sales' outstanding balance past the credit limit"},
+	{CodeQueueBusy, 400, "Another order is being loaded"},
+	{CodeQueueEmpty, 200, "There are no orders in the queue"},
+	{CodeOrderSplit, 400, "The order is split into shipments, which are queued instead"},
+	{CodeSplitExceeded, 400, "The shipments would carry more of an item than the order has left"},
+	{CodeOrderNumberTaken, 409, "Another order already has the order number"},
+	{CodeOrderChangePending, 400, "An edit of the order's items awaits the sales' confirmation"},
+	{CodeCancelNotFound, 200, "The cancellation request does not exist"},
+	{CodeCancelPending, 409, "The order already has a cancellation request waiting for review"},
+
+	{CodePaymentAlreadyVerified, 400, "The payment was already verified"},
+	{CodePaymentNotPending, 400, "The payment is not pending verification"},
+	{CodePaymentNotVerified, 400, "The payment must be verified first"},
+	{CodePaymentProofMissing, 400, "No payment proof was uploaded"},
+	{CodePaymentInvalidStatus, 400, "The payment status is not valid"},
+	{CodePaymentSameApprover, 400, "The second approval must come from a different admin"},
+	{CodePaymentNotAwaiting, 400, "The payment is not waiting for a second approval"},
+	{CodePaymentClaimed, 409, "Another admin has claimed the payment"},
+
+	{CodeDeliveryNoteNotFound, 200, "The delivery note does not exist"},
+	{CodeDeliveryNoteSuperseded, 400, "The delivery note was replaced by a newer revision"},
+	{CodeDeliveryNoteNotReady, 200, "The order has no delivery note yet"},
+	{CodeSalesNotFound, 200, "The sales does not exist"},
+	{CodeSalesAlreadyMerged, 400, "The sales was merged into another sales"},
+	{CodeSalesPhoneTaken, 409, "Another active sales already uses the phone number"},
+	{CodeProductNotFound, 200, "The product does not exist"},
+	{CodeComplaintNotFound, 200, "The complaint does not exist"},
+	{CodeComplaintClosed, 400, "The complaint is closed or not open"},
+	{CodeTagNotFound, 200, "The tag does not exist"},
+	{CodeTagExists, 400, "A tag with this name already exists"},
+	{CodeReportNotFound, 200, "The report does not exist"},
+	{CodeViewNotFound, 200, "The saved view does not exist"},
+	{CodeDeviceNotFound, 200, "The device does not exist"},
+	{CodeExportNotReady, 400, "The export has not finished yet"},
+	{CodeWhatsAppNotReady, 500, "WhatsApp is not initialized or not logged in"},
+	{CodeWhatsAppSessionInvalid, 400, "The file is not a WhatsApp session or cannot be decrypted"},
+	{CodeAddressNotFound, 400, "The delivery address could not be geocoded"},
+	{CodeDeliveryOutOfArea, 400, "The delivery address is beyond the delivery fee table"},
+	{CodeShortLinkNotFound, 404, "The short link does not exist"},
+	{CodeShortLinkExpired, 410, "The short link has expired"},
+	{CodeSettingsRevisionNotFound, 200, "The settings version does not exist"},
+	{CodeUnitNotFound, 200, "The unit does not exist"},
+	{CodeUnitUnknown, 400, "The unit is not in the unit registry, see the suggestions"},
+	{CodeHookNotFound, 200, "The REST hook subscription does not exist"},
+	{CodeMediaLinkInvalid, 403, "The image link is not valid"},
+	{CodeMediaLinkExpired, 403, "The image link has expired, reload the page for a new one"},
+	{CodeSurveyNotFound, 200, "The satisfaction survey does not exist"},
+	{CodeSurveyAnswered, 400, "The satisfaction survey was already answered"},
+}
+
+// codeForStatus returns the generic code of an HTTP status
+func codeForStatus(status int) Code {
+	switch {
+	case status == fiber.StatusUnauthorized:
+		return CodeUnauthorized
+	case status == fiber.StatusForbidden:
+		return CodeForbidden
+	case status == fiber.StatusNotFound:
+		return CodeNotFound
+	case status == fiber.StatusConflict:
+		return CodeConflict
+	case status == fiber.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case status == fiber.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	case status >= 500:
+		return CodeInternal
+	default:
+		return CodeBadRequest
+	}
+}
+
+// ErrorCode sends an error response with a specific error code
+func ErrorCode(c *fiber.Ctx, status int, code Code, message string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"status":     status,
+		"message":    message,
+		"error_code": code,
+	})
+}
+
+// ErrorCodeWithData sends an error response with a specific error code and additional data
+func ErrorCodeWithData(c *fiber.Ctx, status int, code Code, message string, data interface{}) error {
+	return c.Status(status).JSON(fiber.Map{
+		"status":     status,
+		"message":    message,
+		"data":       data,
+		"error_code": code,
+	})
+}
+
+// NotFoundCode sends a not found response, like NotFound, with a specific error code
+func NotFoundCode(c *fiber.Ctx, code Code, message string) error {
+	return c.Status(200).JSON(fiber.Map{
+		"status":     200,
+		"message":    message,
+		"data":       nil,
+		"error_code": code,
+	})
+}
+
+// ErrorHandler is the Fiber error handler, so errors no handler answered (unknown
+// routes, body limit, recovered panics) use the same shape and carry an error code
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	return Error(c, status, err.Error())
+}
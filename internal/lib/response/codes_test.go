@@ -0,0 +1,66 @@
+package response
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+)
+
+func TestCatalogListsEveryCode(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "codes.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parse codes.go: %v", err)
+	}
+	declared := map[Code]string{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			code, _ := strconv.Unquote(value.Values[0].(*ast.BasicLit).Value)
+			declared[Code(code)] = value.Names[0].Name
+		}
+	}
+
+	listed := map[Code]bool{}
+	for _, info := range Catalog {
+		if listed[info.Code] {
+			t.Fatalf("%s is listed twice", info.Code)
+		}
+		if info.Status == 0 || info.Description == "" {
+			t.Fatalf("%s has no status or description", info.Code)
+		}
+		listed[info.Code] = true
+	}
+	for code, name := range declared {
+		if !listed[code] {
+			t.Fatalf("%s (%s) is not in the catalog", name, code)
+		}
+	}
+	if len(listed) != len(declared) {
+		t.Fatalf("%d codes declared, %d in the catalog", len(declared), len(listed))
+	}
+}
+
+func TestCodeForStatus(t *testing.T) {
+	for status, want := range map[int]Code{
+		400: CodeBadRequest,
+		401: CodeUnauthorized,
+		403: CodeForbidden,
+		404: CodeNotFound,
+		409: CodeConflict,
+		410: CodeBadRequest,
+		429: CodeTooManyRequests,
+		500: CodeInternal,
+		502: CodeInternal,
+		503: CodeServiceUnavailable,
+	} {
+		if got := codeForStatus(status); got != want {
+			t.Fatalf("%d: got %s, want %s", status, got, want)
+		}
+	}
+}
@@ -48,20 +48,18 @@ func SuccessWithPagination(c *fiber.Ctx, status int, data interface{}, paginatio
 	})
 }
 
-// Error sends an error response
+// Error sends an error response with the generic error code of the status
 func Error(c *fiber.Ctx, status int, message string) error {
-	return c.Status(status).JSON(fiber.Map{
-		"status":  status,
-		"message": message,
-	})
+	return ErrorCode(c, status, codeForStatus(status), message)
 }
 
 // ErrorWithData sends an error response with additional data
 func ErrorWithData(c *fiber.Ctx, status int, message string, data interface{}) error {
 	return c.Status(status).JSON(fiber.Map{
-		"status":  status,
-		"message": message,
-		"data":    data,
+		"status":     status,
+		"message":    message,
+		"data":       data,
+		"error_code": codeForStatus(status),
 	})
 }
 
@@ -70,11 +68,7 @@ func NotFound(c *fiber.Ctx, message string) error {
 	if message == "" {
 		message = "Resource not found"
 	}
-	return c.Status(200).JSON(fiber.Map{
-		"status":  200,
-		"message": message,
-		"data":    nil,
-	})
+	return NotFoundCode(c, CodeNotFound, message)
 }
 
 // Unauthorized sends a 401 response
@@ -82,10 +76,7 @@ func Unauthorized(c *fiber.Ctx, message string) error {
 	if message == "" {
 		message = "Unauthorized"
 	}
-	return c.Status(401).JSON(fiber.Map{
-		"status":  401,
-		"message": message,
-	})
+	return ErrorCode(c, 401, CodeUnauthorized, message)
 }
 
 // BadRequest sends a 400 response
@@ -93,10 +84,7 @@ func BadRequest(c *fiber.Ctx, message string) error {
 	if message == "" {
 		message = "Bad request"
 	}
-	return c.Status(400).JSON(fiber.Map{
-		"status":  400,
-		"message": message,
-	})
+	return ErrorCode(c, 400, CodeBadRequest, message)
 }
 
 // InternalError sends a 500 response
@@ -104,10 +92,7 @@ func InternalError(c *fiber.Ctx, message string) error {
 	if message == "" {
 		message = "Internal server error"
 	}
-	return c.Status(500).JSON(fiber.Map{
-		"status":  500,
-		"message": message,
-	})
+	return ErrorCode(c, 500, CodeInternal, message)
 }
 
 // CalculatePagination creates pagination info
@@ -130,3 +115,16 @@ func CalculatePagination(currentPage, perPage, totalItems int64) *Pagination {
 		HasPrev:     currentPage > 1,
 	}
 }
+
+// CalculatePaginationWithoutTotal creates pagination info for a list fetched without
+// counting; TotalPages and TotalItems are -1 because they are unknown
+func CalculatePaginationWithoutTotal(currentPage, perPage int64, hasNext bool) *Pagination {
+	return &Pagination{
+		CurrentPage: int(currentPage),
+		TotalPages:  -1,
+		TotalItems:  -1,
+		PerPage:     int(perPage),
+		HasNext:     hasNext,
+		HasPrev:     currentPage > 1,
+	}
+}
@@ -0,0 +1,204 @@
+package resthook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Collection holds the REST hook subscriptions
+const Collection = "rest_hooks"
+
+// PaymentUpdated fires for order updates that change the payment (proof uploaded,
+// approved, verified or rejected). It is not an event of its own; it is derived from
+// events.OrderUpdated.
+const PaymentUpdated = "payment.updated"
+
+// Triggers lists the events a hook can subscribe to
+var Triggers = []string{
+	events.OrderCreated,
+	events.OrderUpdated,
+	events.OrderOverdue,
+	events.InvoiceUnviewed,
+	events.CSATLowScore,
+	PaymentUpdated,
+}
+
+// deliveryTimeout bounds one POST to a subscriber
+const deliveryTimeout = 10 * time.Second
+
+var client = &http.Client{Timeout: deliveryTimeout}
+
+// Hook is a subscription of an integration (Zapier, Make) to an event; every matching
+// event is POSTed as JSON to TargetURL
+type Hook struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	Event           string             `json:"event" bson:"event"`
+	TargetURL       string             `json:"target_url" bson:"target_url"`
+	CreatedBy       string             `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	LastDeliveredAt *time.Time         `json:"last_delivered_at,omitempty" bson:"last_delivered_at,omitempty"`
+	LastStatus      int                `json:"last_status,omitempty" bson:"last_status,omitempty"`
+	LastError       string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	Failures        int                `json:"failures" bson:"failures"` // In a row
+}
+
+// IsTrigger reports whether hooks can subscribe to an event
+func IsTrigger(event string) bool {
+	for _, trigger := range Triggers {
+		if trigger == event {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderRecord flattens an order for spreadsheets and no-code tools. Its id changes with
+// every update, so pollers that deduplicate by id see each update once.
+func OrderRecord(order *models.Order) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             fmt.Sprintf("%s-%d", order.ID.Hex(), order.UpdatedAt.UnixMilli()),
+		"order_id":       order.ID.Hex(),
+		"order_number":   order.OrderNumber,
+		"status":         order.Status,
+		"payment_status": order.PaymentStatus,
+		"sales_id":       order.SalesID,
+		"customer_name":  order.CustomerName,
+		"customer_phone": order.CustomerPhone,
+		"quantity":       order.Quantity,
+		"total_price":    order.TotalPrice,
+		"due_date":       order.DueDate,
+		"overdue":        order.Overdue,
+		"invoice_url":    order.InvoiceURL,
+		"created_at":     order.CreatedAt,
+		"updated_at":     order.UpdatedAt,
+	}
+}
+
+// Start delivers events to the subscribed hooks. Call it before the order feed starts.
+func Start() {
+	events.Subscribe(events.All, deliver)
+}
+
+// triggers returns the hook events an event fires
+func triggers(event events.Event) []string {
+	fired := []string{}
+	if IsTrigger(event.Type) {
+		fired = append(fired, event.Type)
+	}
+	if event.Type == events.OrderUpdated && touchesPayment(event) {
+		fired = append(fired, PaymentUpdated)
+	}
+	return fired
+}
+
+// touchesPayment reports whether an order update changed its payment. Polled feeds do
+// not know the updated fields, so every update of an order with a payment proof counts.
+func touchesPayment(event events.Event) bool {
+	fields, known := event.Data["updated_fields"].([]string)
+	if !known {
+		order, _ := event.Data["order"].(*models.Order)
+		return order != nil && order.PaymentStatus != models.PaymentStatusPending
+	}
+	for _, field := range fields {
+		if strings.HasPrefix(field, "payment_") {
+			return true
+		}
+	}
+	return false
+}
+
+// payload is the body POSTed for an event; order events carry the flattened order
+func payload(trigger string, event events.Event) map[string]interface{} {
+	var data interface{} = event.Data
+	if order, ok := event.Data["order"].(*models.Order); ok && order != nil {
+		data = OrderRecord(order)
+	}
+	return map[string]interface{}{
+		"id":          event.ID.Hex(),
+		"event":       trigger,
+		"resource":    event.Resource,
+		"resource_id": event.ResourceID,
+		"occurred_at": event.CreatedAt,
+		"data":        data,
+	}
+}
+
+// deliver POSTs an event to every hook subscribed to one of its triggers
+func deliver(event events.Event) {
+	fired := triggers(event)
+	if len(fired) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	collection := database.GetMongoCollection(Collection)
+	var hooks []Hook
+	cursor, err := collection.Find(ctx, bson.M{"event": bson.M{"$in": fired}})
+	if err == nil {
+		err = cursor.All(ctx, &hooks)
+		cursor.Close(ctx)
+	}
+	cancel()
+	if err != nil {
+		log.Printf("[RestHook] Failed to load hooks for %s: %v", event.Type, err)
+		return
+	}
+
+	for _, hook := range hooks {
+		post(&hook, payload(hook.Event, event))
+	}
+}
+
+// post sends one payload to a hook and records the outcome. A 410 Gone response means
+// the integration was switched off, so the hook is removed as REST hooks expect.
+func post(hook *Hook, body map[string]interface{}) {
+	raw, _ := json.Marshal(body)
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	status := 0
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.TargetURL, bytes.NewReader(raw))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		var resp *http.Response
+		if resp, err = client.Do(req); err == nil {
+			status = resp.StatusCode
+			resp.Body.Close()
+			if status >= 300 {
+				err = fmt.Errorf("target responded %d", status)
+			}
+		}
+	}
+
+	// A slow target may have used up ctx
+	recordCtx, recordCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer recordCancel()
+
+	collection := database.GetMongoCollection(Collection)
+	if status == http.StatusGone {
+		collection.DeleteOne(recordCtx, bson.M{"_id": hook.ID})
+		log.Printf("[RestHook] %s unsubscribed %s", hook.TargetURL, hook.Event)
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"last_delivered_at": now, "last_status": status, "failures": 0}, "$unset": bson.M{"last_error": ""}}
+	if err != nil {
+		update = bson.M{"$set": bson.M{"last_delivered_at": now, "last_status": status, "last_error": err.Error()}, "$inc": bson.M{"failures": 1}}
+		log.Printf("[RestHook] Failed to deliver %s to %s: %v", hook.Event, hook.TargetURL, err)
+	}
+	collection.UpdateOne(recordCtx, bson.M{"_id": hook.ID}, update)
+}
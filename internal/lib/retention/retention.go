@@ -0,0 +1,225 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// target is a place where a retention field is stored
+type target struct {
+	Collection string
+	Path       string
+	DateField  string // Age is measured from this field
+	Filter     bson.M // Extra conditions, e.g. only orders in a final state
+}
+
+var finalOrder = bson.M{"status": bson.M{"$in": []string{models.OrderStatusCompleted, models.OrderStatusCancelled, models.OrderStatusDeclined}}}
+var finalReturn = bson.M{"status": bson.M{"$in": []string{models.ReturnStatusResolved, models.ReturnStatusRejected}}}
+
+// targets lists every copy of each personal data field, including snapshots
+var targets = map[string][]target{
+	models.RetentionFieldDriverPhone: {
+		{Collection: "orders", Path: "driver_phone", DateField: "updated_at", Filter: finalOrder},
+		{Collection: archive.Collection, Path: "driver_phone", DateField: "updated_at"},
+		{Collection: "delivery_notes", Path: "driver_phone", DateField: "created_at"},
+	},
+	models.RetentionFieldCustomerPhone: {
+		{Collection: "orders", Path: "customer_phone", DateField: "updated_at", Filter: finalOrder},
+		{Collection: archive.Collection, Path: "customer_phone", DateField: "updated_at"},
+	},
+	models.RetentionFieldSalesPhone: {
+		{Collection: "delivery_notes", Path: "sales_phone", DateField: "created_at"},
+		{Collection: "returns", Path: "sales_phone", DateField: "updated_at", Filter: finalReturn},
+	},
+	models.RetentionFieldPaymentProof: {
+		{Collection: "orders", Path: "payment_proof", DateField: "updated_at", Filter: finalOrder},
+		{Collection: archive.Collection, Path: "payment_proof", DateField: "updated_at"},
+	},
+	models.RetentionFieldMessage: {
+		{Collection: "notifications", Path: "message", DateField: "created_at"},
+		{Collection: "inbound_messages", Path: "text", DateField: "received_at"},
+	},
+}
+
+// Fields lists the configurable retention fields in display order
+var Fields = []string{
+	models.RetentionFieldDriverPhone,
+	models.RetentionFieldCustomerPhone,
+	models.RetentionFieldSalesPhone,
+	models.RetentionFieldPaymentProof,
+	models.RetentionFieldMessage,
+}
+
+// defaultAfterDays is the retention period of a field without a saved rule
+var defaultAfterDays = map[string]int{
+	models.RetentionFieldDriverPhone:   365,
+	models.RetentionFieldCustomerPhone: 365,
+	models.RetentionFieldSalesPhone:    730,
+	models.RetentionFieldPaymentProof:  730,
+	models.RetentionFieldMessage:       730,
+}
+
+// FieldReport is the outcome of a rule; counts are matches on a dry run and changes otherwise
+type FieldReport struct {
+	Field     string           `json:"field"`
+	AfterDays int              `json:"after_days"`
+	Cutoff    time.Time        `json:"cutoff"`
+	Counts    map[string]int64 `json:"counts"` // Per collection
+	Total     int64            `json:"total"`
+}
+
+// Report summarizes a retention run
+type Report struct {
+	DryRun bool          `json:"dry_run"`
+	RanAt  time.Time     `json:"ran_at"`
+	Fields []FieldReport `json:"fields"`
+	Total  int64         `json:"total"`
+}
+
+// LoadPolicy returns the saved policy, filling in any field without a saved rule
+func LoadPolicy(ctx context.Context) *models.RetentionPolicy {
+	policy := &models.RetentionPolicy{}
+	database.GetMongoCollection("retention_policy").FindOne(ctx, bson.M{}).Decode(policy)
+
+	saved := map[string]models.RetentionRule{}
+	for _, rule := range policy.Fields {
+		saved[rule.Field] = rule
+	}
+
+	policy.Fields = []models.RetentionRule{}
+	for _, field := range Fields {
+		rule, ok := saved[field]
+		if !ok {
+			rule = models.RetentionRule{Field: field, AfterDays: defaultAfterDays[field]}
+		}
+		policy.Fields = append(policy.Fields, rule)
+	}
+
+	return policy
+}
+
+// IsField reports whether field is a configurable retention field
+func IsField(field string) bool {
+	_, ok := targets[field]
+	return ok
+}
+
+// Apply anonymizes every enabled field of the policy. With dryRun nothing is
+// changed and the report holds the number of records that would be anonymized.
+func Apply(ctx context.Context, policy *models.RetentionPolicy, dryRun bool) (*Report, error) {
+	now := time.Now()
+	report := &Report{DryRun: dryRun, RanAt: now, Fields: []FieldReport{}}
+
+	for _, rule := range policy.Fields {
+		if !rule.Enabled || rule.AfterDays <= 0 {
+			continue
+		}
+
+		fieldReport := FieldReport{
+			Field:     rule.Field,
+			AfterDays: rule.AfterDays,
+			Cutoff:    now.AddDate(0, 0, -rule.AfterDays),
+			Counts:    map[string]int64{},
+		}
+
+		for _, t := range targets[rule.Field] {
+			filter := bson.M{
+				t.DateField: bson.M{"$lt": fieldReport.Cutoff},
+				t.Path:      bson.M{"$nin": []interface{}{nil, ""}},
+			}
+			for key, value := range t.Filter {
+				filter[key] = value
+			}
+
+			count, err := apply(ctx, rule.Field, t, filter, dryRun)
+			if err != nil {
+				return report, err
+			}
+			fieldReport.Counts[t.Collection] += count
+			fieldReport.Total += count
+		}
+
+		report.Fields = append(report.Fields, fieldReport)
+		report.Total += fieldReport.Total
+	}
+
+	if !dryRun {
+		database.GetMongoCollection("retention_policy").UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"last_run_at": now}})
+	}
+
+	return report, nil
+}
+
+// apply counts or anonymizes the matching records of one target
+func apply(ctx context.Context, field string, t target, filter bson.M, dryRun bool) (int64, error) {
+	collection := database.GetMongoCollection(t.Collection)
+
+	if dryRun {
+		return collection.CountDocuments(ctx, filter)
+	}
+
+	// Payment proofs are files; remove them from the CDN before dropping the link
+	if field == models.RetentionFieldPaymentProof {
+		cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{t.Path: 1}))
+		if err != nil {
+			return 0, err
+		}
+		var docs []struct {
+			PaymentProof *models.Image `bson:"payment_proof"`
+		}
+		err = cursor.All(ctx, &docs)
+		cursor.Close(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, doc := range docs {
+			if doc.PaymentProof != nil && doc.PaymentProof.PublicID != "" {
+				if err := file.DeleteFile(doc.PaymentProof.PublicID); err != nil {
+					log.Printf("[Retention] Failed to delete payment proof %s: %v", doc.PaymentProof.PublicID, err)
+				}
+			}
+		}
+
+		result, err := collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{t.Path: ""}})
+		if err != nil {
+			return 0, err
+		}
+		return result.ModifiedCount, nil
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{t.Path: ""}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Run applies the saved policy when it is enabled; it is registered as a cron job
+func Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	policy := LoadPolicy(ctx)
+	if !policy.Enabled {
+		return
+	}
+
+	report, err := Apply(ctx, policy, false)
+	if err != nil {
+		log.Printf("[Retention] Run failed: %v", err)
+		return
+	}
+	if report.Total > 0 {
+		log.Printf("[Retention] Anonymized %d record field(s)", report.Total)
+	}
+}
@@ -0,0 +1,99 @@
+package sandbox
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Collection holds the messages captured instead of being delivered
+const Collection = "sandbox_outbox"
+
+// Channels a message can be captured from
+const (
+	ChannelWhatsApp = "whatsapp"
+	ChannelEmail    = "email"
+	ChannelSMS      = "sms"
+)
+
+// Message is a captured outgoing message
+type Message struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Channel     string             `json:"channel" bson:"channel"`
+	To          string             `json:"to" bson:"to"`
+	Subject     string             `json:"subject,omitempty" bson:"subject,omitempty"`
+	Body        string             `json:"body" bson:"body"`
+	Attachments []string           `json:"attachments,omitempty" bson:"attachments,omitempty"` // File names only
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Enabled reports whether sandbox mode is on
+func Enabled() bool {
+	return config.Cfg.Notification.Sandbox
+}
+
+// Intercepts reports whether a message to recipient (a phone number or email address)
+// must be captured instead of delivered: sandbox mode is on and it is not allowlisted
+func Intercepts(recipient string) bool {
+	if !Enabled() {
+		return false
+	}
+	return !allowed(recipient)
+}
+
+// Allowlist returns the recipients that still receive real messages in sandbox mode
+func Allowlist() []string {
+	return config.Cfg.Notification.SandboxAllowlist
+}
+
+func allowed(recipient string) bool {
+	key := recipientKey(recipient)
+	for _, entry := range config.Cfg.Notification.SandboxAllowlist {
+		if recipientKey(entry) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// recipientKey normalizes phones so 0812... and +62812... match, and lowercases emails
+func recipientKey(recipient string) string {
+	recipient = strings.TrimSpace(recipient)
+	if strings.Contains(recipient, "@") {
+		return strings.ToLower(recipient)
+	}
+	if phone, err := utils.NormalizePhone(recipient); err == nil {
+		return phone
+	}
+	return recipient
+}
+
+// Capture stores a message in the sandbox outbox
+func Capture(channel string, to string, subject string, body string, attachments ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	message := Message{
+		ID:          primitive.NewObjectID(),
+		Channel:     channel,
+		To:          to,
+		Subject:     subject,
+		Body:        body,
+		Attachments: attachments,
+		CreatedAt:   time.Now(),
+	}
+	if _, err := database.GetMongoCollection(Collection).InsertOne(ctx, message); err != nil {
+		log.Printf("[Sandbox] Failed to capture %s message to %s: %v", channel, to, err)
+		return err
+	}
+
+	log.Printf("[Sandbox] Captured %s message to %s", channel, to)
+	return nil
+}
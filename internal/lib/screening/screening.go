@@ -0,0 +1,170 @@
+package screening
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/media"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxResponseBytes bounds the verdict read from the service
+const maxResponseBytes = 64 << 10
+
+// Request is the payload posted to the screening service
+type Request struct {
+	OrderID      string     `json:"order_id"`
+	OrderNumber  string     `json:"order_number"`
+	SalesID      string     `json:"sales_id"`
+	CustomerName string     `json:"customer_name,omitempty"`
+	TotalPrice   float64    `json:"total_price"`
+	ImageURL     string     `json:"image_url"` // Signed link, valid for the media TTL
+	UploadedAt   *time.Time `json:"uploaded_at,omitempty"`
+}
+
+// Verdict is the response of the screening service. Verdict is clean or suspicious;
+// anything else is recorded as an error.
+type Verdict struct {
+	Verdict   string   `json:"verdict"`
+	Score     float64  `json:"score"`
+	Reasons   []string `json:"reasons"`
+	Reference string   `json:"reference"`
+}
+
+// Enabled reports whether a screening service is configured
+func Enabled() bool {
+	return config.Cfg.Screening.URL != ""
+}
+
+// Screen sends the current payment proof of an order to the screening service and
+// stores its verdict on the order. It marks the order pending first, so the
+// verification list shows the check is running. Run it in the background after an
+// upload; failures are stored as an error verdict and never block verification.
+func Screen(orderID primitive.ObjectID) {
+	if !Enabled() {
+		return
+	}
+
+	cfg := config.Cfg.Screening
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout+10*time.Second)
+	defer cancel()
+
+	collection := database.GetMongoCollection("orders")
+	order := &models.Order{}
+	if err := collection.FindOne(ctx, bson.M{"_id": orderID}).Decode(order); err != nil {
+		log.Printf("[Screening] Order %s not found: %v", orderID.Hex(), err)
+		return
+	}
+	if order.PaymentProof == nil || order.PaymentProof.URL == "" {
+		return
+	}
+	proofURL := order.PaymentProof.URL
+
+	result := &models.PaymentScreening{
+		Status:      models.ScreeningPending,
+		ProofURL:    proofURL,
+		RequestedAt: time.Now(),
+	}
+	if !store(ctx, orderID, result) {
+		return
+	}
+
+	verdict, err := check(ctx, Request{
+		OrderID:      orderID.Hex(),
+		OrderNumber:  order.OrderNumber,
+		SalesID:      order.SalesID,
+		CustomerName: order.CustomerName,
+		TotalPrice:   order.TotalPrice,
+		ImageURL:     media.URL(orderID.Hex(), media.KindPaymentProof),
+		UploadedAt:   order.PaymentUploadedAt,
+	})
+
+	now := time.Now()
+	result.CheckedAt = &now
+	if err != nil {
+		log.Printf("[Screening] Failed to screen the payment of order %s: %v", order.OrderNumber, err)
+		result.Status = models.ScreeningError
+		result.Error = err.Error()
+	} else {
+		result.Status = verdict.Verdict
+		result.Score = verdict.Score
+		result.Reasons = verdict.Reasons
+		result.Reference = verdict.Reference
+	}
+	store(ctx, orderID, result)
+}
+
+// store saves a screening on an order, unless the proof was replaced since; it reports
+// whether it was saved
+func store(ctx context.Context, orderID primitive.ObjectID, result *models.PaymentScreening) bool {
+	res, err := database.GetMongoCollection("orders").UpdateOne(ctx,
+		bson.M{"_id": orderID, "payment_proof.url": result.ProofURL},
+		bson.M{"$set": bson.M{"payment_screening": result}})
+	if err != nil {
+		log.Printf("[Screening] Failed to store the screening of order %s: %v", orderID.Hex(), err)
+		return false
+	}
+	return res.MatchedCount > 0
+}
+
+// check posts a proof to the screening service and returns its verdict
+func check(ctx context.Context, payload Request) (*Verdict, error) {
+	cfg := config.Cfg.Screening
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Secret != "" {
+		req.Header.Set("X-Screening-Signature", Sign(body, cfg.Secret))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("screening service responded %d", resp.StatusCode)
+	}
+
+	verdict := &Verdict{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(verdict); err != nil {
+		return nil, fmt.Errorf("invalid verdict: %v", err)
+	}
+	verdict.Verdict = strings.ToLower(strings.TrimSpace(verdict.Verdict))
+	if verdict.Verdict != models.ScreeningClean && verdict.Verdict != models.ScreeningSuspicious {
+		return nil, fmt.Errorf("unknown verdict %q", verdict.Verdict)
+	}
+	return verdict, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of a payload, as sent in X-Screening-Signature
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSessionInvalid is returned when a session is missing, revoked, or expired
+var ErrSessionInvalid = errors.New("session is invalid or revoked")
+
+// activeCacheTTL is how long a session check of an access token is reused. Revoking on
+// this instance clears the cache; other instances notice a revocation within the TTL.
+const activeCacheTTL = 30 * time.Second
+
+// activeEntry is a cached session check
+type activeEntry struct {
+	active    bool
+	expiresAt time.Time // Session expiry, an active entry turns inactive past it
+	checkedAt time.Time
+}
+
+var (
+	activeMu    sync.Mutex
+	activeCache = map[string]activeEntry{}
+)
+
+// Active reports whether a session exists and is neither revoked nor expired. Results
+// are cached for activeCacheTTL so access tokens can be checked on every request.
+func Active(ctx context.Context, sessionID string) (bool, error) {
+	now := time.Now()
+
+	activeMu.Lock()
+	entry, ok := activeCache[sessionID]
+	activeMu.Unlock()
+	if ok && now.Sub(entry.checkedAt) < activeCacheTTL {
+		return entry.active && now.Before(entry.expiresAt), nil
+	}
+
+	objID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return false, nil
+	}
+
+	sess := &models.Session{}
+	err = database.GetMongoCollection("sessions").FindOne(ctx, bson.M{"_id": objID}).Decode(sess)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return false, err
+	}
+	entry = activeEntry{
+		active:    err == nil && sess.RevokedAt == nil,
+		expiresAt: sess.ExpiresAt,
+		checkedAt: now,
+	}
+
+	activeMu.Lock()
+	if len(activeCache) > 10000 {
+		activeCache = map[string]activeEntry{}
+	}
+	activeCache[sessionID] = entry
+	activeMu.Unlock()
+
+	return entry.active && now.Before(entry.expiresAt), nil
+}
+
+// forgetActive drops the cached session checks after a revocation
+func forgetActive() {
+	activeMu.Lock()
+	activeCache = map[string]activeEntry{}
+	activeMu.Unlock()
+}
+
+// Create stores a new session for a user logging in from a device
+func Create(userID string, ip string, userAgent string) (*models.Session, error) {
+	now := time.Now()
+	sess := &models.Session{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		IP:             ip,
+		UserAgent:      userAgent,
+		CreatedAt:      now,
+		LastActivityAt: now,
+		ExpiresAt:      now.Add(config.Cfg.JWT.RefreshExpiry),
+	}
+
+	collection := database.GetMongoCollection("sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, sess); err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// Touch validates a session and records new activity on it.
+// The expiry is extended because a fresh refresh token is issued each time.
+func Touch(sessionID string, ip string, userAgent string) error {
+	objID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionInvalid
+	}
+
+	collection := database.GetMongoCollection("sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"_id":        objID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"last_activity_at": now,
+		"expires_at":       now.Add(config.Cfg.JWT.RefreshExpiry),
+		"ip":               ip,
+		"user_agent":       userAgent,
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionInvalid
+	}
+
+	return nil
+}
+
+// ListActive returns all active sessions for a user, most recent first
+func ListActive(userID string) ([]models.Session, error) {
+	collection := database.GetMongoCollection("sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "last_activity_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Revoke revokes a single session belonging to userID
+func Revoke(sessionID string, userID string, revokedBy string) error {
+	objID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionInvalid
+	}
+
+	collection := database.GetMongoCollection("sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":        objID,
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	}
+	update := bson.M{
+		"revoked_at": time.Now(),
+		"revoked_by": revokedBy,
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionInvalid
+	}
+	forgetActive()
+
+	return nil
+}
+
+// RevokeAll revokes every active session of a user and returns how many were revoked
+func RevokeAll(userID string, revokedBy string) (int64, error) {
+	collection := database.GetMongoCollection("sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	}
+	update := bson.M{
+		"revoked_at": time.Now(),
+		"revoked_by": revokedBy,
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		return 0, err
+	}
+	forgetActive()
+
+	return result.ModifiedCount, nil
+}
+
+// RevokeOthers revokes every active session of a user except keepSessionID, e.g. after
+// a password change, and returns how many were revoked
+func RevokeOthers(userID string, keepSessionID string, revokedBy string) (int64, error) {
+	collection := database.GetMongoCollection("sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	}
+	if keepObjID, err := primitive.ObjectIDFromHex(keepSessionID); err == nil {
+		filter["_id"] = bson.M{"$ne": keepObjID}
+	}
+	update := bson.M{
+		"revoked_at": time.Now(),
+		"revoked_by": revokedBy,
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		return 0, err
+	}
+	forgetActive()
+
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,142 @@
+package shortlink
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the short links
+const Collection = "short_links"
+
+// codeLength is the number of characters of a code; 62^7 codes make guessing impractical
+const codeLength = 7
+
+const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var (
+	ErrNotFound = errors.New("short link not found")
+	ErrExpired  = errors.New("short link has expired")
+)
+
+// Enabled reports whether a short link base URL is configured
+func Enabled() bool {
+	return config.Cfg != nil && config.Cfg.ShortLink.BaseURL != ""
+}
+
+// URL returns the public short URL of a code
+func URL(code string) string {
+	return config.Cfg.ShortLink.BaseURL + "/s/" + code
+}
+
+// Shorten returns a short URL for the target. A live link to the same target is reused
+// and its expiry extended, so repeated messages about an order share one link.
+func Shorten(ctx context.Context, target string) (string, error) {
+	collection := database.GetMongoCollection(Collection)
+	now := time.Now()
+	expiresAt := now.Add(config.Cfg.ShortLink.TTL)
+
+	link := &models.ShortLink{}
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"target": target, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+	).Decode(link)
+	if err == nil {
+		return URL(link.Code), nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+
+	// Codes are random; retry the rare collision with an existing code
+	for attempt := 0; attempt < 5; attempt++ {
+		link = &models.ShortLink{
+			Code:      newCode(),
+			Target:    target,
+			ExpiresAt: expiresAt,
+			CreatedAt: now,
+		}
+		_, err = collection.InsertOne(ctx, link)
+		if err == nil {
+			return URL(link.Code), nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return "", err
+		}
+	}
+	return "", err
+}
+
+// Link returns the short URL of a target, or the target itself when short links are
+// disabled or shortening failed, so messages always carry a working link
+func Link(target string) string {
+	if !Enabled() || target == "" {
+		return target
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	short, err := Shorten(ctx, target)
+	if err != nil {
+		log.Printf("[ShortLink] Failed to shorten %s: %v", target, err)
+		return target
+	}
+	return short
+}
+
+// Resolve returns the target of a code and counts the hit
+func Resolve(ctx context.Context, code string) (string, error) {
+	now := time.Now()
+	link := &models.ShortLink{}
+	err := database.GetMongoCollection(Collection).FindOneAndUpdate(ctx,
+		bson.M{"_id": code},
+		bson.M{"$inc": bson.M{"hits": 1}, "$set": bson.M{"last_hit_at": now}},
+	).Decode(link)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if now.After(link.ExpiresAt) {
+		return "", ErrExpired
+	}
+	return link.Target, nil
+}
+
+// EnsureIndexes creates the index used to reuse links of the same target
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := database.GetMongoCollection(Collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "target", Value: 1}, {Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("target"),
+	})
+	if err != nil {
+		log.Printf("[ShortLink] Failed to create index: %v", err)
+	}
+}
+
+// newCode returns a random code of codeLength characters
+func newCode() string {
+	b := make([]byte, codeLength)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, max)
+		b[i] = alphabet[n.Int64()]
+	}
+	return string(b)
+}
@@ -0,0 +1,250 @@
+// Package sla times orders through the operational stages against the SLA policy:
+// flagging orders at risk and breached on listings and alerting the role responsible
+// for the stage.
+package sla
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/chatalert"
+	"bg-go/internal/lib/events"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the SLA policy
+const Collection = "sla_policy"
+
+// alertBatch bounds the orders alerted per stage and state in one run
+const alertBatch = 200
+
+// Stages
+const (
+	StagePaymentVerification = "payment_verification" // From payment upload to verification
+	StageQueueCall           = "queue_call"           // From queue check-in to the call
+	StageLoading             = "loading"              // From loading start to the delivery note
+)
+
+// stage is where an order is while in a stage: its status, and the field set when
+// it entered the stage
+type stage struct {
+	status    string
+	startPath string
+	start     func(order *models.Order) *time.Time
+}
+
+var stages = map[string]stage{
+	StagePaymentVerification: {models.OrderStatusPaid, "payment_uploaded_at", func(o *models.Order) *time.Time { return o.PaymentUploadedAt }},
+	StageQueueCall:           {models.OrderStatusQueued, "queue_entered_at", func(o *models.Order) *time.Time { return o.QueueEnteredAt }},
+	StageLoading:             {models.OrderStatusLoading, "loading_started_at", func(o *models.Order) *time.Time { return o.LoadingStartedAt }},
+}
+
+// DefaultRules are the rules of stages the saved policy does not mention
+var DefaultRules = []models.SLARule{
+	{Stage: StagePaymentVerification, Enabled: true, TargetMinutes: 120, WarnMinutes: 30, Role: models.RoleAdmin},
+	{Stage: StageQueueCall, Enabled: true, TargetMinutes: 180, WarnMinutes: 30, Role: models.RoleAdmin},
+	{Stage: StageLoading, TargetMinutes: 60, WarnMinutes: 15, Role: models.RoleAdmin},
+}
+
+// IsStage reports whether a stage exists
+func IsStage(name string) bool {
+	_, ok := stages[name]
+	return ok
+}
+
+// LoadPolicy returns the saved SLA policy with a rule for every stage
+func LoadPolicy(ctx context.Context) *models.SLAPolicy {
+	policy := &models.SLAPolicy{}
+	if err := database.GetMongoCollection(Collection).FindOne(ctx, bson.M{}).Decode(policy); err != nil {
+		*policy = models.SLAPolicy{}
+	}
+
+	rules := make([]models.SLARule, 0, len(DefaultRules))
+	for _, rule := range DefaultRules {
+		for _, saved := range policy.Rules {
+			if saved.Stage == rule.Stage {
+				rule = saved
+			}
+		}
+		rules = append(rules, rule)
+	}
+	policy.Rules = rules
+	return policy
+}
+
+// Evaluate returns the SLA status of an order's current stage, nil when the stage has
+// no enabled SLA
+func Evaluate(policy *models.SLAPolicy, order *models.Order, now time.Time) *models.SLAStatus {
+	for _, rule := range policy.Rules {
+		st := stages[rule.Stage]
+		if !rule.Enabled || rule.TargetMinutes <= 0 || order.Status != st.status {
+			continue
+		}
+		started := st.start(order)
+		if started == nil {
+			continue
+		}
+
+		due := started.Add(time.Duration(rule.TargetMinutes) * time.Minute)
+		status := &models.SLAStatus{
+			Stage:          rule.Stage,
+			Role:           rule.Role,
+			State:          models.SLAOnTrack,
+			StartedAt:      *started,
+			DueAt:          due,
+			ElapsedMinutes: int(now.Sub(*started).Minutes()),
+		}
+		switch {
+		case !now.Before(due):
+			status.State = models.SLABreached
+		case !now.Before(due.Add(-time.Duration(rule.WarnMinutes) * time.Minute)):
+			status.State = models.SLAAtRisk
+		}
+		return status
+	}
+	return nil
+}
+
+// Annotate sets the SLA status of every order
+func Annotate(policy *models.SLAPolicy, orders []models.Order, now time.Time) {
+	for i := range orders {
+		orders[i].SLA = Evaluate(policy, &orders[i], now)
+	}
+}
+
+// Filter returns the condition matching the orders in an SLA state, in any stage with an
+// enabled SLA. It matches nothing when no SLA is enabled.
+func Filter(policy *models.SLAPolicy, state string, now time.Time) bson.M {
+	conditions := []bson.M{}
+	for _, rule := range policy.Rules {
+		if !rule.Enabled || rule.TargetMinutes <= 0 {
+			continue
+		}
+		conditions = append(conditions, stageFilter(rule, state, now))
+	}
+	if len(conditions) == 0 {
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$or": conditions}
+}
+
+// stageFilter matches the orders in a stage and an SLA state
+func stageFilter(rule models.SLARule, state string, now time.Time) bson.M {
+	st := stages[rule.Stage]
+	breachedFrom := now.Add(-time.Duration(rule.TargetMinutes) * time.Minute)
+	atRiskFrom := breachedFrom.Add(time.Duration(rule.WarnMinutes) * time.Minute)
+
+	started := bson.M{}
+	switch state {
+	case models.SLABreached:
+		started["$lte"] = breachedFrom
+	case models.SLAAtRisk:
+		started["$gt"], started["$lte"] = breachedFrom, atRiskFrom
+	default:
+		started["$gt"] = atRiskFrom
+	}
+	return bson.M{"status": st.status, st.startPath: started}
+}
+
+// Check alerts the role responsible for a stage, once per order and stage entry, when an
+// order gets at risk of breaching the stage's SLA and when it breaches it. Alerts are
+// published as events for the admin notification center and posted to the chat
+// channels of the sla_breach alert rule. It is registered as a cron job.
+func Check() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	policy := LoadPolicy(ctx)
+	now := time.Now()
+	for _, rule := range policy.Rules {
+		if !rule.Enabled || rule.TargetMinutes <= 0 {
+			continue
+		}
+		for _, state := range []string{models.SLAAtRisk, models.SLABreached} {
+			alertOrders(ctx, rule, state, now)
+		}
+	}
+}
+
+// alertOrders alerts the orders of a stage newly in an SLA state, oldest first and at
+// most alertBatch per run. Orders already alerted since they entered the stage are left
+// out by the query, so a backlog of breached orders is worked through over the runs.
+func alertOrders(ctx context.Context, rule models.SLARule, state string, now time.Time) {
+	collection := database.GetMongoCollection("orders")
+	key := "sla_alerts." + rule.Stage + "_" + state
+	startPath := stages[rule.Stage].startPath
+
+	filter := stageFilter(rule, state, now)
+	filter["$expr"] = bson.M{"$ne": bson.A{"$" + key, "$" + startPath}}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: startPath, Value: 1}}).
+		SetLimit(alertBatch)
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		log.Printf("[SLA] Failed to load %s orders of %s: %v", state, rule.Stage, err)
+		return
+	}
+	var orders []models.Order
+	err = cursor.All(ctx, &orders)
+	cursor.Close(ctx)
+	if err != nil {
+		log.Printf("[SLA] Failed to load %s orders of %s: %v", state, rule.Stage, err)
+		return
+	}
+
+	for i := range orders {
+		order := &orders[i]
+		started := stages[rule.Stage].start(order)
+		if started == nil {
+			continue
+		}
+
+		// Claim the alert first so concurrent runs send it once
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": order.ID, key: bson.M{"$ne": *started}},
+			bson.M{"$set": bson.M{key: *started}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+
+		alert(ctx, rule, state, order, *started, now)
+	}
+}
+
+// alert publishes the SLA event of an order and posts it to the chat channels
+func alert(ctx context.Context, rule models.SLARule, state string, order *models.Order, started, now time.Time) {
+	due := started.Add(time.Duration(rule.TargetMinutes) * time.Minute)
+	eventType, title := events.SLAAtRisk, "SLA at risk"
+	text := fmt.Sprintf("Order %s breaches the %s SLA in %s.", order.OrderNumber, rule.Stage, due.Sub(now).Round(time.Minute))
+	if state == models.SLABreached {
+		eventType, title = events.SLABreached, "SLA breached"
+		text = fmt.Sprintf("Order %s breached the %s SLA %s ago.", order.OrderNumber, rule.Stage, now.Sub(due).Round(time.Minute))
+	}
+
+	events.Publish(ctx, eventType, "order", order.ID.Hex(), map[string]interface{}{
+		"order_number":   order.OrderNumber,
+		"stage":          rule.Stage,
+		"role":           rule.Role,
+		"started_at":     started,
+		"due_at":         due,
+		"target_minutes": rule.TargetMinutes,
+	})
+
+	chatalert.Fire(chatalert.Alert{
+		Type:  chatalert.TypeSLABreach,
+		Title: title,
+		Text:  text,
+		Fields: []chatalert.Field{
+			{Name: "Stage", Value: rule.Stage},
+			{Name: "Responsible", Value: rule.Role},
+			{Name: "Due", Value: due.Format("02/01/2006 15:04")},
+		},
+	})
+}
@@ -0,0 +1,202 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"bg-go/internal/config"
+)
+
+// twilio sends with the Twilio Programmable Messaging API. The price is only known once
+// the message is delivered, so the cost is usually estimated.
+type twilio struct {
+	baseURL string
+	sid     string
+	token   string
+	from    string
+	client  *http.Client
+}
+
+func newTwilio(cfg config.SMSConfig) Gateway {
+	baseURL := cfg.URL
+	if baseURL == "" {
+		baseURL = "https://api.twilio.com"
+	}
+	return &twilio{baseURL: baseURL, sid: cfg.Account, token: cfg.Token, from: cfg.From, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (g *twilio) Send(ctx context.Context, to string, message string) (*Result, error) {
+	form := url.Values{"To": {"+" + to}, "From": {g.from}, "Body": {message}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+"/2010-04-01/Accounts/"+g.sid+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(g.sid, g.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var body struct {
+		SID         string  `json:"sid"`
+		NumSegments string  `json:"num_segments"`
+		Price       *string `json:"price"`
+		PriceUnit   string  `json:"price_unit"`
+		Code        int     `json:"code"`
+		Message     string  `json:"message"`
+	}
+	status, err := doJSON(g.client, req, &body)
+	if err != nil {
+		return nil, err
+	}
+	if status >= 300 {
+		return nil, fmt.Errorf("twilio error %d: %s", body.Code, body.Message)
+	}
+
+	result := &Result{MessageID: body.SID}
+	result.Segments, _ = strconv.Atoi(body.NumSegments)
+	if body.Price != nil {
+		if price, err := strconv.ParseFloat(*body.Price, 64); err == nil {
+			result.Cost = math.Abs(price) // Twilio reports charges as negative amounts
+			result.Currency = body.PriceUnit
+		}
+	}
+	return result, nil
+}
+
+// vonage sends with the Vonage (Nexmo) SMS API, which reports the price of every part
+type vonage struct {
+	baseURL string
+	key     string
+	secret  string
+	from    string
+	client  *http.Client
+}
+
+func newVonage(cfg config.SMSConfig) Gateway {
+	baseURL := cfg.URL
+	if baseURL == "" {
+		baseURL = "https://rest.nexmo.com"
+	}
+	return &vonage{baseURL: baseURL, key: cfg.Account, secret: cfg.Token, from: cfg.From, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (g *vonage) Send(ctx context.Context, to string, message string) (*Result, error) {
+	form := url.Values{"api_key": {g.key}, "api_secret": {g.secret}, "from": {g.from}, "to": {to}, "text": {message}}
+	for _, r := range message {
+		if !isGSM(r) {
+			form.Set("type", "unicode")
+			break
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+"/sms/json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var body struct {
+		Messages []struct {
+			MessageID string `json:"message-id"`
+			Status    string `json:"status"`
+			ErrorText string `json:"error-text"`
+			Price     string `json:"message-price"`
+		} `json:"messages"`
+	}
+	status, err := doJSON(g.client, req, &body)
+	if err != nil {
+		return nil, err
+	}
+	if status >= 300 || len(body.Messages) == 0 {
+		return nil, fmt.Errorf("vonage returned status %d", status)
+	}
+
+	result := &Result{MessageID: body.Messages[0].MessageID, Segments: len(body.Messages), Currency: "EUR"}
+	for _, part := range body.Messages {
+		if part.Status != "0" {
+			return nil, fmt.Errorf("vonage error %s: %s", part.Status, part.ErrorText)
+		}
+		price, _ := strconv.ParseFloat(part.Price, 64)
+		result.Cost += price
+	}
+	if result.Cost == 0 {
+		result.Currency = ""
+	}
+	return result, nil
+}
+
+// httpGateway posts to a local aggregator: {"to": "+628...", "from": ..., "message": ...}
+// with SMS_TOKEN as bearer token. Any 2xx response is a success; it may answer with
+// {"id": ..., "segments": ..., "cost": ..., "currency": ...}.
+type httpGateway struct {
+	url    string
+	token  string
+	from   string
+	client *http.Client
+}
+
+func newHTTP(cfg config.SMSConfig) Gateway {
+	return &httpGateway{url: cfg.URL, token: cfg.Token, from: cfg.From, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (g *httpGateway) Send(ctx context.Context, to string, message string) (*Result, error) {
+	if g.url == "" {
+		return nil, fmt.Errorf("the http SMS gateway needs SMS_URL")
+	}
+	payload, err := json.Marshal(map[string]string{"to": "+" + to, "from": g.from, "message": message})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+
+	var body struct {
+		ID       string  `json:"id"`
+		Segments int     `json:"segments"`
+		Cost     float64 `json:"cost"`
+		Currency string  `json:"currency"`
+		Error    string  `json:"error"`
+	}
+	status, err := doJSON(g.client, req, &body)
+	if err != nil {
+		return nil, err
+	}
+	if status >= 300 {
+		if body.Error != "" {
+			return nil, fmt.Errorf("SMS gateway error: %s", body.Error)
+		}
+		return nil, fmt.Errorf("SMS gateway returned status %d", status)
+	}
+	return &Result{MessageID: body.ID, Segments: body.Segments, Cost: body.Cost, Currency: body.Currency}, nil
+}
+
+// doJSON sends a request and decodes a JSON response body, if any, into out
+func doJSON(client *http.Client, req *http.Request, out interface{}) (int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, out); err != nil && resp.StatusCode < 300 {
+			return resp.StatusCode, fmt.Errorf("invalid response from the SMS gateway: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
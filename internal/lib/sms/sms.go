@@ -0,0 +1,143 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/sandbox"
+	"bg-go/internal/lib/utils"
+)
+
+// ErrDisabled is returned when no SMS gateway is configured
+var ErrDisabled = errors.New("SMS delivery is not configured")
+
+// Result is an SMS accepted by the gateway
+type Result struct {
+	Gateway   string  `json:"gateway" bson:"gateway"`
+	MessageID string  `json:"message_id,omitempty" bson:"message_id,omitempty"`
+	Segments  int     `json:"segments" bson:"segments"`
+	Cost      float64 `json:"cost" bson:"cost"`
+	Currency  string  `json:"currency,omitempty" bson:"currency,omitempty"`
+	Estimated bool    `json:"estimated,omitempty" bson:"estimated,omitempty"` // Cost from SMS_COST_PER_SEGMENT, the gateway reported no price
+}
+
+// Gateway sends an SMS to an international phone number without "+" (628...)
+type Gateway interface {
+	Send(ctx context.Context, to string, message string) (*Result, error)
+}
+
+// Factory creates a gateway from the configuration
+type Factory func(cfg config.SMSConfig) Gateway
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		"twilio": newTwilio,
+		"vonage": newVonage,
+		"http":   newHTTP,
+	}
+)
+
+// Register adds a gateway that SMS_GATEWAY can select
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[name] = factory
+}
+
+// Enabled reports whether an SMS gateway is configured
+func Enabled() bool {
+	return config.Cfg.SMS.Gateway != ""
+}
+
+// FallbackFor reports whether notifications of a type fall back to SMS
+func FallbackFor(notificationType string) bool {
+	if !Enabled() {
+		return false
+	}
+	for _, t := range config.Cfg.SMS.Types {
+		t = strings.TrimSpace(t)
+		if t == "*" || t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+// Send sends an SMS with the configured gateway. The cost is estimated from
+// SMS_COST_PER_SEGMENT when the gateway does not report it.
+func Send(ctx context.Context, phone string, message string) (*Result, error) {
+	cfg := config.Cfg.SMS
+	if cfg.Gateway == "" {
+		return nil, ErrDisabled
+	}
+	if sandbox.Intercepts(phone) {
+		if err := sandbox.Capture(sandbox.ChannelSMS, phone, "", message); err != nil {
+			return nil, err
+		}
+		return &Result{Gateway: "sandbox", Segments: Segments(message)}, nil
+	}
+
+	to, err := utils.NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	factory, ok := factories[cfg.Gateway]
+	mu.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown SMS gateway: " + cfg.Gateway)
+	}
+	result, err := factory(cfg).Send(ctx, to, message)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Gateway = cfg.Gateway
+	if result.Segments == 0 {
+		result.Segments = Segments(message)
+	}
+	if result.Cost == 0 && cfg.Cost > 0 {
+		result.Cost = cfg.Cost * float64(result.Segments)
+		result.Currency = cfg.Currency
+		result.Estimated = true
+	}
+	return result, nil
+}
+
+// Segments returns how many SMS a message is split into: 160 characters fit a single
+// GSM-7 message and 153 each part of a longer one; any other character switches the
+// message to UCS-2, with 70 and 67.
+func Segments(message string) int {
+	length, single, part := 0, 160, 153
+	for _, r := range message {
+		if !isGSM(r) {
+			single, part = 70, 67
+			break
+		}
+	}
+	for _, r := range message {
+		length++
+		if single == 160 && strings.ContainsRune(gsmExtended, r) {
+			length++ // Escaped with a second character
+		}
+	}
+	if length <= single {
+		return 1
+	}
+	return (length + part - 1) / part
+}
+
+// gsmBasic and gsmExtended are the characters of the GSM-7 alphabet
+const (
+	gsmBasic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsmExtended = "^{}\\[~]|€\f"
+)
+
+func isGSM(r rune) bool {
+	return strings.ContainsRune(gsmBasic, r) || strings.ContainsRune(gsmExtended, r)
+}
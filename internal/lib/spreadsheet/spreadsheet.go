@@ -0,0 +1,208 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Read parses a CSV or XLSX file, chosen by extension, into rows of cells.
+// Only the first worksheet of a workbook is read.
+func Read(filename string, data []byte) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return ReadCSV(data)
+	case ".xlsx":
+		return ReadXLSX(data)
+	}
+	return nil, errors.New("unsupported file type, use .csv or .xlsx")
+}
+
+// ReadCSV parses CSV data. The delimiter is detected from the first line, since
+// spreadsheets saved with Indonesian locale settings use semicolons.
+func ReadCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	return rows, nil
+}
+
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline struct {
+		Text string `xml:"t"`
+	} `xml:"is"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// ReadXLSX parses the first worksheet of an XLSX workbook
+func ReadXLSX(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("invalid XLSX file")
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	var shared xlsxSharedStrings
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeXML(f, &shared); err != nil {
+			return nil, err
+		}
+	}
+	strs := make([]string, len(shared.Items))
+	for i, item := range shared.Items {
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		strs[i] = text
+	}
+
+	sheetFile, ok := files[firstSheetPath(files)]
+	if !ok {
+		return nil, errors.New("XLSX file has no worksheet")
+	}
+	var sheet xlsxSheet
+	if err := decodeXML(sheetFile, &sheet); err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{}
+	for _, row := range sheet.Rows {
+		cells := []string{}
+		for i, cell := range row.Cells {
+			col := columnIndex(cell.Ref, i)
+			for len(cells) < col {
+				cells = append(cells, "")
+			}
+			cells = append(cells, cellValue(cell, strs))
+		}
+		rows = append(rows, cells)
+	}
+
+	return rows, nil
+}
+
+// firstSheetPath resolves the first sheet of the workbook through its relationships
+func firstSheetPath(files map[string]*zip.File) string {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var workbook xlsxWorkbook
+	var rels xlsxRelationships
+	workbookFile, ok := files["xl/workbook.xml"]
+	relsFile, relsOK := files["xl/_rels/workbook.xml.rels"]
+	if !ok || !relsOK || decodeXML(workbookFile, &workbook) != nil || decodeXML(relsFile, &rels) != nil || len(workbook.Sheets) == 0 {
+		return fallback
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID == workbook.Sheets[0].RelID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/")
+			}
+			return path.Join("xl", rel.Target)
+		}
+	}
+	return fallback
+}
+
+func cellValue(cell xlsxCell, strs []string) string {
+	switch cell.Type {
+	case "s":
+		if i, err := strconv.Atoi(cell.Value); err == nil && i >= 0 && i < len(strs) {
+			return strings.TrimSpace(strs[i])
+		}
+		return ""
+	case "inlineStr":
+		return strings.TrimSpace(cell.Inline.Text)
+	}
+
+	// Long numbers such as phone numbers may be stored in scientific notation
+	if strings.ContainsAny(cell.Value, "eE") {
+		if f, err := strconv.ParseFloat(cell.Value, 64); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	return strings.TrimSpace(cell.Value)
+}
+
+// columnIndex converts the letters of a cell reference such as "C7" to a zero-based column
+func columnIndex(ref string, fallback int) int {
+	col := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	if col == 0 {
+		return fallback
+	}
+	return col - 1
+}
+
+func decodeXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := xml.NewDecoder(io.LimitReader(rc, 50<<20)).Decode(v); err != nil {
+		return fmt.Errorf("invalid XLSX content in %s: %v", f.Name, err)
+	}
+	return nil
+}
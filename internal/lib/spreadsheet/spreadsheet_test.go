@@ -0,0 +1,57 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	// Spreadsheets saved with Indonesian settings use semicolons and start with a BOM
+	rows, err := Read("sales.CSV", []byte("\ufeffnama;no hp\nBudi; 0812-3456-7890\nSiti\n"))
+	want := [][]string{{"nama", "no hp"}, {"Budi", "0812-3456-7890"}, {"Siti"}}
+	if err != nil || !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %q (%v)", rows, err)
+	}
+
+	if rows, err := Read("sales.csv", []byte("name,phone\n\"Toko, Jaya\",62811\n")); err != nil || rows[1][0] != "Toko, Jaya" {
+		t.Fatalf("comma rows = %q (%v)", rows, err)
+	}
+	if _, err := Read("sales.xls", nil); err == nil {
+		t.Fatal("xls: want an error")
+	}
+}
+
+func TestReadXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Sales" r:id="rId3"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId3" Target="worksheets/data.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":       `<sst><si><t>Name</t></si><si><t>Phone</t></si><si><r><t>Bu</t></r><r><t>di </t></r></si></sst>`,
+		"xl/worksheets/data.xml": `<worksheet><sheetData>` +
+			`<row><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>` +
+			`<row><c r="A2" t="s"><v>2</v></c><c r="C2"><v>6.2812345678E11</v></c></row>` +
+			`<row><c r="B3" t="inlineStr"><is><t>Siti</t></is></c></row>` +
+			`</sheetData></worksheet>`,
+	}
+	for name, content := range files {
+		w, _ := archive.Create(name)
+		w.Write([]byte(content))
+	}
+	archive.Close()
+
+	// The sheet is found through the workbook, gaps keep their columns and
+	// numbers in scientific notation are written out
+	rows, err := Read("sales.xlsx", buf.Bytes())
+	want := [][]string{{"Name", "Phone"}, {"Budi", "", "628123456780"}, {"", "Siti"}}
+	if err != nil || !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %q (%v)", rows, err)
+	}
+
+	if _, err := Read("sales.xlsx", []byte("not a zip")); err == nil {
+		t.Fatal("invalid archive: want an error")
+	}
+}
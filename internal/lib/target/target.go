@@ -0,0 +1,276 @@
+package target
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/lib/archive"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one target per sales rep and month
+const Collection = "sales_targets"
+
+// MonthFormat is the format of a target month
+const MonthFormat = "2006-01"
+
+// Target is a sales rep's revenue and volume goal for a month
+type Target struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	SalesID       string             `json:"sales_id" bson:"sales_id"`
+	Month         string             `json:"month" bson:"month"` // YYYY-MM
+	Revenue       float64            `json:"revenue" bson:"revenue"`
+	Volume        int                `json:"volume" bson:"volume"` // Item quantity of the completed orders
+	CreatedBy     string             `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	SummarySentAt *time.Time         `json:"summary_sent_at,omitempty" bson:"summary_sent_at,omitempty"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Actual is what a sales rep's completed orders of a month add up to
+type Actual struct {
+	Orders  int     `json:"orders" bson:"orders"`
+	Revenue float64 `json:"revenue" bson:"revenue"`
+	Volume  int     `json:"volume" bson:"volume"`
+}
+
+// Achievement compares a rep's actuals of a month to the target; the percentages are
+// 0 without a target
+type Achievement struct {
+	SalesID        string  `json:"sales_id"`
+	SalesName      string  `json:"sales_name,omitempty"`
+	Month          string  `json:"month"`
+	Target         *Target `json:"target,omitempty"`
+	Actual         Actual  `json:"actual"`
+	RevenuePercent float64 `json:"revenue_percent"`
+	VolumePercent  float64 `json:"volume_percent"`
+	Rank           int     `json:"rank,omitempty"`
+}
+
+// MonthRange returns the start and end of a target month in local time
+func MonthRange(month string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation(MonthFormat, month, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid month %q, use YYYY-MM", month)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Actuals adds up the orders completed in a month per sales rep, including archived
+// ones. Shipments of a split order are left out; the split order counts once it completes.
+func Actuals(ctx context.Context, month string) (map[string]Actual, error) {
+	start, end, err := MonthRange(month)
+	if err != nil {
+		return nil, err
+	}
+
+	actuals := map[string]Actual{}
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"status":       models.OrderStatusCompleted,
+			"completed_at": bson.M{"$gte": start, "$lt": end},
+			"shipment_of":  bson.M{"$exists": false},
+		}},
+		{"$group": bson.M{
+			"_id":     "$sales_id",
+			"orders":  bson.M{"$sum": 1},
+			"revenue": bson.M{"$sum": "$total_price"},
+			"volume":  bson.M{"$sum": "$quantity"},
+		}},
+	}
+	for _, name := range []string{"orders", archive.Collection} {
+		cursor, err := database.GetMongoCollection(name).Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		var rows []struct {
+			SalesID string `bson:"_id"`
+			Actual  `bson:",inline"`
+		}
+		err = cursor.All(ctx, &rows)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			actual := actuals[row.SalesID]
+			actual.Orders += row.Orders
+			actual.Revenue += row.Revenue
+			actual.Volume += row.Volume
+			actuals[row.SalesID] = actual
+		}
+	}
+	return actuals, nil
+}
+
+// Achieve compares actuals to a target, which may be nil
+func Achieve(salesID string, month string, t *Target, actual Actual) Achievement {
+	achievement := Achievement{SalesID: salesID, Month: month, Target: t, Actual: actual}
+	if t != nil {
+		achievement.RevenuePercent = percent(actual.Revenue, t.Revenue)
+		achievement.VolumePercent = percent(float64(actual.Volume), float64(t.Volume))
+	}
+	return achievement
+}
+
+// Leaderboard returns the achievement of every rep with a target or completed orders in
+// a month, ranked by revenue achievement and then revenue. Reps without a target rank last.
+func Leaderboard(ctx context.Context, month string) ([]Achievement, error) {
+	actuals, err := Actuals(ctx, month)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := ForMonth(ctx, month)
+	if err != nil {
+		return nil, err
+	}
+
+	board := []Achievement{}
+	for salesID, t := range targets {
+		board = append(board, Achieve(salesID, month, t, actuals[salesID]))
+	}
+	for salesID, actual := range actuals {
+		if targets[salesID] == nil {
+			board = append(board, Achieve(salesID, month, nil, actual))
+		}
+	}
+
+	sort.SliceStable(board, func(i, j int) bool {
+		if (board[i].Target == nil) != (board[j].Target == nil) {
+			return board[i].Target != nil
+		}
+		if board[i].RevenuePercent != board[j].RevenuePercent {
+			return board[i].RevenuePercent > board[j].RevenuePercent
+		}
+		if board[i].Actual.Revenue != board[j].Actual.Revenue {
+			return board[i].Actual.Revenue > board[j].Actual.Revenue
+		}
+		return board[i].SalesID < board[j].SalesID
+	})
+	for i := range board {
+		board[i].Rank = i + 1
+	}
+	return board, nil
+}
+
+// ForMonth returns the targets of a month by sales ID
+func ForMonth(ctx context.Context, month string) (map[string]*Target, error) {
+	cursor, err := database.GetMongoCollection(Collection).Find(ctx, bson.M{"month": month})
+	if err != nil {
+		return nil, err
+	}
+	var targets []Target
+	err = cursor.All(ctx, &targets)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bySales := make(map[string]*Target, len(targets))
+	for i := range targets {
+		bySales[targets[i].SalesID] = &targets[i]
+	}
+	return bySales, nil
+}
+
+// SendSummaries sends every rep with a target for last month their achievement, once.
+// It is registered as a cron job, so a summary missed on the 1st goes out on the next run.
+func SendSummaries() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local).AddDate(0, -1, 0).Format(MonthFormat)
+
+	collection := database.GetMongoCollection(Collection)
+	pending, _ := collection.CountDocuments(ctx, bson.M{"month": month, "summary_sent_at": bson.M{"$exists": false}})
+	if pending == 0 {
+		return
+	}
+
+	board, err := Leaderboard(ctx, month)
+	if err != nil {
+		log.Printf("[Target] Failed to build the %s leaderboard: %v", month, err)
+		return
+	}
+
+	for _, achievement := range board {
+		t := achievement.Target
+		if t == nil || t.SummarySentAt != nil {
+			continue
+		}
+
+		// Claim the summary first so overlapping runs do not send it twice
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": t.ID, "summary_sent_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"summary_sent_at": now}})
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+
+		sales := &models.Sales{}
+		if salesObjID, err := primitive.ObjectIDFromHex(t.SalesID); err == nil {
+			database.GetMongoCollection("sales").FindOne(ctx, bson.M{"_id": salesObjID}).Decode(sales)
+		}
+		if sales.Phone == "" {
+			continue
+		}
+
+		message := notification.RenderTargetSummary(map[string]string{
+			"name":            sales.Name,
+			"month":           month,
+			"revenue":         fmt.Sprintf("%.0f", achievement.Actual.Revenue),
+			"revenue_target":  fmt.Sprintf("%.0f", t.Revenue),
+			"revenue_percent": strconv.FormatFloat(achievement.RevenuePercent, 'f', 1, 64),
+			"volume":          strconv.Itoa(achievement.Actual.Volume),
+			"volume_target":   strconv.Itoa(t.Volume),
+			"volume_percent":  strconv.FormatFloat(achievement.VolumePercent, 'f', 1, 64),
+			"orders":          strconv.Itoa(achievement.Actual.Orders),
+			"rank":            strconv.Itoa(achievement.Rank),
+			"ranked":          strconv.Itoa(len(board)),
+		})
+		if err := notification.SendTargetSummary(sales.Phone, message); err != nil {
+			log.Printf("[Target] Failed to send the %s summary to %s: %v", month, sales.Name, err)
+		}
+	}
+}
+
+// EnsureIndexes allows one target per sales rep and month
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := database.GetMongoCollection(Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sales_id", Value: 1}, {Key: "month", Value: 1}},
+			Options: options.Index().SetName("sales_month").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "month", Value: 1}},
+			Options: options.Index().SetName("month"),
+		},
+	})
+	if err != nil {
+		log.Printf("[Target] Failed to create indexes: %v", err)
+	}
+}
+
+// percent returns part of whole in percent, rounded to one decimal
+func percent(part float64, whole float64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return math.Round(part/whole*1000) / 10
+}
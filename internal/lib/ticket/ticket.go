@@ -0,0 +1,153 @@
+package ticket
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"bg-go/internal/lib/barcode"
+	"bg-go/internal/lib/pdf"
+)
+
+// Formats supported by the renderers
+const (
+	FormatZPL    = "zpl"
+	FormatESCPOS = "escpos"
+	FormatPDF    = "pdf"
+)
+
+// Ticket is the content of a printed queue ticket
+type Ticket struct {
+	Company      string
+	QueueNumber  int
+	OrderNumber  string
+	VehiclePlate string
+	DriverName   string
+	QRContent    string
+	EnteredAt    time.Time
+}
+
+func (t Ticket) timestamp() string {
+	return t.EnteredAt.Format("02 Jan 2006 15:04")
+}
+
+// ZPL renders the ticket for Zebra label printers (80mm, 203 dpi)
+func ZPL(t Ticket) string {
+	var b strings.Builder
+	field := func(y, size int, text string) {
+		fmt.Fprintf(&b, "^FO0,%d^FB576,1,0,C^A0N,%d,%d^FD%s^FS\n", y, size, size, zplEscape(text))
+	}
+
+	b.WriteString("^XA\n^CI28\n^PW576\n^LL760\n")
+	field(30, 30, t.Company)
+	field(80, 28, "NO. ANTRIAN")
+	field(120, 120, fmt.Sprintf("#%d", t.QueueNumber))
+	field(270, 30, t.VehiclePlate)
+	field(310, 24, t.DriverName)
+	field(345, 24, t.OrderNumber)
+	fmt.Fprintf(&b, "^FO178,390^BQN,2,8^FDQA,%s^FS\n", zplEscape(t.QRContent))
+	field(700, 24, t.timestamp())
+	b.WriteString("^XZ\n")
+
+	return b.String()
+}
+
+// ESCPOS renders the ticket for ESC/POS thermal receipt printers
+func ESCPOS(t Ticket) []byte {
+	var b bytes.Buffer
+	line := func(text string) {
+		b.WriteString(asciiOnly(text))
+		b.WriteByte('\n')
+	}
+
+	b.Write([]byte{0x1b, 0x40})       // initialize
+	b.Write([]byte{0x1b, 0x61, 0x01}) // center
+
+	b.Write([]byte{0x1b, 0x45, 0x01}) // bold on
+	line(t.Company)
+	b.Write([]byte{0x1b, 0x45, 0x00}) // bold off
+	line("NO. ANTRIAN")
+
+	b.Write([]byte{0x1d, 0x21, 0x33}) // 4x width and height
+	line(fmt.Sprintf("#%d", t.QueueNumber))
+	b.Write([]byte{0x1d, 0x21, 0x11}) // 2x width and height
+	line(t.VehiclePlate)
+	b.Write([]byte{0x1d, 0x21, 0x00}) // normal size
+	line(t.DriverName)
+	line(t.OrderNumber)
+	b.WriteByte('\n')
+
+	// QR code: model 2, module size 6, error correction M, store then print
+	data := []byte(t.QRContent)
+	storeLen := len(data) + 3
+	b.Write([]byte{0x1d, 0x28, 0x6b, 0x04, 0x00, 0x31, 0x41, 0x32, 0x00})
+	b.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x43, 0x06})
+	b.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x45, 0x31})
+	b.Write([]byte{0x1d, 0x28, 0x6b, byte(storeLen % 256), byte(storeLen / 256), 0x31, 0x50, 0x30})
+	b.Write(data)
+	b.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x51, 0x30})
+	b.WriteByte('\n')
+
+	line(t.timestamp())
+
+	b.Write([]byte{0x1b, 0x64, 0x04})       // feed 4 lines
+	b.Write([]byte{0x1d, 0x56, 0x42, 0x00}) // partial cut
+
+	return b.Bytes()
+}
+
+// PDF renders the ticket on an 80mm x 120mm page
+func PDF(t Ticket) ([]byte, error) {
+	width := pdf.MM(80)
+	doc := pdf.NewWithSize(width, pdf.MM(120))
+
+	centered := func(y, size float64, bold bool, text string) {
+		doc.Text((width-pdf.TextWidth(text, size))/2, y, size, bold, text)
+	}
+
+	centered(24, 11, true, t.Company)
+	centered(44, 9, false, "NO. ANTRIAN")
+	queueNumber := fmt.Sprintf("#%d", t.QueueNumber)
+	centered(90, 44, true, queueNumber)
+	centered(114, 14, true, t.VehiclePlate)
+	centered(130, 9, false, t.DriverName)
+	centered(144, 9, false, t.OrderNumber)
+
+	matrix, err := barcode.QRMatrix(t.QRContent)
+	if err != nil {
+		return nil, err
+	}
+	size := pdf.MM(45)
+	module := size / float64(len(matrix))
+	left := (width - size) / 2
+	top := 152.0
+	for row, cells := range matrix {
+		for col, dark := range cells {
+			if dark {
+				doc.FillRect(left+float64(col)*module, top+float64(row)*module, module, module)
+			}
+		}
+	}
+
+	centered(top+size+16, 9, false, t.timestamp())
+
+	return doc.Bytes(), nil
+}
+
+// zplEscape removes characters that ZPL treats as command prefixes
+func zplEscape(text string) string {
+	return strings.NewReplacer("^", " ", "~", " ").Replace(text)
+}
+
+// asciiOnly replaces characters outside the printer's default code page
+func asciiOnly(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if r < 32 || r > 126 {
+			r = '?'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
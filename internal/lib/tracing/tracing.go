@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"bg-go/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "bg-go"
+
+// Init configures the global tracer provider to export spans over OTLP/HTTP.
+// When tracing is disabled spans are no-ops. The returned function flushes pending spans.
+func Init() (func(context.Context) error, error) {
+	cfg := config.Cfg
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !cfg.Tracing.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporterOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Tracing.Endpoint)}
+	if cfg.Tracing.Insecure {
+		exporterOptions = append(exporterOptions, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), exporterOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+	}
+
+	res, err := resource.New(context.Background(), resource.WithAttributes(
+		attribute.String("service.name", cfg.App.Name),
+		attribute.String("deployment.environment.name", cfg.App.Env),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing resource: %v", err)
+	}
+
+	ratio := float64(cfg.Tracing.SamplePercent) / 100
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	log.Printf("Tracing enabled, exporting to %s (%d%% sampled)", cfg.Tracing.Endpoint, cfg.Tracing.SamplePercent)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware starts a server span for every request and stores it in the user context,
+// so handlers that pass c.UserContext() on get their database calls nested under it
+func Middleware() fiber.Handler {
+	propagator := otel.GetTextMapPropagator()
+
+	return func(c *fiber.Ctx) error {
+		carrier := propagation.MapCarrier{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			carrier[string(key)] = string(value)
+		})
+		parent := propagator.Extract(c.UserContext(), carrier)
+
+		// Spans outlive the request, so they get a copy of Fiber's reused method string.
+		// The raw path carries tokens and IDs; spans are named by the route pattern only.
+		method := utils.CopyString(c.Method())
+		ctx, span := otel.Tracer(instrumentationName).Start(parent, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", method),
+			),
+		)
+		defer span.End()
+
+		c.SetUserContext(ctx)
+		err := c.Next()
+
+		// The matched route is only known once routing has happened
+		route := c.Route().Path
+		span.SetName(method + " " + route)
+		span.SetAttributes(
+			attribute.String("http.route", route),
+			attribute.String("url.path", route),
+			attribute.Int("http.response.status_code", c.Response().StatusCode()),
+		)
+		if err != nil {
+			span.RecordError(err)
+		}
+		if c.Response().StatusCode() >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", c.Response().StatusCode()))
+		}
+
+		return err
+	}
+}
+
+// MongoMonitor creates a span for every MongoDB command, nested under the span of
+// the context the command was issued with
+func MongoMonitor() *event.CommandMonitor {
+	var spans sync.Map // request ID -> trace.Span
+
+	finish := func(requestID int64, duration time.Duration, err error) {
+		value, ok := spans.LoadAndDelete(requestID)
+		if !ok {
+			return
+		}
+		span := value.(trace.Span)
+		span.SetAttributes(attribute.Int64("db.duration_ms", duration.Milliseconds()))
+		End(span, err)
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			collection := ""
+			if value, err := evt.Command.LookupErr(evt.CommandName); err == nil {
+				collection, _ = value.StringValueOK()
+			}
+			_, span := otel.Tracer(instrumentationName).Start(ctx, "mongo."+evt.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("db.system.name", "mongodb"),
+					attribute.String("db.namespace", evt.DatabaseName),
+					attribute.String("db.collection.name", collection),
+					attribute.String("db.operation.name", evt.CommandName),
+				),
+			)
+			spans.Store(evt.RequestID, span)
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			finish(evt.RequestID, evt.Duration, nil)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			finish(evt.RequestID, evt.Duration, fmt.Errorf("%s", evt.Failure))
+		},
+	}
+}
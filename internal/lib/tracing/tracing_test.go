@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddlewareNamesSpansByRoute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	app := fiber.New()
+	app.Use(Middleware())
+	app.Get("/client/status/:token", func(c *fiber.Ctx) error {
+		return c.SendStatus(200)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/client/status/secret-token", nil))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("request: %v, %v", resp, err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans ended, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /client/status/:token" {
+		t.Fatalf("span name = %q", span.Name())
+	}
+	for _, attr := range span.Attributes() {
+		if attr.Key == "url.path" && attr.Value.AsString() != "/client/status/:token" {
+			t.Fatalf("url.path = %q, want the route", attr.Value.AsString())
+		}
+		if strings.Contains(attr.Value.Emit(), "secret-token") {
+			t.Fatalf("attribute %s holds the token: %q", attr.Key, attr.Value.Emit())
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package uom
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the unit of measure registry
+const Collection = "units"
+
+// maxSuggestions caps the units suggested for an unknown unit
+const maxSuggestions = 3
+
+// BaseUnits is the unit every quantity of a dimension converts to
+var BaseUnits = map[string]string{
+	models.UnitDimensionCount:  "pcs",
+	models.UnitDimensionMass:   "kg",
+	models.UnitDimensionVolume: "m3",
+	models.UnitDimensionLength: "m",
+}
+
+// Defaults is the registry used until admins save their own units
+func Defaults() []models.Unit {
+	return []models.Unit{
+		{Code: "pcs", Name: "Pieces", Dimension: models.UnitDimensionCount, Factor: 1, Aliases: []string{"pc", "buah", "unit"}},
+		{Code: "kg", Name: "Kilogram", Dimension: models.UnitDimensionMass, Factor: 1, Aliases: []string{"kilo", "kgs"}},
+		{Code: "ton", Name: "Ton", Dimension: models.UnitDimensionMass, Factor: 1000, Aliases: []string{"t", "tons"}},
+		{Code: "sak", Name: "Sak (50 kg)", Dimension: models.UnitDimensionMass, Factor: 50, Aliases: []string{"zak", "bag"}},
+		{Code: "m3", Name: "Meter kubik", Dimension: models.UnitDimensionVolume, Factor: 1, Aliases: []string{"kubik", "cbm"}},
+		{Code: "liter", Name: "Liter", Dimension: models.UnitDimensionVolume, Factor: 0.001, Aliases: []string{"l", "ltr"}},
+		{Code: "m", Name: "Meter", Dimension: models.UnitDimensionLength, Factor: 1, Aliases: []string{"meter"}},
+	}
+}
+
+// Registry converts quantities between the registered units
+type Registry struct {
+	units map[string]models.Unit
+	names map[string]string // Code or alias to code
+}
+
+// Normalize lowercases and trims a unit so "Sak " and "sak" are the same unit
+func Normalize(unit string) string {
+	return strings.Join(strings.Fields(strings.ToLower(unit)), " ")
+}
+
+// LoadUnits returns the saved units sorted by dimension and code, or the defaults when
+// none are saved. Read failures are returned rather than answered with the defaults, which
+// would convert admin-defined units wrongly.
+func LoadUnits(ctx context.Context) ([]models.Unit, error) {
+	cursor, err := database.GetMongoCollection(Collection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "dimension", Value: 1}, {Key: "code", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	units := []models.Unit{}
+	err = cursor.All(ctx, &units)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return Defaults(), nil
+	}
+	return units, nil
+}
+
+// Load returns a registry over the saved units. Load it once per request or report, not
+// once per item.
+func Load(ctx context.Context) (*Registry, error) {
+	units, err := LoadUnits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return New(units), nil
+}
+
+// New returns a registry over the given units
+func New(units []models.Unit) *Registry {
+	r := &Registry{units: map[string]models.Unit{}, names: map[string]string{}}
+	for _, unit := range units {
+		r.units[unit.Code] = unit
+		r.names[unit.Code] = unit.Code
+	}
+	// Codes win over aliases of other units
+	for _, unit := range units {
+		for _, alias := range unit.Aliases {
+			if _, taken := r.names[alias]; !taken {
+				r.names[alias] = unit.Code
+			}
+		}
+	}
+	return r
+}
+
+// Lookup returns the unit with the given code or alias
+func (r *Registry) Lookup(unit string) (models.Unit, bool) {
+	code, ok := r.names[Normalize(unit)]
+	if !ok {
+		return models.Unit{}, false
+	}
+	return r.units[code], true
+}
+
+// ToBase converts a quantity to the base unit of its dimension
+func (r *Registry) ToBase(quantity float64, unit string) (float64, string, bool) {
+	u, ok := r.Lookup(unit)
+	if !ok {
+		return 0, "", false
+	}
+	return quantity * u.Factor, BaseUnits[u.Dimension], true
+}
+
+// Suggest returns the codes of the units closest to an unknown unit, for typos such as
+// "sack" or "tonn"
+func (r *Registry) Suggest(unit string) []string {
+	unit = Normalize(unit)
+	if unit == "" {
+		return []string{}
+	}
+
+	type candidate struct {
+		code     string
+		distance int
+	}
+	best := map[string]int{}
+	for name, code := range r.names {
+		distance := editDistance(unit, name)
+		if strings.HasPrefix(name, unit) || strings.HasPrefix(unit, name) {
+			distance = min(distance, 1)
+		}
+		// Allow one edit for short units, two for longer ones
+		if distance > 1+len(unit)/5 {
+			continue
+		}
+		if d, seen := best[code]; !seen || distance < d {
+			best[code] = distance
+		}
+	}
+
+	candidates := []candidate{}
+	for code, distance := range best {
+		candidates = append(candidates, candidate{code, distance})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].code < candidates[j].code
+	})
+
+	suggestions := []string{}
+	for _, c := range candidates {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, c.code)
+	}
+	return suggestions
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
+
+// EnsureDefaults creates the unique code index and stores the default units when the
+// registry is empty, so admins can edit them
+func EnsureDefaults() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := database.GetMongoCollection(Collection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetName("code").SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("[UoM] Failed to create index: %v", err)
+	}
+
+	if n, err := collection.CountDocuments(ctx, bson.M{}); err != nil || n > 0 {
+		return
+	}
+	docs := []interface{}{}
+	for _, unit := range Defaults() {
+		stored := models.NewUnit()
+		unit.BaseModel = stored.BaseModel
+		docs = append(docs, unit)
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		log.Printf("[UoM] Failed to store the default units: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package uom
+
+import (
+	"reflect"
+	"testing"
+
+	"bg-go/internal/models"
+)
+
+func TestLookup(t *testing.T) {
+	registry := New(Defaults())
+	for entered, want := range map[string]string{
+		"sak":   "sak",
+		" Sak ": "sak",
+		"ZAK":   "sak",
+		"kubik": "m3",
+		"t":     "ton",
+		"Buah":  "pcs",
+	} {
+		unit, known := registry.Lookup(entered)
+		if !known || unit.Code != want {
+			t.Fatalf("Lookup(%q) = %q (%v), want %q", entered, unit.Code, known, want)
+		}
+	}
+	if _, known := registry.Lookup("sack"); known {
+		t.Fatal("Lookup(sack) found a unit")
+	}
+
+	// Codes win over aliases of other units
+	registry = New([]models.Unit{
+		{Code: "box", Dimension: models.UnitDimensionCount, Factor: 12, Aliases: []string{"dus"}},
+		{Code: "dus", Dimension: models.UnitDimensionCount, Factor: 24},
+	})
+	if unit, _ := registry.Lookup("dus"); unit.Factor != 24 {
+		t.Fatalf("Lookup(dus) = %+v, want the dus unit", unit)
+	}
+}
+
+func TestToBase(t *testing.T) {
+	registry := New(Defaults())
+	tests := []struct {
+		quantity float64
+		unit     string
+		want     float64
+		base     string
+	}{
+		{10, "sak", 500, "kg"},
+		{2, "Ton", 2000, "kg"},
+		{500, "liter", 0.5, "m3"},
+		{3, "pcs", 3, "pcs"},
+		{4, "meter", 4, "m"},
+	}
+	for _, test := range tests {
+		quantity, base, known := registry.ToBase(test.quantity, test.unit)
+		if !known || quantity != test.want || base != test.base {
+			t.Fatalf("ToBase(%v, %q) = %v %q (%v), want %v %q", test.quantity, test.unit, quantity, base, known, test.want, test.base)
+		}
+	}
+	if _, _, known := registry.ToBase(1, "crate"); known {
+		t.Fatal("ToBase converted an unknown unit")
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	registry := New(Defaults())
+	for entered, want := range map[string][]string{
+		"sack":  {"sak"},
+		"tonn":  {"ton"},
+		"kilos": {"kg"},
+		"pcss":  {"pcs"},
+		"":      {},
+		"xyzzy": {},
+	} {
+		if got := registry.Suggest(entered); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Suggest(%q) = %v, want %v", entered, got, want)
+		}
+	}
+	if got := registry.Suggest("m"); len(got) > maxSuggestions {
+		t.Fatalf("Suggest(m) = %v, want at most %d", got, maxSuggestions)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for pair, want := range map[[2]string]int{
+		{"sak", "sak"}:     0,
+		{"sack", "sak"}:    1,
+		{"kilo", "kg"}:     3,
+		{"", "ton"}:        3,
+		{"liter", "litre"}: 2,
+	} {
+		if got := editDistance(pair[0], pair[1]); got != want {
+			t.Fatalf("editDistance(%q, %q) = %d, want %d", pair[0], pair[1], got, want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// NormalizePhone converts an Indonesian phone number to international digits, e.g.
+// "0812-3456 789" and "+62 812 3456789" both become "628123456789"
+func NormalizePhone(phone string) (string, error) {
+	var b strings.Builder
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		}
+	}
+	clean := b.String()
+
+	switch {
+	case strings.HasPrefix(clean, "0"):
+		clean = "62" + clean[1:]
+	case strings.HasPrefix(clean, "8"):
+		clean = "62" + clean
+	}
+
+	if len(clean) < 10 || len(clean) > 15 {
+		return "", errors.New("invalid phone number length")
+	}
+	return clean, nil
+}
+
+// PhoneVariants returns the forms a phone number may be stored in, for lookups over
+// records that keep the number as it was entered: international digits, with a plus,
+// with a leading 0 and without a prefix, as well as the input itself
+func PhoneVariants(phone string) []string {
+	phone = strings.TrimSpace(phone)
+	normalized, err := NormalizePhone(phone)
+	if err != nil {
+		return []string{phone}
+	}
+	local := strings.TrimPrefix(normalized, "62")
+	variants := []string{normalized, "+" + normalized, "0" + local, local}
+	for _, variant := range variants {
+		if variant == phone {
+			return variants
+		}
+	}
+	return append(variants, phone)
+}
@@ -0,0 +1,23 @@
+package utils
+
+import "time"
+
+// Timezone is the business timezone: days, months and operating hours are Jakarta time
+const Timezone = "Asia/Jakarta"
+
+var jakarta = loadJakarta()
+
+// Jakarta returns the business timezone, whatever TZ the server runs in
+func Jakarta() *time.Location {
+	return jakarta
+}
+
+// loadJakarta loads the zone, falling back to a fixed UTC+7 zone when the zone database
+// is missing; Jakarta has no daylight saving time, so the two agree
+func loadJakarta() *time.Location {
+	loc, err := time.LoadLocation(Timezone)
+	if err != nil {
+		return time.FixedZone("WIB", 7*60*60)
+	}
+	return loc
+}
@@ -0,0 +1,348 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/envelope"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BackupBucket is the GridFS bucket of the session backups
+const BackupBucket = "whatsapp_session"
+
+// ErrInvalidSession is returned for an import that is not a WhatsApp session
+var ErrInvalidSession = errors.New("not a WhatsApp session")
+
+// sqliteHeader starts every SQLite database file
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+var (
+	backupMu   sync.Mutex // Serializes exports, imports and backups
+	lastBackup string     // SHA-256 of the last backed up database, to skip unchanged sessions
+)
+
+// backupFile is the GridFS file document of a backup
+type backupFile struct {
+	ID         interface{} `bson:"_id"`
+	UploadDate time.Time   `bson:"uploadDate"`
+	Metadata   struct {
+		Sealed bool   `bson:"sealed"`
+		KeyID  string `bson:"key_id,omitempty"`
+		SHA256 string `bson:"sha256"`
+	} `bson:"metadata"`
+}
+
+// sessionDir returns the configured session directory
+func sessionDir() string {
+	if path := config.Cfg.WhatsApp.SessionPath; path != "" {
+		return path
+	}
+	return "./whatsapp-session"
+}
+
+// Export returns a consistent copy of the session database, sealed with the current
+// session key when one is set. sealed tells which of the two it is.
+func Export() (data []byte, sealed bool, err error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	return export()
+}
+
+func export() ([]byte, bool, error) {
+	plaintext, err := dump()
+	if err != nil {
+		return nil, false, err
+	}
+	keyring, err := Keyring()
+	if err != nil || keyring == nil {
+		return plaintext, false, err
+	}
+	sealed, err := keyring.Seal(plaintext)
+	return sealed, true, err
+}
+
+// dump copies the live database next to the working copy
+func dump() ([]byte, error) {
+	c := WhatsApp
+	if c == nil || c.db == nil {
+		return nil, errors.New("WhatsApp is not initialized")
+	}
+	copyPath := filepath.Join(sessionDir(), SessionFile+".export")
+	if c.session != nil {
+		copyPath = c.session.workPath + ".export"
+	}
+	return copyDatabase(c.db, copyPath)
+}
+
+// Import replaces the session with an exported one and reconnects with it. The data is
+// checked before the running session is touched; an invalid upload leaves it as it is.
+func Import(data []byte) error {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	keyring, err := Keyring()
+	if err != nil {
+		return err
+	}
+	plaintext, err := decodeSession(keyring, data)
+	if err != nil {
+		return err
+	}
+
+	if WhatsApp != nil {
+		WhatsApp.Close()
+	}
+	if err := installSession(sessionDir(), keyring, plaintext); err != nil {
+		return err
+	}
+	lastBackup = ""
+	if err := Init(); err != nil {
+		return err
+	}
+	go WhatsApp.Connect()
+	return nil
+}
+
+// decodeSession decrypts sealed data and checks it is a WhatsApp session database
+func decodeSession(keyring *envelope.Keyring, data []byte) ([]byte, error) {
+	plaintext := data
+	if envelope.IsSealed(data) {
+		if keyring == nil {
+			return nil, fmt.Errorf("%w: the session is encrypted, set WHATSAPP_SESSION_KEY to import it", ErrInvalidSession)
+		}
+		opened, _, err := keyring.Open(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSession, err)
+		}
+		plaintext = opened
+	}
+	if !bytes.HasPrefix(plaintext, sqliteHeader) {
+		return nil, fmt.Errorf("%w: the file is neither a sealed session nor a SQLite database", ErrInvalidSession)
+	}
+	if err := checkDatabase(keyring, plaintext); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+	return plaintext, nil
+}
+
+// checkDatabase opens a copy of the database and looks for the whatsmeow device table.
+// The copy of an encrypted session goes to the runtime directory, like the working copy.
+func checkDatabase(keyring *envelope.Keyring, plaintext []byte) error {
+	dir := sessionDir()
+	if keyring != nil {
+		dir = config.Cfg.WhatsApp.SessionRuntimePath
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, SessionFile+".import")
+	if err := os.WriteFile(path, plaintext, 0600); err != nil {
+		return err
+	}
+	defer removeDatabase(path)
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'whatsmeow_device'").Scan(&tables); err != nil {
+		return fmt.Errorf("the database cannot be read: %v", err)
+	}
+	if tables == 0 {
+		return errors.New("the database has no WhatsApp device")
+	}
+	return nil
+}
+
+// installSession writes a session database into the session directory, sealed when a
+// key is set, for Init to open
+func installSession(sessionPath string, keyring *envelope.Keyring, plaintext []byte) error {
+	if err := os.MkdirAll(sessionPath, 0755); err != nil {
+		return err
+	}
+	plainPath := filepath.Join(sessionPath, SessionFile)
+	if keyring != nil {
+		if err := writeSealed(keyring, filepath.Join(sessionPath, SealedSessionFile), plaintext); err != nil {
+			return err
+		}
+		removeDatabase(plainPath)
+		return nil
+	}
+	if err := WriteFileAtomic(plainPath, plaintext); err != nil {
+		return err
+	}
+	// Journals of the replaced database must not be applied to the new one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		os.Remove(plainPath + suffix)
+	}
+	return nil
+}
+
+// bucket returns the GridFS bucket of the backups, or nil without a MongoDB connection
+func bucket() (*gridfs.Bucket, error) {
+	if database.DBInstance == nil || database.DBInstance.MongoDB == nil {
+		return nil, errors.New("MongoDB is not connected")
+	}
+	return gridfs.NewBucket(database.DBInstance.MongoDB, options.GridFSBucket().SetName(BackupBucket))
+}
+
+// StartBackups backs the session up to MongoDB every interval while backups are enabled
+func StartBackups(interval time.Duration) {
+	if !config.Cfg.WhatsApp.SessionBackup || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			Backup()
+		}
+	}()
+}
+
+// Backup stores the session in MongoDB when it is paired and changed since the last
+// backup, and removes all but the newest backups. An unpaired session is never backed
+// up, so a deployment that failed to restore cannot replace a good backup.
+func Backup() {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	c := WhatsApp
+	if c == nil || c.client == nil || c.client.Store.ID == nil {
+		return
+	}
+	if err := backup(); err != nil {
+		log.Printf("[WhatsApp] Failed to back up the session: %v", err)
+	}
+}
+
+func backup() error {
+	plaintext, err := dump()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(plaintext)
+	hash := hex.EncodeToString(sum[:])
+	if hash == lastBackup {
+		return nil
+	}
+
+	b, err := bucket()
+	if err != nil {
+		return err
+	}
+	keyring, err := Keyring()
+	if err != nil {
+		return err
+	}
+	data, filename := plaintext, SessionFile
+	metadata := bson.M{"sealed": false, "sha256": hash}
+	if keyring != nil {
+		if data, err = keyring.Seal(plaintext); err != nil {
+			return err
+		}
+		filename = SealedSessionFile
+		metadata["sealed"] = true
+		metadata["key_id"] = keyring.CurrentID()
+	}
+
+	b.SetWriteDeadline(time.Now().Add(time.Minute))
+	if _, err := b.UploadFromStream(filename, bytes.NewReader(data), options.GridFSUpload().SetMetadata(metadata)); err != nil {
+		return err
+	}
+	lastBackup = hash
+	log.Printf("[WhatsApp] Session backed up to MongoDB")
+
+	return prune(b, config.Cfg.WhatsApp.SessionBackupKeep)
+}
+
+// prune deletes the backups older than the newest keep
+func prune(b *gridfs.Bucket, keep int) error {
+	if keep < 1 {
+		keep = 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cursor, err := b.FindContext(ctx, bson.M{}, options.GridFSFind().SetSort(bson.M{"uploadDate": -1}).SetSkip(int32(keep)))
+	if err != nil {
+		return err
+	}
+	var old []backupFile
+	if err := cursor.All(ctx, &old); err != nil {
+		return err
+	}
+	for _, file := range old {
+		if err := b.DeleteContext(ctx, file.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBackup writes the newest backup into an empty session directory. A session
+// already on disk always wins over the backup.
+func restoreBackup(sessionPath string) error {
+	for _, name := range []string{SessionFile, SealedSessionFile} {
+		if _, err := os.Stat(filepath.Join(sessionPath, name)); err == nil {
+			return nil
+		}
+	}
+
+	b, err := bucket()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cursor, err := b.FindContext(ctx, bson.M{}, options.GridFSFind().SetSort(bson.M{"uploadDate": -1}).SetLimit(1))
+	if err != nil {
+		return err
+	}
+	var files []backupFile
+	if err := cursor.All(ctx, &files); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		log.Printf("[WhatsApp] No session backup to restore")
+		return nil
+	}
+
+	var data bytes.Buffer
+	b.SetReadDeadline(time.Now().Add(time.Minute))
+	if _, err := b.DownloadToStream(files[0].ID, &data); err != nil {
+		return err
+	}
+	keyring, err := Keyring()
+	if err != nil {
+		return err
+	}
+	plaintext, err := decodeSession(keyring, data.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := installSession(sessionPath, keyring, plaintext); err != nil {
+		return err
+	}
+	lastBackup = files[0].Metadata.SHA256
+	log.Printf("[WhatsApp] Restored the session backed up at %s", files[0].UploadDate.Format(time.RFC3339))
+	return nil
+}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"bg-go/internal/config"
+	"bg-go/internal/lib/sandbox"
+	"bg-go/internal/lib/tracing"
 
 	"github.com/skip2/go-qrcode"
 	"go.mau.fi/whatsmeow"
@@ -30,6 +32,7 @@ type Client struct {
 	container *sqlstore.Container
 	device    *store.Device
 	db        *sql.DB
+	session   *sessionStore // Encrypts the session at rest; nil when it is not encrypted
 
 	// QR code for pairing
 	qrCode     string
@@ -53,14 +56,15 @@ type Client struct {
 // WhatsApp client singleton
 var WhatsApp *Client
 
+// MessageHandler receives the sender phone and text of inbound direct messages.
+// It is set by the notification package to handle opt-out replies.
+var MessageHandler func(phone string, text string)
+
 // Init initializes the WhatsApp client
 func Init() error {
 	log.Printf("[WhatsApp] Starting initialization...")
 
-	sessionPath := config.Cfg.WhatsApp.SessionPath
-	if sessionPath == "" {
-		sessionPath = "./whatsapp-session"
-	}
+	sessionPath := sessionDir()
 
 	// Create session directory if not exists
 	err := os.MkdirAll(sessionPath, 0755)
@@ -70,8 +74,20 @@ func Init() error {
 	}
 	log.Printf("[WhatsApp] Session directory: %s", sessionPath)
 
-	// Database path - using modernc.org/sqlite (pure Go, no CGO)
-	dbPath := fmt.Sprintf("%s/whatsapp.db", sessionPath)
+	// A fresh deployment starts from the session backed up in MongoDB, if any
+	if config.Cfg.WhatsApp.SessionBackup {
+		if err := restoreBackup(sessionPath); err != nil {
+			log.Printf("[WhatsApp] Failed to restore the session backup: %v", err)
+		}
+	}
+
+	// Database path - using modernc.org/sqlite (pure Go, no CGO). An encrypted session
+	// is decrypted into the runtime directory first.
+	dbPath, session, err := openSession(sessionPath)
+	if err != nil {
+		log.Printf("[WhatsApp] Failed to open session: %v", err)
+		return fmt.Errorf("failed to open session: %v", err)
+	}
 	log.Printf("[WhatsApp] Database path: %s", dbPath)
 
 	// Create context
@@ -137,6 +153,7 @@ func Init() error {
 		device:    deviceStore,
 		client:    client,
 		db:        db,
+		session:   session,
 		ctx:       ctx,
 		cancel:    cancel,
 	}
@@ -144,6 +161,11 @@ func Init() error {
 	// Set up event handlers
 	WhatsApp.setupEventHandlers()
 
+	if session != nil {
+		session.start(db, config.Cfg.WhatsApp.SessionSnapshotInterval)
+		log.Printf("[WhatsApp] Session encrypted at rest with key %s", session.keyring.CurrentID())
+	}
+
 	log.Printf("[WhatsApp] Initialization complete")
 	return nil
 }
@@ -158,14 +180,18 @@ func (c *Client) setupEventHandlers() {
 			c.handleDisconnected()
 		case *events.LoggedOut:
 			c.handleLoggedOut()
+			go c.Snapshot()
 		case *events.PairSuccess:
 			log.Printf("[WhatsApp] Pair success: %v", v.ID)
 			c.handleConnected()
+			go c.Snapshot()
 		case *events.PairError:
 			log.Printf("[WhatsApp] Pair error: %v", v.Error)
 			c.mu.Lock()
 			c.lastError = fmt.Sprintf("Pair error: %v", v.Error)
 			c.mu.Unlock()
+		case *events.Message:
+			c.handleMessage(v)
 		case *events.StreamError:
 			log.Printf("[WhatsApp] Stream error: %v", v)
 			c.mu.Lock()
@@ -187,6 +213,32 @@ func (c *Client) handleConnected() {
 	log.Printf("[WhatsApp] Connected successfully!")
 }
 
+// handleMessage passes inbound direct text messages to MessageHandler
+func (c *Client) handleMessage(evt *events.Message) {
+	if MessageHandler == nil || evt.Info.IsFromMe || evt.Info.IsGroup {
+		return
+	}
+
+	// Senders may be addressed by LID; the phone is then in the alternative address
+	sender := evt.Info.Sender
+	if sender.Server != types.DefaultUserServer {
+		sender = evt.Info.SenderAlt
+	}
+	if sender.Server != types.DefaultUserServer {
+		return
+	}
+
+	text := evt.Message.GetConversation()
+	if text == "" {
+		text = evt.Message.GetExtendedTextMessage().GetText()
+	}
+	if text == "" {
+		return
+	}
+
+	go MessageHandler(sender.User, text)
+}
+
 // handleDisconnected handles disconnected event
 func (c *Client) handleDisconnected() {
 	c.mu.Lock()
@@ -314,6 +366,7 @@ func (c *Client) GetStatus() map[string]interface{} {
 	hasSession := c.client.Store.ID != nil
 
 	return map[string]interface{}{
+		"provider":       ProviderWhatsmeow,
 		"connected":      c.connected,
 		"logged_in":      c.loggedIn,
 		"qr_code":        c.qrCode,
@@ -349,8 +402,46 @@ func parsePhoneToJID(phone string) (types.JID, error) {
 	}, nil
 }
 
+// registrationTTL is how long whether a number uses WhatsApp is remembered
+const registrationTTL = 24 * time.Hour
+
+// registration is a cached IsOnWhatsApp answer
+type registration struct {
+	isIn      bool
+	checkedAt time.Time
+}
+
+var (
+	registrationsMu sync.Mutex
+	registrations   = map[string]registration{}
+)
+
+// onWhatsApp reports whether a number uses WhatsApp. A failed lookup counts as yes, so
+// the send itself decides.
+func (c *Client) onWhatsApp(ctx context.Context, user string) bool {
+	registrationsMu.Lock()
+	cached, ok := registrations[user]
+	registrationsMu.Unlock()
+	if ok && time.Since(cached.checkedAt) < registrationTTL {
+		return cached.isIn
+	}
+
+	results, err := c.client.IsOnWhatsApp(ctx, []string{"+" + user})
+	if err != nil || len(results) == 0 {
+		return true
+	}
+	registrationsMu.Lock()
+	registrations[user] = registration{isIn: results[0].IsIn, checkedAt: time.Now()}
+	registrationsMu.Unlock()
+	return results[0].IsIn
+}
+
 // SendMessage sends a text message to a phone number
 func (c *Client) SendMessage(phone string, message string) error {
+	if sandbox.Intercepts(phone) {
+		return sandbox.Capture(sandbox.ChannelWhatsApp, phone, "", message)
+	}
+
 	c.mu.RLock()
 	connected := c.connected
 	c.mu.RUnlock()
@@ -368,13 +459,19 @@ func (c *Client) SendMessage(phone string, message string) error {
 	ctx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
 	defer cancel()
 
+	if !c.onWhatsApp(ctx, jid.User) {
+		return fmt.Errorf("%w: %s", ErrNoWhatsApp, phone)
+	}
+
 	// Create text message
 	msg := &waE2E.Message{
 		Conversation: proto.String(message),
 	}
 
 	// Send message
+	ctx, span := tracing.Start(ctx, "whatsapp.send")
 	_, err = c.client.SendMessage(ctx, jid, msg)
+	tracing.End(span, err)
 	if err != nil {
 		log.Printf("[WhatsApp] Failed to send message: %v", err)
 		return err
@@ -388,6 +485,9 @@ func (c *Client) SendMessage(phone string, message string) error {
 func (c *Client) Close() {
 	log.Printf("[WhatsApp] Closing...")
 	c.client.Disconnect()
+	if c.session != nil {
+		c.session.stop()
+	}
 	if c.db != nil {
 		c.db.Close()
 	}
@@ -424,6 +524,8 @@ func (c *Client) Logout() error {
 	c.qrCodeData = ""
 	c.lastError = ""
 
+	go c.Snapshot()
+
 	log.Printf("[WhatsApp] Logged out")
 	return nil
 }
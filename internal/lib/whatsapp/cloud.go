@@ -0,0 +1,182 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/sandbox"
+	"bg-go/internal/lib/tracing"
+)
+
+// CloudClient sends messages through the Meta WhatsApp Cloud API. Free-form text is
+// only delivered within 24 hours of the recipient's last message to the business
+// number; outside that window the API rejects it and the notification is retried and
+// dead-lettered like any failed send.
+type CloudClient struct {
+	url           string
+	phoneNumberID string
+	token         string
+	http          *http.Client
+
+	mu         sync.RWMutex
+	lastError  string
+	lastSentAt time.Time
+}
+
+// Cloud is the Cloud API provider, nil unless it is configured
+var Cloud *CloudClient
+
+// InitCloud sets up the Cloud API provider when its phone number and token are set
+func InitCloud() {
+	cfg := config.Cfg.WhatsApp
+	if cfg.Provider != ProviderWhatsmeow && cfg.Provider != ProviderCloud {
+		log.Printf("[WhatsApp] Unknown WHATSAPP_PROVIDER %q, use %s or %s", cfg.Provider, ProviderWhatsmeow, ProviderCloud)
+	}
+	if cfg.CloudPhoneNumberID == "" || cfg.CloudAccessToken == "" {
+		Cloud = nil
+		if cfg.Provider == ProviderCloud {
+			log.Printf("[WhatsApp] The cloud provider needs WHATSAPP_CLOUD_PHONE_NUMBER_ID and WHATSAPP_CLOUD_ACCESS_TOKEN")
+		}
+		return
+	}
+	Cloud = &CloudClient{
+		url:           strings.TrimRight(cfg.CloudURL, "/"),
+		phoneNumberID: cfg.CloudPhoneNumberID,
+		token:         cfg.CloudAccessToken,
+		http:          &http.Client{Timeout: cfg.CloudTimeout},
+	}
+	log.Printf("[WhatsApp] Cloud API provider ready for phone number %s", cfg.CloudPhoneNumberID)
+}
+
+// Name identifies the Cloud API as a provider
+func (c *CloudClient) Name() string {
+	return ProviderCloud
+}
+
+// IsConnected is always true, the API is stateless
+func (c *CloudClient) IsConnected() bool {
+	return true
+}
+
+// IsLoggedIn is always true once configured; a revoked token shows as send errors
+func (c *CloudClient) IsLoggedIn() bool {
+	return true
+}
+
+// GetStatus returns the provider status in the shape of the whatsmeow client's
+func (c *CloudClient) GetStatus() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := map[string]interface{}{
+		"provider":        ProviderCloud,
+		"connected":       true,
+		"logged_in":       true,
+		"phone_number_id": c.phoneNumberID,
+		"last_error":      c.lastError,
+	}
+	if !c.lastSentAt.IsZero() {
+		status["last_sent_at"] = c.lastSentAt
+	}
+	return status
+}
+
+// cloudMessage is the body of a text message request
+type cloudMessage struct {
+	MessagingProduct string `json:"messaging_product"`
+	RecipientType    string `json:"recipient_type"`
+	To               string `json:"to"`
+	Type             string `json:"type"`
+	Text             struct {
+		Body       string `json:"body"`
+		PreviewURL bool   `json:"preview_url"`
+	} `json:"text"`
+}
+
+// cloudUndeliverable is the Cloud API error for a recipient without WhatsApp
+const cloudUndeliverable = 131026
+
+// cloudError is the error body of the Graph API
+type cloudError struct {
+	Error struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
+
+// SendMessage sends a text message to a phone number
+func (c *CloudClient) SendMessage(phone string, message string) error {
+	if sandbox.Intercepts(phone) {
+		return sandbox.Capture(sandbox.ChannelWhatsApp, phone, "", message)
+	}
+
+	jid, err := parsePhoneToJID(phone)
+	if err != nil {
+		return err
+	}
+	body := cloudMessage{MessagingProduct: "whatsapp", RecipientType: "individual", To: jid.User, Type: "text"}
+	body.Text.Body = message
+	body.Text.PreviewURL = strings.Contains(message, "http")
+
+	ctx, span := tracing.Start(context.Background(), "whatsapp.cloud.send")
+	err = c.post(ctx, body)
+	tracing.End(span, err)
+
+	c.mu.Lock()
+	if err != nil {
+		c.lastError = err.Error()
+	} else {
+		c.lastError = ""
+		c.lastSentAt = time.Now()
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		log.Printf("[WhatsApp] Cloud API failed to send message: %v", err)
+		return err
+	}
+	log.Printf("[WhatsApp] Message sent to %s via the Cloud API", phone)
+	return nil
+}
+
+// post sends a message request and turns error responses into errors
+func (c *CloudClient) post(ctx context.Context, message cloudMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/"+c.phoneNumberID+"/messages", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var apiErr cloudError
+	if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error.Message != "" {
+		if apiErr.Error.Code == cloudUndeliverable {
+			return fmt.Errorf("%w: cloud API error %d: %s", ErrNoWhatsApp, apiErr.Error.Code, apiErr.Error.Message)
+		}
+		return fmt.Errorf("cloud API error %d: %s", apiErr.Error.Code, apiErr.Error.Message)
+	}
+	return fmt.Errorf("cloud API returned status %d", resp.StatusCode)
+}
@@ -0,0 +1,133 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"bg-go/internal/lib/sandbox"
+	"bg-go/internal/lib/tracing"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Group is a WhatsApp group the logged in account participates in
+type Group struct {
+	JID          string `json:"jid"`
+	Name         string `json:"name"`
+	Topic        string `json:"topic,omitempty"`
+	Participants int    `json:"participants"`
+	AnnounceOnly bool   `json:"announce_only"` // Only admins may send
+	IsAdmin      bool   `json:"is_admin"`      // The account is an admin of the group
+}
+
+// GroupSender lists groups and sends to them and to phones. Only the whatsmeow client
+// supports groups; tests replace it with SetGroupSender.
+type GroupSender interface {
+	IsLoggedIn() bool
+	JoinedGroups() ([]Group, error)
+	SendGroupMessage(groupJID string, message string) error
+	SendMessage(phone string, message string) error
+}
+
+// groupSender replaces the whatsmeow client for groups when set
+var groupSender GroupSender
+
+// SetGroupSender replaces the group sender, nil restores the whatsmeow client
+func SetGroupSender(s GroupSender) {
+	groupSender = s
+}
+
+// Groups returns the group sender, or nil when the whatsmeow client is not set up
+func Groups() GroupSender {
+	if groupSender != nil {
+		return groupSender
+	}
+	// Checked separately, a nil *Client would make a non-nil GroupSender
+	if WhatsApp != nil {
+		return WhatsApp
+	}
+	return nil
+}
+
+// JoinedGroups returns the groups the account participates in, sorted by name
+func (c *Client) JoinedGroups() ([]Group, error) {
+	if !c.IsLoggedIn() {
+		return nil, fmt.Errorf("not logged in")
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
+	defer cancel()
+
+	infos, err := c.client.GetJoinedGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	self := types.EmptyJID
+	if c.client.Store.ID != nil {
+		self = c.client.Store.ID.ToNonAD()
+	}
+
+	groups := make([]Group, 0, len(infos))
+	for _, info := range infos {
+		group := Group{
+			JID:          info.JID.String(),
+			Name:         info.Name,
+			Topic:        info.Topic,
+			Participants: len(info.Participants),
+			AnnounceOnly: info.IsAnnounce,
+		}
+		for _, participant := range info.Participants {
+			if participant.JID.User == self.User && (participant.IsAdmin || participant.IsSuperAdmin) {
+				group.IsAdmin = true
+			}
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// SendGroupMessage sends a text message to a group by its JID (e.g. 120363...@g.us)
+func (c *Client) SendGroupMessage(groupJID string, message string) error {
+	if sandbox.Intercepts(groupJID) {
+		return sandbox.Capture(sandbox.ChannelWhatsApp, groupJID, "", message)
+	}
+
+	c.mu.RLock()
+	connected := c.connected
+	c.mu.RUnlock()
+
+	if !connected {
+		return fmt.Errorf("not connected")
+	}
+
+	jid, err := types.ParseJID(groupJID)
+	if err != nil {
+		return err
+	}
+	if jid.Server != types.GroupServer {
+		return fmt.Errorf("%s is not a group JID", groupJID)
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "whatsapp.send_group")
+	_, err = c.client.SendMessage(ctx, jid, &waE2E.Message{
+		Conversation: proto.String(message),
+	})
+	tracing.End(span, err)
+	if err != nil {
+		log.Printf("[WhatsApp] Failed to send group message: %v", err)
+		return err
+	}
+
+	log.Printf("[WhatsApp] Message sent to group %s", groupJID)
+	return nil
+}
@@ -0,0 +1,89 @@
+package whatsapp
+
+import (
+	"errors"
+
+	"bg-go/internal/config"
+)
+
+// Provider names, as set in WHATSAPP_PROVIDER
+const (
+	ProviderWhatsmeow = "whatsmeow"
+	ProviderCloud     = "cloud"
+)
+
+// ErrNoWhatsApp is returned by SendMessage when the recipient has no WhatsApp account
+var ErrNoWhatsApp = errors.New("the number is not on WhatsApp")
+
+// Provider sends text messages through one WhatsApp API. Everything beyond direct
+// messages, such as groups and pairing, stays specific to the whatsmeow client.
+type Provider interface {
+	Name() string
+	IsConnected() bool
+	IsLoggedIn() bool
+	SendMessage(phone string, message string) error
+	GetStatus() map[string]interface{}
+}
+
+// Name identifies the whatsmeow client as a provider
+func (c *Client) Name() string {
+	return ProviderWhatsmeow
+}
+
+// provider returns the provider of a name, or nil when it is not set up
+func provider(name string) Provider {
+	switch name {
+	case ProviderCloud:
+		// Checked separately, a nil *CloudClient would make a non-nil Provider
+		if Cloud != nil {
+			return Cloud
+		}
+	case ProviderWhatsmeow:
+		if WhatsApp != nil {
+			return WhatsApp
+		}
+	}
+	return nil
+}
+
+// Providers returns the providers that are set up, the one selected by WHATSAPP_PROVIDER
+// first and the other after it when WHATSAPP_PROVIDER_FALLBACK is on
+func Providers() []Provider {
+	cfg := config.Cfg.WhatsApp
+	names := []string{cfg.Provider}
+	if cfg.ProviderFallback {
+		switch cfg.Provider {
+		case ProviderCloud:
+			names = append(names, ProviderWhatsmeow)
+		default:
+			names = append(names, ProviderCloud)
+		}
+	}
+
+	var providers []Provider
+	for _, name := range names {
+		if p := provider(name); p != nil {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// Active returns the first provider able to send, or nil while none is
+func Active() Provider {
+	for _, p := range Providers() {
+		if p.IsLoggedIn() {
+			return p
+		}
+	}
+	return nil
+}
+
+// Statuses returns the status of every provider that is set up, by name
+func Statuses() map[string]interface{} {
+	statuses := map[string]interface{}{}
+	for _, p := range Providers() {
+		statuses[p.Name()] = p.GetStatus()
+	}
+	return statuses
+}
@@ -0,0 +1,232 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/envelope"
+)
+
+// File names in the session directory
+const (
+	SessionFile       = "whatsapp.db"     // Plaintext session database
+	SealedSessionFile = "whatsapp.db.enc" // Encrypted snapshot of the session database
+)
+
+// sessionStore keeps the session database encrypted at rest. The database is decrypted
+// into the runtime directory on start and snapshotted back, encrypted, while running.
+type sessionStore struct {
+	keyring    *envelope.Keyring
+	sealedPath string
+	workPath   string
+	legacyPath string // Plaintext database found on start, removed after the first snapshot
+
+	mu   sync.Mutex
+	db   *sql.DB
+	done chan struct{}
+}
+
+// Keyring returns the keyring of the configured session keys, or nil when the session
+// is not encrypted
+func Keyring() (*envelope.Keyring, error) {
+	cfg := config.Cfg.WhatsApp
+	if cfg.SessionKey == "" {
+		return nil, nil
+	}
+	return envelope.NewKeyring(cfg.SessionKey, cfg.SessionOldKeys)
+}
+
+// openSession returns the path of the database to open and, when the session is
+// encrypted, the store that snapshots it
+func openSession(sessionPath string) (string, *sessionStore, error) {
+	sealedPath := filepath.Join(sessionPath, SealedSessionFile)
+	plainPath := filepath.Join(sessionPath, SessionFile)
+
+	keyring, err := Keyring()
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid WHATSAPP_SESSION_KEY: %v", err)
+	}
+	if keyring == nil {
+		if _, err := os.Stat(sealedPath); err == nil {
+			if _, err := os.Stat(plainPath); errors.Is(err, os.ErrNotExist) {
+				return "", nil, fmt.Errorf("the session is encrypted, set WHATSAPP_SESSION_KEY to open it")
+			}
+		}
+		return plainPath, nil, nil
+	}
+
+	runtimePath := config.Cfg.WhatsApp.SessionRuntimePath
+	if err := os.MkdirAll(runtimePath, 0700); err != nil {
+		return "", nil, fmt.Errorf("failed to create runtime directory: %v", err)
+	}
+	s := &sessionStore{
+		keyring:    keyring,
+		sealedPath: sealedPath,
+		workPath:   filepath.Join(runtimePath, SessionFile),
+	}
+	removeDatabase(s.workPath)
+
+	sealed, err := os.ReadFile(sealedPath)
+	switch {
+	case err == nil:
+		plaintext, current, err := keyring.Open(sealed)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to decrypt %s: %v", sealedPath, err)
+		}
+		if err := os.WriteFile(s.workPath, plaintext, 0600); err != nil {
+			return "", nil, err
+		}
+		if !current {
+			log.Printf("[WhatsApp] Session was sealed with an old key, re-encrypting with key %s", keyring.CurrentID())
+			if err := writeSealed(keyring, sealedPath, plaintext); err != nil {
+				return "", nil, err
+			}
+		}
+	case errors.Is(err, os.ErrNotExist):
+		// Encryption was just enabled: start from the plaintext session, if any
+		if plaintext, err := os.ReadFile(plainPath); err == nil {
+			log.Printf("[WhatsApp] Encrypting the plaintext session in %s", sessionPath)
+			if err := os.WriteFile(s.workPath, plaintext, 0600); err != nil {
+				return "", nil, err
+			}
+			s.legacyPath = plainPath
+		}
+	default:
+		return "", nil, err
+	}
+	return s.workPath, s, nil
+}
+
+// start snapshots the session every interval
+func (s *sessionStore) start(db *sql.DB, interval time.Duration) {
+	s.mu.Lock()
+	s.db = db
+	s.done = make(chan struct{})
+	done := s.done
+	s.mu.Unlock()
+
+	if err := s.snapshot(); err != nil {
+		log.Printf("[WhatsApp] Failed to encrypt the session: %v", err)
+	}
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.snapshot(); err != nil {
+					log.Printf("[WhatsApp] Failed to encrypt the session: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// stop ends the snapshots, waiting for a running one, and takes a final snapshot before
+// the database is closed, so changes since the last interval are not lost
+func (s *sessionStore) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+	if err := s.snapshotLocked(); err != nil {
+		log.Printf("[WhatsApp] Failed to encrypt the session: %v", err)
+	}
+	s.db = nil
+}
+
+// snapshot copies the live database consistently and stores it encrypted
+func (s *sessionStore) snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotLocked()
+}
+
+// snapshotLocked is snapshot with s.mu held
+func (s *sessionStore) snapshotLocked() error {
+	if s.db == nil {
+		return nil
+	}
+
+	plaintext, err := copyDatabase(s.db, s.workPath+".snapshot")
+	if err != nil {
+		return err
+	}
+	if err := writeSealed(s.keyring, s.sealedPath, plaintext); err != nil {
+		return err
+	}
+
+	if s.legacyPath != "" {
+		removeDatabase(s.legacyPath)
+		log.Printf("[WhatsApp] Removed the plaintext session %s", s.legacyPath)
+		s.legacyPath = ""
+	}
+	return nil
+}
+
+// Snapshot stores the session right away, e.g. after pairing: encrypted in the session
+// directory when a key is set, and in the MongoDB backup when backups are enabled
+func (c *Client) Snapshot() {
+	if c.session != nil {
+		if err := c.session.snapshot(); err != nil {
+			log.Printf("[WhatsApp] Failed to encrypt the session: %v", err)
+		}
+	}
+	if config.Cfg.WhatsApp.SessionBackup {
+		Backup()
+	}
+}
+
+// copyDatabase copies the live database consistently through copyPath and returns it
+func copyDatabase(db *sql.DB, copyPath string) ([]byte, error) {
+	os.Remove(copyPath)
+	defer os.Remove(copyPath)
+	if _, err := db.Exec("VACUUM INTO ?", copyPath); err != nil {
+		return nil, fmt.Errorf("failed to copy the database: %v", err)
+	}
+	return os.ReadFile(copyPath)
+}
+
+// writeSealed encrypts plaintext into path, replacing it atomically
+func writeSealed(keyring *envelope.Keyring, path string, plaintext []byte) error {
+	sealed, err := keyring.Seal(plaintext)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, sealed)
+}
+
+// WriteFileAtomic replaces a file with data through a temporary file, so a crash never
+// leaves a partly written session
+func WriteFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeDatabase removes a SQLite database with its journal files
+func removeDatabase(path string) {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
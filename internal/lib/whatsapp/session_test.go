@@ -0,0 +1,66 @@
+package whatsapp
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bg-go/internal/lib/envelope"
+)
+
+func TestSessionFinalSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	keyring, err := envelope.NewKeyring("session test key", nil)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	s := &sessionStore{
+		keyring:    keyring,
+		sealedPath: filepath.Join(dir, SealedSessionFile),
+		workPath:   filepath.Join(dir, SessionFile),
+	}
+
+	db, err := sql.Open("sqlite", s.workPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE devices (jid TEXT)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// Without an interval only the first snapshot is taken; the write after it is
+	// stored by the final snapshot on stop
+	s.start(db, 0)
+	if _, err := db.Exec("INSERT INTO devices VALUES ('628111@s.whatsapp.net')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	s.stop()
+
+	sealed, err := os.ReadFile(s.sealedPath)
+	if err != nil {
+		t.Fatalf("read sealed: %v", err)
+	}
+	plaintext, _, err := keyring.Open(sealed)
+	if err != nil {
+		t.Fatalf("open sealed: %v", err)
+	}
+	restored := filepath.Join(dir, "restored.db")
+	if err := os.WriteFile(restored, plaintext, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	snapshot, err := sql.Open("sqlite", restored)
+	if err != nil {
+		t.Fatalf("open restored: %v", err)
+	}
+	defer snapshot.Close()
+
+	var count int
+	if err := snapshot.QueryRow("SELECT COUNT(*) FROM devices").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("devices in the snapshot = %d, %v, want 1", count, err)
+	}
+
+	// Stopping again does not snapshot a closed database
+	s.stop()
+}
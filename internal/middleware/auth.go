@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"context"
 	"strings"
+	"time"
 
 	"bg-go/internal/lib/jwt"
 	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/session"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -15,26 +18,47 @@ func AuthGuard() fiber.Handler {
 		authHeader := c.Get("Authorization")
 		
 		if authHeader == "" {
-			return response.Unauthorized(c, "No token provided")
+			return response.ErrorCode(c, 401, response.CodeTokenMissing, "No token provided")
 		}
 		
 		// Extract token from "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			return response.Unauthorized(c, "Invalid token format")
+			return response.ErrorCode(c, 401, response.CodeTokenInvalid, "Invalid token format")
 		}
 		
 		tokenString := parts[1]
 		
 		claims, err := jwt.VerifyAccessToken(tokenString)
 		if err != nil {
-			return response.Unauthorized(c, "Invalid or expired token")
+			if jwt.IsExpired(err) {
+				return response.ErrorCode(c, 401, response.CodeTokenExpired, "Token has expired")
+			}
+			return response.ErrorCode(c, 401, response.CodeTokenInvalid, "Invalid or expired token")
+		}
+
+		// A revoked session ends its access tokens too. Impersonation tokens carry no
+		// session and are short-lived. Other tokens without a session predate session
+		// tracking and are refused like revoked ones, so their holders sign in again.
+		if claims.ActorID == "" {
+			ctx, cancel := context.WithTimeout(c.UserContext(), 5*time.Second)
+			active, err := session.Active(ctx, claims.SessionID)
+			cancel()
+			if err != nil {
+				return response.Error(c, fiber.StatusServiceUnavailable, "Could not verify the session, please retry shortly")
+			}
+			if !active {
+				return response.ErrorCode(c, 401, response.CodeSessionRevoked, "Session has been revoked")
+			}
 		}
 		
 		// Store claims in locals for later use
 		c.Locals("user", claims)
 		c.Locals("user_id", claims.UserID)
 		c.Locals("role", claims.Role)
+		if claims.ActorID != "" {
+			c.Locals("actor_id", claims.ActorID)
+		}
 		
 		return c.Next()
 	}
@@ -57,7 +81,7 @@ func RoleGuard(allowedRoles ...string) fiber.Handler {
 			}
 		}
 		
-		return response.Error(c, 403, "Insufficient permissions")
+		return response.ErrorCode(c, 403, response.CodeInsufficientPermissions, "Insufficient permissions")
 	}
 }
 
@@ -69,6 +93,20 @@ func GetUserID(c *fiber.Ctx) string {
 	return ""
 }
 
+// GetActorID returns the real user behind the request.
+// While impersonating this is the admin, otherwise it equals GetUserID.
+func GetActorID(c *fiber.Ctx) string {
+	if actorID := c.Locals("actor_id"); actorID != nil {
+		return actorID.(string)
+	}
+	return GetUserID(c)
+}
+
+// IsImpersonating reports whether the request uses an impersonation token
+func IsImpersonating(c *fiber.Ctx) bool {
+	return c.Locals("actor_id") != nil
+}
+
 // GetUserRole extracts user role from context
 func GetUserRole(c *fiber.Ctx) string {
 	if role := c.Locals("role"); role != nil {
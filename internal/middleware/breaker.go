@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/breaker"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DatabaseBreaker fails requests fast with 503 while the database breaker is open,
+// instead of letting each one wait for driver timeouts. Health checks always pass.
+func DatabaseBreaker() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if strings.HasPrefix(c.Path(), "/health") {
+			return c.Next()
+		}
+
+		if err := breaker.Get(breaker.Database).Allow(); err != nil {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(config.Cfg.Breaker.Cooldown.Seconds())))
+			return response.Error(c, fiber.StatusServiceUnavailable, "Database is temporarily unavailable, please retry shortly")
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RequestLogCollection is the capped collection holding captured requests
+const RequestLogCollection = "request_logs"
+
+const redacted = "[REDACTED]"
+
+var (
+	// secretKeys are JSON keys whose values are always removed
+	secretKeys = regexp.MustCompile(`(?i)(password|token|secret|otp|authorization|api_?key)`)
+	// phoneKeys are JSON keys whose values are masked
+	phoneKeys = regexp.MustCompile(`(?i)(phone|whatsapp)`)
+	// phonePattern finds phone numbers in free text
+	phonePattern = regexp.MustCompile(`\+?\b(?:62|0)8\d{7,11}\b`)
+	// tokenSegment finds access tokens embedded in paths, e.g. /client/invoice/<token>
+	tokenSegment = regexp.MustCompile(`/[0-9a-fA-F]{24,}(/|$)`)
+)
+
+// capturedHeaders are the request headers worth keeping
+var capturedHeaders = []string{"Content-Type", "User-Agent", "Origin", "Referer", "X-Request-Id"}
+
+// RequestCapture stores a sample of requests with their responses in a capped collection.
+// Server errors are always captured. Passwords, tokens and phone numbers are redacted.
+func RequestCapture() fiber.Handler {
+	cfg := config.Cfg.Capture
+	if !cfg.Enabled {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	if err := database.EnsureCappedCollection(RequestLogCollection, cfg.StorageBytes); err != nil {
+		log.Printf("[Capture] Failed to prepare %s: %v", RequestLogCollection, err)
+	}
+
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			if fiberErr, ok := err.(*fiber.Error); ok {
+				status = fiberErr.Code
+			} else {
+				status = 500
+			}
+		}
+		if status < 500 && rand.Intn(100) >= cfg.SamplePercent {
+			return err
+		}
+
+		// The entry is stored after the handler returns, when fasthttp reuses the request
+		// buffers, so request strings are copied
+		entry := models.RequestLog{
+			ID:          primitive.NewObjectID(),
+			Method:      utils.CopyString(c.Method()),
+			Path:        tokenSegment.ReplaceAllString(utils.CopyString(c.Path()), "/[token]$1"),
+			Query:       redactQuery(string(c.Request().URI().QueryString())),
+			Status:      status,
+			DurationMs:  time.Since(start).Milliseconds(),
+			IP:          c.IP(),
+			UserID:      GetUserID(c),
+			Role:        GetUserRole(c),
+			RequestBody: captureBody(string(c.Request().Header.ContentType()), c.Body(), cfg.MaxBodyBytes),
+			CreatedAt:   start,
+		}
+
+		// Reading a streamed body would buffer the whole download in memory
+		if c.Response().IsBodyStream() {
+			entry.ResponseBody = "[streamed]"
+		} else {
+			entry.ResponseBody = captureBody(string(c.Response().Header.ContentType()), c.Response().Body(), cfg.MaxBodyBytes)
+		}
+
+		entry.RequestHeaders = map[string]string{}
+		for _, name := range capturedHeaders {
+			if value := c.Get(name); value != "" {
+				entry.RequestHeaders[name] = utils.CopyString(value)
+			}
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := database.GetMongoCollection(RequestLogCollection).InsertOne(ctx, entry); err != nil {
+				log.Printf("[Capture] Failed to store request log: %v", err)
+			}
+		}()
+
+		return err
+	}
+}
+
+// captureBody returns a redacted, truncated copy of a body.
+// Uploads and binary downloads are summarized instead of stored.
+func captureBody(contentType string, body []byte, maxBytes int) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	switch {
+	case strings.HasPrefix(contentType, "multipart/"):
+		return fmt.Sprintf("[multipart %d bytes]", len(body))
+	case strings.HasPrefix(contentType, "application/json"):
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err == nil {
+			if redactedBody, err := json.Marshal(redactValue("", value)); err == nil {
+				return truncate(string(redactedBody), maxBytes)
+			}
+		}
+		return truncate(phonePattern.ReplaceAllStringFunc(string(body), maskPhone), maxBytes)
+	case strings.HasPrefix(contentType, "text/"), strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		return truncate(redactQuery(string(body)), maxBytes)
+	}
+
+	return fmt.Sprintf("[%s %d bytes]", contentType, len(body))
+}
+
+// redactValue walks decoded JSON and redacts secrets and phone numbers
+func redactValue(key string, value interface{}) interface{} {
+	if key != "" && secretKeys.MatchString(key) {
+		return redacted
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = redactValue(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(key, item)
+		}
+		return v
+	case string:
+		if key != "" && phoneKeys.MatchString(key) {
+			return maskPhone(v)
+		}
+		return phonePattern.ReplaceAllStringFunc(v, maskPhone)
+	}
+
+	return value
+}
+
+// redactQuery redacts secrets and phone numbers in a query string or form body
+func redactQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		switch {
+		case secretKeys.MatchString(key):
+			pairs[i] = key + "=" + redacted
+		case phoneKeys.MatchString(key):
+			pairs[i] = key + "=" + maskPhone(value)
+		default:
+			pairs[i] = key + "=" + phonePattern.ReplaceAllStringFunc(value, maskPhone)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// maskPhone keeps only the last three digits of a phone number
+func maskPhone(phone string) string {
+	if len(phone) <= 3 {
+		return strings.Repeat("*", len(phone))
+	}
+	return strings.Repeat("*", len(phone)-3) + phone[len(phone)-3:]
+}
+
+func truncate(s string, maxBytes int) string {
+	if maxBytes <= 0 || len(s) <= maxBytes {
+		return s
+	}
+	return s[:maxBytes] + "…[truncated]"
+}
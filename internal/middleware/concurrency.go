@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/response"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Concurrency limit groups
+const (
+	LimitReports   = "reports"
+	LimitExports   = "exports"
+	LimitDashboard = "dashboard"
+)
+
+// limiter is the semaphore shared by every route of a group
+type limiter struct {
+	name     string
+	slots    chan struct{}
+	rejected atomic.Int64
+}
+
+// LimitStatus is a snapshot of a concurrency limit group
+type LimitStatus struct {
+	Name      string `json:"name"`
+	InFlight  int    `json:"in_flight"`
+	MaxFlight int    `json:"max_in_flight"`
+	Rejected  int64  `json:"rejected"`
+}
+
+// slotLocal is the locals key of the request's concurrency slot
+const slotLocal = "concurrency_slot"
+
+// slot is a request's place in a limiter, released once, by the middleware or, when
+// held, by whoever streams the response
+type slot struct {
+	limiter *limiter
+	held    bool
+	once    sync.Once
+}
+
+func (s *slot) release() {
+	s.once.Do(func() { <-s.limiter.slots })
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*limiter{}
+)
+
+// groupLimit returns the configured in-flight maximum of a group
+func groupLimit(group string) int {
+	cfg := config.Cfg.Concurrency
+	switch group {
+	case LimitReports:
+		return cfg.Reports
+	case LimitExports:
+		return cfg.Exports
+	case LimitDashboard:
+		return cfg.Dashboard
+	}
+	return 0
+}
+
+// getLimiter returns the limiter of a group, creating it on first use; nil when unlimited
+func getLimiter(group string) *limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	if l, ok := limiters[group]; ok {
+		return l
+	}
+	max := groupLimit(group)
+	if max <= 0 {
+		return nil
+	}
+	l := &limiter{name: group, slots: make(chan struct{}, max)}
+	limiters[group] = l
+	return l
+}
+
+// ConcurrencyLimit caps the requests of a route group in flight at once. Routes of the
+// same group share the limit; requests over it get 429 with Retry-After instead of
+// piling more queries on the database. Streamed responses keep their slot until the
+// stream ends, see HoldConcurrencySlot.
+func ConcurrencyLimit(group string) fiber.Handler {
+	l := getLimiter(group)
+	if l == nil {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	retryAfter := strconv.Itoa(max(int(config.Cfg.Concurrency.RetryAfter.Seconds()), 1))
+
+	return func(c *fiber.Ctx) error {
+		select {
+		case l.slots <- struct{}{}:
+			s := &slot{limiter: l}
+			c.Locals(slotLocal, s)
+			defer func() {
+				if !s.held {
+					s.release()
+				}
+			}()
+		default:
+			l.rejected.Add(1)
+			c.Set(fiber.HeaderRetryAfter, retryAfter)
+			return response.ErrorCode(c, fiber.StatusTooManyRequests, response.CodeTooManyRequests, "Too many "+l.name+" requests in progress, please retry shortly")
+		}
+
+		return c.Next()
+	}
+}
+
+// HoldConcurrencySlot keeps the request's concurrency slot past the handler, for a body
+// written by SetBodyStreamWriter after it returns. The returned func releases the slot
+// and must be called when the stream finishes; it does nothing on unlimited routes.
+func HoldConcurrencySlot(c *fiber.Ctx) func() {
+	s, ok := c.Locals(slotLocal).(*slot)
+	if !ok {
+		return func() {}
+	}
+	s.held = true
+	return s.release
+}
+
+// ConcurrencyStatuses returns a snapshot of every limited group, sorted by name
+func ConcurrencyStatuses() []LimitStatus {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	statuses := make([]LimitStatus, 0, len(limiters))
+	for _, l := range limiters {
+		statuses = append(statuses, LimitStatus{
+			Name:      l.name,
+			InFlight:  len(l.slots),
+			MaxFlight: cap(l.slots),
+			Rejected:  l.rejected.Load(),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,85 @@
+package middleware_test
+
+import (
+	"bufio"
+	"io"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func exportsInFlight() int {
+	for _, status := range middleware.ConcurrencyStatuses() {
+		if status.Name == middleware.LimitExports {
+			return status.InFlight
+		}
+	}
+	return -1
+}
+
+func TestStreamedResponseHoldsSlot(t *testing.T) {
+	saved := config.Cfg
+	config.Cfg = &config.Config{Concurrency: config.ConcurrencyConfig{Exports: 1, RetryAfter: time.Second}}
+	t.Cleanup(func() { config.Cfg = saved })
+
+	// Only the first stream waits for proceed
+	proceed := make(chan struct{})
+	started := make(chan struct{})
+	var requests atomic.Int32
+	app := fiber.New()
+	app.Get("/export", middleware.ConcurrencyLimit(middleware.LimitExports), func(c *fiber.Ctx) error {
+		first := requests.Add(1) == 1
+		release := middleware.HoldConcurrencySlot(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer release()
+			if first {
+				<-proceed
+			}
+			w.WriteString("done")
+		})
+		if first {
+			close(started)
+		}
+		return nil
+	})
+
+	first := make(chan string)
+	go func() {
+		resp, err := app.Test(httptest.NewRequest("GET", "/export", nil), -1)
+		if err != nil {
+			first <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		first <- string(body)
+	}()
+
+	// The handler has returned, but the stream still holds the only slot
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	resp, err := app.Test(httptest.NewRequest("GET", "/export", nil), -1)
+	close(proceed)
+	if err != nil || resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("request while streaming: %v %v", resp, err)
+	}
+
+	if body := <-first; body != "done" {
+		t.Fatalf("streamed body = %q", body)
+	}
+	deadline := time.Now().Add(time.Second)
+	for exportsInFlight() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not released after the stream, %d in flight", exportsInFlight())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if resp, err := app.Test(httptest.NewRequest("GET", "/export", nil), -1); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("request after the stream: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestContext replaces the user context with one that is cancelled when the
+// handler returns or the server shuts down. Database calls made with a context
+// derived from c.UserContext() stop instead of running on after the request is gone;
+// work started in a goroutine must therefore not use it.
+func RequestContext() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithCancel(c.UserContext())
+		defer cancel()
+
+		stop := context.AfterFunc(c.Context(), cancel)
+		defer stop()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bg-go/internal/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type contextKey struct{}
+
+func TestRequestContextCancelsAfterHandler(t *testing.T) {
+	app := fiber.New()
+	// An earlier middleware's values stay reachable through the derived context
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(context.WithValue(c.UserContext(), contextKey{}, "span"))
+		return c.Next()
+	})
+	app.Use(middleware.RequestContext())
+
+	captured := make(chan context.Context, 1)
+	app.Get("/", func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+		if ctx.Err() != nil {
+			t.Errorf("context cancelled in the handler: %v", ctx.Err())
+		}
+		if ctx.Value(contextKey{}) != "span" {
+			t.Errorf("value of the parent context lost")
+		}
+		captured <- ctx
+		return c.SendStatus(204)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+	if err != nil || resp.StatusCode != 204 {
+		t.Fatalf("request: %v %v", resp, err)
+	}
+	select {
+	case <-(<-captured).Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after the handler returned")
+	}
+}
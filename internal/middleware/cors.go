@@ -1,45 +1,134 @@
 package middleware
 
 import (
+	"path"
+	"strconv"
+	"strings"
+
 	"bg-go/internal/config"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
-// SetupCORS configures CORS middleware
-func SetupCORS() fiber.Handler {
-	cfg := config.Cfg
-	
-	return cors.New(cors.Config{
-		AllowOrigins:     cfg.CORS.AllowedOrigins,
-		AllowMethods:     cfg.CORS.AllowedMethods,
-		AllowHeaders:     cfg.CORS.AllowedHeaders,
-		AllowCredentials: true,
-		MaxAge:           86400,
-	})
+// CORSPolicy decides which origins may call the API and what they may send
+type CORSPolicy struct {
+	Origins     []string // Exact origins or wildcard patterns; "*" allows any origin
+	Methods     string
+	Headers     string
+	Expose      string
+	Credentials bool // Only granted to origins matching a pattern other than "*"
+	MaxAge      int  // Preflight cache lifetime in seconds
+}
+
+// CORSOverride applies a different policy to every path under Prefix
+type CORSOverride struct {
+	Prefix string
+	Policy CORSPolicy
+}
+
+// DefaultCORSPolicy is the configured policy for the admin API
+func DefaultCORSPolicy() CORSPolicy {
+	cfg := config.Cfg.CORS
+
+	return CORSPolicy{
+		Origins:     cfg.AllowedOrigins,
+		Methods:     cfg.AllowedMethods,
+		Headers:     cfg.AllowedHeaders,
+		Expose:      cfg.ExposedHeaders,
+		Credentials: cfg.AllowCredentials,
+		MaxAge:      cfg.MaxAge,
+	}
+}
+
+// ClientCORSPolicy is the policy for the public client pages. They authenticate
+// with the token in the URL, so cookies are never allowed.
+func ClientCORSPolicy() CORSPolicy {
+	policy := DefaultCORSPolicy()
+	policy.Origins = config.Cfg.CORS.ClientOrigins
+	policy.Credentials = false
+	return policy
 }
 
-// CustomCORS adds custom CORS headers (for preflight handling)
-func CustomCORS() fiber.Handler {
+// CORS applies the default policy, or the override with the longest matching prefix.
+// Preflight requests are answered here without reaching the router.
+func CORS(base CORSPolicy, overrides ...CORSOverride) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		origin := c.Get("Origin")
-		if origin == "" {
-			origin = "*"
-		}
-		
-		cfg := config.Cfg
-		
-		c.Set("Access-Control-Allow-Origin", origin)
-		c.Set("Access-Control-Allow-Methods", cfg.CORS.AllowedMethods)
-		c.Set("Access-Control-Allow-Headers", cfg.CORS.AllowedHeaders)
-		c.Set("Access-Control-Allow-Credentials", "true")
-		
-		if c.Method() == "OPTIONS" {
-			c.Set("Access-Control-Max-Age", "86400")
+		policy := base
+		matched := 0
+		for _, override := range overrides {
+			if strings.HasPrefix(c.Path(), override.Prefix) && len(override.Prefix) > matched {
+				policy = override.Policy
+				matched = len(override.Prefix)
+			}
+		}
+
+		c.Vary(fiber.HeaderOrigin)
+
+		origin := c.Get(fiber.HeaderOrigin)
+		preflight := c.Method() == fiber.MethodOptions && c.Get(fiber.HeaderAccessControlRequestMethod) != ""
+
+		allowed, explicit := policy.match(origin)
+		if origin == "" || !allowed {
+			if preflight {
+				return c.SendStatus(fiber.StatusNoContent)
+			}
+			return c.Next()
+		}
+
+		credentials := policy.Credentials && explicit
+		if credentials || !policy.allowsAny() {
+			c.Set(fiber.HeaderAccessControlAllowOrigin, origin)
+		} else {
+			c.Set(fiber.HeaderAccessControlAllowOrigin, "*")
+		}
+		if credentials {
+			c.Set(fiber.HeaderAccessControlAllowCredentials, "true")
+		}
+
+		if preflight {
+			c.Set(fiber.HeaderAccessControlAllowMethods, policy.Methods)
+			if policy.Headers != "" {
+				c.Set(fiber.HeaderAccessControlAllowHeaders, policy.Headers)
+			} else {
+				c.Set(fiber.HeaderAccessControlAllowHeaders, c.Get(fiber.HeaderAccessControlRequestHeaders))
+			}
+			if policy.MaxAge > 0 {
+				c.Set(fiber.HeaderAccessControlMaxAge, strconv.Itoa(policy.MaxAge))
+			}
 			return c.SendStatus(fiber.StatusNoContent)
 		}
-		
+
+		if policy.Expose != "" {
+			c.Set(fiber.HeaderAccessControlExposeHeaders, policy.Expose)
+		}
 		return c.Next()
 	}
 }
+
+// match reports whether origin is allowed, and whether it matched an entry other than "*"
+func (p CORSPolicy) match(origin string) (allowed bool, explicit bool) {
+	for _, pattern := range p.Origins {
+		pattern = strings.TrimSpace(pattern)
+		switch {
+		case pattern == "*":
+			allowed = true
+		case pattern == origin:
+			return true, true
+		case strings.Contains(pattern, "*"):
+			// "*" does not match "/", so a wildcard stays within the host or port
+			if ok, _ := path.Match(pattern, origin); ok {
+				return true, true
+			}
+		}
+	}
+	return allowed, false
+}
+
+func (p CORSPolicy) allowsAny() bool {
+	for _, pattern := range p.Origins {
+		if strings.TrimSpace(pattern) == "*" {
+			return true
+		}
+	}
+	return false
+}
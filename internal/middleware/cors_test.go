@@ -0,0 +1,75 @@
+package middleware_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"bg-go/internal/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCORS(t *testing.T) {
+	admin := middleware.CORSPolicy{
+		Origins:     []string{"https://admin.example.com", "https://*.staging.example.com"},
+		Methods:     "GET,POST",
+		Headers:     "Content-Type,Authorization",
+		Expose:      "Content-Disposition",
+		Credentials: true,
+		MaxAge:      600,
+	}
+	client := middleware.CORSPolicy{Origins: []string{"*"}, Methods: "GET,POST", Credentials: true}
+
+	app := fiber.New()
+	app.Use(middleware.CORS(admin, middleware.CORSOverride{Prefix: "/api/v1/client", Policy: client}))
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		preflight   bool
+		status      int
+		allowOrigin string
+		credentials string
+		maxAge      string
+	}{
+		{"admin origin", "GET", "/api/v1/orders", "https://admin.example.com", false, 200, "https://admin.example.com", "true", ""},
+		{"wildcard subdomain", "GET", "/api/v1/orders", "https://qa.staging.example.com", false, 200, "https://qa.staging.example.com", "true", ""},
+		{"wildcard stays within the host", "GET", "/api/v1/orders", "https://evil.com/.staging.example.com", false, 200, "", "", ""},
+		{"unknown origin", "GET", "/api/v1/orders", "https://evil.com", false, 200, "", "", ""},
+		{"admin preflight", "OPTIONS", "/api/v1/orders", "https://admin.example.com", true, 204, "https://admin.example.com", "true", "600"},
+		{"refused preflight", "OPTIONS", "/api/v1/orders", "https://evil.com", true, 204, "", "", ""},
+		// Client pages accept any origin but never with cookies
+		{"client page", "GET", "/api/v1/client/invoice/abc", "https://evil.com", false, 200, "*", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Fatalf("allow origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+				t.Fatalf("allow credentials = %q, want %q", got, tt.credentials)
+			}
+			if got := resp.Header.Get("Access-Control-Max-Age"); got != tt.maxAge {
+				t.Fatalf("max age = %q, want %q", got, tt.maxAge)
+			}
+			if got := resp.Header.Get("Vary"); got != "Origin" {
+				t.Fatalf("vary = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"strings"
+
+	"bg-go/internal/lib/device"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// KioskGuard accepts either a kiosk device token or a regular user token.
+// Only routes that use this guard can be reached with a device token.
+func KioskGuard() fiber.Handler {
+	authGuard := AuthGuard()
+
+	return func(c *fiber.Ctx) error {
+		token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		if !device.IsToken(token) {
+			return authGuard(c)
+		}
+
+		dev, err := device.Authenticate(token, c.IP())
+		if err != nil {
+			return response.ErrorCode(c, 401, response.CodeDeviceTokenInvalid, "Invalid or revoked device token")
+		}
+
+		c.Locals("user_id", dev.ID.Hex())
+		c.Locals("role", models.RoleDevice)
+		c.Locals("device_id", dev.ID.Hex())
+
+		return c.Next()
+	}
+}
+
+// GetDeviceID returns the kiosk device making the request, if any
+func GetDeviceID(c *fiber.Ctx) string {
+	if deviceID := c.Locals("device_id"); deviceID != nil {
+		return deviceID.(string)
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"bg-go/internal/config"
+	"bg-go/internal/lib/errreport"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// ErrorReporting recovers from panics in later handlers and reports them, and 5xx
+// responses when enabled, to the configured error sinks with the request, the user and
+// the release. It replaces Fiber's recover middleware: a panic still ends as a 500 through
+// the error handler, but its stack is logged instead of swallowed. Bodies are redacted
+// like captured requests.
+func ErrorReporting() fiber.Handler {
+	return func(c *fiber.Ctx) (err error) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			stack := string(debug.Stack())
+			log.Printf("[Panic] %s %s: %v\n%s", c.Method(), c.Path(), recovered, stack)
+
+			event := requestEvent(c, errreport.LevelFatal)
+			event.Message = fmt.Sprintf("panic: %v", recovered)
+			event.Exception = &errreport.Exceptions{Values: []errreport.Exception{{
+				Type:  fmt.Sprintf("panic (%T)", recovered),
+				Value: fmt.Sprint(recovered),
+			}}}
+			event.Extra = map[string]string{"stack": stack}
+			errreport.Capture(event)
+
+			err = fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+		}()
+
+		err = c.Next()
+		if !config.Cfg.ErrorReport.ServerErrors || !errreport.Enabled() {
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		message := ""
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if fiberErr, ok := err.(*fiber.Error); ok {
+				status = fiberErr.Code
+			}
+			message = err.Error()
+		}
+		if status < 500 {
+			return err
+		}
+		if message == "" {
+			message = responseMessage(c)
+		}
+
+		event := requestEvent(c, errreport.LevelError)
+		event.Message = fmt.Sprintf("HTTP %d: %s", status, message)
+		event.Tags["status"] = fmt.Sprint(status)
+		errreport.Capture(event)
+		return err
+	}
+}
+
+// requestEvent builds a report of the current request with its user. The event is sent
+// after the handler returns, when fasthttp reuses the request buffers, so request strings
+// are copied.
+func requestEvent(c *fiber.Ctx, level string) errreport.Event {
+	method := utils.CopyString(c.Method())
+	event := errreport.Event{
+		Level:       level,
+		Transaction: method + " " + c.Route().Path,
+		Request: &errreport.Request{
+			Method:      method,
+			URL:         c.BaseURL() + tokenSegment.ReplaceAllString(utils.CopyString(c.Path()), "/[token]$1"),
+			QueryString: redactQuery(string(c.Request().URI().QueryString())),
+			Headers:     map[string]string{},
+			Data:        captureBody(string(c.Request().Header.ContentType()), c.Body(), config.Cfg.Capture.MaxBodyBytes),
+		},
+		User: &errreport.User{
+			ID:        GetUserID(c),
+			Role:      GetUserRole(c),
+			IPAddress: c.IP(),
+		},
+		Tags: map[string]string{"method": method},
+	}
+	for _, name := range capturedHeaders {
+		if value := c.Get(name); value != "" {
+			event.Request.Headers[name] = utils.CopyString(value)
+		}
+	}
+	if requestID := c.Get("X-Request-Id"); requestID != "" {
+		event.Tags["request_id"] = utils.CopyString(requestID)
+	}
+	return event
+}
+
+// responseMessage returns the message of a JSON error response written by a handler
+func responseMessage(c *fiber.Ctx) string {
+	if c.Response().IsBodyStream() {
+		return ""
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(c.Response().Body(), &body)
+	return body.Message
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JournalCollection holds the journaled client page submissions
+const JournalCollection = "client_submissions"
+
+// journalSecretKeys are JSON keys whose values are removed from journaled bodies; codes
+// are one-time passwords. Unlike captured requests, phones are kept: they are what
+// usually arrives mangled.
+var journalSecretKeys = regexp.MustCompile(`(?i)(password|token|secret|otp|code|authorization|api_?key)`)
+
+// ClientJournal stores every POST of the client pages with its outcome, when the journal
+// is enabled, so support can see exactly what a frontend sent. Multipart uploads are
+// summarized, not stored.
+func ClientJournal() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodPost || !config.Cfg.Journal.Enabled {
+			return c.Next()
+		}
+
+		err := c.Next()
+
+		// The entry is stored after the handler returns, when fasthttp reuses the request
+		// buffers, so request strings are copied
+		entry := models.ClientSubmission{
+			ID:          primitive.NewObjectID(),
+			Token:       utils.CopyString(c.Params("token")),
+			Route:       c.Route().Path,
+			Path:        utils.CopyString(c.Path()),
+			ContentType: string(c.Request().Header.ContentType()),
+			Status:      c.Response().StatusCode(),
+			IP:          c.IP(),
+			UserAgent:   utils.CopyString(c.Get(fiber.HeaderUserAgent)),
+			AppVersion:  utils.CopyString(c.Get("X-App-Version")),
+			CreatedAt:   time.Now(),
+		}
+		entry.Body, entry.Truncated = journalBody(entry.ContentType, c.Body(), config.Cfg.Journal.MaxBodyBytes)
+		if fiberErr, ok := err.(*fiber.Error); ok {
+			entry.Status, entry.Message = fiberErr.Code, fiberErr.Message
+		} else if !c.Response().IsBodyStream() {
+			var outcome struct {
+				Message   string `json:"message"`
+				ErrorCode string `json:"error_code"`
+			}
+			json.Unmarshal(c.Response().Body(), &outcome)
+			entry.Message, entry.ErrorCode = outcome.Message, outcome.ErrorCode
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := database.GetMongoCollection(JournalCollection).InsertOne(ctx, entry); err != nil {
+				log.Printf("[Journal] Failed to store client submission: %v", err)
+			}
+		}()
+
+		return err
+	}
+}
+
+// journalBody returns a body with its secrets redacted, cut to maxBytes
+func journalBody(contentType string, body []byte, maxBytes int) (string, bool) {
+	if len(body) == 0 {
+		return "", false
+	}
+
+	text := string(body)
+	switch {
+	case strings.HasPrefix(contentType, "multipart/"):
+		return fmt.Sprintf("[multipart %d bytes]", len(body)), false
+	case strings.HasPrefix(contentType, "application/json"):
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err == nil {
+			if redactedBody, err := json.Marshal(redactSecrets("", value)); err == nil {
+				text = string(redactedBody)
+			}
+		}
+		// Invalid JSON is kept as sent, it is usually what needs diagnosing
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		pairs := strings.Split(text, "&")
+		for i, pair := range pairs {
+			if key, _, found := strings.Cut(pair, "="); found && journalSecretKeys.MatchString(key) {
+				pairs[i] = key + "=" + redacted
+			}
+		}
+		text = strings.Join(pairs, "&")
+	}
+
+	if maxBytes > 0 && len(text) > maxBytes {
+		return text[:maxBytes], true
+	}
+	return text, false
+}
+
+// redactSecrets walks decoded JSON and removes the values of secret keys
+func redactSecrets(key string, value interface{}) interface{} {
+	if key != "" && journalSecretKeys.MatchString(key) {
+		return redacted
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = redactSecrets(k, item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactSecrets(key, item)
+		}
+	}
+	return value
+}
+
+// EnsureJournalIndexes expires journal entries after the configured TTL and indexes the
+// lookup by token
+func EnsureJournalIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ttl := config.Cfg.Journal.TTL
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+	_, err := database.GetMongoCollection(JournalCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("created_at_ttl").SetExpireAfterSeconds(int32(ttl.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("token_created_at"),
+		},
+	})
+	if err != nil {
+		log.Printf("[Journal] Failed to create indexes: %v", err)
+	}
+}
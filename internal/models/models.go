@@ -19,8 +19,29 @@ type BaseModel struct {
 
 // Image represents an image with CDN info
 type Image struct {
-	PublicID string `json:"public_id" bson:"public_id"`
-	URL      string `json:"url" bson:"url"`
+	PublicID   string            `json:"public_id" bson:"public_id"`
+	URL        string            `json:"url" bson:"url"`
+	Variants   map[string]string `json:"variants,omitempty" bson:"variants,omitempty"`       // Resized copies by name, e.g. thumb and medium
+	CapturedAt *time.Time        `json:"captured_at,omitempty" bson:"captured_at,omitempty"` // From the photo's EXIF data
+	Location   *GeoPoint         `json:"-" bson:"location,omitempty"`                        // From the photo's EXIF data, never returned
+
+	Moderation  string     `json:"moderation,omitempty" bson:"moderation,omitempty"` // pending, approved or rejected, when CDN_MODERATION is on
+	ModeratedAt *time.Time `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+}
+
+// Moderation statuses of an image
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved"
+	ModerationRejected = "rejected"
+)
+
+// GeoPoint is a reported position
+type GeoPoint struct {
+	Lat        float64   `json:"lat" bson:"lat"`
+	Lng        float64   `json:"lng" bson:"lng"`
+	Accuracy   float64   `json:"accuracy,omitempty" bson:"accuracy,omitempty"` // Meters
+	RecordedAt time.Time `json:"recorded_at" bson:"recorded_at"`
 }
 
 // User model (MongoDB)
@@ -31,7 +52,15 @@ type User struct {
 	Password    string `json:"-" bson:"password"`
 	Role        string `json:"role" bson:"role"`
 	Email       string `json:"email" bson:"email,omitempty"`
+	Phone       string `json:"phone,omitempty" bson:"phone,omitempty"`
+	Avatar      *Image `json:"avatar,omitempty" bson:"avatar,omitempty"`
 	IsActive    bool   `json:"is_active" bson:"is_active"`
+
+	// Invite of a user who sets their own password; the hash is cleared once accepted
+	InviteTokenHash  string     `json:"-" bson:"invite_token_hash,omitempty"`
+	InviteExpiresAt  *time.Time `json:"invite_expires_at,omitempty" bson:"invite_expires_at,omitempty"`
+	InvitedBy        string     `json:"invited_by,omitempty" bson:"invited_by,omitempty"`
+	InviteAcceptedAt *time.Time `json:"invite_accepted_at,omitempty" bson:"invite_accepted_at,omitempty"`
 }
 
 // NewUser creates a new User instance (MongoDB)
@@ -58,6 +87,19 @@ type Sales struct {
 	Email     string `json:"email,omitempty" bson:"email,omitempty"`
 	Address   string `json:"address,omitempty" bson:"address,omitempty"`
 	IsActive  bool   `json:"is_active" bson:"is_active"`
+
+	// Set when the record was merged into another sales rep
+	MergedInto string     `json:"merged_into,omitempty" bson:"merged_into,omitempty"`
+	MergedAt   *time.Time `json:"merged_at,omitempty" bson:"merged_at,omitempty"`
+
+	// Extra recipients of every delivery note of this sales (warehouse, finance)
+	DeliveryRecipients []DeliveryRecipient `json:"delivery_recipients,omitempty" bson:"delivery_recipients,omitempty"`
+
+	// Credit terms: the outstanding balance may not exceed CreditLimit (0 = no limit),
+	// orders are due PaymentTermDays after they are created
+	CreditLimit     float64 `json:"credit_limit,omitempty" bson:"credit_limit,omitempty"`
+	PaymentTermDays int     `json:"payment_term_days,omitempty" bson:"payment_term_days,omitempty"`
+	CreditCheck     string  `json:"credit_check,omitempty" bson:"credit_check,omitempty"` // block (default), warn
 }
 
 // NewSales creates a new Sales instance
@@ -84,6 +126,7 @@ type Product struct {
 	Price       float64 `json:"price" bson:"price"`
 	Unit        string  `json:"unit" bson:"unit"`
 	Stock       int     `json:"stock" bson:"stock"`
+	Category    string  `json:"category,omitempty" bson:"category,omitempty"` // Loading duration category
 	Image       *Image  `json:"image,omitempty" bson:"image,omitempty"`
 	IsActive    bool    `json:"is_active" bson:"is_active"`
 }
@@ -113,6 +156,19 @@ type OrderItem struct {
 	Quantity    int     `json:"quantity" bson:"quantity"`
 	Unit        string  `json:"unit" bson:"unit"` // Default: "pcs"
 	Subtotal    float64 `json:"subtotal" bson:"subtotal"`
+	Category    string  `json:"category,omitempty" bson:"category,omitempty"`     // Loading duration category, from the product catalog
+	ListPrice   float64 `json:"list_price,omitempty" bson:"list_price,omitempty"` // Catalog price of the product with this name, when entered
+	PriceNote   string  `json:"price_note,omitempty" bson:"price_note,omitempty"` // Why the unit price deviates from ListPrice
+
+	// Quantity in the base unit of Unit's dimension (kg, pcs, m3, m), from the unit registry
+	BaseQuantity float64 `json:"base_quantity,omitempty" bson:"base_quantity,omitempty"`
+	BaseUnit     string  `json:"base_unit,omitempty" bson:"base_unit,omitempty"`
+
+	// Split shipments: on a split order, how much of the item is allocated to shipments and
+	// how much of that was loaded; on a shipment, which item of the split order it ships
+	Shipped    int `json:"shipped,omitempty" bson:"shipped,omitempty"`
+	Fulfilled  int `json:"fulfilled,omitempty" bson:"fulfilled,omitempty"`
+	ParentItem int `json:"parent_item,omitempty" bson:"parent_item,omitempty"`
 
 	// Legacy fields for backward compatibility
 	ProductID string   `json:"product_id" bson:"product_id"`
@@ -126,13 +182,40 @@ type Order struct {
 	// Basic Info
 	OrderNumber string `json:"order_number" bson:"order_number"`
 
+	// Tags (free-form; colors come from the tag catalog)
+	Tags     []string  `json:"tags,omitempty" bson:"tags,omitempty"`
+	TagHints []TagHint `json:"tag_hints,omitempty" bson:"-"` // Populated for responses
+
+	// Walk-in (a cash purchase created and queued when the truck arrived, for reporting)
+	WalkIn bool `json:"walk_in,omitempty" bson:"walk_in,omitempty"`
+
+	// Split Shipments (an order loaded over several trucks or days; each shipment is an
+	// order of its own with its driver, queue entry and delivery note)
+	ShipmentOf     string `json:"shipment_of,omitempty" bson:"shipment_of,omitempty"`         // ID of the split order, on a shipment
+	ShipmentNumber int    `json:"shipment_number,omitempty" bson:"shipment_number,omitempty"` // 1-based, on a shipment
+	ShipmentCount  int    `json:"shipment_count,omitempty" bson:"shipment_count,omitempty"`   // Shipments created, on a split order
+
+	// Combined Loading (orders of one customer sharing a truck; the first one carries the
+	// queue entry and the combined delivery note)
+	LoadedWith   string   `json:"loaded_with,omitempty" bson:"loaded_with,omitempty"`     // ID of the order carrying this one
+	MergedOrders []string `json:"merged_orders,omitempty" bson:"merged_orders,omitempty"` // IDs of the orders this one carries
+
 	// Sales Info
 	SalesID string `json:"sales_id" bson:"sales_id"`
 	Sales   *Sales  `json:"sales,omitempty" bson:"sales,omitempty"`
 
+	// Customer Info (optional, receives status notifications)
+	CustomerName  string `json:"customer_name,omitempty" bson:"customer_name,omitempty"`
+	CustomerPhone string `json:"customer_phone,omitempty" bson:"customer_phone,omitempty"`
+
 	// Order Items (multiple products - entered manually)
 	Items []OrderItem `json:"items" bson:"items"`
 
+	// Item edits made after the invoice was sent wait in PendingChange until the sales
+	// rep confirms them on the invoice page; resolved edits move to ChangeHistory
+	PendingChange *OrderChange  `json:"pending_change,omitempty" bson:"pending_change,omitempty"`
+	ChangeHistory []OrderChange `json:"change_history,omitempty" bson:"change_history,omitempty"`
+
 	// Legacy fields for backwards compatibility
 	ProductID  string   `json:"product_id" bson:"product_id"`
 	Product    *Product `json:"product,omitempty" bson:"product,omitempty"`
@@ -141,12 +224,25 @@ type Order struct {
 	TotalPrice float64  `json:"total_price" bson:"total_price"`
 
 	// Status
-	Status string `json:"status" bson:"status"`
+	Status        string         `json:"status" bson:"status"`
+	StatusHistory []StatusChange `json:"status_history,omitempty" bson:"status_history,omitempty"`
 
 	// Client Access
 	InvoiceToken string `json:"invoice_token" bson:"invoice_token"`
 	InvoiceURL   string `json:"invoice_url" bson:"invoice_url"`
 
+	// Invoice Views (each open of the invoice link is logged in invoice_views)
+	InvoiceFirstViewedAt *time.Time `json:"first_viewed_at,omitempty" bson:"first_viewed_at,omitempty"`
+	InvoiceLastViewedAt  *time.Time `json:"last_viewed_at,omitempty" bson:"last_viewed_at,omitempty"`
+	InvoiceViewCount     int        `json:"invoice_view_count,omitempty" bson:"invoice_view_count,omitempty"`
+	UnviewedEventAt      *time.Time `json:"unviewed_event_at,omitempty" bson:"unviewed_event_at,omitempty"` // invoice.unviewed published
+
+	// Sales Confirmation (on the invoice page, required before payment when enabled)
+	AcceptedAt    *time.Time `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+	DeclinedAt    *time.Time `json:"declined_at,omitempty" bson:"declined_at,omitempty"`
+	DeclineReason string     `json:"decline_reason,omitempty" bson:"decline_reason,omitempty"`
+	NeedsAccept   bool       `json:"needs_accept,omitempty" bson:"-"` // Populated on the invoice page
+
 	// Payment Info
 	PaymentProof       *Image     `json:"payment_proof,omitempty" bson:"payment_proof,omitempty"`
 	PaymentStatus      string     `json:"payment_status" bson:"payment_status"`
@@ -157,21 +253,53 @@ type Order struct {
 	PaymentRejectedBy  string     `json:"payment_rejected_by,omitempty" bson:"payment_rejected_by,omitempty"`
 	PaymentRejectReason string    `json:"payment_reject_reason,omitempty" bson:"payment_reject_reason,omitempty"`
 
+	// First approval of a payment above the approval threshold, waiting for a second approver
+	PaymentApprovedAt *time.Time `json:"payment_approved_at,omitempty" bson:"payment_approved_at,omitempty"`
+	PaymentApprovedBy string     `json:"payment_approved_by,omitempty" bson:"payment_approved_by,omitempty"`
+
+	// Claim of the payment by the admin reviewing it, released when the admin goes offline
+	PaymentClaimedBy string     `json:"payment_claimed_by,omitempty" bson:"payment_claimed_by,omitempty"`
+	PaymentClaimedAt *time.Time `json:"payment_claimed_at,omitempty" bson:"payment_claimed_at,omitempty"`
+
+	// Verdict of the fraud-screening service on the latest payment proof
+	PaymentScreening *PaymentScreening `json:"payment_screening,omitempty" bson:"payment_screening,omitempty"`
+
+	// Bank mutation matched to the payment by a statement import
+	PaymentMatch *PaymentMatch `json:"payment_match,omitempty" bson:"payment_match,omitempty"`
+
+	// Payment Reminders (sent while the order waits for payment)
+	PaymentReminders     []PaymentReminder `json:"payment_reminders,omitempty" bson:"payment_reminders,omitempty"`
+	PaymentReminderCount int               `json:"payment_reminder_count,omitempty" bson:"payment_reminder_count,omitempty"`
+
+	// Due Date (entered, or from the sales' payment terms); the overdue job keeps Overdue
+	// set while the order is unpaid past it and sends the overdue reminders
+	DueDate              *time.Time        `json:"due_date,omitempty" bson:"due_date,omitempty"`
+	Overdue              bool              `json:"overdue,omitempty" bson:"overdue,omitempty"`
+	OverdueSince         *time.Time        `json:"overdue_since,omitempty" bson:"overdue_since,omitempty"`
+	OverdueReminders     []PaymentReminder `json:"overdue_reminders,omitempty" bson:"overdue_reminders,omitempty"`
+	OverdueReminderCount int               `json:"overdue_reminder_count,omitempty" bson:"overdue_reminder_count,omitempty"`
+	OverdueEscalatedAt   *time.Time        `json:"overdue_escalated_at,omitempty" bson:"overdue_escalated_at,omitempty"` // Sent to the sales manager
+
 	// Driver Info
 	DriverName     string     `json:"driver_name,omitempty" bson:"driver_name,omitempty"`
 	DriverPhone    string     `json:"driver_phone,omitempty" bson:"driver_phone,omitempty"`
 	VehiclePlate   string     `json:"vehicle_plate,omitempty" bson:"vehicle_plate,omitempty"`
 	VehiclePhoto   *Image     `json:"vehicle_photo,omitempty" bson:"vehicle_photo,omitempty"`
 	DriverFilledAt *time.Time `json:"driver_filled_at,omitempty" bson:"driver_filled_at,omitempty"`
+	DriverNeedsOTP bool       `json:"driver_needs_otp,omitempty" bson:"-"` // Populated on the invoice page
 
 	// Queue Info
 	QueueNumber    int        `json:"queue_number,omitempty" bson:"queue_number,omitempty"`
 	QueueToken     string     `json:"queue_token,omitempty" bson:"queue_token,omitempty"`
 	QueueBarcode   string     `json:"queue_barcode,omitempty" bson:"queue_barcode,omitempty"`
 	QueueQRCode    string     `json:"queue_qrcode,omitempty" bson:"queue_qrcode,omitempty"` // Base64 QR code image
+	BarcodeFormat  string     `json:"barcode_format,omitempty" bson:"barcode_format,omitempty"`
+	BarcodeImage   string     `json:"barcode_image,omitempty" bson:"barcode_image,omitempty"` // Base64 1D barcode image
 	QueueEnteredAt *time.Time `json:"queue_entered_at,omitempty" bson:"queue_entered_at,omitempty"`
 	EstimatedTime  string     `json:"estimated_time,omitempty" bson:"estimated_time,omitempty"`
+	WaitNotifiedAt *time.Time `json:"wait_notified_at,omitempty" bson:"wait_notified_at,omitempty"` // Last delayed-ETA update
 	QueueCalledAt  *time.Time `json:"queue_called_at,omitempty" bson:"queue_called_at,omitempty"`
+	LoadingBay     string     `json:"loading_bay,omitempty" bson:"loading_bay,omitempty"`
 
 	// Loading Info
 	LoadingStartedAt  *time.Time `json:"loading_started_at,omitempty" bson:"loading_started_at,omitempty"`
@@ -184,6 +312,36 @@ type Order struct {
 	DeliveryNoteURL    string     `json:"delivery_note_url,omitempty" bson:"delivery_note_url,omitempty"`
 	DeliveryNoteAt     *time.Time `json:"delivery_note_at,omitempty" bson:"delivery_note_at,omitempty"`
 	CompletedAt        *time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	CSATSentAt         *time.Time `json:"csat_sent_at,omitempty" bson:"csat_sent_at,omitempty"`
+	ArchivedAt         *time.Time `json:"archived_at,omitempty" bson:"archived_at,omitempty"` // Set once moved to orders_archive
+
+	// Shipment Tracking (orders delivered to the customer's site)
+	DeliveryAddress string     `json:"delivery_address,omitempty" bson:"delivery_address,omitempty"`
+	DispatchedAt    *time.Time `json:"dispatched_at,omitempty" bson:"dispatched_at,omitempty"`
+	DeliveryETA     *time.Time `json:"delivery_eta,omitempty" bson:"delivery_eta,omitempty"`
+	ArrivedAt       *time.Time `json:"arrived_at,omitempty" bson:"arrived_at,omitempty"`
+	DriverLocation  *GeoPoint  `json:"driver_location,omitempty" bson:"driver_location,omitempty"` // Latest ping
+
+	// Delivery Fee (a separate invoice line; TotalPrice includes it)
+	Subtotal           float64   `json:"subtotal,omitempty" bson:"subtotal,omitempty"` // Items only
+	DeliveryFee        float64   `json:"delivery_fee,omitempty" bson:"delivery_fee,omitempty"`
+	DeliveryDistanceKm float64   `json:"delivery_distance_km,omitempty" bson:"delivery_distance_km,omitempty"`
+	DeliveryLocation   *GeoPoint `json:"delivery_location,omitempty" bson:"delivery_location,omitempty"` // Geocoded address
+
+	// Data Integrity (set by the integrity repair in flag mode)
+	IntegrityFlags []string `json:"integrity_flags,omitempty" bson:"integrity_flags,omitempty"`
+
+	// SLA of the current stage (populated on listings). SLAAlerts holds, keyed
+	// <stage>_<state>, the start of the stage each at-risk and breach alert fired for, so
+	// a stage entered again alerts again.
+	SLA       *SLAStatus           `json:"sla,omitempty" bson:"-"`
+	SLAAlerts map[string]time.Time `json:"sla_alerts,omitempty" bson:"sla_alerts,omitempty"`
+
+	// Extra recipients of this order's delivery note, on top of the sales' own list
+	DeliveryRecipients []DeliveryRecipient `json:"delivery_recipients,omitempty" bson:"delivery_recipients,omitempty"`
+
+	// Notes (populated for responses, stored in order_notes)
+	Notes []OrderNote `json:"notes,omitempty" bson:"-"`
 }
 
 // NewOrder creates a new Order instance
@@ -196,6 +354,46 @@ func NewOrder() *Order {
 		},
 		Status:       OrderStatusPending,
 		PaymentStatus: PaymentStatusPending,
+		StatusHistory: []StatusChange{NewStatusChange(OrderStatusPending, "")},
+	}
+}
+
+// OrderNote is a comment on an order, either internal or shared with sales
+type OrderNote struct {
+	BaseModel  `bson:",inline"`
+	OrderID    string `json:"order_id" bson:"order_id"`
+	AuthorID   string `json:"author_id" bson:"author_id"`
+	AuthorName string `json:"author_name" bson:"author_name"`
+	Text       string `json:"text" bson:"text"`
+	Attachment *Image `json:"attachment,omitempty" bson:"attachment,omitempty"`
+	Visibility string `json:"visibility" bson:"visibility"` // internal, shared
+}
+
+// NewOrderNote creates a new OrderNote instance
+func NewOrderNote() *OrderNote {
+	return &OrderNote{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Visibility: NoteVisibilityInternal,
+	}
+}
+
+// StatusChange records a single order status transition
+type StatusChange struct {
+	Status    string    `json:"status" bson:"status"`
+	ChangedAt time.Time `json:"changed_at" bson:"changed_at"`
+	ChangedBy string    `json:"changed_by,omitempty" bson:"changed_by,omitempty"`
+}
+
+// NewStatusChange creates a status history entry stamped with the current time
+func NewStatusChange(status string, changedBy string) StatusChange {
+	return StatusChange{
+		Status:    status,
+		ChangedAt: time.Now(),
+		ChangedBy: changedBy,
 	}
 }
 
@@ -211,6 +409,10 @@ type DeliveryNote struct {
 	OrderID string `json:"order_id" bson:"order_id"`
 	Order   *Order `json:"order,omitempty" bson:"order,omitempty"`
 
+	// Orders loaded together on one truck, OrderID first; empty for a single order
+	OrderIDs     []string `json:"order_ids,omitempty" bson:"order_ids,omitempty"`
+	OrderNumbers []string `json:"order_numbers,omitempty" bson:"order_numbers,omitempty"`
+
 	// Note Info
 	NoteNumber string `json:"note_number" bson:"note_number"`
 
@@ -227,11 +429,48 @@ type DeliveryNote struct {
 	// Items (multiple products support)
 	Items []DeliveryNoteItem `json:"items,omitempty" bson:"items,omitempty"`
 
+	// Returnable assets lent with the shipment (pallets, containers)
+	Assets []AssetCount `json:"assets,omitempty" bson:"assets,omitempty"`
+
+	// Extra recipients the note was sent to, besides the sales
+	Recipients []NoteRecipient `json:"recipients,omitempty" bson:"recipients,omitempty"`
+
 	// Access Token
 	Token string `json:"token" bson:"token"`
 
 	// Created By
 	CreatedBy string `json:"created_by" bson:"created_by"`
+
+	// Revision Info (amendments create a new revision, the old one is superseded)
+	Revision     int    `json:"revision" bson:"revision"`
+	RootID       string `json:"root_id,omitempty" bson:"root_id,omitempty"` // ID of the first revision
+	Superseded   bool   `json:"superseded" bson:"superseded"`
+	SupersededBy string `json:"superseded_by,omitempty" bson:"superseded_by,omitempty"`
+	AmendReason  string `json:"amend_reason,omitempty" bson:"amend_reason,omitempty"`
+
+	// Data Integrity (set by the integrity repair in flag mode)
+	IntegrityFlags []string `json:"integrity_flags,omitempty" bson:"integrity_flags,omitempty"`
+}
+
+// DeliveryRecipient is a contact that receives delivery notes by WhatsApp and/or email
+type DeliveryRecipient struct {
+	Name  string `json:"name" bson:"name"`
+	Role  string `json:"role,omitempty" bson:"role,omitempty"` // Free-form label, e.g. warehouse, finance
+	Phone string `json:"phone,omitempty" bson:"phone,omitempty"`
+	Email string `json:"email,omitempty" bson:"email,omitempty"`
+}
+
+// NoteRecipient is the delivery of a note to one recipient over one channel.
+// WhatsApp deliveries keep the ID of their notification record.
+type NoteRecipient struct {
+	Name           string     `json:"name" bson:"name"`
+	Role           string     `json:"role,omitempty" bson:"role,omitempty"`
+	Channel        string     `json:"channel" bson:"channel"` // whatsapp, email
+	To             string     `json:"to" bson:"to"`
+	Status         string     `json:"status" bson:"status"` // pending, sent, failed
+	NotificationID string     `json:"notification_id,omitempty" bson:"notification_id,omitempty"`
+	Error          string     `json:"error,omitempty" bson:"error,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
 }
 
 // DeliveryNoteItem represents an item in a delivery note
@@ -240,6 +479,11 @@ type DeliveryNoteItem struct {
 	Quantity    int     `json:"quantity" bson:"quantity"`
 	Unit        string  `json:"unit" bson:"unit"`
 	UnitPrice   float64 `json:"unit_price" bson:"unit_price"`
+	OrderNumber string  `json:"order_number,omitempty" bson:"order_number,omitempty"` // Source order, on a combined note
+
+	// Quantity in the base unit, copied from the order item
+	BaseQuantity float64 `json:"base_quantity,omitempty" bson:"base_quantity,omitempty"`
+	BaseUnit     string  `json:"base_unit,omitempty" bson:"base_unit,omitempty"`
 }
 
 // NewDeliveryNote creates a new DeliveryNote instance
@@ -250,6 +494,89 @@ func NewDeliveryNote() *DeliveryNote {
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		},
+		Revision: 1,
+	}
+}
+
+// ============================================
+// Return / Complaint Model
+// ============================================
+
+// ReturnRequest is a customer complaint opened after delivery
+type ReturnRequest struct {
+	BaseModel `bson:",inline"`
+
+	ReturnNumber string `json:"return_number" bson:"return_number"`
+
+	// Reference
+	DeliveryNoteID string `json:"delivery_note_id" bson:"delivery_note_id"`
+	NoteNumber     string `json:"note_number" bson:"note_number"`
+	OrderID        string `json:"order_id" bson:"order_id"`
+	SalesName      string `json:"sales_name" bson:"sales_name"`
+	SalesPhone     string `json:"sales_phone" bson:"sales_phone"`
+
+	// Complaint
+	ProductName      string  `json:"product_name,omitempty" bson:"product_name,omitempty"`
+	Description      string  `json:"description" bson:"description"`
+	QuantityAffected int     `json:"quantity_affected" bson:"quantity_affected"`
+	Photos           []Image `json:"photos,omitempty" bson:"photos,omitempty"`
+
+	// Triage & Resolution
+	Status         string     `json:"status" bson:"status"`
+	Resolution     string     `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty" bson:"resolution_note,omitempty"`
+	ReviewedBy     string     `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	ResolvedBy     string     `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+// NewReturnRequest creates a new ReturnRequest instance
+func NewReturnRequest() *ReturnRequest {
+	return &ReturnRequest{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Status: ReturnStatusOpen,
+	}
+}
+
+// ============================================
+// Cancellation Request Model
+// ============================================
+
+// CancelRequest is a cancellation of an unpaid order asked for on its invoice page. An
+// admin approves it, which cancels the order, or denies it; either way the requester is
+// told on WhatsApp.
+type CancelRequest struct {
+	BaseModel `bson:",inline"`
+
+	// Reference
+	OrderID     string `json:"order_id" bson:"order_id"`
+	OrderNumber string `json:"order_number" bson:"order_number"`
+	SalesID     string `json:"sales_id" bson:"sales_id"`
+	SalesName   string `json:"sales_name" bson:"sales_name"`
+	SalesPhone  string `json:"sales_phone" bson:"sales_phone"`
+
+	// Request & Review
+	Reason     string     `json:"reason" bson:"reason"`
+	Status     string     `json:"status" bson:"status"`
+	ReviewNote string     `json:"review_note,omitempty" bson:"review_note,omitempty"`
+	ReviewedBy string     `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+}
+
+// NewCancelRequest creates a new CancelRequest instance
+func NewCancelRequest() *CancelRequest {
+	return &CancelRequest{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Status: CancelRequestPending,
 	}
 }
 
@@ -279,6 +606,15 @@ type CompanySettings struct {
 
 	// WhatsApp Number for notifications
 	WhatsAppNumber string `json:"whatsapp_number" bson:"whatsapp_number"`
+
+	// Queue barcode format printed for drivers: qr, code128, ean13
+	BarcodeFormat string `json:"barcode_format" bson:"barcode_format"`
+
+	// Sender identity of notifications: a bold header naming the sender, a signature
+	// line under the message and a footer; each is left out when empty
+	SenderName string `json:"sender_name" bson:"sender_name"`
+	Signature  string `json:"signature" bson:"signature"`
+	Footer     string `json:"footer" bson:"footer"`
 }
 
 // NewCompanySettings creates a new CompanySettings instance
@@ -292,6 +628,984 @@ func NewCompanySettings() *CompanySettings {
 	}
 }
 
+// SettingsFieldChange is one company settings field changed by a revision
+type SettingsFieldChange struct {
+	Field string `json:"field" bson:"field"`
+	From  string `json:"from" bson:"from"`
+	To    string `json:"to" bson:"to"`
+}
+
+// SettingsRevision is one saved version of the company settings. Snapshot holds the
+// settings as they were after the change.
+type SettingsRevision struct {
+	ID           primitive.ObjectID    `json:"id" bson:"_id"`
+	Version      int                   `json:"version" bson:"version"`
+	Changes      []SettingsFieldChange `json:"changes" bson:"changes"`
+	Snapshot     CompanySettings       `json:"snapshot" bson:"snapshot"`
+	RevertedFrom int                   `json:"reverted_from,omitempty" bson:"reverted_from,omitempty"` // Version restored by a revert
+	ChangedBy    string                `json:"changed_by" bson:"changed_by"`
+	ChangedAt    time.Time             `json:"changed_at" bson:"changed_at"`
+}
+
+// ============================================
+// Status Notification Rule Model
+// ============================================
+
+// StatusNotificationRule configures the message sent when an order enters a status
+type StatusNotificationRule struct {
+	BaseModel  `bson:",inline"`
+	Status     string   `json:"status" bson:"status"`
+	Enabled    bool     `json:"enabled" bson:"enabled"`
+	Template   string   `json:"template" bson:"template"`
+	Recipients []string `json:"recipients" bson:"recipients"` // sales, customer, driver
+	UpdatedBy  string   `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// ============================================
+// Device Model
+// ============================================
+
+// Device is a registered kiosk (e.g. the gate tablet) with its own restricted token
+type Device struct {
+	BaseModel  `bson:",inline"`
+	Name       string     `json:"name" bson:"name"`
+	Location   string     `json:"location,omitempty" bson:"location,omitempty"`
+	TokenHash  string     `json:"-" bson:"token_hash"`
+	TokenHint  string     `json:"token_hint" bson:"token_hint"` // Last characters of the token, for identification
+	Active     bool       `json:"active" bson:"active"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" bson:"last_seen_at,omitempty"`
+	LastIP     string     `json:"last_ip,omitempty" bson:"last_ip,omitempty"`
+	CreatedBy  string     `json:"created_by" bson:"created_by"`
+}
+
+// NewDevice creates a new active Device instance
+func NewDevice() *Device {
+	return &Device{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Active: true,
+	}
+}
+
+// ============================================
+// Queue Scan Model
+// ============================================
+
+// QueueScan records a scan submitted by a kiosk, keyed by the client-generated ID
+// so a scan that is resubmitted after a reconnect is only processed once
+type QueueScan struct {
+	ClientID    string    `json:"client_id" bson:"_id"`
+	DeviceID    string    `json:"device_id,omitempty" bson:"device_id,omitempty"`
+	Barcode     string    `json:"barcode" bson:"barcode"`
+	ScannedAt   time.Time `json:"scanned_at" bson:"scanned_at"`
+	ReceivedAt  time.Time `json:"received_at" bson:"received_at"`
+	Result      string    `json:"result" bson:"result"`
+	Message     string    `json:"message,omitempty" bson:"message,omitempty"`
+	OrderID     string    `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	QueueNumber int       `json:"queue_number,omitempty" bson:"queue_number,omitempty"`
+}
+
+// ============================================
+// Retention Policy Model
+// ============================================
+
+// RetentionPolicy configures when personal data on old records is anonymized.
+// The policy is a single document; aggregates such as totals and quantities are never touched.
+type RetentionPolicy struct {
+	BaseModel `bson:",inline"`
+	Enabled   bool            `json:"enabled" bson:"enabled"`
+	Fields    []RetentionRule `json:"fields" bson:"fields"`
+	UpdatedBy string          `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+	LastRunAt *time.Time      `json:"last_run_at,omitempty" bson:"last_run_at,omitempty"`
+}
+
+// RetentionRule sets how long a personal data field is kept
+type RetentionRule struct {
+	Field     string `json:"field" bson:"field"`
+	Enabled   bool   `json:"enabled" bson:"enabled"`
+	AfterDays int    `json:"after_days" bson:"after_days"`
+}
+
+// ============================================
+// Payment Reminder Model
+// ============================================
+
+// ReminderPolicy configures the reminders re-sending the invoice link of unpaid orders.
+// Reminder n is sent Steps[n].AfterHours after the order was created; once the steps run
+// out the last one repeats every RepeatHours (0 stops) until MaxReminders is reached.
+// The policy is a single document.
+type ReminderPolicy struct {
+	BaseModel    `bson:",inline"`
+	Enabled      bool           `json:"enabled" bson:"enabled"`
+	Steps        []ReminderStep `json:"steps" bson:"steps"`
+	RepeatHours  int            `json:"repeat_hours" bson:"repeat_hours"`
+	MaxReminders int            `json:"max_reminders" bson:"max_reminders"`
+	UpdatedBy    string         `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// ReminderStep is one reminder of the policy; later steps use a firmer message
+type ReminderStep struct {
+	AfterHours int    `json:"after_hours" bson:"after_hours"`
+	Template   string `json:"template" bson:"template"` // Placeholders: {name}, {order_number}, {total}, {hours}, {link}
+}
+
+// PaymentScreening is the fraud-screening verdict on a payment proof. ProofURL is the
+// CDN URL screened, so a verdict arriving after the proof was replaced is dropped.
+type PaymentScreening struct {
+	Status      string     `json:"status" bson:"status"`
+	Score       float64    `json:"score,omitempty" bson:"score,omitempty"`
+	Reasons     []string   `json:"reasons,omitempty" bson:"reasons,omitempty"`
+	Reference   string     `json:"reference,omitempty" bson:"reference,omitempty"` // ID of the check at the provider
+	Error       string     `json:"error,omitempty" bson:"error,omitempty"`
+	ProofURL    string     `json:"-" bson:"proof_url"`
+	RequestedAt time.Time  `json:"requested_at" bson:"requested_at"`
+	CheckedAt   *time.Time `json:"checked_at,omitempty" bson:"checked_at,omitempty"`
+}
+
+// Resolutions of a staged order change
+const (
+	ChangeAccepted    = "accepted"     // Confirmed by the sales rep
+	ChangeRejected    = "rejected"     // Refused by the sales rep
+	ChangeAutoApplied = "auto_applied" // Applied by the timeout policy
+	ChangeExpired     = "expired"      // Discarded by the timeout policy
+	ChangeWithdrawn   = "withdrawn"    // Withdrawn by an admin, or the order moved on
+)
+
+// OrderChange is an edit of an order's items staged until the sales rep confirms it.
+// Items and the totals are the order after the change.
+type OrderChange struct {
+	ID            string       `json:"id" bson:"id"`
+	Items         []OrderItem  `json:"items" bson:"items"`
+	Changes       []ItemChange `json:"changes" bson:"changes"`
+	Quantity      int          `json:"quantity" bson:"quantity"`
+	Subtotal      float64      `json:"subtotal" bson:"subtotal"`
+	TotalPrice    float64      `json:"total_price" bson:"total_price"` // Includes the delivery fee
+	PreviousTotal float64      `json:"previous_total" bson:"previous_total"`
+	Reason        string       `json:"reason,omitempty" bson:"reason,omitempty"`
+	RequestedBy   string       `json:"requested_by" bson:"requested_by"`
+	RequestedAt   time.Time    `json:"requested_at" bson:"requested_at"`
+	ExpiresAt     *time.Time   `json:"expires_at,omitempty" bson:"expires_at,omitempty"` // When the timeout policy resolves it; never when nil
+	Resolution    string       `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	ResolvedAt    *time.Time   `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+// ItemChange is the quantity change of one order item
+type ItemChange struct {
+	Index       int    `json:"index" bson:"index"`
+	ProductName string `json:"product_name" bson:"product_name"`
+	Unit        string `json:"unit" bson:"unit"`
+	From        int    `json:"from" bson:"from"`
+	To          int    `json:"to" bson:"to"` // 0 removes the item
+}
+
+// Bank mutation statuses
+const (
+	MutationUnmatched = "unmatched"
+	MutationMatched   = "matched"  // Matched to an order waiting for verification
+	MutationVerified  = "verified" // The matched order's payment was verified
+	MutationIgnored   = "ignored"  // Debits, seen only so re-imports skip them
+)
+
+// BankMutation is a line of an imported bank statement. Key identifies it across imports
+// of overlapping statements.
+type BankMutation struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Key         string             `json:"-" bson:"key"`
+	Bank        string             `json:"bank" bson:"bank"`
+	ImportID    string             `json:"import_id" bson:"import_id"`
+	Date        time.Time          `json:"date" bson:"date"`
+	Description string             `json:"description" bson:"description"`
+	Reference   string             `json:"reference,omitempty" bson:"reference,omitempty"`
+	Amount      float64            `json:"amount" bson:"amount"`
+	Credit      bool               `json:"credit" bson:"credit"`
+	Balance     float64            `json:"balance,omitempty" bson:"balance,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	OrderID     string             `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	OrderNumber string             `json:"order_number,omitempty" bson:"order_number,omitempty"`
+	Confidence  int                `json:"confidence,omitempty" bson:"confidence,omitempty"`
+	ImportedBy  string             `json:"imported_by" bson:"imported_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// PaymentMatch is the bank mutation a statement import matched to an order's payment.
+// Confidence is 0-100; Verifiable marks a match strong enough to verify in one click.
+type PaymentMatch struct {
+	MutationID  string    `json:"mutation_id" bson:"mutation_id"`
+	Bank        string    `json:"bank" bson:"bank"`
+	Date        time.Time `json:"date" bson:"date"`
+	Amount      float64   `json:"amount" bson:"amount"`
+	Description string    `json:"description" bson:"description"`
+	Confidence  int       `json:"confidence" bson:"confidence"`
+	Reasons     []string  `json:"reasons" bson:"reasons"`
+	Verifiable  bool      `json:"verifiable" bson:"verifiable"`
+	MatchedAt   time.Time `json:"matched_at" bson:"matched_at"`
+}
+
+// PaymentReminder is a reminder sent for an order
+type PaymentReminder struct {
+	Number         int       `json:"number" bson:"number"` // 1-based
+	Phone          string    `json:"phone" bson:"phone"`
+	NotificationID string    `json:"notification_id" bson:"notification_id"`
+	SentAt         time.Time `json:"sent_at" bson:"sent_at"`
+}
+
+// OverduePolicy configures the reminders of orders unpaid past their due date. Reminder
+// n is sent n*EveryDays days after the due date (EveryDays 0 sends one) until
+// MaxReminders is reached; EscalateAfterDays past the due date the sales manager is told
+// once (0 never). The policy is a single document.
+type OverduePolicy struct {
+	BaseModel          `bson:",inline"`
+	Enabled            bool   `json:"enabled" bson:"enabled"`
+	EveryDays          int    `json:"every_days" bson:"every_days"`
+	MaxReminders       int    `json:"max_reminders" bson:"max_reminders"`
+	Template           string `json:"template" bson:"template"` // Placeholders: {name}, {order_number}, {total}, {due_date}, {days}, {link}
+	EscalateAfterDays  int    `json:"escalate_after_days" bson:"escalate_after_days"`
+	ManagerName        string `json:"manager_name,omitempty" bson:"manager_name,omitempty"`
+	ManagerPhone       string `json:"manager_phone,omitempty" bson:"manager_phone,omitempty"`
+	EscalationTemplate string `json:"escalation_template" bson:"escalation_template"` // Also {sales}, the sales' name
+	UpdatedBy          string `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// ============================================
+// Request Log Model
+// ============================================
+
+// RequestLog is a sampled, redacted request/response pair kept for support
+type RequestLog struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	Method         string             `json:"method" bson:"method"`
+	Path           string             `json:"path" bson:"path"`
+	Query          string             `json:"query,omitempty" bson:"query,omitempty"`
+	Status         int                `json:"status" bson:"status"`
+	DurationMs     int64              `json:"duration_ms" bson:"duration_ms"`
+	IP             string             `json:"ip" bson:"ip"`
+	UserID         string             `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Role           string             `json:"role,omitempty" bson:"role,omitempty"`
+	RequestHeaders map[string]string  `json:"request_headers,omitempty" bson:"request_headers,omitempty"`
+	RequestBody    string             `json:"request_body,omitempty" bson:"request_body,omitempty"`
+	ResponseBody   string             `json:"response_body,omitempty" bson:"response_body,omitempty"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// ClientSubmission is a journaled POST of a client page, keyed by the page's token.
+// Secrets are redacted from the body; everything else is kept as sent so a mangled
+// submission can be inspected and replayed against the current validation.
+type ClientSubmission struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Token       string             `json:"token" bson:"token"`
+	Route       string             `json:"route" bson:"route"` // e.g. /api/v1/client/driver/:token
+	Path        string             `json:"path" bson:"path"`
+	ContentType string             `json:"content_type,omitempty" bson:"content_type,omitempty"`
+	Body        string             `json:"body,omitempty" bson:"body,omitempty"`
+	Truncated   bool               `json:"truncated,omitempty" bson:"truncated,omitempty"`
+	Status      int                `json:"status" bson:"status"`
+	ErrorCode   string             `json:"error_code,omitempty" bson:"error_code,omitempty"`
+	Message     string             `json:"message,omitempty" bson:"message,omitempty"`
+	IP          string             `json:"ip" bson:"ip"`
+	UserAgent   string             `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	AppVersion  string             `json:"app_version,omitempty" bson:"app_version,omitempty"` // X-App-Version of the frontend
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// ============================================
+// Saved Report Models
+// ============================================
+
+// ReportDefinition is a saved order report: a filter, the fields to group by and the
+// metrics to compute, optionally run on a schedule and delivered to recipients
+type ReportDefinition struct {
+	BaseModel   `bson:",inline"`
+	Name        string            `json:"name" bson:"name"`
+	Description string            `json:"description,omitempty" bson:"description,omitempty"`
+	Filter      ReportFilter      `json:"filter" bson:"filter"`
+	GroupBy     []string          `json:"group_by" bson:"group_by"`
+	Metrics     []string          `json:"metrics" bson:"metrics"`
+	PeriodDays  int               `json:"period_days" bson:"period_days"` // Scheduled runs cover the last N days
+	Schedule    *ReportSchedule   `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	Recipients  []ReportRecipient `json:"recipients" bson:"recipients"`
+	CreatedBy   string            `json:"created_by" bson:"created_by"`
+	LastRunAt   *time.Time        `json:"last_run_at,omitempty" bson:"last_run_at,omitempty"`
+}
+
+// ReportFilter narrows the orders a report covers; empty fields match everything
+type ReportFilter struct {
+	Statuses      []string `json:"statuses,omitempty" bson:"statuses,omitempty"`
+	PaymentStatus string   `json:"payment_status,omitempty" bson:"payment_status,omitempty"`
+	SalesIDs      []string `json:"sales_ids,omitempty" bson:"sales_ids,omitempty"`
+	Products      []string `json:"products,omitempty" bson:"products,omitempty"` // Item product names
+}
+
+// ReportSchedule runs a report every day, week or month at a local hour
+type ReportSchedule struct {
+	Frequency string    `json:"frequency" bson:"frequency"`
+	Hour      int       `json:"hour" bson:"hour"`
+	Weekday   int       `json:"weekday,omitempty" bson:"weekday,omitempty"` // Weekly: 0 = Sunday
+	Day       int       `json:"day,omitempty" bson:"day,omitempty"`         // Monthly: 1-28
+	NextRunAt time.Time `json:"next_run_at" bson:"next_run_at"`
+}
+
+// ReportRecipient receives the results of scheduled runs
+type ReportRecipient struct {
+	Channel string `json:"channel" bson:"channel"`
+	Address string `json:"address" bson:"address"` // Email address or phone number
+}
+
+// ReportRun is the stored result of running a report definition
+type ReportRun struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ReportID   string             `json:"report_id" bson:"report_id"`
+	Name       string             `json:"name" bson:"name"`
+	From       time.Time          `json:"from" bson:"from"`
+	To         time.Time          `json:"to" bson:"to"`
+	GroupBy    []string           `json:"group_by" bson:"group_by"`
+	Metrics    []string           `json:"metrics" bson:"metrics"`
+	Rows       []ReportRow        `json:"rows" bson:"rows"`
+	Totals     map[string]float64 `json:"totals" bson:"totals"`
+	Trigger    string             `json:"trigger" bson:"trigger"` // manual or schedule
+	RunBy      string             `json:"run_by,omitempty" bson:"run_by,omitempty"`
+	Deliveries []ReportDelivery   `json:"deliveries,omitempty" bson:"deliveries,omitempty"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// ReportRow is one group of a report result
+type ReportRow struct {
+	Group   map[string]string  `json:"group" bson:"group"`
+	Metrics map[string]float64 `json:"metrics" bson:"metrics"`
+}
+
+// ReportDelivery records the outcome of sending a run to a recipient
+type ReportDelivery struct {
+	Channel string `json:"channel" bson:"channel"`
+	Address string `json:"address" bson:"address"`
+	Sent    bool   `json:"sent" bson:"sent"`
+	Error   string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// NewReportDefinition creates a new ReportDefinition instance
+func NewReportDefinition() *ReportDefinition {
+	return &ReportDefinition{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		GroupBy:    []string{},
+		Metrics:    []string{},
+		PeriodDays: 30,
+		Recipients: []ReportRecipient{},
+	}
+}
+
+// ============================================
+// Session Model
+// ============================================
+
+// Session represents a logged-in device backed by a refresh token
+type Session struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID         string             `json:"user_id" bson:"user_id"`
+	IP             string             `json:"ip" bson:"ip"`
+	UserAgent      string             `json:"user_agent" bson:"user_agent"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	LastActivityAt time.Time          `json:"last_activity_at" bson:"last_activity_at"`
+	ExpiresAt      time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt      *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	RevokedBy      string             `json:"revoked_by,omitempty" bson:"revoked_by,omitempty"`
+}
+
+// ============================================
+// Audit Log Model
+// ============================================
+
+// AuditLog records an action performed by an admin
+type AuditLog struct {
+	ID         primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	ActorID    string                 `json:"actor_id" bson:"actor_id"`                               // Real user who performed the action
+	OnBehalfOf string                 `json:"on_behalf_of,omitempty" bson:"on_behalf_of,omitempty"` // Impersonated user (if any)
+	Action     string                 `json:"action" bson:"action"`
+	EntityType string                 `json:"entity_type" bson:"entity_type"`
+	EntityID   string                 `json:"entity_id,omitempty" bson:"entity_id,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	IP         string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+	CreatedAt  time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// ============================================
+// Export Job Model
+// ============================================
+
+// ExportJob tracks a background export whose result is downloaded when done
+type ExportJob struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type       string             `json:"type" bson:"type"`
+	Status     string             `json:"status" bson:"status"`
+	From       time.Time          `json:"from" bson:"from"`
+	To         time.Time          `json:"to" bson:"to"`
+	Total      int                `json:"total" bson:"total"`
+	Processed  int                `json:"processed" bson:"processed"`
+	FileName   string             `json:"file_name,omitempty" bson:"file_name,omitempty"`
+	FilePath   string             `json:"-" bson:"file_path,omitempty"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedBy  string             `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// ============================================
+// Tag Model
+// ============================================
+
+// Tag is a catalog entry for an order tag. Orders may carry tags that are not in the
+// catalog; the catalog only adds a color and description for the frontend.
+type Tag struct {
+	BaseModel   `bson:",inline"`
+	Name        string `json:"name" bson:"name"`   // Normalized: lowercase, trimmed
+	Color       string `json:"color" bson:"color"` // Hex color, e.g. #E53935
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+// NewTag creates a new Tag instance
+func NewTag() *Tag {
+	return &Tag{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+	}
+}
+
+// TagHint is how an order tag is displayed
+type TagHint struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// ============================================
+// Saved View Model
+// ============================================
+
+// SavedView is a named filter and sort combination for an admin list.
+// Filter holds list query parameters, e.g. {"status": "paid", "tags": "urgent"}.
+type SavedView struct {
+	BaseModel `bson:",inline"`
+	Name      string            `json:"name" bson:"name"`
+	Resource  string            `json:"resource" bson:"resource"` // orders, payments, queue
+	Filter    map[string]string `json:"filter" bson:"filter"`
+	Sort      string            `json:"sort,omitempty" bson:"sort,omitempty"` // Field, "-" prefix for descending
+	OwnerID   string            `json:"owner_id" bson:"owner_id"`
+	Shared    bool              `json:"shared" bson:"shared"` // Visible to every admin, editable by the owner
+}
+
+// NewSavedView creates a new SavedView instance
+func NewSavedView() *SavedView {
+	return &SavedView{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Filter: map[string]string{},
+	}
+}
+
+// ============================================
+// Queue Day Model
+// ============================================
+
+// QueueDay is the end-of-day snapshot of one queue day, kept for reconciliation
+type QueueDay struct {
+	Date           string          `json:"date" bson:"_id"` // YYYY-MM-DD, same day boundaries as queue numbering
+	Total          int             `json:"total" bson:"total"`
+	Served         int             `json:"served" bson:"served"`
+	Skipped        int             `json:"skipped" bson:"skipped"`
+	Unserved       int             `json:"unserved" bson:"unserved"`
+	AvgWaitMinutes *float64        `json:"avg_wait_minutes" bson:"avg_wait_minutes"`
+	MaxWaitMinutes *float64        `json:"max_wait_minutes" bson:"max_wait_minutes"`
+	Hours          []QueueHourStat `json:"hours" bson:"hours"`
+	Entries        []QueueDayEntry `json:"entries,omitempty" bson:"entries"`
+	SnapshotAt     time.Time       `json:"snapshot_at" bson:"snapshot_at"`
+}
+
+// QueueHourStat is the throughput of one hour of a queue day
+type QueueHourStat struct {
+	Hour     int `json:"hour" bson:"hour"` // 0-23 in Asia/Jakarta
+	Arrivals int `json:"arrivals" bson:"arrivals"`
+	Called   int `json:"called" bson:"called"`
+}
+
+// QueueDayEntry is one order that entered the queue that day
+type QueueDayEntry struct {
+	OrderID      string     `json:"order_id" bson:"order_id"`
+	OrderNumber  string     `json:"order_number" bson:"order_number"`
+	QueueNumber  int        `json:"queue_number" bson:"queue_number"`
+	DriverName   string     `json:"driver_name,omitempty" bson:"driver_name,omitempty"`
+	VehiclePlate string     `json:"vehicle_plate,omitempty" bson:"vehicle_plate,omitempty"`
+	LoadingBay   string     `json:"loading_bay,omitempty" bson:"loading_bay,omitempty"`
+	Outcome      string     `json:"outcome" bson:"outcome"`
+	EnteredAt    time.Time  `json:"entered_at" bson:"entered_at"`
+	CalledAt     *time.Time `json:"called_at,omitempty" bson:"called_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	WaitMinutes  *float64   `json:"wait_minutes" bson:"wait_minutes"` // Until called, cancelled, or the snapshot
+}
+
+// ============================================
+// Daily Stats Model
+// ============================================
+
+// DailyStats is the snapshot of one day's dashboard figures, kept for trend charts
+type DailyStats struct {
+	Date           string    `json:"date" bson:"_id"` // YYYY-MM-DD, same day boundaries as the dashboard
+	Orders         int       `json:"orders" bson:"orders"`
+	Completed      int       `json:"completed" bson:"completed"`
+	Cancelled      int       `json:"cancelled" bson:"cancelled"`
+	Revenue        float64   `json:"revenue" bson:"revenue"` // Orders created that day, cancelled and declined excluded
+	QueueEntered   int       `json:"queue_entered" bson:"queue_entered"`
+	QueueServed    int       `json:"queue_served" bson:"queue_served"`
+	AvgWaitMinutes *float64  `json:"avg_wait_minutes" bson:"avg_wait_minutes"`
+	SnapshotAt     time.Time `json:"snapshot_at" bson:"snapshot_at"`
+	Live           bool      `json:"live,omitempty" bson:"-"` // Computed on request, not a stored snapshot
+}
+
+// ============================================
+// WhatsApp Broadcast Model
+// ============================================
+
+// Broadcast is an announcement sent to WhatsApp groups and/or sales reps
+type Broadcast struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Message    string             `json:"message" bson:"message"`
+	Status     string             `json:"status" bson:"status"`
+	Targets    []BroadcastTarget  `json:"targets" bson:"targets"`
+	Sent       int                `json:"sent" bson:"sent"`
+	Failed     int                `json:"failed" bson:"failed"`
+	CreatedBy  string             `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// BroadcastTarget is one recipient of a broadcast
+type BroadcastTarget struct {
+	Kind   string     `json:"kind" bson:"kind"` // group, sales
+	To     string     `json:"to" bson:"to"`     // Group JID or phone
+	Name   string     `json:"name" bson:"name"`
+	Status string     `json:"status" bson:"status"`
+	Error  string     `json:"error,omitempty" bson:"error,omitempty"`
+	SentAt *time.Time `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
+
+// ============================================
+// Scheduled Message Model
+// ============================================
+
+// ScheduledMessage is a one-off WhatsApp message sent at SendAt by the scheduler
+type ScheduledMessage struct {
+	BaseModel   `bson:",inline"`
+	Phone       string     `json:"phone" bson:"phone"`
+	Message     string     `json:"message" bson:"message"`
+	SendAt      time.Time  `json:"send_at" bson:"send_at"`
+	Status      string     `json:"status" bson:"status"`
+	CreatedBy   string     `json:"created_by" bson:"created_by"`
+	SentAt      *time.Time `json:"sent_at,omitempty" bson:"sent_at,omitempty"` // Handed to the notification queue, which retries failures
+	CancelledAt *time.Time `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+}
+
+// NewScheduledMessage creates a new ScheduledMessage instance
+func NewScheduledMessage() *ScheduledMessage {
+	return &ScheduledMessage{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Status: ScheduledStatusScheduled,
+	}
+}
+
+// ============================================
+// Notification Broadcast Model
+// ============================================
+
+// NotificationBroadcast is a message an admin composed for an audience segment. It goes
+// out through the notification queue at SendAt, one notification per recipient, so opt-
+// outs, retries and fallbacks apply as to any notification. Status uses the scheduled
+// message statuses.
+type NotificationBroadcast struct {
+	BaseModel   `bson:",inline"`
+	Message     string               `json:"message" bson:"message"` // May use {name}
+	Segment     string               `json:"segment" bson:"segment"`
+	SendAt      time.Time            `json:"send_at" bson:"send_at"`
+	Status      string               `json:"status" bson:"status"`
+	Audience    int                  `json:"audience" bson:"audience"` // Size when composed, then the resolved recipients
+	Recipients  []BroadcastRecipient `json:"recipients,omitempty" bson:"recipients,omitempty"`
+	CreatedBy   string               `json:"created_by" bson:"created_by"`
+	StartedAt   *time.Time           `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	HeartbeatAt *time.Time           `json:"-" bson:"heartbeat_at,omitempty"` // Renewed while a run sends; a stale one is resumed
+	FinishedAt  *time.Time           `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	CancelledAt *time.Time           `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+}
+
+// BroadcastRecipient is one recipient of a notification broadcast, resolved from the
+// segment when sending starts
+type BroadcastRecipient struct {
+	Phone          string `json:"phone" bson:"phone"`
+	Name           string `json:"name" bson:"name"`
+	NotificationID string `json:"notification_id" bson:"notification_id"`
+}
+
+// NewNotificationBroadcast creates a new NotificationBroadcast instance
+func NewNotificationBroadcast() *NotificationBroadcast {
+	return &NotificationBroadcast{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Status: ScheduledStatusScheduled,
+	}
+}
+
+// ============================================
+// Payment Approval Policy Model
+// ============================================
+
+// PaymentApprovalPolicy requires a second approval for payments above Threshold (four-eyes).
+// A SUPERADMIN verifies such payments alone; an ADMIN's verification waits for a second,
+// different approver. A threshold of 0 disables the policy. The policy is a single document.
+type PaymentApprovalPolicy struct {
+	BaseModel `bson:",inline"`
+	Threshold float64 `json:"threshold" bson:"threshold"`
+	UpdatedBy string  `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// ============================================
+// Delivery Fee Model
+// ============================================
+
+// DeliveryFeePolicy prices delivery to site by distance from the depot. The distance is
+// the straight line to the geocoded address times RoadFactor; the fee is that distance
+// times the PerKm of the first tier reaching it, at least MinimumFee, rounded up to
+// RoundTo. Addresses past the last tier are outside the delivery area.
+// The policy is a single document.
+type DeliveryFeePolicy struct {
+	BaseModel    `bson:",inline"`
+	Enabled      bool              `json:"enabled" bson:"enabled"`
+	DepotAddress string            `json:"depot_address" bson:"depot_address"`
+	DepotLat     float64           `json:"depot_lat" bson:"depot_lat"`
+	DepotLng     float64           `json:"depot_lng" bson:"depot_lng"`
+	RoadFactor   float64           `json:"road_factor" bson:"road_factor"`
+	Tiers        []DeliveryFeeTier `json:"tiers" bson:"tiers"`
+	MinimumFee   float64           `json:"minimum_fee" bson:"minimum_fee"`
+	RoundTo      float64           `json:"round_to" bson:"round_to"`
+	UpdatedBy    string            `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// DeliveryFeeTier is one row of the fee table
+type DeliveryFeeTier struct {
+	UpToKm float64 `json:"up_to_km" bson:"up_to_km"`
+	PerKm  float64 `json:"per_km" bson:"per_km"`
+}
+
+// DeliveryQuote is the fee computed for an address
+type DeliveryQuote struct {
+	Address    string   `json:"address"` // As formatted by the geocoder
+	Location   GeoPoint `json:"location"`
+	DistanceKm float64  `json:"distance_km"`
+	Fee        float64  `json:"fee"`
+}
+
+// ============================================
+// Price Check Model
+// ============================================
+
+// PriceCheckPolicy compares manually entered unit prices with the catalog price of the
+// product with the same name. Items deviating more than MaxDeviationPercent either way
+// need a price note and are recorded as price overrides. A value of 0 disables the check.
+// The policy is a single document.
+type PriceCheckPolicy struct {
+	BaseModel           `bson:",inline"`
+	MaxDeviationPercent float64 `json:"max_deviation_percent" bson:"max_deviation_percent"`
+	UpdatedBy           string  `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// PriceOverride is an order item entered at a price beyond the allowed deviation
+type PriceOverride struct {
+	BaseModel        `bson:",inline"`
+	OrderID          string  `json:"order_id" bson:"order_id"`
+	OrderNumber      string  `json:"order_number" bson:"order_number"`
+	ProductName      string  `json:"product_name" bson:"product_name"`
+	Quantity         int     `json:"quantity" bson:"quantity"`
+	ListPrice        float64 `json:"list_price" bson:"list_price"`
+	UnitPrice        float64 `json:"unit_price" bson:"unit_price"`
+	DeviationPercent float64 `json:"deviation_percent" bson:"deviation_percent"` // Negative below the list price
+	Note             string  `json:"note" bson:"note"`
+	AdminID          string  `json:"admin_id" bson:"admin_id"`
+}
+
+// ============================================
+// Unit of Measure Model
+// ============================================
+
+// Unit is an entry of the unit of measure registry. A quantity in the unit times Factor
+// is the quantity in the base unit of its dimension, e.g. 1 ton = 1000 kg.
+type Unit struct {
+	BaseModel `bson:",inline"`
+	Code      string   `json:"code" bson:"code"` // Normalized: lowercase, trimmed, e.g. sak
+	Name      string   `json:"name" bson:"name"`
+	Dimension string   `json:"dimension" bson:"dimension"` // count, mass, volume, length
+	Factor    float64  `json:"factor" bson:"factor"`       // Base units per unit
+	Aliases   []string `json:"aliases" bson:"aliases"`     // Other spellings accepted for the unit
+}
+
+// NewUnit creates a new Unit instance
+func NewUnit() *Unit {
+	return &Unit{
+		BaseModel: BaseModel{
+			ID:        primitive.NewObjectID(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		Aliases: []string{},
+	}
+}
+
+// ============================================
+// Chat Alert Model
+// ============================================
+
+// AlertChannel is a chat webhook operational alerts are posted to
+type AlertChannel struct {
+	Name       string `json:"name" bson:"name"`
+	Kind       string `json:"kind" bson:"kind"` // slack, discord
+	WebhookURL string `json:"webhook_url" bson:"webhook_url"`
+}
+
+// AlertRule configures one alert type. Threshold is in minutes for whatsapp_down and
+// queue_empty, and in payments for payment_backlog; an alert fires at most once per
+// CooldownMinutes. sla_breach alerts follow the SLA policy and ignore Threshold.
+type AlertRule struct {
+	Type            string   `json:"type" bson:"type"`
+	Enabled         bool     `json:"enabled" bson:"enabled"`
+	Channels        []string `json:"channels" bson:"channels"` // Channel names, empty = all channels
+	Threshold       int      `json:"threshold" bson:"threshold"`
+	CooldownMinutes int      `json:"cooldown_minutes" bson:"cooldown_minutes"`
+}
+
+// AlertPolicy routes operational alerts to chat channels. The queue_empty alert only
+// fires between OpenFrom and OpenUntil (HH:MM, Asia/Jakarta time). The policy is a single
+// document.
+type AlertPolicy struct {
+	BaseModel `bson:",inline"`
+	Channels  []AlertChannel `json:"channels" bson:"channels"`
+	Rules     []AlertRule    `json:"rules" bson:"rules"`
+	OpenFrom  string         `json:"open_from" bson:"open_from"`
+	OpenUntil string         `json:"open_until" bson:"open_until"`
+	UpdatedBy string         `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// ============================================
+// SLA Policy Model
+// ============================================
+
+// SLARule sets how long an order may stay in an operational stage. Orders are at risk
+// from WarnMinutes before TargetMinutes and breached past it; both alert Role.
+type SLARule struct {
+	Stage         string `json:"stage" bson:"stage"`
+	Enabled       bool   `json:"enabled" bson:"enabled"`
+	TargetMinutes int    `json:"target_minutes" bson:"target_minutes"`
+	WarnMinutes   int    `json:"warn_minutes" bson:"warn_minutes"`
+	Role          string `json:"role" bson:"role"` // Role responsible for the stage
+}
+
+// SLAPolicy holds the SLA of every stage. The policy is a single document.
+type SLAPolicy struct {
+	BaseModel `bson:",inline"`
+	Rules     []SLARule `json:"rules" bson:"rules"`
+	UpdatedBy string    `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// SLAStatus is the time an order has spent in its current stage against the stage's SLA
+type SLAStatus struct {
+	Stage          string    `json:"stage"`
+	Role           string    `json:"role"`
+	State          string    `json:"state"` // on_track, at_risk or breached
+	StartedAt      time.Time `json:"started_at"`
+	DueAt          time.Time `json:"due_at"`
+	ElapsedMinutes int       `json:"elapsed_minutes"`
+}
+
+// SLA states
+const (
+	SLAOnTrack  = "on_track"
+	SLAAtRisk   = "at_risk"
+	SLABreached = "breached"
+)
+
+// ============================================
+// Returnable Asset Model
+// ============================================
+
+// AssetEntry is a movement in the ledger of returnable assets lent with shipments.
+// The outstanding quantity of a sales/customer is what was lent minus what was returned.
+type AssetEntry struct {
+	BaseModel      `bson:",inline"`
+	Kind           string `json:"kind" bson:"kind"`             // lent, returned
+	AssetType      string `json:"asset_type" bson:"asset_type"` // Lowercase, e.g. pallet
+	Quantity       int    `json:"quantity" bson:"quantity"`
+	SalesID        string `json:"sales_id" bson:"sales_id"`
+	SalesName      string `json:"sales_name" bson:"sales_name"`
+	CustomerName   string `json:"customer_name,omitempty" bson:"customer_name,omitempty"`
+	CustomerPhone  string `json:"customer_phone,omitempty" bson:"customer_phone,omitempty"`
+	DeliveryNoteID string `json:"delivery_note_id,omitempty" bson:"delivery_note_id,omitempty"`
+	NoteNumber     string `json:"note_number,omitempty" bson:"note_number,omitempty"`
+	OrderID        string `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Note           string `json:"note,omitempty" bson:"note,omitempty"`
+	RecordedBy     string `json:"recorded_by" bson:"recorded_by"`
+}
+
+// AssetCount is a quantity of one asset type
+type AssetCount struct {
+	AssetType string `json:"asset_type" bson:"asset_type"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+}
+
+// ============================================
+// Notification Opt-Out Model
+// ============================================
+
+// NotificationOptOut is a phone that asked to stop receiving automated WhatsApp messages.
+// Messages to it are suppressed instead of sent and counted here.
+type NotificationOptOut struct {
+	BaseModel        `bson:",inline"`
+	Phone            string     `json:"phone" bson:"phone"`   // Normalized, unique
+	Source           string     `json:"source" bson:"source"` // admin, inbound
+	Reason           string     `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedBy        string     `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Suppressed       int        `json:"suppressed" bson:"suppressed"`
+	LastSuppressedAt *time.Time `json:"last_suppressed_at,omitempty" bson:"last_suppressed_at,omitempty"`
+}
+
+// InboundMessage is a direct WhatsApp message received from a phone, linked to the order
+// it is most likely about so it shows in the order's conversation
+type InboundMessage struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Phone       string             `json:"phone" bson:"phone"` // Normalized
+	Text        string             `json:"text" bson:"text"`
+	OrderID     string             `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	OrderNumber string             `json:"order_number,omitempty" bson:"order_number,omitempty"`
+	MatchedBy   string             `json:"matched_by,omitempty" bson:"matched_by,omitempty"` // order_number, last_notification
+	ReceivedAt  time.Time          `json:"received_at" bson:"received_at"`
+}
+
+// Inbound Message Match constants
+const (
+	InboundMatchOrderNumber      = "order_number"      // The text names an order notified to the phone
+	InboundMatchLastNotification = "last_notification" // The order of the latest notification to the phone
+)
+
+// ============================================
+// Invoice View Model
+// ============================================
+
+// InvoiceView is one open of an order's invoice link on the client page
+type InvoiceView struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	OrderID     string             `json:"order_id" bson:"order_id"`
+	OrderNumber string             `json:"order_number" bson:"order_number"`
+	IP          string             `json:"ip" bson:"ip"`
+	UserAgent   string             `json:"user_agent" bson:"user_agent"`
+	ViewedAt    time.Time          `json:"viewed_at" bson:"viewed_at"`
+}
+
+// ============================================
+// Short Link Model
+// ============================================
+
+// ShortLink maps a short code to a long client link, for links sent in messages
+type ShortLink struct {
+	Code      string     `json:"code" bson:"_id"`
+	Target    string     `json:"target" bson:"target"`
+	Hits      int        `json:"hits" bson:"hits"`
+	LastHitAt *time.Time `json:"last_hit_at,omitempty" bson:"last_hit_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+}
+
+// ============================================
+// Queue Duration Settings Model
+// ============================================
+
+// CategoryDuration is the loading duration of orders carrying a product category
+type CategoryDuration struct {
+	Category string `json:"category" bson:"category"`
+	Minutes  int    `json:"minutes" bson:"minutes"`
+}
+
+// QuantityBracket is the loading duration of orders of at least MinQuantity units
+type QuantityBracket struct {
+	MinQuantity int `json:"min_quantity" bson:"min_quantity"`
+	Minutes     int `json:"minutes" bson:"minutes"`
+}
+
+// QueueDurationSettings configures how long loading an order takes in ETA computations.
+// An order takes the longest duration that applies to it, or DefaultMinutes when none does.
+// The settings are a single document.
+type QueueDurationSettings struct {
+	BaseModel      `bson:",inline"`
+	DefaultMinutes int                `json:"default_minutes" bson:"default_minutes"`
+	Categories     []CategoryDuration `json:"categories" bson:"categories"`
+	Brackets       []QuantityBracket  `json:"brackets" bson:"brackets"`
+	UpdatedBy      string             `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// ClientView is the denormalized projection of an order read by the client tracking pages.
+// It keeps only what those pages show and is kept in sync from the order change feed.
+// The queue fields share the order's bson names so queue ETAs can be computed from views.
+type ClientView struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	InvoiceToken    string             `json:"-" bson:"invoice_token"`
+	OrderNumber     string             `json:"order_number" bson:"order_number"`
+	Status          string             `json:"status" bson:"status"`
+	PaymentStatus   string             `json:"payment_status" bson:"payment_status"`
+	Accepted        bool               `json:"-" bson:"accepted"`
+	CustomerName    string             `json:"customer_name,omitempty" bson:"customer_name,omitempty"`
+	SalesID         string             `json:"-" bson:"sales_id,omitempty"`
+	SalesName       string             `json:"sales_name,omitempty" bson:"sales_name,omitempty"`
+	ProductID       string             `json:"-" bson:"product_id,omitempty"`
+	ProductName     string             `json:"product_name,omitempty" bson:"product_name,omitempty"`
+	Items           []OrderItem        `json:"items" bson:"items"`
+	Quantity        int                `json:"quantity" bson:"quantity"`
+	TotalPrice      float64            `json:"total_price" bson:"total_price"`
+	QueueNumber     int                `json:"queue_number,omitempty" bson:"queue_number,omitempty"`
+	QueueEnteredAt  *time.Time         `json:"-" bson:"queue_entered_at,omitempty"`
+	QueueBarcode    string             `json:"queue_barcode,omitempty" bson:"queue_barcode,omitempty"`
+	QueueQRCode     string             `json:"queue_qrcode,omitempty" bson:"queue_qrcode,omitempty"`
+	BarcodeFormat   string             `json:"barcode_format,omitempty" bson:"barcode_format,omitempty"`
+	BarcodeImage    string             `json:"barcode_image,omitempty" bson:"barcode_image,omitempty"`
+	EstimatedTime   string             `json:"estimated_time,omitempty" bson:"estimated_time,omitempty"`
+	LoadingBay      string             `json:"loading_bay,omitempty" bson:"loading_bay,omitempty"`
+	DriverName      string             `json:"driver_name,omitempty" bson:"driver_name,omitempty"`
+	VehiclePlate    string             `json:"vehicle_plate,omitempty" bson:"vehicle_plate,omitempty"`
+	DeliveryNoteID  string             `json:"delivery_note_id,omitempty" bson:"delivery_note_id,omitempty"`
+	DeliveryNoteURL string             `json:"delivery_note_url,omitempty" bson:"delivery_note_url,omitempty"`
+	Shipment        *ClientShipment    `json:"shipment,omitempty" bson:"shipment,omitempty"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// ClientShipment is the tracking of an order delivered to the customer's site
+type ClientShipment struct {
+	DeliveryAddress string     `json:"delivery_address" bson:"delivery_address"`
+	DispatchedAt    *time.Time `json:"dispatched_at" bson:"dispatched_at,omitempty"`
+	DeliveryETA     *time.Time `json:"delivery_eta" bson:"delivery_eta,omitempty"`
+	ArrivedAt       *time.Time `json:"arrived_at" bson:"arrived_at,omitempty"`
+	DriverLocation  *GeoPoint  `json:"driver_location" bson:"driver_location,omitempty"`
+	DeliveryFee     float64    `json:"delivery_fee" bson:"delivery_fee"`
+}
+
 // ============================================
 // Constants
 // ============================================
@@ -301,6 +1615,7 @@ const (
 	RoleSuperAdmin = "SUPERADMIN"
 	RoleAdmin      = "ADMIN"
 	RoleUser       = "USER"
+	RoleDevice     = "DEVICE" // Kiosk device token, only scan and call-next
 )
 
 // Order Status constants
@@ -312,14 +1627,198 @@ const (
 	OrderStatusLoading   = "loading"   // Being loaded
 	OrderStatusCompleted = "completed" // Delivery note created
 	OrderStatusCancelled = "cancelled" // Order cancelled
+	OrderStatusDeclined  = "declined"  // Declined by sales on the invoice page
 )
 
+// OrderStatuses lists every order status in lifecycle order
+var OrderStatuses = []string{
+	OrderStatusPending,
+	OrderStatusPaid,
+	OrderStatusConfirmed,
+	OrderStatusQueued,
+	OrderStatusLoading,
+	OrderStatusCompleted,
+	OrderStatusCancelled,
+	OrderStatusDeclined,
+}
+
+// UnrealizedOrderStatuses are the final statuses whose orders never count toward revenue
+var UnrealizedOrderStatuses = []string{OrderStatusCancelled, OrderStatusDeclined}
+
 // Payment Status constants
 const (
 	PaymentStatusPending  = "pending"
 	PaymentStatusVerified = "verified"
 	PaymentStatusRejected = "rejected"
+	PaymentStatusApproval = "pending_second_approval" // Approved once, above the approval threshold
+)
+
+// Payment screening status constants
+const (
+	ScreeningPending    = "pending"    // Sent to the screening service, no verdict yet
+	ScreeningClean      = "clean"      // No fraud signals
+	ScreeningSuspicious = "suspicious" // Flagged; check the proof carefully before verifying
+	ScreeningError      = "error"      // The service failed or timed out
+)
+
+// Return Status constants
+const (
+	ReturnStatusOpen      = "open"      // Submitted by customer
+	ReturnStatusReviewing = "reviewing" // Being triaged by admin
+	ReturnStatusResolved  = "resolved"  // Replaced or refund note issued
+	ReturnStatusRejected  = "rejected"  // Complaint rejected
+)
+
+// CancelRequest status constants
+const (
+	CancelRequestPending  = "pending"  // Waiting for an admin
+	CancelRequestApproved = "approved" // Order cancelled
+	CancelRequestDenied   = "denied"   // Order kept
+)
+
+// Return Resolution constants
+const (
+	ReturnResolutionReplace    = "replace"
+	ReturnResolutionRefundNote = "refund_note"
+	ReturnResolutionReject     = "reject"
+)
+
+// Order Note Visibility constants
+const (
+	NoteVisibilityInternal = "internal" // Staff only
+	NoteVisibilityShared   = "shared"   // Also shown on the client invoice page
+)
+
+// Notification Recipient constants
+const (
+	RecipientSales    = "sales"
+	RecipientCustomer = "customer"
+	RecipientDriver   = "driver"
+)
+
+// Export Job constants
+const (
+	ExportTypeDeliveryNotes = "delivery_notes"
+
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+)
+
+// Queue Scan Result constants
+const (
+	ScanResultProcessing = "processing" // Claimed, not finished yet
+	ScanResultQueued     = "queued"     // Order entered the queue
+	ScanResultConflict   = "conflict"   // Barcode already used or order moved on
+	ScanResultRejected   = "rejected"   // Unknown barcode or incomplete order
+)
+
+// Retention Field constants
+const (
+	RetentionFieldDriverPhone   = "driver_phone"
+	RetentionFieldCustomerPhone = "customer_phone"
+	RetentionFieldSalesPhone    = "sales_phone"
+	RetentionFieldPaymentProof  = "payment_proof"
+	RetentionFieldMessage       = "notification_message" // Content of sent and received WhatsApp messages
+)
+
+// Report constants
+const (
+	ReportGroupSales   = "sales"
+	ReportGroupProduct = "product"
+	ReportGroupStatus  = "status"
+	ReportGroupDay     = "day"
+	ReportGroupWeek    = "week"
+	ReportGroupMonth   = "month"
+
+	ReportMetricRevenue       = "revenue"
+	ReportMetricOrders        = "orders"
+	ReportMetricQuantity      = "quantity"
+	ReportMetricAvgOrderValue = "avg_order_value"
+
+	ReportFrequencyDaily   = "daily"
+	ReportFrequencyWeekly  = "weekly"
+	ReportFrequencyMonthly = "monthly"
+
+	ReportChannelEmail    = "email"
+	ReportChannelWhatsApp = "whatsapp"
+
+	ReportTriggerManual   = "manual"
+	ReportTriggerSchedule = "schedule"
+)
+
+// Saved View Resource constants
+const (
+	ViewResourceOrders   = "orders"
+	ViewResourcePayments = "payments"
+	ViewResourceQueue    = "queue"
+)
+
+// Queue Outcome constants
+const (
+	QueueOutcomeServed   = "served"   // Called to a bay
+	QueueOutcomeSkipped  = "skipped"  // Cancelled while waiting, never called
+	QueueOutcomeUnserved = "unserved" // Still waiting when the day was snapshotted
+)
+
+// Broadcast constants
+const (
+	BroadcastStatusRunning = "running"
+	BroadcastStatusDone    = "done"
+
+	BroadcastTargetGroup = "group"
+	BroadcastTargetSales = "sales"
+
+	BroadcastTargetPending = "pending"
+	BroadcastTargetSent    = "sent"
+	BroadcastTargetFailed  = "failed"
+	BroadcastTargetSkipped = "skipped" // Opted out of automated messages
+)
+
+// Scheduled Message Status constants
+const (
+	ScheduledStatusScheduled = "scheduled"
+	ScheduledStatusSending   = "sending" // Claimed by a scheduler run
+	ScheduledStatusSent      = "sent"
+	ScheduledStatusCancelled = "cancelled"
+)
+
+// Asset Entry Kind constants
+const (
+	AssetEntryLent     = "lent"
+	AssetEntryReturned = "returned"
+)
+
+// Note Recipient constants
+const (
+	NoteChannelWhatsApp = "whatsapp"
+	NoteChannelEmail    = "email"
+
+	NoteRecipientPending = "pending"
+	NoteRecipientSent    = "sent"
+	NoteRecipientFailed  = "failed"
+)
+
+// Credit Check constants, what happens to an order that would exceed the credit limit
+const (
+	CreditCheckBlock = "block"
+	CreditCheckWarn  = "warn"
+)
+
+// Unit Dimension constants
+const (
+	UnitDimensionCount  = "count"  // Base unit pcs
+	UnitDimensionMass   = "mass"   // Base unit kg
+	UnitDimensionVolume = "volume" // Base unit m3
+	UnitDimensionLength = "length" // Base unit m
+)
+
+// Notification opt-out sources
+const (
+	OptOutSourceAdmin   = "admin"
+	OptOutSourceInbound = "inbound" // The phone replied STOP
 )
 
-// Queue Duration (30 minutes per queue)
+// Queue Duration (30 minutes per queue), the fallback when no queue duration settings apply
 const QueueDurationMinutes = 30
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"bg-go/internal/handlers"
+	"bg-go/internal/lib/breaker"
+	"bg-go/internal/lib/changestream"
 	"bg-go/internal/middleware"
 
 	"github.com/gofiber/fiber/v2"
@@ -26,9 +28,42 @@ func SetupRoutes(app *fiber.App) {
 		})
 	})
 
+	// Circuit breaker states of external dependencies. The endpoint is public, so the
+	// last errors, which may name hosts or credentials, are left to the logs.
+	app.Get("/health/breakers", func(c *fiber.Ctx) error {
+		breakers := breaker.Statuses()
+		for i := range breakers {
+			breakers[i].LastError = ""
+		}
+		changes := changestream.GetStatus()
+		changes.LastError = ""
+
+		return c.JSON(fiber.Map{
+			"status":   "ok",
+			"breakers": breakers,
+			"limits":   middleware.ConcurrencyStatuses(),
+			"changes":  changes,
+		})
+	})
+
+	// Short links sent in messages
+	shortLinkHandler := handlers.NewShortLinkHandler()
+	app.Get("/s/:code", shortLinkHandler.Redirect)
+
+	// Exports share one concurrency limit across route groups
+	exportLimit := middleware.ConcurrencyLimit(middleware.LimitExports)
+
 	// API v1 routes
 	v1 := app.Group("/api/v1")
 
+	// Error code catalog (Public)
+	errorCodeHandler := handlers.NewErrorCodeHandler()
+	v1.Get("/error-codes", errorCodeHandler.List)
+
+	// Build and environment information (Public)
+	metaHandler := handlers.NewMetaHandler()
+	v1.Get("/meta", metaHandler.Get)
+
 	// ============================================
 	// Migration Routes (SUPERADMIN only)
 	// ============================================
@@ -36,13 +71,16 @@ func SetupRoutes(app *fiber.App) {
 	migration.Get("/stats", migrationHandler.GetOrderStats)
 	migration.Post("/cleanup-orders", migrationHandler.CleanupOrders)
 	migration.Post("/reset-orders", migrationHandler.ResetOrders)
+	migration.Get("/integrity", migrationHandler.CheckIntegrity)
+	migration.Post("/integrity/repair", migrationHandler.RepairIntegrity)
 
 	// ============================================
 	// Dashboard Routes (Protected)
 	// ============================================
 	dashboardHandler := handlers.NewDashboardHandler()
-	dashboard := v1.Group("/dashboard", middleware.AuthGuard())
+	dashboard := v1.Group("/dashboard", middleware.AuthGuard(), middleware.ConcurrencyLimit(middleware.LimitDashboard))
 	dashboard.Get("/stats", dashboardHandler.GetStats)
+	dashboard.Get("/trends", dashboardHandler.Trends)
 
 	// ============================================
 	// Auth Routes
@@ -51,17 +89,28 @@ func SetupRoutes(app *fiber.App) {
 	auth.Get("/genesis", authHandler.Genesis)
 	auth.Post("/login", authHandler.Login)
 	auth.Post("/refresh", authHandler.Refresh)
+	auth.Get("/invite/:token", authHandler.GetInvite)
+	auth.Post("/accept-invite/:token", authHandler.AcceptInvite)
 
 	// Protected auth routes
 	authProtected := auth.Group("/", middleware.AuthGuard())
 	authProtected.Get("/me", authHandler.Me)
+	authProtected.Put("/me", authHandler.UpdateMe)
+	authProtected.Post("/me/avatar", authHandler.UploadAvatar)
+	authProtected.Delete("/me/avatar", authHandler.DeleteAvatar)
+	authProtected.Get("/sessions", authHandler.ListSessions)
+	authProtected.Delete("/sessions/:id", authHandler.RevokeSession)
 	authProtected.Get("/users", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.ListUsers)
 	authProtected.Get("/list", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.ListUsers) // Alias for frontend compatibility
 	authProtected.Post("/register", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.Register)
+	authProtected.Post("/invite", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.Invite)
+	authProtected.Post("/invite/:id/resend", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.ResendInvite)
 	authProtected.Put("/users/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.UpdateUser)
 	authProtected.Put("/adjust/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.UpdateUser) // Alias for frontend
 	authProtected.Delete("/users/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.DeleteUser)
 	authProtected.Delete("/takedown/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.DeleteUser) // Alias for frontend
+	authProtected.Post("/impersonate/:id", middleware.RoleGuard("SUPERADMIN"), authHandler.Impersonate)
+	authProtected.Delete("/users/:id/sessions", middleware.RoleGuard("SUPERADMIN", "ADMIN"), authHandler.RevokeUserSessions)
 
 	// ============================================
 	// Sales Routes (Protected)
@@ -69,9 +118,19 @@ func SetupRoutes(app *fiber.App) {
 	salesHandler := handlers.NewSalesHandler()
 	sales := v1.Group("/sales", middleware.AuthGuard())
 	sales.Get("/", salesHandler.List)
+	sales.Post("/import", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.Import)
+	sales.Get("/leaderboard", salesHandler.Leaderboard)
 	sales.Get("/:id", salesHandler.Detail)
+	sales.Get("/:id/targets", salesHandler.Targets)
+	sales.Post("/:id/targets", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.CreateTarget)
+	sales.Put("/:id/targets/:month", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.UpdateTarget)
+	sales.Delete("/:id/targets/:month", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.DeleteTarget)
+	sales.Post("/:id/merge-into/:target", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.Merge)
 	sales.Post("/", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.Create)
 	sales.Put("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.Update)
+	sales.Put("/:id/recipients", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.SetRecipients)
+	sales.Get("/:id/credit", salesHandler.Credit)
+	sales.Put("/:id/credit", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.SetCredit)
 	sales.Delete("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), salesHandler.Delete)
 
 	// ============================================
@@ -93,12 +152,68 @@ func SetupRoutes(app *fiber.App) {
 	orders := v1.Group("/orders", middleware.AuthGuard())
 	orders.Get("/", orderHandler.List)
 	orders.Get("/stats", orderHandler.GetStats)
+	orders.Get("/board", orderHandler.Board)
+	orders.Get("/export/csv", middleware.RoleGuard("SUPERADMIN", "ADMIN"), exportLimit, orderHandler.ExportCSV)
+	orders.Get("/number/:order_number", orderHandler.FindByNumber)
+	orders.Get("/item-suggestions", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.ItemSuggestions)
+	orders.Post("/delivery-fee/quote", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.QuoteDeliveryFee)
+	orders.Post("/price-check", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.CheckPrices)
+	orders.Post("/archive", middleware.RoleGuard("SUPERADMIN"), orderHandler.RunArchive)
+	orders.Post("/merge-loading", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.MergeLoading)
+	orders.Get("/cancel-requests", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.ListCancelRequests)
+	orders.Post("/cancel-requests/:id/review", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.ReviewCancelRequest)
 	orders.Get("/:id", orderHandler.Detail)
 	orders.Post("/", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.Create)
 	orders.Put("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.Update)
 	orders.Delete("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.Delete)
 	orders.Post("/:id/call", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.CallQueue)
+	orders.Post("/:id/move", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.Move)
 	orders.Post("/:id/finish-loading", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.FinishLoading)
+	orders.Post("/:id/split", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.Split)
+	orders.Get("/:id/shipments", orderHandler.Shipments)
+	orders.Post("/:id/driver", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.SubmitDriver)
+	orders.Put("/:id/tags", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.SetTags)
+	orders.Put("/:id/shipment", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.UpdateShipment)
+	orders.Put("/:id/recipients", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.SetRecipients)
+	orders.Put("/:id/items", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.ChangeItems)
+	orders.Delete("/:id/items/pending", middleware.RoleGuard("SUPERADMIN", "ADMIN"), orderHandler.WithdrawChange)
+	orders.Get("/:id/invoice-views", orderHandler.InvoiceViews)
+	orders.Get("/:id/conversation", orderHandler.Conversation)
+	orders.Get("/:id/notes", orderHandler.ListNotes)
+	orders.Post("/:id/notes", orderHandler.AddNote)
+	orders.Delete("/:id/notes/:note_id", orderHandler.DeleteNote)
+
+	// ============================================
+	// Unit Routes (Protected)
+	// ============================================
+	unitHandler := handlers.NewUnitHandler()
+	units := v1.Group("/units", middleware.AuthGuard())
+	units.Get("/", unitHandler.List)
+	units.Get("/check", unitHandler.Check)
+	units.Post("/", middleware.RoleGuard("SUPERADMIN", "ADMIN"), unitHandler.Create)
+	units.Put("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), unitHandler.Update)
+	units.Delete("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), unitHandler.Delete)
+
+	// ============================================
+	// Tag Routes (Protected)
+	// ============================================
+	tagHandler := handlers.NewTagHandler()
+	tags := v1.Group("/tags", middleware.AuthGuard())
+	tags.Get("/", tagHandler.List)
+	tags.Post("/", middleware.RoleGuard("SUPERADMIN", "ADMIN"), tagHandler.Create)
+	tags.Put("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), tagHandler.Update)
+	tags.Delete("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), tagHandler.Delete)
+
+	// ============================================
+	// Saved View Routes (Admin)
+	// ============================================
+	viewHandler := handlers.NewViewHandler()
+	views := v1.Group("/views", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	views.Get("/", viewHandler.List)
+	views.Get("/:id", viewHandler.Detail)
+	views.Post("/", viewHandler.Create)
+	views.Put("/:id", viewHandler.Update)
+	views.Delete("/:id", viewHandler.Delete)
 
 	// ============================================
 	// Payment Routes (Protected)
@@ -107,19 +222,47 @@ func SetupRoutes(app *fiber.App) {
 	payments := v1.Group("/payments", middleware.AuthGuard())
 	payments.Get("/pending", paymentHandler.ListPending)
 	payments.Get("/status/:status", paymentHandler.ListByStatus)
+	payments.Get("/mutations", middleware.RoleGuard("SUPERADMIN", "ADMIN"), paymentHandler.ListMutations)
+	payments.Post("/mutations/import", middleware.RoleGuard("SUPERADMIN", "ADMIN"), paymentHandler.ImportMutations)
 	payments.Post("/:id/verify", middleware.RoleGuard("SUPERADMIN", "ADMIN"), paymentHandler.Verify)
+	payments.Post("/:id/approve", middleware.RoleGuard("SUPERADMIN", "ADMIN"), paymentHandler.Approve)
 	payments.Post("/:id/reject", middleware.RoleGuard("SUPERADMIN", "ADMIN"), paymentHandler.Reject)
+	payments.Post("/:id/claim", middleware.RoleGuard("SUPERADMIN", "ADMIN"), paymentHandler.Claim)
+	payments.Delete("/:id/claim", middleware.RoleGuard("SUPERADMIN", "ADMIN"), paymentHandler.Release)
 
 	// ============================================
 	// Queue Routes (Protected)
 	// ============================================
 	queueHandler := handlers.NewQueueHandler()
-	queue := v1.Group("/queue", middleware.AuthGuard())
+	queue := v1.Group("/queue")
+
+	// Kiosk devices may only scan (live or batched), look up and call the next order; these are registered
+	// before the user guard below so a device token never reaches it
+	queue.Post("/scan", middleware.KioskGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN", "DEVICE"), queueHandler.Scan)
+	queue.Post("/scan/batch", middleware.KioskGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN", "DEVICE"), queueHandler.ScanBatch)
+	queue.Post("/call-next", middleware.KioskGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN", "DEVICE"), queueHandler.CallNext)
+	queue.Get("/lookup", middleware.KioskGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN", "DEVICE"), queueHandler.Lookup)
+
+	queue.Use(middleware.AuthGuard())
 	queue.Get("/", queueHandler.List)
 	queue.Get("/estimate", queueHandler.GetEstimate)
 	queue.Get("/current", queueHandler.GetCurrent)
-	queue.Post("/scan", middleware.RoleGuard("SUPERADMIN", "ADMIN"), queueHandler.Scan)
-	queue.Post("/call-next", middleware.RoleGuard("SUPERADMIN", "ADMIN"), queueHandler.CallNext)
+	queue.Get("/history", queueHandler.History)
+	queue.Get("/history/:date", queueHandler.HistoryDay)
+	queue.Get("/:id/ticket", queueHandler.Ticket)
+	queue.Post("/walk-in", middleware.RoleGuard("SUPERADMIN", "ADMIN"), queueHandler.WalkIn)
+
+	// ============================================
+	// Device Routes (Admin)
+	// ============================================
+	deviceHandler := handlers.NewDeviceHandler()
+	devices := v1.Group("/devices", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	devices.Get("/", deviceHandler.List)
+	devices.Post("/", deviceHandler.Create)
+	devices.Put("/:id", deviceHandler.Update)
+	devices.Post("/:id/token", deviceHandler.RotateToken)
+	devices.Delete("/:id", deviceHandler.Delete)
+	devices.Get("/:id/scans", deviceHandler.Scans)
 
 	// ============================================
 	// Delivery Routes (Protected)
@@ -128,25 +271,104 @@ func SetupRoutes(app *fiber.App) {
 	delivery := v1.Group("/delivery", middleware.AuthGuard())
 	delivery.Get("/", deliveryHandler.List)
 	delivery.Get("/ready", deliveryHandler.ListReady)
+	delivery.Get("/export", middleware.RoleGuard("SUPERADMIN", "ADMIN"), exportLimit, deliveryHandler.Export)
+	delivery.Get("/export/csv", middleware.RoleGuard("SUPERADMIN", "ADMIN"), exportLimit, deliveryHandler.ExportCSV)
+	delivery.Get("/export/jobs/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), deliveryHandler.ExportStatus)
+	delivery.Get("/export/jobs/:id/download", middleware.RoleGuard("SUPERADMIN", "ADMIN"), deliveryHandler.ExportDownload)
 	delivery.Get("/:id", deliveryHandler.Detail)
+	delivery.Get("/:id/revisions", deliveryHandler.Revisions)
+	delivery.Get("/:id/recipients", deliveryHandler.Recipients)
+	delivery.Post("/:id/send", middleware.RoleGuard("SUPERADMIN", "ADMIN"), deliveryHandler.SendToRecipients)
 	delivery.Get("/order/:order_id", deliveryHandler.GetByOrder)
 	delivery.Post("/", middleware.RoleGuard("SUPERADMIN", "ADMIN"), deliveryHandler.Create)
+	delivery.Put("/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), deliveryHandler.Amend)
+
+	// ============================================
+	// Returns Routes (Protected)
+	// ============================================
+	returnHandler := handlers.NewReturnHandler()
+	returns := v1.Group("/returns", middleware.AuthGuard())
+	returns.Get("/", returnHandler.List)
+	returns.Get("/report", returnHandler.Report)
+	returns.Get("/:id", returnHandler.Detail)
+	returns.Post("/:id/review", middleware.RoleGuard("SUPERADMIN", "ADMIN"), returnHandler.Review)
+	returns.Post("/:id/resolve", middleware.RoleGuard("SUPERADMIN", "ADMIN"), returnHandler.Resolve)
+
+	// ============================================
+	// Returnable Asset Routes (Protected)
+	// ============================================
+	assetHandler := handlers.NewAssetHandler()
+	assets := v1.Group("/assets", middleware.AuthGuard())
+	assets.Get("/ledger", assetHandler.Ledger)
+	assets.Get("/outstanding", assetHandler.Outstanding)
+	assets.Post("/lend", middleware.RoleGuard("SUPERADMIN", "ADMIN"), assetHandler.Lend)
+	assets.Post("/returns", middleware.RoleGuard("SUPERADMIN", "ADMIN"), assetHandler.Return)
+
+	// ============================================
+	// Report Routes (Protected)
+	// ============================================
+	reportHandler := handlers.NewReportHandler()
+	reports := v1.Group("/reports", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"), middleware.ConcurrencyLimit(middleware.LimitReports))
+	reports.Get("/funnel", reportHandler.Funnel)
+	reports.Get("/queue-heatmap", reportHandler.QueueHeatmap)
+	reports.Get("/user-activity", reportHandler.UserActivity)
+	reports.Get("/price-overrides", reportHandler.PriceOverrides)
+	reports.Get("/shipped-quantities", reportHandler.ShippedQuantities)
+	reports.Get("/receivables-aging", reportHandler.ReceivablesAging)
+	reports.Get("/numbering-gaps", reportHandler.NumberingGaps)
+	reports.Post("/numbering-gaps/voids", reportHandler.VoidNumber)
+	reports.Post("/preview", reportHandler.Preview)
+	reports.Get("/saved", reportHandler.ListSaved)
+	reports.Post("/saved", reportHandler.CreateSaved)
+	reports.Get("/saved/:id", reportHandler.GetSaved)
+	reports.Put("/saved/:id", reportHandler.UpdateSaved)
+	reports.Delete("/saved/:id", reportHandler.DeleteSaved)
+	reports.Post("/saved/:id/run", reportHandler.RunSaved)
+	reports.Get("/saved/:id/runs", reportHandler.ListRuns)
+	reports.Get("/saved/:id/runs/:run_id/csv", reportHandler.RunCSV)
+
+	// ============================================
+	// Customer Satisfaction Routes
+	// ============================================
+	csatHandler := handlers.NewCSATHandler()
+	csatRoutes := v1.Group("/csat", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	csatRoutes.Get("/summary", csatHandler.Summary)
+	csatRoutes.Get("/responses", csatHandler.Responses)
+	csatRoutes.Post("/responses/:id/follow-up", csatHandler.FollowUp)
+
+	// ============================================
+	// Request Log Routes (Support)
+	// ============================================
+	requestLogHandler := handlers.NewRequestLogHandler()
+	requestLogs := v1.Group("/request-logs", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	requestLogs.Get("/", requestLogHandler.Search)
+	requestLogs.Get("/submissions", requestLogHandler.Submissions)
+	requestLogs.Post("/submissions/:id/replay", requestLogHandler.ReplaySubmission)
 
 	// ============================================
 	// Client Routes (Public with Token)
 	// ============================================
 	clientHandler := handlers.NewClientHandler()
-	client := v1.Group("/client")
+	client := v1.Group("/client", middleware.ClientJournal())
 
 	// Invoice
 	client.Get("/invoice/:token", clientHandler.GetInvoice)
+	client.Post("/confirm/:token", clientHandler.Confirm)
+	client.Post("/changes/:token", clientHandler.ConfirmChange)
+	client.Post("/cancel-request/:token", clientHandler.RequestCancel)
+
+	// Lost invoice link, verified by a code sent to the sales rep's phone
+	client.Post("/link/request", clientHandler.RequestLinkCode)
+	client.Post("/link/resend", clientHandler.ResendLink)
 
 	// Payment
 	client.Post("/payment/:token", clientHandler.UploadPayment)
 
 	// Driver
 	client.Post("/driver/:token", clientHandler.SubmitDriver)
+	client.Post("/driver/:token/code", clientHandler.RequestDriverCode)
 	client.Post("/driver/:token/photo", clientHandler.UploadVehiclePhoto)
+	client.Post("/driver/:token/location", clientHandler.PingLocation)
 
 	// Queue
 	client.Get("/queue/:token", clientHandler.GetQueueStatus)
@@ -154,12 +376,65 @@ func SetupRoutes(app *fiber.App) {
 	// Delivery
 	client.Get("/delivery/:token", clientHandler.GetDeliveryNote)
 
+	// Returns / Complaints (by delivery token)
+	client.Get("/returns/:token", returnHandler.ListByToken)
+	client.Post("/returns/:token", returnHandler.Submit)
+
+	// Satisfaction survey (rating link sent after completion)
+	client.Get("/survey/:token", csatHandler.GetSurvey)
+	client.Post("/survey/:token", csatHandler.SubmitSurvey)
+
 	// Order Status (for polling)
 	client.Get("/status/:token", clientHandler.GetOrderStatus)
 
 	// Client Settings (public)
 	client.Get("/settings", settingsHandler.GetPublic)
 
+	// ============================================
+	// Media Routes (Public with a signed, expiring link)
+	// ============================================
+	mediaHandler := handlers.NewMediaHandler()
+	v1.Get("/media/orders/:id/:kind", mediaHandler.OrderImage)
+
+	// ============================================
+	// Upload Routes (Protected)
+	// ============================================
+	uploadHandler := handlers.NewUploadHandler()
+	uploads := v1.Group("/uploads", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	uploads.Get("/", uploadHandler.List)
+	uploads.Get("/categories", uploadHandler.Categories)
+	uploads.Post("/orphans/cleanup", middleware.RoleGuard("SUPERADMIN"), uploadHandler.CleanupOrphans)
+
+	// ============================================
+	// Event Routes (Protected)
+	// ============================================
+	eventHandler := handlers.NewEventHandler()
+	events := v1.Group("/events", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	events.Get("/", eventHandler.List)
+
+	// ============================================
+	// Presence Routes (Protected, admin heartbeats and payment queue coverage)
+	// ============================================
+	presenceHandler := handlers.NewPresenceHandler()
+	presenceGroup := v1.Group("/presence", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	presenceGroup.Get("/", presenceHandler.List)
+	presenceGroup.Post("/heartbeat", presenceHandler.Heartbeat)
+	presenceGroup.Get("/coverage", presenceHandler.Coverage)
+
+	// ============================================
+	// Integration Routes (Protected, Zapier/Make polling triggers and REST hooks)
+	// ============================================
+	integrationHandler := handlers.NewIntegrationHandler()
+	// Called by Cloudinary and verified by its signature, so registered ahead of the guarded group
+	v1.Post("/integrations/cloudinary/webhook", integrationHandler.CloudinaryWebhook)
+	integrations := v1.Group("/integrations", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	integrations.Get("/triggers", integrationHandler.Triggers)
+	integrations.Get("/orders", integrationHandler.Orders)
+	integrations.Get("/payments", integrationHandler.Payments)
+	integrations.Get("/hooks", integrationHandler.ListHooks)
+	integrations.Post("/hooks", integrationHandler.Subscribe)
+	integrations.Delete("/hooks/:id", integrationHandler.Unsubscribe)
+
 	// ============================================
 	// Notification Routes (Protected)
 	// ============================================
@@ -168,15 +443,63 @@ func SetupRoutes(app *fiber.App) {
 	notifications.Get("/", notificationHandler.List)
 	notifications.Get("/pending", notificationHandler.GetPending)
 	notifications.Get("/stats", notificationHandler.GetStats)
+	notifications.Get("/failed", notificationHandler.GetFailed)
+	notifications.Get("/suppressed", notificationHandler.GetSuppressed)
+	notifications.Get("/search", middleware.RoleGuard("SUPERADMIN"), notificationHandler.Search)
+	notifications.Get("/search/export", middleware.RoleGuard("SUPERADMIN"), exportLimit, notificationHandler.ExportSearch)
+	notifications.Get("/broadcast/preview", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.PreviewBroadcast)
+	notifications.Post("/broadcast", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.Broadcast)
+	notifications.Get("/broadcasts", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.ListNotificationBroadcasts)
+	notifications.Get("/broadcasts/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.NotificationBroadcastDetail)
+	notifications.Delete("/broadcasts/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.CancelNotificationBroadcast)
+	notifications.Get("/opt-outs", notificationHandler.ListOptOuts)
+	notifications.Post("/opt-outs", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.AddOptOut)
+	notifications.Delete("/opt-outs/:phone", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.RemoveOptOut)
+	notifications.Get("/sandbox", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.SandboxOutbox)
+	notifications.Get("/sandbox/status", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.SandboxStatus)
+	notifications.Delete("/sandbox", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.ClearSandbox)
+	notifications.Post("/retry", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.RetryFailed)
 	notifications.Post("/:id/sent", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.MarkAsSent)
 	notifications.Post("/send", middleware.RoleGuard("SUPERADMIN", "ADMIN"), notificationHandler.SendManual)
 
+	// ============================================
+	// Template Routes (Protected)
+	// ============================================
+	templateHandler := handlers.NewTemplateHandler()
+	templates := v1.Group("/templates", middleware.AuthGuard(), middleware.RoleGuard("SUPERADMIN", "ADMIN"))
+	templates.Post("/preview", templateHandler.Preview)
+
 	// ============================================
 	// Settings Routes (Protected)
 	// ============================================
 	settings := v1.Group("/settings", middleware.AuthGuard())
 	settings.Get("/", settingsHandler.Get)
 	settings.Put("/", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.Update)
+	settings.Get("/history", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.GetHistory)
+	settings.Post("/history/:version/revert", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.RevertSettings)
+	settings.Get("/notifications", settingsHandler.GetNotificationRules)
+	settings.Put("/notifications/:status", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdateNotificationRule)
+	settings.Get("/payment-reminders", settingsHandler.GetReminderPolicy)
+	settings.Put("/payment-reminders", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdateReminderPolicy)
+	settings.Get("/overdue", settingsHandler.GetOverduePolicy)
+	settings.Put("/overdue", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdateOverduePolicy)
+	settings.Get("/alerts", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.GetAlertPolicy)
+	settings.Put("/alerts", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdateAlertPolicy)
+	settings.Post("/alerts/test", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.TestAlert)
+	settings.Get("/sla", settingsHandler.GetSLAPolicy)
+	settings.Put("/sla", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdateSLAPolicy)
+	settings.Get("/payment-approval", settingsHandler.GetApprovalPolicy)
+	settings.Put("/payment-approval", middleware.RoleGuard("SUPERADMIN"), settingsHandler.UpdateApprovalPolicy)
+	settings.Get("/delivery-fee", settingsHandler.GetDeliveryFeePolicy)
+	settings.Put("/delivery-fee", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdateDeliveryFeePolicy)
+	settings.Get("/price-check", settingsHandler.GetPriceCheckPolicy)
+	settings.Put("/price-check", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdatePriceCheckPolicy)
+	settings.Get("/queue-durations", settingsHandler.GetQueueDurations)
+	settings.Put("/queue-durations", middleware.RoleGuard("SUPERADMIN", "ADMIN"), settingsHandler.UpdateQueueDurations)
+	settings.Get("/retention", middleware.RoleGuard("SUPERADMIN"), settingsHandler.GetRetention)
+	settings.Put("/retention", middleware.RoleGuard("SUPERADMIN"), settingsHandler.UpdateRetention)
+	settings.Get("/retention/preview", middleware.RoleGuard("SUPERADMIN"), settingsHandler.PreviewRetention)
+	settings.Post("/retention/run", middleware.RoleGuard("SUPERADMIN"), settingsHandler.RunRetention)
 
 	// ============================================
 	// WhatsApp Routes (Protected)
@@ -188,6 +511,15 @@ func SetupRoutes(app *fiber.App) {
 	whatsapp.Post("/disconnect", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.Disconnect)
 	whatsapp.Post("/logout", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.Logout)
 	whatsapp.Post("/restart", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.Restart)
+	whatsapp.Get("/session/export", middleware.RoleGuard("SUPERADMIN"), whatsAppHandler.ExportSession)
+	whatsapp.Post("/session/import", middleware.RoleGuard("SUPERADMIN"), whatsAppHandler.ImportSession)
 	whatsapp.Post("/send", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.SendMessage)
 	whatsapp.Post("/test", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.SendTestMessage)
+	whatsapp.Get("/groups", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.Groups)
+	whatsapp.Post("/broadcast", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.Broadcast)
+	whatsapp.Get("/broadcasts", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.ListBroadcasts)
+	whatsapp.Get("/broadcasts/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.BroadcastDetail)
+	whatsapp.Post("/schedule", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.Schedule)
+	whatsapp.Get("/schedule", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.ListScheduled)
+	whatsapp.Delete("/schedule/:id", middleware.RoleGuard("SUPERADMIN", "ADMIN"), whatsAppHandler.CancelScheduled)
 }
@@ -0,0 +1,285 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bg-go/internal/lib/cloudinary"
+	"bg-go/internal/lib/whatsapp"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+)
+
+// FakeStorage stores uploads in memory instead of on Cloudinary
+type FakeStorage struct {
+	mu        sync.Mutex
+	uploads   []string
+	contents  map[string][]byte
+	assets    []cloudinary.Asset
+	destroyed []string
+	err       error
+}
+
+// Upload records the file and returns a fake CDN URL for it, shaped like a Cloudinary one
+func (s *FakeStorage) Upload(content []byte, filename string, category string) (*cloudinary.UploadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	publicID := fmt.Sprintf("%s/%d-%s", category, len(s.uploads)+1, filename)
+	s.uploads = append(s.uploads, publicID)
+	if s.contents == nil {
+		s.contents = map[string][]byte{}
+	}
+	s.contents[publicID] = content
+	resourceType := "image"
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pdf", ".doc", ".docx":
+		resourceType = "raw"
+	}
+	url := "https://cdn.test/" + resourceType + "/upload/" + publicID
+	s.assets = append(s.assets, cloudinary.Asset{
+		PublicID:     publicID,
+		URL:          url,
+		ResourceType: resourceType,
+		Bytes:        len(content),
+		Tags:         []string{category},
+		CreatedAt:    time.Now(),
+	})
+	return &cloudinary.UploadResult{
+		URL:          url,
+		PublicID:     publicID,
+		ResourceType: resourceType,
+	}, nil
+}
+
+// AddAsset stores a file on the fake CDN as if it had been uploaded outside the app
+func (s *FakeStorage) AddAsset(asset cloudinary.Asset) {
+	s.mu.Lock()
+	s.assets = append(s.assets, asset)
+	s.mu.Unlock()
+}
+
+// ListByTag pages through the stored images with the tag; the cursor is the offset
+func (s *FakeStorage) ListByTag(ctx context.Context, tag string, cursor string, limit int) ([]cloudinary.Asset, string, error) {
+	return s.list(cursor, limit, func(asset cloudinary.Asset) bool {
+		return asset.ResourceType == string(api.Image) && slices.Contains(asset.Tags, tag)
+	})
+}
+
+// ListFolder pages through the stored files of a resource type; the cursor is the offset
+func (s *FakeStorage) ListFolder(ctx context.Context, resourceType api.AssetType, cursor string, limit int) ([]cloudinary.Asset, string, error) {
+	return s.list(cursor, limit, func(asset cloudinary.Asset) bool {
+		return asset.ResourceType == string(resourceType)
+	})
+}
+
+// list returns a page of the stored, not deleted files that match
+func (s *FakeStorage) list(cursor string, limit int, match func(cloudinary.Asset) bool) ([]cloudinary.Asset, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, "", s.err
+	}
+	matched := []cloudinary.Asset{}
+	for _, asset := range s.assets {
+		if match(asset) && !slices.Contains(s.destroyed, asset.PublicID) {
+			matched = append(matched, asset)
+		}
+	}
+	offset, _ := strconv.Atoi(cursor)
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := min(offset+limit, len(matched))
+	next := ""
+	if end < len(matched) {
+		next = strconv.Itoa(end)
+	}
+	return matched[offset:end], next, nil
+}
+
+// DestroyAsset records the deleted file
+func (s *FakeStorage) DestroyAsset(ctx context.Context, asset cloudinary.Asset) error {
+	return s.Destroy(asset.PublicID)
+}
+
+// Destroy records the deleted file
+func (s *FakeStorage) Destroy(publicID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.destroyed = append(s.destroyed, publicID)
+	return nil
+}
+
+// Reset forgets the recorded files and clears a failure
+func (s *FakeStorage) Reset() {
+	s.mu.Lock()
+	s.uploads, s.contents, s.assets, s.destroyed, s.err = nil, nil, nil, nil, nil
+	s.mu.Unlock()
+}
+
+// Fail makes the next uploads and deletes fail with err, nil makes them succeed again
+func (s *FakeStorage) Fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// Uploads returns the public IDs of the uploaded files
+func (s *FakeStorage) Uploads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.uploads...)
+}
+
+// Content returns the stored bytes of an uploaded file
+func (s *FakeStorage) Content(publicID string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.contents[publicID]
+}
+
+// Destroyed returns the public IDs of the deleted files
+func (s *FakeStorage) Destroyed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.destroyed...)
+}
+
+// Message is a WhatsApp message captured by FakeWhatsApp
+type Message struct {
+	Phone string
+	Text  string
+}
+
+// FakeWhatsApp captures WhatsApp messages instead of sending them. It starts logged in.
+// Messages to a group are captured with the group JID as the phone.
+type FakeWhatsApp struct {
+	mu        sync.Mutex
+	loggedOut bool
+	messages  []Message
+	groups    []whatsapp.Group
+	err       error
+	delay     time.Duration
+}
+
+// Name identifies the fake as a provider
+func (w *FakeWhatsApp) Name() string {
+	return "fake"
+}
+
+// IsLoggedIn reports whether messages are accepted
+func (w *FakeWhatsApp) IsLoggedIn() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.loggedOut
+}
+
+// SendMessage captures a message
+func (w *FakeWhatsApp) SendMessage(phone string, message string) error {
+	w.mu.Lock()
+	delay := w.delay
+	w.mu.Unlock()
+	time.Sleep(delay)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, Message{Phone: phone, Text: message})
+	return nil
+}
+
+// JoinedGroups returns the groups set with SetGroups
+func (w *FakeWhatsApp) JoinedGroups() ([]whatsapp.Group, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]whatsapp.Group(nil), w.groups...), nil
+}
+
+// SendGroupMessage captures a message to a joined group
+func (w *FakeWhatsApp) SendGroupMessage(groupJID string, message string) error {
+	w.mu.Lock()
+	joined := false
+	for _, group := range w.groups {
+		joined = joined || group.JID == groupJID
+	}
+	w.mu.Unlock()
+
+	if !joined {
+		return fmt.Errorf("not a member of %s", groupJID)
+	}
+	return w.SendMessage(groupJID, message)
+}
+
+// Reset logs the fake session back in, forgets the messages and groups and clears a failure
+func (w *FakeWhatsApp) Reset() {
+	w.mu.Lock()
+	w.loggedOut, w.messages, w.groups, w.err, w.delay = false, nil, nil, nil, 0
+	w.mu.Unlock()
+}
+
+// SetGroups sets the groups the fake account participates in
+func (w *FakeWhatsApp) SetGroups(groups ...whatsapp.Group) {
+	w.mu.Lock()
+	w.groups = groups
+	w.mu.Unlock()
+}
+
+// SetDelay makes every message take d to send, so concurrent senders overlap
+func (w *FakeWhatsApp) SetDelay(d time.Duration) {
+	w.mu.Lock()
+	w.delay = d
+	w.mu.Unlock()
+}
+
+// SetLoggedIn logs the fake session in or out
+func (w *FakeWhatsApp) SetLoggedIn(loggedIn bool) {
+	w.mu.Lock()
+	w.loggedOut = !loggedIn
+	w.mu.Unlock()
+}
+
+// Fail makes the next messages fail with err, nil makes them succeed again
+func (w *FakeWhatsApp) Fail(err error) {
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+}
+
+// Messages returns the captured messages
+func (w *FakeWhatsApp) Messages() []Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Message(nil), w.messages...)
+}
+
+// MessagesTo returns the captured messages sent to a phone
+func (w *FakeWhatsApp) MessagesTo(phone string) []Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	messages := []Message{}
+	for _, m := range w.messages {
+		if m.Phone == phone {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,242 @@
+// Package testutil runs the API against an in-memory MongoDB with fake Cloudinary and
+// WhatsApp implementations, for handler tests that need no external services. Tests using
+// it must not run in parallel, they share one database.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"bg-go/internal/config"
+	"bg-go/internal/database"
+	"bg-go/internal/lib/file"
+	"bg-go/internal/lib/jwt"
+	"bg-go/internal/lib/notification"
+	"bg-go/internal/lib/response"
+	"bg-go/internal/lib/session"
+	"bg-go/internal/lib/whatsapp"
+	"bg-go/internal/middleware"
+	"bg-go/internal/routes"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Harness is the API wired to an in-memory database and fake external services
+type Harness struct {
+	T        testing.TB
+	App      *fiber.App
+	Mongo    *MemoryMongo
+	Storage  *FakeStorage
+	WhatsApp *FakeWhatsApp
+}
+
+// Response is a decoded API response
+type Response struct {
+	Status int
+	Body   map[string]interface{}
+	Raw    []byte
+}
+
+// shared is the state every harness of a test binary uses. Handlers leave notifications
+// running in goroutines after they respond, so the database and the fakes are set up
+// once and only emptied between tests, never swapped while those goroutines run.
+var shared struct {
+	once     sync.Once
+	mongo    *MemoryMongo
+	storage  *FakeStorage
+	whatsapp *FakeWhatsApp
+	err      error
+}
+
+// setup loads the configuration and connects the API to the in-memory database and fakes
+func setup() error {
+	config.Load()
+
+	shared.mongo = NewMemoryMongo()
+	shared.storage = &FakeStorage{}
+	shared.whatsapp = &FakeWhatsApp{}
+
+	opts := options.Client().SetServerSelectionTimeout(time.Second)
+	opts.Deployment = shared.mongo
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	database.DBInstance = &database.DB{Mongo: client, MongoDB: client.Database("LabaLaba")}
+
+	file.SetStorage(shared.storage)
+	notification.SetSender(shared.whatsapp)
+	whatsapp.SetGroupSender(shared.whatsapp)
+	notification.Init(config.Cfg.Client.URL)
+	return nil
+}
+
+// New starts the API on an empty in-memory database with fresh fakes
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	shared.once.Do(func() { shared.err = setup() })
+	if shared.err != nil {
+		t.Fatalf("connect to in-memory mongo: %v", shared.err)
+	}
+	shared.mongo.Reset()
+	shared.storage.Reset()
+	shared.whatsapp.Reset()
+
+	h := &Harness{
+		T:        t,
+		Mongo:    shared.mongo,
+		Storage:  shared.storage,
+		WhatsApp: shared.whatsapp,
+	}
+	h.App = fiber.New(fiber.Config{ErrorHandler: response.ErrorHandler})
+	h.App.Use(middleware.RequestContext())
+	routes.SetupRoutes(h.App)
+	return h
+}
+
+// Token returns an access token for a new user with a role
+func (h *Harness) Token(role string) string {
+	return h.TokenFor(primitive.NewObjectID().Hex(), role)
+}
+
+// TokenFor returns an access token for a user
+func (h *Harness) TokenFor(userID string, role string) string {
+	h.T.Helper()
+
+	sess, err := session.Create(userID, "127.0.0.1", "testutil")
+	if err != nil {
+		h.T.Fatalf("create session: %v", err)
+	}
+	token, err := jwt.GenerateAccessToken(userID, role, sess.ID.Hex())
+	if err != nil {
+		h.T.Fatalf("generate token: %v", err)
+	}
+	return token
+}
+
+// Request sends a request with a JSON body, authenticated when token is not empty
+func (h *Harness) Request(method string, path string, body interface{}, token string) *Response {
+	h.T.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			h.T.Fatalf("encode request body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return h.send(req, token)
+}
+
+// Upload posts a file as a multipart form field, authenticated when token is not empty
+func (h *Harness) Upload(path string, field string, filename string, content []byte, token string) *Response {
+	h.T.Helper()
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile(field, filename)
+	if err != nil {
+		h.T.Fatalf("create form file: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest("POST", path, &buf)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return h.send(req, token)
+}
+
+// Send runs a prepared request unauthenticated, for requests that need their own
+// headers such as signed webhook calls
+func (h *Harness) Send(req *http.Request) *Response {
+	h.T.Helper()
+	return h.send(req, "")
+}
+
+// send runs a request through the app and decodes the response
+func (h *Harness) send(req *http.Request, token string) *Response {
+	h.T.Helper()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := h.App.Test(req, -1)
+	if err != nil {
+		h.T.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	result := &Response{Status: resp.StatusCode, Raw: raw}
+	json.Unmarshal(raw, &result.Body)
+	return result
+}
+
+// Data returns the data object of a response
+func (r *Response) Data() map[string]interface{} {
+	data, _ := r.Body["data"].(map[string]interface{})
+	return data
+}
+
+// ErrorCode returns the error code of a response
+func (r *Response) ErrorCode() response.Code {
+	code, _ := r.Body["error_code"].(string)
+	return response.Code(code)
+}
+
+// Insert stores documents in a collection
+func (h *Harness) Insert(collection string, docs ...interface{}) {
+	h.T.Helper()
+
+	if _, err := database.GetMongoCollection(collection).InsertMany(context.Background(), docs); err != nil {
+		h.T.Fatalf("insert into %s: %v", collection, err)
+	}
+}
+
+// Find decodes the first document of a collection matching a filter, failing the test
+// when there is none
+func (h *Harness) Find(collection string, filter bson.M, out interface{}) {
+	h.T.Helper()
+
+	if err := database.GetMongoCollection(collection).FindOne(context.Background(), filter).Decode(out); err != nil {
+		h.T.Fatalf("find in %s %v: %v", collection, filter, err)
+	}
+}
+
+// Count returns how many documents of a collection match a filter
+func (h *Harness) Count(collection string, filter bson.M) int {
+	return len(h.Mongo.Docs(collection, filter))
+}
+
+// Eventually waits up to two seconds for a condition set by background work
+func (h *Harness) Eventually(condition func() bool, format string, args ...interface{}) {
+	h.T.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.T.Fatalf(format, args...)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
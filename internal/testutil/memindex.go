@@ -0,0 +1,100 @@
+package testutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// uniqueIndex is a unique index created on a collection; the memory deployment enforces
+// unique indexes so tests see the duplicate key errors a server returns. Other indexes
+// are accepted and ignored.
+type uniqueIndex struct {
+	name    string
+	keys    []string
+	partial bson.M
+}
+
+// createIndexes records the unique indexes of a createIndexes command
+func (m *MemoryMongo) createIndexes(collection string, args bson.M) bson.D {
+	for _, raw := range asArray(args["indexes"]) {
+		spec := toM(raw)
+		if !truthy(spec["unique"]) {
+			continue
+		}
+		keys := []string{}
+		for key := range toM(spec["key"]) {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		name, _ := spec["name"].(string)
+
+		index := uniqueIndex{name: name, keys: keys, partial: toM(spec["partialFilterExpression"])}
+		replaced := false
+		for i, existing := range m.indexes[collection] {
+			if existing.name == name {
+				m.indexes[collection][i] = index
+				replaced = true
+			}
+		}
+		if !replaced {
+			m.indexes[collection] = append(m.indexes[collection], index)
+		}
+	}
+	return bson.D{{Key: "ok", Value: 1}}
+}
+
+// uniqueViolation returns the duplicate key error of the first unique index doc
+// violates, or nil. Documents outside an index's partial filter are not indexed.
+func (m *MemoryMongo) uniqueViolation(collection string, doc bson.M, index int) bson.D {
+	for _, unique := range m.indexes[collection] {
+		if unique.partial != nil && !matches(doc, unique.partial) {
+			continue
+		}
+		for _, other := range m.collections[collection] {
+			if equal(other["_id"], doc["_id"]) || (unique.partial != nil && !matches(other, unique.partial)) {
+				continue
+			}
+			same := true
+			for _, key := range unique.keys {
+				a, _ := getValue(doc, key)
+				b, _ := getValue(other, key)
+				if !equal(a, b) && !(a == nil && b == nil) {
+					same = false
+					break
+				}
+			}
+			if same {
+				return duplicateIndexKey(index, collection, unique, doc)
+			}
+		}
+	}
+	return nil
+}
+
+// duplicateIndexKey is the write error of a unique index violation, in the server's format
+func duplicateIndexKey(index int, collection string, unique uniqueIndex, doc bson.M) bson.D {
+	values := []string{}
+	for _, key := range unique.keys {
+		value, _ := getValue(doc, key)
+		values = append(values, fmt.Sprintf("%s: %q", key, fmt.Sprint(value)))
+	}
+	return bson.D{
+		{Key: "index", Value: int32(index)},
+		{Key: "code", Value: int32(11000)},
+		{Key: "errmsg", Value: fmt.Sprintf("E11000 duplicate key error collection: test.%s index: %s dup key: { %s }",
+			collection, unique.name, strings.Join(values, ", "))},
+	}
+}
+
+// restore puts the fields of before back into doc, undoing an update in place
+func restore(doc bson.M, before bson.M) {
+	for k := range doc {
+		delete(doc, k)
+	}
+	for k, v := range before {
+		doc[k] = v
+	}
+}
@@ -0,0 +1,1099 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+const memAddress = address.Address("memory:27017")
+
+var sessionTimeout int64 = 30
+
+// MemoryMongo is an in-memory MongoDB deployment for tests. The driver talks to it as
+// to a standalone server; it answers the commands the handlers use (find, insert,
+// update, delete, findAndModify, count, distinct and simple aggregations) from
+// documents kept in memory. Unsupported commands fail like a server error would.
+type MemoryMongo struct {
+	mu          sync.Mutex
+	collections map[string][]bson.M
+	indexes     map[string][]uniqueIndex
+	updates     chan description.Topology
+	queries     int64
+	failing     map[string]bool
+}
+
+var (
+	_ driver.Deployment   = &MemoryMongo{}
+	_ driver.Server       = &MemoryMongo{}
+	_ driver.Connector    = &MemoryMongo{}
+	_ driver.Disconnector = &MemoryMongo{}
+	_ driver.Subscriber   = &MemoryMongo{}
+)
+
+// NewMemoryMongo returns an empty in-memory deployment
+func NewMemoryMongo() *MemoryMongo {
+	return &MemoryMongo{collections: map[string][]bson.M{}, indexes: map[string][]uniqueIndex{}}
+}
+
+// Reset drops every collection and index
+func (m *MemoryMongo) Reset() {
+	m.mu.Lock()
+	m.collections = map[string][]bson.M{}
+	m.indexes = map[string][]uniqueIndex{}
+	m.failing = nil
+	m.mu.Unlock()
+}
+
+// Fail makes every read and write of the collections fail like an unreachable server
+// until the next Reset, so tests can check that handlers fail closed
+func (m *MemoryMongo) Fail(collections ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failing == nil {
+		m.failing = map[string]bool{}
+	}
+	for _, collection := range collections {
+		m.failing[collection] = true
+	}
+}
+
+// Recover undoes Fail for the collections, keeping their documents
+func (m *MemoryMongo) Recover(collections ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, collection := range collections {
+		delete(m.failing, collection)
+	}
+}
+
+// Queries returns how many reads and writes were run, not counting handshakes and other
+// server commands. Benchmarks use it to report database round trips per request.
+func (m *MemoryMongo) Queries() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queries
+}
+
+// Docs returns copies of the documents of a collection matching a filter, in insertion
+// order
+func (m *MemoryMongo) Docs(collection string, filter bson.M) []bson.M {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	filter = toM(filter)
+	docs := []bson.M{}
+	for _, doc := range m.collections[collection] {
+		if matches(doc, filter) {
+			docs = append(docs, copyDoc(doc))
+		}
+	}
+	return docs
+}
+
+// SelectServer returns the deployment itself, it is its only server
+func (m *MemoryMongo) SelectServer(context.Context, description.ServerSelector) (driver.Server, error) {
+	return m, nil
+}
+
+// Kind reports a single server topology
+func (m *MemoryMongo) Kind() description.TopologyKind {
+	return description.Single
+}
+
+// Connection returns a new connection to the in-memory server
+func (m *MemoryMongo) Connection(context.Context) (driver.Connection, error) {
+	return &memConnection{mongo: m}, nil
+}
+
+// RTTMonitor returns a monitor that always reports zero round-trip times
+func (m *MemoryMongo) RTTMonitor() driver.RTTMonitor {
+	return zeroRTT{}
+}
+
+// Connect is a no-op
+func (m *MemoryMongo) Connect() error {
+	return nil
+}
+
+// Disconnect is a no-op
+func (m *MemoryMongo) Disconnect(context.Context) error {
+	return nil
+}
+
+// Subscribe returns the fixed topology of the deployment
+func (m *MemoryMongo) Subscribe() (*driver.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.updates == nil {
+		m.updates = make(chan description.Topology, 1)
+		m.updates <- description.Topology{SessionTimeoutMinutesPtr: &sessionTimeout}
+	}
+	return &driver.Subscription{Updates: m.updates}, nil
+}
+
+// Unsubscribe is a no-op
+func (m *MemoryMongo) Unsubscribe(*driver.Subscription) error {
+	return nil
+}
+
+type zeroRTT struct{}
+
+func (zeroRTT) EWMA() time.Duration { return 0 }
+func (zeroRTT) Min() time.Duration  { return 0 }
+func (zeroRTT) P90() time.Duration  { return 0 }
+func (zeroRTT) Stats() string       { return "" }
+
+// memConnection runs each written command and keeps its reply for the next read
+type memConnection struct {
+	mongo *MemoryMongo
+	reply []byte
+}
+
+var _ driver.Connection = &memConnection{}
+
+func (c *memConnection) WriteWireMessage(_ context.Context, wm []byte) error {
+	_, requestID, _, opcode, rem, ok := wiremessage.ReadHeader(wm)
+	if !ok || opcode != wiremessage.OpMsg {
+		return errors.New("memory mongo only understands OP_MSG")
+	}
+	command, err := readCommand(rem)
+	if err != nil {
+		return err
+	}
+
+	reply := c.mongo.run(command)
+
+	var dst []byte
+	var index int32
+	index, dst = wiremessage.AppendHeaderStart(dst, wiremessage.NextRequestID(), requestID, wiremessage.OpMsg)
+	dst = wiremessage.AppendMsgFlags(dst, 0)
+	dst = wiremessage.AppendMsgSectionType(dst, wiremessage.SingleDocument)
+	body, err := bson.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	dst = append(dst, body...)
+	c.reply = bsoncore.UpdateLength(dst, index, int32(len(dst[index:])))
+	return nil
+}
+
+func (c *memConnection) ReadWireMessage(context.Context) ([]byte, error) {
+	if c.reply == nil {
+		return nil, errors.New("no command was sent")
+	}
+	reply := c.reply
+	c.reply = nil
+	return reply, nil
+}
+
+func (c *memConnection) Description() description.Server {
+	return description.Server{
+		Addr:                     memAddress,
+		CanonicalAddr:            memAddress,
+		Kind:                     description.Standalone,
+		MaxDocumentSize:          16 * 1024 * 1024,
+		MaxMessageSize:           48000000,
+		MaxBatchCount:            100000,
+		SessionTimeoutMinutesPtr: &sessionTimeout,
+		WireVersion:              &description.VersionRange{Max: topology.SupportedWireVersions.Max},
+	}
+}
+
+func (c *memConnection) Close() error               { return nil }
+func (c *memConnection) ID() string                 { return "memory" }
+func (c *memConnection) DriverConnectionID() uint64 { return 0 }
+func (c *memConnection) ServerConnectionID() *int64 { return nil }
+func (c *memConnection) Address() address.Address   { return memAddress }
+func (c *memConnection) Stale() bool                { return false }
+
+// readCommand decodes the body and document sequences of an OP_MSG into one command
+func readCommand(src []byte) (bson.D, error) {
+	_, src, ok := wiremessage.ReadMsgFlags(src)
+	if !ok {
+		return nil, errors.New("malformed OP_MSG")
+	}
+
+	var command bson.D
+	var sequences []bson.E
+	for len(src) > 0 {
+		var stype wiremessage.SectionType
+		stype, src, ok = wiremessage.ReadMsgSectionType(src)
+		if !ok {
+			break
+		}
+		switch stype {
+		case wiremessage.SingleDocument:
+			var doc bsoncore.Document
+			doc, src, ok = wiremessage.ReadMsgSectionSingleDocument(src)
+			if !ok {
+				return nil, errors.New("malformed OP_MSG body")
+			}
+			if err := bson.Unmarshal(doc, &command); err != nil {
+				return nil, err
+			}
+		case wiremessage.DocumentSequence:
+			var identifier string
+			var docs []bsoncore.Document
+			identifier, docs, src, ok = wiremessage.ReadMsgSectionDocumentSequence(src)
+			if !ok {
+				return nil, errors.New("malformed OP_MSG document sequence")
+			}
+			array := bson.A{}
+			for _, doc := range docs {
+				var d bson.D
+				if err := bson.Unmarshal(doc, &d); err != nil {
+					return nil, err
+				}
+				array = append(array, d)
+			}
+			sequences = append(sequences, bson.E{Key: identifier, Value: array})
+		default:
+			return nil, fmt.Errorf("unsupported OP_MSG section %d", stype)
+		}
+	}
+	return append(command, sequences...), nil
+}
+
+// commandError is the reply of a failed command
+func commandError(code int32, format string, args ...interface{}) bson.D {
+	return bson.D{{Key: "ok", Value: 0}, {Key: "errmsg", Value: fmt.Sprintf(format, args...)}, {Key: "code", Value: code}}
+}
+
+// run executes a command against the in-memory collections
+func (m *MemoryMongo) run(command bson.D) bson.D {
+	if len(command) == 0 {
+		return commandError(59, "empty command")
+	}
+
+	args := bson.M{}
+	for _, e := range command[1:] {
+		args[e.Key] = e.Value
+	}
+	name := command[0].Key
+	collection, _ := command[0].Value.(string)
+	db, _ := args["$db"].(string)
+	ns := db + "." + collection
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch strings.ToLower(name) {
+	case "find", "insert", "update", "delete", "findandmodify", "count", "distinct", "aggregate":
+		m.queries++
+		if m.failing[collection] {
+			return commandError(1, "memory mongo: %s is unavailable", collection)
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case "hello", "ismaster":
+		return bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "isWritablePrimary", Value: true},
+			{Key: "ismaster", Value: true},
+			{Key: "maxWireVersion", Value: topology.SupportedWireVersions.Max},
+			{Key: "logicalSessionTimeoutMinutes", Value: sessionTimeout},
+		}
+	case "ping", "buildinfo", "endsessions", "dropindexes", "killcursors",
+		"create", "committransaction", "aborttransaction":
+		return bson.D{{Key: "ok", Value: 1}}
+	case "createindexes":
+		return m.createIndexes(collection, args)
+	case "drop":
+		delete(m.collections, collection)
+		delete(m.indexes, collection)
+		return bson.D{{Key: "ok", Value: 1}}
+	case "listcollections", "listindexes":
+		return cursorReply(ns, []bson.M{})
+	case "find":
+		return m.find(ns, collection, args)
+	case "insert":
+		return m.insert(collection, args)
+	case "update":
+		return m.update(collection, args)
+	case "delete":
+		return m.delete(collection, args)
+	case "findandmodify":
+		return m.findAndModify(collection, args)
+	case "count":
+		count := len(m.filter(collection, toM(args["query"])))
+		return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: int32(count)}}
+	case "distinct":
+		return m.distinct(collection, args)
+	case "aggregate":
+		return m.aggregate(ns, collection, args)
+	}
+	return commandError(59, "memory mongo does not support the %s command", name)
+}
+
+func cursorReply(ns string, docs []bson.M) bson.D {
+	batch := bson.A{}
+	for _, doc := range docs {
+		batch = append(batch, doc)
+	}
+	return bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "cursor", Value: bson.D{
+			{Key: "id", Value: int64(0)},
+			{Key: "ns", Value: ns},
+			{Key: "firstBatch", Value: batch},
+		}},
+	}
+}
+
+// filter returns the stored documents matching a filter, in insertion order
+func (m *MemoryMongo) filter(collection string, filter bson.M) []bson.M {
+	var docs []bson.M
+	for _, doc := range m.collections[collection] {
+		if matches(doc, filter) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// sortSpec reads a sort argument, keeping the order of its keys
+func sortSpec(v interface{}) bson.D {
+	spec, _ := v.(bson.D)
+	return spec
+}
+
+// window applies skip and limit to a result
+func window(docs []bson.M, skip, limit interface{}) []bson.M {
+	if n, ok := number(skip); ok && n > 0 {
+		if int(n) >= len(docs) {
+			return []bson.M{}
+		}
+		docs = docs[int(n):]
+	}
+	if n, ok := number(limit); ok && n != 0 {
+		if n < 0 {
+			n = -n
+		}
+		if int(n) < len(docs) {
+			docs = docs[:int(n)]
+		}
+	}
+	return docs
+}
+
+func copies(docs []bson.M) []bson.M {
+	out := make([]bson.M, len(docs))
+	for i, doc := range docs {
+		out[i] = copyDoc(doc)
+	}
+	return out
+}
+
+func (m *MemoryMongo) find(ns, collection string, args bson.M) bson.D {
+	docs := copies(m.filter(collection, toM(args["filter"])))
+	sortDocs(docs, sortSpec(args["sort"]))
+	docs = window(docs, args["skip"], args["limit"])
+	return cursorReply(ns, docs)
+}
+
+func (m *MemoryMongo) insert(collection string, args bson.M) bson.D {
+	writeErrors := bson.A{}
+	inserted := 0
+	for i, raw := range asArray(args["documents"]) {
+		doc := toM(raw)
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = primitive.NewObjectID()
+		}
+		if m.hasID(collection, doc["_id"]) {
+			writeErrors = append(writeErrors, duplicateKey(i, doc["_id"]))
+			continue
+		}
+		if violation := m.uniqueViolation(collection, doc, i); violation != nil {
+			writeErrors = append(writeErrors, violation)
+			continue
+		}
+		m.collections[collection] = append(m.collections[collection], doc)
+		inserted++
+	}
+
+	reply := bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: int32(inserted)}}
+	if len(writeErrors) > 0 {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: writeErrors})
+	}
+	return reply
+}
+
+func (m *MemoryMongo) hasID(collection string, id interface{}) bool {
+	for _, doc := range m.collections[collection] {
+		if equal(doc["_id"], id) {
+			return true
+		}
+	}
+	return false
+}
+
+func duplicateKey(index int, id interface{}) bson.D {
+	return bson.D{
+		{Key: "index", Value: int32(index)},
+		{Key: "code", Value: int32(11000)},
+		{Key: "errmsg", Value: fmt.Sprintf("E11000 duplicate key error dup key: { _id: %v }", id)},
+	}
+}
+
+func (m *MemoryMongo) update(collection string, args bson.M) bson.D {
+	matched, modified := 0, 0
+	upserted := bson.A{}
+	writeErrors := bson.A{}
+
+	for i, raw := range asArray(args["updates"]) {
+		spec := toM(raw)
+		filter := toM(spec["q"])
+		update := toM(spec["u"])
+		if update == nil {
+			writeErrors = append(writeErrors, bson.D{
+				{Key: "index", Value: int32(i)},
+				{Key: "code", Value: int32(9)},
+				{Key: "errmsg", Value: "memory mongo does not support pipeline updates"},
+			})
+			continue
+		}
+
+		targets := m.filter(collection, filter)
+		if multi, _ := spec["multi"].(bool); !multi && len(targets) > 1 {
+			targets = targets[:1]
+		}
+
+		if len(targets) == 0 {
+			if upsert, _ := spec["upsert"].(bool); upsert {
+				doc := upsertSeed(filter)
+				if err := applyUpdate(doc, update, true); err != nil {
+					writeErrors = append(writeErrors, bson.D{{Key: "index", Value: int32(i)}, {Key: "code", Value: int32(9)}, {Key: "errmsg", Value: err.Error()}})
+					continue
+				}
+				if _, ok := doc["_id"]; !ok {
+					doc["_id"] = primitive.NewObjectID()
+				}
+				if m.hasID(collection, doc["_id"]) {
+					writeErrors = append(writeErrors, duplicateKey(i, doc["_id"]))
+					continue
+				}
+				if violation := m.uniqueViolation(collection, doc, i); violation != nil {
+					writeErrors = append(writeErrors, violation)
+					continue
+				}
+				m.collections[collection] = append(m.collections[collection], doc)
+				upserted = append(upserted, bson.D{{Key: "index", Value: int32(i)}, {Key: "_id", Value: doc["_id"]}})
+			}
+			continue
+		}
+
+		arrayFilters := asArray(spec["arrayFilters"])
+		for _, doc := range targets {
+			before := copyDoc(doc)
+			if err := applyUpdate(doc, resolvePositional(doc, filter, resolveArrayFilters(doc, update, arrayFilters)), false); err != nil {
+				writeErrors = append(writeErrors, bson.D{{Key: "index", Value: int32(i)}, {Key: "code", Value: int32(9)}, {Key: "errmsg", Value: err.Error()}})
+				break
+			}
+			if violation := m.uniqueViolation(collection, doc, i); violation != nil {
+				restore(doc, before)
+				writeErrors = append(writeErrors, violation)
+				break
+			}
+			matched++
+			if !equal(before, doc) {
+				modified++
+			}
+		}
+	}
+
+	reply := bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "n", Value: int32(matched + len(upserted))},
+		{Key: "nModified", Value: int32(modified)},
+	}
+	if len(upserted) > 0 {
+		reply = append(reply, bson.E{Key: "upserted", Value: upserted})
+	}
+	if len(writeErrors) > 0 {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: writeErrors})
+	}
+	return reply
+}
+
+func (m *MemoryMongo) delete(collection string, args bson.M) bson.D {
+	deleted := 0
+	for _, raw := range asArray(args["deletes"]) {
+		spec := toM(raw)
+		filter := toM(spec["q"])
+		limit, _ := number(spec["limit"])
+
+		kept := []bson.M{}
+		for _, doc := range m.collections[collection] {
+			if matches(doc, filter) && (limit == 0 || deleted < int(limit)) {
+				deleted++
+				continue
+			}
+			kept = append(kept, doc)
+		}
+		m.collections[collection] = kept
+	}
+	return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: int32(deleted)}}
+}
+
+func (m *MemoryMongo) findAndModify(collection string, args bson.M) bson.D {
+	filter := toM(args["query"])
+	targets := m.filter(collection, filter)
+	sortDocs(targets, sortSpec(args["sort"]))
+	returnNew, _ := args["new"].(bool)
+
+	lastError := bson.D{}
+	var value interface{}
+
+	switch {
+	case truthy(args["remove"]):
+		if len(targets) > 0 {
+			target := targets[0]
+			value = copyDoc(target)
+			kept := []bson.M{}
+			for _, doc := range m.collections[collection] {
+				if doc["_id"] != nil && equal(doc["_id"], target["_id"]) {
+					continue
+				}
+				kept = append(kept, doc)
+			}
+			m.collections[collection] = kept
+			lastError = bson.D{{Key: "n", Value: int32(1)}}
+		} else {
+			lastError = bson.D{{Key: "n", Value: int32(0)}}
+		}
+	case len(targets) > 0:
+		doc := targets[0]
+		before := copyDoc(doc)
+		if err := applyUpdate(doc, resolvePositional(doc, filter, toM(args["update"])), false); err != nil {
+			return commandError(9, "%v", err)
+		}
+		if violation := m.uniqueViolation(collection, doc, 0); violation != nil {
+			restore(doc, before)
+			return commandError(11000, "%v", violation[2].Value)
+		}
+		value = before
+		if returnNew {
+			value = copyDoc(doc)
+		}
+		lastError = bson.D{{Key: "n", Value: int32(1)}, {Key: "updatedExisting", Value: true}}
+	case truthy(args["upsert"]):
+		doc := upsertSeed(filter)
+		if err := applyUpdate(doc, toM(args["update"]), true); err != nil {
+			return commandError(9, "%v", err)
+		}
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = primitive.NewObjectID()
+		}
+		if violation := m.uniqueViolation(collection, doc, 0); violation != nil {
+			return commandError(11000, "%v", violation[2].Value)
+		}
+		m.collections[collection] = append(m.collections[collection], doc)
+		if returnNew {
+			value = copyDoc(doc)
+		}
+		lastError = bson.D{{Key: "n", Value: int32(1)}, {Key: "updatedExisting", Value: false}, {Key: "upserted", Value: doc["_id"]}}
+	default:
+		lastError = bson.D{{Key: "n", Value: int32(0)}, {Key: "updatedExisting", Value: false}}
+	}
+
+	return bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: value}, {Key: "lastErrorObject", Value: lastError}}
+}
+
+func (m *MemoryMongo) distinct(collection string, args bson.M) bson.D {
+	key, _ := args["key"].(string)
+	values := bson.A{}
+	for _, doc := range m.filter(collection, toM(args["query"])) {
+		found, _ := lookup(doc, key)
+		for _, v := range found {
+			if isArray(v) {
+				continue
+			}
+			if !matchEquals([]interface{}(values), true, v) {
+				values = append(values, v)
+			}
+		}
+	}
+	return bson.D{{Key: "ok", Value: 1}, {Key: "values", Value: values}}
+}
+
+// aggregate runs the stages tests rely on: $match, $sort, $skip, $limit, $count, $unwind
+// on a field path, $group with $sum, $first, $max and $addToSet keyed by expressions
+// (which covers CountDocuments), $project, $set, $facet and $lookup with let and a pipeline
+func (m *MemoryMongo) aggregate(ns, collection string, args bson.M) bson.D {
+	pipeline, _ := args["pipeline"].(bson.A)
+	docs, reply := m.runPipeline(copies(m.collections[collection]), pipeline)
+	if reply != nil {
+		return reply
+	}
+	return cursorReply(ns, docs)
+}
+
+// runPipeline runs the stages on docs; a non-nil reply is a command error
+func (m *MemoryMongo) runPipeline(docs []bson.M, pipeline bson.A) ([]bson.M, bson.D) {
+	for _, raw := range pipeline {
+		stage, _ := raw.(bson.D)
+		if len(stage) != 1 {
+			return nil, commandError(40323, "a pipeline stage must have exactly one field")
+		}
+		switch stage[0].Key {
+		case "$match":
+			filter := toM(stage[0].Value)
+			kept := []bson.M{}
+			for _, doc := range docs {
+				if matches(doc, filter) {
+					kept = append(kept, doc)
+				}
+			}
+			docs = kept
+		case "$sort":
+			sortDocs(docs, sortSpec(stage[0].Value))
+		case "$skip":
+			docs = window(docs, stage[0].Value, nil)
+		case "$limit":
+			docs = window(docs, nil, stage[0].Value)
+		case "$count":
+			field, _ := stage[0].Value.(string)
+			docs = []bson.M{{field: int32(len(docs))}}
+			if len(docs) == 1 && docs[0][field] == int32(0) {
+				docs = []bson.M{}
+			}
+		case "$unwind":
+			path, _ := stage[0].Value.(string)
+			preserve := false
+			if spec := toM(stage[0].Value); spec != nil {
+				path, _ = spec["path"].(string)
+				preserve, _ = spec["preserveNullAndEmptyArrays"].(bool)
+			}
+			docs = unwind(docs, strings.TrimPrefix(path, "$"), preserve)
+		case "$group":
+			grouped, err := group(docs, toM(stage[0].Value))
+			if err != nil {
+				return nil, commandError(40324, "%v", err)
+			}
+			docs = grouped
+		case "$project":
+			docs = project(docs, toM(stage[0].Value))
+		case "$set", "$addFields":
+			for field, value := range toM(stage[0].Value) {
+				for _, doc := range docs {
+					setValue(doc, field, expression(doc, value))
+				}
+			}
+		case "$lookup":
+			spec := toM(stage[0].Value)
+			from, _ := spec["from"].(string)
+			as, _ := spec["as"].(string)
+			var sub bson.A
+			fields, _ := stage[0].Value.(bson.D) // The stages must stay documents, as in $facet
+			for _, e := range fields {
+				if e.Key == "pipeline" {
+					sub, _ = e.Value.(bson.A)
+				}
+			}
+			for _, doc := range docs {
+				vars := bson.M{}
+				for name, value := range toM(spec["let"]) {
+					vars[name] = expression(doc, value)
+				}
+				out, reply := m.runPipeline(copies(m.collections[from]), bindVariables(sub, vars).(bson.A))
+				if reply != nil {
+					return nil, reply
+				}
+				joined := bson.A{}
+				for _, match := range out {
+					joined = append(joined, match)
+				}
+				doc[as] = joined
+			}
+		case "$facet":
+			faceted := bson.M{}
+			facets, _ := stage[0].Value.(bson.D) // Kept as is, normalizing would turn the stages into maps
+			for _, facet := range facets {
+				sub, _ := facet.Value.(bson.A)
+				out, reply := m.runPipeline(copies(docs), sub)
+				if reply != nil {
+					return nil, reply
+				}
+				values := bson.A{}
+				for _, doc := range out {
+					values = append(values, doc)
+				}
+				faceted[facet.Key] = values
+			}
+			docs = []bson.M{faceted}
+		default:
+			return nil, commandError(40324, "memory mongo does not support the %s stage", stage[0].Key)
+		}
+	}
+	return docs, nil
+}
+
+// project keeps the included fields and computed ones of each document, or removes the
+// excluded fields when the specification only excludes
+func project(docs []bson.M, spec bson.M) []bson.M {
+	excluding := true
+	for field, value := range spec {
+		if n, ok := number(value); field != "_id" && (!ok || n != 0) {
+			excluding = false
+		}
+	}
+	projected := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		if excluding {
+			for field := range spec {
+				unsetValue(doc, field)
+			}
+			projected = append(projected, doc)
+			continue
+		}
+		out := bson.M{}
+		if id, ok := doc["_id"]; ok {
+			out["_id"] = id
+		}
+		for field, value := range spec {
+			n, isNumber := number(value)
+			switch {
+			case isNumber && n == 0:
+				delete(out, field)
+			case isNumber || value == true:
+				if found, ok := getValue(doc, field); ok {
+					setValue(out, field, found)
+				}
+			default:
+				setValue(out, field, expression(doc, value))
+			}
+		}
+		projected = append(projected, out)
+	}
+	return projected
+}
+
+// bindVariables replaces the "$$name" references of a $lookup pipeline with the values of
+// its let variables
+func bindVariables(v interface{}, vars bson.M) interface{} {
+	switch value := v.(type) {
+	case string:
+		if name, ok := strings.CutPrefix(value, "$$"); ok {
+			if bound, ok := vars[name]; ok {
+				return bsonLiteral{bound}
+			}
+		}
+	case bson.D:
+		bound := make(bson.D, len(value))
+		for i, e := range value {
+			bound[i] = bson.E{Key: e.Key, Value: bindVariables(e.Value, vars)}
+		}
+		return bound
+	case bson.M:
+		bound := bson.M{}
+		for k, e := range value {
+			bound[k] = bindVariables(e, vars)
+		}
+		return bound
+	case bson.A:
+		bound := make(bson.A, len(value))
+		for i, e := range value {
+			bound[i] = bindVariables(e, vars)
+		}
+		return bound
+	}
+	return v
+}
+
+// bsonLiteral is a bound variable, evaluated as is even when it looks like a field path
+type bsonLiteral struct{ value interface{} }
+
+// unwind outputs a document per element of the array at path, dropping documents
+// without elements unless preserve is set
+func unwind(docs []bson.M, path string, preserve bool) []bson.M {
+	unwound := []bson.M{}
+	for _, doc := range docs {
+		value, _ := getValue(doc, path)
+		if !isArray(value) {
+			if value != nil || preserve {
+				unwound = append(unwound, doc)
+			}
+			continue
+		}
+		if preserve && len(asArray(value)) == 0 {
+			unsetValue(doc, path)
+			unwound = append(unwound, doc)
+			continue
+		}
+		for _, element := range asArray(value) {
+			copied := copyDoc(doc)
+			setValue(copied, path, element)
+			unwound = append(unwound, copied)
+		}
+	}
+	return unwound
+}
+
+// group supports grouping on a constant, a field path or a document of them with $sum,
+// $first, $max and $addToSet accumulators
+func group(docs []bson.M, spec bson.M) ([]bson.M, error) {
+	groups := []bson.M{}
+	for _, doc := range docs {
+		key := expression(doc, spec["_id"])
+		var target bson.M
+		for _, g := range groups {
+			if equal(g["_id"], key) || (g["_id"] == nil && key == nil) {
+				target = g
+				break
+			}
+		}
+		if target == nil {
+			target = bson.M{"_id": key}
+			groups = append(groups, target)
+		}
+
+		for field, raw := range spec {
+			if field == "_id" {
+				continue
+			}
+			accumulator := toM(raw)
+			if len(accumulator) != 1 {
+				return nil, fmt.Errorf("a $group accumulator must have exactly one operator")
+			}
+			if arg, ok := accumulator["$first"]; ok {
+				if _, seen := target[field]; !seen {
+					target[field] = expression(doc, arg)
+				}
+				continue
+			}
+			if arg, ok := accumulator["$max"]; ok {
+				value := expression(doc, arg)
+				if current, seen := target[field]; !seen || current == nil {
+					target[field] = value
+				} else if cmp, ok := compare(value, current); ok && cmp > 0 {
+					target[field] = value
+				}
+				continue
+			}
+			if arg, ok := accumulator["$addToSet"]; ok {
+				set, _ := target[field].(bson.A)
+				if value := expression(doc, arg); !matchEquals([]interface{}(set), true, value) {
+					set = append(set, value)
+				}
+				target[field] = set
+				continue
+			}
+			sum, ok := accumulator["$sum"]
+			if !ok {
+				return nil, fmt.Errorf("memory mongo only supports $sum, $first, $max and $addToSet in $group")
+			}
+			value := expression(doc, sum)
+			if _, isNumber := number(value); !isNumber {
+				value = int32(0)
+			}
+			target[field] = addNumbers(target[field], value)
+		}
+	}
+	return groups, nil
+}
+
+// expression evaluates a constant, a "$field" path, an operator or a document of them
+func expression(doc bson.M, v interface{}) interface{} {
+	if literal, ok := v.(bsonLiteral); ok {
+		return literal.value
+	}
+	if path, ok := v.(string); ok && strings.HasPrefix(path, "$") {
+		return fieldPath(doc, strings.TrimPrefix(path, "$"))
+	}
+	if fields, ok := v.(bson.M); ok {
+		if len(fields) == 1 {
+			for op, arg := range fields {
+				if strings.HasPrefix(op, "$") {
+					return operator(doc, op, arg)
+				}
+			}
+		}
+		evaluated := bson.M{}
+		for key, field := range fields {
+			evaluated[key] = expression(doc, field)
+		}
+		return evaluated
+	}
+	return v
+}
+
+// fieldPath returns the value at a path, collecting the values of the documents of arrays
+// on the way like MongoDB field paths do
+func fieldPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	head, rest, _ := strings.Cut(path, ".")
+	switch value := v.(type) {
+	case bson.M:
+		return fieldPath(value[head], rest)
+	case bson.A:
+		values := bson.A{}
+		for _, element := range value {
+			if _, isDoc := element.(bson.M); !isDoc {
+				continue
+			}
+			if found := fieldPath(element, path); found != nil {
+				values = append(values, found)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// operator evaluates the expression operators tests rely on
+func operator(doc bson.M, op string, arg interface{}) interface{} {
+	args := asArray(arg)
+	operand := func(i int) interface{} {
+		if i >= len(args) {
+			return nil
+		}
+		return expression(doc, args[i])
+	}
+	switch op {
+	case "$dayOfWeek", "$hour":
+		at, ok := datePart(doc, arg)
+		if !ok {
+			return nil
+		}
+		if op == "$hour" {
+			return int32(at.Hour())
+		}
+		return int32(at.Weekday()) + 1
+	case "$dateToString":
+		at, ok := datePart(doc, arg)
+		if !ok {
+			return nil
+		}
+		year, week := at.ISOWeek()
+		format, _ := toM(arg)["format"].(string)
+		return strings.NewReplacer(
+			"%Y", at.Format("2006"), "%m", at.Format("01"), "%d", at.Format("02"),
+			"%H", at.Format("15"), "%M", at.Format("04"), "%S", at.Format("05"),
+			"%G", fmt.Sprintf("%04d", year), "%V", fmt.Sprintf("%02d", week),
+		).Replace(format)
+	case "$size":
+		array, _ := expression(doc, arg).(bson.A)
+		return int32(len(array))
+	case "$eq", "$ne":
+		return equal(operand(0), operand(1)) == (op == "$eq")
+	case "$cond":
+		if spec := toM(arg); spec != nil {
+			args = bson.A{spec["if"], spec["then"], spec["else"]}
+		}
+		if truthy(operand(0)) {
+			return operand(1)
+		}
+		return operand(2)
+	case "$ifNull":
+		for i := range args {
+			if value := operand(i); value != nil {
+				return value
+			}
+		}
+		return nil
+	case "$arrayElemAt":
+		array, _ := operand(0).(bson.A)
+		n, _ := number(operand(1))
+		i := int(n)
+		if i < 0 {
+			i += len(array)
+		}
+		if i < 0 || i >= len(array) {
+			return nil
+		}
+		return array[i]
+	case "$subtract", "$divide":
+		return arithmetic(op, operand(0), operand(1))
+	case "$convert":
+		spec := toM(arg)
+		input := expression(doc, spec["input"])
+		if input == nil {
+			return spec["onNull"]
+		}
+		if spec["to"] == "objectId" {
+			if hex, ok := input.(string); ok {
+				if id, err := primitive.ObjectIDFromHex(hex); err == nil {
+					return id
+				}
+			}
+			if id, ok := input.(primitive.ObjectID); ok {
+				return id
+			}
+			return spec["onError"]
+		}
+		return input
+	}
+	return nil
+}
+
+// arithmetic subtracts or divides two values; a date minus a date is their difference in
+// milliseconds and integers stay integers when subtracted
+func arithmetic(op string, a, b interface{}) interface{} {
+	if typeOrder(a) == typeOrder(primitive.DateTime(0)) {
+		if typeOrder(b) == typeOrder(primitive.DateTime(0)) {
+			return dateMillis(a) - dateMillis(b)
+		}
+		if n, ok := number(b); ok && op == "$subtract" {
+			return primitive.DateTime(dateMillis(a) - int64(n))
+		}
+		return nil
+	}
+	x, okA := number(a)
+	y, okB := number(b)
+	if !okA || !okB {
+		return nil
+	}
+	if op == "$divide" {
+		if y == 0 {
+			return nil
+		}
+		return x / y
+	}
+	if _, isFloat := a.(float64); !isFloat {
+		if _, isFloat := b.(float64); !isFloat {
+			return int64(x - y)
+		}
+	}
+	return x - y
+}
+
+// datePart evaluates the date of a date operator, given as an expression or as a
+// document with "date" and an optional "timezone", in that timezone
+func datePart(doc bson.M, arg interface{}) (time.Time, bool) {
+	date, tz := arg, "UTC"
+	if spec, ok := arg.(bson.M); ok {
+		date = spec["date"]
+		if name, ok := spec["timezone"].(string); ok {
+			tz = name
+		}
+	}
+	value := expression(doc, date)
+	if _, ok := value.(primitive.DateTime); !ok {
+		if _, ok := value.(time.Time); !ok {
+			return time.Time{}, false
+		}
+	}
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(dateMillis(value)).In(location), true
+}
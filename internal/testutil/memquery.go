@@ -0,0 +1,835 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// normalize converts decoded BSON into bson.M documents and bson.A arrays all the way
+// down, so stored documents and command arguments compare the same way
+func normalize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case bson.D:
+		m := bson.M{}
+		for _, e := range value {
+			m[e.Key] = normalize(e.Value)
+		}
+		return m
+	case bson.M:
+		m := bson.M{}
+		for k, e := range value {
+			m[k] = normalize(e)
+		}
+		return m
+	case bson.A:
+		a := make(bson.A, len(value))
+		for i, e := range value {
+			a[i] = normalize(e)
+		}
+		return a
+	case []interface{}:
+		return normalize(bson.A(value))
+	}
+	return v
+}
+
+// toM returns a command argument as a document, or nil
+func toM(v interface{}) bson.M {
+	m, _ := normalize(v).(bson.M)
+	return m
+}
+
+// copyDoc returns a deep copy of a document
+func copyDoc(doc bson.M) bson.M {
+	return normalize(doc).(bson.M)
+}
+
+// lookup returns the values at a dotted path. Arrays on the way are searched element
+// by element, and an array at the end also yields its elements, which gives the
+// "any element matches" semantics of MongoDB queries.
+func lookup(v interface{}, path string) ([]interface{}, bool) {
+	if path == "" {
+		if a, ok := v.(bson.A); ok {
+			return append([]interface{}{a}, a...), true
+		}
+		return []interface{}{v}, true
+	}
+
+	head, rest, _ := strings.Cut(path, ".")
+	switch value := v.(type) {
+	case bson.M:
+		next, ok := value[head]
+		if !ok {
+			return nil, false
+		}
+		return lookup(next, rest)
+	case bson.A:
+		if i, err := strconv.Atoi(head); err == nil {
+			if i < 0 || i >= len(value) {
+				return nil, false
+			}
+			return lookup(value[i], rest)
+		}
+		var values []interface{}
+		found := false
+		for _, element := range value {
+			if vs, ok := lookup(element, path); ok {
+				values = append(values, vs...)
+				found = true
+			}
+		}
+		return values, found
+	}
+	return nil, false
+}
+
+// getValue returns the single value at a dotted path, without array expansion
+func getValue(doc bson.M, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case bson.M:
+			next, ok := value[part]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case bson.A:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(value) {
+				return nil, false
+			}
+			current = value[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setValue sets the value at a dotted path, creating documents on the way
+func setValue(doc bson.M, path string, v interface{}) {
+	parts := strings.Split(path, ".")
+	var current interface{} = doc
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch container := current.(type) {
+		case bson.M:
+			if last {
+				container[part] = v
+				return
+			}
+			next, ok := container[part]
+			if _, isDoc := next.(bson.M); !ok || (!isDoc && !isArray(next)) {
+				next = bson.M{}
+				container[part] = next
+			}
+			current = next
+		case bson.A:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(container) {
+				return
+			}
+			if last {
+				container[index] = v
+				return
+			}
+			if _, isDoc := container[index].(bson.M); !isDoc {
+				container[index] = bson.M{}
+			}
+			current = container[index]
+		default:
+			return
+		}
+	}
+}
+
+// unsetValue removes the value at a dotted path
+func unsetValue(doc bson.M, path string) {
+	parent, key := path, ""
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		parent, key = path[:i], path[i+1:]
+	} else {
+		delete(doc, path)
+		return
+	}
+	if container, ok := getValue(doc, parent); ok {
+		if m, ok := container.(bson.M); ok {
+			delete(m, key)
+		}
+	}
+}
+
+func isArray(v interface{}) bool {
+	_, ok := v.(bson.A)
+	return ok
+}
+
+// number returns a numeric BSON value as float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// typeOrder ranks BSON types the way MongoDB sorts mixed values
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int32, int64, int, float64:
+		return 2
+	case string:
+		return 3
+	case bson.M:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime, time.Time:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	}
+	return 12
+}
+
+// compare orders two BSON values, reporting false when they are of different types
+func compare(a, b interface{}) (int, bool) {
+	if typeOrder(a) != typeOrder(b) {
+		return typeOrder(a) - typeOrder(b), false
+	}
+	switch x := a.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return 0, true
+	case string:
+		return strings.Compare(x, b.(string)), true
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:]), true
+	case primitive.DateTime, time.Time:
+		return compareInt(dateMillis(a), dateMillis(b)), true
+	}
+	if x, ok := number(a); ok {
+		y, _ := number(b)
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, true
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)), true
+}
+
+func dateMillis(v interface{}) int64 {
+	switch d := v.(type) {
+	case primitive.DateTime:
+		return int64(d)
+	case time.Time:
+		return d.UnixMilli()
+	}
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// equal reports whether two BSON values are equal
+func equal(a, b interface{}) bool {
+	if typeOrder(a) != typeOrder(b) {
+		return false
+	}
+	switch a.(type) {
+	case bson.M, bson.A:
+		return reflect.DeepEqual(normalize(a), normalize(b))
+	}
+	c, ok := compare(a, b)
+	return ok && c == 0
+}
+
+// isOperatorDoc reports whether a filter value is an operator expression like {$gt: 1}
+func isOperatorDoc(v interface{}) (bson.M, bool) {
+	m, ok := v.(bson.M)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// matches reports whether a document matches a query filter
+func matches(doc bson.M, filter bson.M) bool {
+	for key, condition := range filter {
+		switch key {
+		case "$and":
+			for _, sub := range asArray(condition) {
+				if !matches(doc, toM(sub)) {
+					return false
+				}
+			}
+		case "$or":
+			any := false
+			for _, sub := range asArray(condition) {
+				if matches(doc, toM(sub)) {
+					any = true
+					break
+				}
+			}
+			if !any {
+				return false
+			}
+		case "$nor":
+			for _, sub := range asArray(condition) {
+				if matches(doc, toM(sub)) {
+					return false
+				}
+			}
+		case "$comment":
+		case "$expr":
+			if !truthy(exprValue(doc, condition)) {
+				return false
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				// Unsupported top-level operators never match
+				return false
+			}
+			values, found := lookup(doc, key)
+			if !matchField(values, found, condition) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// exprValue evaluates a $expr condition; only $eq and $ne of two expressions are
+// supported, anything else is evaluated as a plain expression
+func exprValue(doc bson.M, v interface{}) interface{} {
+	if m, ok := normalize(v).(bson.M); ok && len(m) == 1 {
+		for op, arg := range m {
+			operands := asArray(arg)
+			if (op != "$eq" && op != "$ne") || len(operands) != 2 {
+				break
+			}
+			same := equal(expression(doc, operands[0]), expression(doc, operands[1]))
+			return same == (op == "$eq")
+		}
+	}
+	return expression(doc, v)
+}
+
+func asArray(v interface{}) bson.A {
+	a, _ := normalize(v).(bson.A)
+	return a
+}
+
+// matchField checks the values found at a path against a filter condition
+func matchField(values []interface{}, found bool, condition interface{}) bool {
+	ops, isOps := isOperatorDoc(condition)
+	if !isOps {
+		return matchEquals(values, found, condition)
+	}
+
+	for op, arg := range ops {
+		ok := false
+		switch op {
+		case "$eq":
+			ok = matchEquals(values, found, arg)
+		case "$ne":
+			ok = !matchEquals(values, found, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = anyValue(values, func(v interface{}) bool {
+				c, comparable := compare(v, arg)
+				if !comparable {
+					return false
+				}
+				switch op {
+				case "$gt":
+					return c > 0
+				case "$gte":
+					return c >= 0
+				case "$lt":
+					return c < 0
+				}
+				return c <= 0
+			})
+		case "$in":
+			for _, candidate := range asArray(arg) {
+				if matchEquals(values, found, candidate) {
+					ok = true
+					break
+				}
+			}
+		case "$nin":
+			ok = true
+			for _, candidate := range asArray(arg) {
+				if matchEquals(values, found, candidate) {
+					ok = false
+					break
+				}
+			}
+		case "$exists":
+			want := truthy(arg)
+			ok = found == want
+		case "$regex":
+			pattern := ""
+			switch p := arg.(type) {
+			case string:
+				pattern = p
+			case primitive.Regex:
+				pattern = regexPattern(p.Pattern, p.Options)
+			}
+			if options, ok := ops["$options"].(string); ok {
+				pattern = regexPattern(pattern, options)
+			}
+			ok = matchRegex(values, pattern)
+		case "$options":
+			ok = true
+		case "$not":
+			ok = !matchField(values, found, arg)
+		case "$size":
+			want, _ := number(arg)
+			ok = anyValue(values, func(v interface{}) bool {
+				a, isA := v.(bson.A)
+				return isA && float64(len(a)) == want
+			})
+		case "$all":
+			ok = true
+			for _, candidate := range asArray(arg) {
+				if !matchEquals(values, found, candidate) {
+					ok = false
+					break
+				}
+			}
+		case "$elemMatch":
+			sub := toM(arg)
+			ok = anyValue(values, func(v interface{}) bool {
+				a, isA := v.(bson.A)
+				if !isA {
+					return false
+				}
+				for _, element := range a {
+					if m, isDoc := element.(bson.M); isDoc && matches(m, sub) {
+						return true
+					}
+					if _, isOps := isOperatorDoc(sub); isOps && matchField([]interface{}{element}, true, sub) {
+						return true
+					}
+				}
+				return false
+			})
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchEquals is equality matching: a missing field equals null, and an array matches
+// when any of its elements does
+func matchEquals(values []interface{}, found bool, want interface{}) bool {
+	if re, ok := want.(primitive.Regex); ok {
+		return matchRegex(values, regexPattern(re.Pattern, re.Options))
+	}
+	if want == nil {
+		if !found {
+			return true
+		}
+		return anyValue(values, func(v interface{}) bool { return v == nil })
+	}
+	return anyValue(values, func(v interface{}) bool { return equal(v, want) })
+}
+
+func matchRegex(values []interface{}, pattern string) bool {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	return anyValue(values, func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && re.MatchString(s)
+	})
+}
+
+// regexPattern adds the supported MongoDB regex options to a Go pattern
+func regexPattern(pattern, options string) string {
+	flags := ""
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			flags += string(option)
+		}
+	}
+	if flags == "" {
+		return pattern
+	}
+	return "(?" + flags + ")" + pattern
+}
+
+func anyValue(values []interface{}, fn func(interface{}) bool) bool {
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	if n, ok := number(v); ok {
+		return n != 0
+	}
+	return v != nil
+}
+
+// sortDocs sorts documents by a sort specification, keeping the order of ties
+func sortDocs(docs []bson.M, spec bson.D) {
+	if len(spec) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range spec {
+			direction, _ := number(field.Value)
+			a, _ := getValue(docs[i], field.Key)
+			b, _ := getValue(docs[j], field.Key)
+			c, _ := compare(a, b)
+			if c == 0 {
+				continue
+			}
+			if direction < 0 {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// applyUpdate applies an update document to doc. Plain documents replace doc, keeping
+// its _id. setOnInsert is only applied for upserted documents.
+func applyUpdate(doc bson.M, update bson.M, inserting bool) error {
+	if _, isOps := isOperatorDoc(update); !isOps {
+		id := doc["_id"]
+		for k := range doc {
+			delete(doc, k)
+		}
+		for k, v := range update {
+			doc[k] = v
+		}
+		if id != nil {
+			doc["_id"] = id
+		}
+		return nil
+	}
+
+	for op, arg := range update {
+		fields := toM(arg)
+		for path, value := range fields {
+			switch op {
+			case "$set":
+				setValue(doc, path, value)
+			case "$setOnInsert":
+				if inserting {
+					setValue(doc, path, value)
+				}
+			case "$unset":
+				unsetValue(doc, path)
+			case "$inc":
+				current, _ := getValue(doc, path)
+				setValue(doc, path, addNumbers(current, value))
+			case "$min", "$max":
+				current, ok := getValue(doc, path)
+				c, _ := compare(value, current)
+				if !ok || (op == "$min" && c < 0) || (op == "$max" && c > 0) {
+					setValue(doc, path, value)
+				}
+			case "$currentDate":
+				setValue(doc, path, primitive.NewDateTimeFromTime(time.Now()))
+			case "$push", "$addToSet":
+				current, _ := getValue(doc, path)
+				array, _ := current.(bson.A)
+				items := bson.A{value}
+				if each, ok := isOperatorDoc(value); ok && each["$each"] != nil {
+					items = asArray(each["$each"])
+				}
+				for _, item := range items {
+					if op == "$addToSet" && matchEquals([]interface{}(array), true, item) {
+						continue
+					}
+					array = append(array, item)
+				}
+				if array == nil {
+					array = bson.A{}
+				}
+				setValue(doc, path, array)
+			case "$pull":
+				current, _ := getValue(doc, path)
+				array, ok := current.(bson.A)
+				if !ok {
+					continue
+				}
+				kept := bson.A{}
+				for _, element := range array {
+					if pullMatches(element, value) {
+						continue
+					}
+					kept = append(kept, element)
+				}
+				setValue(doc, path, kept)
+			default:
+				return fmt.Errorf("unsupported update operator %s", op)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveArrayFilters rewrites the filtered positional paths of an update, like
+// items.$[bad].product_id, into one path per array element the array filters match
+func resolveArrayFilters(doc bson.M, update bson.M, arrayFilters bson.A) bson.M {
+	if len(arrayFilters) == 0 {
+		return update
+	}
+
+	// Conditions by identifier, on the element itself ("") or on its fields
+	conditions := map[string]bson.M{}
+	for _, raw := range arrayFilters {
+		for key, condition := range toM(raw) {
+			identifier, field, _ := strings.Cut(key, ".")
+			if conditions[identifier] == nil {
+				conditions[identifier] = bson.M{}
+			}
+			conditions[identifier][field] = condition
+		}
+	}
+
+	resolved := bson.M{}
+	for op, arg := range update {
+		fields := toM(arg)
+		if fields == nil {
+			resolved[op] = arg
+			continue
+		}
+		paths := bson.M{}
+		for path, value := range fields {
+			for _, concrete := range expandFiltered(doc, nil, strings.Split(path, "."), conditions) {
+				paths[concrete] = value
+			}
+		}
+		resolved[op] = paths
+	}
+	return resolved
+}
+
+// resolvePositional rewrites the positional paths of an update, like sales_ids.$, to the
+// index of the first array element the query filter matched
+func resolvePositional(doc bson.M, filter bson.M, update bson.M) bson.M {
+	resolved := bson.M{}
+	for op, arg := range update {
+		fields := toM(arg)
+		if fields == nil {
+			resolved[op] = arg
+			continue
+		}
+		paths := bson.M{}
+		for path, value := range fields {
+			parts := strings.Split(path, ".")
+			for i, part := range parts {
+				if part != "$" {
+					continue
+				}
+				if index := firstMatch(doc, filter, strings.Join(parts[:i], ".")); index >= 0 {
+					parts[i] = strconv.Itoa(index)
+				}
+				break
+			}
+			paths[strings.Join(parts, ".")] = value
+		}
+		resolved[op] = paths
+	}
+	return resolved
+}
+
+// firstMatch returns the index of the first element of the array at path that satisfies
+// the conditions the filter puts on the array, or -1
+func firstMatch(doc bson.M, filter bson.M, path string) int {
+	conditions := bson.M{}
+	for key, condition := range filter {
+		if key == path || strings.HasPrefix(key, path+".") {
+			conditions[key] = condition
+		}
+	}
+	array, _ := getValue(doc, path)
+	for i, element := range asArray(array) {
+		single := copyDoc(doc)
+		setValue(single, path, bson.A{element})
+		if matches(single, conditions) {
+			return i
+		}
+	}
+	return -1
+}
+
+// expandFiltered resolves the $[identifier] parts of a split path below current
+func expandFiltered(current interface{}, prefix []string, parts []string, conditions map[string]bson.M) []string {
+	for i, part := range parts {
+		if strings.HasPrefix(part, "$[") && strings.HasSuffix(part, "]") && len(part) > 3 {
+			condition := conditions[part[2:len(part)-1]]
+			array, _ := current.(bson.A)
+			paths := []string{}
+			for index, element := range array {
+				if !elementMatches(element, condition) {
+					continue
+				}
+				next := append(append(append([]string{}, prefix...), parts[:i]...), strconv.Itoa(index))
+				paths = append(paths, expandFiltered(element, next, parts[i+1:], conditions)...)
+			}
+			return paths
+		}
+		switch container := current.(type) {
+		case bson.M:
+			current = container[part]
+		case bson.A:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(container) {
+				current = nil
+			} else {
+				current = container[index]
+			}
+		default:
+			current = nil
+		}
+	}
+	return []string{strings.Join(append(append([]string{}, prefix...), parts...), ".")}
+}
+
+// elementMatches reports whether an array element meets an array filter's conditions
+func elementMatches(element interface{}, condition bson.M) bool {
+	for field, cond := range condition {
+		if field == "" {
+			if !matches(bson.M{"v": element}, bson.M{"v": cond}) {
+				return false
+			}
+			continue
+		}
+		doc, ok := element.(bson.M)
+		if !ok || !matches(doc, bson.M{field: cond}) {
+			return false
+		}
+	}
+	return true
+}
+
+// pullMatches reports whether $pull removes an array element
+func pullMatches(element, condition interface{}) bool {
+	if cond, ok := condition.(bson.M); ok {
+		if _, isOps := isOperatorDoc(cond); isOps {
+			return matchField([]interface{}{element}, true, cond)
+		}
+		if m, isDoc := element.(bson.M); isDoc {
+			return matches(m, cond)
+		}
+		return false
+	}
+	return equal(element, condition)
+}
+
+// addNumbers adds an $inc amount, keeping integer types when both values are integers
+func addNumbers(current, amount interface{}) interface{} {
+	switch a := amount.(type) {
+	case int32:
+		switch c := current.(type) {
+		case nil:
+			return a
+		case int32:
+			return c + a
+		case int64:
+			return c + int64(a)
+		}
+	case int64:
+		switch c := current.(type) {
+		case nil:
+			return a
+		case int32:
+			return int64(c) + a
+		case int64:
+			return c + a
+		}
+	}
+	x, _ := number(current)
+	y, _ := number(amount)
+	return x + y
+}
+
+// upsertSeed builds the document an upsert starts from: the equality conditions of the
+// filter
+func upsertSeed(filter bson.M) bson.M {
+	doc := bson.M{}
+	for key, condition := range filter {
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+		if ops, isOps := isOperatorDoc(condition); isOps {
+			if eq, ok := ops["$eq"]; ok {
+				setValue(doc, key, eq)
+			}
+			continue
+		}
+		setValue(doc, key, condition)
+	}
+	return doc
+}